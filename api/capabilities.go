@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// Capabilities describes the build a client is talking to, so front-ends
+// and scripts can offer only what it supports. It is served by
+// CapabilitiesHandler and printed by the capabilities subcommand.
+type Capabilities struct {
+	Build        BuildInfo          `json:"build"`
+	APIVersions  []string           `json:"api_versions"` // Current first
+	Converter    converter.Features `json:"converter"`
+	JobStorage   []string           `json:"job_storage"`  // Backends JOB_STORAGE accepts
+	Integrations map[string]bool    `json:"integrations"` // Optional features and whether this build has them
+}
+
+// BuildInfo identifies the binary.
+type BuildInfo struct {
+	Version   string   `json:"version"` // Module version, "(devel)" for local builds
+	Revision  string   `json:"revision,omitempty"`
+	GoVersion string   `json:"go_version"`
+	OS        string   `json:"os"`
+	Arch      string   `json:"arch"`
+	CGO       bool     `json:"cgo"`
+	Tags      []string `json:"tags,omitempty"` // Build tags, such as leakcheck
+}
+
+// NewCapabilities returns the Capabilities of this build serving versions.
+func NewCapabilities(versions ...APIVersion) Capabilities {
+	caps := Capabilities{
+		Build:      readBuildInfo(),
+		Converter:  converter.SupportedFeatures(),
+		JobStorage: []string{"memory", "local", "s3"},
+		Integrations: map[string]bool{
+			"autocert": true,
+			"h2c":      true,
+			"s3":       true,
+			"socks5":   true,
+			"webhooks": true,
+			// Not built in yet; listed so clients can check for them.
+			"avif": false,
+			"ocr":  false,
+			"rar":  false,
+		},
+	}
+	for _, v := range versions {
+		caps.APIVersions = append(caps.APIVersions, v.Name)
+	}
+	return caps
+}
+
+func readBuildInfo() BuildInfo {
+	info := BuildInfo{Version: "(unknown)", GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Version = bi.Main.Version
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "CGO_ENABLED":
+			info.CGO = setting.Value == "1"
+		case "-tags":
+			info.Tags = strings.Split(setting.Value, ",")
+		}
+	}
+	return info
+}
+
+// CapabilitiesHandler returns a handler for GET /capabilities serving caps.
+func CapabilitiesHandler(caps Capabilities) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, "Invalid request method", "Only GET is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, caps)
+	}
+}
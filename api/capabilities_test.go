@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	h := CapabilitiesHandler(NewCapabilities(APIVersion{Name: "2"}, APIVersion{Name: "1"}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body)
+	}
+	var caps Capabilities
+	if err := json.Unmarshal(rr.Body.Bytes(), &caps); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(caps.APIVersions, []string{"2", "1"}) {
+		t.Errorf("Expected API versions 2 and 1, got %v", caps.APIVersions)
+	}
+	var types []string
+	for _, format := range caps.Converter.InputFormats {
+		types = append(types, format.ContentType)
+	}
+	for _, want := range []string{"image/jpeg", "image/png", "image/webp", "image/gif", "image/bmp"} {
+		if !slices.Contains(types, want) {
+			t.Errorf("Expected input format %s, got %v", want, types)
+		}
+	}
+	if !slices.Contains(caps.Converter.OutputFormats, "epub") || caps.Build.GoVersion == "" {
+		t.Errorf("Expected EPUB output and the Go version, got %+v", caps)
+	}
+	if ocr, listed := caps.Integrations["ocr"]; !listed || ocr {
+		t.Errorf("Expected OCR listed as not built in, got %v", caps.Integrations)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/capabilities", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// gzipWriterPool and zstdEncoderPool reuse compressors across responses,
+// mirroring the converter's buffer pooling.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+var zstdEncoderPool = sync.Pool{
+	New: func() interface{} {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			// Only invalid options make NewWriter fail; treat it as a programming error.
+			panic(err)
+		}
+		return enc
+	},
+}
+
+// Compress wraps next so that JSON responses are transparently compressed
+// with zstd or gzip when the client advertises support for either.
+// Responses of any other content type, notably the PDF stream, are passed
+// through untouched: PDFs are already compressed and are served with an
+// exact Content-Length.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks "zstd" or "gzip" from an Accept-Encoding header,
+// preferring zstd, or returns "" if neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	switch {
+	case accepted["zstd"]:
+		return "zstd"
+	case accepted["gzip"]:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressWriter decides at WriteHeader time, based on the Content-Type the
+// handler set, whether to compress the body.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	compressible := strings.HasPrefix(h.Get("Content-Type"), "application/json") &&
+		h.Get("Content-Encoding") == "" &&
+		statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+	if compressible {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Add("Vary", "Accept-Encoding")
+		switch cw.encoding {
+		case "zstd":
+			enc := zstdEncoderPool.Get().(*zstd.Encoder)
+			enc.Reset(cw.ResponseWriter)
+			cw.encoder = enc
+		case "gzip":
+			gz := gzipWriterPool.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.encoder = gz
+		}
+	}
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush pushes buffered compressed data to the client, keeping streaming
+// responses working through the wrapper.
+func (cw *compressWriter) Flush() {
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			slog.Debug("Failed to flush compressed response", "error", err)
+		}
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the compressed stream and returns the encoder to its pool.
+func (cw *compressWriter) close() {
+	if cw.encoder == nil {
+		return
+	}
+	if err := cw.encoder.Close(); err != nil {
+		slog.Debug("Failed to finish compressed response", "error", err)
+	}
+	switch enc := cw.encoder.(type) {
+	case *zstd.Encoder:
+		enc.Reset(nil)
+		zstdEncoderPool.Put(enc)
+	case *gzip.Writer:
+		enc.Reset(io.Discard)
+		gzipWriterPool.Put(enc)
+	}
+	cw.encoder = nil
+}
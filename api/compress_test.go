@@ -0,0 +1,79 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"zstd;q=0, gzip;q=0.5", "gzip"},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.expected {
+			t.Errorf("negotiateEncoding(%q): expected %q, got %q", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	const body = `{"status":"ok","padding":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`
+	handler := Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pdf" {
+			w.Header().Set("Content-Type", "application/pdf")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		io.WriteString(w, body)
+	}))
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve("/json", "gzip")
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", rr.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip body: %v", err)
+	}
+	if data, _ := io.ReadAll(gz); string(data) != body {
+		t.Errorf("Unexpected gzip body: %s", data)
+	}
+
+	rr = serve("/json", "zstd")
+	if rr.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("Expected zstd encoding, got %q", rr.Header().Get("Content-Encoding"))
+	}
+	dec, err := zstd.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("Failed to open zstd body: %v", err)
+	}
+	defer dec.Close()
+	if data, _ := io.ReadAll(dec); string(data) != body {
+		t.Errorf("Unexpected zstd body: %s", data)
+	}
+
+	rr = serve("/pdf", "gzip, zstd")
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != body {
+		t.Errorf("Expected PDF responses to be passed through, got encoding %q", rr.Header().Get("Content-Encoding"))
+	}
+}
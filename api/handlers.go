@@ -8,17 +8,24 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"sync" // For order preservation with fetched URLs
+	"time"
 
 	"manga_to_pdf/internal/converter"
+	"manga_to_pdf/internal/storage"
 )
 
 const defaultMaxMemory = 32 << 20 // 32 MB for multipart form parsing
 
+// convertToPDF is the conversion entry point used by the handlers.
+// It is a variable so tests can substitute a controllable implementation.
+var convertToPDF = converter.ConvertToPDFWithStats
+
 type APIErrorResponse struct {
 	Error   string      `json:"error"`
 	Details interface{} `json:"details,omitempty"`
@@ -44,13 +51,122 @@ type indexedImageSource struct {
 	err    error
 }
 
+// Options holds server-side settings for the conversion handlers.
+// The zero value means "no limits, converter defaults".
+type Options struct {
+	MaxUploadBytes int64         // Maximum accepted request body size in bytes (0 = unlimited)
+	Workers        int           // Default and upper bound for per-request num_workers (0 = converter default)
+	ConvertTimeout time.Duration // Stop a request running longer than this with converter.ErrTimedOut (0 = no limit)
+	MaxMegapixels  float64       // Default and upper bound for per-request max_total_megapixels (0 = no limit)
+
+	// UploadTypes, if set, is the allowlist of the uploaded images'
+	// content types, e.g. "image/jpeg": each part is sniffed before anything
+	// is decoded, and a request with a part that is no image of these types,
+	// or claims to be another image type than it is, is rejected whole with
+	// 415 and an UploadRejection for each such part. See ValidateUploadTypes.
+	UploadTypes []string
+
+	// DecodeLimits is the default and upper bound of each format's
+	// per-request decode_limits; formats it leaves out are up to the request.
+	DecodeLimits map[string]int
+
+	// TempDir is where uploads beyond the in-memory limit are spilled, in a
+	// subdirectory per tenant named after it (see Tenant); empty means
+	// os.TempDir().
+	TempDir string
+
+	// Storage keeps the outputs of finished /jobs; nil keeps them in memory.
+	// TenantQuota caps the bytes of finished outputs kept per tenant (see
+	// Tenant): a tenant's oldest outputs are dropped to make room for its
+	// new ones, never another tenant's. 0 means no limit.
+	Storage     storage.Storage
+	TenantQuota int64
+
+	// MaxJobs caps the /jobs running at once on the server, answered with
+	// 503 beyond it, and MaxTenantJobs those of each tenant, answered with
+	// 429. 0 means no limit.
+	MaxJobs       int
+	MaxTenantJobs int
+
+	// MaxSessions caps the upload sessions open at once on the server,
+	// answered with 503 beyond it, and MaxTenantSessions those of each
+	// tenant, answered with 429. 0 means no limit.
+	MaxSessions       int
+	MaxTenantSessions int
+
+	// Webhooks delivers the notifications requested with webhook_url on
+	// POST /jobs; nil rejects such requests.
+	Webhooks *WebhookNotifier
+
+	// Fetcher downloads image_urls. It should be shared by all requests so
+	// its per-host limits hold server-wide; nil means converter.FetchImage.
+	// Each request or job fetches with Fetcher.ForJob, so with a proxy no
+	// two of them share connections.
+	Fetcher *converter.Fetcher
+
+	// FetchRetries is how many more times image_urls that failed with a
+	// transient error are tried, after every URL has been tried once and
+	// FetchRetryDelay apart, before the conversion starts. 0 disables
+	// retries.
+	FetchRetries    int
+	FetchRetryDelay time.Duration
+
+	// SlowLog receives one entry, with the request's settings and per-stage
+	// timings, for every conversion that reaches SlowLogDuration or whose
+	// upload or PDF reaches SlowLogBytes. A zero threshold is not checked.
+	SlowLog         *slog.Logger
+	SlowLogDuration time.Duration
+	SlowLogBytes    int64
+}
+
+// NewConvertHandler returns a /convert handler using the given server options.
+func NewConvertHandler(opts Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleConvert(w, r, opts)
+	}
+}
+
+// HandleConvert handles /convert with default Options.
 func HandleConvert(w http.ResponseWriter, r *http.Request) {
+	handleConvert(w, r, Options{})
+}
+
+// cancellationMessages and cancellationStatus describe a conversion stopped
+// for each converter.CancellationReason.
+var (
+	cancellationMessages = map[string]string{
+		"user":     "canceled by client",
+		"timeout":  "time limit reached",
+		"limit":    "resource limit reached",
+		"shutdown": "server is shutting down, retry later",
+	}
+	cancellationStatus = map[string]int{
+		"user":     http.StatusGatewayTimeout, // The client is usually gone; this only reaches logs
+		"timeout":  http.StatusGatewayTimeout,
+		"limit":    http.StatusRequestEntityTooLarge,
+		"shutdown": http.StatusServiceUnavailable,
+	}
+)
+
+func handleConvert(w http.ResponseWriter, r *http.Request, opts Options) {
 	if r.Method != http.MethodPost {
 		writeJSONError(w, "Invalid request method", "Only POST is allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	ctx := r.Context()
+	if opts.ConvertTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, opts.ConvertTimeout, converter.ErrTimedOut)
+		defer cancel()
+	}
+
+	if opts.MaxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, opts.MaxUploadBytes)
+	}
+
+	slow, w := startSlowRequest(w, r, opts)
+	defer slow.finish(opts)
 
 	// Ensure body is closed
 	defer func() {
@@ -60,52 +176,160 @@ func HandleConvert(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	req := parseConvertRequest(w, r, opts, slow)
+	if req == nil {
+		return
+	}
+	result, convErr := runConversion(ctx, req, opts, slow)
+	if convErr != nil {
+		writeJSONError(w, convErr.message, convErr.details, convErr.status)
+		return
+	}
+
+	// --- Success Response ---
+	if req.responseMode == responseModeMultipart {
+		report := newConversionReport(result.filename, result.fetchFailures, result.stats)
+		slog.Info("Successfully generated PDF", "filename", result.filename, "size", result.output.Len(), "failures", len(report.Failures))
+		writeMultipartReport(w, report, result.contentType, &result.output)
+		return
+	}
+
+	w.Header().Set("Content-Type", result.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, result.filename))
+	contentLength := result.output.Len()
+	w.Header().Set("Content-Length", strconv.Itoa(contentLength))
+
+	slog.Info("Successfully generated PDF", "filename", result.filename, "size", contentLength)
+	if _, err := result.output.WriteTo(w); err != nil {
+		// This error usually means the client closed the connection.
+		slog.Error("Failed to write PDF to response", "error", err)
+		// Cannot send JSON error here as headers are already sent.
+	}
+}
+
+// convertRequest is a parsed conversion request: its settings, the uploaded
+// images (opened, in order) and the image URLs still to fetch.
+type convertRequest struct {
+	config       *converter.Config
+	responseMode string
+	uploads      []converter.ImageSource
+	urls         []string
+}
+
+// closeUploads closes the readers of the uploaded images.
+func (req *convertRequest) closeUploads() {
+	for _, src := range req.uploads {
+		if src.Reader != nil {
+			src.Reader.Close()
+		}
+	}
+}
+
+// parseConvertRequest reads the multipart form of a conversion request. On
+// failure it writes the error response and returns nil.
+func parseConvertRequest(w http.ResponseWriter, r *http.Request, opts Options, slow *slowRequest) *convertRequest {
 	// Parse multipart form
 	// The request body is an io.ReadCloser. It can be read once.
-	// ParseMultipartForm reads the body.
-	if err := r.ParseMultipartForm(defaultMaxMemory); err != nil {
+	// parseUploadForm reads the body.
+	form, err := parseUploadForm(r, defaultMaxMemory, uploadDir(r, opts))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			slog.Warn("Request body exceeds upload limit", "limit", maxBytesErr.Limit)
+			writeJSONError(w, "Request body too large", fmt.Sprintf("Maximum upload size is %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return nil
+		}
 		if err == io.EOF || err == io.ErrUnexpectedEOF { // These can happen if body is empty or malformed
 			slog.Warn("Empty or malformed request body", "error", err)
 			writeJSONError(w, "Malformed request body or empty request", err.Error(), http.StatusBadRequest)
-			return
+			return nil
 		}
 		slog.Error("Failed to parse multipart form", "error", err)
 		writeJSONError(w, "Failed to parse request data", err.Error(), http.StatusBadRequest)
-		return
+		return nil
 	}
 
 	slog.Debug("Multipart form parsed successfully")
+	slow.mark("parse")
 
 	// --- Configuration ---
+	defaultConfig := converter.NewDefaultConfig()
+	if opts.Workers > 0 {
+		defaultConfig.NumWorkers = opts.Workers
+	}
 	apiConfig := converter.NewDefaultConfig()
+	apiConfig.NumWorkers = defaultConfig.NumWorkers
 	configStr := r.FormValue("config")
 	if configStr != "" {
 		slog.Debug("Received config string", "config", configStr)
 		if err := json.Unmarshal([]byte(configStr), apiConfig); err != nil {
 			slog.Warn("Failed to parse 'config' JSON", "error", err, "configStr", configStr)
 			writeJSONError(w, "Invalid 'config' JSON", err.Error(), http.StatusBadRequest)
-			return
+			return nil
+		}
+		if apiConfig.Profile != "" {
+			// The profile is the base that the other settings in the
+			// request override, so the config is read again on top of it.
+			profiled := converter.NewDefaultConfig()
+			profiled.NumWorkers = defaultConfig.NumWorkers
+			err := profiled.ApplyProfile(apiConfig.Profile)
+			if err == nil {
+				err = json.Unmarshal([]byte(configStr), profiled)
+			}
+			if err != nil {
+				writeJSONError(w, "Invalid 'config' JSON", err.Error(), http.StatusBadRequest)
+				return nil
+			}
+			apiConfig = profiled
 		}
 		// Validate config values (JPEGQuality, NumWorkers)
 		if apiConfig.JPEGQuality < 1 || apiConfig.JPEGQuality > 100 {
 			slog.Warn("Invalid JPEG quality in config, using default", "provided", apiConfig.JPEGQuality)
-			apiConfig.JPEGQuality = converter.NewDefaultConfig().JPEGQuality // Reset to default
+			apiConfig.JPEGQuality = defaultConfig.JPEGQuality // Reset to default
 		}
 		if apiConfig.NumWorkers <= 0 {
 			slog.Warn("Invalid NumWorkers in config, using default", "provided", apiConfig.NumWorkers)
-			apiConfig.NumWorkers = converter.NewDefaultConfig().NumWorkers // Reset to default
+			apiConfig.NumWorkers = defaultConfig.NumWorkers // Reset to default
+		}
+		if opts.Workers > 0 && apiConfig.NumWorkers > opts.Workers {
+			slog.Debug("Capping NumWorkers to server limit", "provided", apiConfig.NumWorkers, "limit", opts.Workers)
+			apiConfig.NumWorkers = opts.Workers
+		}
+		// Stage-specific worker counts fall back to NumWorkers when unset and share its server limit.
+		for _, stageWorkers := range []*int{&apiConfig.DecodeWorkers, &apiConfig.EncodeWorkers} {
+			if *stageWorkers < 0 || (opts.Workers > 0 && *stageWorkers > opts.Workers) {
+				*stageWorkers = 0
+			}
+		}
+		if err := apiConfig.Validate(); err != nil {
+			writeJSONError(w, "Invalid 'config' JSON", err.Error(), http.StatusBadRequest)
+			return nil
 		}
 		slog.Debug("Successfully parsed config", "parsedConfig", apiConfig)
 	} else {
 		slog.Debug("No 'config' provided, using default config")
 	}
+	if opts.MaxMegapixels > 0 && (apiConfig.MaxTotalMegapixels == 0 || apiConfig.MaxTotalMegapixels > opts.MaxMegapixels) {
+		apiConfig.MaxTotalMegapixels = opts.MaxMegapixels
+	}
+	apiConfig.DecodeLimits = capDecodeLimits(apiConfig.DecodeLimits, opts.DecodeLimits)
+	if slow != nil {
+		slow.config = apiConfig
+	}
 
-	var imageSources []converter.ImageSource
-	var sourceIndex int // To maintain original order
+	req := &convertRequest{config: apiConfig, responseMode: r.FormValue("response_mode")}
+	switch req.responseMode {
+	case "":
+		req.responseMode = responseModePDF
+	case responseModePDF, responseModeMultipart:
+	default:
+		writeJSONError(w, "Invalid 'response_mode'", fmt.Sprintf("Expected %q or %q", responseModePDF, responseModeMultipart), http.StatusBadRequest)
+		return nil
+	}
 
 	// --- Process Uploaded Files ---
-	// r.MultipartForm is populated by ParseMultipartForm.
-	uploadedFiles := r.MultipartForm.File["images"]
+	uploadedFiles := form.File["images"]
+	var rejections []UploadRejection
 	slog.Debug("Processing uploaded files", "count", len(uploadedFiles))
 	for _, fileHeader := range uploadedFiles {
 		slog.Debug("Processing uploaded file", "filename", fileHeader.Filename, "size", fileHeader.Size)
@@ -117,130 +341,164 @@ func HandleConvert(w http.ResponseWriter, r *http.Request) {
 			// To properly skip, we'd need to collect errors and report them.
 			// For simplicity in this step, a single file error might cause a general failure.
 			// A more robust approach would be to collect all sources and errors, then decide.
+			req.closeUploads()
 			writeJSONError(w, fmt.Sprintf("Failed to open uploaded file: %s", fileHeader.Filename), err.Error(), http.StatusInternalServerError)
-			return // Early exit for now
+			return nil // Early exit for now
 		}
 		// Note: The 'file' (multipart.File) needs to be closed. converter.processSingleImage will close it.
 
 		contentType := fileHeader.Header.Get("Content-Type")
-		if contentType == "" || contentType == "application/octet-stream" {
+		if len(opts.UploadTypes) > 0 {
+			sniffed, rejection, err := checkUploadType(len(req.uploads), fileHeader, file, opts.UploadTypes)
+			if err != nil {
+				file.Close()
+				req.closeUploads()
+				writeJSONError(w, fmt.Sprintf("Failed to read uploaded file: %s", fileHeader.Filename), err.Error(), http.StatusInternalServerError)
+				return nil
+			}
+			if rejection != nil {
+				slog.Warn("Rejected upload by its type", "filename", fileHeader.Filename, "declared", rejection.DeclaredType, "sniffed", rejection.SniffedType, "reason", rejection.Reason)
+				rejections = append(rejections, *rejection)
+			}
+			contentType = sniffed
+		} else if contentType == "" || contentType == "application/octet-stream" {
 			// Fallback to extension if content type is generic or missing
 			contentType = converter.GetContentTypeFromFilename(fileHeader.Filename)
 			slog.Debug("Guessed content type from filename", "filename", fileHeader.Filename, "guessedType", contentType)
 		}
 
-		imageSources = append(imageSources, converter.ImageSource{
+		req.uploads = append(req.uploads, converter.ImageSource{
 			OriginalFilename: fileHeader.Filename,
 			Reader:           file, // This is an io.ReadCloser
 			ContentType:      contentType,
-			Index:            sourceIndex,
+			Index:            len(req.uploads),
 		})
-		sourceIndex++
 	}
-	slog.Debug("Finished processing uploaded files", "count", len(imageSources))
-
-	// --- Process Image URLs ---
-	imageURLsStr := r.FormValue("image_urls")
-	var fetchedSources []converter.ImageSource // To hold successfully fetched sources from URLs
+	if len(rejections) > 0 {
+		req.closeUploads()
+		writeJSONError(w, "Uploaded files are not of an allowed type", rejections, http.StatusUnsupportedMediaType)
+		return nil
+	}
+	slog.Debug("Finished processing uploaded files", "count", len(req.uploads))
+	if slow != nil {
+		slow.uploads = len(req.uploads)
+	}
 
-	if imageURLsStr != "" {
+	if imageURLsStr := r.FormValue("image_urls"); imageURLsStr != "" {
 		slog.Debug("Processing image_urls", "urls_string", imageURLsStr)
-		var urls []string
-		if err := json.Unmarshal([]byte(imageURLsStr), &urls); err != nil {
+		if err := json.Unmarshal([]byte(imageURLsStr), &req.urls); err != nil {
 			slog.Warn("Failed to parse 'image_urls' JSON", "error", err, "urlsStr", imageURLsStr)
 			// Close any already opened uploaded files before returning
-			for _, src := range imageSources {
-				if src.Reader != nil {
-					src.Reader.Close()
-				}
-			}
+			req.closeUploads()
 			writeJSONError(w, "Invalid 'image_urls' JSON", err.Error(), http.StatusBadRequest)
-			return
+			return nil
 		}
+	}
+	return req
+}
 
-		if len(urls) > 0 {
-			slog.Debug("Fetching images from URLs", "count", len(urls))
-			fetchedChan := make(chan indexedImageSource, len(urls))
-			var wg sync.WaitGroup
-
-			for _, urlStr := range urls {
-				wg.Add(1)
-				go func(u string, currentIndex int) {
-					defer wg.Done()
-					select {
-					case <-ctx.Done():
-						fetchedChan <- indexedImageSource{err: ctx.Err()}
-						return
-					default:
-						slog.Debug("Fetching URL", "url", u, "index", currentIndex)
-						imgSrc, err := converter.FetchImage(ctx, u, currentIndex) // Pass current global index
-						if err != nil {
-							slog.Warn("Failed to fetch image from URL", "url", u, "error", err)
-							// Send error to channel, reader is already closed by FetchImage on error
-							fetchedChan <- indexedImageSource{err: err, source: converter.ImageSource{OriginalFilename: u, Index: currentIndex}}
-						} else {
-							slog.Debug("Successfully fetched URL", "url", u, "filename", imgSrc.OriginalFilename)
-							fetchedChan <- indexedImageSource{source: imgSrc}
-						}
-					}
-				}(urlStr, sourceIndex) // Pass the current sourceIndex for this URL
-				sourceIndex++ // Increment global index for each URL source
-			}
+// conversionError is a failed conversion as reported to the client.
+type conversionError struct {
+	message string
+	details interface{}
+	status  int
+}
+
+// capDecodeLimits returns the decode limits of a request: those of the
+// request, lowered to the server's, with the server's for the formats the
+// request leaves unlimited.
+func capDecodeLimits(requested, server map[string]int) map[string]int {
+	if len(server) == 0 {
+		return requested
+	}
+	limits := maps.Clone(requested)
+	if limits == nil {
+		limits = make(map[string]int, len(server))
+	}
+	for format, limit := range server {
+		if limit > 0 && (limits[format] == 0 || limits[format] > limit) {
+			limits[format] = limit
+		}
+	}
+	return limits
+}
 
-			wg.Wait()
-			close(fetchedChan)
+// conversionResult is a finished conversion.
+type conversionResult struct {
+	output        bytes.Buffer
+	filename      string // Sanitised download filename, with the output format's extension
+	contentType   string
+	fetchFailures []SourceFailure // URLs that could not be fetched, for the conversion report
+	stats         *converter.Stats
+}
 
-			tempFetchedSources := make([]indexedImageSource, 0, len(urls))
-			for res := range fetchedChan {
-				tempFetchedSources = append(tempFetchedSources, res)
-			}
-			// Sort by original index to maintain order relative to other URLs
-			sort.Slice(tempFetchedSources, func(i, j int) bool {
-				return tempFetchedSources[i].source.Index < tempFetchedSources[j].source.Index
-			})
-
-			urlErrors := []string{}
-			for _, res := range tempFetchedSources {
-				if res.err != nil {
-					// Collect errors for URLs. Decide if one failure means total failure.
-					// For now, collect and log. If an error occurs, the source.Reader will be nil or closed.
-					urlErrors = append(urlErrors, fmt.Sprintf("Failed to fetch %s: %s", res.source.OriginalFilename, res.err.Error()))
-					// Ensure reader is closed if somehow it wasn't (FetchImage should handle this)
-					if res.source.Reader != nil {
-						res.source.Reader.Close()
-					}
-				} else if res.source.Reader != nil { // Only add if successfully fetched and reader is present
-					fetchedSources = append(fetchedSources, res.source)
-				}
-			}
+// runConversion fetches the image URLs of req and converts them together
+// with its uploads. The readers of all sources are closed.
+func runConversion(ctx context.Context, req *convertRequest, opts Options, slow *slowRequest) (*conversionResult, *conversionError) {
+	apiConfig := req.config
+	imageSources := req.uploads
+	result := &conversionResult{}
 
-			if len(urlErrors) > 0 && len(fetchedSources) == 0 && len(uploadedFiles) == 0 {
-				// All URL fetches failed, and no uploaded files either
-				slog.Warn("All image URL fetches failed and no uploaded files.", "errors", strings.Join(urlErrors, "; "))
-				// Close any uploaded file readers if they existed but fetchedSources is the only source type
-				for _, src := range imageSources { // imageSources here only contains uploaded files
-					if src.Reader != nil {
-						src.Reader.Close()
-					}
+	// --- Process Image URLs ---
+	var fetchedSources []converter.ImageSource // To hold successfully fetched sources from URLs
+	var missingSources []converter.ImageSource // URLs that could not be fetched, with Config.Placeholders
+	if urls := req.urls; len(urls) > 0 {
+		fetch := converter.FetchImage
+		if opts.Fetcher != nil {
+			fetcher, done := opts.Fetcher.ForJob()
+			defer done()
+			fetch = fetcher.Fetch
+		}
+		slog.Debug("Fetching images from URLs", "count", len(urls))
+		tempFetchedSources := fetchURLs(ctx, fetch, urls, len(req.uploads), opts, apiConfig) // After the uploads, to maintain original order
+
+		urlErrors := []string{}
+		for _, res := range tempFetchedSources {
+			if res.err != nil {
+				// Collect errors for URLs. Decide if one failure means total failure.
+				// For now, collect and log. If an error occurs, the source.Reader will be nil or closed.
+				urlErrors = append(urlErrors, fmt.Sprintf("Failed to fetch %s: %s", res.source.OriginalFilename, res.err.Error()))
+				result.fetchFailures = append(result.fetchFailures, SourceFailure{Source: res.source.OriginalFilename, Stage: "fetch", Error: res.err.Error(), Reason: converter.FetchFailureReason(res.err)})
+				// Ensure reader is closed if somehow it wasn't (FetchImage should handle this)
+				if res.source.Reader != nil {
+					res.source.Reader.Close()
 				}
-				writeJSONError(w, "Failed to fetch any images from URLs and no files uploaded.", urlErrors, http.StatusUnprocessableEntity)
-				return
-			}
-			// Log URL errors if any, but proceed if some images were fetched or uploaded
-			if len(urlErrors) > 0 {
-				slog.Warn("Some image URL fetches failed", "errors", strings.Join(urlErrors, "; "))
+				if apiConfig.Placeholders {
+					// The converter puts a placeholder page in its place.
+					missing := res.source
+					missing.Reader, missing.FetchErr = nil, res.err
+					missingSources = append(missingSources, missing)
+				}
+			} else if res.source.Reader != nil { // Only add if successfully fetched and reader is present
+				fetchedSources = append(fetchedSources, res.source)
 			}
 		}
+
+		if slow != nil {
+			slow.urls, slow.urlErrors = len(urls), len(urlErrors)
+		}
+		if len(urlErrors) > 0 && len(fetchedSources) == 0 && len(req.uploads) == 0 {
+			// All URL fetches failed, and no uploaded files either
+			slog.Warn("All image URL fetches failed and no uploaded files.", "errors", strings.Join(urlErrors, "; "))
+			return nil, &conversionError{"Failed to fetch any images from URLs and no files uploaded.", urlErrors, http.StatusUnprocessableEntity}
+		}
+		// Log URL errors if any, but proceed if some images were fetched or uploaded
+		if len(urlErrors) > 0 {
+			slog.Warn("Some image URL fetches failed", "errors", strings.Join(urlErrors, "; "))
+		}
 	}
 	// Append successfully fetched URL sources to the main list
 	imageSources = append(imageSources, fetchedSources...)
+	if len(fetchedSources) > 0 || len(req.uploads) > 0 {
+		imageSources = append(imageSources, missingSources...)
+	}
 	slog.Debug("Finished processing image_urls", "successfully_fetched_count", len(fetchedSources))
+	slow.mark("fetch")
 
 	// --- Final Check and Cleanup ---
 	if len(imageSources) == 0 {
 		slog.Info("No image files or URLs provided or successfully processed up to this point.")
-		writeJSONError(w, "No images provided", "Please upload files or provide image URLs.", http.StatusBadRequest)
-		return
+		return nil, &conversionError{"No images provided", "Please upload files or provide image URLs.", http.StatusBadRequest}
 	}
 
 	// Ensure sources are sorted by their original index before passing to converter
@@ -254,33 +512,37 @@ func HandleConvert(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// --- Conversion ---
-	var pdfOutputBuffer bytes.Buffer
 	slog.Info("Starting PDF conversion with converter package", "num_sources", len(imageSources), "config", apiConfig)
 
 	// The readers in imageSources (from uploads or FetchImage) will be closed by the converter package.
-	hasContent, err := converter.ConvertToPDF(ctx, imageSources, apiConfig, &pdfOutputBuffer)
+	result.stats = slow.conversionStats()
+	if result.stats == nil {
+		result.stats = &converter.Stats{}
+	}
+	hasContent, err := convertToPDF(ctx, imageSources, apiConfig, &result.output, result.stats)
+	slow.mark("convert")
 	if err != nil {
 		slog.Error("PDF conversion failed", "error", err)
 		// imageSources readers should have been closed by ConvertToPDF or its sub-functions
-		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-			writeJSONError(w, "PDF conversion timed out or was canceled by client", err.Error(), http.StatusGatewayTimeout) // Or 499 Client Closed Request if detectable
+		if reason := converter.CancellationReason(err); reason != "" {
+			slow.setCancelReason(reason)
+			return nil, &conversionError{"PDF conversion stopped: " + cancellationMessages[reason], err.Error(), cancellationStatus[reason]}
+		} else if errors.Is(err, converter.ErrTargetSizeNotReached) {
+			return nil, &conversionError{"Output could not be made small enough for target_size", err.Error(), http.StatusUnprocessableEntity}
 		} else if errors.Is(err, converter.ErrNoSupportedImages) {
-			writeJSONError(w, "No images could be processed into the PDF", err.Error(), http.StatusUnprocessableEntity)
+			return nil, &conversionError{"No images could be processed into the PDF", err.Error(), http.StatusUnprocessableEntity}
 		} else if errors.Is(err, converter.ErrUnsupportedContentType) {
-			writeJSONError(w, "Unsupported image content type from URL", err.Error(), http.StatusUnprocessableEntity)
-		} else {
-			writeJSONError(w, "Failed to convert images to PDF", err.Error(), http.StatusInternalServerError)
+			return nil, &conversionError{"Unsupported image content type from URL", err.Error(), http.StatusUnprocessableEntity}
 		}
-		return
+		return nil, &conversionError{"Failed to convert images to PDF", err.Error(), http.StatusInternalServerError}
 	}
 
 	if !hasContent {
 		slog.Info("Conversion successful but PDF has no content (e.g., all images were invalid or skipped).")
-		writeJSONError(w, "No content added to PDF", "All provided images might have been invalid, corrupted, or unsupported.", http.StatusUnprocessableEntity)
-		return
+		return nil, &conversionError{"No content added to PDF", "All provided images might have been invalid, corrupted, or unsupported.", http.StatusUnprocessableEntity}
 	}
 
-	// --- Success Response ---
+	contentType, ext, _ := apiConfig.OutputType() // Validated with the config
 	outputFilename := apiConfig.OutputFilename
 	if outputFilename == "" {
 		outputFilename = "converted.pdf"
@@ -288,19 +550,114 @@ func HandleConvert(w http.ResponseWriter, r *http.Request) {
 	// Sanitize filename slightly (very basic)
 	outputFilename = strings.ReplaceAll(outputFilename, "/", "_")
 	outputFilename = strings.ReplaceAll(outputFilename, "\"", "")
-	if !strings.HasSuffix(strings.ToLower(outputFilename), ".pdf") {
-		outputFilename += ".pdf"
+	if !strings.HasSuffix(strings.ToLower(outputFilename), ext) {
+		if ext != ".pdf" && strings.HasSuffix(strings.ToLower(outputFilename), ".pdf") {
+			outputFilename = outputFilename[:len(outputFilename)-len(".pdf")]
+		}
+		outputFilename += ext
 	}
+	result.filename, result.contentType = outputFilename, contentType
+	return result, nil
+}
 
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, outputFilename))
-	contentLength := pdfOutputBuffer.Len()
-	w.Header().Set("Content-Length", strconv.Itoa(contentLength))
+// fetchURLs downloads urls, numbered from firstIndex, and returns the
+// results in that order. Downloads that fail with a transient error are
+// tried again once every URL has been tried, up to opts.FetchRetries more
+// times, opts.FetchRetryDelay apart, so a brief outage does not leave a
+// volume without one of its pages. With retries on, bodies are read in
+// full here, so a download cut short is retried rather than failing its
+// page during the conversion.
+func fetchURLs(ctx context.Context, fetch func(context.Context, string, int) (converter.ImageSource, error), urls []string, firstIndex int, opts Options, apiConfig *converter.Config) []indexedImageSource {
+	results := make([]indexedImageSource, len(urls))
+	pending := make([]int, len(urls)) // Positions in urls to fetch in this round
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; ; attempt++ {
+		var wg sync.WaitGroup
+		for _, i := range pending {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = fetchURL(ctx, fetch, urls[i], firstIndex+i, opts.FetchRetries > 0)
+				if results[i].err == nil {
+					slog.Debug("Successfully fetched URL", "url", urls[i], "filename", results[i].source.OriginalFilename, "attempt", attempt)
+					reportProgress(apiConfig, converter.ProgressFetched, firstIndex+i, results[i].source.OriginalFilename, nil)
+				}
+			}(i)
+		}
+		wg.Wait()
 
-	slog.Info("Successfully generated PDF", "filename", outputFilename, "size", contentLength)
-	if _, err := pdfOutputBuffer.WriteTo(w); err != nil {
-		// This error usually means the client closed the connection.
-		slog.Error("Failed to write PDF to response", "error", err)
-		// Cannot send JSON error here as headers are already sent.
+		var retry []int
+		for _, i := range pending {
+			if results[i].err != nil && converter.IsTransientFetchError(results[i].err) {
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 || attempt > opts.FetchRetries || !waitRetry(ctx, opts.FetchRetryDelay) {
+			break
+		}
+		slog.Info("Retrying image URLs that failed with transient errors", "count", len(retry), "retry", attempt, "of", opts.FetchRetries)
+		pending = retry
+	}
+	for i, res := range results {
+		if res.err != nil {
+			slog.Warn("Failed to fetch image from URL", "url", urls[i], "error", res.err)
+			reportProgress(apiConfig, converter.ProgressFailed, firstIndex+i, urls[i], res.err)
+		}
+	}
+	return results
+}
+
+// fetchURL downloads one image URL, reading its body in full if buffer is
+// set. A failed download's source names the URL.
+func fetchURL(ctx context.Context, fetch func(context.Context, string, int) (converter.ImageSource, error), u string, index int, buffer bool) indexedImageSource {
+	failed := converter.ImageSource{OriginalFilename: u, Index: index}
+	if ctx.Err() != nil {
+		return indexedImageSource{err: converter.CancellationError(ctx), source: failed}
+	}
+	slog.Debug("Fetching URL", "url", u, "index", index)
+	src, err := fetch(ctx, u, index)
+	if err != nil {
+		return indexedImageSource{err: err, source: failed} // Fetch closes the body on errors
+	}
+	if buffer {
+		data, err := io.ReadAll(src.Reader)
+		src.Reader.Close()
+		var bodyErr *converter.FetchBodyError
+		if errors.As(err, &bodyErr) {
+			return indexedImageSource{err: err, source: failed} // Names the URL already
+		}
+		if err != nil {
+			return indexedImageSource{err: fmt.Errorf("failed to read %s: %w", u, err), source: failed}
+		}
+		src.Reader = io.NopCloser(bytes.NewReader(data))
+	}
+	return indexedImageSource{source: src}
+}
+
+// waitRetry waits delay before a retry, and reports false if ctx ends
+// first.
+func waitRetry(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reportProgress sends a progress event for work done outside the converter,
+// such as fetching image URLs, to cfg.Progress.
+func reportProgress(cfg *converter.Config, stage converter.ProgressStage, index int, filename string, err error) {
+	if cfg.Progress == nil {
+		return
+	}
+	event := converter.ProgressEvent{Stage: stage, Index: index, Filename: filename}
+	if err != nil {
+		event.Error = err.Error()
 	}
+	cfg.Progress(event)
 }
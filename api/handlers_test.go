@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"log/slog"
+	"maps"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -44,7 +49,6 @@ func newFileUploadRequest(t *testing.T, url string, params map[string]string, fi
 			slog.Warn("API Test: image file not found, using dummy", "path", path, "using", fullPath)
 		}
 
-
 		file, err := os.Open(fullPath)
 		if err != nil {
 			t.Fatalf("Failed to open file %s: %v", fullPath, err)
@@ -153,6 +157,19 @@ func TestHandleConvert_InvalidImageURLsJSON(t *testing.T) {
 	}
 }
 
+// TestHandleConvert_UploadTooLarge tests that bodies above Options.MaxUploadBytes are rejected.
+func TestHandleConvert_UploadTooLarge(t *testing.T) {
+	files := map[string]string{"images": "dummy.txt"}
+	req := newFileUploadRequest(t, "/convert", nil, files)
+	rr := httptest.NewRecorder()
+	handler := NewConvertHandler(Options{MaxUploadBytes: 64})
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusRequestEntityTooLarge)
+		t.Logf("Response body: %s", rr.Body.String())
+	}
+}
 
 // TestHandleConvert_FetchImageFailures tests when URL fetching fails.
 func TestHandleConvert_FetchImageFailures(t *testing.T) {
@@ -215,6 +232,61 @@ func TestHandleConvert_FetchImageFailures(t *testing.T) {
 	}
 }
 
+// TestHandleConvert_FetchRetries tests that URLs failing with transient
+// errors are retried before converting, and others are not.
+func TestHandleConvert_FetchRetries(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	hits := map[string]int{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/gone.png":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/flaky.png" && n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/truncated.png" && n == 1:
+			// Promise more than is sent, so the body is cut short.
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Length", fmt.Sprint(pngData.Len()))
+			w.Write(pngData.Bytes()[:10])
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngData.Bytes())
+		}
+	}))
+	defer mockServer.Close()
+
+	params := map[string]string{
+		"image_urls": fmt.Sprintf(`["%[1]s/flaky.png", "%[1]s/truncated.png", "%[1]s/gone.png"]`, mockServer.URL),
+		"config":     `{"output_filename": "retried.pdf"}`,
+	}
+	handler := NewConvertHandler(Options{FetchRetries: 2, FetchRetryDelay: time.Millisecond})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newFileUploadRequest(t, "/convert", params, map[string]string{}))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	doc, err := converter.ReadPDF(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.NumPages() != 2 {
+		t.Errorf("Expected the two retried pages, got %d", doc.NumPages())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/flaky.png"] != 2 || hits["/truncated.png"] != 2 || hits["/gone.png"] != 1 {
+		t.Errorf("Expected the transient failures fetched twice and the 404 once, got %v", hits)
+	}
+}
+
 // TestHandleConvert_SuccessfulConversion_DummyFileAsImage
 // This test uses a dummy text file. The converter.ConvertToPDF will fail to process it as an image.
 // So, the API should return an error (e.g., 422 Unprocessable Entity).
@@ -255,20 +327,19 @@ func TestHandleConvert_DummyFileAsImage(t *testing.T) {
 	}
 }
 
-
 // TestHandleConvert_ContextCancellationDuringProcessing
 // This test is tricky because cancellation needs to happen *during* processing.
 // We can use a custom converter function that signals readiness and waits for cancellation.
 func TestHandleConvert_ContextCancellationDuringProcessing(t *testing.T) {
 	// Store the original converter function and defer its restoration
-	originalConvertToPDF := converter.ConvertToPDF
-	defer func() { converter.ConvertToPDF = originalConvertToPDF }()
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
 
 	ctxCancelledSignal := make(chan struct{})    // To signal the test that the context in handler was cancelled
 	proceedWithConversion := make(chan struct{}) // To signal the mock converter to proceed after delay
 
 	// Mock converter.ConvertToPDF
-	converter.ConvertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer) (bool, error) {
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
 		// Signal that conversion has started and is about to wait on context
 		slog.Debug("Mock ConvertToPDF started, waiting for context or proceed signal")
 		select {
@@ -279,7 +350,7 @@ func TestHandleConvert_ContextCancellationDuringProcessing(t *testing.T) {
 		case <-proceedWithConversion:
 			slog.Debug("Mock ConvertToPDF: Proceeding after signal (context not cancelled yet).")
 			// Simulate some work and then a successful conversion
-			fmt.Fprint(writer, "%PDF-1.4\n%%EOF\n") // Minimal PDF
+			io.WriteString(writer, "%PDF-1.4\n%%EOF\n") // Minimal PDF
 			return true, nil
 		case <-time.After(5 * time.Second): // Timeout for the mock converter itself
 			slog.Error("Mock ConvertToPDF: timed out waiting for context cancellation or proceed signal")
@@ -320,7 +391,6 @@ func TestHandleConvert_ContextCancellationDuringProcessing(t *testing.T) {
 		t.Error("Test: Mock converter did not signal context cancellation in time.")
 	}
 
-
 	// Expected status depends on when cancellation is caught.
 	// If caught by server/handler before PDF generation logic fully completes and writes headers,
 	// it might be 499 (if server supports it) or a timeout-like status.
@@ -342,7 +412,6 @@ func TestHandleConvert_ContextCancellationDuringProcessing(t *testing.T) {
 	}
 }
 
-
 // TestMain is used to create dummy files in testdata if they don't exist.
 func TestMain(m *testing.M) {
 	// Create api/testdata directory if it doesn't exist
@@ -369,7 +438,6 @@ func TestMain(m *testing.M) {
 		}
 	}
 
-
 	// TODO: Add small, valid test.jpg, test.png, test.webp files to api/testdata
 	// For example:
 	// CreateDummyImage(filepath.Join(testDataDir, "test.jpg"), "jpg")
@@ -434,3 +502,143 @@ func TestHandleConvert_Success(t *testing.T) {
 	t.Logf("Successfully received PDF of size %d bytes", rr.Body.Len())
 }
 */
+
+func TestHandleConvert_Timeout(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		<-ctx.Done()
+		return false, converter.CancellationError(ctx)
+	}
+
+	req := newFileUploadRequest(t, "/convert", nil, map[string]string{"images": "dummy.txt"})
+	rr := httptest.NewRecorder()
+	NewConvertHandler(Options{ConvertTimeout: 50 * time.Millisecond})(rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 after the time limit, got %d", rr.Code)
+	}
+	var resp APIErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Could not parse JSON error response: %v", err)
+	}
+	if !strings.Contains(resp.Error, "time limit reached") {
+		t.Errorf("Expected the timeout reason in the error, got %q", resp.Error)
+	}
+}
+
+func TestHandleConvert_PixelBudget(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		if cfg.MaxTotalMegapixels != 100 {
+			t.Errorf("Expected the server budget to cap the requested one, got %g", cfg.MaxTotalMegapixels)
+		}
+		return false, fmt.Errorf("%w: %w", context.Canceled, &converter.PixelBudgetError{Budget: 100, Decoded: 120, Filename: "huge.png"})
+	}
+
+	req := newFileUploadRequest(t, "/convert", map[string]string{"config": `{"max_total_megapixels": 500}`}, map[string]string{"images": "dummy.txt"})
+	rr := httptest.NewRecorder()
+	NewConvertHandler(Options{MaxMegapixels: 100})(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a conversion over its pixel budget, got %d", rr.Code)
+	}
+	var resp APIErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Could not parse JSON error response: %v", err)
+	}
+	if details, _ := resp.Details.(string); !strings.Contains(details, "pixel budget of 100 megapixels exceeded") {
+		t.Errorf("Expected the budget in the error details, got %q", resp.Details)
+	}
+}
+
+func TestCapDecodeLimits(t *testing.T) {
+	got := capDecodeLimits(map[string]int{"webp": 4, "png": 1, "gif": 3}, map[string]int{"webp": 2, "jpeg": 8, "png": 2})
+	want := map[string]int{"webp": 2, "jpeg": 8, "png": 1, "gif": 3}
+	if !maps.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := capDecodeLimits(nil, nil); got != nil {
+		t.Errorf("Expected no limits without any, got %v", got)
+	}
+}
+
+func TestHandleConvert_Profile(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		if cfg.MaxWidth != 1236 || !cfg.Grayscale || cfg.JPEGQuality != 70 {
+			t.Errorf("Expected the Kindle profile with the requested quality, got max width %d, grayscale %v, quality %d", cfg.MaxWidth, cfg.Grayscale, cfg.JPEGQuality)
+		}
+		io.WriteString(writer, "%PDF-")
+		return true, nil
+	}
+
+	params := map[string]string{"config": `{"jpeg_quality": 70, "profile": "kindle-paperwhite"}`}
+	req := newFileUploadRequest(t, "/convert", params, map[string]string{"images": "dummy.txt"})
+	rr := httptest.NewRecorder()
+	HandleConvert(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	params = map[string]string{"config": `{"profile": "etch-a-sketch"}`}
+	req = newFileUploadRequest(t, "/convert", params, map[string]string{"images": "dummy.txt"})
+	rr = httptest.NewRecorder()
+	HandleConvert(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown profile, got %d", rr.Code)
+	}
+}
+
+func TestHandleConvert_EPUBOutput(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		if cfg.OutputFormat != converter.FormatEPUB {
+			t.Errorf("Expected the EPUB format to reach the converter, got %q", cfg.OutputFormat)
+		}
+		io.WriteString(writer, "PK")
+		return true, nil
+	}
+
+	params := map[string]string{"config": `{"output_format": "epub", "output_filename": "vol1.pdf"}`}
+	req := newFileUploadRequest(t, "/convert", params, map[string]string{"images": "dummy.txt"})
+	rr := httptest.NewRecorder()
+	HandleConvert(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/epub+zip" {
+		t.Errorf("Expected EPUB content type, got %q", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="vol1.epub"`) {
+		t.Errorf("Expected the filename to get the EPUB extension, got %q", cd)
+	}
+
+	params = map[string]string{"config": `{"output_format": "mobi"}`}
+	req = newFileUploadRequest(t, "/convert", params, map[string]string{"images": "dummy.txt"})
+	rr = httptest.NewRecorder()
+	HandleConvert(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown output_format, got %d", rr.Code)
+	}
+
+	params = map[string]string{"config": `{"watermark": {"text": "Review copy", "position": "middle"}}`}
+	req = newFileUploadRequest(t, "/convert", params, map[string]string{"images": "dummy.txt"})
+	rr = httptest.NewRecorder()
+	HandleConvert(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid watermark, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DependencyCheck probes one service or resource the server depends on.
+// Check returns nil when the dependency is usable.
+type DependencyCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// DependencyStatus is the outcome of the latest run of a DependencyCheck.
+type DependencyStatus struct {
+	Name      string    `json:"name"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthMonitor runs dependency checks and remembers their latest results,
+// so /readyz can answer without probing every dependency on each request.
+type HealthMonitor struct {
+	checks  []DependencyCheck
+	timeout time.Duration
+
+	mu       sync.RWMutex
+	statuses map[string]DependencyStatus
+}
+
+// NewHealthMonitor returns a monitor for checks. Each check run is limited
+// to timeout (10 seconds if zero).
+func NewHealthMonitor(checks []DependencyCheck, timeout time.Duration) *HealthMonitor {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return &HealthMonitor{checks: checks, timeout: timeout, statuses: map[string]DependencyStatus{}}
+}
+
+// CheckNow runs every check concurrently, records the results and returns
+// them sorted by name.
+func (m *HealthMonitor) CheckNow(ctx context.Context) []DependencyStatus {
+	results := make([]DependencyStatus, len(m.checks))
+	var wg sync.WaitGroup
+	for i, check := range m.checks {
+		wg.Add(1)
+		go func(i int, check DependencyCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, m.timeout)
+			defer cancel()
+			started := time.Now()
+			err := check.Check(checkCtx)
+			status := DependencyStatus{Name: check.Name, OK: err == nil, LatencyMS: time.Since(started).Milliseconds(), CheckedAt: started}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			results[i] = status
+		}(i, check)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	for _, status := range results {
+		previous, seen := m.statuses[status.Name]
+		switch {
+		case !status.OK && (!seen || previous.OK):
+			slog.Warn("Dependency check failed", "dependency", status.Name, "error", status.Error)
+		case status.OK && seen && !previous.OK:
+			slog.Info("Dependency recovered", "dependency", status.Name)
+		}
+		m.statuses[status.Name] = status
+	}
+	m.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// Run re-checks all dependencies every interval until ctx is done.
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.CheckNow(ctx)
+		}
+	}
+}
+
+// Statuses returns the latest results sorted by name, and whether all
+// dependencies are healthy.
+func (m *HealthMonitor) Statuses() ([]DependencyStatus, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	statuses := make([]DependencyStatus, 0, len(m.statuses))
+	ready := true
+	for _, status := range m.statuses {
+		statuses = append(statuses, status)
+		ready = ready && status.OK
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses, ready
+}
+
+// ReadyHandler serves /readyz: 200 when every dependency passed its latest
+// check, 503 otherwise, with the per-dependency results as JSON.
+func (m *HealthMonitor) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses, ready := m.Statuses()
+		response := struct {
+			Status       string             `json:"status"`
+			Dependencies []DependencyStatus `json:"dependencies"`
+		}{Status: "ready", Dependencies: statuses}
+		statusCode := http.StatusOK
+		if !ready {
+			response.Status = "unavailable"
+			statusCode = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(statusCode)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to write readiness response", "error", err)
+		}
+	}
+}
+
+// HTTPCheck returns a check that succeeds when url answers a HEAD request
+// with a status below 500. Any answer proves the service is reachable;
+// 5xx means it is up but not working.
+func HTTPCheck(name, url string) DependencyCheck {
+	return DependencyCheck{Name: name, Check: func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s answered %s", url, resp.Status)
+		}
+		return nil
+	}}
+}
+
+// WritableDirCheck returns a check that succeeds when a file can be created
+// and removed in dir, e.g. to notice a full or remounted read-only volume.
+func WritableDirCheck(name, dir string) DependencyCheck {
+	return DependencyCheck{Name: name, Check: func(ctx context.Context) error {
+		probe, err := os.CreateTemp(dir, ".health-check-*")
+		if err != nil {
+			return err
+		}
+		probe.Close()
+		return os.Remove(probe.Name())
+	}}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthMonitor_ReadyHandler(t *testing.T) {
+	var storageErr error
+	monitor := NewHealthMonitor([]DependencyCheck{
+		{Name: "storage", Check: func(ctx context.Context) error { return storageErr }},
+		WritableDirCheck("data_dir", t.TempDir()),
+	}, 0)
+
+	get := func() (int, map[string]DependencyStatus) {
+		rr := httptest.NewRecorder()
+		monitor.ReadyHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body struct {
+			Dependencies []DependencyStatus `json:"dependencies"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Could not decode /readyz response: %v", err)
+		}
+		statuses := map[string]DependencyStatus{}
+		for _, status := range body.Dependencies {
+			statuses[status.Name] = status
+		}
+		return rr.Code, statuses
+	}
+
+	storageErr = errors.New("connection refused")
+	monitor.CheckNow(context.Background())
+	code, statuses := get()
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with a failing dependency, got %d", code)
+	}
+	if statuses["storage"].OK || statuses["storage"].Error != "connection refused" || !statuses["data_dir"].OK {
+		t.Errorf("Unexpected dependency statuses: %+v", statuses)
+	}
+
+	storageErr = nil
+	monitor.CheckNow(context.Background())
+	if code, _ := get(); code != http.StatusOK {
+		t.Errorf("Expected 200 once the dependency recovered, got %d", code)
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	check := HTTPCheck("upscaler", server.URL)
+	if err := check.Check(context.Background()); err != nil {
+		t.Errorf("Expected any non-5xx answer to pass, got %v", err)
+	}
+	status = http.StatusBadGateway
+	if err := check.Check(context.Background()); err == nil {
+		t.Error("Expected a 5xx answer to fail")
+	}
+}
@@ -0,0 +1,692 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+	"manga_to_pdf/internal/storage"
+)
+
+// Job states reported by the jobs API.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// DefaultJobRetention is how long a finished job and its output are kept
+// when NewJobManager is given no retention.
+const DefaultJobRetention = time.Hour
+
+// evictInterval is how often finished jobs past the retention period and
+// idle sessions are dropped when no request comes in to drop them.
+const evictInterval = time.Minute
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// proxies do not close it while a slow page is being processed.
+const sseKeepAlive = 15 * time.Second
+
+// JobManager runs conversions in the background for the /jobs endpoints.
+// Jobs are kept in memory, and their output too unless Options.Storage is
+// set, until they have been finished for longer than the retention period,
+// are deleted, or are dropped to keep their tenant within
+// Options.TenantQuota. Each job belongs to the tenant that created it and
+// is invisible to the others; stored outputs are kept under the tenant's
+// name. Options.MaxJobs and Options.MaxTenantJobs cap the jobs running at
+// once, and Options.MaxSessions and Options.MaxTenantSessions the open
+// upload sessions.
+type JobManager struct {
+	opts      Options
+	retention time.Duration
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
+
+	mu   sync.Mutex
+	jobs map[string]*job
+
+	sessionIdle time.Duration
+	sessions    map[string]*session
+}
+
+// job is one background conversion. Its progress events are appended as
+// they arrive; changed is closed and replaced on every update so event
+// streams can wait for the next one.
+type job struct {
+	id      string
+	tenant  string
+	created time.Time
+	cancel  context.CancelCauseFunc
+	webhook string // Receiver notified when the job finishes, if any
+
+	mu       sync.Mutex
+	status   string
+	finished time.Time
+	events   []converter.ProgressEvent
+	changed  chan struct{}
+	result   *conversionResult
+	err      *conversionError
+	key      string // Key of the output in Options.Storage, once stored there
+	size     int    // Size of the output, wherever it is kept
+}
+
+// JobStatus is the JSON description of a job.
+type JobStatus struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Events     int             `json:"events"`                 // Progress events so far
+	Filename   string          `json:"filename,omitempty"`     // Set once the job is done
+	Download   string          `json:"download_url,omitempty"` // Where to fetch the output once the job is done
+	Error      string          `json:"error,omitempty"`        // Set if the job failed
+	Details    interface{}     `json:"details,omitempty"`
+	Failures   []SourceFailure `json:"failures,omitempty"` // Image URLs that could not be fetched
+}
+
+// NewJobManager returns a JobManager converting with the given server
+// options. Finished jobs are dropped after retention (DefaultJobRetention
+// if zero).
+func NewJobManager(opts Options, retention time.Duration) *JobManager {
+	if retention <= 0 {
+		retention = DefaultJobRetention
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	m := &JobManager{opts: opts, retention: retention, ctx: ctx, cancel: cancel, jobs: map[string]*job{}, sessionIdle: SessionIdleTimeout, sessions: map[string]*session{}}
+	go m.evictExpired()
+	if pruner, ok := opts.Storage.(storage.Pruner); ok {
+		go m.pruneStorage(pruner)
+	}
+	return m
+}
+
+// evictExpired drops the jobs finished for longer than the retention period
+// and the idle sessions every evictInterval, or every retention period if
+// that is shorter, until m is closed, so their outputs and pages are freed
+// even if no request comes in.
+func (m *JobManager) evictExpired() {
+	ticker := time.NewTicker(min(m.retention, evictInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.mu.Lock()
+			m.evictLocked(now)
+			m.evictSessionsLocked(now)
+			m.mu.Unlock()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// pruneStorage removes the stored outputs older than the retention period
+// now and then every retention period until m is closed. Outputs are only
+// found through m.jobs, so those of a server that stopped before they
+// expired would otherwise be kept forever, outside any tenant's quota.
+func (m *JobManager) pruneStorage(pruner storage.Pruner) {
+	ticker := time.NewTicker(m.retention)
+	defer ticker.Stop()
+	for {
+		// An output is stored just before its job finishes; the margin keeps
+		// it until the job has expired too.
+		removed, err := pruner.Prune(m.ctx, time.Now().Add(-m.retention-time.Minute))
+		if err != nil && m.ctx.Err() == nil {
+			slog.Warn("Failed to prune stored job outputs", "error", err)
+		} else if removed > 0 {
+			slog.Info("Pruned expired job outputs from storage", "count", removed)
+		}
+		select {
+		case <-ticker.C:
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops the jobs still running with converter.ErrShuttingDown.
+func (m *JobManager) Close() {
+	m.cancel(converter.ErrShuttingDown)
+}
+
+// PurgeResult is the response of DELETE /jobs.
+type PurgeResult struct {
+	Purged     int   `json:"purged"`      // Jobs deleted, running ones included
+	FreedBytes int64 `json:"freed_bytes"` // Size of the outputs deleted with them
+}
+
+// Handler returns the handler for POST /jobs, DELETE /jobs, GET and
+// DELETE /jobs/{id}, GET /jobs/{id}/events and GET /jobs/{id}/result, and
+// for the upload sessions that end in a job: POST /sessions, GET and
+// DELETE /sessions/{id}, POST /sessions/{id}/pages and
+// POST /sessions/{id}/finalize.
+func (m *JobManager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", m.handleCreate)
+	mux.HandleFunc("DELETE /jobs", m.handlePurge)
+	mux.HandleFunc("GET /jobs/{id}", m.withJob(m.handleStatus))
+	mux.HandleFunc("DELETE /jobs/{id}", m.withJob(m.handleDelete))
+	mux.HandleFunc("GET /jobs/{id}/events", m.withJob(m.handleEvents))
+	mux.HandleFunc("GET /jobs/{id}/result", m.withJob(m.handleResult))
+	mux.HandleFunc("POST /sessions", m.handleOpenSession)
+	mux.HandleFunc("GET /sessions/{id}", m.withSession(m.handleSessionStatus))
+	mux.HandleFunc("DELETE /sessions/{id}", m.withSession(m.handleDeleteSession))
+	mux.HandleFunc("POST /sessions/{id}/pages", m.withSession(m.handleAppendPages))
+	mux.HandleFunc("POST /sessions/{id}/finalize", m.withSession(m.handleFinalizeSession))
+	return mux
+}
+
+// handleCreate starts a job from a /convert-style request and answers 202
+// with the job's status and its URL in the Location header.
+func (m *JobManager) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if m.opts.MaxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, m.opts.MaxUploadBytes)
+	}
+	defer func() {
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	req := parseConvertRequest(w, r, m.opts, nil)
+	if req == nil {
+		return
+	}
+	webhook, err := m.requestWebhook(r)
+	if err != nil {
+		req.closeUploads()
+		writeJSONError(w, "Invalid webhook_url", err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := holdUploads(w, req); !ok {
+		return
+	}
+	j, ctx := m.addJob(w, r, webhook)
+	if j == nil {
+		req.closeUploads()
+		return
+	}
+	m.startJob(ctx, w, r, j, req)
+}
+
+// requestWebhook returns the webhook_url of the request r, empty if it has
+// none, or why it cannot be used.
+func (m *JobManager) requestWebhook(r *http.Request) (string, error) {
+	webhook := r.FormValue("webhook_url")
+	if webhook == "" {
+		return "", nil
+	}
+	if err := validWebhookURL(webhook); err != nil {
+		return "", err
+	}
+	if m.opts.Webhooks == nil {
+		return "", errors.New("this server does not send webhooks")
+	}
+	if err := m.opts.Webhooks.checkHost(webhook); err != nil {
+		return "", err
+	}
+	return webhook, nil
+}
+
+// holdUploads replaces the readers of req's uploads with copies held in
+// memory, since multipart files are removed when the request ends, and
+// returns their total size. On failure it closes the uploads, writes the
+// error response and returns false.
+func holdUploads(w http.ResponseWriter, req *convertRequest) (int64, bool) {
+	var size int64
+	for i, src := range req.uploads {
+		data, err := io.ReadAll(src.Reader)
+		src.Reader.Close()
+		if err != nil {
+			req.closeUploads()
+			writeJSONError(w, fmt.Sprintf("Failed to read uploaded file: %s", src.OriginalFilename), err.Error(), http.StatusBadRequest)
+			return 0, false
+		}
+		req.uploads[i].Reader = io.NopCloser(bytes.NewReader(data))
+		size += int64(len(data))
+	}
+	return size, true
+}
+
+// addJob registers a running job for the tenant of r and returns it with
+// the context it runs in. If the tenant already runs Options.MaxTenantJobs
+// jobs it answers 429, if the server runs Options.MaxJobs it answers 503,
+// and it returns nil either way.
+func (m *JobManager) addJob(w http.ResponseWriter, r *http.Request, webhook string) (*job, context.Context) {
+	id, err := newJobID()
+	if err != nil {
+		writeJSONError(w, "Failed to create job", err.Error(), http.StatusInternalServerError)
+		return nil, nil
+	}
+	tenant := Tenant(r.Context())
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evictLocked(now)
+	running, tenantRunning := m.runningLocked(tenant)
+	switch {
+	case m.opts.MaxTenantJobs > 0 && tenantRunning >= m.opts.MaxTenantJobs:
+		writeJSONError(w, "Too many running jobs", fmt.Sprintf("This tenant already runs %d jobs, the maximum", tenantRunning), http.StatusTooManyRequests)
+		return nil, nil
+	case m.opts.MaxJobs > 0 && running >= m.opts.MaxJobs:
+		writeJSONError(w, "Server busy", fmt.Sprintf("The server already runs %d jobs, the maximum", running), http.StatusServiceUnavailable)
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancelCause(m.ctx)
+	j := &job{id: id, tenant: tenant, created: now, cancel: cancel, webhook: webhook, status: JobRunning, changed: make(chan struct{})}
+	m.jobs[id] = j
+	return j, ctx
+}
+
+// runningLocked returns how many jobs are running, in total and for the
+// tenant. m.mu must be held.
+func (m *JobManager) runningLocked(tenant string) (running, tenantRunning int) {
+	for _, j := range m.jobs {
+		j.mu.Lock()
+		if j.status == JobRunning {
+			running++
+			if j.tenant == tenant {
+				tenantRunning++
+			}
+		}
+		j.mu.Unlock()
+	}
+	return running, tenantRunning
+}
+
+// startJob starts j, added with addJob, converting req in ctx. The uploads
+// of req must be held in memory. It answers 202 with the job's status and
+// its URL in the Location header.
+func (m *JobManager) startJob(ctx context.Context, w http.ResponseWriter, r *http.Request, j *job, req *convertRequest) {
+	req.config.Progress = j.addEvent
+	slog.Info("Started conversion job", "job", j.id, "tenant", j.tenant, "uploads", len(req.uploads), "urls", len(req.urls))
+	go m.run(ctx, j, req)
+
+	w.Header().Set("Location", versionPrefix(r.Context())+"/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, m.status(r.Context(), j))
+}
+
+// run converts req and records the outcome in j. ctx is canceled when the
+// job is deleted.
+func (m *JobManager) run(ctx context.Context, j *job, req *convertRequest) {
+	defer j.cancel(nil)
+	if m.opts.ConvertTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, m.opts.ConvertTimeout, converter.ErrTimedOut)
+		defer cancel()
+	}
+	result, convErr := runConversion(ctx, req, m.opts, nil)
+	var key string
+	var size int
+	if convErr == nil {
+		size = result.output.Len()
+		if m.opts.TenantQuota > 0 && int64(size) > m.opts.TenantQuota {
+			convErr = &conversionError{"Output exceeds the tenant storage quota", fmt.Sprintf("The output is %d bytes, the quota is %d bytes", size, m.opts.TenantQuota), http.StatusRequestEntityTooLarge}
+		}
+	}
+	if convErr == nil && m.opts.Storage != nil {
+		key = j.tenant + "/" + j.id + path.Ext(result.filename)
+		if err := m.opts.Storage.Put(ctx, key, bytes.NewReader(result.output.Bytes()), int64(size), result.contentType); err != nil {
+			slog.Error("Failed to store job output", "job", j.id, "error", err)
+			convErr = &conversionError{"Failed to store the converted file", err.Error(), http.StatusInternalServerError}
+		} else {
+			result.output = bytes.Buffer{} // Served from storage from now on
+		}
+	}
+
+	// m.mu is held until the tenant is trimmed, so clients told the job is
+	// done never see the outputs it displaced.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.mu.Lock()
+	j.finished = time.Now()
+	if convErr != nil {
+		slog.Warn("Conversion job failed", "job", j.id, "error", convErr.message)
+		j.status, j.err = JobFailed, convErr
+	} else {
+		slog.Info("Conversion job done", "job", j.id, "filename", result.filename, "size", size)
+		j.status, j.result, j.key, j.size = JobDone, result, key, size
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+	j.mu.Unlock()
+
+	if m.jobs[j.id] != j {
+		// Deleted while running; its output may have been stored since.
+		m.deleteOutput(j.id, key)
+		return
+	}
+	m.trimTenantLocked(j.tenant)
+	if j.webhook != "" {
+		status := m.status(context.Background(), j)
+		if err := m.opts.Webhooks.Notify(j.tenant, j.webhook, "job."+status.Status, status); err != nil {
+			slog.Error("Failed to queue job webhook", "job", j.id, "error", err)
+		}
+	}
+}
+
+// evictLocked drops jobs that finished more than the retention period
+// before now. m.mu must be held.
+func (m *JobManager) evictLocked(now time.Time) {
+	for _, j := range m.jobs {
+		j.mu.Lock()
+		expired := !j.finished.IsZero() && now.Sub(j.finished) > m.retention
+		j.mu.Unlock()
+		if expired {
+			m.dropLocked(j)
+		}
+	}
+}
+
+// trimTenantLocked drops the tenant's oldest finished outputs until the
+// rest fit in Options.TenantQuota. m.mu must be held.
+func (m *JobManager) trimTenantLocked(tenant string) {
+	if m.opts.TenantQuota <= 0 {
+		return
+	}
+	type doneJob struct {
+		job      *job
+		finished time.Time
+		size     int64
+	}
+	var done []doneJob
+	var used int64
+	for _, j := range m.jobs {
+		if j.tenant != tenant {
+			continue
+		}
+		j.mu.Lock()
+		if j.status == JobDone {
+			done = append(done, doneJob{j, j.finished, int64(j.size)})
+			used += int64(j.size)
+		}
+		j.mu.Unlock()
+	}
+	sort.Slice(done, func(a, b int) bool { return done[a].finished.Before(done[b].finished) })
+	for _, d := range done {
+		if used <= m.opts.TenantQuota {
+			break
+		}
+		slog.Info("Dropping job output to stay within the tenant quota", "job", d.job.id, "tenant", tenant, "size", d.size)
+		m.dropLocked(d.job)
+		used -= d.size
+	}
+}
+
+// dropLocked forgets j, stopping it if it is still running, and deletes its
+// stored output. It returns the size of the output. m.mu must be held.
+func (m *JobManager) dropLocked(j *job) int64 {
+	delete(m.jobs, j.id)
+	j.cancel(converter.ErrCanceledByUser)
+	j.mu.Lock()
+	key, size := j.key, j.size
+	j.mu.Unlock()
+	m.deleteOutput(j.id, key)
+	return int64(size)
+}
+
+// deleteOutput removes a job output from Options.Storage in the background;
+// an empty key means it was never stored there.
+func (m *JobManager) deleteOutput(id, key string) {
+	if key == "" {
+		return
+	}
+	go func() {
+		if err := m.opts.Storage.Delete(context.Background(), key); err != nil {
+			slog.Warn("Failed to delete job output", "job", id, "error", err)
+		}
+	}()
+}
+
+// handleDelete deletes a job, stopping it if it is still running.
+func (m *JobManager) handleDelete(w http.ResponseWriter, r *http.Request, j *job) {
+	m.mu.Lock()
+	m.dropLocked(j)
+	m.mu.Unlock()
+	slog.Info("Deleted conversion job", "job", j.id, "tenant", j.tenant)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurge deletes all of the caller's jobs and their outputs.
+func (m *JobManager) handlePurge(w http.ResponseWriter, r *http.Request) {
+	tenant := Tenant(r.Context())
+	var result PurgeResult
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		if j.tenant == tenant {
+			result.FreedBytes += m.dropLocked(j)
+			result.Purged++
+		}
+	}
+	m.mu.Unlock()
+	slog.Info("Purged conversion jobs", "tenant", tenant, "jobs", result.Purged, "bytes", result.FreedBytes)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// withJob looks up the job named by the {id} path value, answering 404 if
+// there is none or it belongs to another tenant.
+func (m *JobManager) withJob(next func(http.ResponseWriter, *http.Request, *job)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		j := m.jobs[r.PathValue("id")]
+		m.mu.Unlock()
+		if j == nil || j.tenant != Tenant(r.Context()) {
+			writeJSONError(w, "Job not found", r.PathValue("id"), http.StatusNotFound)
+			return
+		}
+		next(w, r, j)
+	}
+}
+
+func (m *JobManager) handleStatus(w http.ResponseWriter, r *http.Request, j *job) {
+	writeJSON(w, http.StatusOK, m.status(r.Context(), j))
+}
+
+// status describes j, with the URL its output can be downloaded from once
+// it is done: a direct link from storage backends that offer one, or the
+// job's result endpoint under the API version of the request behind ctx.
+func (m *JobManager) status(ctx context.Context, j *job) JobStatus {
+	status := j.statusJSON()
+	if status.Status != JobDone {
+		return status
+	}
+	status.Download = versionPrefix(ctx) + "/jobs/" + j.id + "/result"
+	j.mu.Lock()
+	key := j.key
+	j.mu.Unlock()
+	if key != "" {
+		url, err := m.opts.Storage.DownloadURL(ctx, key, status.Filename, m.retention)
+		if err != nil {
+			slog.Warn("Failed to create download URL for job output", "job", j.id, "error", err)
+		} else if url != "" {
+			status.Download = url
+		}
+	}
+	return status
+}
+
+// handleResult sends the output of a finished job.
+func (m *JobManager) handleResult(w http.ResponseWriter, r *http.Request, j *job) {
+	j.mu.Lock()
+	status, result, convErr, key, size := j.status, j.result, j.err, j.key, j.size
+	j.mu.Unlock()
+	switch status {
+	case JobRunning:
+		writeJSONError(w, "Job is still running", j.id, http.StatusConflict)
+		return
+	case JobFailed:
+		writeJSONError(w, convErr.message, convErr.details, convErr.status)
+		return
+	}
+	if key != "" {
+		m.serveStored(w, r, j, result, key, size)
+		return
+	}
+	w.Header().Set("Content-Type", result.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, result.filename))
+	w.Header().Set("Content-Length", strconv.Itoa(result.output.Len()))
+	// The buffer is read without being consumed so the result can be
+	// downloaded again until the job expires.
+	if _, err := w.Write(result.output.Bytes()); err != nil {
+		slog.Error("Failed to write job result to response", "job", j.id, "error", err)
+	}
+}
+
+// serveStored sends a job output kept in Options.Storage, redirecting to
+// the backend's download URL when it has one.
+func (m *JobManager) serveStored(w http.ResponseWriter, r *http.Request, j *job, result *conversionResult, key string, size int) {
+	url, err := m.opts.Storage.DownloadURL(r.Context(), key, result.filename, m.retention)
+	if err != nil {
+		writeJSONError(w, "Failed to create download URL", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if url != "" {
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	rc, err := m.opts.Storage.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeJSONError(w, "Job output no longer exists", err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "Failed to read job output", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", result.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, result.filename))
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	if _, err := io.Copy(w, rc); err != nil {
+		slog.Error("Failed to write job result to response", "job", j.id, "error", err)
+	}
+}
+
+// handleEvents streams the job's progress as Server-Sent Events: one
+// "progress" event per converter.ProgressEvent, numbered from 1, then a
+// final "done" or "failed" event carrying the job status. A reconnecting
+// client resumes after its Last-Event-ID.
+func (m *JobManager) handleEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	next := 0
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && lastID > 0 {
+		next = lastID
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream lasts as long as the conversion, which may exceed the
+	// server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("Could not clear write deadline for event stream", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		j.mu.Lock()
+		events := j.events[min(next, len(j.events)):]
+		status, changed := j.status, j.changed
+		j.mu.Unlock()
+
+		for _, event := range events {
+			next++
+			if err := writeSSE(w, strconv.Itoa(next), "progress", event); err != nil {
+				return
+			}
+		}
+		if status != JobRunning {
+			writeSSE(w, "", status, m.status(r.Context(), j))
+			rc.Flush()
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes one Server-Sent Event with data encoded as JSON.
+func writeSSE(w io.Writer, id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// addEvent records a progress event; it is the job's converter.ProgressFunc.
+func (j *job) addEvent(event converter.ProgressEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events = append(j.events, event)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *job) statusJSON() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := JobStatus{ID: j.id, Status: j.status, CreatedAt: j.created, Events: len(j.events)}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.FinishedAt = &finished
+	}
+	if j.result != nil {
+		status.Filename = j.result.filename
+		status.Failures = j.result.fetchFailures
+	}
+	if j.err != nil {
+		status.Error, status.Details = j.err.message, j.err.details
+	}
+	return status
+}
+
+// newJobID returns a random, unguessable job identifier.
+func newJobID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
@@ -0,0 +1,410 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+	"manga_to_pdf/internal/storage"
+)
+
+func TestJobs_EventsAndResult(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			data, _ := io.ReadAll(src.Reader)
+			src.Reader.Close()
+			if len(data) == 0 {
+				t.Errorf("Upload %s reached the converter empty", src.OriginalFilename)
+			}
+			cfg.Progress(converter.ProgressEvent{Stage: converter.ProgressDecoded, Index: src.Index, Filename: src.OriginalFilename})
+			cfg.Progress(converter.ProgressEvent{Stage: converter.ProgressPageAdded, Index: src.Index, Filename: src.OriginalFilename})
+		}
+		io.WriteString(writer, "%PDF-stub")
+		return true, nil
+	}
+
+	jobs := NewJobManager(Options{}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(jobs.Handler())
+	defer server.Close()
+
+	req := newFileUploadRequest(t, server.URL+"/jobs", map[string]string{"config": `{"output_filename": "job.pdf"}`}, map[string]string{"images": "dummy.txt"})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /jobs failed: %v", err)
+	}
+	var created JobStatus
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || created.ID == "" {
+		t.Fatalf("Expected 202 with a job id, got %d %+v", resp.StatusCode, created)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/jobs/"+created.ID {
+		t.Errorf("Expected Location /jobs/%s, got %q", created.ID, loc)
+	}
+
+	resp, err = http.Get(server.URL + "/jobs/" + created.ID + "/events")
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", ct)
+	}
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			events = append(events, name)
+		}
+	}
+	if want := "progress,progress,done"; strings.Join(events, ",") != want {
+		t.Errorf("Expected events %s, got %v", want, events)
+	}
+
+	// A client reconnecting after the first event only gets the rest.
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/jobs/"+created.ID+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	replay, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(replay), "id: 1\n") || !strings.Contains(string(replay), "id: 2\n") {
+		t.Errorf("Expected the stream to resume after event 1, got:\n%s", replay)
+	}
+
+	resp, err = http.Get(server.URL + "/jobs/" + created.ID + "/result")
+	if err != nil {
+		t.Fatalf("GET result failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "%PDF-stub" {
+		t.Errorf("Expected the converted PDF, got %d %q", resp.StatusCode, body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, `filename="job.pdf"`) {
+		t.Errorf("Expected job.pdf as the download name, got %q", cd)
+	}
+}
+
+func TestJobs_UnknownJob(t *testing.T) {
+	jobs := NewJobManager(Options{}, 0)
+	defer jobs.Close()
+	rr := httptest.NewRecorder()
+	jobs.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/jobs/missing/events", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown job, got %d", rr.Code)
+	}
+}
+
+func TestJobs_Storage(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		io.WriteString(writer, "%PDF-stored")
+		return true, nil
+	}
+
+	dir := t.TempDir()
+	st, err := storage.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := NewJobManager(Options{Storage: st}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(jobs.Handler())
+	defer server.Close()
+
+	resp, err := http.DefaultClient.Do(newFileUploadRequest(t, server.URL+"/jobs", nil, map[string]string{"images": "dummy.txt"}))
+	if err != nil {
+		t.Fatalf("POST /jobs failed: %v", err)
+	}
+	var status JobStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+
+	// The event stream ends when the job does.
+	resp, err = http.Get(server.URL + "/jobs/" + status.ID + "/events")
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/jobs/" + status.ID)
+	if err != nil {
+		t.Fatalf("GET status failed: %v", err)
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Status != JobDone || status.Download != "/jobs/"+status.ID+"/result" {
+		t.Fatalf("Expected a done job served by the API, got %+v", status)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, DefaultTenant, status.ID+".pdf")); err != nil || string(data) != "%PDF-stored" {
+		t.Errorf("Expected the output in the storage directory, got %q, %v", data, err)
+	}
+
+	resp, err = http.Get(server.URL + status.Download)
+	if err != nil {
+		t.Fatalf("GET result failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "%PDF-stored" || resp.Header.Get("Content-Length") != "11" {
+		t.Errorf("Expected the stored PDF, got %q (Content-Length %s)", body, resp.Header.Get("Content-Length"))
+	}
+}
+
+func TestJobs_PrunesStorageOnStart(t *testing.T) {
+	dir := t.TempDir()
+	st, err := storage.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Outputs left behind by a server that stopped before they expired.
+	orphan, recent := filepath.Join(dir, DefaultTenant, "orphan.pdf"), filepath.Join(dir, DefaultTenant, "recent.pdf")
+	for _, path := range []string{orphan, recent} {
+		if err := st.Put(context.Background(), DefaultTenant+"/"+filepath.Base(path), strings.NewReader("%PDF"), 4, "application/pdf"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := NewJobManager(Options{Storage: st}, 30*time.Minute)
+	defer jobs.Close()
+	fileExists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fileExists(orphan) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fileExists(orphan) {
+		t.Error("Expected an output older than the retention period to be pruned")
+	}
+	if !fileExists(recent) {
+		t.Error("Expected an output within the retention period to be kept")
+	}
+}
+
+func TestJobs_Tenants(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		io.WriteString(writer, "%PDF-1234") // 9 bytes
+		return true, nil
+	}
+
+	jobs := NewJobManager(Options{TenantQuota: 20}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(RequireBearerToken([]string{"alice", "bob"}, jobs.Handler()))
+	defer server.Close()
+
+	do := func(token string, req *http.Request) *http.Response {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", req.Method, req.URL.Path, err)
+		}
+		return resp
+	}
+	create := func(token string) string {
+		t.Helper()
+		resp := do(token, newFileUploadRequest(t, server.URL+"/jobs", nil, map[string]string{"images": "dummy.txt"}))
+		var status JobStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		// Wait for the job to finish.
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/jobs/"+status.ID+"/events", nil)
+		resp = do(token, req)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return status.ID
+	}
+	statusCode := func(token, method, path string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		resp := do(token, req)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	bobJob := create("bob")
+	aliceJobs := []string{create("alice"), create("alice"), create("alice")}
+
+	if code := statusCode("alice", http.MethodGet, "/jobs/"+bobJob); code != http.StatusNotFound {
+		t.Errorf("Expected another tenant's job to be hidden, got %d", code)
+	}
+	// Two 9-byte outputs fit in the quota of 20, so Alice's third job
+	// evicted her first, not Bob's.
+	if code := statusCode("alice", http.MethodGet, "/jobs/"+aliceJobs[0]); code != http.StatusNotFound {
+		t.Errorf("Expected the oldest output over the quota to be dropped, got %d", code)
+	}
+	if code := statusCode("bob", http.MethodGet, "/jobs/"+bobJob+"/result"); code != http.StatusOK {
+		t.Errorf("Expected Bob's output to survive Alice's jobs, got %d", code)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/jobs", nil)
+	resp := do("alice", req)
+	var purged PurgeResult
+	json.NewDecoder(resp.Body).Decode(&purged)
+	resp.Body.Close()
+	if purged.Purged != 2 || purged.FreedBytes != 18 {
+		t.Errorf("Expected Alice's two jobs purged, got %+v", purged)
+	}
+	if code := statusCode("alice", http.MethodGet, "/jobs/"+aliceJobs[2]); code != http.StatusNotFound {
+		t.Errorf("Expected purged jobs to be gone, got %d", code)
+	}
+	if code := statusCode("bob", http.MethodDelete, "/jobs/"+bobJob); code != http.StatusNoContent {
+		t.Errorf("Expected Bob to delete his job, got %d", code)
+	}
+	if code := statusCode("bob", http.MethodGet, "/jobs/"+bobJob); code != http.StatusNotFound {
+		t.Errorf("Expected the deleted job to be gone, got %d", code)
+	}
+}
+
+func TestJobs_RunningLimits(t *testing.T) {
+	release := make(chan struct{})
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return false, context.Cause(ctx)
+		}
+		io.WriteString(writer, "%PDF-stub")
+		return true, nil
+	}
+
+	jobs := NewJobManager(Options{MaxJobs: 2, MaxTenantJobs: 1}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(RequireBearerToken([]string{"alice", "bob", "carol"}, jobs.Handler()))
+	defer server.Close()
+
+	do := func(token string, req *http.Request) *http.Response {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", req.Method, req.URL.Path, err)
+		}
+		return resp
+	}
+	create := func(token string) (int, string) {
+		t.Helper()
+		resp := do(token, newFileUploadRequest(t, server.URL+"/jobs", nil, map[string]string{"images": "dummy.txt"}))
+		var status JobStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		return resp.StatusCode, status.ID
+	}
+
+	code, aliceJob := create("alice")
+	if code != http.StatusAccepted {
+		t.Fatalf("Expected Alice's first job to start, got %d", code)
+	}
+	if code, _ := create("alice"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for a second job of Alice's, got %d", code)
+	}
+	if code, _ := create("bob"); code != http.StatusAccepted {
+		t.Errorf("Expected Bob's job to start beside Alice's, got %d", code)
+	}
+	if code, _ := create("carol"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the server runs MaxJobs jobs, got %d", code)
+	}
+
+	// A finalized session the server has no room for stays open.
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/sessions", nil)
+	resp := do("carol", req)
+	var session SessionStatus
+	json.NewDecoder(resp.Body).Decode(&session)
+	resp.Body.Close()
+	resp = do("carol", newFileUploadRequest(t, server.URL+"/sessions/"+session.ID+"/finalize", nil, map[string]string{"images": "dummy.txt"}))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 finalizing a session on a full server, got %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/sessions/"+session.ID, nil)
+	resp = do("carol", req)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the rejected session to be kept, got %d", resp.StatusCode)
+	}
+
+	close(release)
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/jobs/"+aliceJob+"/events", nil)
+	resp = do("alice", req)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if code, _ := create("alice"); code != http.StatusAccepted {
+		t.Errorf("Expected Alice to start a job once hers finished, got %d", code)
+	}
+}
+
+func TestJobs_EvictsWithoutRequests(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		io.WriteString(writer, "%PDF-stub")
+		return true, nil
+	}
+
+	jobs := NewJobManager(Options{}, 50*time.Millisecond)
+	defer jobs.Close()
+	server := httptest.NewServer(jobs.Handler())
+	defer server.Close()
+
+	resp, err := http.DefaultClient.Do(newFileUploadRequest(t, server.URL+"/jobs", nil, map[string]string{"images": "dummy.txt"}))
+	if err != nil {
+		t.Fatalf("POST /jobs failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs.mu.Lock()
+		left := len(jobs.jobs)
+		jobs.mu.Unlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the finished job to be evicted without another request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
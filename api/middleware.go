@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// RequireBearerToken wraps next so that requests must carry one of the given
+// tokens as "Authorization: Bearer <token>". With no tokens configured the
+// handler is returned unchanged (authentication disabled). The tenant the
+// token belongs to is available to next through Tenant.
+func RequireBearerToken(tokens []string, next http.Handler) http.Handler {
+	if len(tokens) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		provided = strings.TrimSpace(provided)
+		if !ok || !tokenAllowed(tokens, provided) {
+			slog.Warn("Rejected unauthenticated request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="manga_to_pdf"`)
+			writeJSONError(w, "Unauthorized", "A valid bearer token is required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenantID(provided))))
+	})
+}
+
+// DefaultTenant is the tenant of requests that did not go through
+// RequireBearerToken, e.g. with authentication disabled.
+const DefaultTenant = "default"
+
+type tenantKey struct{}
+
+// Tenant returns the tenant the request behind ctx authenticated as, or
+// DefaultTenant. Jobs, their stored outputs and quotas are kept per tenant.
+func Tenant(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// tenantID names the tenant of a bearer token: stable across restarts and
+// usable in storage keys, without revealing the token.
+func tenantID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "t" + hex.EncodeToString(sum[:8])
+}
+
+// tokenAllowed reports whether provided matches any configured token,
+// comparing in constant time to avoid leaking token contents.
+func tokenAllowed(tokens []string, provided string) bool {
+	if provided == "" {
+		return false
+	}
+	allowed := 0
+	for _, token := range tokens {
+		allowed |= subtle.ConstantTimeCompare([]byte(token), []byte(provided))
+	}
+	return allowed == 1
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// Response modes selectable with the "response_mode" form field.
+const (
+	responseModePDF       = "pdf"       // PDF body only; failed sources are only logged (default)
+	responseModeMultipart = "multipart" // multipart/mixed: JSON report followed by the PDF
+)
+
+// SourceFailure describes an image that did not make it into the PDF.
+type SourceFailure struct {
+	Source string `json:"source"` // Uploaded filename or URL
+	Stage  string `json:"stage"`  // "fetch" or "convert"
+	Error  string `json:"error"`
+	Reason string `json:"reason,omitempty"` // For fetch failures: empty_body, truncated_body, http_status, unsupported_type or network
+}
+
+// DroppedPage describes a page that was left out on purpose, with the
+// dedup_pages or skip_blank options.
+type DroppedPage struct {
+	Source      string `json:"source"`
+	Reason      string `json:"reason"`                 // "duplicate" or "blank"
+	DuplicateOf string `json:"duplicate_of,omitempty"` // The page kept, for duplicates
+}
+
+// ConversionReport is the machine-readable summary sent alongside the PDF in
+// multipart response mode.
+type ConversionReport struct {
+	Filename    string          `json:"filename"`
+	Sources     int             `json:"sources"`
+	PagesAdded  int             `json:"pages_added"`
+	PagesFailed int             `json:"pages_failed"`
+	Failures    []SourceFailure `json:"failures"`
+
+	PagesPlaceholder int `json:"pages_placeholder"` // Failed pages replaced by a placeholder page
+
+	Dropped []DroppedPage `json:"dropped,omitempty"`
+}
+
+// newConversionReport combines the sources that could not be fetched with
+// the converter's per-page failures. Sources that were handed to the
+// converter anyway, for a placeholder page, are only counted once.
+func newConversionReport(filename string, fetchFailures []SourceFailure, stats *converter.Stats) ConversionReport {
+	passedOn := 0
+	for _, failure := range stats.Failures {
+		if failure.Fetch {
+			passedOn++
+		}
+	}
+	report := ConversionReport{
+		Filename:    filename,
+		Sources:     stats.Sources + len(fetchFailures) - passedOn,
+		PagesAdded:  stats.PagesAdded,
+		PagesFailed: stats.PagesFailed + len(fetchFailures) - passedOn,
+		Failures:    append([]SourceFailure{}, fetchFailures...),
+
+		PagesPlaceholder: stats.PagesPlaceholder,
+	}
+	for _, failure := range stats.Failures {
+		if failure.Fetch {
+			continue
+		}
+		report.Failures = append(report.Failures, SourceFailure{Source: failure.Filename, Stage: "convert", Error: failure.Error})
+	}
+	for _, drop := range stats.Dropped {
+		report.Dropped = append(report.Dropped, DroppedPage{Source: drop.Filename, Reason: drop.Reason, DuplicateOf: drop.DuplicateOf})
+	}
+	return report
+}
+
+// writeMultipartReport writes report and pdf, a document of the given
+// content type, as a multipart/mixed response.
+// The status is 207 Multi-Status when some sources failed, 200 otherwise.
+func writeMultipartReport(w http.ResponseWriter, report ConversionReport, contentType string, pdf *bytes.Buffer) {
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		slog.Error("Failed to encode conversion report", "error", err)
+		writeJSONError(w, "Failed to encode conversion report", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	status := http.StatusOK
+	if len(report.Failures) > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.WriteHeader(status)
+
+	if err := writeReportParts(mw, reportJSON, report.Filename, contentType, pdf); err != nil {
+		// Usually the client went away; headers are already sent.
+		slog.Error("Failed to write multipart response", "error", err)
+	}
+}
+
+func writeReportParts(mw *multipart.Writer, reportJSON []byte, filename, contentType string, pdf *bytes.Buffer) error {
+	reportPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/json"},
+		"Content-Disposition": {`inline; name="report"`},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := reportPart.Write(reportJSON); err != nil {
+		return err
+	}
+	pdfPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {contentType},
+		"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"name": "pdf", "filename": filename})},
+		"Content-Length":      {strconv.Itoa(pdf.Len())},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := pdf.WriteTo(pdfPart); err != nil {
+		return err
+	}
+	return mw.Close()
+}
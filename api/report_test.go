@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func TestHandleConvert_MultipartReport(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		io.WriteString(writer, "%PDF-1.4\n%%EOF\n")
+		stats.Sources, stats.PagesAdded, stats.PagesFailed = 2, 1, 1
+		stats.Failures = []converter.PageFailure{{Index: 1, Filename: "broken.png", Error: "could not decode"}}
+		return true, nil
+	}
+
+	mockServer := httptest.NewServer(http.NotFoundHandler())
+	defer mockServer.Close()
+	params := map[string]string{
+		"response_mode": "multipart",
+		"image_urls":    fmt.Sprintf(`["%s/missing.jpg"]`, mockServer.URL),
+		"config":        `{"output_filename": "Tome\\\\1 été.pdf"}`,
+	}
+	req := newFileUploadRequest(t, "/convert", params, map[string]string{"images": "dummy.txt"})
+	rr := httptest.NewRecorder()
+	HandleConvert(rr, req)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("Expected 207 with failed sources, got %d: %s", rr.Code, rr.Body.String())
+	}
+	mediaType, mediaParams, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Expected multipart/mixed response, got %q", rr.Header().Get("Content-Type"))
+	}
+	mr := multipart.NewReader(rr.Body, mediaParams["boundary"])
+
+	reportPart, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Missing report part: %v", err)
+	}
+	var report ConversionReport
+	if err := json.NewDecoder(reportPart).Decode(&report); err != nil {
+		t.Fatalf("Could not decode report: %v", err)
+	}
+	if report.PagesAdded != 1 || report.PagesFailed != 2 || len(report.Failures) != 2 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if report.Failures[0].Stage != "fetch" || report.Failures[1].Stage != "convert" {
+		t.Errorf("Expected fetch and convert failures, got %+v", report.Failures)
+	}
+	if report.Failures[0].Reason != "http_status" || report.Failures[1].Reason != "" {
+		t.Errorf("Expected the fetch failure classified as http_status, got %+v", report.Failures)
+	}
+
+	pdfPart, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Missing PDF part: %v", err)
+	}
+	if pdfPart.Header.Get("Content-Type") != "application/pdf" || pdfPart.FileName() != `Tome\\1 été.pdf` {
+		t.Errorf("Unexpected PDF part headers: %v", pdfPart.Header)
+	}
+	if body, _ := io.ReadAll(pdfPart); string(body) != "%PDF-1.4\n%%EOF\n" {
+		t.Errorf("Unexpected PDF part body: %q", body)
+	}
+}
+
+func TestHandleConvert_Placeholders(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData.Bytes())
+	}))
+	defer mockServer.Close()
+
+	params := map[string]string{
+		"response_mode": "multipart",
+		"image_urls":    fmt.Sprintf(`["%[1]s/1.png", "%[1]s/gone.png", "%[1]s/3.png"]`, mockServer.URL),
+		"config":        `{"placeholders": true}`,
+	}
+	rr := httptest.NewRecorder()
+	HandleConvert(rr, newFileUploadRequest(t, "/convert", params, map[string]string{}))
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("Expected 207 with a failed fetch, got %d: %s", rr.Code, rr.Body.String())
+	}
+	_, mediaParams, _ := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	mr := multipart.NewReader(rr.Body, mediaParams["boundary"])
+	reportPart, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Missing report part: %v", err)
+	}
+	var report ConversionReport
+	if err := json.NewDecoder(reportPart).Decode(&report); err != nil {
+		t.Fatalf("Could not decode report: %v", err)
+	}
+	// The failed fetch is reported once, although the converter saw it too.
+	if report.Sources != 3 || report.PagesAdded != 2 || report.PagesFailed != 1 || report.PagesPlaceholder != 1 || len(report.Failures) != 1 || report.Failures[0].Stage != "fetch" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	pdfPart, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("Missing PDF part: %v", err)
+	}
+	body, _ := io.ReadAll(pdfPart)
+	if doc, err := converter.ReadPDF(body); err != nil || doc.NumPages() != 3 {
+		t.Errorf("Expected three pages, the second a placeholder, got %v", err)
+	}
+}
+
+func TestHandleConvert_InvalidResponseMode(t *testing.T) {
+	req := newFileUploadRequest(t, "/convert", map[string]string{"response_mode": "zip"}, map[string]string{"images": "dummy.txt"})
+	rr := httptest.NewRecorder()
+	HandleConvert(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown response_mode, got %d", rr.Code)
+	}
+}
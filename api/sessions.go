@@ -0,0 +1,326 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// SessionIdleTimeout is how long an upload session is kept without pages
+// being appended to it before it is dropped with its pages.
+const SessionIdleTimeout = 30 * time.Minute
+
+// session collects pages uploaded across several requests, for clients such
+// as browser extensions that capture one page at a time as the user reads.
+// Finalizing it starts a job converting the pages in the order they were
+// appended. Sessions are kept in memory and belong to the tenant that
+// opened them, like jobs.
+type session struct {
+	id      string
+	tenant  string
+	created time.Time
+
+	mu      sync.Mutex
+	touched time.Time // When the session was opened or last had pages appended
+	pages   []sessionPage
+	size    int64 // Bytes of all pages
+	closed  bool  // Finalized or deleted; no more pages are accepted
+}
+
+// sessionPage is one uploaded page of a session.
+type sessionPage struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// SessionStatus is the JSON description of an upload session.
+type SessionStatus struct {
+	ID        string    `json:"id"`
+	Pages     int       `json:"pages"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"` // When the session is dropped unless more pages are appended
+}
+
+// handleOpenSession opens an empty session and answers 201 with its status
+// and its URL in the Location header. If the tenant already has
+// Options.MaxTenantSessions sessions open it answers 429, and if the server
+// has Options.MaxSessions it answers 503.
+func (m *JobManager) handleOpenSession(w http.ResponseWriter, r *http.Request) {
+	id, err := newJobID()
+	if err != nil {
+		writeJSONError(w, "Failed to create session", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	s := &session{id: id, tenant: Tenant(r.Context()), created: now, touched: now}
+	m.mu.Lock()
+	m.evictSessionsLocked(now)
+	open, tenantOpen := len(m.sessions), 0
+	for _, other := range m.sessions {
+		if other.tenant == s.tenant {
+			tenantOpen++
+		}
+	}
+	switch {
+	case m.opts.MaxTenantSessions > 0 && tenantOpen >= m.opts.MaxTenantSessions:
+		m.mu.Unlock()
+		writeJSONError(w, "Too many open sessions", fmt.Sprintf("This tenant already has %d sessions open, the maximum", tenantOpen), http.StatusTooManyRequests)
+		return
+	case m.opts.MaxSessions > 0 && open >= m.opts.MaxSessions:
+		m.mu.Unlock()
+		writeJSONError(w, "Server busy", fmt.Sprintf("The server already has %d sessions open, the maximum", open), http.StatusServiceUnavailable)
+		return
+	}
+	m.sessions[id] = s
+	m.mu.Unlock()
+
+	slog.Info("Opened upload session", "session", id, "tenant", s.tenant)
+	w.Header().Set("Location", versionPrefix(r.Context())+"/sessions/"+id)
+	writeJSON(w, http.StatusCreated, m.sessionStatus(s))
+}
+
+// evictSessionsLocked drops sessions idle for longer than m.sessionIdle
+// before now. m.mu must be held.
+func (m *JobManager) evictSessionsLocked(now time.Time) {
+	for _, s := range m.sessions {
+		if m.sessionExpired(s, now) {
+			m.dropSessionLocked(s)
+		}
+	}
+}
+
+// sessionExpired reports whether s has been idle for too long at now.
+func (m *JobManager) sessionExpired(s *session, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return now.Sub(s.touched) > m.sessionIdle
+}
+
+// dropSessionLocked forgets s and its pages. m.mu must be held.
+func (m *JobManager) dropSessionLocked(s *session) {
+	delete(m.sessions, s.id)
+	s.mu.Lock()
+	s.closed, s.pages = true, nil
+	s.mu.Unlock()
+}
+
+// withSession looks up the session named by the {id} path value, answering
+// 404 if there is none, it belongs to another tenant or it has expired.
+func (m *JobManager) withSession(next func(http.ResponseWriter, *http.Request, *session)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		s := m.sessions[r.PathValue("id")]
+		if s != nil && m.sessionExpired(s, time.Now()) {
+			m.dropSessionLocked(s)
+			s = nil
+		}
+		m.mu.Unlock()
+		if s == nil || s.tenant != Tenant(r.Context()) {
+			writeJSONError(w, "Session not found", r.PathValue("id"), http.StatusNotFound)
+			return
+		}
+		next(w, r, s)
+	}
+}
+
+func (m *JobManager) handleSessionStatus(w http.ResponseWriter, r *http.Request, s *session) {
+	writeJSON(w, http.StatusOK, m.sessionStatus(s))
+}
+
+func (m *JobManager) sessionStatus(s *session) SessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionStatus{ID: s.id, Pages: len(s.pages), Bytes: s.size, CreatedAt: s.created, ExpiresAt: s.touched.Add(m.sessionIdle)}
+}
+
+// handleDeleteSession drops a session and its pages.
+func (m *JobManager) handleDeleteSession(w http.ResponseWriter, r *http.Request, s *session) {
+	m.mu.Lock()
+	m.dropSessionLocked(s)
+	m.mu.Unlock()
+	slog.Info("Deleted upload session", "session", s.id, "tenant", s.tenant)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAppendPages adds the "images" files of a multipart request to the
+// end of the session, in the order they appear, and answers with the
+// session's status. Options.MaxUploadBytes caps each request and also the
+// pages of the whole session, which is converted as a single upload.
+func (m *JobManager) handleAppendPages(w http.ResponseWriter, r *http.Request, s *session) {
+	if m.opts.MaxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, m.opts.MaxUploadBytes)
+	}
+	defer func() {
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}()
+	form, err := parseUploadForm(r, defaultMaxMemory, uploadDir(r, m.opts))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeJSONError(w, "Request body too large", fmt.Sprintf("Maximum upload size is %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeJSONError(w, "Failed to parse request data", err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer form.removeAll()
+
+	files := form.File["images"]
+	if len(files) == 0 {
+		writeJSONError(w, "No pages to append", "Send the pages as 'images' files", http.StatusBadRequest)
+		return
+	}
+	var pages []sessionPage
+	var rejections []UploadRejection
+	var size int64
+	for i, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			writeJSONError(w, fmt.Sprintf("Failed to open uploaded file: %s", fileHeader.Filename), err.Error(), http.StatusInternalServerError)
+			return
+		}
+		contentType := fileHeader.Header.Get("Content-Type")
+		if len(m.opts.UploadTypes) > 0 {
+			sniffed, rejection, err := checkUploadType(i, fileHeader, file, m.opts.UploadTypes)
+			if err != nil {
+				file.Close()
+				writeJSONError(w, fmt.Sprintf("Failed to read uploaded file: %s", fileHeader.Filename), err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if rejection != nil {
+				file.Close()
+				slog.Warn("Rejected session page by its type", "session", s.id, "filename", fileHeader.Filename, "declared", rejection.DeclaredType, "sniffed", rejection.SniffedType, "reason", rejection.Reason)
+				rejections = append(rejections, *rejection)
+				continue
+			}
+			contentType = sniffed
+		} else if contentType == "" || contentType == "application/octet-stream" {
+			contentType = converter.GetContentTypeFromFilename(fileHeader.Filename)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeJSONError(w, fmt.Sprintf("Failed to read uploaded file: %s", fileHeader.Filename), err.Error(), http.StatusBadRequest)
+			return
+		}
+		pages = append(pages, sessionPage{filename: fileHeader.Filename, contentType: contentType, data: data})
+		size += int64(len(data))
+	}
+	if len(rejections) > 0 {
+		writeJSONError(w, "Uploaded files are not of an allowed type", rejections, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		writeJSONError(w, "Session not found", s.id, http.StatusNotFound)
+		return
+	case m.opts.MaxUploadBytes > 0 && s.size+size > m.opts.MaxUploadBytes:
+		s.mu.Unlock()
+		writeJSONError(w, "Session too large", fmt.Sprintf("The session would hold %d bytes, the maximum is %d bytes", s.size+size, m.opts.MaxUploadBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	s.pages = append(s.pages, pages...)
+	s.size += size
+	s.touched = time.Now()
+	total := len(s.pages)
+	s.mu.Unlock()
+
+	slog.Debug("Appended pages to upload session", "session", s.id, "appended", len(pages), "pages", total)
+	writeJSON(w, http.StatusOK, m.sessionStatus(s))
+}
+
+// handleFinalizeSession converts the session's pages as POST /jobs would,
+// with the settings and any further images or image_urls of this request
+// added after them, and answers the same way. The session is gone once the
+// job has started; until then a failed request leaves it as it was.
+func (m *JobManager) handleFinalizeSession(w http.ResponseWriter, r *http.Request, s *session) {
+	if m.opts.MaxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, m.opts.MaxUploadBytes)
+	}
+	defer func() {
+		io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+	}()
+
+	req := parseConvertRequest(w, r, m.opts, nil)
+	if req == nil {
+		return
+	}
+	webhook, err := m.requestWebhook(r)
+	if err != nil {
+		req.closeUploads()
+		writeJSONError(w, "Invalid webhook_url", err.Error(), http.StatusBadRequest)
+		return
+	}
+	extra, ok := holdUploads(w, req)
+	if !ok {
+		return
+	}
+	// The job is added first so a server too busy to run it leaves the
+	// session open.
+	j, ctx := m.addJob(w, r, webhook)
+	if j == nil {
+		req.closeUploads()
+		return
+	}
+	dropJob := func() {
+		m.mu.Lock()
+		m.dropLocked(j)
+		m.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	switch {
+	case s.closed:
+		s.mu.Unlock()
+		dropJob()
+		req.closeUploads()
+		writeJSONError(w, "Session not found", s.id, http.StatusNotFound)
+		return
+	case len(s.pages)+len(req.uploads)+len(req.urls) == 0:
+		s.mu.Unlock()
+		dropJob()
+		writeJSONError(w, "Session has no pages", "Append pages to the session before finalizing it", http.StatusBadRequest)
+		return
+	case m.opts.MaxUploadBytes > 0 && s.size+extra > m.opts.MaxUploadBytes:
+		s.mu.Unlock()
+		dropJob()
+		req.closeUploads()
+		writeJSONError(w, "Session too large", fmt.Sprintf("The session would hold %d bytes, the maximum is %d bytes", s.size+extra, m.opts.MaxUploadBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	pages := s.pages
+	s.closed, s.pages = true, nil
+	s.mu.Unlock()
+	m.mu.Lock()
+	delete(m.sessions, s.id)
+	m.mu.Unlock()
+
+	sources := make([]converter.ImageSource, 0, len(pages)+len(req.uploads))
+	for _, page := range pages {
+		sources = append(sources, converter.ImageSource{
+			OriginalFilename: page.filename,
+			Reader:           io.NopCloser(bytes.NewReader(page.data)),
+			ContentType:      page.contentType,
+		})
+	}
+	req.uploads = append(sources, req.uploads...)
+	for i := range req.uploads {
+		req.uploads[i].Index = i
+	}
+	slog.Info("Finalized upload session", "session", s.id, "tenant", s.tenant, "pages", len(pages))
+	m.startJob(ctx, w, r, j, req)
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// pagesRequest returns a multipart request with one "images" file per
+// name, each holding the name itself, plus the given form fields.
+func pagesRequest(t *testing.T, url string, fields map[string]string, names ...string) *http.Request {
+	t.Helper()
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for key, val := range fields {
+		writer.WriteField(key, val)
+	}
+	for _, name := range names {
+		part, err := writer.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file %s: %v", name, err)
+		}
+		io.WriteString(part, name)
+	}
+	writer.Close()
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestSessions_AppendAndFinalize(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	var mu sync.Mutex
+	var converted []string
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i, src := range sources {
+			data, _ := io.ReadAll(src.Reader)
+			src.Reader.Close()
+			if src.Index != i || string(data) != src.OriginalFilename {
+				t.Errorf("Source %d is %+v with data %q", i, src, data)
+			}
+			converted = append(converted, src.OriginalFilename)
+		}
+		io.WriteString(writer, "%PDF-stub")
+		return true, nil
+	}
+
+	jobs := NewJobManager(Options{}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(jobs.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/sessions", "", nil)
+	if err != nil {
+		t.Fatalf("POST /sessions failed: %v", err)
+	}
+	var opened SessionStatus
+	json.NewDecoder(resp.Body).Decode(&opened)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || opened.ID == "" {
+		t.Fatalf("Expected 201 with a session id, got %d %+v", resp.StatusCode, opened)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/sessions/"+opened.ID {
+		t.Errorf("Expected Location /sessions/%s, got %q", opened.ID, loc)
+	}
+	sessionURL := server.URL + "/sessions/" + opened.ID
+
+	// Finalizing an empty session is refused and leaves it open.
+	resp, err = http.DefaultClient.Do(pagesRequest(t, sessionURL+"/finalize", nil))
+	if err != nil {
+		t.Fatalf("POST finalize failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty session, got %d", resp.StatusCode)
+	}
+
+	for _, names := range [][]string{{"01.jpg"}, {"02.jpg", "03.jpg"}} {
+		resp, err = http.DefaultClient.Do(pagesRequest(t, sessionURL+"/pages", nil, names...))
+		if err != nil {
+			t.Fatalf("POST pages failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200 appending pages, got %d", resp.StatusCode)
+		}
+	}
+	resp, err = http.Get(sessionURL)
+	if err != nil {
+		t.Fatalf("GET session failed: %v", err)
+	}
+	var status SessionStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Pages != 3 || status.Bytes != 18 {
+		t.Errorf("Expected 3 pages of 18 bytes, got %+v", status)
+	}
+
+	resp, err = http.DefaultClient.Do(pagesRequest(t, sessionURL+"/finalize", map[string]string{"config": `{"output_filename": "chapter.pdf"}`}, "04.jpg"))
+	if err != nil {
+		t.Fatalf("POST finalize failed: %v", err)
+	}
+	var job JobStatus
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || job.ID == "" {
+		t.Fatalf("Expected 202 with a job, got %d %+v", resp.StatusCode, job)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/jobs/"+job.ID {
+		t.Errorf("Expected Location /jobs/%s, got %q", job.ID, loc)
+	}
+
+	resp, err = http.Get(server.URL + "/jobs/" + job.ID + "/events")
+	if err != nil {
+		t.Fatalf("GET events failed: %v", err)
+	}
+	events, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(events), "event: done") {
+		t.Errorf("Expected the job to finish, got events %q", events)
+	}
+	mu.Lock()
+	if got := strings.Join(converted, ","); got != "01.jpg,02.jpg,03.jpg,04.jpg" {
+		t.Errorf("Expected the pages in the order they were appended, got %s", got)
+	}
+	mu.Unlock()
+
+	resp, err = http.Get(sessionURL)
+	if err != nil {
+		t.Fatalf("GET session failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the finalized session to be gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestSessions_Limits(t *testing.T) {
+	jobs := NewJobManager(Options{MaxUploadBytes: 4096}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(RequireBearerToken([]string{"alice", "bob"}, jobs.Handler()))
+	defer server.Close()
+
+	do := func(token string, req *http.Request) int {
+		t.Helper()
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", req.Method, req.URL.Path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	open := func(token string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /sessions failed: %v", err)
+		}
+		var status SessionStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		return status.ID
+	}
+
+	id := open("alice")
+	get, _ := http.NewRequest(http.MethodGet, server.URL+"/sessions/"+id, nil)
+	if code := do("bob", get); code != http.StatusNotFound {
+		t.Errorf("Expected another tenant's session to be hidden, got %d", code)
+	}
+
+	// Each request fits the upload limit, but together they do not.
+	page := strings.Repeat("x", 1500)
+	for range 2 {
+		if code := do("alice", pagesRequest(t, server.URL+"/sessions/"+id+"/pages", nil, page)); code != http.StatusOK {
+			t.Fatalf("Expected a page within the limit to be appended, got %d", code)
+		}
+	}
+	if code := do("alice", pagesRequest(t, server.URL+"/sessions/"+id+"/pages", nil, page)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a session over the upload limit, got %d", code)
+	}
+
+	// Sessions left idle are dropped.
+	jobs.mu.Lock()
+	jobs.sessionIdle = time.Millisecond
+	jobs.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	get, _ = http.NewRequest(http.MethodGet, server.URL+"/sessions/"+id, nil)
+	if code := do("alice", get); code != http.StatusNotFound {
+		t.Errorf("Expected an idle session to expire, got %d", code)
+	}
+}
+
+func TestSessions_OpenLimits(t *testing.T) {
+	jobs := NewJobManager(Options{MaxSessions: 2, MaxTenantSessions: 1}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(RequireBearerToken([]string{"alice", "bob", "carol"}, jobs.Handler()))
+	defer server.Close()
+
+	open := func(token string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /sessions failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var status SessionStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp.StatusCode, status.ID
+	}
+
+	code, aliceSession := open("alice")
+	if code != http.StatusCreated {
+		t.Fatalf("Expected Alice's first session to open, got %d", code)
+	}
+	if code, _ := open("alice"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 for a second session of Alice's, got %d", code)
+	}
+	if code, _ := open("bob"); code != http.StatusCreated {
+		t.Errorf("Expected Bob's session to open beside Alice's, got %d", code)
+	}
+	if code, _ := open("carol"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the server has MaxSessions sessions, got %d", code)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/sessions/"+aliceSession, nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE session failed: %v", err)
+	}
+	resp.Body.Close()
+	if code, _ := open("alice"); code != http.StatusCreated {
+		t.Errorf("Expected Alice to open a session once hers was deleted, got %d", code)
+	}
+}
+
+func TestSessions_EvictedWithoutRequests(t *testing.T) {
+	jobs := NewJobManager(Options{}, 20*time.Millisecond)
+	defer jobs.Close()
+	jobs.mu.Lock()
+	jobs.sessionIdle = time.Millisecond
+	jobs.mu.Unlock()
+	server := httptest.NewServer(jobs.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/sessions", "", nil)
+	if err != nil {
+		t.Fatalf("POST /sessions failed: %v", err)
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs.mu.Lock()
+		left := len(jobs.sessions)
+		jobs.mu.Unlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle session to be dropped without another request")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
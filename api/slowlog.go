@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// slowRequest collects what the slow-log needs to know about one /convert
+// request. All methods are no-ops on a nil *slowRequest, so the handler can
+// call them unconditionally when the slow-log is disabled.
+type slowRequest struct {
+	started   time.Time
+	lastMark  time.Time
+	phases    []slog.Attr
+	recorder  *statusRecorder
+	body      *countingReadCloser
+	request   *http.Request
+	config    *converter.Config
+	uploads   int
+	urls      int
+	urlErrors int
+	stats     converter.Stats
+	canceled  string // converter.CancellationReason, if the conversion was stopped
+}
+
+// setCancelReason records why the conversion was stopped.
+func (sr *slowRequest) setCancelReason(reason string) {
+	if sr != nil {
+		sr.canceled = reason
+	}
+}
+
+// startSlowRequest begins tracking r when opts enables the slow-log. It wraps
+// the request body to count uploaded bytes and returns the ResponseWriter the
+// handler must use from then on.
+func startSlowRequest(w http.ResponseWriter, r *http.Request, opts Options) (*slowRequest, http.ResponseWriter) {
+	if opts.SlowLog == nil || (opts.SlowLogDuration <= 0 && opts.SlowLogBytes <= 0) {
+		return nil, w
+	}
+	now := time.Now()
+	sr := &slowRequest{
+		started:  now,
+		lastMark: now,
+		recorder: &statusRecorder{ResponseWriter: w, status: http.StatusOK},
+		body:     &countingReadCloser{ReadCloser: r.Body},
+		request:  r,
+	}
+	r.Body = sr.body
+	return sr, sr.recorder
+}
+
+// mark records the time spent since the previous mark under name.
+func (sr *slowRequest) mark(name string) {
+	if sr == nil {
+		return
+	}
+	now := time.Now()
+	sr.phases = append(sr.phases, slog.Duration(name, now.Sub(sr.lastMark)))
+	sr.lastMark = now
+}
+
+// conversionStats returns the Stats the converter should fill in, or nil.
+func (sr *slowRequest) conversionStats() *converter.Stats {
+	if sr == nil {
+		return nil
+	}
+	return &sr.stats
+}
+
+// finish writes the request to the slow-log if it crossed a threshold.
+func (sr *slowRequest) finish(opts Options) {
+	if sr == nil {
+		return
+	}
+	sr.mark("write")
+	elapsed := time.Since(sr.started)
+	slowByTime := opts.SlowLogDuration > 0 && elapsed >= opts.SlowLogDuration
+	slowBySize := opts.SlowLogBytes > 0 && (sr.body.n >= opts.SlowLogBytes || sr.stats.OutputBytes >= opts.SlowLogBytes)
+	if !slowByTime && !slowBySize {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("remote", sr.request.RemoteAddr),
+		slog.String("user_agent", sr.request.UserAgent()),
+		slog.Int("status", sr.recorder.status),
+		slog.Duration("duration", elapsed),
+		slog.Int64("upload_bytes", sr.body.n),
+		slog.Int64("pdf_bytes", sr.stats.OutputBytes),
+		slog.Int("uploads", sr.uploads),
+		slog.Int("urls", sr.urls),
+		slog.Int("url_errors", sr.urlErrors),
+		slog.Int("pages_added", sr.stats.PagesAdded),
+		slog.Int("pages_failed", sr.stats.PagesFailed),
+		slog.Int("pages_duplicate", sr.stats.PagesDuplicate),
+		slog.Int("pages_blank", sr.stats.PagesBlank),
+		slog.Int("pages_passed_through", sr.stats.PagesPassedThrough),
+		slog.Int64("bytes_decoded", sr.stats.BytesDecoded),
+		slog.Int64("bytes_encoded", sr.stats.BytesEncoded),
+		slog.Float64("buffer_pool_hit_rate", sr.stats.BufferHitRate()),
+		slog.Attr{Key: "phases", Value: slog.GroupValue(sr.phases...)},
+		slog.Group("stages",
+			slog.Duration("decode", sr.stats.DecodeTime),
+			slog.Duration("encode", sr.stats.EncodeTime),
+			slog.Duration("process", sr.stats.ProcessTime),
+			slog.Duration("pdf", sr.stats.PDFTime),
+		),
+	}
+	if sr.canceled != "" {
+		attrs = append(attrs, slog.String("canceled", sr.canceled))
+	}
+	if sr.config != nil {
+		attrs = append(attrs, slog.Any("config", sr.config))
+	}
+	opts.SlowLog.LogAttrs(context.Background(), slog.LevelWarn, "Slow conversion", attrs...)
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	if !sr.wroteHeader {
+		sr.status = statusCode
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
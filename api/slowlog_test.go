@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func TestHandleConvert_SlowLog(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		n, _ := io.WriteString(writer, "%PDF-1.4\n%%EOF\n")
+		if stats != nil {
+			stats.PagesAdded = len(sources)
+			stats.OutputBytes = int64(n)
+			stats.DecodeTime = time.Millisecond
+		}
+		return true, nil
+	}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		logged bool
+	}{
+		{"size threshold reached", Options{SlowLogBytes: 1}, true},
+		{"duration threshold not reached", Options{SlowLogDuration: time.Hour}, false},
+		{"no thresholds", Options{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+			tt.opts.SlowLog = slog.New(slog.NewJSONHandler(&logBuf, nil))
+			req := newFileUploadRequest(t, "/convert", map[string]string{"config": `{"jpeg_quality": 70}`}, map[string]string{"images": "dummy.txt"})
+			rr := httptest.NewRecorder()
+			NewConvertHandler(tt.opts).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if !tt.logged {
+				if logBuf.Len() != 0 {
+					t.Errorf("Expected no slow-log entry, got %s", logBuf.String())
+				}
+				return
+			}
+
+			var entry struct {
+				Status      int              `json:"status"`
+				UploadBytes int64            `json:"upload_bytes"`
+				PDFBytes    int64            `json:"pdf_bytes"`
+				Uploads     int              `json:"uploads"`
+				Phases      map[string]int64 `json:"phases"`
+				Stages      map[string]int64 `json:"stages"`
+				Config      converter.Config `json:"config"`
+			}
+			if err := json.Unmarshal(logBuf.Bytes(), &entry); err != nil {
+				t.Fatalf("Could not decode slow-log entry %q: %v", logBuf.String(), err)
+			}
+			if entry.Status != http.StatusOK || entry.Uploads != 1 || entry.UploadBytes == 0 || entry.PDFBytes == 0 {
+				t.Errorf("Unexpected slow-log entry: %s", logBuf.String())
+			}
+			for _, phase := range []string{"parse", "fetch", "convert", "write"} {
+				if _, ok := entry.Phases[phase]; !ok {
+					t.Errorf("Expected phase %q in slow-log entry: %s", phase, logBuf.String())
+				}
+			}
+			if entry.Stages["decode"] != int64(time.Millisecond) {
+				t.Errorf("Expected converter stage timings in slow-log entry: %s", logBuf.String())
+			}
+			if entry.Config.JPEGQuality != 70 {
+				t.Errorf("Expected request config in slow-log entry, got %+v", entry.Config)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// maxValueBytes is how much the non-file fields of a request may add up to
+// on top of the memory limit of its files, as with http.Request's
+// ParseMultipartForm.
+const maxValueBytes = 10 << 20
+
+// uploadedFile is one file part of a multipart request, held in memory or,
+// beyond the memory limit, in a temporary file.
+type uploadedFile struct {
+	Filename string
+	Header   textproto.MIMEHeader
+	Size     int64
+
+	content []byte
+	tmpfile string
+}
+
+// Open returns a reader over the file.
+func (f *uploadedFile) Open() (multipart.File, error) {
+	if f.tmpfile != "" {
+		return os.Open(f.tmpfile)
+	}
+	return sectionReadCloser{io.NewSectionReader(bytes.NewReader(f.content), 0, int64(len(f.content)))}, nil
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+}
+
+func (sectionReadCloser) Close() error { return nil }
+
+// uploadForm is a parsed multipart request. Unlike http.Request's
+// ParseMultipartForm, which spills large files to os.TempDir, it keeps them
+// in the temporary directory of the request's tenant.
+type uploadForm struct {
+	File map[string][]*uploadedFile
+}
+
+// removeAll removes the temporary files of the form.
+func (form *uploadForm) removeAll() error {
+	var errs []error
+	for _, files := range form.File {
+		for _, f := range files {
+			if f.tmpfile != "" {
+				if err := os.Remove(f.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// uploadDir returns the directory the uploads of r's tenant are spilled to:
+// a subdirectory of opts.TempDir, or of os.TempDir, named after the tenant.
+func uploadDir(r *http.Request, opts Options) string {
+	dir := opts.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, Tenant(r.Context()))
+}
+
+// parseUploadForm reads the multipart body of r like ParseMultipartForm:
+// files are held in memory up to maxMemory bytes in total, and the rest
+// are written to temporary files in dir, which are removed once the
+// handler has returned. The other fields are available through r.FormValue.
+func parseUploadForm(r *http.Request, maxMemory int64, dir string) (*uploadForm, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	form := &uploadForm{File: map[string][]*uploadedFile{}}
+	context.AfterFunc(r.Context(), func() { form.removeAll() })
+	values := url.Values{}
+	valueBytes := maxMemory + maxValueBytes
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		if part.FileName() == "" {
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, part, valueBytes+1)
+			part.Close()
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if valueBytes -= n; valueBytes < 0 {
+				return nil, multipart.ErrMessageTooLarge
+			}
+			values.Add(name, value.String())
+			continue
+		}
+
+		file := &uploadedFile{Filename: part.FileName(), Header: part.Header}
+		var content bytes.Buffer
+		n, err := io.CopyN(&content, part, maxMemory+1)
+		if err != nil && err != io.EOF {
+			part.Close()
+			return nil, err
+		}
+		if n > maxMemory {
+			err = file.spill(dir, content.Bytes(), part)
+			part.Close()
+			if err != nil {
+				return nil, err
+			}
+			form.File[name] = append(form.File[name], file)
+			continue
+		}
+		part.Close()
+		file.content, file.Size = content.Bytes(), n
+		maxMemory -= n
+		form.File[name] = append(form.File[name], file)
+	}
+
+	r.PostForm = values
+	r.Form = url.Values{}
+	for name, value := range values {
+		r.Form[name] = append(r.Form[name], value...)
+	}
+	for name, query := range r.URL.Query() {
+		r.Form[name] = append(r.Form[name], query...)
+	}
+	r.MultipartForm = &multipart.Form{Value: values}
+	return form, nil
+}
+
+// spill writes head and then the rest of part to a temporary file in dir.
+func (f *uploadedFile) spill(dir string, head []byte, part io.Reader) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "multipart-")
+	if err != nil {
+		return err
+	}
+	f.tmpfile = tmp.Name()
+	size, err := io.Copy(tmp, io.MultiReader(bytes.NewReader(head), part))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.tmpfile)
+		return err
+	}
+	f.Size = size
+	return nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseUploadForm_SpillsPerTenant(t *testing.T) {
+	tempDir := t.TempDir()
+	large := strings.Repeat("x", 1024)
+	var spilled string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		form, err := parseUploadForm(r, 16, uploadDir(r, Options{TempDir: tempDir}))
+		if err != nil {
+			t.Errorf("parseUploadForm: %v", err)
+			return
+		}
+		files := form.File["images"]
+		if len(files) != 2 {
+			t.Errorf("got %d files, want 2", len(files))
+			return
+		}
+		if files[0].tmpfile != "" {
+			t.Errorf("small file was spilled to %s", files[0].tmpfile)
+		}
+		spilled = files[1].tmpfile
+		if filepath.Dir(spilled) != filepath.Join(tempDir, tenantID("alice")) {
+			t.Errorf("large file spilled to %s, want the directory of tenant alice", spilled)
+		}
+		f, err := files[1].Open()
+		if err != nil {
+			t.Errorf("Open: %v", err)
+			return
+		}
+		defer f.Close()
+		if data, _ := io.ReadAll(f); string(data) != large || files[1].Size != int64(len(large)) {
+			t.Errorf("large file read back %d bytes (size %d), want %d", len(data), files[1].Size, len(large))
+		}
+	})
+	server := httptest.NewServer(RequireBearerToken([]string{"alice"}, handler))
+	defer server.Close()
+
+	req := newTypedUploadRequest(t, server.URL, [3]string{"a.png", "image/png", "small"}, [3]string{"b.png", "image/png", large})
+	req.Header.Set("Authorization", "Bearer alice")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if spilled == "" {
+		t.Fatal("large file was not spilled")
+	}
+	// The request context is canceled once the handler returns, after
+	// which the temporary file is removed.
+	for range 100 {
+		if _, err := os.Stat(spilled); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("%s was not removed after the request", spilled)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"slices"
+	"strings"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// ErrInvalidUploadTypes is returned by ValidateUploadTypes for a type the
+// converter cannot detect in an upload.
+var ErrInvalidUploadTypes = errors.New("invalid upload types")
+
+// UploadRejection is an uploaded part refused by Options.UploadTypes.
+type UploadRejection struct {
+	Part         int    `json:"part"` // Position among the uploaded images, from 0
+	Filename     string `json:"filename"`
+	DeclaredType string `json:"declared_type,omitempty"` // The part's Content-Type header
+	SniffedType  string `json:"sniffed_type,omitempty"`  // The type of its content; empty if it is no image the converter reads
+	Reason       string `json:"reason"`                  // unknown_type, not_allowed or type_mismatch
+}
+
+// uploadTypes returns the content types the converter detects in uploads.
+func uploadTypes() []string {
+	var types []string
+	for _, format := range converter.SupportedFeatures().InputFormats {
+		types = append(types, format.ContentType)
+	}
+	return types
+}
+
+// ValidateUploadTypes checks that every type of an Options.UploadTypes
+// allowlist is an input format of the converter, e.g. "image/jpeg".
+func ValidateUploadTypes(types []string) error {
+	known := uploadTypes()
+	for _, contentType := range types {
+		if !slices.Contains(known, contentType) {
+			return fmt.Errorf("%w: unknown type %q (expected one of %s)", ErrInvalidUploadTypes, contentType, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// checkUploadType sniffs the type of the part-th upload, file, before
+// anything decodes it, and returns it with the rejection of a part that
+// allowed does not let through: one that is no image the converter reads,
+// of a type left out of allowed, or whose Content-Type names another
+// image type than its content is. file is read from the start again.
+func checkUploadType(part int, header *uploadedFile, file multipart.File, allowed []string) (string, *UploadRejection, error) {
+	sniffed, err := converter.SniffContentType(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	rejection := &UploadRejection{Part: part, Filename: header.Filename, DeclaredType: header.Header.Get("Content-Type"), SniffedType: sniffed}
+	declared, _, _ := mime.ParseMediaType(rejection.DeclaredType)
+	switch {
+	case sniffed == "":
+		rejection.Reason = "unknown_type"
+	case !slices.Contains(allowed, sniffed):
+		rejection.Reason = "not_allowed"
+	case declared != sniffed && slices.Contains(uploadTypes(), declared):
+		rejection.Reason = "type_mismatch"
+	default:
+		return sniffed, nil, nil
+	}
+	return sniffed, rejection, nil
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"manga_to_pdf/internal/converter"
+)
+
+const (
+	jpegHeader = "\xff\xd8\xff\xe0 not a full JPEG"
+	pngHeader  = "\x89PNG\r\n\x1a\n not a full PNG"
+)
+
+// newTypedUploadRequest returns a request to url uploading each of parts,
+// given as filename, declared Content-Type and content, as an image.
+func newTypedUploadRequest(t *testing.T, url string, parts ...[3]string) *http.Request {
+	t.Helper()
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="images"; filename="`+p[0]+`"`)
+		if p[1] != "" {
+			header.Set("Content-Type", p[1])
+		}
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, p[2])
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestHandleConvert_UploadTypes(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	converted := false
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		converted = true
+		for i, src := range sources {
+			data, _ := io.ReadAll(src.Reader)
+			src.Reader.Close()
+			if want := []string{jpegHeader, pngHeader}[i]; string(data) != want {
+				t.Errorf("Source %d reads %q, want it from the start", i, data)
+			}
+			if want := []string{"image/jpeg", "image/png"}[i]; src.ContentType != want {
+				t.Errorf("Source %d has content type %q, want the sniffed %q", i, src.ContentType, want)
+			}
+		}
+		return false, errors.New("stop here")
+	}
+	handler := NewConvertHandler(Options{UploadTypes: []string{"image/jpeg", "image/png"}})
+
+	// Parts of allowed types reach the converter, typed by their content.
+	rr := httptest.NewRecorder()
+	handler(rr, newTypedUploadRequest(t, "/convert", [3]string{"1.jpg", "application/octet-stream", jpegHeader}, [3]string{"2", "", pngHeader}))
+	if !converted {
+		t.Fatalf("Allowed uploads were not converted: %d %s", rr.Code, rr.Body)
+	}
+
+	converted = false
+	rr = httptest.NewRecorder()
+	handler(rr, newTypedUploadRequest(t, "/convert",
+		[3]string{"1.jpg", "image/jpeg", jpegHeader},
+		[3]string{"2.gif", "image/gif", "GIF89a not a full GIF"},
+		[3]string{"3.jpg", "image/jpeg", pngHeader},
+		[3]string{"4.jpg", "image/jpeg", "<html>"},
+	))
+	if converted {
+		t.Error("A request with rejected uploads was converted")
+	}
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for rejected uploads, got %d", rr.Code)
+	}
+	var resp struct {
+		Details []UploadRejection `json:"details"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Could not parse JSON error response: %v", err)
+	}
+	want := []UploadRejection{
+		{Part: 1, Filename: "2.gif", DeclaredType: "image/gif", SniffedType: "image/gif", Reason: "not_allowed"},
+		{Part: 2, Filename: "3.jpg", DeclaredType: "image/jpeg", SniffedType: "image/png", Reason: "type_mismatch"},
+		{Part: 3, Filename: "4.jpg", DeclaredType: "image/jpeg", Reason: "unknown_type"},
+	}
+	if len(resp.Details) != len(want) {
+		t.Fatalf("Expected %d rejections, got %+v", len(want), resp.Details)
+	}
+	for i := range want {
+		if resp.Details[i] != want[i] {
+			t.Errorf("Rejection %d is %+v, want %+v", i, resp.Details[i], want[i])
+		}
+	}
+}
+
+func TestValidateUploadTypes(t *testing.T) {
+	if err := ValidateUploadTypes([]string{"image/jpeg", "image/webp"}); err != nil {
+		t.Errorf("Expected input formats to be valid upload types: %v", err)
+	}
+	if err := ValidateUploadTypes([]string{"image/avif"}); !errors.Is(err, ErrInvalidUploadTypes) {
+		t.Errorf("Expected ErrInvalidUploadTypes for an unknown type, got %v", err)
+	}
+}
+
+func TestSessions_UploadTypes(t *testing.T) {
+	jobs := NewJobManager(Options{UploadTypes: []string{"image/jpeg"}}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(jobs.Handler())
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/sessions", "", nil)
+	if err != nil {
+		t.Fatalf("POST /sessions failed: %v", err)
+	}
+	var opened SessionStatus
+	json.NewDecoder(resp.Body).Decode(&opened)
+	resp.Body.Close()
+	pagesURL := server.URL + "/sessions/" + opened.ID + "/pages"
+
+	resp, err = http.DefaultClient.Do(newTypedUploadRequest(t, pagesURL, [3]string{"1.jpg", "image/jpeg", jpegHeader}, [3]string{"2.png", "image/png", pngHeader}))
+	if err != nil {
+		t.Fatalf("POST pages failed: %v", err)
+	}
+	var rejected struct {
+		Details []UploadRejection `json:"details"`
+	}
+	json.NewDecoder(resp.Body).Decode(&rejected)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a page of a type not allowed, got %d", resp.StatusCode)
+	}
+	want := UploadRejection{Part: 1, Filename: "2.png", DeclaredType: "image/png", SniffedType: "image/png", Reason: "not_allowed"}
+	if len(rejected.Details) != 1 || rejected.Details[0] != want {
+		t.Errorf("Expected the rejection %+v, got %+v", want, rejected.Details)
+	}
+
+	// Nothing of a rejected request is appended; allowed pages are.
+	resp, err = http.DefaultClient.Do(newTypedUploadRequest(t, pagesURL, [3]string{"1.jpg", "", jpegHeader}))
+	if err != nil {
+		t.Fatalf("POST pages failed: %v", err)
+	}
+	var status SessionStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || status.Pages != 1 {
+		t.Errorf("Expected an allowed page to be appended alone, got %d %+v", resp.StatusCode, status)
+	}
+}
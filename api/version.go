@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion describes one version of the HTTP API, served under /v<Name>/.
+type APIVersion struct {
+	Name       string    // "1": served under /v1/, requested with "Accept-Version: 1"
+	Deprecated time.Time // When the version was deprecated; zero while it is not
+	Sunset     time.Time // When the version stops being served; zero if not planned
+	Link       string    // Migration guide for a deprecated version, if any
+}
+
+// Response headers naming the version a request was served with and
+// marking deprecated versions (RFC 9745 and RFC 8594).
+const (
+	headerAcceptVersion = "Accept-Version"
+	headerAPIVersion    = "API-Version"
+	headerDeprecation   = "Deprecation"
+	headerSunset        = "Sunset"
+)
+
+type versionKey struct{}
+
+// requestVersion is the API version a request is served with.
+type requestVersion struct {
+	name     string
+	prefixed bool // The path had the /v<name> prefix
+}
+
+// RequestAPIVersion returns the name of the API version the request behind
+// ctx is served with, or "" outside of Versioned.
+func RequestAPIVersion(ctx context.Context) string {
+	v, _ := ctx.Value(versionKey{}).(requestVersion)
+	return v.name
+}
+
+// versionPrefix returns the /v<N> prefix the request behind ctx was made
+// with, so URLs handed back to the client keep using it, or "" for an
+// unprefixed path.
+func versionPrefix(ctx context.Context) string {
+	if v, _ := ctx.Value(versionKey{}).(requestVersion); v.prefixed {
+		return "/v" + v.name
+	}
+	return ""
+}
+
+// Versioned serves h under a /v<N>/ prefix for each of versions, with the
+// prefix removed, and at its unprefixed paths for the version asked for
+// with an Accept-Version header or, without one, the first of versions,
+// the current one. A request for an unknown version, or whose
+// Accept-Version contradicts its prefix, gets 406 Not Acceptable. Every
+// response names its version in an API-Version header, and responses of
+// deprecated versions carry Deprecation, Sunset and Link headers.
+func Versioned(h http.Handler, versions ...APIVersion) http.Handler {
+	byName := make(map[string]APIVersion, len(versions))
+	names := make([]string, len(versions))
+	for i, v := range versions {
+		byName[v.Name] = v
+		names[i] = v.Name
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(headerAcceptVersion)), "v")
+
+		rv := requestVersion{name: versions[0].Name}
+		if name, rest, ok := cutVersionPrefix(r.URL.Path); ok {
+			if _, known := byName[name]; !known || (requested != "" && requested != name) {
+				writeJSONError(w, "Unsupported API version", map[string]any{"requested": "v" + name, "accept_version": requested, "supported": names}, http.StatusNotAcceptable)
+				return
+			}
+			rv = requestVersion{name: name, prefixed: true}
+			r = r.Clone(r.Context())
+			r.URL.Path = rest
+			r.URL.RawPath = ""
+		} else if requested != "" {
+			if _, known := byName[requested]; !known {
+				writeJSONError(w, "Unsupported API version", map[string]any{"accept_version": requested, "supported": names}, http.StatusNotAcceptable)
+				return
+			}
+			rv.name = requested
+		}
+
+		v := byName[rv.name]
+		header := w.Header()
+		header.Set(headerAPIVersion, v.Name)
+		header.Add("Vary", headerAcceptVersion)
+		if !v.Deprecated.IsZero() {
+			header.Set(headerDeprecation, "@"+strconv.FormatInt(v.Deprecated.Unix(), 10))
+			if v.Link != "" {
+				header.Add("Link", "<"+v.Link+`>; rel="deprecation"`)
+			}
+		}
+		if !v.Sunset.IsZero() {
+			header.Set(headerSunset, v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey{}, rv)))
+	})
+}
+
+// cutVersionPrefix splits "/v1/convert" into "1" and "/convert". ok is
+// false for paths without a /v<N> prefix.
+func cutVersionPrefix(path string) (name, rest string, ok bool) {
+	after, found := strings.CutPrefix(path, "/v")
+	if !found {
+		return "", "", false
+	}
+	name, rest, _ = strings.Cut(after, "/")
+	if name == "" || strings.Trim(name, "0123456789") != "" {
+		return "", "", false
+	}
+	return name, "/" + rest, true
+}
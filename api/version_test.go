@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVersioned(t *testing.T) {
+	deprecated := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	h := Versioned(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Version", RequestAPIVersion(r.Context()))
+		w.Header().Set("X-Prefix", versionPrefix(r.Context()))
+	}), APIVersion{Name: "2"}, APIVersion{Name: "1", Deprecated: deprecated, Sunset: sunset, Link: "https://example.com/migrate"})
+
+	for _, tc := range []struct {
+		path, acceptVersion string
+		status              int
+		wantPath, version   string
+		prefix              string
+	}{
+		{"/convert", "", http.StatusOK, "/convert", "2", ""},
+		{"/v2/convert", "", http.StatusOK, "/convert", "2", "/v2"},
+		{"/v1/jobs/abc", "", http.StatusOK, "/jobs/abc", "1", "/v1"},
+		{"/jobs", "1", http.StatusOK, "/jobs", "1", ""},
+		{"/jobs", "v2", http.StatusOK, "/jobs", "2", ""},
+		{"/video", "", http.StatusOK, "/video", "2", ""}, // Not a version prefix
+		{"/v3/convert", "", http.StatusNotAcceptable, "", "", ""},
+		{"/convert", "3", http.StatusNotAcceptable, "", "", ""},
+		{"/v1/convert", "2", http.StatusNotAcceptable, "", "", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.acceptVersion != "" {
+			req.Header.Set("Accept-Version", tc.acceptVersion)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.status {
+			t.Errorf("%s (Accept-Version %q): expected %d, got %d", tc.path, tc.acceptVersion, tc.status, rr.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		got := rr.Header()
+		if got.Get("X-Path") != tc.wantPath || got.Get("X-Version") != tc.version || got.Get("X-Prefix") != tc.prefix || got.Get("API-Version") != tc.version {
+			t.Errorf("%s (Accept-Version %q): got path %q, version %q (header %q), prefix %q", tc.path, tc.acceptVersion, got.Get("X-Path"), got.Get("X-Version"), got.Get("API-Version"), got.Get("X-Prefix"))
+		}
+		if deprecatedVersion := tc.version == "1"; deprecatedVersion != (got.Get("Deprecation") != "") {
+			t.Errorf("%s: unexpected Deprecation header %q", tc.path, got.Get("Deprecation"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/convert", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Deprecation"); got != "@1767225600" {
+		t.Errorf("Expected the deprecation date as a Unix timestamp, got %q", got)
+	}
+	if got := rr.Header().Get("Sunset"); got != "Wed, 01 Jul 2026 00:00:00 GMT" {
+		t.Errorf("Expected the sunset as an HTTP date, got %q", got)
+	}
+	if got := rr.Header().Get("Link"); got != `<https://example.com/migrate>; rel="deprecation"` {
+		t.Errorf("Expected the migration guide link, got %q", got)
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WebhookPolicy controls how often a webhook delivery is retried, how many
+// are sent at once and which addresses receivers may have.
+type WebhookPolicy struct {
+	MaxAttempts int           // Attempts before a delivery is dead-lettered (default 8)
+	BaseDelay   time.Duration // Wait after the first failure, doubled after each further one (default 5s)
+	MaxDelay    time.Duration // Upper bound of the wait between attempts (default 10m)
+	Timeout     time.Duration // Time limit of a single attempt (default 10s)
+	Concurrency int           // Deliveries sent at once (default 4)
+
+	// AllowedNetworks are the loopback, private, link-local and other
+	// non-public networks receivers may be in anyway. Receivers anywhere
+	// else in them are refused when dialed, so a webhook_url cannot reach
+	// the server's own services or a cloud metadata endpoint.
+	AllowedNetworks []netip.Prefix
+}
+
+// errWebhookAddress is returned for receivers at an address outside the
+// public internet and WebhookPolicy.AllowedNetworks.
+var errWebhookAddress = errors.New("receiver address is not public and not in the allowed networks")
+
+// WebhookDelivery is one notification to a receiver. Deliveries that fail
+// are retried with exponential backoff; after the last attempt they are kept
+// as dead letters until an administrator retries or deletes them.
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	Tenant      string          `json:"tenant"`
+	URL         string          `json:"url"`
+	Event       string          `json:"event"` // e.g. "job.done"
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"` // Zero for dead letters
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// webhookState is what the notifier persists between restarts.
+type webhookState struct {
+	Pending     []*WebhookDelivery `json:"pending"`
+	DeadLetters []*WebhookDelivery `json:"dead_letters"`
+}
+
+// WebhookNotifier POSTs job notifications to the receivers' URLs. Pending
+// deliveries and dead letters are saved to a state file after every change,
+// so a restart neither drops a notification nor forgets a failed one.
+type WebhookNotifier struct {
+	client    *http.Client
+	policy    WebhookPolicy
+	stateFile string // "" keeps the state in memory only
+
+	mu      sync.Mutex
+	state   webhookState
+	sending map[string]bool // IDs of the deliveries being attempted
+	wake    chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWebhookNotifier returns a WebhookNotifier persisting its state to
+// stateFile (or nowhere if empty), resuming the deliveries pending there.
+// Close stops it.
+func NewWebhookNotifier(stateFile string, policy WebhookPolicy) (*WebhookNotifier, error) {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 8
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 5 * time.Second
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = 10 * time.Minute
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = 10 * time.Second
+	}
+	if policy.Concurrency <= 0 {
+		policy.Concurrency = 4
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: policy.checkAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil // Dialed directly, so checkAddress sees the receiver's address
+	n := &WebhookNotifier{
+		client:    &http.Client{Timeout: policy.Timeout, Transport: transport},
+		policy:    policy,
+		stateFile: stateFile,
+		sending:   map[string]bool{},
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if stateFile != "" {
+		data, err := os.ReadFile(stateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not read webhook state: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &n.state); err != nil {
+				return nil, fmt.Errorf("could not parse webhook state %s: %w", stateFile, err)
+			}
+		}
+		if len(n.state.Pending) > 0 {
+			slog.Info("Resuming webhook deliveries", "pending", len(n.state.Pending), "deadLetters", len(n.state.DeadLetters))
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	go n.loop(ctx)
+	return n, nil
+}
+
+// Close stops delivering. Pending deliveries stay in the state file.
+func (n *WebhookNotifier) Close() {
+	n.cancel()
+	<-n.done
+}
+
+// Notify queues a delivery of payload, encoded as JSON, to rawURL.
+func (n *WebhookNotifier) Notify(tenant, rawURL, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	n.mu.Lock()
+	n.state.Pending = append(n.state.Pending, &WebhookDelivery{ID: id, Tenant: tenant, URL: rawURL, Event: event, Payload: data, CreatedAt: now, NextAttempt: now})
+	n.saveLocked()
+	n.mu.Unlock()
+	n.signal()
+	return nil
+}
+
+// validWebhookURL checks that a receiver URL is an absolute http(s) URL.
+func validWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// checkAddress is the net.Dialer.Control of the webhook client: it refuses
+// connections to addresses outside the public internet unless they are in
+// p.AllowedNetworks. It runs for every address dialed, so a receiver whose
+// name resolves to a private address, or is redirected to one, is refused
+// too.
+func (p WebhookPolicy) checkAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if publicAddress(addr) {
+		return nil
+	}
+	for _, prefix := range p.AllowedNetworks {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return errWebhookAddress
+}
+
+// checkHost refuses a receiver URL whose host is an IP address
+// checkAddress would refuse, so such requests fail at once; names are only
+// checked once they are resolved, when dialed.
+func (n *WebhookNotifier) checkHost(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(u.Hostname())
+	if err != nil {
+		return nil
+	}
+	return n.policy.checkAddress("tcp", netip.AddrPortFrom(addr, 0).String(), nil)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether addr may be on the public internet.
+func publicAddress(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+func (n *WebhookNotifier) signal() {
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop sends due deliveries, up to WebhookPolicy.Concurrency at once, and
+// sleeps until the next one is due, one is queued or an attempt finishes.
+func (n *WebhookNotifier) loop(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer close(n.done)
+	defer wg.Wait()
+	for {
+		n.mu.Lock()
+		var due []*WebhookDelivery
+		var next time.Time
+		now := time.Now()
+		for _, d := range n.state.Pending {
+			switch {
+			case n.sending[d.ID]:
+			case !now.Before(d.NextAttempt):
+				// Due ones beyond the limit wait for an attempt to finish.
+				if len(n.sending) < n.policy.Concurrency {
+					n.sending[d.ID] = true
+					due = append(due, d)
+				}
+			case next.IsZero() || d.NextAttempt.Before(next):
+				next = d.NextAttempt
+			}
+		}
+		n.mu.Unlock()
+
+		for _, d := range due {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := n.send(ctx, d)
+				if ctx.Err() != nil {
+					return
+				}
+				n.finish(d, err)
+			}()
+		}
+
+		timer := time.NewTimer(time.Hour)
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-n.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// send makes one delivery attempt; any 2xx answer counts as delivered.
+func (n *WebhookNotifier) send(ctx context.Context, d *WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "manga_to_pdf-webhook")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+// finish records the outcome of an attempt: the delivery is dropped when it
+// succeeded, rescheduled with backoff, or dead-lettered after the last
+// attempt or when its receiver's address is refused, which retrying would
+// not change.
+func (n *WebhookNotifier) finish(d *WebhookDelivery, err error) {
+	defer n.signal()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.sending, d.ID)
+	n.state.Pending = removeDelivery(n.state.Pending, d.ID)
+	d.Attempts++
+	switch {
+	case err == nil:
+		slog.Info("Delivered webhook", "delivery", d.ID, "event", d.Event, "attempts", d.Attempts)
+	case d.Attempts >= n.policy.MaxAttempts || errors.Is(err, errWebhookAddress):
+		slog.Warn("Webhook delivery failed for good, keeping it as a dead letter", "delivery", d.ID, "url", d.URL, "attempts", d.Attempts, "error", err)
+		d.LastError, d.NextAttempt = err.Error(), time.Time{}
+		n.state.DeadLetters = append(n.state.DeadLetters, d)
+	default:
+		delay := min(n.policy.BaseDelay<<min(d.Attempts-1, 20), n.policy.MaxDelay)
+		slog.Warn("Webhook delivery failed, retrying", "delivery", d.ID, "url", d.URL, "attempts", d.Attempts, "retryIn", delay, "error", err)
+		d.LastError, d.NextAttempt = err.Error(), time.Now().Add(delay)
+		n.state.Pending = append(n.state.Pending, d)
+	}
+	n.saveLocked()
+}
+
+func removeDelivery(deliveries []*WebhookDelivery, id string) []*WebhookDelivery {
+	for i, d := range deliveries {
+		if d.ID == id {
+			return append(deliveries[:i:i], deliveries[i+1:]...)
+		}
+	}
+	return deliveries
+}
+
+// saveLocked writes the state file, replacing the previous one only once
+// the new one is complete. n.mu must be held.
+func (n *WebhookNotifier) saveLocked() {
+	if n.stateFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(n.state, "", "  ")
+	if err == nil {
+		tmp := n.stateFile + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0o644); err == nil {
+			err = os.Rename(tmp, n.stateFile)
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to save webhook state", "file", n.stateFile, "error", err)
+	}
+}
+
+// AdminHandler returns the handler for the dead-letter admin API:
+// GET /admin/webhooks lists pending deliveries and dead letters,
+// POST /admin/webhooks/dead-letters/{id}/retry queues a dead letter again
+// and DELETE /admin/webhooks/dead-letters/{id} discards it.
+func (n *WebhookNotifier) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/webhooks", n.handleList)
+	mux.HandleFunc("POST /admin/webhooks/dead-letters/{id}/retry", n.handleRetry)
+	mux.HandleFunc("DELETE /admin/webhooks/dead-letters/{id}", n.handleDiscard)
+	return mux
+}
+
+func (n *WebhookNotifier) handleList(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	state := webhookState{
+		Pending:     append([]*WebhookDelivery{}, n.state.Pending...),
+		DeadLetters: append([]*WebhookDelivery{}, n.state.DeadLetters...),
+	}
+	data, err := json.Marshal(state) // Encoded under the lock, as finish updates deliveries in place
+	n.mu.Unlock()
+	if err != nil {
+		writeJSONError(w, "Failed to list webhook deliveries", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+func (n *WebhookNotifier) handleRetry(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	n.mu.Lock()
+	var found *WebhookDelivery
+	for _, d := range n.state.DeadLetters {
+		if d.ID == id {
+			found = d
+		}
+	}
+	if found != nil {
+		n.state.DeadLetters = removeDelivery(n.state.DeadLetters, id)
+		found.Attempts, found.NextAttempt = 0, time.Now()
+		n.state.Pending = append(n.state.Pending, found)
+		n.saveLocked()
+	}
+	n.mu.Unlock()
+	if found == nil {
+		writeJSONError(w, "Dead letter not found", id, http.StatusNotFound)
+		return
+	}
+	slog.Info("Retrying dead-lettered webhook", "delivery", id)
+	n.signal()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (n *WebhookNotifier) handleDiscard(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	n.mu.Lock()
+	before := len(n.state.DeadLetters)
+	n.state.DeadLetters = removeDelivery(n.state.DeadLetters, id)
+	removed := len(n.state.DeadLetters) < before
+	if removed {
+		n.saveLocked()
+	}
+	n.mu.Unlock()
+	if !removed {
+		writeJSONError(w, "Dead letter not found", id, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
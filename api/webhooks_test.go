@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// loopback allows webhooks to the test receivers on 127.0.0.1.
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookNotifier_RetryAndDeadLetters(t *testing.T) {
+	var flakyCalls, downCalls atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flakyCalls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downCalls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	stateFile := filepath.Join(t.TempDir(), "webhooks.json")
+	policy := WebhookPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, AllowedNetworks: loopback}
+	n, err := NewWebhookNotifier(stateFile, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify("t1", flaky.URL, "job.done", map[string]string{"id": "a"})
+	n.Notify("t1", down.URL, "job.done", map[string]string{"id": "b"})
+
+	list := func(n *WebhookNotifier) webhookState {
+		rr := httptest.NewRecorder()
+		n.AdminHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/webhooks", nil))
+		var state webhookState
+		json.NewDecoder(rr.Body).Decode(&state)
+		return state
+	}
+	waitFor(t, "both deliveries to settle", func() bool {
+		state := list(n)
+		return len(state.Pending) == 0 && len(state.DeadLetters) == 1
+	})
+	if flakyCalls.Load() != 3 || downCalls.Load() != 3 {
+		t.Errorf("Expected 3 attempts per receiver, got %d and %d", flakyCalls.Load(), downCalls.Load())
+	}
+	n.Close()
+
+	// The dead letter survives a restart and can be retried from the admin API.
+	n, err = NewWebhookNotifier(stateFile, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	dead := list(n).DeadLetters
+	if len(dead) != 1 || dead[0].URL != down.URL || dead[0].LastError == "" {
+		t.Fatalf("Expected the failed delivery as a dead letter, got %+v", dead)
+	}
+	rr := httptest.NewRecorder()
+	n.AdminHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/webhooks/dead-letters/"+dead[0].ID+"/retry", nil))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 for a retry, got %d", rr.Code)
+	}
+	waitFor(t, "the retried delivery to fail again", func() bool { return downCalls.Load() == 6 })
+
+	rr = httptest.NewRecorder()
+	waitFor(t, "the dead letter to return", func() bool { return len(list(n).DeadLetters) == 1 })
+	n.AdminHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/admin/webhooks/dead-letters/"+dead[0].ID, nil))
+	if rr.Code != http.StatusNoContent || len(list(n).DeadLetters) != 0 {
+		t.Errorf("Expected the dead letter to be discarded, got %d", rr.Code)
+	}
+}
+
+func TestJobs_Webhook(t *testing.T) {
+	originalConvertToPDF := convertToPDF
+	defer func() { convertToPDF = originalConvertToPDF }()
+	convertToPDF = func(ctx context.Context, sources []converter.ImageSource, cfg *converter.Config, writer io.Writer, stats *converter.Stats) (bool, error) {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		io.WriteString(writer, "%PDF-stub")
+		return true, nil
+	}
+
+	received := make(chan JobStatus, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status JobStatus
+		json.NewDecoder(r.Body).Decode(&status)
+		if r.Header.Get("X-Webhook-Event") == "job.done" {
+			received <- status
+		}
+	}))
+	defer receiver.Close()
+
+	n, err := NewWebhookNotifier("", WebhookPolicy{AllowedNetworks: loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	jobs := NewJobManager(Options{Webhooks: n}, 0)
+	defer jobs.Close()
+
+	rr := httptest.NewRecorder()
+	jobs.Handler().ServeHTTP(rr, newFileUploadRequest(t, "/jobs", map[string]string{"webhook_url": "ftp://example.com"}, map[string]string{"images": "dummy.txt"}))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a non-HTTP webhook_url, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	jobs.Handler().ServeHTTP(rr, newFileUploadRequest(t, "/jobs", map[string]string{"webhook_url": receiver.URL}, map[string]string{"images": "dummy.txt"}))
+	var created JobStatus
+	json.NewDecoder(rr.Body).Decode(&created)
+	select {
+	case status := <-received:
+		if status.ID != created.ID || status.Status != JobDone || status.Download == "" {
+			t.Errorf("Unexpected webhook payload %+v", status)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the webhook")
+	}
+}
+
+func TestWebhookNotifier_RefusesPrivateAddresses(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer receiver.Close()
+
+	n, err := NewWebhookNotifier("", WebhookPolicy{BaseDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.Notify("t1", receiver.URL, "job.done", map[string]string{"id": "a"})
+	waitFor(t, "the delivery to be dead-lettered", func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return len(n.state.DeadLetters) == 1
+	})
+	n.mu.Lock()
+	dead := n.state.DeadLetters[0]
+	n.mu.Unlock()
+	if calls.Load() != 0 || dead.Attempts != 1 {
+		t.Errorf("Expected the loopback receiver refused once and never reached, got %d calls in %d attempts", calls.Load(), dead.Attempts)
+	}
+
+	for _, addr := range []string{"169.254.169.254", "10.1.2.3", "::1", "::ffff:192.168.0.1", "100.64.0.1"} {
+		if err := n.checkHost("http://" + net.JoinHostPort(addr, "80") + "/hook"); err == nil {
+			t.Errorf("Expected a webhook_url on %s to be refused", addr)
+		}
+	}
+	if err := n.checkHost("http://93.184.215.14/hook"); err != nil {
+		t.Errorf("Expected a public address to be accepted, got %v", err)
+	}
+}
+
+func TestWebhookNotifier_SendsConcurrently(t *testing.T) {
+	var arrived atomic.Int32
+	both := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if arrived.Add(1) == 2 {
+			close(both)
+		}
+		select {
+		case <-both:
+		case <-time.After(time.Second):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	n, err := NewWebhookNotifier("", WebhookPolicy{Concurrency: 2, AllowedNetworks: loopback})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	n.Notify("t1", receiver.URL, "job.done", map[string]string{"id": "a"})
+	n.Notify("t1", receiver.URL, "job.done", map[string]string{"id": "b"})
+	select {
+	case <-both:
+	case <-time.After(time.Second):
+		t.Fatal("Expected both deliveries to be sent at once")
+	}
+}
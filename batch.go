@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// runBatch converts each of cfg.Inputs to its own output, cfg.Jobs at a
+// time. The conversions share one converter.WorkerLimit of cfg.Workers, so
+// the batch as a whole uses no more CPUs than a single conversion would. A
+// failed input is logged and does not stop the others; the error returned
+// lists every one that failed. Inputs the checkpoint, if any, records as
+// done are skipped, and those converted are recorded in it.
+func runBatch(ctx context.Context, cfg Config) error {
+	jobs := max(cfg.Jobs, 1)
+	if jobs > 1 && cfg.OnExists == "prompt" {
+		return errors.New("-on-exists prompt needs -jobs 1 to convert several inputs")
+	}
+	outputs, err := batchOutputs(cfg)
+	if err != nil {
+		return err
+	}
+	slog.Info("Converting inputs", "inputs", len(cfg.Inputs), "jobs", jobs, "workers", cfg.Workers)
+
+	started := time.Now()
+	limit := converter.NewWorkerLimit(cfg.Workers)
+	errs := make([]error, len(cfg.Inputs))
+	slots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, input := range cfg.Inputs {
+		if cfg.checkpoint.done(input) {
+			slog.Info("Converted before the checkpoint, skipping", "input", input)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break // Inputs not started yet are left alone
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			c := cfg
+			c.Input, c.Inputs, c.Output, c.limit = input, nil, outputs[i], limit
+			if jobs > 1 {
+				c.Progress = false // One bar cannot show several conversions
+			}
+			errs[i] = runInput(ctx, c)
+			if errs[i] != nil && converter.CancellationReason(errs[i]) == "" {
+				slog.Error("Input failed", "input", input, "error", errs[i])
+			}
+			if errs[i] == nil {
+				if err := cfg.checkpoint.markDone(input); err != nil {
+					slog.Warn("Failed to record input in checkpoint", "input", input, "error", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", cfg.Inputs[i], err))
+		}
+	}
+	slog.Info("Converted inputs", "inputs", len(cfg.Inputs), "failed", len(failed), "duration", time.Since(started).Round(time.Millisecond))
+	if ctx.Err() != nil {
+		return converter.CancellationError(ctx)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d inputs failed: %w", len(failed), len(cfg.Inputs), errors.Join(failed...))
+	}
+	return nil
+}
+
+// batchOutputs returns the output of each of cfg.Inputs: from
+// cfg.OutputTemplate, or named after the input in the cfg.Output
+// directory, or "" for the usual output next to the input. Their
+// directories are created. Two inputs with the same output are an error.
+func batchOutputs(cfg Config) ([]string, error) {
+	outputs := make([]string, len(cfg.Inputs))
+	inputOf := make(map[string]string, len(cfg.Inputs))
+	for i, input := range cfg.Inputs {
+		switch {
+		case cfg.OutputTemplate != "":
+			outputs[i] = expandOutputTemplate(cfg.OutputTemplate, cfg, input, i+1, len(cfg.Inputs))
+		case cfg.Output != "":
+			outputs[i] = filepath.Join(cfg.Output, inputName(input)+outputExt(cfg))
+		default:
+			continue
+		}
+		if other, ok := inputOf[outputs[i]]; ok {
+			return nil, fmt.Errorf("%s and %s would both be written to %s; use an -output-template with {name} or {index}", other, input, outputs[i])
+		}
+		inputOf[outputs[i]] = input
+		if err := os.MkdirAll(filepath.Dir(outputs[i]), 0o755); err != nil {
+			return nil, fmt.Errorf("could not create output directory: %w", err)
+		}
+	}
+	return outputs, nil
+}
+
+// expandOutputTemplate returns the output of input, the index-th of total
+// inputs, from template: {dir} is replaced by the input's directory,
+// {name} by its name without an archive extension, {ext} by the output's
+// extension and {index} by index, zero-padded to the width of total.
+func expandOutputTemplate(template string, cfg Config, input string, index, total int) string {
+	path := strings.TrimPrefix(input, tarInputPrefix)
+	return strings.NewReplacer(
+		"{dir}", filepath.Dir(filepath.Clean(path)),
+		"{name}", inputName(input),
+		"{ext}", outputExt(cfg),
+		"{index}", fmt.Sprintf("%0*d", len(strconv.Itoa(total)), index),
+	).Replace(template)
+}
+
+// inputName returns the name of input for its output: the directory name,
+// or the archive or tar file name without its extension.
+func inputName(input string) string {
+	name := filepath.Base(filepath.Clean(strings.TrimPrefix(input, tarInputPrefix)))
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".cbz", ".zip", ".tar":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunApp_Batch(t *testing.T) {
+	dir, outputDir := t.TempDir(), t.TempDir()
+	for _, name := range []string{"vol1", "vol2"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writePNG(t, filepath.Join(dir, name, "1.png"))
+		writePNG(t, filepath.Join(dir, name, "2.png"))
+	}
+	cfg := defaultConfig()
+	cfg.Inputs = []string{filepath.Join(dir, "vol1"), filepath.Join(dir, "missing"), filepath.Join(dir, "vol2")}
+	cfg.Input = cfg.Inputs[0]
+	cfg.OutputTemplate = filepath.Join(outputDir, "{index}-{name}{ext}")
+	cfg.Jobs = 2
+	cfg.Workers = 1
+	err := runApp(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 inputs failed") || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Expected the missing input to be reported, got %v", err)
+	}
+	for _, name := range []string{"1-vol1.pdf", "3-vol2.pdf"} {
+		if !pdfWritten(filepath.Join(outputDir, name)) {
+			t.Errorf("Expected %s to be written despite the failed input", name)
+		}
+	}
+
+	// Without a template, -o is the directory for the outputs.
+	cfg.Inputs = []string{filepath.Join(dir, "vol1"), filepath.Join(dir, "vol2")}
+	cfg.OutputTemplate, cfg.Output = "", filepath.Join(outputDir, "plain")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Batch into a directory failed: %v", err)
+	}
+	for _, name := range []string{"vol1.pdf", "vol2.pdf"} {
+		if !pdfWritten(filepath.Join(outputDir, "plain", name)) {
+			t.Errorf("Expected %s in the -o directory", name)
+		}
+	}
+
+	cfg.OutputTemplate = filepath.Join(outputDir, "same.pdf")
+	if err := runApp(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "both be written") {
+		t.Errorf("Expected inputs with the same output to be rejected, got %v", err)
+	}
+}
+
+func TestExpandOutputTemplate(t *testing.T) {
+	cfg := defaultConfig()
+	for _, tc := range []struct {
+		input, want string
+	}{
+		{"series/vol1", "series/vol1.pdf"},
+		{"series/vol2.cbz/", "series/vol2.pdf"},
+		{"tar:dl/vol3.tar", "dl/vol3.pdf"},
+	} {
+		if got := expandOutputTemplate("{dir}/{name}{ext}", cfg, tc.input, 1, 1); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.input, tc.want, got)
+		}
+	}
+	if got := expandOutputTemplate("out/{index}-{name}{ext}", cfg, "vol7", 7, 120); got != "out/007-vol7.pdf" {
+		t.Errorf("Expected the index padded to the width of the count, got %q", got)
+	}
+}
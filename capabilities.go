@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"manga_to_pdf/api"
+)
+
+// apiVersions are the versions of the HTTP API the server serves, current
+// first. Later versions will be added here, with the dates old ones are
+// deprecated and retired.
+var apiVersions = []api.APIVersion{{Name: "1"}}
+
+// runCapabilitiesCommand prints what this build supports: input and output
+// formats, decoders and optional integrations, as a table or, with -json,
+// as the JSON GET /capabilities serves.
+func runCapabilitiesCommand(args []string, out, errOut io.Writer) int {
+	flagSet := flag.NewFlagSet("capabilities", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: manga_to_pdf capabilities [-json]")
+		flagSet.PrintDefaults()
+	}
+	asJSON := flagSet.Bool("json", false, "Print the capabilities as JSON, as served by GET /capabilities")
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flagSet.NArg() != 0 {
+		flagSet.Usage()
+		return 2
+	}
+
+	caps := api.NewCapabilities(apiVersions...)
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(caps); err != nil {
+			fmt.Fprintln(errOut, "capabilities:", err)
+			return 1
+		}
+		return 0
+	}
+	printCapabilities(out, caps)
+	return 0
+}
+
+// printCapabilities writes caps as a human-readable summary.
+func printCapabilities(w io.Writer, caps api.Capabilities) {
+	b := caps.Build
+	fmt.Fprintf(w, "manga_to_pdf %s (%s, %s/%s", b.Version, b.GoVersion, b.OS, b.Arch)
+	if len(b.Tags) > 0 {
+		fmt.Fprintf(w, ", tags %s", strings.Join(b.Tags, ","))
+	}
+	fmt.Fprintln(w, ")")
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INPUT\tEXTENSIONS\tDECODER\tPASS-THROUGH")
+	for _, format := range caps.Converter.InputFormats {
+		passThrough := "no"
+		if format.PassThrough {
+			passThrough = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", format.ContentType, strings.Join(format.Extensions, " "), format.Decoder, passThrough)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Output formats:\t%s\n", strings.Join(caps.Converter.OutputFormats, ", "))
+	fmt.Fprintf(tw, "WebP targets:\t%s\n", strings.Join(caps.Converter.WebPTargets, ", "))
+	fmt.Fprintf(tw, "Resample filters:\t%s\n", strings.Join(caps.Converter.ResampleFilters, ", "))
+	fmt.Fprintf(tw, "Profiles:\t%s\n", strings.Join(caps.Converter.Profiles, ", "))
+	fmt.Fprintf(tw, "Job storage:\t%s\n", strings.Join(caps.JobStorage, ", "))
+	fmt.Fprintf(tw, "API versions:\t%s\n", strings.Join(caps.APIVersions, ", "))
+
+	var with, without []string
+	for name, ok := range caps.Integrations {
+		if ok {
+			with = append(with, name)
+		} else {
+			without = append(without, name)
+		}
+	}
+	sort.Strings(with)
+	sort.Strings(without)
+	fmt.Fprintf(tw, "Integrations:\t%s\n", strings.Join(with, ", "))
+	fmt.Fprintf(tw, "Not built in:\t%s\n", strings.Join(without, ", "))
+	tw.Flush()
+}
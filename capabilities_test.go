@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"manga_to_pdf/api"
+)
+
+func TestRunCapabilitiesCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := runCapabilitiesCommand([]string{"--json"}, &out, &errOut); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, errOut.String())
+	}
+	var caps api.Capabilities
+	if err := json.Unmarshal(out.Bytes(), &caps); err != nil {
+		t.Fatalf("Expected JSON, got %q: %v", out.String(), err)
+	}
+	if len(caps.Converter.InputFormats) == 0 || len(caps.APIVersions) == 0 {
+		t.Errorf("Expected input formats and API versions, got %+v", caps)
+	}
+
+	out.Reset()
+	if code := runCapabilitiesCommand(nil, &out, &errOut); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	for _, want := range []string{"image/webp", "golang.org/x/image/webp", "Output formats:", "Not built in:", "ocr"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the summary, got:\n%s", want, out.String())
+		}
+	}
+
+	if code := runCapabilitiesCommand([]string{"extra"}, &out, &errOut); code != 2 {
+		t.Errorf("Expected exit code 2 for an extra argument, got %d", code)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// checkpointStateFile is the file of a -checkpoint directory listing the
+// inputs of a batch that are done; the converted pages are kept in its
+// pages subdirectory.
+const checkpointStateFile = "state.json"
+
+// checkpoint is the progress of a run kept in its -checkpoint directory.
+// Every page is kept as soon as it is converted and every input of a
+// batch is recorded once its output is written, so a run that is stopped,
+// by SIGTERM, Ctrl-C or anything else, is taken up where it stopped when
+// it is run again with the same -checkpoint: finished inputs are skipped
+// and converted pages are taken as they are instead of being converted
+// again.
+type checkpoint struct {
+	dir   string
+	pages pageDir // Converted pages, in dir/pages
+
+	mu    sync.Mutex
+	state checkpointState
+}
+
+// checkpointState is the content of checkpointStateFile.
+type checkpointState struct {
+	Done []string `json:"done"` // Absolute paths of the inputs converted
+}
+
+// openCheckpoint opens the checkpoint in dir, creating dir if need be. The
+// progress it holds is resumed.
+func openCheckpoint(dir string) (*checkpoint, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "pages"), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create checkpoint: %w", err)
+	}
+	c := &checkpoint{dir: dir, pages: pageDir(filepath.Join(dir, "pages"))}
+	data, err := os.ReadFile(filepath.Join(dir, checkpointStateFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read checkpoint: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &c.state); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", filepath.Join(dir, checkpointStateFile), err)
+		}
+		slog.Info("Resuming from checkpoint", "checkpoint", dir, "inputs_done", len(c.state.Done), "pages", c.pages.count())
+	}
+	return c, nil
+}
+
+// pageCache returns the page cache of conversions with c, nil without a
+// checkpoint.
+func (c *checkpoint) pageCache() converter.PageCache {
+	if c == nil {
+		return nil
+	}
+	return c.pages
+}
+
+// done reports whether input was converted before the checkpoint.
+func (c *checkpoint) done(input string) bool {
+	if c == nil {
+		return false
+	}
+	abs, _ := filepath.Abs(input)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.state.Done, abs)
+}
+
+// markDone records that input is converted, replacing the state file only
+// once the new one is complete.
+func (c *checkpoint) markDone(input string) error {
+	if c == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Done = append(c.state.Done, abs)
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(c.dir, checkpointStateFile)
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("could not save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// finish removes the checkpoint once the run it was opened for succeeded,
+// and its directory if nothing else is in it. After a failure it is kept,
+// for the next run to resume.
+func (c *checkpoint) finish(runErr error) {
+	if runErr == nil {
+		err := errors.Join(os.RemoveAll(string(c.pages)), os.Remove(filepath.Join(c.dir, checkpointStateFile)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to remove checkpoint", "checkpoint", c.dir, "error", err)
+		}
+		os.Remove(c.dir) // Fails if other files are in it
+		return
+	}
+	c.mu.Lock()
+	inputs := len(c.state.Done)
+	c.mu.Unlock()
+	slog.Info("Kept checkpoint; run again with the same -checkpoint to resume", "checkpoint", c.dir, "inputs_done", inputs, "pages", c.pages.count())
+}
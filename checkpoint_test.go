@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunApp_Checkpoint(t *testing.T) {
+	dir, outputDir := t.TempDir(), t.TempDir()
+	checkpointDir := filepath.Join(t.TempDir(), "checkpoint")
+	for _, name := range []string{"vol1", "vol2"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writePNG(t, filepath.Join(dir, name, "1.png"))
+	}
+	cfg := defaultConfig()
+	cfg.Inputs = []string{filepath.Join(dir, "vol1"), filepath.Join(dir, "vol3"), filepath.Join(dir, "vol2")}
+	cfg.Input = cfg.Inputs[0]
+	cfg.Output = outputDir
+	cfg.Checkpoint = checkpointDir
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Fatal("Expected the missing input to fail the run")
+	}
+	checkpoint, err := openCheckpoint(checkpointDir)
+	if err != nil {
+		t.Fatalf("Expected the checkpoint to be kept after a failed run: %v", err)
+	}
+	if !checkpoint.done(cfg.Inputs[0]) || !checkpoint.done(cfg.Inputs[2]) || checkpoint.done(cfg.Inputs[1]) || checkpoint.pages.count() == 0 {
+		t.Errorf("Expected vol1 and vol2 done with their pages kept, got %+v and %d pages", checkpoint.state, checkpoint.pages.count())
+	}
+
+	// The run resumes: the inputs done are not converted again.
+	os.Remove(filepath.Join(outputDir, "vol1.pdf"))
+	if err := os.Mkdir(filepath.Join(dir, "vol3"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(dir, "vol3", "1.png"))
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Resumed run failed: %v", err)
+	}
+	if !pdfWritten(filepath.Join(outputDir, "vol3.pdf")) || fileExists(filepath.Join(outputDir, "vol1.pdf")) {
+		t.Error("Expected only the input left over to be converted")
+	}
+	if fileExists(checkpointDir) {
+		t.Error("Expected the checkpoint to be removed once the run succeeded")
+	}
+}
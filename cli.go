@@ -0,0 +1,1097 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// orderOverrideFile is the per-series ordering override kept in the input
+// directory, so repeated conversions of the same release keep its order.
+const orderOverrideFile = ".manga_to_pdf-order.json"
+
+// runApp converts the images in cfg.Input to a PDF at cfg.Output. With
+// cfg.Recursive every directory below cfg.Input holding images is a chapter;
+// chapters go into one PDF in order, or into one PDF each with cfg.Split.
+// A CBZ or ZIP file is converted by runArchive instead, and a tar stream
+// by runTarStream. With cfg.EventsFile, the steps of the run are written
+// to it as they happen, and with cfg.Manifest, what became of every output
+// and page is written to it when the run ends, even if it fails. With
+// cfg.Watch, cfg.Input is a drop folder watched by runWatch. With
+// cfg.MetricsPush, the metrics of the run are pushed there when it ends.
+// An Output of stdioName writes the one output of the run to stdout.
+func runApp(ctx context.Context, cfg Config) error {
+	if cfg.Output == stdioName {
+		switch {
+		case cfg.Watch || len(cfg.Inputs) > 1 || cfg.Split || cfg.SplitEvery > 0 || cfg.SplitSize > 0:
+			return errors.New("-o - writes one output to stdout; it cannot be used with -watch, -split-chapters, -split-every, -split-size or several inputs")
+		case cfg.QualityReport:
+			return errors.New("-quality-report is written next to the output, which -o - does not have")
+		}
+	}
+	if cfg.Watch {
+		return runWatch(ctx, cfg)
+	}
+	if cfg.MetricsPush != "" && cfg.metrics == nil {
+		cfg.metrics = newRunMetrics()
+		err := runApp(ctx, cfg)
+		cfg.metrics.push(ctx, cfg.MetricsPush, err)
+		return err
+	}
+	if cfg.Manifest != "" && cfg.manifest == nil {
+		cfg.manifest = newManifest(cfg.Input)
+		err := runApp(ctx, cfg)
+		if writeErr := cfg.manifest.write(cfg.Manifest, err); writeErr != nil {
+			slog.Error("Failed to write manifest", "manifest", cfg.Manifest, "error", writeErr)
+			if err == nil {
+				err = fmt.Errorf("could not write manifest: %w", writeErr)
+			}
+		}
+		return err
+	}
+	if cfg.EventsFile == "" || cfg.events != nil {
+		return runInput(ctx, cfg)
+	}
+	events, err := openEventLog(cfg.EventsFile)
+	if err != nil {
+		return err
+	}
+	cfg.events = events
+	restore := events.captureWarnings()
+	started := time.Now()
+	err = runInput(ctx, cfg)
+	restore()
+	done := conversionEvent{Event: eventDone, Input: cfg.Input, DurationMS: time.Since(started).Milliseconds()}
+	if err != nil {
+		done.Error = err.Error()
+	}
+	events.emit(done)
+	if closeErr := events.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runInput converts cfg.Input as runApp describes, or every one of
+// several cfg.Inputs with runBatch, or the downloads of cfg.URLs with
+// runURLs. With cfg.Checkpoint, the progress of
+// the run is kept there as it goes, and taken up again, until it succeeds.
+// With cfg.Cache, converted pages are kept there for later runs as well.
+func runInput(ctx context.Context, cfg Config) error {
+	if err := (&converter.Config{OutputFormat: cfg.Format, WebPTarget: cfg.WebPTarget, ResampleFilter: cfg.Resample, Profile: cfg.Profile, MaxWidth: cfg.MaxWidth, MaxHeight: cfg.MaxHeight, AutoLevels: autoLevels(cfg), AutoCrop: autoCrop(cfg), Adjust: adjustments(cfg), Colour: colourTuning(cfg), PrintLayout: printLayout(cfg), PageSize: pageSize(cfg), Margin: cfg.Margin, Background: cfg.Background}).Validate(); err != nil {
+		return err
+	}
+	switch cfg.OnExists {
+	case "", "overwrite", "skip", "rename", "prompt":
+	default:
+		return fmt.Errorf("invalid -on-exists %q: must be overwrite, skip, rename or prompt", cfg.OnExists)
+	}
+	if cfg.JPEGQuality < 0 || cfg.JPEGQuality > 100 {
+		return fmt.Errorf("invalid -jpeg-quality %d: must be 1 to 100, or 0 for the default", cfg.JPEGQuality)
+	}
+	if cfg.SplitEvery < 0 {
+		return fmt.Errorf("invalid -split-every %d: must not be negative", cfg.SplitEvery)
+	}
+	if cfg.Cache != "" && cfg.cache == nil {
+		cache, err := openPageCacheDir(cfg.Cache, int64(cfg.CacheSize))
+		if err != nil {
+			return err
+		}
+		cfg.cache = cache
+		err = runInput(ctx, cfg)
+		cache.prune()
+		return err
+	}
+	if cfg.Checkpoint != "" && cfg.checkpoint == nil {
+		checkpoint, err := openCheckpoint(cfg.Checkpoint)
+		if err != nil {
+			return err
+		}
+		cfg.checkpoint = checkpoint
+		err = runInput(ctx, cfg)
+		checkpoint.finish(err)
+		return err
+	}
+	if len(cfg.Inputs) > 1 {
+		return runBatch(ctx, cfg)
+	}
+	if cfg.OutputTemplate != "" && cfg.Output == "" {
+		cfg.Output = expandOutputTemplate(cfg.OutputTemplate, cfg, cfg.Input, 1, 1)
+	}
+	splitting := cfg.SplitEvery > 0 || cfg.SplitSize > 0
+	if len(cfg.URLs) > 0 {
+		if splitting {
+			return errors.New("-split-every and -split-size need a directory as input")
+		}
+		return runURLs(ctx, cfg)
+	}
+	if strings.HasPrefix(cfg.Input, tarInputPrefix) {
+		if splitting {
+			return errors.New("-split-every and -split-size need a directory as input")
+		}
+		return runTarStream(ctx, cfg)
+	}
+	if cfg.ViaServer && (cfg.QualityReport || cfg.Manifest != "") {
+		slog.Info("Converting in-process, -quality-report and -manifest need the pages")
+	} else if cfg.ViaServer && cfg.server == nil {
+		cfg.server = dialLocalServer(cfg.ServerSocket, cfg.ServerToken)
+	}
+	if isArchive(cfg.Input) {
+		if splitting {
+			return errors.New("-split-every and -split-size need a directory as input")
+		}
+		return runArchive(ctx, cfg)
+	}
+	chapters, err := scanChapters(cfg)
+	if err != nil {
+		return err
+	}
+	pages := 0
+	for _, ch := range chapters {
+		pages += len(ch.Files)
+	}
+	cfg.events.emit(conversionEvent{Event: eventScan, Input: cfg.Input, Chapters: len(chapters), Pages: pages})
+	if pages == 0 {
+		return fmt.Errorf("no supported images in %s: %w", cfg.Input, converter.ErrNoSupportedImages)
+	}
+	if cfg.MaxPages > 0 && pages > cfg.MaxPages {
+		return fmt.Errorf("%s has %d pages, more than the limit of %d; check the input path or raise -max-pages (0 disables the limit)", cfg.Input, pages, cfg.MaxPages)
+	}
+
+	if !cfg.Split {
+		output := cfg.Output
+		if output == "" {
+			output = filepath.Clean(cfg.Input) + outputExt(cfg)
+		}
+		return convertChapters(ctx, cfg, chapters, output)
+	}
+	if cfg.Output != "" {
+		if err := os.MkdirAll(cfg.Output, 0o755); err != nil {
+			return fmt.Errorf("could not create output directory: %w", err)
+		}
+	}
+	for _, ch := range chapters {
+		if err := convertChapters(ctx, cfg, []chapter{ch}, chapterOutput(cfg, ch)); err != nil {
+			return fmt.Errorf("chapter %s: %w", ch.Name, err)
+		}
+	}
+	return nil
+}
+
+// chapter is a directory of pages, in reading order.
+type chapter struct {
+	Name  string   // Path relative to the input directory ("." for the input itself)
+	Dir   string   // Path on disk
+	Files []string // Page file names relative to Dir
+}
+
+// scanChapters lists the chapters to convert: just cfg.Input, or with
+// cfg.Recursive every directory below it that holds images, in natural order
+// of their relative paths. Directories without images are left out.
+func scanChapters(cfg Config) ([]chapter, error) {
+	dirs := []string{"."}
+	if cfg.Recursive {
+		var subdirs []string
+		err := filepath.WalkDir(cfg.Input, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() || p == cfg.Input {
+				return nil
+			}
+			if junkReason(d.Name()) != "" {
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(cfg.Input, p)
+			if err != nil {
+				return err
+			}
+			subdirs = append(subdirs, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read input directory: %w", err)
+		}
+		subdirs, _ = converter.NaturalOrder{}.Order(subdirs)
+		dirs = append(dirs, subdirs...)
+	}
+
+	var chapters []chapter
+	for _, name := range dirs {
+		ch := chapter{Name: name, Dir: filepath.Join(cfg.Input, filepath.FromSlash(name))}
+		files, skipped, err := findSupportedImageFiles(ch.Dir, scanOptions{Lenient: cfg.Lenient, Sniff: cfg.Sniff, Include: cfg.Include, Exclude: cfg.Exclude})
+		if err != nil {
+			return nil, err
+		}
+		reportSkippedFiles(skipped)
+		if len(files) == 0 {
+			continue
+		}
+		resolver, err := loadOrderResolver(ch.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if ch.Files, err = resolver.Order(files); err != nil {
+			return nil, fmt.Errorf("could not order pages of %s: %w", ch.Dir, err)
+		}
+		if cfg.SaveOrder {
+			if err := saveOrderOverride(ch.Dir, ch.Files); err != nil {
+				return nil, err
+			}
+		}
+		chapters = append(chapters, ch)
+	}
+	return chapters, nil
+}
+
+// isArchive reports whether input names a CBZ or ZIP file rather than a
+// directory.
+func isArchive(input string) bool {
+	switch strings.ToLower(filepath.Ext(input)) {
+	case ".cbz", ".zip":
+		info, err := os.Stat(input)
+		return err == nil && info.Mode().IsRegular()
+	}
+	return false
+}
+
+// runArchive converts the images inside the archive cfg.Input, read in place,
+// to a PDF at cfg.Output (default: the archive name with a .pdf extension).
+func runArchive(ctx context.Context, cfg Config) error {
+	file, err := os.Open(cfg.Input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	output := cfg.Output
+	if output == "" {
+		output = strings.TrimSuffix(cfg.Input, filepath.Ext(cfg.Input)) + outputExt(cfg)
+	}
+	return convertArchive(ctx, cfg, file, output)
+}
+
+// convertArchive converts the images inside the archive file, which
+// cfg.Input names, to output.
+func convertArchive(ctx context.Context, cfg Config, file *os.File, output string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	sources, err := converter.ArchiveSources(file, info.Size(), nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cfg.Input, err)
+	}
+	if len(cfg.Include) > 0 || len(cfg.Exclude) > 0 {
+		var skipped []skippedFile
+		sources, skipped = filterSources(sources, cfg.Include, cfg.Exclude)
+		reportSkippedFiles(skipped)
+	}
+	cfg.events.emit(conversionEvent{Event: eventScan, Input: cfg.Input, Chapters: 1, Pages: len(sources)})
+	if len(sources) == 0 {
+		return fmt.Errorf("no supported images in %s: %w", cfg.Input, converter.ErrNoSupportedImages)
+	}
+	if cfg.MaxPages > 0 && len(sources) > cfg.MaxPages {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+		return fmt.Errorf("%s has %d pages, more than the limit of %d; check the input path or raise -max-pages (0 disables the limit)", cfg.Input, len(sources), cfg.MaxPages)
+	}
+	_, err = convertSources(ctx, cfg, sources, 1, output)
+	return err
+}
+
+// filterSources returns the sources the -include and -exclude patterns
+// keep, closing the others and returning them as skipped.
+func filterSources(sources []converter.ImageSource, include, exclude []string) (kept []converter.ImageSource, skipped []skippedFile) {
+	for _, src := range sources {
+		if reason := filterReason(src.OriginalFilename, include, exclude); reason != "" {
+			src.Reader.Close()
+			skipped = append(skipped, skippedFile{src.OriginalFilename, reason})
+			continue
+		}
+		kept = append(kept, src)
+	}
+	return kept, skipped
+}
+
+// outputExt returns the file extension of the -format output.
+func outputExt(cfg Config) string {
+	_, ext, _ := (&converter.Config{OutputFormat: cfg.Format}).OutputType() // Checked by runApp
+	return ext
+}
+
+// chapterOutput returns where the output of ch goes in split mode: next to the
+// chapter directory, or flattened into the cfg.Output directory.
+func chapterOutput(cfg Config, ch chapter) string {
+	if cfg.Output == "" {
+		return filepath.Clean(ch.Dir) + outputExt(cfg)
+	}
+	name := strings.ReplaceAll(ch.Name, "/", " - ")
+	if ch.Name == "." {
+		name = filepath.Base(filepath.Clean(cfg.Input))
+	}
+	return filepath.Join(cfg.Output, name+outputExt(cfg))
+}
+
+// volumePage is a page file of the chapters being converted.
+type volumePage struct {
+	Path    string // On disk
+	Name    string // Relative to the input directory
+	Chapter string // Bookmark of its chapter; empty without one
+	Size    int64  // File size, set with -split-size
+}
+
+// convertChapters converts the pages of chapters, in order, to a PDF at
+// output, or with -split-every or -split-size to numbered parts of it.
+func convertChapters(ctx context.Context, cfg Config, chapters []chapter, output string) error {
+	var pages []volumePage
+	for _, ch := range chapters {
+		bookmark := ""
+		if len(chapters) > 1 {
+			bookmark = ch.Name
+			if bookmark == "." {
+				bookmark = filepath.Base(cfg.Input)
+			}
+		}
+		for _, name := range ch.Files {
+			page := volumePage{Path: filepath.Join(ch.Dir, name), Name: path.Join(ch.Name, name), Chapter: bookmark}
+			if cfg.SplitSize > 0 {
+				info, err := os.Stat(page.Path)
+				if err != nil {
+					return err
+				}
+				page.Size = info.Size()
+			}
+			pages = append(pages, page)
+		}
+	}
+	if cfg.SplitEvery == 0 && cfg.SplitSize == 0 {
+		_, err := convertPages(ctx, cfg, pages, len(chapters), output)
+		return err
+	}
+	return convertParts(ctx, cfg, pages, output)
+}
+
+// convertParts converts pages to numbered parts of output, "name_001.pdf"
+// and so on, each with at most cfg.SplitEvery pages and, as far as can be
+// told before converting it, no larger than cfg.SplitSize. Part sizes are
+// estimated from the size of their page files, scaled by how the output of
+// the parts written so far compares with theirs; a part holds at least one
+// page however large it is.
+func convertParts(ctx context.Context, cfg Config, pages []volumePage, output string) error {
+	var pageBytes, written int64
+	for start, part := 0, 1; start < len(pages); part++ {
+		ratio := 1.0
+		if pageBytes > 0 {
+			ratio = float64(written) / float64(pageBytes)
+		}
+		end := splitEnd(cfg, pages, start, ratio)
+		partOutput := fmt.Sprintf("%s_%03d%s", strings.TrimSuffix(output, filepath.Ext(output)), part, filepath.Ext(output))
+		chapters := 0
+		for i := start; i < end; i++ {
+			if i == start || pages[i].Chapter != pages[i-1].Chapter {
+				chapters++
+			}
+		}
+		n, err := convertPages(ctx, cfg, pages[start:end], chapters, partOutput)
+		if err != nil {
+			return fmt.Errorf("part %d: %w", part, err)
+		}
+		if cfg.SplitSize > 0 && n > int64(cfg.SplitSize) {
+			slog.Warn("Part is larger than -split-size", "output", partOutput, "size", byteSize(n).String(), "pages", end-start)
+		}
+		for _, page := range pages[start:end] {
+			pageBytes += page.Size
+		}
+		written += n
+		start = end
+	}
+	return nil
+}
+
+// splitEnd returns where the part of pages that starts at start ends:
+// after cfg.SplitEvery pages, or before the page that would take the part
+// past cfg.SplitSize, with page sizes estimated as their file size times
+// ratio.
+func splitEnd(cfg Config, pages []volumePage, start int, ratio float64) int {
+	end := len(pages)
+	if cfg.SplitEvery > 0 {
+		end = min(end, start+cfg.SplitEvery)
+	}
+	if cfg.SplitSize > 0 {
+		size := 0.0
+		for i := start; i < end; i++ {
+			if size += float64(pages[i].Size) * ratio; size > float64(cfg.SplitSize) && i > start {
+				return i
+			}
+		}
+	}
+	return end
+}
+
+// convertPages converts pages to a PDF at output like convertSources.
+func convertPages(ctx context.Context, cfg Config, pages []volumePage, chapters int, output string) (int64, error) {
+	var sources []converter.ImageSource
+	closeAll := func() {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+	}
+	for _, page := range pages {
+		contentType, err := fileContentType(page.Path, cfg.Sniff)
+		if err != nil {
+			closeAll()
+			return 0, err
+		}
+		file, err := os.Open(page.Path)
+		if err != nil {
+			closeAll()
+			return 0, err
+		}
+		sources = append(sources, converter.ImageSource{
+			OriginalFilename: page.Name,
+			Reader:           file, // Closed by the converter
+			ContentType:      contentType,
+			Index:            len(sources),
+			Chapter:          page.Chapter,
+		})
+	}
+	return convertSources(ctx, cfg, sources, chapters, output)
+}
+
+// convertSources converts sources to a PDF at output, closing them, and
+// returns the size of the output. The output file is removed if the
+// conversion fails. cfg.PreCmd runs first and cfg.PostCmd last, whatever
+// the outcome; a failing pre-cmd stops the conversion.
+func convertSources(ctx context.Context, cfg Config, sources []converter.ImageSource, chapters int, output string) (int64, error) {
+	closeAll := func() {
+		for _, src := range sources {
+			src.Reader.Close()
+		}
+	}
+	var written int64
+	err := convert(ctx, cfg, output, chapters, len(sources), closeAll, func(convCfg *converter.Config, out io.Writer, stats *converter.Stats) (bool, error) {
+		var hasContent bool
+		var err error
+		file, isFile := out.(*os.File)
+		switch {
+		case cfg.server != nil:
+			hasContent, err = cfg.server.convert(ctx, sources, convCfg, out, stats)
+		case isFile && isRegularFile(file):
+			hasContent, err = converter.ConvertToPDFAt(ctx, sources, convCfg, file, stats)
+		default: // Stdout, /dev/fd/N or a FIFO cannot be written at offsets
+			hasContent, err = converter.ConvertToPDFWithStats(ctx, sources, convCfg, out, stats)
+		}
+		written = stats.OutputBytes
+		return hasContent, err
+	})
+	return written, err
+}
+
+// isRegularFile reports whether file is a regular file, which unlike a
+// pipe or a device can be written at offsets.
+func isRegularFile(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// convert sets up the conversion of cfg to output and runs it with run,
+// handling the hooks, the existing output and the removal of a failed one
+// as convertSources describes. chapters and pages are the size of the
+// input, or 0 if it is not known in advance; closeAll releases the inputs
+// if run is never called. An output of stdioName is written to stdout,
+// which is left open, and nothing is removed if the conversion fails.
+func convert(ctx context.Context, cfg Config, output string, chapters, pages int, closeAll func(), run func(convCfg *converter.Config, out io.Writer, stats *converter.Stats) (bool, error)) error {
+	convCfg := converter.NewDefaultConfig()
+	if cfg.Profile != "" {
+		if err := convCfg.ApplyProfile(cfg.Profile); err != nil {
+			closeAll()
+			return err
+		}
+	}
+	if cfg.Captions != "" {
+		captions, err := readCaptions(cfg.Captions)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		convCfg.Captions = captions
+	}
+	rules, err := inputQualityRules(cfg.Input)
+	if err != nil {
+		closeAll()
+		return err
+	}
+	convCfg.QualityRules = rules
+	if cfg.Watermark != "" {
+		watermark, err := readWatermark(cfg)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		convCfg.Watermark = watermark
+	}
+	hookOut := io.Writer(os.Stdout)
+	if output == stdioName {
+		hookOut = os.Stderr // Stdout has the document
+	}
+	if err := runHook(ctx, "pre-cmd", cfg.PreCmd, hookEnv(cfg, output, "", 0, nil), hookOut); err != nil {
+		closeAll()
+		return err
+	}
+	out, output, err := openOutput(cfg, output)
+	if errors.Is(err, errOutputExists) {
+		closeAll()
+		slog.Info("Output exists, skipping", "output", output)
+		cfg.manifest.skip(output)
+		cfg.metrics.skip()
+		return runHook(ctx, "post-cmd", cfg.PostCmd, hookEnv(cfg, output, hookSkipped, 0, nil), hookOut)
+	}
+	if err != nil {
+		closeAll()
+		return err
+	}
+
+	convCfg.NumWorkers = cfg.Workers
+	convCfg.ReadAhead = cfg.ReadAhead
+	convCfg.PageCache = combinePageCaches(cfg.checkpoint.pageCache(), cfg.cache.pageCache())
+	convCfg.Limit = cfg.limit
+	convCfg.NormalizeWidth = cfg.Normalize
+	convCfg.DedupPages = cfg.Dedupe
+	convCfg.SkipBlank = cfg.SkipBlank
+	convCfg.GIFFrames = cfg.GIFFrames
+	convCfg.WebPTarget = cfg.WebPTarget
+	convCfg.ResampleFilter = cfg.Resample
+	convCfg.PrintLayout = printLayout(cfg)
+	convCfg.PageSize = pageSize(cfg)
+	convCfg.Margin = cfg.Margin
+	convCfg.Background = cfg.Background
+	convCfg.KeepAlpha = cfg.KeepAlpha
+	convCfg.Placeholders = cfg.Placeholders
+	convCfg.TargetSize = int64(cfg.TargetSize)
+	// Page settings given along with -profile add to or override its own.
+	convCfg.MaxWidth = cmp.Or(cfg.MaxWidth, convCfg.MaxWidth)
+	convCfg.MaxHeight = cmp.Or(cfg.MaxHeight, convCfg.MaxHeight)
+	convCfg.JPEGQuality = cmp.Or(cfg.JPEGQuality, convCfg.JPEGQuality)
+	convCfg.Grayscale = convCfg.Grayscale || cfg.Grayscale
+	if levels := autoLevels(cfg); levels != nil {
+		convCfg.AutoLevels = levels
+	}
+	convCfg.AutoCrop = autoCrop(cfg)
+	convCfg.Adjust = adjustments(cfg)
+	if colour := colourTuning(cfg); colour != nil {
+		if convCfg.Colour != nil {
+			colour.Saturation = cmp.Or(colour.Saturation, convCfg.Colour.Saturation)
+			colour.Levels = cmp.Or(colour.Levels, convCfg.Colour.Levels)
+		}
+		convCfg.Colour = colour
+	}
+	convCfg.MaxTotalMegapixels = cfg.MaxTotalMegapixels
+	convCfg.DecodeLimits = cfg.DecodeLimits
+	convCfg.OutputFormat = cfg.Format
+	convCfg.RightToLeft = cfg.RTL
+	convCfg.Tagged = cfg.Tagged
+	convCfg.Provenance = cfg.Provenance
+	convCfg.PageBookmarks = cfg.PageBookmarks
+	convCfg.Language = cfg.Lang
+	convCfg.Title = cfg.Title
+	convCfg.Author = cfg.Author
+	convCfg.Subject = cfg.Subject
+	convCfg.Keywords = cfg.Keywords
+	switch {
+	case output != stdioName:
+		convCfg.OutputFilename = filepath.Base(output)
+	case cfg.Input != tarInputPrefix+stdioName:
+		convCfg.OutputFilename = inputName(cfg.Input) + outputExt(cfg)
+	}
+	if cfg.events != nil {
+		convCfg.Progress = cfg.events.progress
+	}
+	counts := []any{"input", cfg.Input}
+	if pages > 0 {
+		counts = append(counts, "chapters", chapters, "pages", pages)
+	}
+	slog.Info("Converting", append(counts, "output", output)...)
+	var bar *progressBar
+	if output != stdioName { // Stdout has the document
+		bar = newProgressBar(cfg, filepath.Base(output), pages)
+	}
+	if bar != nil {
+		convCfg.Progress = bar.progress(convCfg.Progress)
+	}
+	var report *qualityReport
+	if cfg.QualityReport {
+		report = newQualityReport()
+		convCfg.Inspect = report.add
+	}
+	var inspected *manifestPages
+	if cfg.manifest != nil {
+		inspected = newManifestPages()
+		convCfg.Inspect = inspected.inspect(convCfg.Inspect)
+	}
+	var stats converter.Stats
+	hasContent, err := run(convCfg, out, &stats)
+	bar.finish()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !hasContent {
+		err = converter.ErrNoSupportedImages
+	}
+	if err != nil {
+		if output != stdioName {
+			os.Remove(output)
+		}
+		cfg.manifest.add(output, inspected, stats, err)
+		cfg.metrics.add(stats, err)
+		if hookErr := runHook(ctx, "post-cmd", cfg.PostCmd, hookEnv(cfg, output, hookFailed, 0, err), hookOut); hookErr != nil {
+			slog.Error("Hook failed", "error", hookErr)
+		}
+		return err
+	}
+	slog.Info("Wrote output", "output", output)
+	cfg.manifest.add(output, inspected, stats, nil)
+	cfg.metrics.add(stats, nil)
+	if report != nil {
+		if err := report.write(qualityReportPath(output), output, stats); err != nil {
+			slog.Warn("Failed to write quality report", "output", output, "error", err)
+		} else {
+			slog.Info("Wrote quality report", "report", qualityReportPath(output))
+		}
+	}
+	cfg.events.emit(conversionEvent{Event: eventWrite, Input: cfg.Input, Output: output, Pages: stats.PagesAdded, Bytes: stats.OutputBytes, DurationMS: (stats.ProcessTime + stats.PDFTime).Milliseconds()})
+	logConversionSummary(stats)
+	return runHook(ctx, "post-cmd", cfg.PostCmd, hookEnv(cfg, output, hookSuccess, stats.PagesAdded, nil), hookOut)
+}
+
+// stdioName is the -i and -o value for stdin and stdout.
+const stdioName = "-"
+
+// stdoutOutput is where an output of stdioName is written.
+var stdoutOutput io.Writer = os.Stdout
+
+// openOutput opens output for writing: stdout for stdioName, or the file
+// createOutput creates.
+func openOutput(cfg Config, output string) (io.WriteCloser, string, error) {
+	if output == stdioName {
+		return nopWriteCloser{stdoutOutput}, output, nil
+	}
+	return createOutput(cfg, output)
+}
+
+// nopWriteCloser is a Writer whose Close does nothing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// errOutputExists is returned by createOutput when an existing output is
+// to be left alone.
+var errOutputExists = errors.New("output file exists")
+
+// promptInput and promptOutput are where -on-exists prompt asks whether to
+// overwrite.
+var (
+	promptInput            = bufio.NewReader(os.Stdin)
+	promptOutput io.Writer = os.Stderr
+)
+
+// createOutput creates the output file, handling an existing file as
+// cfg.OnExists says: overwrite truncates it, skip returns errOutputExists,
+// prompt asks first, and rename (the default) writes to the first free
+// "name (N).ext" instead. It returns the path actually created.
+func createOutput(cfg Config, output string) (*os.File, string, error) {
+	mode := cmp.Or(cfg.OnExists, "rename")
+	if mode == "overwrite" {
+		file, err := os.Create(output)
+		return file, output, err
+	}
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if !errors.Is(err, fs.ErrExist) {
+		return file, output, err
+	}
+	switch mode {
+	case "skip":
+		return nil, output, errOutputExists
+	case "prompt":
+		fmt.Fprintf(promptOutput, "%s exists. Overwrite? [y/N] ", output)
+		answer, _ := promptInput.ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			return nil, output, errOutputExists
+		}
+		file, err := os.Create(output)
+		return file, output, err
+	}
+	base, ext := strings.TrimSuffix(output, filepath.Ext(output)), filepath.Ext(output)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, n, ext)
+		file, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+		if !errors.Is(err, fs.ErrExist) {
+			if err == nil {
+				slog.Info("Output exists, writing to a new file", "exists", output, "output", candidate)
+			}
+			return file, candidate, err
+		}
+	}
+}
+
+// readCaptions reads a captions file: a JSON object mapping page filenames
+// or page numbers to caption text.
+func readCaptions(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read captions: %w", err)
+	}
+	var captions map[string]string
+	if err := json.Unmarshal(data, &captions); err != nil {
+		return nil, fmt.Errorf("invalid captions file %s: %w", path, err)
+	}
+	return captions, nil
+}
+
+// autoLevels returns the auto levels set by the -auto-levels flags, or nil
+// if they are off.
+func autoLevels(cfg Config) *converter.AutoLevels {
+	if !cfg.AutoLevels {
+		return nil
+	}
+	return &converter.AutoLevels{BlackClip: cfg.AutoLevelsBlackClip, WhiteClip: cfg.AutoLevelsWhiteClip}
+}
+
+// autoCrop returns the auto crop set by the -auto-crop flags, or nil if it
+// is off.
+func autoCrop(cfg Config) *converter.AutoCrop {
+	if !cfg.AutoCrop {
+		return nil
+	}
+	return &converter.AutoCrop{Tolerance: cfg.AutoCropTolerance}
+}
+
+// adjustments returns the image adjustments set by -brightness, -contrast,
+// -gamma and -sharpen, or nil if there are none.
+func adjustments(cfg Config) *converter.Adjustments {
+	adjust := &converter.Adjustments{Brightness: cfg.Brightness, Contrast: cfg.Contrast, Gamma: cfg.Gamma, Sharpen: cfg.Sharpen}
+	if *adjust == (converter.Adjustments{}) {
+		return nil
+	}
+	return adjust
+}
+
+// colourTuning returns the colour tuning set by -saturation and
+// -colour-levels, or nil if there is none.
+func colourTuning(cfg Config) *converter.ColourTuning {
+	if cfg.Saturation == 0 && cfg.ColourLevels == 0 {
+		return nil
+	}
+	return &converter.ColourTuning{Saturation: cfg.Saturation, Levels: cfg.ColourLevels}
+}
+
+// profileNames lists the device profiles for flag help.
+func profileNames() string {
+	var names []string
+	for _, p := range converter.Profiles() {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// printLayout returns the print layout set by -pages-per-sheet and -paper,
+// or nil for one page per PDF page.
+func printLayout(cfg Config) *converter.PrintLayout {
+	if cfg.PagesPerSheet == 0 {
+		return nil
+	}
+	return &converter.PrintLayout{PagesPerSheet: cfg.PagesPerSheet, Paper: cfg.Paper}
+}
+
+// pageSize returns the page size set by -page-size and -fit, or nil for
+// pages the size of their images. A size that is neither WxH points nor a
+// paper is passed on as a paper for Validate to reject.
+func pageSize(cfg Config) *converter.PageSize {
+	if cfg.PageSize == "" {
+		return nil
+	}
+	size := &converter.PageSize{Paper: strings.ToLower(cfg.PageSize), Fit: cfg.Fit}
+	if w, h, ok := strings.Cut(size.Paper, "x"); ok {
+		width, errW := strconv.ParseFloat(w, 64)
+		height, errH := strconv.ParseFloat(h, 64)
+		if errW == nil && errH == nil {
+			size.Paper, size.Width, size.Height = "", width, height
+		}
+	}
+	return size
+}
+
+// readWatermark builds the watermark from the -watermark flags. A value that
+// names an existing file is read as the watermark image; anything else is
+// the watermark text.
+func readWatermark(cfg Config) (*converter.Watermark, error) {
+	wm := &converter.Watermark{
+		Text:          cfg.Watermark,
+		Position:      cfg.WatermarkPosition,
+		Opacity:       cfg.WatermarkOpacity,
+		FirstPageOnly: cfg.WatermarkFirstPage,
+	}
+	if info, err := os.Stat(cfg.Watermark); err == nil && !info.IsDir() {
+		if wm.Image, err = os.ReadFile(cfg.Watermark); err != nil {
+			return nil, fmt.Errorf("could not read watermark image: %w", err)
+		}
+		wm.Text = ""
+	}
+	return wm, nil
+}
+
+// logConversionSummary logs the page counts and working-set figures of a
+// finished conversion, for tuning workers and spotting memory-hungry inputs.
+func logConversionSummary(stats converter.Stats) {
+	slog.Info("Conversion summary",
+		"pages", stats.PagesAdded,
+		"failed", stats.PagesFailed,
+		"placeholders", stats.PagesPlaceholder,
+		"duplicates", stats.PagesDuplicate,
+		"blank", stats.PagesBlank,
+		"passed_through", stats.PagesPassedThrough,
+		"from_cache", stats.PagesCached,
+		"pdf_bytes", stats.OutputBytes,
+		"peak_rss", byteSize(peakRSS()).String(),
+		"bytes_decoded", byteSize(stats.BytesDecoded).String(),
+		"bytes_encoded", byteSize(stats.BytesEncoded).String(),
+		"buffer_pool_hit_rate", fmt.Sprintf("%.0f%%", stats.BufferHitRate()*100),
+		"duration", stats.ProcessTime+stats.PDFTime,
+	)
+}
+
+// scanOptions controls which files findSupportedImageFiles picks up.
+type scanOptions struct {
+	Lenient bool // Skip thumbnails, OS metadata and empty files instead of converting them
+	Sniff   bool // Detect the format of files without an extension from their content
+
+	Include []string // Name patterns of the files to pick up; none picks up every image
+	Exclude []string // Name patterns of the files to leave out
+}
+
+// skippedFile is a file the lenient scan left out, with the reason.
+type skippedFile struct {
+	Name   string
+	Reason string
+}
+
+// findSupportedImageFiles lists the image files directly inside dir whose
+// extension the converter recognises. Names are relative to dir. In lenient
+// mode junk that would otherwise be embedded or fail the conversion is left
+// out and returned as skipped. Images left out by opts.Include or
+// opts.Exclude are returned as skipped in either mode.
+func findSupportedImageFiles(dir string, opts scanOptions) (files []string, skipped []skippedFile, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read input directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == orderOverrideFile {
+			continue
+		}
+		if !opts.Lenient {
+			if entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			contentType, err := fileContentType(filepath.Join(dir, name), opts.Sniff)
+			if err != nil {
+				return nil, nil, err
+			}
+			if contentType == "" {
+				continue
+			}
+			if reason := filterReason(name, opts.Include, opts.Exclude); reason != "" {
+				skipped = append(skipped, skippedFile{name, reason})
+				continue
+			}
+			files = append(files, name)
+			continue
+		}
+
+		reason := junkReason(name)
+		if entry.IsDir() {
+			if reason != "" {
+				skipped = append(skipped, skippedFile{name + "/", reason})
+			}
+			continue
+		}
+		if reason == "" {
+			contentType, err := fileContentType(filepath.Join(dir, name), opts.Sniff)
+			if err != nil {
+				return nil, nil, err
+			}
+			if contentType == "" {
+				reason = "not a supported image"
+			}
+		}
+		if reason == "" {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, nil, err
+			}
+			if info.Size() == 0 {
+				reason = "empty file"
+			}
+		}
+		if reason == "" {
+			reason = filterReason(name, opts.Include, opts.Exclude)
+		}
+		if reason != "" {
+			skipped = append(skipped, skippedFile{name, reason})
+			continue
+		}
+		files = append(files, name)
+	}
+	return files, skipped, nil
+}
+
+// fileContentType returns the image content type of the file at filePath
+// from its extension. With sniff, a file without an extension is identified
+// by its magic bytes instead. It returns "" for unsupported files.
+func fileContentType(filePath string, sniff bool) (string, error) {
+	if contentType := converter.GetContentTypeFromFilename(filePath); contentType != "" || !sniff || filepath.Ext(filePath) != "" {
+		return contentType, nil
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return converter.SniffContentType(file)
+}
+
+// filterReason returns why the -include and -exclude patterns leave out the
+// file at name, or "" if they keep it. Patterns are matched against the
+// base name, ignoring case.
+func filterReason(name string, include, exclude []string) string {
+	base := strings.ToLower(path.Base(filepath.ToSlash(name)))
+	matches := func(pattern string) bool {
+		ok, _ := path.Match(strings.ToLower(pattern), base) // Checked when the flag was set
+		return ok
+	}
+	for _, pattern := range exclude {
+		if matches(pattern) {
+			return "excluded by -exclude " + pattern
+		}
+	}
+	if len(include) > 0 && !slices.ContainsFunc(include, matches) {
+		return "not matched by -include"
+	}
+	return ""
+}
+
+// junkReason returns why name is operating-system or viewer clutter rather
+// than a page, or "" if it is not recognised as junk.
+func junkReason(name string) string {
+	lower := strings.ToLower(name)
+	base := strings.TrimSuffix(lower, path.Ext(lower))
+	switch {
+	case lower == "thumbs.db" || lower == "desktop.ini" || lower == ".ds_store":
+		return "operating system metadata"
+	case lower == "__macosx":
+		return "macOS archive metadata"
+	case strings.HasPrefix(lower, "._"):
+		return "macOS resource fork"
+	case strings.HasPrefix(lower, "."):
+		return "hidden file"
+	case strings.HasSuffix(base, "_thumb") || strings.HasSuffix(base, ".thumb"):
+		return "thumbnail"
+	}
+	return ""
+}
+
+// reportSkippedFiles logs what a lenient scan left out.
+func reportSkippedFiles(skipped []skippedFile) {
+	if len(skipped) == 0 {
+		return
+	}
+	for _, file := range skipped {
+		slog.Info("Skipped file", "file", file.Name, "reason", file.Reason)
+	}
+	slog.Info("Skipped files that are not pages", "count", len(skipped))
+}
+
+// readOrderOverride reads the override file of dir, reporting whether
+// there is one.
+func readOrderOverride(dir string) (converter.OrderOverride, bool, error) {
+	var override converter.OrderOverride
+	overridePath := filepath.Join(dir, orderOverrideFile)
+	data, err := os.ReadFile(overridePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return override, false, nil
+	}
+	if err != nil {
+		return override, false, fmt.Errorf("could not read %s: %w", overridePath, err)
+	}
+	if err := json.Unmarshal(data, &override); err != nil {
+		return override, false, fmt.Errorf("could not parse %s: %w", overridePath, err)
+	}
+	return override, true, nil
+}
+
+// loadOrderResolver returns the page order for dir: the override file if
+// present, the natural filename order otherwise.
+func loadOrderResolver(dir string) (converter.OrderResolver, error) {
+	override, ok, err := readOrderOverride(dir)
+	if err != nil || !ok {
+		return converter.NaturalOrder{}, err
+	}
+	overridePath := filepath.Join(dir, orderOverrideFile)
+	resolver, err := override.Resolver()
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", overridePath, err)
+	}
+	if override.Order != nil || override.Pattern != "" || override.Reverse {
+		slog.Info("Using page order override", "file", overridePath)
+	}
+	return resolver, nil
+}
+
+// inputQualityRules returns the quality rules in the override file of the
+// input directory, none if input is no directory or has no such file.
+func inputQualityRules(input string) ([]converter.QualityRule, error) {
+	if info, err := os.Stat(input); err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	override, _, err := readOrderOverride(input)
+	if len(override.QualityRules) > 0 {
+		slog.Info("Using quality rules", "file", filepath.Join(input, orderOverrideFile), "rules", len(override.QualityRules))
+	}
+	return override.QualityRules, err
+}
+
+// saveOrderOverride writes files as an explicit order to dir, where it can
+// be edited by hand and is picked up by later conversions. The quality
+// rules of an existing override file are kept.
+func saveOrderOverride(dir string, files []string) error {
+	override, _, err := readOrderOverride(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(converter.OrderOverride{Order: files, QualityRules: override.QualityRules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	overridePath := filepath.Join(dir, orderOverrideFile)
+	if err := os.WriteFile(overridePath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("could not save page order: %w", err)
+	}
+	slog.Info("Saved page order", "file", overridePath)
+	return nil
+}
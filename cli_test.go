@@ -0,0 +1,696 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func writePNG(t *testing.T, path string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatalf("Failed to encode %s: %v", path, err)
+	}
+}
+
+func TestRunApp_QualityRules(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "p1.png"))
+	manifest := filepath.Join(dir, orderOverrideFile)
+	if err := os.WriteFile(manifest, []byte(`{"quality_rules": [{"pages": "1", "jpeg_quality": 0}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "out.pdf")
+	if err := runApp(context.Background(), cfg); !errors.Is(err, converter.ErrInvalidQualityRule) {
+		t.Errorf("Expected the quality rules of the manifest to be checked, got %v", err)
+	}
+
+	rules := []converter.QualityRule{{Pages: "1", JPEGQuality: 95}}
+	data, _ := json.Marshal(converter.OrderOverride{Pattern: `p(\d+)`, QualityRules: rules})
+	if err := os.WriteFile(manifest, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.SaveOrder = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with quality rules failed: %v", err)
+	}
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved converter.OrderOverride
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.QualityRules, rules) || saved.Pattern != "" || len(saved.Order) != 1 {
+		t.Errorf("Expected -save-order to keep the quality rules, got %+v", saved)
+	}
+}
+
+func TestRunApp_OrderOverride(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"p10.png", "p2.png", "p1.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, orderOverrideFile), []byte(`{"reverse": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.SaveOrder = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	if info, err := os.Stat(dir + ".pdf"); err != nil || info.Size() == 0 {
+		t.Fatalf("Expected PDF next to the input directory: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, orderOverrideFile))
+	if err != nil {
+		t.Fatalf("Expected saved order: %v", err)
+	}
+	var saved converter.OrderOverride
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Could not parse saved order: %v", err)
+	}
+	expected := []string{"p10.png", "p2.png", "p1.png"}
+	if !reflect.DeepEqual(saved.Order, expected) || saved.Reverse {
+		t.Errorf("Expected saved explicit order %v, got %+v", expected, saved)
+	}
+}
+
+func TestRunApp_NoImages(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Input = t.TempDir()
+	cfg.Output = filepath.Join(t.TempDir(), "out.pdf")
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Error("Expected error for a directory without images")
+	}
+	if _, err := os.Stat(cfg.Output); !os.IsNotExist(err) {
+		t.Error("Expected no output file to be created")
+	}
+}
+
+func TestFindSupportedImageFiles_Lenient(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "001.png"))
+	writePNG(t, filepath.Join(dir, "001_thumb.png"))
+	for _, name := range []string{"Thumbs.db", ".DS_Store", "._001.png", "notes.txt", orderOverrideFile} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("junk"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "002.png"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "__MACOSX"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, skipped, err := findSupportedImageFiles(dir, scanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{"001.png", "001_thumb.png", "002.png"}) || len(skipped) != 0 {
+		t.Errorf("Strict scan: unexpected files %v, skipped %v", files, skipped)
+	}
+
+	files, skipped, err = findSupportedImageFiles(dir, scanOptions{Lenient: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{"001.png"}) {
+		t.Errorf("Lenient scan: expected only the real page, got %v", files)
+	}
+	reasons := map[string]string{}
+	for _, file := range skipped {
+		reasons[file.Name] = file.Reason
+	}
+	expected := map[string]string{
+		"001_thumb.png": "thumbnail",
+		"002.png":       "empty file",
+		"Thumbs.db":     "operating system metadata",
+		".DS_Store":     "operating system metadata",
+		"._001.png":     "macOS resource fork",
+		"notes.txt":     "not a supported image",
+		"__MACOSX/":     "macOS archive metadata",
+	}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("Lenient scan: expected skipped %v, got %v", expected, reasons)
+	}
+}
+
+func TestFindSupportedImageFiles_IncludeExclude(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"001.png", "002.PNG", "003.jpg", "z_Credits.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("junk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lenient := range []bool{false, true} {
+		files, skipped, err := findSupportedImageFiles(dir, scanOptions{Lenient: lenient, Include: []string{"*.png"}, Exclude: []string{"*credit*"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(files, []string{"001.png", "002.PNG"}) {
+			t.Errorf("Lenient %v: expected the PNG pages without credits, got %v", lenient, files)
+		}
+		reasons := map[string]string{}
+		for _, file := range skipped {
+			reasons[file.Name] = file.Reason
+		}
+		if reasons["003.jpg"] != "not matched by -include" || reasons["z_Credits.png"] != "excluded by -exclude *credit*" {
+			t.Errorf("Lenient %v: unexpected skipped files %v", lenient, reasons)
+		}
+	}
+
+	if _, _, err := loadConfig([]string{"-i", dir, "-exclude", "[credits"}, envMap(nil), &bytes.Buffer{}); err == nil {
+		t.Error("Expected a malformed pattern to be rejected")
+	}
+}
+
+func TestFindSupportedImageFiles_Sniff(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "010"))
+	writePNG(t, filepath.Join(dir, "002"))
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("scanlation credits"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _, err := findSupportedImageFiles(dir, scanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected extension-less files to be ignored without sniffing, got %v", files)
+	}
+
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Sniff = true
+	cfg.SaveOrder = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with sniffing failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, orderOverrideFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved converter.OrderOverride
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Order, []string{"002", "010"}) {
+		t.Errorf("Expected sniffed pages in order, got %v", saved.Order)
+	}
+}
+
+func TestRunApp_MaxPages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.png", "2.png", "3.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "out.pdf")
+	cfg.MaxPages = 2
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Fatal("Expected the page limit to stop the conversion")
+	}
+	if _, err := os.Stat(cfg.Output); !os.IsNotExist(err) {
+		t.Error("Expected no output file when over the page limit")
+	}
+
+	cfg.MaxPages = 0
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Errorf("Expected -max-pages 0 to disable the limit, got %v", err)
+	}
+}
+
+func TestRunApp_Recursive(t *testing.T) {
+	dir := t.TempDir()
+	for _, page := range []string{"Chapter 10/1.png", "Chapter 2/1.png", "Chapter 2/2.png", "Chapter 2/Extras/1.png", "__MACOSX/1.png"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(page)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writePNG(t, filepath.Join(dir, page))
+	}
+	if err := os.Mkdir(filepath.Join(dir, "Empty"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Recursive = true
+	chapters, err := scanChapters(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ch := range chapters {
+		names = append(names, ch.Name)
+	}
+	if expected := []string{"Chapter 2", "Chapter 2/Extras", "Chapter 10"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected chapters %v, got %v", expected, names)
+	}
+
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	if data, err := os.ReadFile(dir + ".pdf"); err != nil {
+		t.Errorf("Expected a single PDF for the series: %v", err)
+	} else if n := bytes.Count(data, []byte("/Dest [")); n != 3 {
+		t.Errorf("Expected one bookmark per chapter, got %d", n)
+	}
+
+	cfg.Split = true
+	cfg.Output = filepath.Join(t.TempDir(), "chapters")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -split-chapters failed: %v", err)
+	}
+	for _, name := range []string{"Chapter 2.pdf", "Chapter 2 - Extras.pdf", "Chapter 10.pdf"} {
+		if _, err := os.Stat(filepath.Join(cfg.Output, name)); err != nil {
+			t.Errorf("Expected chapter PDF %s: %v", name, err)
+		}
+	}
+}
+
+func TestRunApp_SplitEvery(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "Volume")
+	if err := os.Mkdir(input, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		writePNG(t, filepath.Join(input, fmt.Sprintf("%03d.png", i)))
+	}
+	cfg := defaultConfig()
+	cfg.Input = input
+	cfg.SplitEvery = 2
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -split-every failed: %v", err)
+	}
+	for i, want := range []int{2, 2, 1} {
+		data, err := os.ReadFile(fmt.Sprintf("%s_%03d.pdf", input, i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc, err := converter.ReadPDF(data); err != nil || doc.NumPages() != want {
+			t.Errorf("Part %d: expected %d pages, got %v", i+1, want, err)
+		}
+	}
+	if _, err := os.Stat(input + ".pdf"); !os.IsNotExist(err) {
+		t.Errorf("Expected no unsplit output, got %v", err)
+	}
+}
+
+func TestSplitEnd(t *testing.T) {
+	pages := []volumePage{{Size: 100}, {Size: 100}, {Size: 300}, {Size: 50}}
+	for _, tc := range []struct {
+		every, size int
+		start       int
+		ratio       float64
+		want        int
+	}{
+		{every: 3, start: 0, ratio: 1, want: 3},
+		{every: 3, start: 2, ratio: 1, want: 4},
+		{size: 250, start: 0, ratio: 1, want: 2},
+		{size: 250, start: 0, ratio: 2, want: 1},
+		{size: 250, start: 2, ratio: 1, want: 3}, // A page larger than the limit is a part of its own
+		{every: 1, size: 1000, start: 1, ratio: 1, want: 2},
+	} {
+		cfg := Config{SplitEvery: tc.every, SplitSize: byteSize(tc.size)}
+		if got := splitEnd(cfg, pages, tc.start, tc.ratio); got != tc.want {
+			t.Errorf("%+v: got %d", tc, got)
+		}
+	}
+}
+
+func TestRunApp_Archive(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "volume01.cbz")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	for _, name := range []string{"002.png", "001.png", "__MACOSX/._001.png"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(w, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	cfg := defaultConfig()
+	cfg.Input = input
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp on an archive failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "volume01.pdf")); err != nil || info.Size() == 0 {
+		t.Errorf("Expected volume01.pdf next to the archive: %v", err)
+	}
+}
+
+func TestRunApp_TarStream(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "volume01.tar")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(file)
+	tw.WriteHeader(&tar.Header{Name: "ch1/", Typeflag: tar.TypeDir, Mode: 0o755})
+	for _, name := range []string{"ch1/002.png", "ch1/001.png", "ch1/._001.png", "ch1/notes.txt", "ch1/003"} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+			t.Fatal(err)
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(buf.Len())})
+		tw.Write(buf.Bytes())
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	cfg := defaultConfig()
+	cfg.Input = "tar:" + input
+	cfg.Sniff = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp on a tar stream failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "volume01.pdf"))
+	if err != nil {
+		t.Fatalf("Expected volume01.pdf next to the tar file: %v", err)
+	}
+	doc, err := converter.ReadPDF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The two PNGs and the extension-less page, but not the resource fork
+	// or the notes.
+	if n := doc.NumPages(); n != 3 {
+		t.Errorf("Expected 3 pages, got %d", n)
+	}
+
+	cfg.Input, cfg.Output = "tar:"+input, filepath.Join(dir, "capped.pdf")
+	cfg.MaxPages = 2
+	if err := runApp(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "more than 2 pages") {
+		t.Errorf("Expected -max-pages to stop the stream, got %v", err)
+	}
+	if _, err := os.Stat(cfg.Output); !os.IsNotExist(err) {
+		t.Errorf("Expected the failed output to be removed, got %v", err)
+	}
+
+	cfg.Input, cfg.Output = "tar:-", ""
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Error("Expected -o to be required with tar:-")
+	}
+}
+
+func TestRunApp_Stdio(t *testing.T) {
+	var pages bytes.Buffer
+	tw := tar.NewWriter(&pages)
+	for _, name := range []string{"001.png", "002.png"} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+			t.Fatal(err)
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(buf.Len())})
+		tw.Write(buf.Bytes())
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.Write(pages.Bytes())
+		w.Close()
+	}()
+	var stdout bytes.Buffer
+	defer func(previousIn *os.File, previousOut io.Writer) { os.Stdin, stdoutOutput = previousIn, previousOut }(os.Stdin, stdoutOutput)
+	os.Stdin, stdoutOutput = stdin, &stdout
+
+	cfg, _, err := loadConfig([]string{"-i", "-", "-o", "-"}, envMap(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Converting stdin to stdout failed: %v", err)
+	}
+	doc, err := converter.ReadPDF(stdout.Bytes())
+	if err != nil || doc.NumPages() != 2 {
+		t.Fatalf("Expected a 2-page PDF on stdout, got %v", err)
+	}
+	if _, err := os.Stat("-"); !os.IsNotExist(err) {
+		t.Errorf("Expected no file named -, got %v", err)
+	}
+
+	// A failed conversion writes nothing to stdout.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.png"), []byte("not a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	cfg = defaultConfig()
+	cfg.Input, cfg.Output = dir, "-"
+	if err := runApp(context.Background(), cfg); err == nil || stdout.Len() != 0 {
+		t.Errorf("Expected the conversion to fail without output, got %v and %d bytes", err, stdout.Len())
+	}
+	cfg.SplitEvery = 1
+	if err := runApp(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "-o -") {
+		t.Errorf("Expected -split-every to be refused with -o -, got %v", err)
+	}
+}
+
+func TestRunApp_PipeOutput(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Needs /dev/fd")
+	}
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		received <- data
+	}()
+
+	// -o /dev/fd/N opens the pipe again, which cannot be written at offsets.
+	cfg := defaultConfig()
+	cfg.Input, cfg.Output, cfg.OnExists = dir, fmt.Sprintf("/dev/fd/%d", w.Fd()), "overwrite"
+	err = runApp(context.Background(), cfg)
+	w.Close()
+	data := <-received
+	if err != nil {
+		t.Fatalf("Converting to a pipe failed: %v", err)
+	}
+	if doc, err := converter.ReadPDF(data); err != nil || doc.NumPages() != 1 {
+		t.Errorf("Expected a 1-page PDF through the pipe, got %v", err)
+	}
+}
+
+func TestRunApp_OnExists(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	out := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(out, "vol.pdf")
+	if err := os.WriteFile(cfg.Output, []byte("previous"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(onExists string) {
+		t.Helper()
+		cfg.OnExists = onExists
+		if err := runApp(context.Background(), cfg); err != nil {
+			t.Fatalf("runApp with -on-exists %q failed: %v", onExists, err)
+		}
+	}
+	previous := func() bool {
+		data, _ := os.ReadFile(cfg.Output)
+		return string(data) == "previous"
+	}
+
+	run("") // Renames by default
+	run("rename")
+	for _, name := range []string{"vol (1).pdf", "vol (2).pdf"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Errorf("Expected the new output as %s: %v", name, err)
+		}
+	}
+	run("skip")
+	originalInput, originalOutput := promptInput, promptOutput
+	defer func() { promptInput, promptOutput = originalInput, originalOutput }()
+	promptInput, promptOutput = bufio.NewReader(strings.NewReader("n\n")), io.Discard
+	run("prompt")
+	if !previous() {
+		t.Fatal("Expected the existing output to be kept")
+	}
+	promptInput = bufio.NewReader(strings.NewReader("y\n"))
+	run("prompt")
+	if previous() {
+		t.Error("Expected a confirmed prompt to overwrite the output")
+	}
+	if entries, _ := os.ReadDir(out); len(entries) != 3 {
+		t.Errorf("Expected 3 outputs, got %d", len(entries))
+	}
+
+	cfg.OnExists = "clobber"
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Error("Expected an invalid -on-exists to be rejected")
+	}
+}
+
+func TestRunApp_Profile(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.Profile = "kobo-clara"
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -profile failed: %v", err)
+	}
+	cfg.Profile = "etch-a-sketch"
+	if err := runApp(context.Background(), cfg); !errors.Is(err, converter.ErrUnknownProfile) {
+		t.Errorf("Expected an unknown -profile to be rejected, got %v", err)
+	}
+}
+
+func TestRunApp_DedupeAndSkipBlank(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	writePNG(t, filepath.Join(dir, "2.png"))
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.Dedupe = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -dedupe failed: %v", err)
+	}
+	data, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := converter.ReadPDF(data); err != nil || doc.NumPages() != 1 {
+		t.Errorf("Expected the identical second page dropped, got %v", err)
+	}
+
+	// writePNG's pages are blank, so nothing is left to convert.
+	cfg.OnExists = "overwrite"
+	cfg.SkipBlank = true
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Error("Expected -skip-blank to leave no pages")
+	}
+}
+
+func TestRunApp_PageSize(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.PageSize = "432x648"
+	cfg.Fit = "cover"
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with a custom -page-size failed: %v", err)
+	}
+	data, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("/MediaBox [0 0 432.00 648.00]")) {
+		t.Error("Expected a 432x648 point page")
+	}
+	cfg.PageSize = "Letter"
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -page-size Letter failed: %v", err)
+	}
+	cfg.PageSize = "432x"
+	if err := runApp(context.Background(), cfg); !errors.Is(err, converter.ErrInvalidPageSize) {
+		t.Errorf("Expected an invalid -page-size to be rejected, got %v", err)
+	}
+	cfg.PageSize, cfg.Margin, cfg.Background = "", 18, "#000000"
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -margin and -background failed: %v", err)
+	}
+	cfg.Background = "black"
+	if err := runApp(context.Background(), cfg); !errors.Is(err, converter.ErrInvalidBackground) {
+		t.Errorf("Expected an invalid -background to be rejected, got %v", err)
+	}
+}
+
+func TestRunApp_Hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The hooks below are POSIX shell commands")
+	}
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	log := filepath.Join(t.TempDir(), "hooks.log")
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.PreCmd = `echo "pre $MANGA_TO_PDF_OUTPUT" >> ` + log
+	cfg.PostCmd = `echo "post $MANGA_TO_PDF_STATUS $MANGA_TO_PDF_PAGES $MANGA_TO_PDF_OUTPUT" >> ` + log
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	cfg.OnExists = "skip"
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	data, _ := os.ReadFile(log)
+	expected := "pre " + cfg.Output + "\npost success 1 " + cfg.Output + "\npre " + cfg.Output + "\npost skipped 0 " + cfg.Output + "\n"
+	if string(data) != expected {
+		t.Errorf("Expected hook log:\n%s\ngot:\n%s", expected, data)
+	}
+
+	cfg.OnExists = "overwrite"
+	cfg.PreCmd = "exit 3"
+	if err := runApp(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "pre-cmd") {
+		t.Errorf("Expected a failing -pre-cmd to stop the conversion, got %v", err)
+	}
+}
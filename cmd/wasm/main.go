@@ -0,0 +1,131 @@
+//go:build js && wasm
+
+// Command wasm exposes the converter core to JavaScript so conversions can run
+// entirely inside the browser. Images never leave the user's machine.
+//
+// Build with:
+//
+//	GOOS=js GOARCH=wasm go build -o manga_to_pdf.wasm ./cmd/wasm
+//
+// After loading the module (together with Go's wasm_exec.js), the global
+// mangaToPDF object provides:
+//
+//	mangaToPDF.convert(images, config) -> Promise<Uint8Array>
+//
+// where images is an array of {name, type, data} objects (data being a
+// Uint8Array) and config is an optional object using the same keys as the
+// API's 'config' field (e.g. {jpeg_quality: 85}).
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"syscall/js"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func main() {
+	api := js.Global().Get("Object").New()
+	api.Set("convert", js.FuncOf(convert))
+	js.Global().Set("mangaToPDF", api)
+
+	// Keep the Go runtime alive so the exported functions stay callable.
+	select {}
+}
+
+// convert is the JS entry point. It returns a Promise so the (potentially
+// long) conversion does not block the caller's event loop turn.
+func convert(this js.Value, args []js.Value) interface{} {
+	var images, jsConfig js.Value
+	if len(args) > 0 {
+		images = args[0]
+	}
+	if len(args) > 1 {
+		jsConfig = args[1]
+	}
+
+	handler := js.FuncOf(func(this js.Value, promiseArgs []js.Value) interface{} {
+		resolve, reject := promiseArgs[0], promiseArgs[1]
+		go func() {
+			pdfBytes, err := convertImages(images, jsConfig)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			out := js.Global().Get("Uint8Array").New(len(pdfBytes))
+			js.CopyBytesToJS(out, pdfBytes)
+			resolve.Invoke(out)
+		}()
+		return nil
+	})
+	defer handler.Release()
+
+	return js.Global().Get("Promise").New(handler)
+}
+
+// convertImages copies the JS image list into in-memory sources and runs the
+// regular converter pipeline over them.
+func convertImages(images, jsConfig js.Value) ([]byte, error) {
+	if images.IsUndefined() || images.IsNull() || images.Length() == 0 {
+		return nil, converter.ErrNoSupportedImages
+	}
+
+	cfg := converter.NewDefaultConfig()
+	if !jsConfig.IsUndefined() && !jsConfig.IsNull() {
+		configJSON := js.Global().Get("JSON").Call("stringify", jsConfig).String()
+		if err := json.Unmarshal([]byte(configJSON), cfg); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		if cfg.JPEGQuality < 1 || cfg.JPEGQuality > 100 {
+			cfg.JPEGQuality = converter.NewDefaultConfig().JPEGQuality
+		}
+		if cfg.NumWorkers <= 0 {
+			cfg.NumWorkers = converter.NewDefaultConfig().NumWorkers
+		}
+	}
+
+	sources := make([]converter.ImageSource, 0, images.Length())
+	for i := 0; i < images.Length(); i++ {
+		img := images.Index(i)
+		data := img.Get("data")
+		if data.IsUndefined() || data.IsNull() {
+			return nil, fmt.Errorf("image %d has no data", i)
+		}
+		buf := make([]byte, data.Get("length").Int())
+		js.CopyBytesToGo(buf, data)
+
+		name := fmt.Sprintf("image_%d", i)
+		if v := img.Get("name"); v.Type() == js.TypeString {
+			name = v.String()
+		}
+		contentType := ""
+		if v := img.Get("type"); v.Type() == js.TypeString {
+			contentType = v.String()
+		}
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = converter.GetContentTypeFromFilename(name)
+		}
+
+		sources = append(sources, converter.ImageSource{
+			OriginalFilename: name,
+			Reader:           io.NopCloser(bytes.NewReader(buf)),
+			ContentType:      contentType,
+			Index:            i,
+		})
+	}
+
+	var out bytes.Buffer
+	hasContent, err := converter.ConvertToPDF(context.Background(), sources, cfg, &out)
+	if err != nil {
+		return nil, err
+	}
+	if !hasContent {
+		return nil, errors.New("no content added to PDF")
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,1167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"manga_to_pdf/api"
+	"manga_to_pdf/internal/converter"
+)
+
+// Config holds all application configuration for the server.
+//
+// Values are resolved with the precedence defaults < environment < config
+// file < command-line flags, so container deployments can rely on
+// environment variables alone while local runs can still override anything.
+// Flags missing from the command line may still be given by the user's
+// defaults file and MTP_ environment variables (see applyFlagDefaults).
+type Config struct {
+	ListenAddress  string   `json:"listen_address"`
+	VerboseLogging bool     `json:"verbose_logging"`
+	MaxUploadBytes byteSize `json:"max_upload"`             // Maximum /convert request body size (0 = unlimited)
+	Workers        int      `json:"workers"`                // Default and maximum image workers per conversion
+	ConvertTimeout duration `json:"convert_timeout"`        // Stop a /convert request running longer than this (0 = no limit)
+	AuthTokens     []string `json:"auth_tokens,omitempty"`  // Accepted bearer tokens; empty disables auth
+	AdminTokens    []string `json:"admin_tokens,omitempty"` // Bearer tokens for /admin/; empty disables the admin API
+	DataDir        string   `json:"data_dir"`               // Single writable root for everything the server writes
+	TempDir        string   `json:"temp_dir"`               // Temporary upload files (default: <data_dir>/tmp)
+	CacheDir       string   `json:"cache_dir"`              // Cached data, such as the autocert cache (default: <data_dir>/cache)
+
+	TLSCert         string   `json:"tls_cert,omitempty"`         // PEM certificate file; enables HTTPS together with TLSKey
+	TLSKey          string   `json:"tls_key,omitempty"`          // PEM private key file
+	AutocertDomains []string `json:"autocert_domains,omitempty"` // Domains to obtain Let's Encrypt certificates for
+	AutocertEmail   string   `json:"autocert_email,omitempty"`   // Contact address for the ACME account
+	H2C             bool     `json:"h2c"`                        // Accept cleartext HTTP/2 (for internal networks without TLS)
+
+	SocketMode  fileMode `json:"socket_mode"`            // Permissions of the socket of a unix: ListenAddress
+	SocketGroup string   `json:"socket_group,omitempty"` // Group (name or GID) owning that socket (default: the server's)
+
+	ReadHeaderTimeout duration `json:"read_header_timeout"` // Drop connections that do not send their request headers in time (0 = no limit)
+	IdleTimeout       duration `json:"idle_timeout"`        // Close keep-alive connections idle this long (0 = no limit)
+	ShutdownTimeout   duration `json:"shutdown_timeout"`    // How long running requests may finish after a shutdown signal
+
+	SlowLogDuration duration `json:"slow_log_duration"`       // Log conversions taking at least this long (0 = off)
+	SlowLogSize     byteSize `json:"slow_log_size"`           // Log conversions whose upload or PDF reaches this size (0 = off)
+	SlowLogFile     string   `json:"slow_log_file,omitempty"` // Slow-log destination (default: <data_dir>/slow.log)
+
+	DependencyURLs []string `json:"dependency_urls,omitempty"` // External services checked for /readyz, as "name=url" or "url"
+	HealthInterval duration `json:"health_interval"`           // How often dependencies are re-checked
+
+	FetchMaxConnsPerHost int      `json:"fetch_max_conns_per_host"` // Concurrent image_urls downloads per host (0 = unlimited)
+	FetchHostDelay       duration `json:"fetch_host_delay"`         // Minimum gap between requests to the same host
+	FetchProxy           string   `json:"fetch_proxy,omitempty"`    // SOCKS5 proxy every image_urls download goes through, with per-job isolation
+	FetchRetries         int      `json:"fetch_retries"`            // Retries of image_urls that failed with transient errors
+	FetchRetryDelay      duration `json:"fetch_retry_delay"`        // Gap before each round of retries
+
+	MaxTotalMegapixels float64 `json:"max_total_megapixels"` // Pixels a conversion may decode in total, in megapixels (0 = no limit)
+
+	DecodeLimits map[string]int `json:"decode_limits,omitempty"` // Pages of a format a conversion decodes at once, e.g. {"webp": 2}
+
+	UploadTypes []string `json:"upload_types,omitempty"` // Content types uploaded images must be sniffed as (empty = any)
+
+	JobStorage  string   `json:"job_storage"`           // Where /jobs outputs are kept: "memory", "local" (<data_dir>/jobs) or "s3://bucket/prefix"
+	S3Endpoint  string   `json:"s3_endpoint,omitempty"` // S3-compatible service URL for job_storage s3:// (empty for AWS)
+	S3Region    string   `json:"s3_region,omitempty"`   // Region for job_storage s3:// (default us-east-1)
+	TenantQuota byteSize `json:"tenant_quota"`          // Finished /jobs outputs kept per tenant (0 = unlimited)
+
+	MaxJobs       int `json:"max_jobs"`        // /jobs running at once on the server, beyond which new ones get 503 (0 = unlimited)
+	MaxTenantJobs int `json:"max_tenant_jobs"` // /jobs running at once per tenant, beyond which new ones get 429 (0 = unlimited)
+
+	MaxSessions       int `json:"max_sessions"`        // Upload sessions open at once on the server, beyond which new ones get 503 (0 = unlimited)
+	MaxTenantSessions int `json:"max_tenant_sessions"` // Upload sessions open at once per tenant, beyond which new ones get 429 (0 = unlimited)
+
+	WebhookAllowNetworks []string `json:"webhook_allow_networks,omitempty"` // CIDRs of non-public networks webhook receivers may be in anyway
+
+	MemoryTrimInterval duration `json:"memory_trim_interval"` // How often pooled buffers are dropped and freed memory returned to the OS (0 = never)
+	GCPercent          int      `json:"gc_percent"`           // Garbage collector target, as GOGC (0 = the runtime's, -1 = collect only at MemoryLimit)
+	MemoryLimit        byteSize `json:"memory_limit"`         // Soft heap limit the collector works harder to stay under (0 = none)
+
+	// Local conversion mode: when Input is set the images in that directory
+	// are converted to Output instead of starting the server.
+	Input         string `json:"-"`
+	Output        string `json:"-"`
+	PreCmd        string `json:"-"` // Shell command run before each conversion
+	PostCmd       string `json:"-"` // Shell command run after each conversion, with its status
+	OnExists      string `json:"-"` // When the output file exists: "overwrite", "skip", "rename" or "prompt"
+	SaveOrder     bool   `json:"-"` // Persist the resolved page order next to the input
+	Info          bool   `json:"-"` // Print the dependency check results and exit
+	Lenient       bool   `json:"-"` // Skip junk files in the input directory instead of converting them
+	Sniff         bool   `json:"-"` // Detect extension-less images by their magic bytes
+	MaxPages      int    `json:"-"` // Refuse inputs with more pages than this (0 = no limit)
+	ReadAhead     int    `json:"-"` // Pages read into memory ahead of the decode workers (0 = none)
+	Recursive     bool   `json:"-"` // Treat every subdirectory holding images as a chapter
+	Split         bool   `json:"-"` // With Recursive, write one PDF per chapter
+	Normalize     bool   `json:"-"` // Scale every page to the volume's most common width
+	Dedupe        bool   `json:"-"` // Drop pages byte-identical to an earlier page
+	SkipBlank     bool   `json:"-"` // Drop blank and nearly blank pages
+	GIFFrames     bool   `json:"-"` // One page per frame of animated GIFs
+	WebPTarget    string `json:"-"` // How WebP pages are re-encoded: "auto", "png" or "jpeg"
+	Resample      string `json:"-"` // Filter pages are resized with: "lanczos", "catmullrom" or "nearest"
+	Profile       string `json:"-"` // Device profile the other page settings start from
+	Grayscale     bool   `json:"-"` // Convert colour pages to grayscale
+	MaxWidth      int    `json:"-"` // Scale wider pages down to this width (0 = no limit)
+	MaxHeight     int    `json:"-"` // Scale taller pages down to this height (0 = no limit)
+	JPEGQuality   int    `json:"-"` // Quality of re-encoded JPEG pages, 1 to 100 (0 = the profile's or the converter default)
+	PagesPerSheet int    `json:"-"` // Print layout: 2 or 4 pages per sheet of Paper (0 = one page per PDF page)
+	Paper         string `json:"-"` // Paper size for PagesPerSheet
+	Format        string `json:"-"` // Output format: "pdf" or "epub"
+	RTL           bool   `json:"-"` // Mark the output as read right to left
+	Tagged        bool   `json:"-"` // Write a tagged PDF with alternate text for every page
+	Provenance    bool   `json:"-"` // Record each page's source file and SHA-256 in the PDF
+	PageBookmarks bool   `json:"-"` // Add a bookmark for every page
+	Lang          string `json:"-"` // Document language
+	Title         string `json:"-"` // Document metadata
+	Author        string `json:"-"`
+	Subject       string `json:"-"`
+	Keywords      string `json:"-"`
+	Captions      string `json:"-"` // JSON file mapping pages to caption text
+
+	PageSize   string  `json:"-"` // Uniform page size: a paper or WxH points ("" = each page the size of its image)
+	Fit        string  `json:"-"` // How images fit a PageSize page: contain, cover or stretch
+	Margin     float64 `json:"-"` // Inset of every image on its page, in points
+	Background string  `json:"-"` // #rrggbb fill behind every image ("" = white paper)
+	KeepAlpha  bool    `json:"-"` // Embed transparent pages without flattening them onto Background
+
+	Placeholders bool `json:"-"` // Put a placeholder page in place of every page that fails
+
+	Watermark          string  `json:"-"` // Watermark text, or the path of a PNG/JPEG to stamp
+	WatermarkPosition  string  `json:"-"`
+	WatermarkOpacity   float64 `json:"-"`
+	WatermarkFirstPage bool    `json:"-"` // Stamp only the first page
+
+	AutoLevels          bool    `json:"-"` // Stretch the tonal range of every page
+	AutoLevelsBlackClip float64 `json:"-"` // Percent of pixels that may be clipped to black
+	AutoLevelsWhiteClip float64 `json:"-"` // Percent of pixels that may be clipped to white
+
+	AutoCrop          bool `json:"-"` // Trim the uniform margins around every page
+	AutoCropTolerance int  `json:"-"` // Levels a margin pixel may differ from the margin colour
+
+	Brightness float64 `json:"-"` // Percent, -100 to 100
+	Contrast   float64 `json:"-"` // Percent, -100 to 100
+	Gamma      float64 `json:"-"` // 0 or 1 leaves the midtones
+	Sharpen    float64 `json:"-"` // Unsharp mask sigma in pixels
+
+	Saturation   float64 `json:"-"` // Saturation multiplier for colour pages, 1 to 4 (0 = unchanged)
+	ColourLevels int     `json:"-"` // Levels per channel colour pages are dithered to (0 = all)
+
+	TargetSize byteSize `json:"-"` // Keep the output under this size by lowering quality, then resolution (0 = off)
+
+	Include []string `json:"-"` // Name patterns of the page files to convert (none = every supported image)
+	Exclude []string `json:"-"` // Name patterns of page files to leave out
+
+	QualityReport bool `json:"-"` // Write name.report.html comparing every page before and after next to each output
+	Progress      bool `json:"-"` // Draw a progress bar when stdout is a terminal
+
+	Inputs         []string               `json:"-"` // Every input when several are given (Input is then the first)
+	OutputTemplate string                 `json:"-"` // Output path of each input (see expandOutputTemplate)
+	Jobs           int                    `json:"-"` // Inputs of a batch converted at once
+	limit          *converter.WorkerLimit // Shared by the conversions of a batch
+
+	URLs     []string `json:"-"` // Image URLs, or the URL of one CBZ/ZIP archive, to convert instead of Input
+	URLsFile string   `json:"-"` // File listing more URLs, one per line
+
+	Checkpoint string      `json:"-"` // Keep the converted pages and the finished inputs here, to resume a stopped run
+	checkpoint *checkpoint // Open while runInput runs with Checkpoint
+
+	Cache     string        `json:"-"` // Keep converted pages here for later runs to reuse
+	CacheSize byteSize      `json:"-"` // Size -cache is pruned to after each run (0 = unlimited)
+	cache     *pageCacheDir // Open while runInput runs with Cache
+
+	EventsFile string    `json:"-"` // Append NDJSON lifecycle events of the run to this file
+	events     *eventLog // Open while runApp runs with EventsFile
+
+	Manifest string    `json:"-"` // Write a JSON report of every output and page to this file when the run ends
+	manifest *manifest // Collected while runApp runs with Manifest
+
+	MetricsPush string      `json:"-"` // Push the metrics of the run to this Pushgateway or StatsD endpoint when it ends
+	metrics     *runMetrics // Collected while runApp runs with MetricsPush
+
+	Watch         bool     `json:"-"` // Keep converting the chapter directories and archives dropped into Input
+	WatchInterval duration `json:"-"` // How often Input is scanned with Watch
+	WatchSettle   duration `json:"-"` // How long a dropped entry must stay unchanged before it is converted
+
+	SplitEvery int      `json:"-"` // Write numbered parts of at most this many pages (0 = one output)
+	SplitSize  byteSize `json:"-"` // Write numbered parts of about at most this size (0 = one output)
+
+	ViaServer    bool         `json:"-"` // Convert through a server listening on ServerSocket, if there is one
+	ServerSocket string       `json:"-"`
+	ServerToken  string       `json:"-"` // Bearer token for a server with AUTH_TOKENS
+	server       *localServer // Found by runInput with ViaServer
+	// CPUProfileFile string // Profiling can be added back if needed via HTTP endpoints (e.g. net/http/pprof)
+	// MemProfileFile string
+}
+
+// defaultConfig returns the built-in configuration defaults.
+func defaultConfig() Config {
+	return Config{
+		ListenAddress:  ":8080",   // Default listen address
+		VerboseLogging: false,     // Default logging level
+		MaxUploadBytes: 256 << 20, // 256 MiB per request
+		Workers:        runtime.NumCPU(),
+		HealthInterval: duration(30 * time.Second),
+		MaxPages:       5000, // Far above any real volume; catches a wrong -i path
+		ReadAhead:      4,
+		CacheSize:      1 << 30, // 1 GiB of converted pages
+		JobStorage:     "memory",
+
+		ReadHeaderTimeout: duration(30 * time.Second),
+		IdleTimeout:       duration(2 * time.Minute),
+		ShutdownTimeout:   duration(30 * time.Second),
+
+		SocketMode: 0o660,
+
+		MemoryTrimInterval: duration(5 * time.Minute),
+
+		WatchInterval: duration(2 * time.Second),
+		WatchSettle:   duration(10 * time.Second),
+
+		FetchRetries:    2,
+		FetchRetryDelay: duration(2 * time.Second),
+
+		MaxJobs:       16,
+		MaxTenantJobs: 4,
+
+		MaxSessions:       64,
+		MaxTenantSessions: 8,
+	}
+}
+
+// Commands loadCommandConfig reads the flags of.
+const (
+	commandAny     = ""        // No subcommand: every flag, with -i selecting a conversion
+	commandConvert = "convert" // The conversion flags
+	commandServe   = "serve"   // The server flags
+)
+
+// loadConfig resolves the effective configuration from the environment, an
+// optional JSON config file and the command-line arguments (without the
+// program name). printOnly is true when --print-config was requested.
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (cfg Config, printOnly bool, err error) {
+	return loadCommandConfig(commandAny, args, getenv, output)
+}
+
+// loadCommandConfig is loadConfig for the arguments of command, which
+// accepts only its own flags besides the shared ones.
+func loadCommandConfig(command string, args []string, getenv func(string) string, output io.Writer) (cfg Config, printOnly bool, err error) {
+	cfg = defaultConfig()
+	if err := applyEnv(&cfg, getenv); err != nil {
+		return cfg, false, err
+	}
+
+	configPath := getenv("CONFIG_FILE")
+	var overrides []func(*Config) error
+	flagSet := configFlagSet(command, &cfg, &configPath, &printOnly, &overrides)
+	flagSet.SetOutput(output)
+	if err := flagSet.Parse(args); err != nil {
+		return cfg, false, err
+	}
+	if command != commandAny {
+		// A conversion's inputs may also be given as its arguments.
+		if command == commandConvert {
+			cfg.Inputs = append(cfg.Inputs, flagSet.Args()...)
+		} else if flagSet.NArg() > 0 {
+			return cfg, false, fmt.Errorf("unexpected argument %q", flagSet.Arg(0))
+		}
+	}
+	if cfg.URLsFile != "" {
+		urls, err := readURLsFile(cfg.URLsFile)
+		if err != nil {
+			return cfg, false, err
+		}
+		cfg.URLs = append(cfg.URLs, urls...)
+	}
+	if len(cfg.URLs) > 0 {
+		if len(cfg.Inputs) > 0 || cfg.Watch {
+			return cfg, false, errors.New("-url and -urls-file cannot be combined with -i or -watch")
+		}
+		cfg.Inputs = []string{cfg.URLs[0]} // The input the run is named after
+	}
+	for i, input := range cfg.Inputs {
+		if input == stdioName {
+			cfg.Inputs[i] = tarInputPrefix + stdioName
+		}
+	}
+	if len(cfg.Inputs) > 0 {
+		cfg.Input = cfg.Inputs[0]
+	}
+	if len(cfg.Inputs) == 1 {
+		cfg.Inputs = nil
+	}
+	if err := applyFlagDefaults(flagSet, getenv); err != nil {
+		return cfg, false, err
+	}
+
+	if configPath != "" {
+		if err := loadConfigFile(&cfg, configPath); err != nil {
+			return cfg, false, err
+		}
+	}
+	for _, apply := range overrides {
+		if err := apply(&cfg); err != nil {
+			return cfg, false, err
+		}
+	}
+	if cfg.MetricsPush != "" {
+		if _, err := parseMetricsPush(cfg.MetricsPush); err != nil {
+			return cfg, false, fmt.Errorf("invalid -metrics-push: %w", err)
+		}
+	}
+	resolvePaths(&cfg)
+	return cfg, printOnly, nil
+}
+
+// configFlagSet defines the flags of command, which set cfg, configPath and
+// printOnly directly or append to overrides.
+func configFlagSet(command string, cfg *Config, configPath *string, printOnly *bool, overrides *[]func(*Config) error) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(strings.TrimSpace("manga_to_pdf "+command), flag.ContinueOnError)
+
+	// Flags are collected first and applied last so that they win over the
+	// config file, which is only known once the flags have been parsed.
+	override := func(name, usage string, apply func(*Config, string) error) {
+		flagSet.Func(name, usage, func(value string) error {
+			*overrides = append(*overrides, func(c *Config) error {
+				if err := apply(c, value); err != nil {
+					return fmt.Errorf("invalid -%s: %w", name, err)
+				}
+				return nil
+			})
+			return nil
+		})
+	}
+	flagSet.StringVar(configPath, "config", *configPath, "Path to a JSON config file (env CONFIG_FILE)")
+	flagSet.BoolVar(printOnly, "print-config", false, "Print the effective configuration as JSON and exit")
+	flagSet.BoolFunc("verbose", "Enable debug logging (env VERBOSE_LOGGING)", func(v string) error {
+		verbose, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*overrides = append(*overrides, func(c *Config) error {
+			c.VerboseLogging = verbose
+			return nil
+		})
+		return nil
+	})
+	override("workers", "Default and maximum image workers per conversion (env WORKERS)", func(c *Config, v string) error {
+		return setWorkers(c, v)
+	})
+	override("fetch-max-conns-per-host", "Concurrent image URL downloads per host; 0 is unlimited (env FETCH_MAX_CONNS_PER_HOST)", func(c *Config, v string) error {
+		return setFetchMaxConns(c, v)
+	})
+	override("fetch-host-delay", "Minimum delay between requests to the same host, e.g. 250ms (env FETCH_HOST_DELAY)", func(c *Config, v string) error {
+		return c.FetchHostDelay.Set(v)
+	})
+	override("fetch-retries", "How many more times image URLs that failed with a network error, 429 or 5xx are tried before converting; 0 disables retries (env FETCH_RETRIES)", func(c *Config, v string) error {
+		return setFetchRetries(c, v)
+	})
+	override("fetch-retry-delay", "Wait before each round of image URL retries, e.g. 2s (env FETCH_RETRY_DELAY)", func(c *Config, v string) error {
+		return c.FetchRetryDelay.Set(v)
+	})
+	override("fetch-proxy", "SOCKS5 proxy for image URL downloads, e.g. socks5h://127.0.0.1:9050 for Tor; each job gets its own connections and circuit (env FETCH_PROXY)", func(c *Config, v string) error {
+		return setFetchProxy(c, v)
+	})
+	if command != commandServe {
+		flagSet.Func("i", "Convert the images in this directory or CBZ/ZIP archive, or the tar stream tar:<file> (- or tar:- for stdin), instead of starting the server; repeat to convert several inputs in one run", func(v string) error {
+			cfg.Inputs = append(cfg.Inputs, v)
+			return nil
+		})
+		flagSet.Func("url", "Download and convert this image, in the order given, or this CBZ/ZIP archive, instead of -i; repeat for several images", func(v string) error {
+			cfg.URLs = append(cfg.URLs, v)
+			return nil
+		})
+		flagSet.StringVar(&cfg.URLsFile, "urls-file", "", "Like -url for every URL in this file, one per line; blank lines and lines starting with # are ignored")
+		flagSet.StringVar(&cfg.OutputTemplate, "output-template", "", "Output path of each input, with {dir} (the input's directory), {name} (its name), {ext} (.pdf or .epub) and {index} (its position among the inputs), e.g. out/{index}-{name}{ext}")
+		flagSet.IntVar(&cfg.Jobs, "jobs", 2, "With several inputs, how many are converted at once; they share the -workers")
+		flagSet.StringVar(&cfg.Checkpoint, "checkpoint", "", "With -i, keep every converted page and finished input in this directory, so a run stopped by SIGTERM or Ctrl-C resumes where it stopped when run again with the same -checkpoint; removed once the run succeeds")
+		flagSet.StringVar(&cfg.Cache, "cache", "", "With -i, keep converted pages in this directory, so converting a volume again after adding or changing pages only converts the new and changed ones")
+		flagSet.Var(&cfg.CacheSize, "cache-size", "Size the -cache directory is pruned to after each run, dropping the pages used longest ago, e.g. 2GB; 0 is unlimited")
+		flagSet.StringVar(&cfg.Output, "o", "", "Output file for -i (default: <input>.pdf, or .epub with -format epub), or - for stdout; with several inputs, the directory for their outputs")
+		flagSet.StringVar(&cfg.OnExists, "on-exists", "rename", "With -i, when an output file exists: overwrite it, skip the conversion, rename the new output with a numeric suffix, or prompt")
+		flagSet.StringVar(&cfg.PreCmd, "pre-cmd", "", "With -i, shell command to run before each conversion; the conversion is skipped if it fails")
+		flagSet.StringVar(&cfg.PostCmd, "post-cmd", "", "With -i, shell command to run after each conversion, with MANGA_TO_PDF_STATUS set to success, failed or skipped")
+		flagSet.StringVar(&cfg.Format, "format", "pdf", "With -i, output format: pdf, or epub for a fixed-layout EPUB 3 for e-readers")
+		flagSet.BoolVar(&cfg.Lenient, "lenient", false, "With -i, skip thumbnails, OS metadata, hidden and empty files and report them")
+		flagSet.Func("include", "With -i, convert only the page files whose name matches this pattern, e.g. \"*.png\"; repeat for several patterns", func(v string) error {
+			return addNamePattern(&cfg.Include, v)
+		})
+		flagSet.Func("exclude", "With -i, leave out the page files whose name matches this pattern, e.g. \"*credit*\"; repeat for several patterns", func(v string) error {
+			return addNamePattern(&cfg.Exclude, v)
+		})
+		flagSet.BoolVar(&cfg.Dedupe, "dedupe", false, "With -i, drop pages that are byte-for-byte identical to an earlier page, such as credits repeated in every chapter, and report them")
+		flagSet.BoolVar(&cfg.SkipBlank, "skip-blank", false, "With -i, drop blank and nearly blank pages, whatever their colour, and report them")
+		flagSet.BoolVar(&cfg.Sniff, "sniff", false, "With -i, include files without an extension whose content is a supported image")
+		flagSet.IntVar(&cfg.ReadAhead, "read-ahead", cfg.ReadAhead, "With -i, read up to this many pages into memory ahead of the decode workers, so CBZ/ZIP entries are decompressed while earlier pages are processed; 0 reads each page as it is decoded")
+		flagSet.IntVar(&cfg.MaxPages, "max-pages", cfg.MaxPages, "With -i, refuse inputs with more pages than this; 0 disables the check")
+		flagSet.BoolVar(&cfg.Recursive, "recursive", false, "With -i, convert the images in every subdirectory too, one chapter per directory in natural order")
+		flagSet.BoolVar(&cfg.Split, "split-chapters", false, "With -recursive, write one PDF per chapter (next to each chapter, or into the -o directory)")
+		flagSet.BoolVar(&cfg.Normalize, "normalize-width", false, "With -i, scale every page to the most common page width of the PDF")
+		flagSet.BoolVar(&cfg.GIFFrames, "gif-frames", false, "With -i, expand animated GIFs into one page per frame instead of using the first frame")
+		flagSet.StringVar(&cfg.Profile, "profile", "", "With -i, device profile setting the page size, grayscale and contrast: "+profileNames()+"; other flags add to it")
+		flagSet.BoolVar(&cfg.Grayscale, "grayscale", false, "With -i, convert colour pages to grayscale")
+		flagSet.IntVar(&cfg.MaxWidth, "max-width", 0, "With -i, scale pages wider than this many pixels down, keeping their aspect ratio; 0 disables")
+		flagSet.IntVar(&cfg.MaxHeight, "max-height", 0, "With -i, scale pages taller than this many pixels down, keeping their aspect ratio; 0 disables")
+		flagSet.IntVar(&cfg.JPEGQuality, "jpeg-quality", 0, "With -i, JPEG quality (1-100) pages are re-encoded at; 0 keeps the -profile's or the default of 90")
+		flagSet.BoolVar(&cfg.Progress, "progress", true, "With -i, show a progress bar with the pages done, throughput and time left while converting, if stdout is a terminal and -verbose is off")
+		flagSet.BoolVar(&cfg.QualityReport, "quality-report", false, "With -i, write an HTML report next to each output (name.report.html) with thumbnails of every page before and after, its size change and the transforms applied")
+		flagSet.StringVar(&cfg.Manifest, "manifest", "", "With -i, write a JSON manifest to this file when the run ends: every output with its status and timings, and every page with its source, dimensions, formats, sizes and why it failed or was left out")
+		flagSet.StringVar(&cfg.EventsFile, "events-file", "", "With -i, append one JSON event per line to this file for every step of the run (scan, fetch, decode, transform, embed, write, warning, done)")
+		flagSet.StringVar(&cfg.MetricsPush, "metrics-push", "", "With -i or -url, push the duration, pages and failures of the run to this Prometheus Pushgateway URL (http://host:9091) or StatsD endpoint (statsd://host:8125[/prefix]) when it ends")
+		flagSet.BoolVar(&cfg.Watch, "watch", false, "With -i DIR, keep running and convert every chapter directory or CBZ/ZIP dropped into DIR to the -o directory (default: DIR) once it stops changing")
+		flagSet.Var(&cfg.WatchInterval, "watch-interval", "With -watch, how often the input directory is scanned")
+		flagSet.Var(&cfg.WatchSettle, "watch-settle", "With -watch, how long a dropped directory or archive must stay unchanged before it is converted")
+		flagSet.IntVar(&cfg.SplitEvery, "split-every", 0, "With -i, write the output in numbered parts (name_001.pdf, name_002.pdf, ...) of at most this many pages")
+		flagSet.Var(&cfg.SplitSize, "split-size", "With -i, write the output in numbered parts of at most about this size, e.g. 200MB, for readers that refuse huge files")
+		flagSet.BoolVar(&cfg.ViaServer, "via-server", false, "With -i, have a manga_to_pdf server listening on -server-socket convert, with its settings and caches; without one, convert in-process")
+		flagSet.StringVar(&cfg.ServerSocket, "server-socket", defaultServerSocket, "Unix socket -via-server looks for the server on")
+		flagSet.StringVar(&cfg.ServerToken, "server-token", "", "Bearer token -via-server sends, for a server with AUTH_TOKENS; better set as MTP_SERVER_TOKEN than on the command line")
+		flagSet.Var(&cfg.TargetSize, "target-size", "With -i, keep the output under this size, e.g. 50MB, by converting again at lower JPEG quality and then resolution")
+		flagSet.StringVar(&cfg.Resample, "resample-filter", "lanczos", "With -i, filter for resizing pages: lanczos, catmullrom (less ringing on line art) or nearest (fastest)")
+		flagSet.StringVar(&cfg.WebPTarget, "webp-target", "auto", "With -i, re-encode WebP pages as png or jpeg; auto uses PNG for lossless or transparent WebP and JPEG otherwise")
+		flagSet.BoolVar(&cfg.AutoLevels, "auto-levels", false, "With -i, stretch the tonal range of every page to full black and white")
+		flagSet.Float64Var(&cfg.AutoLevelsBlackClip, "auto-levels-black-clip", 0.5, "Percent of pixels -auto-levels may clip to black")
+		flagSet.Float64Var(&cfg.AutoLevelsWhiteClip, "auto-levels-white-clip", 0.5, "Percent of pixels -auto-levels may clip to white")
+		flagSet.BoolVar(&cfg.AutoCrop, "auto-crop", false, "With -i, trim the uniform white or black margins around every page")
+		flagSet.IntVar(&cfg.AutoCropTolerance, "auto-crop-tolerance", 16, "Levels (0-128) a pixel may differ from the margin colour and still be trimmed by -auto-crop")
+		flagSet.Float64Var(&cfg.Brightness, "brightness", 0, "With -i, change the brightness of every page by this percentage (-100 to 100)")
+		flagSet.Float64Var(&cfg.Contrast, "contrast", 0, "With -i, change the contrast of every page by this percentage (-100 to 100); faded scans read better on e-ink with 20-40")
+		flagSet.Float64Var(&cfg.Gamma, "gamma", 0, "With -i, gamma-correct every page: below 1 darkens the midtones, above 1 lightens them")
+		flagSet.Float64Var(&cfg.Sharpen, "sharpen", 0, "With -i, sharpen every page with an unsharp mask of this sigma in pixels, e.g. 0.5")
+		flagSet.Float64Var(&cfg.Saturation, "saturation", 0, "With -i, boost the saturation of colour pages by this factor (1 to 4), as far as each colour stays in range, for colour e-ink")
+		flagSet.IntVar(&cfg.ColourLevels, "colour-levels", 0, "With -i, dither colour pages to this many levels per channel (2 to 255), e.g. 16 for the 4096 colours of Kaleido 3 screens")
+		flagSet.IntVar(&cfg.PagesPerSheet, "pages-per-sheet", 0, "With -i, lay out 2 (side by side) or 4 (2x2) pages per sheet of -paper for printing")
+		flagSet.StringVar(&cfg.Paper, "paper", "a4", "Paper size for -pages-per-sheet: a4, a5, a3, letter or legal")
+		flagSet.StringVar(&cfg.PageSize, "page-size", "", "With -i, give every page the same size, for printing double-sided: a4, a5, a3, letter, legal or WxH in points, e.g. 432x648")
+		flagSet.StringVar(&cfg.Fit, "fit", converter.FitContain, "How images fit a -page-size page: contain (letterboxed), cover (cropped) or stretch")
+		flagSet.Float64Var(&cfg.Margin, "margin", 0, "With -i, inset every image by this many points (1/72 inch) on each side of its page")
+		flagSet.StringVar(&cfg.Background, "background", "", "With -i, fill pages with this colour (#rrggbb) behind their images: in the -margin, around letterboxed images and through transparency")
+		flagSet.BoolVar(&cfg.KeepAlpha, "keep-alpha", false, "With -i, embed transparent PNG, WebP and GIF pages as they are instead of flattening them onto -background (white by default)")
+		flagSet.BoolVar(&cfg.Placeholders, "placeholders", false, "With -i or -url, put a \"Page N missing\" page in place of every image that fails, so later pages keep their page numbers")
+		flagSet.BoolVar(&cfg.RTL, "rtl", false, "With -i, mark the output as read right to left so readers page in manga order")
+		flagSet.BoolVar(&cfg.PageBookmarks, "page-bookmarks", false, "With -i, add a PDF bookmark for every page (below its chapter's with -recursive)")
+		flagSet.BoolVar(&cfg.Tagged, "tagged", false, "With -i, write a tagged PDF in which every page image is a figure with its filename as alternate text")
+		flagSet.BoolVar(&cfg.Provenance, "provenance", false, "With -i, record the source filename, chapter and SHA-256 of every page in the PDF page's /PieceInfo, to trace archived conversions back to their sources")
+		flagSet.StringVar(&cfg.Title, "title", "", "With -i, document title (default: the output file name)")
+		flagSet.StringVar(&cfg.Author, "author", "", "With -i, document author")
+		flagSet.StringVar(&cfg.Subject, "subject", "", "With -i, document subject, e.g. the series name")
+		flagSet.StringVar(&cfg.Keywords, "keywords", "", "With -i, comma-separated document keywords")
+		flagSet.StringVar(&cfg.Captions, "captions", "", "With -i, JSON file mapping page filenames or numbers to captions embedded as invisible text")
+		flagSet.StringVar(&cfg.Watermark, "watermark", "", "With -i, text or PNG/JPEG image file to stamp on every page")
+		flagSet.StringVar(&cfg.WatermarkPosition, "watermark-position", "center", "Watermark position: center, top, bottom, top-left, top-right, bottom-left or bottom-right")
+		flagSet.Float64Var(&cfg.WatermarkOpacity, "watermark-opacity", 0.3, "Watermark opacity, from 0 (exclusive) to 1")
+		flagSet.BoolVar(&cfg.WatermarkFirstPage, "watermark-first-page", false, "Stamp the watermark on the first page only")
+		flagSet.StringVar(&cfg.Lang, "lang", "", "With -i, document language for screen readers (e.g. en, ja)")
+		flagSet.BoolVar(&cfg.SaveOrder, "save-order", false, "With -i, write the resolved page order to "+orderOverrideFile+" in the input directory")
+	}
+	if command != commandConvert {
+		flagSet.BoolVar(&cfg.Info, "info", false, "Check the configured dependencies, print the results and exit")
+		override("tls-cert", "PEM certificate file to serve HTTPS (env TLS_CERT)", func(c *Config, v string) error {
+			c.TLSCert = v
+			return nil
+		})
+		override("tls-key", "PEM private key file for -tls-cert (env TLS_KEY)", func(c *Config, v string) error {
+			c.TLSKey = v
+			return nil
+		})
+		override("autocert-domains", "Comma-separated domains for automatic Let's Encrypt certificates (env AUTOCERT_DOMAINS)", func(c *Config, v string) error {
+			c.AutocertDomains = splitList(v)
+			return nil
+		})
+		override("autocert-email", "Contact email for the ACME account (env AUTOCERT_EMAIL)", func(c *Config, v string) error {
+			c.AutocertEmail = v
+			return nil
+		})
+		override("slow-log-duration", "Log conversions taking at least this long, e.g. 30s; 0 disables (env SLOW_LOG_DURATION)", func(c *Config, v string) error {
+			return c.SlowLogDuration.Set(v)
+		})
+		override("slow-log-size", "Log conversions whose upload or PDF reaches this size, e.g. 100MB; 0 disables (env SLOW_LOG_SIZE)", func(c *Config, v string) error {
+			return c.SlowLogSize.Set(v)
+		})
+		override("slow-log-file", "Slow-log file (env SLOW_LOG_FILE, default <data-dir>/slow.log)", func(c *Config, v string) error {
+			c.SlowLogFile = v
+			return nil
+		})
+		override("dependency-urls", "Comma-separated external services to check for /readyz, as name=url or url (env DEPENDENCY_URLS)", func(c *Config, v string) error {
+			c.DependencyURLs = splitList(v)
+			return nil
+		})
+		override("health-interval", "How often dependencies are re-checked, e.g. 30s (env HEALTH_INTERVAL)", func(c *Config, v string) error {
+			return c.HealthInterval.Set(v)
+		})
+		override("max-total-megapixels", "Stop a conversion once its pages add up to more megapixels than this; 0 is unlimited (env MAX_TOTAL_MEGAPIXELS)", func(c *Config, v string) error {
+			return setMaxTotalMegapixels(c, v)
+		})
+		override("decode-limits", "Pages of each format a conversion decodes at once, e.g. webp=2,jpeg=8; formats not listed are limited by the decode workers only (env DECODE_LIMITS)", func(c *Config, v string) error {
+			return setDecodeLimits(c, v)
+		})
+		override("upload-types", "Comma-separated content types uploaded images must be, checked on their content before anything is decoded, e.g. image/jpeg,image/png; a request with any other part is rejected (env UPLOAD_TYPES)", func(c *Config, v string) error {
+			return setUploadTypes(c, v)
+		})
+		override("job-storage", "Where /jobs outputs are kept: memory, local (<data-dir>/jobs) or s3://bucket/prefix (env JOB_STORAGE)", func(c *Config, v string) error {
+			return setJobStorage(c, v)
+		})
+		override("s3-endpoint", "S3-compatible service URL for -job-storage s3://, e.g. http://minio:9000 (env S3_ENDPOINT)", func(c *Config, v string) error {
+			c.S3Endpoint = v
+			return nil
+		})
+		override("s3-region", "Region for -job-storage s3:// (env S3_REGION or AWS_REGION)", func(c *Config, v string) error {
+			c.S3Region = v
+			return nil
+		})
+		override("tenant-quota", "Size of finished /jobs outputs kept per tenant, e.g. 2GB; 0 disables the limit (env TENANT_QUOTA)", func(c *Config, v string) error {
+			return c.TenantQuota.Set(v)
+		})
+		override("max-jobs", "Background jobs running at once on the server, beyond which new ones get 503; 0 disables the limit (env MAX_JOBS)", func(c *Config, v string) error {
+			return setLimit(&c.MaxJobs, v)
+		})
+		override("max-tenant-jobs", "Background jobs running at once per tenant, beyond which new ones get 429; 0 disables the limit (env MAX_TENANT_JOBS)", func(c *Config, v string) error {
+			return setLimit(&c.MaxTenantJobs, v)
+		})
+		override("max-sessions", "Upload sessions open at once on the server, beyond which new ones get 503; 0 disables the limit (env MAX_SESSIONS)", func(c *Config, v string) error {
+			return setLimit(&c.MaxSessions, v)
+		})
+		override("max-tenant-sessions", "Upload sessions open at once per tenant, beyond which new ones get 429; 0 disables the limit (env MAX_TENANT_SESSIONS)", func(c *Config, v string) error {
+			return setLimit(&c.MaxTenantSessions, v)
+		})
+		override("webhook-allow-networks", "Comma-separated CIDRs of loopback, private or link-local networks webhook receivers may be in, e.g. 10.0.0.0/8 (env WEBHOOK_ALLOW_NETWORKS)", func(c *Config, v string) error {
+			return setWebhookAllowNetworks(c, v)
+		})
+		override("memory-trim-interval", "How often pooled buffers are dropped and freed memory is returned to the OS, e.g. 5m; 0 disables (env MEMORY_TRIM_INTERVAL)", func(c *Config, v string) error {
+			return c.MemoryTrimInterval.Set(v)
+		})
+		override("gc-percent", "Garbage collector target percentage, as GOGC; 0 keeps the runtime's, -1 collects only near -memory-limit (env GC_PERCENT)", func(c *Config, v string) error {
+			return setGCPercent(c, v)
+		})
+		override("memory-limit", "Soft memory limit the garbage collector works to stay under, e.g. 2GB; 0 disables (env MEMORY_LIMIT)", func(c *Config, v string) error {
+			return c.MemoryLimit.Set(v)
+		})
+		override("listen", "Address to listen on, e.g. :8080, or unix:/path for a Unix socket (env LISTEN_ADDRESS or PORT)", func(c *Config, v string) error {
+			c.ListenAddress = v
+			return nil
+		})
+		override("socket-mode", "Permissions of a unix: -listen socket, in octal (env SOCKET_MODE)", func(c *Config, v string) error {
+			return c.SocketMode.Set(v)
+		})
+		override("socket-group", "Group, by name or GID, owning a unix: -listen socket (env SOCKET_GROUP)", func(c *Config, v string) error {
+			c.SocketGroup = v
+			return nil
+		})
+		flagSet.BoolFunc("h2c", "Accept cleartext HTTP/2 when TLS is off (env H2C)", func(v string) error {
+			h2c, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*overrides = append(*overrides, func(c *Config) error {
+				c.H2C = h2c
+				return nil
+			})
+			return nil
+		})
+		override("max-upload", "Maximum request body size, e.g. 64MB; 0 disables the limit (env MAX_UPLOAD)", func(c *Config, v string) error {
+			return c.MaxUploadBytes.Set(v)
+		})
+		override("read-header-timeout", "Drop connections that do not send their request headers within this time, e.g. 30s; 0 disables (env READ_HEADER_TIMEOUT)", func(c *Config, v string) error {
+			return c.ReadHeaderTimeout.Set(v)
+		})
+		override("idle-timeout", "Close keep-alive connections idle this long, e.g. 2m; 0 disables (env IDLE_TIMEOUT)", func(c *Config, v string) error {
+			return c.IdleTimeout.Set(v)
+		})
+		override("shutdown-timeout", "How long running requests may finish after SIGTERM or Ctrl-C before they are stopped (env SHUTDOWN_TIMEOUT)", func(c *Config, v string) error {
+			return c.ShutdownTimeout.Set(v)
+		})
+		override("convert-timeout", "Stop /convert requests running longer than this, e.g. 5m; 0 disables (env CONVERT_TIMEOUT)", func(c *Config, v string) error {
+			return c.ConvertTimeout.Set(v)
+		})
+		override("auth-tokens", "Comma-separated bearer tokens required for /convert (env AUTH_TOKENS)", func(c *Config, v string) error {
+			c.AuthTokens = splitList(v)
+			return nil
+		})
+		override("admin-tokens", "Comma-separated bearer tokens enabling the /admin/ API (env ADMIN_TOKENS)", func(c *Config, v string) error {
+			c.AdminTokens = splitList(v)
+			return nil
+		})
+		override("data-dir", "Writable directory holding all server state (env DATA_DIR)", func(c *Config, v string) error {
+			c.DataDir = v
+			return nil
+		})
+		override("temp-dir", "Directory for temporary upload files (env TEMP_DIR, default <data-dir>/tmp)", func(c *Config, v string) error {
+			c.TempDir = v
+			return nil
+		})
+		override("cache-dir", "Directory for cached data, such as the -autocert-domains certificates (env CACHE_DIR, default <data-dir>/cache)", func(c *Config, v string) error {
+			c.CacheDir = v
+			return nil
+		})
+	}
+	return flagSet
+}
+
+// applyEnv overlays configuration from environment variables.
+func applyEnv(cfg *Config, getenv func(string) string) error {
+	if port := getenv("PORT"); port != "" {
+		cfg.ListenAddress = ":" + port
+	}
+	if addr := getenv("LISTEN_ADDRESS"); addr != "" {
+		cfg.ListenAddress = addr
+	}
+	if verbose := getenv("VERBOSE_LOGGING"); verbose == "true" || verbose == "1" {
+		cfg.VerboseLogging = true
+	}
+	if h2c := getenv("H2C"); h2c == "true" || h2c == "1" {
+		cfg.H2C = true
+	}
+	if mode := getenv("SOCKET_MODE"); mode != "" {
+		if err := cfg.SocketMode.Set(mode); err != nil {
+			return fmt.Errorf("invalid SOCKET_MODE: %w", err)
+		}
+	}
+	if group := getenv("SOCKET_GROUP"); group != "" {
+		cfg.SocketGroup = group
+	}
+	for name, d := range map[string]*duration{"READ_HEADER_TIMEOUT": &cfg.ReadHeaderTimeout, "IDLE_TIMEOUT": &cfg.IdleTimeout, "SHUTDOWN_TIMEOUT": &cfg.ShutdownTimeout} {
+		if value := getenv(name); value != "" {
+			if err := d.Set(value); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	if maxUpload := getenv("MAX_UPLOAD"); maxUpload != "" {
+		if err := cfg.MaxUploadBytes.Set(maxUpload); err != nil {
+			return fmt.Errorf("invalid MAX_UPLOAD: %w", err)
+		}
+	}
+	if workers := getenv("WORKERS"); workers != "" {
+		if err := setWorkers(cfg, workers); err != nil {
+			return fmt.Errorf("invalid WORKERS: %w", err)
+		}
+	}
+	if timeout := getenv("CONVERT_TIMEOUT"); timeout != "" {
+		if err := cfg.ConvertTimeout.Set(timeout); err != nil {
+			return fmt.Errorf("invalid CONVERT_TIMEOUT: %w", err)
+		}
+	}
+	if tokens := getenv("AUTH_TOKENS"); tokens != "" {
+		cfg.AuthTokens = splitList(tokens)
+	}
+	if tokens := getenv("ADMIN_TOKENS"); tokens != "" {
+		cfg.AdminTokens = splitList(tokens)
+	}
+	if tlsCert := getenv("TLS_CERT"); tlsCert != "" {
+		cfg.TLSCert = tlsCert
+	}
+	if tlsKey := getenv("TLS_KEY"); tlsKey != "" {
+		cfg.TLSKey = tlsKey
+	}
+	if domains := getenv("AUTOCERT_DOMAINS"); domains != "" {
+		cfg.AutocertDomains = splitList(domains)
+	}
+	if email := getenv("AUTOCERT_EMAIL"); email != "" {
+		cfg.AutocertEmail = email
+	}
+	if slowDuration := getenv("SLOW_LOG_DURATION"); slowDuration != "" {
+		if err := cfg.SlowLogDuration.Set(slowDuration); err != nil {
+			return fmt.Errorf("invalid SLOW_LOG_DURATION: %w", err)
+		}
+	}
+	if slowSize := getenv("SLOW_LOG_SIZE"); slowSize != "" {
+		if err := cfg.SlowLogSize.Set(slowSize); err != nil {
+			return fmt.Errorf("invalid SLOW_LOG_SIZE: %w", err)
+		}
+	}
+	if slowFile := getenv("SLOW_LOG_FILE"); slowFile != "" {
+		cfg.SlowLogFile = slowFile
+	}
+	if urls := getenv("DEPENDENCY_URLS"); urls != "" {
+		cfg.DependencyURLs = splitList(urls)
+	}
+	if interval := getenv("HEALTH_INTERVAL"); interval != "" {
+		if err := cfg.HealthInterval.Set(interval); err != nil {
+			return fmt.Errorf("invalid HEALTH_INTERVAL: %w", err)
+		}
+	}
+	if maxConns := getenv("FETCH_MAX_CONNS_PER_HOST"); maxConns != "" {
+		if err := setFetchMaxConns(cfg, maxConns); err != nil {
+			return fmt.Errorf("invalid FETCH_MAX_CONNS_PER_HOST: %w", err)
+		}
+	}
+	if megapixels := getenv("MAX_TOTAL_MEGAPIXELS"); megapixels != "" {
+		if err := setMaxTotalMegapixels(cfg, megapixels); err != nil {
+			return fmt.Errorf("invalid MAX_TOTAL_MEGAPIXELS: %w", err)
+		}
+	}
+	if limits := getenv("DECODE_LIMITS"); limits != "" {
+		if err := setDecodeLimits(cfg, limits); err != nil {
+			return fmt.Errorf("invalid DECODE_LIMITS: %w", err)
+		}
+	}
+	if types := getenv("UPLOAD_TYPES"); types != "" {
+		if err := setUploadTypes(cfg, types); err != nil {
+			return fmt.Errorf("invalid UPLOAD_TYPES: %w", err)
+		}
+	}
+	if delay := getenv("FETCH_HOST_DELAY"); delay != "" {
+		if err := cfg.FetchHostDelay.Set(delay); err != nil {
+			return fmt.Errorf("invalid FETCH_HOST_DELAY: %w", err)
+		}
+	}
+	if retries := getenv("FETCH_RETRIES"); retries != "" {
+		if err := setFetchRetries(cfg, retries); err != nil {
+			return fmt.Errorf("invalid FETCH_RETRIES: %w", err)
+		}
+	}
+	if delay := getenv("FETCH_RETRY_DELAY"); delay != "" {
+		if err := cfg.FetchRetryDelay.Set(delay); err != nil {
+			return fmt.Errorf("invalid FETCH_RETRY_DELAY: %w", err)
+		}
+	}
+	if proxy := getenv("FETCH_PROXY"); proxy != "" {
+		if err := setFetchProxy(cfg, proxy); err != nil {
+			return fmt.Errorf("invalid FETCH_PROXY: %w", err)
+		}
+	}
+	if jobStorage := getenv("JOB_STORAGE"); jobStorage != "" {
+		if err := setJobStorage(cfg, jobStorage); err != nil {
+			return fmt.Errorf("invalid JOB_STORAGE: %w", err)
+		}
+	}
+	if endpoint := getenv("S3_ENDPOINT"); endpoint != "" {
+		cfg.S3Endpoint = endpoint
+	}
+	if region := getenv("AWS_REGION"); region != "" {
+		cfg.S3Region = region
+	}
+	if region := getenv("S3_REGION"); region != "" {
+		cfg.S3Region = region
+	}
+	if quota := getenv("TENANT_QUOTA"); quota != "" {
+		if err := cfg.TenantQuota.Set(quota); err != nil {
+			return fmt.Errorf("invalid TENANT_QUOTA: %w", err)
+		}
+	}
+	if limit := getenv("MAX_JOBS"); limit != "" {
+		if err := setLimit(&cfg.MaxJobs, limit); err != nil {
+			return fmt.Errorf("invalid MAX_JOBS: %w", err)
+		}
+	}
+	if limit := getenv("MAX_TENANT_JOBS"); limit != "" {
+		if err := setLimit(&cfg.MaxTenantJobs, limit); err != nil {
+			return fmt.Errorf("invalid MAX_TENANT_JOBS: %w", err)
+		}
+	}
+	if limit := getenv("MAX_SESSIONS"); limit != "" {
+		if err := setLimit(&cfg.MaxSessions, limit); err != nil {
+			return fmt.Errorf("invalid MAX_SESSIONS: %w", err)
+		}
+	}
+	if limit := getenv("MAX_TENANT_SESSIONS"); limit != "" {
+		if err := setLimit(&cfg.MaxTenantSessions, limit); err != nil {
+			return fmt.Errorf("invalid MAX_TENANT_SESSIONS: %w", err)
+		}
+	}
+	if networks := getenv("WEBHOOK_ALLOW_NETWORKS"); networks != "" {
+		if err := setWebhookAllowNetworks(cfg, networks); err != nil {
+			return fmt.Errorf("invalid WEBHOOK_ALLOW_NETWORKS: %w", err)
+		}
+	}
+	if interval := getenv("MEMORY_TRIM_INTERVAL"); interval != "" {
+		if err := cfg.MemoryTrimInterval.Set(interval); err != nil {
+			return fmt.Errorf("invalid MEMORY_TRIM_INTERVAL: %w", err)
+		}
+	}
+	if percent := getenv("GC_PERCENT"); percent != "" {
+		if err := setGCPercent(cfg, percent); err != nil {
+			return fmt.Errorf("invalid GC_PERCENT: %w", err)
+		}
+	}
+	if limit := getenv("MEMORY_LIMIT"); limit != "" {
+		if err := cfg.MemoryLimit.Set(limit); err != nil {
+			return fmt.Errorf("invalid MEMORY_LIMIT: %w", err)
+		}
+	}
+	if dataDir := getenv("DATA_DIR"); dataDir != "" {
+		cfg.DataDir = dataDir
+	}
+	if tempDir := getenv("TEMP_DIR"); tempDir != "" {
+		cfg.TempDir = tempDir
+	}
+	if cacheDir := getenv("CACHE_DIR"); cacheDir != "" {
+		cfg.CacheDir = cacheDir
+	}
+	return nil
+}
+
+// loadConfigFile overlays the values present in a JSON config file.
+// Keys missing from the file keep their current values.
+func loadConfigFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	if cfg.Workers <= 0 {
+		return fmt.Errorf("could not parse config file %s: workers must be positive", path)
+	}
+	if cfg.FetchMaxConnsPerHost < 0 {
+		return fmt.Errorf("could not parse config file %s: fetch_max_conns_per_host must not be negative", path)
+	}
+	if cfg.MaxTotalMegapixels < 0 {
+		return fmt.Errorf("could not parse config file %s: max_total_megapixels must not be negative", path)
+	}
+	if cfg.FetchRetries < 0 {
+		return fmt.Errorf("could not parse config file %s: fetch_retries must not be negative", path)
+	}
+	if cfg.MaxJobs < 0 {
+		return fmt.Errorf("could not parse config file %s: max_jobs must not be negative", path)
+	}
+	if cfg.MaxTenantJobs < 0 {
+		return fmt.Errorf("could not parse config file %s: max_tenant_jobs must not be negative", path)
+	}
+	if cfg.MaxSessions < 0 {
+		return fmt.Errorf("could not parse config file %s: max_sessions must not be negative", path)
+	}
+	if cfg.MaxTenantSessions < 0 {
+		return fmt.Errorf("could not parse config file %s: max_tenant_sessions must not be negative", path)
+	}
+	for _, network := range cfg.WebhookAllowNetworks {
+		if _, err := netip.ParsePrefix(network); err != nil {
+			return fmt.Errorf("could not parse config file %s: webhook_allow_networks: %w", path, err)
+		}
+	}
+	if err := converter.ValidateDecodeLimits(cfg.DecodeLimits); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	if err := api.ValidateUploadTypes(cfg.UploadTypes); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	if cfg.FetchProxy != "" {
+		if _, err := converter.ParseFetchProxy(cfg.FetchProxy); err != nil {
+			return fmt.Errorf("could not parse config file %s: fetch_proxy: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// redactTokens returns a placeholder for each token.
+func redactTokens(tokens []string) []string {
+	if len(tokens) == 0 {
+		return nil
+	}
+	redacted := make([]string, len(tokens))
+	for i := range redacted {
+		redacted[i] = "<redacted>"
+	}
+	return redacted
+}
+
+// printConfig writes the effective configuration as indented JSON, with
+// secrets redacted.
+func printConfig(w io.Writer, cfg Config) error {
+	redacted := cfg
+	redacted.AuthTokens = redactTokens(cfg.AuthTokens)
+	redacted.AdminTokens = redactTokens(cfg.AdminTokens)
+	if proxy, err := url.Parse(cfg.FetchProxy); err == nil {
+		redacted.FetchProxy = proxy.Redacted()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(redacted)
+}
+
+func setWorkers(cfg *Config, value string) error {
+	workers, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if workers <= 0 {
+		return errors.New("must be positive")
+	}
+	cfg.Workers = workers
+	return nil
+}
+
+func setJobStorage(cfg *Config, value string) error {
+	if value != "memory" && value != "local" && !strings.HasPrefix(value, "s3://") {
+		return fmt.Errorf("expected memory, local or s3://bucket/prefix, got %q", value)
+	}
+	cfg.JobStorage = value
+	return nil
+}
+
+func setFetchMaxConns(cfg *Config, value string) error {
+	maxConns, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if maxConns < 0 {
+		return errors.New("must not be negative")
+	}
+	cfg.FetchMaxConnsPerHost = maxConns
+	return nil
+}
+
+func setGCPercent(cfg *Config, value string) error {
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if percent < -1 {
+		return errors.New("must be -1 or more")
+	}
+	cfg.GCPercent = percent
+	return nil
+}
+
+func setFetchProxy(cfg *Config, value string) error {
+	if _, err := converter.ParseFetchProxy(value); err != nil {
+		return err
+	}
+	cfg.FetchProxy = value
+	return nil
+}
+
+func setFetchRetries(cfg *Config, value string) error {
+	retries, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if retries < 0 {
+		return errors.New("must not be negative")
+	}
+	cfg.FetchRetries = retries
+	return nil
+}
+
+// setLimit sets limit, one of the caps on running jobs or open sessions, to
+// value.
+func setLimit(limit *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("must not be negative")
+	}
+	*limit = n
+	return nil
+}
+
+// fetchProxy returns the parsed FetchProxy, or nil for direct downloads.
+func fetchProxy(cfg Config) *url.URL {
+	if cfg.FetchProxy == "" {
+		return nil
+	}
+	proxy, _ := converter.ParseFetchProxy(cfg.FetchProxy) // Validated when it was set
+	return proxy
+}
+
+func setWebhookAllowNetworks(cfg *Config, value string) error {
+	networks := splitList(value)
+	for _, network := range networks {
+		if _, err := netip.ParsePrefix(network); err != nil {
+			return err
+		}
+	}
+	cfg.WebhookAllowNetworks = networks
+	return nil
+}
+
+// webhookAllowNetworks returns the parsed WebhookAllowNetworks.
+func webhookAllowNetworks(cfg Config) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, network := range cfg.WebhookAllowNetworks {
+		prefix, _ := netip.ParsePrefix(network) // Validated when it was set
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+func setMaxTotalMegapixels(cfg *Config, value string) error {
+	megapixels, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if megapixels < 0 {
+		return errors.New("must not be negative")
+	}
+	cfg.MaxTotalMegapixels = megapixels
+	return nil
+}
+
+func setDecodeLimits(cfg *Config, value string) error {
+	limits, err := converter.ParseDecodeLimits(value)
+	if err != nil {
+		return err
+	}
+	cfg.DecodeLimits = limits
+	return nil
+}
+
+func setUploadTypes(cfg *Config, value string) error {
+	types := splitList(value)
+	if err := api.ValidateUploadTypes(types); err != nil {
+		return err
+	}
+	cfg.UploadTypes = types
+	return nil
+}
+
+// addNamePattern adds the -include or -exclude pattern to patterns.
+func addNamePattern(patterns *[]string, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	*patterns = append(*patterns, pattern)
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// byteSize is a size in bytes that can be written as a plain number or with
+// a binary unit suffix ("512KB", "64MB", "1.5GB"). It marshals to JSON in
+// the same human-readable form.
+type byteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// Set parses a size string into b.
+func (b *byteSize) Set(value string) error {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1.0
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*b = byteSize(n * multiplier)
+	return nil
+}
+
+func (b byteSize) String() string {
+	switch {
+	case b == 0:
+		return "0"
+	case b%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", b>>30)
+	case b%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", b>>20)
+	case b%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", b>>10)
+	default:
+		return strconv.FormatInt(int64(b), 10)
+	}
+}
+
+func (b byteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *byteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		*b = byteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("size must be a number or a string like \"64MB\"")
+	}
+	return b.Set(s)
+}
+
+// duration is a time.Duration written as a Go duration string ("750ms",
+// "30s", "2m"), both in the environment and in the config file.
+type duration time.Duration
+
+// Set parses a duration string into d. A bare "0" is accepted.
+func (d *duration) Set(value string) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	return d.Set(s)
+}
+
+// fileMode is a file permission mode written in octal ("0660"), both in the
+// environment and in the config file.
+type fileMode os.FileMode
+
+// Set parses an octal mode into m.
+func (m *fileMode) Set(value string) error {
+	n, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+	if err != nil || n > 0o777 {
+		return fmt.Errorf("invalid file mode %q", value)
+	}
+	*m = fileMode(n)
+	return nil
+}
+
+func (m fileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+func (m fileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *fileMode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("file mode must be an octal string like \"0660\"")
+	}
+	return m.Set(s)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultsFileNames are looked for, in this order, in the manga_to_pdf
+// directory of the user's config directory.
+var defaultsFileNames = []string{"config.yaml", "config.yml", "config.toml"}
+
+// defaultsFile returns the user's defaults file,
+// $XDG_CONFIG_HOME/manga_to_pdf/config.yaml (~/.config by default) or one of
+// the other defaultsFileNames, or "" if there is none.
+func defaultsFile(getenv func(string) string) string {
+	dir := getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := getenv("HOME")
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	for _, name := range defaultsFileNames {
+		if path := filepath.Join(dir, "manga_to_pdf", name); fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// flagEnvName is the environment variable giving a default for the flag
+// name, e.g. MTP_JPEG_QUALITY for -jpeg-quality.
+func flagEnvName(name string) string {
+	return "MTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyFlagDefaults sets the flags of flagSet that were not on the command
+// line from their MTP_ environment variable or, without one, from the
+// user's defaults file, so settings used for every run need not be typed
+// each time. Keys of the defaults file that are flags of another command
+// only are ignored; keys that are no flag at all are an error.
+func applyFlagDefaults(flagSet *flag.FlagSet, getenv func(string) string) error {
+	path := defaultsFile(getenv)
+	var settings map[string]string
+	if path != "" {
+		var err error
+		if settings, err = readDefaultsFile(path); err != nil {
+			return err
+		}
+		for name := range settings {
+			if !isConfigFlag(name) {
+				return fmt.Errorf("defaults file %s: unknown setting %q", path, name)
+			}
+		}
+	}
+
+	given := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		if value := getenv(flagEnvName(f.Name)); value != "" {
+			if setErr := flagSet.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", flagEnvName(f.Name), setErr)
+			}
+			return
+		}
+		if value, ok := settings[f.Name]; ok {
+			if setErr := flagSet.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("defaults file %s: invalid %s: %w", path, f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// isConfigFlag reports whether name is a flag of convert or serve.
+func isConfigFlag(name string) bool {
+	var (
+		cfg       Config
+		path      string
+		printOnly bool
+		overrides []func(*Config) error
+	)
+	return configFlagSet(commandAny, &cfg, &path, &printOnly, &overrides).Lookup(name) != nil
+}
+
+// readDefaultsFile reads the settings of a defaults file, keyed by flag
+// name. The file holds one setting per line, "key: value" in YAML or
+// "key = value" in TOML (.toml files), with flag names as keys in which _
+// may stand for -. Comments and quoted values are understood; sections,
+// nested settings and lists are not, as no flag needs them.
+func readDefaultsFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read defaults file: %w", err)
+	}
+	separator := ":"
+	if filepath.Ext(path) == ".toml" {
+		separator = "="
+	}
+	settings := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' || trimmed[0] == '-' || trimmed[0] == '[' {
+			return nil, fmt.Errorf("defaults file %s:%d: sections, nested settings and lists are not supported", path, i+1)
+		}
+		key, value, ok := strings.Cut(trimmed, separator)
+		if !ok {
+			return nil, fmt.Errorf("defaults file %s:%d: expected key%s value", path, i+1, separator)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if value, err = settingValue(strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("defaults file %s:%d: %s: %w", path, i+1, key, err)
+		}
+		if _, dup := settings[key]; dup {
+			return nil, fmt.Errorf("defaults file %s:%d: %s is set twice", path, i+1, key)
+		}
+		settings[key] = value
+	}
+	return settings, nil
+}
+
+// settingValue returns the value of a setting as written after its key:
+// a double-quoted string with escapes, a single-quoted literal or a bare
+// value, followed by an optional comment.
+func settingValue(raw string) (string, error) {
+	var value, rest string
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case raw[0] == '"':
+		quoted, err := strconv.QuotedPrefix(raw)
+		if err != nil {
+			return "", errors.New("unterminated string")
+		}
+		if value, err = strconv.Unquote(quoted); err != nil {
+			return "", err
+		}
+		rest = raw[len(quoted):]
+	case raw[0] == '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated string")
+		}
+		value, rest = raw[1:end+1], raw[end+2:]
+	default:
+		value, _, _ = strings.Cut(raw, " #")
+		return strings.TrimSpace(value), nil
+	}
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return "", fmt.Errorf("unexpected %q after the value", rest)
+	}
+	return value, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCommandConfig_Defaults(t *testing.T) {
+	home := t.TempDir()
+	dir := filepath.Join(home, ".config", "manga_to_pdf")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeDefaults := func(name, content string) {
+		t.Helper()
+		for _, old := range defaultsFileNames {
+			os.Remove(filepath.Join(dir, old))
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeDefaults("config.yaml", `# Settings for every run
+jpeg_quality: 70
+profile: "kindle-paperwhite" # Quoted
+rtl: true
+title: 'Vol: 1'
+listen: :9000
+`)
+	env := map[string]string{"HOME": home}
+	cfg, _, err := loadCommandConfig(commandConvert, []string{"./vol"}, envMap(env), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("loadCommandConfig failed: %v", err)
+	}
+	if cfg.JPEGQuality != 70 || cfg.Profile != "kindle-paperwhite" || !cfg.RTL || cfg.Title != "Vol: 1" {
+		t.Errorf("Defaults file not applied: quality %d, profile %q, rtl %t, title %q", cfg.JPEGQuality, cfg.Profile, cfg.RTL, cfg.Title)
+	}
+
+	// The environment wins over the file, and the command line over both.
+	env["MTP_JPEG_QUALITY"] = "80"
+	env["MTP_PROFILE"] = "kobo-clara"
+	cfg, _, err = loadCommandConfig(commandConvert, []string{"-profile", "ipad", "./vol"}, envMap(env), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("loadCommandConfig failed: %v", err)
+	}
+	if cfg.JPEGQuality != 80 || cfg.Profile != "ipad" {
+		t.Errorf("Expected quality 80 from the environment and the profile flag, got %d and %q", cfg.JPEGQuality, cfg.Profile)
+	}
+
+	// Settings of other commands are left to them.
+	cfg, _, err = loadCommandConfig(commandServe, nil, envMap(env), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("serve with conversion defaults failed: %v", err)
+	}
+	if cfg.ListenAddress != ":9000" {
+		t.Errorf("Expected the listen address of the defaults file, got %q", cfg.ListenAddress)
+	}
+
+	writeDefaults("config.toml", "jpeg-quality = 65\ngrayscale = true\n")
+	delete(env, "MTP_JPEG_QUALITY")
+	env["XDG_CONFIG_HOME"] = filepath.Join(home, ".config")
+	cfg, _, err = loadCommandConfig(commandConvert, []string{"./vol"}, envMap(env), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("loadCommandConfig with TOML failed: %v", err)
+	}
+	if cfg.JPEGQuality != 65 || !cfg.Grayscale {
+		t.Errorf("TOML defaults not applied: quality %d, grayscale %t", cfg.JPEGQuality, cfg.Grayscale)
+	}
+
+	for _, content := range []string{
+		"no_such_setting = 1\n",
+		"jpeg_quality = high\n",
+		"jpeg_quality = 60\njpeg_quality = 70\n",
+		"[convert]\njpeg_quality = 60\n",
+		"title = \"unterminated\n",
+	} {
+		writeDefaults("config.toml", content)
+		if _, _, err := loadCommandConfig(commandConvert, []string{"./vol"}, envMap(env), &bytes.Buffer{}); err == nil {
+			t.Errorf("Expected defaults file %q to be rejected", content)
+		}
+	}
+	writeDefaults("config.toml", "")
+	env["MTP_WORKERS"] = "none"
+	if _, _, err := loadCommandConfig(commandConvert, []string{"./vol"}, envMap(env), &bytes.Buffer{}); err == nil {
+		t.Error("Expected an invalid MTP_WORKERS to be rejected")
+	}
+}
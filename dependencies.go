@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"manga_to_pdf/api"
+)
+
+// dependencyChecks lists the checks for everything the configured server
+// relies on: its writable directories, the ACME directory when automatic
+// certificates are enabled, and any external services from DependencyURLs.
+func dependencyChecks(cfg Config) []api.DependencyCheck {
+	var checks []api.DependencyCheck
+	dirs := writableDirs(cfg)
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checks = append(checks, api.WritableDirCheck(name, dirs[name]))
+	}
+	if len(cfg.AutocertDomains) > 0 {
+		checks = append(checks, api.HTTPCheck("acme", autocert.DefaultACMEDirectory))
+	}
+	for _, entry := range cfg.DependencyURLs {
+		name, url, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, url = entry, entry
+		}
+		checks = append(checks, api.HTTPCheck(name, url))
+	}
+	return checks
+}
+
+// printDependencyInfo runs every dependency check once and writes the
+// results as a table. It reports whether all checks passed.
+func printDependencyInfo(ctx context.Context, w io.Writer, cfg Config) bool {
+	monitor := api.NewHealthMonitor(dependencyChecks(cfg), 0)
+	statuses := monitor.CheckNow(ctx)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEPENDENCY\tSTATUS\tLATENCY\tERROR")
+	allOK := true
+	for _, status := range statuses {
+		state := "ok"
+		if !status.OK {
+			state = "FAIL"
+			allOK = false
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%dms\t%s\n", status.Name, state, status.LatencyMS, status.Error)
+	}
+	tw.Flush()
+	return allOK
+}
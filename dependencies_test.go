@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrintDependencyInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cfg := Config{DataDir: t.TempDir(), DependencyURLs: []string{"ocr=" + server.URL}}
+	resolvePaths(&cfg)
+	if err := checkWritableDirs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if !printDependencyInfo(context.Background(), &out, cfg) {
+		t.Errorf("Expected all dependencies to pass:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "ocr") || !strings.Contains(out.String(), "data_dir") {
+		t.Errorf("Expected every dependency in the output:\n%s", out.String())
+	}
+
+	cfg.DependencyURLs = append(cfg.DependencyURLs, "http://127.0.0.1:1")
+	out.Reset()
+	if printDependencyInfo(context.Background(), &out, cfg) {
+		t.Errorf("Expected an unreachable dependency to fail:\n%s", out.String())
+	}
+}
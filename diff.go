@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sort"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// diffDocument is a converted PDF or CBZ loaded for comparison.
+type diffDocument struct {
+	Path     string
+	Pages    []diffPage
+	Metadata map[string]string // PDF info dictionary entries
+}
+
+// diffPage is one page of a diffDocument.
+type diffPage struct {
+	Width, Height int
+	Hash          uint64 // converter.PerceptualHash of the page image
+	Err           error  // The page image could not be decoded
+}
+
+// runDiffCommand compares the two documents named in args and prints their
+// differences to out. Like diff(1) it returns 0 when they match, 1 when they
+// differ and 2 on errors.
+func runDiffCommand(args []string, out, errOut io.Writer) int {
+	flagSet := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: manga_to_pdf diff [-threshold N] old.pdf|old.cbz new.pdf|new.cbz")
+		flagSet.PrintDefaults()
+	}
+	threshold := flagSet.Int("threshold", 6, "Perceptual hash bits (of 64) two pages may differ by and still count as the same picture")
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flagSet.NArg() != 2 || *threshold < 0 {
+		flagSet.Usage()
+		return 2
+	}
+
+	var docs [2]*diffDocument
+	for i, path := range flagSet.Args() {
+		doc, err := loadDiffDocument(path)
+		if err != nil {
+			fmt.Fprintln(errOut, "diff:", err)
+			return 2
+		}
+		docs[i] = doc
+	}
+	differences := compareDocuments(docs[0], docs[1], *threshold)
+	for _, doc := range docs {
+		fmt.Fprintf(out, "%s: %d pages\n", doc.Path, len(doc.Pages))
+	}
+	if len(differences) == 0 {
+		fmt.Fprintln(out, "No differences")
+		return 0
+	}
+	for _, difference := range differences {
+		fmt.Fprintln(out, difference)
+	}
+	return 1
+}
+
+// loadDiffDocument reads a PDF, or a CBZ/ZIP archive of images, and hashes
+// every page.
+func loadDiffDocument(path string) (*diffDocument, error) {
+	doc := &diffDocument{Path: path, Metadata: map[string]string{}}
+	if isArchive(path) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		sources, err := converter.ArchiveSources(file, info.Size(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, src := range sources {
+			img, _, err := image.Decode(src.Reader)
+			src.Reader.Close()
+			doc.Pages = append(doc.Pages, newDiffPage(img, err))
+		}
+		return doc, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pdf, err := converter.ReadPDF(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc.Metadata = pdf.Info
+	for i := 0; i < pdf.NumPages(); i++ {
+		doc.Pages = append(doc.Pages, newDiffPage(pdf.PageImage(i)))
+	}
+	return doc, nil
+}
+
+func newDiffPage(img image.Image, err error) diffPage {
+	if err != nil {
+		return diffPage{Err: err}
+	}
+	return diffPage{Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), Hash: converter.PerceptualHash(img)}
+}
+
+// compareDocuments lists the differences between a and b: metadata, page
+// count, and pages whose pixel size changed or whose perceptual hashes are
+// more than threshold bits apart.
+func compareDocuments(a, b *diffDocument, threshold int) []string {
+	var differences []string
+	keys := map[string]bool{}
+	for key := range a.Metadata {
+		keys[key] = true
+	}
+	for key := range b.Metadata {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		if a.Metadata[key] != b.Metadata[key] {
+			differences = append(differences, fmt.Sprintf("metadata %s: %q -> %q", key, a.Metadata[key], b.Metadata[key]))
+		}
+	}
+
+	if len(a.Pages) != len(b.Pages) {
+		differences = append(differences, fmt.Sprintf("page count: %d -> %d", len(a.Pages), len(b.Pages)))
+	}
+	for i := 0; i < min(len(a.Pages), len(b.Pages)); i++ {
+		pa, pb := a.Pages[i], b.Pages[i]
+		switch {
+		case pa.Err != nil || pb.Err != nil:
+			differences = append(differences, fmt.Sprintf("page %d: could not compare: %s", i+1, errors.Join(pa.Err, pb.Err)))
+		case pa.Width != pb.Width || pa.Height != pb.Height:
+			differences = append(differences, fmt.Sprintf("page %d: size %dx%d -> %dx%d", i+1, pa.Width, pa.Height, pb.Width, pb.Height))
+		default:
+			if d := converter.HashDistance(pa.Hash, pb.Hash); d > threshold {
+				differences = append(differences, fmt.Sprintf("page %d: content differs (perceptual hash distance %d)", i+1, d))
+			}
+		}
+	}
+	for i := len(b.Pages); i < len(a.Pages); i++ {
+		differences = append(differences, fmt.Sprintf("page %d: only in %s", i+1, a.Path))
+	}
+	for i := len(a.Pages); i < len(b.Pages); i++ {
+		differences = append(differences, fmt.Sprintf("page %d: only in %s", i+1, b.Path))
+	}
+	return differences
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func diffTestPage(t *testing.T, flip bool) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			v := uint8(x * 8)
+			if flip {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeDiffPDF(t *testing.T, path, title string, pages ...[]byte) {
+	t.Helper()
+	var sources []converter.ImageSource
+	for i, page := range pages {
+		sources = append(sources, converter.ImageSource{
+			OriginalFilename: "page.png",
+			Reader:           io.NopCloser(bytes.NewReader(page)),
+			ContentType:      "image/png",
+			Index:            i,
+		})
+	}
+	cfg := converter.NewDefaultConfig()
+	cfg.Title = title
+	var out bytes.Buffer
+	if _, err := converter.ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunDiffCommand(t *testing.T) {
+	dir := t.TempDir()
+	a, b := diffTestPage(t, false), diffTestPage(t, true)
+	writeDiffPDF(t, filepath.Join(dir, "old.pdf"), "Vol 1", a, b)
+	writeDiffPDF(t, filepath.Join(dir, "same.pdf"), "Vol 1", a, b)
+	writeDiffPDF(t, filepath.Join(dir, "new.pdf"), "Volume 1", b, b, a)
+
+	cbz, err := os.Create(filepath.Join(dir, "old.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(cbz)
+	for i, page := range [][]byte{a, b} {
+		w, err := zw.Create([]string{"001.png", "002.png"}[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(page)
+	}
+	zw.Close()
+	cbz.Close()
+
+	var out, errOut bytes.Buffer
+	if code := runDiffCommand([]string{filepath.Join(dir, "old.pdf"), filepath.Join(dir, "same.pdf")}, &out, &errOut); code != 0 {
+		t.Errorf("Expected identical PDFs to match, got %d:\n%s%s", code, out.String(), errOut.String())
+	}
+
+	out.Reset()
+	if code := runDiffCommand([]string{"-threshold", "4", filepath.Join(dir, "old.cbz"), filepath.Join(dir, "old.pdf")}, &out, &errOut); code != 1 {
+		t.Errorf("Expected only the metadata to differ between the CBZ and its PDF, got %d:\n%s", code, out.String())
+	} else if strings.Contains(out.String(), "page ") {
+		t.Errorf("Expected the CBZ pages to match the PDF pages:\n%s", out.String())
+	}
+
+	out.Reset()
+	if code := runDiffCommand([]string{filepath.Join(dir, "old.pdf"), filepath.Join(dir, "new.pdf")}, &out, &errOut); code != 1 {
+		t.Fatalf("Expected differences, got %d", code)
+	}
+	for _, want := range []string{`metadata Title: "Vol 1" -> "Volume 1"`, "page count: 2 -> 3", "page 1: content differs", "page 3: only in"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the report:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "page 2:") {
+		t.Errorf("Expected page 2 to match:\n%s", out.String())
+	}
+
+	if code := runDiffCommand([]string{filepath.Join(dir, "missing.pdf"), filepath.Join(dir, "old.pdf")}, &out, &errOut); code != 2 {
+		t.Errorf("Expected exit code 2 for a missing file, got %d", code)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The end-to-end tests build the real binary and drive it as users do: a
+// server on a random port over HTTP, synchronously through /convert and
+// asynchronously through /jobs, and the CLI on a CBZ. They are skipped
+// with -short.
+
+var (
+	buildOnce sync.Once
+	binPath   string
+	buildErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if binPath != "" {
+		os.RemoveAll(filepath.Dir(binPath))
+	}
+	os.Exit(code)
+}
+
+// buildBinary compiles the server once per test run.
+func buildBinary(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping end-to-end test in short mode")
+	}
+	buildOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "manga_to_pdf-e2e")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		binPath = filepath.Join(dir, "manga_to_pdf")
+		if out, err := exec.Command("go", "build", "-o", binPath, ".").CombinedOutput(); err != nil {
+			buildErr = fmt.Errorf("%v: %s", err, out)
+		}
+	})
+	if buildErr != nil {
+		t.Fatalf("Failed to build the binary: %v", buildErr)
+	}
+	return binPath
+}
+
+var listeningRE = regexp.MustCompile(`msg="Server is listening" address=(\S+)`)
+
+// startServer runs the binary as a server on a random local port and
+// returns its base URL. The server is interrupted when the test ends.
+func startServer(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(buildBinary(t), append([]string{"-listen", "127.0.0.1:0", "-data-dir", t.TempDir()}, args...)...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			cmd.Process.Kill()
+		}
+		cmd.Wait()
+	})
+
+	addr := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if m := listeningRE.FindStringSubmatch(scanner.Text()); m != nil {
+				addr <- m[1]
+				break
+			}
+		}
+		io.Copy(io.Discard, stderr) // Keep the server from blocking on its log
+	}()
+	select {
+	case a := <-addr:
+		return "http://" + a
+	case <-time.After(30 * time.Second):
+		t.Fatal("Server did not report its address in time")
+		return ""
+	}
+}
+
+var pdfPageRE = regexp.MustCompile(`/Type /Page[^s]`)
+
+// checkPDF fails the test unless data is a complete PDF with pages pages.
+func checkPDF(t *testing.T, data []byte, pages int) {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-")) || !bytes.Contains(data[max(len(data)-32, 0):], []byte("%%EOF")) {
+		t.Fatalf("Not a complete PDF (%d bytes)", len(data))
+	}
+	if n := len(pdfPageRE.FindAll(data, -1)); n != pages {
+		t.Errorf("Expected %d pages, found %d", pages, n)
+	}
+}
+
+// testImage encodes a small non-trivial image as PNG or JPEG.
+func testImage(t *testing.T, format string, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestE2E_ServerConvert(t *testing.T) {
+	base := startServer(t)
+
+	for _, endpoint := range []string{"/health", "/readyz"} {
+		resp, err := http.Get(base + endpoint)
+		if err != nil {
+			t.Fatalf("GET %s: %v", endpoint, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: expected 200, got %d", endpoint, resp.StatusCode)
+		}
+	}
+
+	remotePage := testImage(t, "jpeg", 40, 60)
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(remotePage)
+	}))
+	defer images.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, data := range map[string][]byte{"001.png": testImage(t, "png", 30, 40), "002.jpg": testImage(t, "jpeg", 50, 70)} {
+		part, err := mw.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	mw.WriteField("image_urls", fmt.Sprintf(`["%s/003.jpg"]`, images.URL))
+	mw.WriteField("config", `{"output_filename": "e2e.pdf"}`)
+	mw.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/convert", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /convert: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, data)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "e2e.pdf") {
+		t.Errorf("Unexpected Content-Disposition %q", cd)
+	}
+	checkPDF(t, data, 3)
+}
+
+func TestE2E_ServerJob(t *testing.T) {
+	base := startServer(t, "-job-storage", "local")
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, name := range []string{"001.png", "002.png"} {
+		part, err := mw.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(testImage(t, "png", 30, 40))
+	}
+	mw.WriteField("config", `{"output_filename": "job.pdf"}`)
+	mw.Close()
+	resp, err := http.Post(base+"/jobs", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("POST /jobs: %v", err)
+	}
+	var status struct {
+		ID       string `json:"id"`
+		Status   string `json:"status"`
+		Download string `json:"download_url"`
+		Error    string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || status.ID == "" {
+		t.Fatalf("Expected 202 with a job id, got %d %+v", resp.StatusCode, status)
+	}
+
+	// Poll the job until it is no longer running.
+	deadline := time.Now().Add(30 * time.Second)
+	for status.Status == "running" {
+		if time.Now().After(deadline) {
+			t.Fatal("Job did not finish in time")
+		}
+		time.Sleep(50 * time.Millisecond)
+		resp, err := http.Get(base + "/jobs/" + status.ID)
+		if err != nil {
+			t.Fatalf("GET /jobs/%s: %v", status.ID, err)
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+	}
+	if status.Status != "done" || status.Download != "/jobs/"+status.ID+"/result" {
+		t.Fatalf("Expected a done job with a result URL, got %+v", status)
+	}
+
+	resp, err = http.Get(base + status.Download)
+	if err != nil {
+		t.Fatalf("GET %s: %v", status.Download, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for the result, got %d: %s", resp.StatusCode, data)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, "job.pdf") {
+		t.Errorf("Unexpected Content-Disposition %q", cd)
+	}
+	checkPDF(t, data, 2)
+}
+
+func TestE2E_CLIArchive(t *testing.T) {
+	bin := buildBinary(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "volume.cbz")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	for i, name := range []string{"p1.png", "p2.jpg", "p10.png"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		format := "jpeg"
+		if filepath.Ext(name) == ".png" {
+			format = "png"
+		}
+		w.Write(testImage(t, format, 20+i, 30))
+	}
+	zw.Close()
+	file.Close()
+
+	if out, err := exec.Command(bin, "-i", input).CombinedOutput(); err != nil {
+		t.Fatalf("CLI conversion failed: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "volume.pdf"))
+	if err != nil {
+		t.Fatalf("Expected volume.pdf: %v", err)
+	}
+	checkPDF(t, data, 3)
+}
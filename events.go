@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// Lifecycle steps written to -events-file, in the order a run goes
+// through them.
+const (
+	eventScan      = "scan"      // The input was scanned: chapters and pages found
+	eventFetch     = "fetch"     // An image URL was downloaded
+	eventDecode    = "decode"    // A page was read and decoded
+	eventTransform = "transform" // A page was transformed and encoded, or passed through
+	eventEmbed     = "embed"     // A page was added to the output document
+	eventWrite     = "write"     // An output file was written
+	eventWarning   = "warning"   // Something was logged at warning level or above
+	eventDone      = "done"      // The run finished, with its error if it failed
+)
+
+// conversionEvent is one line of -events-file.
+type conversionEvent struct {
+	Time       time.Time      `json:"time"`
+	Event      string         `json:"event"`
+	Input      string         `json:"input,omitempty"`
+	Output     string         `json:"output,omitempty"`
+	Index      *int           `json:"index,omitempty"` // Page position in its conversion
+	Filename   string         `json:"filename,omitempty"`
+	Chapters   int            `json:"chapters,omitempty"`
+	Pages      int            `json:"pages,omitempty"`
+	Bytes      int64          `json:"bytes,omitempty"`
+	DurationMS int64          `json:"duration_ms,omitempty"`
+	Level      string         `json:"level,omitempty"` // Of warnings: WARN or ERROR
+	Message    string         `json:"message,omitempty"`
+	Attrs      map[string]any `json:"attrs,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// eventLog writes the lifecycle events of a run as newline-delimited JSON,
+// one object per line, for dashboards that follow long runs and for
+// post-mortems. Its methods are safe for concurrent use, and do nothing on
+// a nil *eventLog.
+type eventLog struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+	err  error // First write error; later events are dropped
+}
+
+// openEventLog creates, or appends to, the events file at path.
+func openEventLog(path string) (*eventLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open events file: %w", err)
+	}
+	return &eventLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// emit writes event, stamped with the current time.
+func (l *eventLog) emit(event conversionEvent) {
+	if l == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = l.enc.Encode(event)
+	}
+}
+
+// progressEvents maps the converter's progress stages to events. Failed
+// pages have none: the converter logs them as warnings.
+var progressEvents = map[converter.ProgressStage]string{
+	converter.ProgressFetched:     eventFetch,
+	converter.ProgressDecoded:     eventDecode,
+	converter.ProgressTransformed: eventTransform,
+	converter.ProgressPageAdded:   eventEmbed,
+}
+
+// progress writes the event for a converter progress event, if it has one.
+func (l *eventLog) progress(ev converter.ProgressEvent) {
+	name := progressEvents[ev.Stage]
+	if name == "" {
+		return
+	}
+	index := ev.Index
+	l.emit(conversionEvent{Event: name, Index: &index, Filename: ev.Filename, Bytes: ev.Bytes})
+}
+
+// captureWarnings also writes everything logged at warning level or above
+// through the default logger as warning events, until the returned
+// function restores the previous logger. The default logger must be one
+// set up with slog.SetDefault, as main does: slog's built-in one writes
+// through package log, which would loop back into the wrapper.
+func (l *eventLog) captureWarnings() (restore func()) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(&eventsHandler{Handler: previous.Handler(), log: l}))
+	return func() { slog.SetDefault(previous) }
+}
+
+// Close closes the file and reports the first error writing to it.
+func (l *eventLog) Close() error {
+	err := l.file.Close()
+	if l.err != nil {
+		return fmt.Errorf("could not write events file: %w", l.err)
+	}
+	return err
+}
+
+// eventsHandler passes records on to Handler and writes warnings to log.
+type eventsHandler struct {
+	slog.Handler
+	log   *eventLog
+	attrs []slog.Attr
+}
+
+func (h *eventsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.Handler.Enabled(ctx, level)
+}
+
+func (h *eventsHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		attrs := make(map[string]any, len(h.attrs)+r.NumAttrs())
+		for _, a := range h.attrs {
+			attrs[a.Key] = attrValue(a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = attrValue(a)
+			return true
+		})
+		h.log.emit(conversionEvent{Event: eventWarning, Level: r.Level.String(), Message: r.Message, Attrs: attrs})
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// attrValue returns the value of a for JSON encoding.
+func attrValue(a slog.Attr) any {
+	value := a.Value.Resolve().Any()
+	if err, ok := value.(error); ok {
+		return err.Error() // Most errors marshal as {}
+	}
+	return value
+}
+
+func (h *eventsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventsHandler{Handler: h.Handler.WithAttrs(attrs), log: h.log, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *eventsHandler) WithGroup(name string) slog.Handler {
+	return &eventsHandler{Handler: h.Handler.WithGroup(name), log: h.log, attrs: h.attrs}
+}
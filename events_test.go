@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunApp_EventsFile(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	writePNG(t, filepath.Join(dir, "2.png"))
+	if err := os.WriteFile(filepath.Join(dir, "3.png"), []byte("not a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Wrapping slog's built-in handler deadlocks, so install one as main does.
+	originalLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer slog.SetDefault(originalLogger)
+
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.EventsFile = filepath.Join(t.TempDir(), "events.ndjson")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -events-file failed: %v", err)
+	}
+
+	file, err := os.Open(cfg.EventsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []conversionEvent
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event conversionEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid event line %q: %v", scanner.Text(), err)
+		}
+		if event.Time.IsZero() {
+			t.Errorf("Expected a timestamp on %q", scanner.Text())
+		}
+		events = append(events, event)
+		counts[event.Event]++
+	}
+	if len(events) < 2 || events[0].Event != eventScan || events[0].Pages != 3 || events[len(events)-1].Event != eventDone {
+		t.Fatalf("Expected a scan of 3 pages first and done last, got %+v", events)
+	}
+	for name, want := range map[string]int{eventDecode: 2, eventTransform: 2, eventEmbed: 2, eventWrite: 1} {
+		if counts[name] != want {
+			t.Errorf("Expected %d %s events, got %d", want, name, counts[name])
+		}
+	}
+	if counts[eventWarning] == 0 {
+		t.Error("Expected the broken page to be reported as a warning")
+	}
+	if done := events[len(events)-1]; done.Error != "" {
+		t.Errorf("Expected done without an error, got %q", done.Error)
+	}
+}
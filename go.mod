@@ -7,5 +7,9 @@ toolchain go1.23.6
 require (
 	github.com/disintegration/imaging v1.6.2
 	github.com/jung-kurt/gofpdf v1.0.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.36.0
 	golang.org/x/image v0.28.0
+	golang.org/x/net v0.38.0
+	golang.org/x/text v0.26.0
 )
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// Hook statuses passed to -post-cmd in MANGA_TO_PDF_STATUS.
+const (
+	hookSuccess = "success"
+	hookFailed  = "failed"
+	hookSkipped = "skipped"
+)
+
+// runHook runs a -pre-cmd or -post-cmd command through the shell, with env
+// added to its environment, its standard output written to stdout and its
+// errors passed through. An empty command does nothing.
+func runHook(ctx context.Context, name, command string, env []string, stdout io.Writer) error {
+	if command == "" {
+		return nil
+	}
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = stdout, os.Stderr
+	slog.Debug("Running hook", "hook", name, "command", command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("-%s %q failed: %w", name, command, err)
+	}
+	return nil
+}
+
+// hookEnv describes a conversion to its hooks: the input, the output and,
+// after the conversion, its status with the page count or error.
+func hookEnv(cfg Config, output, status string, pages int, convErr error) []string {
+	env := []string{"MANGA_TO_PDF_INPUT=" + cfg.Input, "MANGA_TO_PDF_OUTPUT=" + output}
+	if status != "" {
+		env = append(env, "MANGA_TO_PDF_STATUS="+status, "MANGA_TO_PDF_PAGES="+strconv.Itoa(pages))
+	}
+	if convErr != nil {
+		env = append(env, "MANGA_TO_PDF_ERROR="+convErr.Error())
+	}
+	return env
+}
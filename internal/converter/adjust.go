@@ -0,0 +1,69 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrInvalidAdjustments is returned for image adjustments out of range.
+var ErrInvalidAdjustments = errors.New("invalid adjust")
+
+// Adjustments are tonal corrections applied to every page with the imaging
+// package, after auto levels and resizing: brightness, then contrast, then
+// gamma, then sharpening. Zero values leave a page as it is; faded scans
+// usually need a contrast boost to read well on e-ink.
+type Adjustments struct {
+	Brightness float64 `json:"brightness,omitempty"` // Percent, -100 (black) to 100 (white)
+	Contrast   float64 `json:"contrast,omitempty"`   // Percent, -100 (flat gray) to 100
+	Gamma      float64 `json:"gamma,omitempty"`      // Below 1 darkens the midtones, above 1 lightens them; 0 or 1 leaves them
+	Sharpen    float64 `json:"sharpen,omitempty"`    // Sigma of the unsharp mask in pixels, 0 for none
+}
+
+func (a *Adjustments) validate() error {
+	switch {
+	case a.Brightness < -100 || a.Brightness > 100:
+		return fmt.Errorf("%w: brightness %v is not between -100 and 100", ErrInvalidAdjustments, a.Brightness)
+	case a.Contrast < -100 || a.Contrast > 100:
+		return fmt.Errorf("%w: contrast %v is not between -100 and 100", ErrInvalidAdjustments, a.Contrast)
+	case a.Gamma < 0:
+		return fmt.Errorf("%w: gamma %v is negative", ErrInvalidAdjustments, a.Gamma)
+	case a.Sharpen < 0:
+		return fmt.Errorf("%w: sharpen %v is negative", ErrInvalidAdjustments, a.Sharpen)
+	}
+	return nil
+}
+
+// active reports whether a changes pages at all.
+func (a *Adjustments) active() bool {
+	return a != nil && (a.Brightness != 0 || a.Contrast != 0 || (a.Gamma != 0 && a.Gamma != 1) || a.Sharpen != 0)
+}
+
+// apply returns img with the adjustments made. Grayscale images stay
+// grayscale. If a changes nothing, img itself and false are returned.
+func (a *Adjustments) apply(img image.Image) (image.Image, bool) {
+	if !a.active() {
+		return img, false
+	}
+	adjusted := img
+	if a.Brightness != 0 {
+		adjusted = imaging.AdjustBrightness(adjusted, a.Brightness)
+	}
+	if a.Contrast != 0 {
+		adjusted = imaging.AdjustContrast(adjusted, a.Contrast)
+	}
+	if a.Gamma != 0 && a.Gamma != 1 {
+		adjusted = imaging.AdjustGamma(adjusted, a.Gamma)
+	}
+	if a.Sharpen != 0 {
+		adjusted = imaging.Sharpen(adjusted, a.Sharpen)
+	}
+	if _, gray := img.(*image.Gray); gray {
+		// imaging works in NRGBA; the page goes back to one channel so it
+		// is not encoded as colour.
+		return toGray(adjusted), true
+	}
+	return adjusted, true
+}
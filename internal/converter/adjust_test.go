@@ -0,0 +1,81 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// fadedPage returns a grayscale page whose left half is gray ink at level
+// 100 and right half paper at level 180, like a faded scan.
+func fadedPage(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(180)
+			if x < w/2 {
+				v = 100
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func TestAdjustmentsApply(t *testing.T) {
+	img := fadedPage(40, 20)
+	adjusted, ok := (&Adjustments{Contrast: 50}).apply(img)
+	if !ok {
+		t.Fatal("Expected a contrast boost to change the page")
+	}
+	gray, isGray := adjusted.(*image.Gray)
+	if !isGray {
+		t.Fatalf("Expected a grayscale page to stay grayscale, got %T", adjusted)
+	}
+	ink, paper := gray.GrayAt(5, 5).Y, gray.GrayAt(35, 5).Y
+	if ink >= 100 || paper <= 180 {
+		t.Errorf("Expected ink darker than 100 and paper lighter than 180, got %d and %d", ink, paper)
+	}
+
+	adjusted, _ = (&Adjustments{Gamma: 2}).apply(img)
+	if v := adjusted.(*image.Gray).GrayAt(5, 5).Y; v <= 100 {
+		t.Errorf("Expected a gamma above 1 to lighten the midtones, got %d", v)
+	}
+
+	if adjusted, ok := (&Adjustments{Gamma: 1}).apply(img); ok || adjusted != image.Image(img) {
+		t.Error("Expected a gamma of 1 to leave the page as it is")
+	}
+	var none *Adjustments
+	if _, ok := none.apply(img); ok {
+		t.Error("Expected no adjustments to leave the page as it is")
+	}
+}
+
+func TestProcessImage_Adjust(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, fadedPage(40, 20)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewDefaultConfig()
+	cfg.Adjust = &Adjustments{Contrast: 40, Sharpen: 1}
+	src := ImageSource{OriginalFilename: "faded.png", ContentType: "image/png", Reader: io.NopCloser(bytes.NewReader(buf.Bytes()))}
+	page, err := ProcessImage(context.Background(), cfg, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.PassedThrough {
+		t.Error("Expected an adjusted page to be re-encoded")
+	}
+
+	for _, adjust := range []Adjustments{{Brightness: 101}, {Contrast: -120}, {Gamma: -1}, {Sharpen: -2}} {
+		cfg.Adjust = &adjust
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidAdjustments) {
+			t.Errorf("Expected ErrInvalidAdjustments for %+v, got %v", adjust, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// mayHaveAlpha reports whether a decoded source may have transparent
+// pixels. For pass-through PNG data only the header is read, so an opaque
+// PNG with an alpha channel still reports true.
+func mayHaveAlpha(decoded decodedSource) bool {
+	if decoded.Raw == nil {
+		return decoded.Image != nil && !isOpaque(decoded.Image)
+	}
+	if decoded.ImageTypeForPDF != "PNG" {
+		return false // JPEG has no alpha
+	}
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(decoded.Raw))
+	if err != nil {
+		return false
+	}
+	switch model := imgConfig.ColorModel.(type) {
+	case color.Palette:
+		return paletteHasAlpha(model)
+	default:
+		return model == color.NRGBAModel || model == color.NRGBA64Model || model == color.RGBAModel || model == color.RGBA64Model
+	}
+}
+
+// isOpaque reports whether every pixel of img is fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func paletteHasAlpha(p color.Palette) bool {
+	for _, c := range p {
+		if _, _, _, a := c.RGBA(); a != 0xffff {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenAlpha composites img over the background colour bg, so pages look
+// the same in every viewer rather than showing whatever a viewer puts
+// behind transparent pixels. Paletted images keep their palette, with its
+// entries composited. If img is opaque, it is returned with false.
+func flattenAlpha(img image.Image, bg color.RGBA) (image.Image, bool) {
+	if isOpaque(img) {
+		return img, false
+	}
+	if paletted, ok := img.(*image.Paletted); ok {
+		flat := *paletted
+		flat.Palette = make(color.Palette, len(paletted.Palette))
+		for i, c := range paletted.Palette {
+			flat.Palette[i] = over(c, bg)
+		}
+		return &flat, true
+	}
+	b := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, b.Min, draw.Over)
+	return flat, true
+}
+
+// over composites c over the opaque colour bg.
+func over(c color.Color, bg color.RGBA) color.RGBA {
+	r, g, b, a := c.RGBA() // Alpha-premultiplied
+	blend := func(v uint32, under uint8) uint8 {
+		return uint8((v + uint32(under)*0x101*(0xffff-a)/0xffff) >> 8)
+	}
+	return color.RGBA{R: blend(r, bg.R), G: blend(g, bg.G), B: blend(b, bg.B), A: 0xff}
+}
+
+// alphaBackground returns the colour transparent pixels are flattened
+// onto: cfg.Background, or white, the paper a page would show on.
+func (cfg *Config) alphaBackground() color.RGBA {
+	if cfg.Background == "" {
+		return color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	}
+	r, g, b, _ := parseHexColour(cfg.Background)
+	return color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 0xff}
+}
@@ -0,0 +1,83 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// alphaPNGSource returns a PNG whose left half is transparent, or fully
+// opaque NRGBA if opaque is set.
+func alphaPNGSource(t *testing.T, opaque bool) ImageSource {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for y := range 10 {
+		for x := range 20 {
+			c := color.NRGBA{R: 200, A: 0xff}
+			if x < 10 && !opaque {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return ImageSource{OriginalFilename: "alpha.png", ContentType: "image/png", Reader: io.NopCloser(&buf)}
+}
+
+func TestProcessImage_FlattensAlpha(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Background = "#000080"
+	page, err := ProcessImage(context.Background(), cfg, alphaPNGSource(t, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.PassedThrough {
+		t.Fatal("Expected a transparent PNG to be flattened")
+	}
+	img, err := png.Decode(bytes.NewReader(page.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, a := img.At(0, 0).RGBA(); r != 0 || g != 0 || b>>8 != 0x80 || a != 0xffff {
+		t.Errorf("Expected transparent pixels to become the background, got %v", img.At(0, 0))
+	}
+	if r, _, _, _ := img.At(15, 0).RGBA(); r>>8 != 200 {
+		t.Errorf("Expected opaque pixels to be kept, got %v", img.At(15, 0))
+	}
+
+	// Opaque PNGs with an alpha channel, and any PNG with KeepAlpha, are
+	// embedded as they are.
+	if page, err := ProcessImage(context.Background(), cfg, alphaPNGSource(t, true)); err != nil || !page.PassedThrough {
+		t.Errorf("Expected an opaque PNG to be passed through, got %v (passed through: %v)", err, page.PassedThrough)
+	}
+	cfg.KeepAlpha = true
+	if page, err := ProcessImage(context.Background(), cfg, alphaPNGSource(t, false)); err != nil || !page.PassedThrough {
+		t.Errorf("Expected KeepAlpha to pass the PNG through, got %v (passed through: %v)", err, page.PassedThrough)
+	}
+}
+
+func TestFlattenAlpha_Paletted(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 2, 1), color.Palette{color.Transparent, color.NRGBA{G: 0xff, A: 0x80}})
+	img.SetColorIndex(1, 0, 1)
+	flat, ok := flattenAlpha(img, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+	paletted, isPaletted := flat.(*image.Paletted)
+	if !ok || !isPaletted {
+		t.Fatalf("Expected a flattened paletted image, got %T (%v)", flat, ok)
+	}
+	if c := paletted.Palette[0]; c != (color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}) {
+		t.Errorf("Expected the transparent entry to become white, got %v", c)
+	}
+	if c := paletted.Palette[1].(color.RGBA); c.A != 0xff || c.G != 0xff || c.R < 0x7e || c.R > 0x80 {
+		t.Errorf("Expected the half transparent entry to be blended with white, got %v", c)
+	}
+	if img.Palette[0] != color.Transparent {
+		t.Error("Expected the source palette to be left alone")
+	}
+}
@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// ArchiveSources lists the images in a ZIP or CBZ archive as ImageSources,
+// without extracting it. Entries are recognised by extension; directories,
+// hidden entries and macOS metadata (__MACOSX/) are ignored. Pages are put
+// in the order given by order, or natural name order if order is nil. Each
+// source reads its entry straight from r, which must stay open until the
+// conversion finishes and must allow concurrent ReadAt calls (*os.File does).
+// When the pages are spread over several folders, each source's Chapter is
+// its folder, so the PDF gets one bookmark per folder.
+func ArchiveSources(r io.ReaderAt, size int64, order OrderResolver) ([]ImageSource, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("could not read archive: %w", err)
+	}
+	entries := make(map[string]*zip.File, len(zr.File))
+	var names []string
+	for _, f := range zr.File {
+		name := archiveEntryName(f)
+		if f.FileInfo().IsDir() || isArchiveMetadata(name) || GetContentTypeFromFilename(name) == "" {
+			continue
+		}
+		if _, dup := entries[name]; dup {
+			continue // Keep the first of repeated entries
+		}
+		entries[name] = f
+		names = append(names, name)
+	}
+	if order == nil {
+		order = NaturalOrder{}
+	}
+	if names, err = order.Order(names); err != nil {
+		return nil, fmt.Errorf("could not order archive pages: %w", err)
+	}
+
+	sources := make([]ImageSource, 0, len(names))
+	folders := map[string]bool{}
+	for i, name := range names {
+		folders[path.Dir(name)] = true
+		sources = append(sources, ImageSource{
+			OriginalFilename: name,
+			Reader:           &archiveEntryReader{file: entries[name]},
+			ContentType:      GetContentTypeFromFilename(name),
+			Index:            i,
+			Chapter:          path.Dir(name),
+		})
+	}
+	if len(folders) < 2 {
+		for i := range sources {
+			sources[i].Chapter = ""
+		}
+	}
+	return sources, nil
+}
+
+// archiveEntryName returns the name of f as UTF-8. Archives made on
+// Japanese Windows store names in Shift-JIS without the UTF-8 flag, so a
+// name that is not valid UTF-8 is read as Shift-JIS if it is valid there;
+// otherwise it is kept as it is.
+func archiveEntryName(f *zip.File) string {
+	if utf8.ValidString(f.Name) {
+		return f.Name
+	}
+	name, err := japanese.ShiftJIS.NewDecoder().String(f.Name)
+	if err != nil || strings.ContainsRune(name, utf8.RuneError) {
+		return f.Name
+	}
+	return name
+}
+
+// isArchiveMetadata reports whether an archive entry is operating-system
+// clutter rather than a page: anything under __MACOSX/ or a hidden path
+// component.
+func isArchiveMetadata(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveEntryReader opens its archive entry on first Read, so listing a
+// large archive does not hold a decompressor per page.
+type archiveEntryReader struct {
+	file *zip.File
+	rc   io.ReadCloser
+}
+
+func (a *archiveEntryReader) Read(p []byte) (int, error) {
+	if a.rc == nil {
+		rc, err := a.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		a.rc = rc
+	}
+	return a.rc.Read(p)
+}
+
+func (a *archiveEntryReader) Close() error {
+	if a.rc == nil {
+		return nil
+	}
+	return a.rc.Close()
+}
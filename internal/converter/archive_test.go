@@ -0,0 +1,49 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestArchiveSources(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"ch1/", "ch1/p10.png", "ch1/p2.png", "ch1/notes.txt", "__MACOSX/ch1/._p2.png", "ch1/.hidden.png"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if GetContentTypeFromFilename(name) != "" {
+			if err := png.Encode(w, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := ArchiveSources(bytes.NewReader(archive.Bytes()), int64(archive.Len()), nil)
+	if err != nil {
+		t.Fatalf("ArchiveSources failed: %v", err)
+	}
+	if len(sources) != 2 || sources[0].OriginalFilename != "ch1/p2.png" || sources[1].OriginalFilename != "ch1/p10.png" {
+		t.Fatalf("Expected the two pages in natural order, got %+v", sources)
+	}
+	if sources[0].Chapter != "" {
+		t.Errorf("Expected no chapter for a single-folder archive, got %q", sources[0].Chapter)
+	}
+
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, NewDefaultConfig(), &bytes.Buffer{}, &stats); err != nil || stats.PagesAdded != 2 {
+		t.Errorf("Expected both archive pages converted, got %+v, %v", stats, err)
+	}
+
+	if _, err := ArchiveSources(bytes.NewReader([]byte("not a zip")), 9, nil); err == nil {
+		t.Error("Expected an error for a non-archive")
+	}
+}
@@ -0,0 +1,65 @@
+package converter
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"log/slog"
+)
+
+// blankTolerance is how far, in levels out of 256, a pixel may be from a
+// page's dominant tone and still count as empty paper: enough for scanner
+// noise and JPEG artefacts on white or black pages.
+const blankTolerance = 24
+
+// blankMaxInk is the fraction of a page's pixels that may stand out from
+// its dominant tone on a blank page: specks of dust, not a line of text.
+const blankMaxInk = 0.001
+
+// blankSamples is the most pixels per side that isBlankPage looks at;
+// larger pages are sampled on a grid.
+const blankSamples = 512
+
+// isBlankPage reports whether img is blank or nearly so: almost all of it
+// one tone, whichever tone that is.
+func isBlankPage(img image.Image) bool {
+	bounds := img.Bounds()
+	stepX, stepY := max(bounds.Dx()/blankSamples, 1), max(bounds.Dy()/blankSamples, 1)
+	var hist [256]int
+	total := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			hist[color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y]++
+			total++
+		}
+	}
+	if total == 0 {
+		return false
+	}
+
+	// Find the band of 2*blankTolerance+1 levels holding the most pixels.
+	band := 0
+	for v := 0; v <= 2*blankTolerance; v++ {
+		band += hist[v]
+	}
+	best := band
+	for v := 2*blankTolerance + 1; v < 256; v++ {
+		band += hist[v] - hist[v-2*blankTolerance-1]
+		best = max(best, band)
+	}
+	return float64(total-best) <= blankMaxInk*float64(total)
+}
+
+// isBlankSource reports whether the page decoded holds is blank, decoding
+// pages that are passed through for the check.
+func isBlankSource(decoded decodedSource) bool {
+	img := decoded.Image
+	if img == nil {
+		var err error
+		if img, _, err = image.Decode(bytes.NewReader(decoded.Raw)); err != nil {
+			slog.Warn("Could not decode page to check whether it is blank", "filename", decoded.OriginalFilename, "error", err)
+			return false
+		}
+	}
+	return isBlankPage(img)
+}
@@ -0,0 +1,31 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func TestConvertToPDF_BMP(t *testing.T) {
+	var data bytes.Buffer
+	if err := bmp.Encode(&data, image.NewRGBA(image.Rect(0, 0, 12, 8))); err != nil {
+		t.Fatal(err)
+	}
+	if ct := DetectContentType(data.Bytes()[:SniffLen]); ct != "image/bmp" {
+		t.Fatalf("Expected the encoded BMP to be sniffed, got %q", ct)
+	}
+	for _, contentType := range []string{GetContentTypeFromFilename("scan.BMP"), "application/octet-stream"} {
+		sources := []ImageSource{{OriginalFilename: "scan.bmp", Reader: io.NopCloser(bytes.NewReader(data.Bytes())), ContentType: contentType}}
+		var stats Stats
+		if _, err := ConvertToPDFWithStats(context.Background(), sources, NewDefaultConfig(), &bytes.Buffer{}, &stats); err != nil {
+			t.Fatalf("Converting a BMP as %q failed: %v", contentType, err)
+		}
+		if stats.PagesAdded != 1 {
+			t.Errorf("Expected one page for %q, got %+v", contentType, stats)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrInvalidPixelBudget is returned by Config.Validate for a negative
+// MaxTotalMegapixels.
+var ErrInvalidPixelBudget = errors.New("invalid max_total_megapixels")
+
+// PixelBudgetError stops a conversion whose pages add up to more pixels
+// than Config.MaxTotalMegapixels. It is the cancellation cause of the
+// conversion, and errors.Is(err, ErrLimitReached) holds on it.
+type PixelBudgetError struct {
+	Budget   float64 // Config.MaxTotalMegapixels
+	Decoded  float64 // Megapixels decoded when the budget ran out, including Filename
+	Filename string  // The source that went over the budget
+}
+
+func (e *PixelBudgetError) Error() string {
+	return fmt.Sprintf("pixel budget of %g megapixels exceeded: %.1f megapixels decoded at %q", e.Budget, e.Decoded, e.Filename)
+}
+
+// Is makes a PixelBudgetError match ErrLimitReached, so CancellationReason
+// reports "limit".
+func (e *PixelBudgetError) Is(target error) bool { return target == ErrLimitReached }
+
+// pixelBudget counts the pixels decoded by one conversion against
+// Config.MaxTotalMegapixels and cancels the conversion once they exceed it.
+type pixelBudget struct {
+	limit   int64 // Pixels
+	megapix float64
+	decoded atomic.Int64
+	cancel  context.CancelCauseFunc
+}
+
+type pixelBudgetKey struct{}
+
+// withPixelBudget returns a context that is canceled with a
+// *PixelBudgetError once the sources decoded under it exceed
+// cfg.MaxTotalMegapixels. Without a budget ctx is returned as is. The
+// returned stop function must be called when the conversion is done.
+func withPixelBudget(ctx context.Context, cfg *Config) (context.Context, context.CancelCauseFunc) {
+	if cfg.MaxTotalMegapixels <= 0 {
+		return ctx, func(error) {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	budget := &pixelBudget{limit: int64(cfg.MaxTotalMegapixels * 1e6), megapix: cfg.MaxTotalMegapixels, cancel: cancel}
+	return context.WithValue(ctx, pixelBudgetKey{}, budget), cancel
+}
+
+// chargePixels adds the pixels of the source filename, decoded or taken
+// from Config.PageCache, to the budget of ctx, if any, and returns the
+// *PixelBudgetError the conversion was canceled with if that went over it.
+func chargePixels(ctx context.Context, filename string, pixels int64) error {
+	budget, ok := ctx.Value(pixelBudgetKey{}).(*pixelBudget)
+	if !ok {
+		return nil
+	}
+	total := budget.decoded.Add(pixels)
+	if total <= budget.limit {
+		return nil
+	}
+	err := &PixelBudgetError{Budget: budget.megapix, Decoded: float64(total) / 1e6, Filename: filename}
+	budget.cancel(err)
+	return context.Cause(ctx) // The first source over the budget, if several went over at once
+}
+
+// pixels returns the number of pixels of a decoded source: the size of
+// its image, or of the image passed through.
+func (decoded decodedSource) pixels() int64 {
+	if decoded.Image != nil {
+		bounds := decoded.Image.Bounds()
+		return int64(bounds.Dx()) * int64(bounds.Dy())
+	}
+	return int64(decoded.Width) * int64(decoded.Height)
+}
+
+// overPixelBudget reports whether ctx was canceled for going over its
+// pixel budget.
+func overPixelBudget(ctx context.Context) bool {
+	_, ok := context.Cause(ctx).(*PixelBudgetError)
+	return ok
+}
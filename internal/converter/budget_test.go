@@ -0,0 +1,99 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/gif"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertToPDF_PixelBudget(t *testing.T) {
+	sources := func() []ImageSource {
+		var sources []ImageSource
+		for i := range 4 {
+			sources = append(sources, pngSource(t, 500, 500, i)) // 0.25 megapixels each
+		}
+		return sources
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.MaxTotalMegapixels = 1
+	if _, err := ConvertToPDF(context.Background(), sources(), cfg, &bytes.Buffer{}); err != nil {
+		t.Fatalf("Expected the pages to fit a budget of exactly their size, got %v", err)
+	}
+
+	cfg.MaxTotalMegapixels = 0.6
+	_, err := ConvertToPDF(context.Background(), sources(), cfg, &bytes.Buffer{})
+	var budgetErr *PixelBudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a *PixelBudgetError, got %v", err)
+	}
+	if budgetErr.Budget != 0.6 || budgetErr.Decoded <= 0.6 {
+		t.Errorf("Expected more than the 0.6 megapixel budget decoded, got %+v", budgetErr)
+	}
+	if CancellationReason(err) != "limit" {
+		t.Errorf("Expected the budget to count as a limit, got %q", CancellationReason(err))
+	}
+
+	cfg.MaxTotalMegapixels = -1
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidPixelBudget) {
+		t.Errorf("Expected ErrInvalidPixelBudget for a negative budget, got %v", err)
+	}
+}
+
+// TestConvertToPDF_PixelBudgetFormats checks that sources decoded rather
+// than passed through are charged too.
+func TestConvertToPDF_PixelBudgetFormats(t *testing.T) {
+	var gifData bytes.Buffer
+	if err := gif.Encode(&gifData, image.NewGray(image.Rect(0, 0, 500, 500)), nil); err != nil {
+		t.Fatal(err)
+	}
+	readFixture := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	for _, tc := range []struct {
+		name, contentType string
+		data              []byte
+	}{
+		{"page.gif", "image/gif", gifData.Bytes()},
+		{"lossy.webp", "image/webp", readFixture("lossy.webp")},
+		{"lossless.webp", "image/webp", readFixture("lossless.webp")},
+		{"exif-orientation-6.jpeg", "image/jpeg", readFixture("exif-orientation-6.jpeg")},
+		{"cmyk.jpeg", "application/octet-stream", readFixture("cmyk.jpeg")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, _, err := image.DecodeConfig(bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pixels := float64(config.Width*config.Height) / 1e6
+			sources := func() []ImageSource {
+				var sources []ImageSource
+				for i := range 4 {
+					sources = append(sources, ImageSource{OriginalFilename: tc.name, Reader: io.NopCloser(bytes.NewReader(tc.data)), ContentType: tc.contentType, Index: i})
+				}
+				return sources
+			}
+
+			cfg := NewDefaultConfig()
+			cfg.MaxTotalMegapixels = 4 * pixels
+			if _, err := ConvertToPDF(context.Background(), sources(), cfg, &bytes.Buffer{}); err != nil {
+				t.Fatalf("Expected the pages to fit a budget of exactly their size, got %v", err)
+			}
+			cfg.MaxTotalMegapixels = 3.5 * pixels
+			var budgetErr *PixelBudgetError
+			if _, err := ConvertToPDF(context.Background(), sources(), cfg, &bytes.Buffer{}); !errors.As(err, &budgetErr) {
+				t.Errorf("Expected a *PixelBudgetError for %d pixels a page, got %v", config.Width*config.Height, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Cancellation causes. Callers stopping a conversion pass one of these to
+// context.WithCancelCause, context.WithTimeoutCause and friends, so the
+// error returned by the converter says why it stopped.
+var (
+	ErrCanceledByUser = errors.New("canceled by user")
+	ErrTimedOut       = errors.New("conversion time limit reached")
+	ErrLimitReached   = errors.New("conversion resource limit reached")
+	ErrShuttingDown   = errors.New("shutting down")
+)
+
+// CancellationError returns the error for work stopped by ctx: ctx.Err(),
+// joined with the cancellation cause when the context was given one. Both
+// errors.Is(err, context.Canceled) and errors.Is(err, ErrTimedOut) (or the
+// other causes) hold on the result. It returns nil if ctx is not done.
+func CancellationError(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(ctx); cause != nil && cause != err {
+		return fmt.Errorf("%w: %w", err, cause)
+	}
+	return err
+}
+
+// CancellationReason classifies why err stopped a conversion: "user",
+// "timeout", "limit" or "shutdown". A cancellation without a known cause is
+// attributed to the caller ("user"), a bare deadline to "timeout". It
+// returns "" if err is not a cancellation.
+func CancellationReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrShuttingDown):
+		return "shutdown"
+	case errors.Is(err, ErrLimitReached):
+		return "limit"
+	case errors.Is(err, ErrTimedOut), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrCanceledByUser), errors.Is(err, context.Canceled):
+		return "user"
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCancellationError(t *testing.T) {
+	if err := CancellationError(context.Background()); err != nil {
+		t.Errorf("Expected nil for a live context, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CancellationError(ctx); err != context.Canceled || CancellationReason(err) != "user" {
+		t.Errorf("Expected a bare cancellation attributed to the user, got %v (%q)", err, CancellationReason(err))
+	}
+
+	ctx, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(ErrShuttingDown)
+	err := CancellationError(ctx)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrShuttingDown) || CancellationReason(err) != "shutdown" {
+		t.Errorf("Expected cancellation with the shutdown cause, got %v", err)
+	}
+
+	if CancellationReason(ErrNoSupportedImages) != "" {
+		t.Error("Expected no reason for an ordinary error")
+	}
+}
+
+func TestConvertToPDF_CancellationCause(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrLimitReached)
+	_, err := ConvertToPDF(ctx, []ImageSource{pngSource(t, 10, 10, 0)}, NewDefaultConfig(), &bytes.Buffer{})
+	if CancellationReason(err) != "limit" {
+		t.Errorf("Expected the limit cause in the conversion error, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package converter
+
+// InputFormat is an image format the converter reads.
+type InputFormat struct {
+	ContentType string   `json:"content_type"`
+	Extensions  []string `json:"extensions"`             // Recognised file extensions, lower case
+	Decoder     string   `json:"decoder"`                // Go package that decodes it
+	PassThrough bool     `json:"pass_through,omitempty"` // Embedded without re-encoding when it needs no changes
+}
+
+// inputFormats are the image formats compiled into the converter.
+var inputFormats = []InputFormat{
+	{ContentType: "image/jpeg", Extensions: []string{".jpg", ".jpeg"}, Decoder: "image/jpeg", PassThrough: true},
+	{ContentType: "image/png", Extensions: []string{".png"}, Decoder: "image/png", PassThrough: true},
+	{ContentType: "image/webp", Extensions: []string{".webp"}, Decoder: "golang.org/x/image/webp"},
+	{ContentType: "image/gif", Extensions: []string{".gif"}, Decoder: "image/gif"},
+	{ContentType: "image/bmp", Extensions: []string{".bmp"}, Decoder: "golang.org/x/image/bmp"},
+}
+
+// Features lists what this build of the converter supports, so callers
+// can adapt to it rather than find out from a failed conversion.
+type Features struct {
+	InputFormats    []InputFormat `json:"input_formats"`
+	OutputFormats   []string      `json:"output_formats"`   // Values of Config.OutputFormat
+	WebPTargets     []string      `json:"webp_targets"`     // Values of Config.WebPTarget
+	ResampleFilters []string      `json:"resample_filters"` // Values of Config.ResampleFilter
+	Profiles        []string      `json:"profiles"`         // Names of the device profiles, sorted
+}
+
+// SupportedFeatures returns the Features of this build.
+func SupportedFeatures() Features {
+	f := Features{
+		InputFormats:    make([]InputFormat, len(inputFormats)),
+		OutputFormats:   []string{FormatPDF, FormatEPUB},
+		WebPTargets:     []string{WebPTargetAuto, WebPTargetPNG, WebPTargetJPEG},
+		ResampleFilters: []string{ResampleLanczos, ResampleCatmullRom, ResampleNearest},
+	}
+	for i, format := range inputFormats {
+		format.Extensions = append([]string(nil), format.Extensions...)
+		f.InputFormats[i] = format
+	}
+	for _, p := range Profiles() {
+		f.Profiles = append(f.Profiles, p.Name)
+	}
+	return f
+}
@@ -0,0 +1,59 @@
+package converter
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// captionFontSize is the size, in points, of the invisible caption text.
+const captionFontSize = 10
+
+// captionFor returns the caption for the page made from filename, which is
+// the page-th page of the document. Captions are looked up by the full
+// filename, then its base name, then the page number.
+func (cfg *Config) captionFor(filename string, page int) string {
+	if len(cfg.Captions) == 0 {
+		return ""
+	}
+	for _, key := range []string{filename, path.Base(filename), strconv.Itoa(page)} {
+		if caption, ok := cfg.Captions[key]; ok {
+			return caption
+		}
+	}
+	return ""
+}
+
+// captionWriter writes captions onto pages as invisible text (render mode
+// 3): readers do not draw it, but it can be searched, selected, copied and
+// read aloud. gofpdf's core fonts use code page 1252, so characters outside
+// it are not preserved; tagged PDFs also carry the caption as the page's
+// alternate text, which has no such limit.
+type captionWriter struct {
+	pdf       *gofpdf.Fpdf
+	translate func(string) string
+}
+
+func newCaptionWriter(pdf *gofpdf.Fpdf) *captionWriter {
+	pdf.SetAutoPageBreak(false, 0) // Long captions must not spill onto a new page
+	pdf.SetMargins(0, 0, 0)
+	return &captionWriter{pdf: pdf, translate: pdf.UnicodeTranslatorFromDescriptor("")}
+}
+
+// write puts caption at the top of the current page, which is width points
+// wide. With tagged, the text is marked as an artifact so that it does not
+// compete with the figure's alternate text in the structure tree.
+func (w *captionWriter) write(caption string, width float64, tagged bool) {
+	if tagged {
+		w.pdf.RawWriteStr("/Artifact BMC")
+	}
+	w.pdf.SetFont("Helvetica", "", captionFontSize) // A watermark may have changed it
+	w.pdf.RawWriteStr("3 Tr")
+	w.pdf.SetXY(0, 0)
+	w.pdf.MultiCell(width, captionFontSize, w.translate(caption), "", "L", false)
+	w.pdf.RawWriteStr("0 Tr")
+	if tagged {
+		w.pdf.RawWriteStr("EMC")
+	}
+}
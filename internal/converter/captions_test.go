@@ -0,0 +1,45 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestConfigCaptionFor(t *testing.T) {
+	cfg := &Config{Captions: map[string]string{"ch1/001.png": "full path", "002.png": "base name", "3": "page number"}}
+	for _, tc := range []struct {
+		filename string
+		page     int
+		want     string
+	}{
+		{"ch1/001.png", 1, "full path"},
+		{"ch1/002.png", 2, "base name"},
+		{"ch1/003.png", 3, "page number"},
+		{"ch1/004.png", 4, ""},
+	} {
+		if got := cfg.captionFor(tc.filename, tc.page); got != tc.want {
+			t.Errorf("captionFor(%q, %d) = %q, want %q", tc.filename, tc.page, got, tc.want)
+		}
+	}
+}
+
+func TestConvertToPDF_Captions(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Tagged = true
+	cfg.Captions = map[string]string{
+		"1": "“Over here!” " + strings.Repeat("A very long caption. ", 200),
+		"2": "Second page",
+	}
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 100, 100, 0), pngSource(t, 100, 100, 1)}, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("/Count 2\n")) {
+		t.Error("Expected long captions to stay on their page")
+	}
+	if !bytes.Contains(out.Bytes(), []byte("/Alt "+pdfTextString("Second page"))) {
+		t.Error("Expected the caption as the page's alternate text")
+	}
+}
@@ -0,0 +1,115 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrInvalidColourTuning is returned for colour tuning out of range.
+var ErrInvalidColourTuning = errors.New("invalid colour")
+
+// ColourTuning prepares colour pages for colour e-ink (Kaleido) screens,
+// whose colour filter washes colours out and which show only a few levels
+// per channel. It is applied last, to pages classified as colour only:
+// black-and-white pages stored as colour are left alone.
+type ColourTuning struct {
+	// Saturation multiplies the saturation of every pixel, 1 to 4. Each
+	// pixel is boosted only as far as it stays within the colours a page
+	// can hold, so already vivid colours do not clip. 0 or 1 leaves it.
+	Saturation float64 `json:"saturation,omitempty"`
+	// Levels reduces each channel to this many levels, 2 to 255, with
+	// Floyd-Steinberg dithering: 16 gives the 4096 colours of Kaleido 3
+	// panels, which would otherwise band. 0 keeps every level.
+	Levels int `json:"levels,omitempty"`
+}
+
+func (c *ColourTuning) validate() error {
+	switch {
+	case c.Saturation != 0 && (c.Saturation < 1 || c.Saturation > 4):
+		return fmt.Errorf("%w: saturation %v is not between 1 and 4", ErrInvalidColourTuning, c.Saturation)
+	case c.Levels != 0 && (c.Levels < 2 || c.Levels > 255):
+		return fmt.Errorf("%w: levels %d is not between 2 and 255", ErrInvalidColourTuning, c.Levels)
+	}
+	return nil
+}
+
+// active reports whether c changes pages at all.
+func (c *ColourTuning) active() bool {
+	return c != nil && (c.Saturation > 1 || c.Levels > 0)
+}
+
+// apply returns img tuned, or img itself and false if it is not a colour
+// page or c changes nothing.
+func (c *ColourTuning) apply(img image.Image) (image.Image, bool) {
+	if !c.active() {
+		return img, false
+	}
+	if _, gray := img.(*image.Gray); gray || classifyPage(img) != pageColor {
+		return img, false
+	}
+	tuned := imaging.Clone(img)
+	if c.Saturation > 1 {
+		saturate(tuned, c.Saturation)
+	}
+	if c.Levels > 0 {
+		posterize(tuned, c.Levels)
+	}
+	return tuned, true
+}
+
+// saturate moves every pixel of img away from its luma by factor, or as far
+// as it goes before a channel would leave 0 to 255.
+func saturate(img *image.NRGBA, factor float64) {
+	for y := range img.Rect.Dy() {
+		row := img.Pix[y*img.Stride : y*img.Stride+img.Rect.Dx()*4]
+		for i := 0; i < len(row); i += 4 {
+			r, g, b := float64(row[i]), float64(row[i+1]), float64(row[i+2])
+			luma := 0.299*r + 0.587*g + 0.114*b
+			f := factor
+			for _, v := range []float64{r, g, b} {
+				switch {
+				case v > luma:
+					f = min(f, (255-luma)/(v-luma))
+				case v < luma:
+					f = min(f, luma/(luma-v))
+				}
+			}
+			row[i] = uint8(math.Round(luma + (r-luma)*f))
+			row[i+1] = uint8(math.Round(luma + (g-luma)*f))
+			row[i+2] = uint8(math.Round(luma + (b-luma)*f))
+		}
+	}
+}
+
+// posterize reduces every colour channel of img to levels evenly spaced
+// levels, diffusing the error Floyd-Steinberg style so gradients dither
+// instead of banding.
+func posterize(img *image.NRGBA, levels int) {
+	step := 255 / float64(levels-1)
+	width := img.Rect.Dx()
+	// Errors carried to the current and next row, per channel, with a
+	// pixel of padding on either side.
+	cur, next := make([]float64, (width+2)*3), make([]float64, (width+2)*3)
+	for y := range img.Rect.Dy() {
+		row := img.Pix[y*img.Stride:]
+		for x := range width {
+			for ch := range 3 {
+				i := (x+1)*3 + ch
+				v := min(max(float64(row[x*4+ch])+cur[i], 0), 255)
+				q := math.Round(v/step) * step
+				row[x*4+ch] = uint8(math.Round(q))
+				e := v - q
+				cur[i+3] += e * 7 / 16
+				next[i-3] += e * 3 / 16
+				next[i] += e * 5 / 16
+				next[i+3] += e / 16
+			}
+		}
+		cur, next = next, cur
+		clear(next)
+	}
+}
@@ -0,0 +1,71 @@
+package converter
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestColourTuning_Apply(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(100 + x), G: 100, B: uint8(100 + y), A: 255})
+		}
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+
+	tuning := &ColourTuning{Saturation: 2}
+	out, ok := tuning.apply(img)
+	if !ok {
+		t.Fatal("Expected a colour page to be tuned")
+	}
+	tuned := out.(*image.NRGBA)
+	if c := tuned.NRGBAAt(63, 0); c.R <= 163 || c.G >= 100 {
+		t.Errorf("Expected a muted pixel to be saturated, got %v", c)
+	}
+	if c := tuned.NRGBAAt(0, 0); c != (color.NRGBA{R: 255, A: 255}) {
+		t.Errorf("Expected pure red to stay as it is, got %v", c)
+	}
+
+	tuning = &ColourTuning{Levels: 16}
+	out, _ = tuning.apply(img)
+	tuned = out.(*image.NRGBA)
+	for i, v := range tuned.Pix {
+		if i%4 != 3 && v%17 != 0 {
+			t.Fatalf("Expected every channel on one of 16 levels, got %d at %d", v, i)
+		}
+	}
+
+	gray := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			v := uint8(x * 4)
+			gray.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	if _, ok := tuning.apply(gray); ok {
+		t.Error("Expected a black-and-white page stored as colour to be left alone")
+	}
+}
+
+func TestColourTuning_Validate(t *testing.T) {
+	for _, tuning := range []ColourTuning{{Saturation: 0.5}, {Saturation: 5}, {Levels: 1}, {Levels: 256}} {
+		cfg := NewDefaultConfig()
+		cfg.Colour = &tuning
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidColourTuning) {
+			t.Errorf("%+v: expected ErrInvalidColourTuning, got %v", tuning, err)
+		}
+	}
+	cfg := NewDefaultConfig()
+	if err := cfg.ApplyProfile("kaleido-3"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Colour == nil || cfg.Colour.Levels != 16 || cfg.Grayscale {
+		t.Errorf("Expected the Kaleido 3 profile to tune colour, got %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the Kaleido 3 profile to be valid, got %v", err)
+	}
+}
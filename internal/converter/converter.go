@@ -1,34 +1,61 @@
 package converter
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
+	_ "image/gif"  // Registers the GIF decoder (first frame)
 	_ "image/jpeg" // Added for JPEG decoding (register decoder)
 	_ "image/png"  // Added for PNG encoding (register decoder)
 	"io"
 	"log/slog"
-	"net/http"
-	"net/url"
-	"os"
-	"path/filepath"
+	"path"
 	"runtime"
-	"sort"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
 
 	"github.com/disintegration/imaging"
 	"github.com/jung-kurt/gofpdf"
+	_ "golang.org/x/image/bmp"  // Registers the BMP decoder
 	_ "golang.org/x/image/webp" // Added for WebP decoding (register decoder)
 )
 
 // bufferPool is used to reuse byte buffers for WEBP to JPG conversion.
-var bufferPool = sync.Pool{
-	New: func() interface{} {
-		return new(bytes.Buffer)
-	},
+// It has no New function so getBuffer can tell reuse from allocation.
+var bufferPool sync.Pool
+
+// getBuffer returns an empty buffer from bufferPool, or a new one if the pool
+// is empty. reused reports which.
+func getBuffer() (buf *bytes.Buffer, reused bool) {
+	if buf, ok := bufferPool.Get().(*bytes.Buffer); ok {
+		buf.Reset()
+		return buf, true
+	}
+	return new(bytes.Buffer), false
+}
+
+// TrimBuffers empties the buffer pool, so buffers that grew to the size of
+// the largest pages of a huge conversion can be collected instead of being
+// kept for the next one. It returns how many buffers were dropped and their
+// capacity in bytes. Conversions running meanwhile simply allocate anew.
+func TrimBuffers() (buffers int, size int64) {
+	for {
+		buf, ok := bufferPool.Get().(*bytes.Buffer)
+		if !ok {
+			return buffers, size
+		}
+		buffers++
+		size += int64(buf.Cap())
+	}
 }
 
 // ErrNoSupportedImages is returned when no supported image sources are provided or processed.
@@ -45,24 +72,121 @@ type ImageSource struct {
 	URL              string        // URL if the image is to be fetched
 	ContentType      string        // Detected content type (e.g., "image/jpeg", "image/png", "image/webp")
 	Index            int           // Original index for ordering
-}
-
-// ProcessedImage holds the data for an image that has been processed and is ready for PDF registration.
-type ProcessedImage struct {
-	Index            int       // Original index of the file, for ordering
-	OriginalFilename string    // Original filename
-	Error            error     // Error encountered during processing
-	Reader           io.Reader // Reader for image data (either *os.File or *bytes.Buffer)
-	Width            float64   // Width of the image in points
-	Height           float64   // Height of the image in points
-	ImageTypeForPDF  string    // Type string for gofpdf ("PNG", "JPG")
+	Chapter          string        // Chapter the page belongs to, used for PDF bookmarks; empty for none
+	FetchErr         error         // Why the image could not be fetched; the source then fails without being read
+	FetchedAt        time.Time     // When URL was downloaded; zero for uploads and files
 }
 
 // Config holds configuration for the conversion process.
 type Config struct {
-	JPEGQuality    int
-	NumWorkers     int
-	OutputFilename string // Suggested output filename, used for Content-Disposition
+	JPEGQuality    int    `json:"jpeg_quality"`
+	NumWorkers     int    `json:"num_workers"`
+	DecodeWorkers  int    `json:"decode_workers,omitempty"` // Concurrent decodes (memory-bound); 0 uses NumWorkers
+	EncodeWorkers  int    `json:"encode_workers,omitempty"` // Concurrent encodes (CPU-bound); 0 uses NumWorkers
+	LargestFirst   bool   `json:"largest_first"`            // Start the largest pages first (by pre-scanned pixel count)
+	PageBookmarks  bool   `json:"page_bookmarks"`           // Add a PDF bookmark for every page, below its chapter's
+	DedupPages     bool   `json:"dedup_pages"`              // Keep only the first of several byte-identical pages
+	SkipBlank      bool   `json:"skip_blank"`               // Drop pages that are blank or nearly so
+	NormalizeWidth bool   `json:"normalize_width"`          // Scale every page to the most common page width
+	SmartQuality   bool   `json:"smart_quality"`            // Raise JPEGQuality for colour pages and lower it for line art
+	GIFFrames      bool   `json:"gif_frames"`               // One page per frame of animated GIFs, instead of the first frame only
+	WebPTarget     string `json:"webp_target,omitempty"`    // WebPTargetAuto (default), WebPTargetPNG or WebPTargetJPEG
+	OutputFilename string `json:"output_filename"`          // Suggested output filename, used for Content-Disposition
+	OutputFormat   string `json:"output_format,omitempty"`  // FormatPDF (default) or FormatEPUB
+	RightToLeft    bool   `json:"rtl"`                      // Mark the document as read right to left (manga page order)
+	Tagged         bool   `json:"tagged"`                   // Write a tagged PDF: each page image is a figure with alternate text
+	Language       string `json:"language,omitempty"`       // Document language (BCP 47, e.g. "en" or "ja")
+	Title          string `json:"title,omitempty"`          // Document title; defaults to OutputFilename without its extension
+	Author         string `json:"author,omitempty"`         // Document author
+	Subject        string `json:"subject,omitempty"`        // Document subject, e.g. the series name
+	Keywords       string `json:"keywords,omitempty"`       // Comma-separated keywords
+	// MaxTotalMegapixels stops the conversion with a *PixelBudgetError once
+	// the pages decoded so far add up to more than this many megapixels.
+	// 0 means no limit.
+	MaxTotalMegapixels float64 `json:"max_total_megapixels,omitempty"`
+	// DecodeLimits caps how many pages of a format ("jpeg", "png", "webp",
+	// "gif" or "bmp", see DecodeFormats) one conversion decodes at once,
+	// below DecodeWorkers, e.g. {"webp": 2} for a format that takes far
+	// more memory to decode than the others. Workers waiting for such a
+	// slot leave the rest of the decode workers to other formats. A format
+	// without a limit, or a limit of 0, is capped by DecodeWorkers only.
+	DecodeLimits map[string]int `json:"decode_limits,omitempty"`
+	// QualityRules give groups of pages, by chapter or page range, their own
+	// JPEG quality instead of JPEGQuality; the first rule a page matches
+	// applies. SmartQuality adapts it to the page like JPEGQuality.
+	QualityRules []QualityRule `json:"quality_rules,omitempty"`
+	// ReadAhead, if set, reads up to this many sources into memory ahead of
+	// the decode workers on a goroutine of their own, so sources that are
+	// slow to read, such as archive entries decompressed as they are read,
+	// do not keep the workers waiting. Uploads to the server are in memory
+	// already, so it is not a request option.
+	ReadAhead int `json:"-"`
+	// PageCache, if set, is where pages are looked up by their source
+	// bytes before they are decoded, and stored once they are made, so
+	// that a conversion can take over the pages of an earlier one, e.g.
+	// one that was stopped before it finished. Pages taken from it are
+	// neither decoded nor inspected; Stats.PagesCached counts them.
+	PageCache PageCache `json:"-"`
+	// ResampleFilter is the filter pages are resized with: ResampleLanczos
+	// (default), ResampleCatmullRom or ResampleNearest.
+	ResampleFilter string `json:"resample_filter,omitempty"`
+	// Profile names the device profile applied with ApplyProfile, if any.
+	Profile string `json:"profile,omitempty"`
+	// Grayscale converts colour pages to grayscale, which e-ink screens
+	// show anyway, for smaller files.
+	Grayscale bool `json:"grayscale,omitempty"`
+	// AutoLevels, if set, stretches the tonal range of every page.
+	AutoLevels *AutoLevels `json:"auto_levels,omitempty"`
+	// Adjust, if set, corrects the brightness, contrast, gamma and
+	// sharpness of every page.
+	Adjust *Adjustments `json:"adjust,omitempty"`
+	// Colour, if set, boosts and quantizes colour pages for colour e-ink.
+	Colour *ColourTuning `json:"colour,omitempty"`
+	// AutoCrop, if set, trims the uniform margins around every page before
+	// it is resized.
+	AutoCrop *AutoCrop `json:"auto_crop,omitempty"`
+	// MaxWidth and MaxHeight scale larger pages down, keeping their aspect
+	// ratio, with ResampleFilter. 0 leaves that side unlimited.
+	MaxWidth  int `json:"max_width,omitempty"`
+	MaxHeight int `json:"max_height,omitempty"`
+	// PrintLayout, if set, puts several pages on each sheet of paper for
+	// printing (PDF only).
+	PrintLayout *PrintLayout `json:"print_layout,omitempty"`
+	// PageSize, if set, gives every page the same size instead of the size
+	// of its image (PDF only).
+	PageSize *PageSize `json:"page_size,omitempty"`
+	// Margin insets every image by this many points on each side of its
+	// page, and Background ("#rrggbb") fills the page behind it, showing in
+	// the margins, around letterboxed images and through transparent
+	// pixels. Without a Background the page is left white (PDF only).
+	Margin     float64 `json:"margin,omitempty"`
+	Background string  `json:"background,omitempty"`
+	// KeepAlpha embeds transparent pages as they are. By default they are
+	// flattened onto Background (white without one), since viewers differ
+	// in what they show behind transparent pixels.
+	KeepAlpha bool `json:"keep_alpha,omitempty"`
+	// Placeholders puts a generated page ("Page 47 missing: fetch error")
+	// in place of every page that fails, instead of leaving it out, so that
+	// the pages after it keep their page numbers (PDF only).
+	Placeholders bool `json:"placeholders,omitempty"`
+	// Provenance records where every page came from in its /PieceInfo: the
+	// source's filename, chapter, URL, download time and SHA-256, so an
+	// archived document can be traced back to its sources (PDF only).
+	Provenance bool `json:"provenance,omitempty"`
+	// TargetSize, if set, keeps the output under this many bytes by
+	// converting again with lower JPEG quality, then lower resolution,
+	// while it is larger. Stats.SizePasses counts the conversions.
+	TargetSize int64 `json:"target_size,omitempty"`
+	// Captions maps pages to text that is embedded invisibly on them. Keys
+	// are filenames (as in ImageSource.OriginalFilename, or its base name)
+	// or 1-based page numbers.
+	Captions  map[string]string `json:"captions,omitempty"`
+	Watermark *Watermark        `json:"watermark,omitempty"` // Stamped on every page, or only the first (PDF only)
+	Progress  ProgressFunc      `json:"-"`                   // Receives per-source progress, if set
+	Inspect   InspectFunc       `json:"-"`                   // Receives every page before and after conversion, if set
+	Limit     *WorkerLimit      `json:"-"`                   // Shared with other conversions to cap their decodes and encodes in total, if set
+
+	sizePass sizePass // Set on the passes of a TargetSize conversion
 	// InputDirectory is no longer needed here as images come from ImageSource list
 }
 
@@ -71,13 +195,117 @@ func NewDefaultConfig() *Config {
 	return &Config{
 		JPEGQuality:    90,
 		NumWorkers:     runtime.NumCPU(),
+		LargestFirst:   true,
 		OutputFilename: "converted.pdf",
 	}
 }
 
-// processSingleImage processes a single ImageSource.
-// It handles decoding based on ContentType and potential re-encoding for WebP.
-func processSingleImage(ctx context.Context, cfg *Config, source ImageSource) ProcessedImage {
+// Validate checks the output format, WebP target, resample filter,
+// profile, pixel budget, decode limits, quality rules, read-ahead, page
+// transforms, print layout, page size, margin, background and watermark of
+// cfg.
+func (cfg *Config) Validate() error {
+	if _, _, err := cfg.OutputType(); err != nil {
+		return err
+	}
+	if err := validWebPTarget(cfg.WebPTarget); err != nil {
+		return err
+	}
+	if err := validResampleFilter(cfg.ResampleFilter); err != nil {
+		return err
+	}
+	if err := validProfile(cfg.Profile); err != nil {
+		return err
+	}
+	if cfg.MaxTotalMegapixels < 0 {
+		return fmt.Errorf("%w %g (expected 0 for no limit, or more)", ErrInvalidPixelBudget, cfg.MaxTotalMegapixels)
+	}
+	if err := ValidateDecodeLimits(cfg.DecodeLimits); err != nil {
+		return err
+	}
+	for _, rule := range cfg.QualityRules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.ReadAhead < 0 {
+		return fmt.Errorf("%w %d (expected 0 to read sources as they are decoded, or more)", ErrInvalidReadAhead, cfg.ReadAhead)
+	}
+	if cfg.TargetSize < 0 {
+		return fmt.Errorf("%w %d (expected 0 for no target, or more)", ErrInvalidTargetSize, cfg.TargetSize)
+	}
+	if cfg.MaxWidth < 0 || cfg.MaxHeight < 0 {
+		return fmt.Errorf("%w %dx%d (expected 0 for no limit, or more)", ErrInvalidMaxSize, cfg.MaxWidth, cfg.MaxHeight)
+	}
+	if cfg.AutoLevels != nil {
+		if err := cfg.AutoLevels.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.AutoCrop != nil {
+		if err := cfg.AutoCrop.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.Adjust != nil {
+		if err := cfg.Adjust.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.Colour != nil {
+		if err := cfg.Colour.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.PrintLayout != nil {
+		if err := cfg.validatePrintLayout(); err != nil {
+			return err
+		}
+	}
+	if cfg.PageSize != nil {
+		if err := cfg.validatePageSize(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.validateFrame(); err != nil {
+		return err
+	}
+	if cfg.Watermark != nil {
+		if cfg.OutputFormat == FormatEPUB {
+			return fmt.Errorf("%w: watermarks are only supported for PDF output", ErrInvalidWatermark)
+		}
+		return cfg.Watermark.validate()
+	}
+	return nil
+}
+
+// decodedSource is the output of the decode stage: either the raw bytes of a
+// JPG/PNG that can be embedded as is, or a decoded image that the encode
+// stage must re-encode.
+type decodedSource struct {
+	Index            int
+	OriginalFilename string
+	Chapter          string
+	URL              string
+	FetchedAt        time.Time
+	FormatName       string      // Format detected by the image package ("jpeg", "png", "webp")
+	ImageTypeForPDF  string      // Type string for gofpdf ("PNG", "JPG")
+	Raw              []byte      // Original bytes for pass-through; nil if Image must be encoded
+	Image            image.Image // Decoded image to re-encode; nil for pass-through
+	Width            float64
+	Height           float64
+	ContentHash      []byte // SHA-256 of the source bytes, with Config.DedupPages or Config.Provenance
+	SourceBytes      int64  // Bytes read from the source
+	DPI              float64
+	Transforms       []string // Transforms that changed the image, in order (see PageInspection)
+}
+
+// decodeSource is the decode stage for a single ImageSource. It reads the
+// source (closing its reader) and either validates it for pass-through or
+// decodes it into an image.Image. This stage is memory-bound. With
+// cfg.DedupPages or cfg.Provenance the source bytes are hashed on the way
+// through.
+func decodeSource(ctx context.Context, cfg *Config, source ImageSource) (decoded decodedSource, err error) {
 	slog.Debug("Starting to process image source", "originalFilename", source.OriginalFilename, "index", source.Index, "contentType", source.ContentType)
 	select {
 	case <-ctx.Done():
@@ -85,461 +313,895 @@ func processSingleImage(ctx context.Context, cfg *Config, source ImageSource) Pr
 		if source.Reader != nil {
 			source.Reader.Close()
 		}
-		return ProcessedImage{Index: source.Index, OriginalFilename: source.OriginalFilename, Error: ctx.Err()}
+		return decodedSource{}, CancellationError(ctx)
 	default:
 	}
 
+	if source.FetchErr != nil {
+		if source.Reader != nil {
+			source.Reader.Close()
+		}
+		return decodedSource{}, fetchError{source.FetchErr}
+	}
 	if source.Reader == nil {
 		slog.Warn("Image source reader is nil", "originalFilename", source.OriginalFilename)
-		return ProcessedImage{Index: source.Index, OriginalFilename: source.OriginalFilename, Error: errors.New("image reader is nil")}
+		return decodedSource{}, errors.New("image reader is nil")
 	}
 	defer source.Reader.Close()
 
-	processedInfo := ProcessedImage{Index: source.Index, OriginalFilename: source.OriginalFilename}
-	var imgConfig image.Config
-	var formatName string // Will store the detected format string from image.Decode/DecodeConfig
-	var err error
-
-	// Determine image type for gofpdf and processing path
-	var imageTypeForPDF string
-	var needsReEncoding bool
+	decoded = decodedSource{Index: source.Index, OriginalFilename: source.OriginalFilename, Chapter: source.Chapter, URL: source.URL, FetchedAt: source.FetchedAt}
+	counter := &countingReader{r: source.Reader}
+	defer func() {
+		if err == nil {
+			decoded.SourceBytes = counter.n
+		}
+	}()
+	reader := io.Reader(counter)
+	if cfg.DedupPages || cfg.Provenance {
+		hasher := sha256.New()
+		reader = io.TeeReader(counter, hasher)
+		defer func() {
+			if err != nil {
+				return
+			}
+			// Decoders may stop before EOF; hash the remainder so identical
+			// files always produce identical hashes.
+			io.Copy(io.Discard, reader)
+			decoded.ContentHash = hasher.Sum(nil)
+		}()
+	}
 
 	switch source.ContentType {
-	case "image/jpeg", "image/jpg":
-		imageTypeForPDF = "JPG"
-		needsReEncoding = false
-	case "image/png":
-		imageTypeForPDF = "PNG"
-		needsReEncoding = false
+	case "image/jpeg", "image/jpg", "image/png":
+		slog.Debug("Processing as PNG/JPG (direct reader)", "filename", source.OriginalFilename)
+		// gofpdf needs the original bytes and we need the dimensions, so the
+		// data is buffered once and only the header is decoded.
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not read image data for %s: %w", source.OriginalFilename, err)
+		}
+		decoded.DPI = imageDPI(data)
+		// Embedded as is, a JPEG with an EXIF orientation would lie on its
+		// side, so it is decoded and turned upright.
+		orientation := jpegOrientation(data)
+		reencode := orientation != 1
+		if cfg.sizePass.reencodeJPEG && source.ContentType != "image/png" {
+			// A target size pass: JPEGs above the lowered quality are
+			// re-encoded like those without a content type.
+			_, ok := jpegPassThrough(cfg.pageQuality(source.Chapter, source.Index), data)
+			reencode = reencode || !ok
+		}
+		if source.ContentType == "image/png" {
+			_, ok := pngPassThrough(data) // gofpdf rejects 16-bit and interlaced PNGs
+			reencode = reencode || !ok
+		}
+		if reencode {
+			img, formatName, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return decodedSource{}, fmt.Errorf("could not decode %s image %s: %w", strings.TrimPrefix(source.ContentType, "image/"), source.OriginalFilename, err)
+			}
+			decoded.FormatName = formatName
+			decoded.ImageTypeForPDF = "JPG"
+			if source.ContentType == "image/png" {
+				decoded.ImageTypeForPDF = "PNG"
+			}
+			decoded.Image = orient(img, orientation)
+			return decoded, nil
+		}
+		imgConfig, formatName, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not decode image config for %s: %w", source.OriginalFilename, err)
+		}
+		decoded.FormatName = formatName
+		decoded.ImageTypeForPDF = "JPG"
+		if source.ContentType == "image/png" {
+			decoded.ImageTypeForPDF = "PNG"
+		}
+		decoded.Raw = data
+		decoded.Width = float64(imgConfig.Width)
+		decoded.Height = float64(imgConfig.Height)
+		return decoded, nil
+
+	case "image/gif":
+		slog.Debug("Processing as GIF (first frame, re-encoded to PNG)", "filename", source.OriginalFilename)
+		img, formatName, err := image.Decode(reader)
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not decode gif image %s: %w", source.OriginalFilename, err)
+		}
+		decoded.FormatName = formatName
+		decoded.ImageTypeForPDF = "PNG" // Paletted, so lossless PNG stays small
+		decoded.Image = img
+		return decoded, nil
+
+	case "image/bmp", "image/x-bmp", "image/x-ms-bmp":
+		slog.Debug("Processing as BMP (decode and re-encode to JPG)", "filename", source.OriginalFilename)
+		img, formatName, err := image.Decode(reader)
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not decode bmp image %s: %w", source.OriginalFilename, err)
+		}
+		decoded.FormatName = formatName
+		decoded.ImageTypeForPDF = "JPG" // Uncompressed, so JPG keeps the PDF small
+		decoded.Image = img
+		return decoded, nil
+
 	case "image/webp":
-		imageTypeForPDF = "JPG" // WebP will be converted to JPG for PDF
-		needsReEncoding = true
+		slog.Debug("Processing as WEBP (decode and re-encode)", "filename", source.OriginalFilename)
+		buffered := bufio.NewReaderSize(reader, webpHeaderPeek)
+		header, _ := buffered.Peek(webpHeaderPeek) // Shorter for small files
+		if webpAnimated(header) {
+			data, err := io.ReadAll(buffered)
+			if err != nil {
+				return decodedSource{}, fmt.Errorf("could not read image data for %s: %w", source.OriginalFilename, err)
+			}
+			img, still, err := decodeAnimatedWebP(data)
+			if err != nil {
+				return decodedSource{}, fmt.Errorf("could not decode webp image %s: %w", source.OriginalFilename, err)
+			}
+			decoded.FormatName = "webp"
+			decoded.ImageTypeForPDF = cfg.webpPDFType(still)
+			decoded.Image = img
+			return decoded, nil
+		}
+		img, formatName, err := image.Decode(buffered)
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not decode webp image %s: %w", source.OriginalFilename, err)
+		}
+		decoded.FormatName = formatName
+		decoded.ImageTypeForPDF = cfg.webpPDFType(header)
+		decoded.Image = img
+		return decoded, nil
+
 	default:
-		// Try to decode config anyway, might be a known format with an unusual content type
+		// Try to decode anyway, might be a known format with an unusual content type.
+		// The original bytes are kept so JPEG and PNG data can still go to
+		// gofpdf as is.
 		slog.Warn("Potentially unsupported content type, attempting to decode", "contentType", source.ContentType, "filename", source.OriginalFilename)
-		// We need to "peek" at the format without consuming the reader for later full decode
-		// This is tricky. For now, let's assume if ContentType is not one of above, we try generic decode.
-		// A better way would be to use a TeeReader if we needed to DecodeConfig then Decode.
-		// However, since we decode directly or re-encode, we can just proceed.
-		img, detectedFormat, decodeErr := image.Decode(source.Reader)
-		if decodeErr != nil {
-			processedInfo.Error = fmt.Errorf("could not decode image (unknown content type %s) %s: %w", source.ContentType, source.OriginalFilename, decodeErr)
-			return processedInfo
-		}
-		formatName = detectedFormat
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not read image data for %s: %w", source.OriginalFilename, err)
+		}
+		decoded.DPI = imageDPI(data)
+		quality := cfg.pageQuality(source.Chapter, source.Index)
+		orientation := jpegOrientation(data)
+		if passThrough, ok := jpegPassThrough(quality, data); ok && orientation == 1 {
+			slog.Info("Passing JPEG through, source quality is not above the target", "filename", source.OriginalFilename, "sourceQuality", passThrough.quality, "jpegQuality", quality)
+			decoded.FormatName = "jpeg"
+			decoded.ImageTypeForPDF = "JPG"
+			decoded.Raw = data
+			decoded.Width = float64(passThrough.config.Width)
+			decoded.Height = float64(passThrough.config.Height)
+			return decoded, nil
+		}
+		if imgConfig, ok := pngPassThrough(data); ok {
+			slog.Info("Passing PNG through despite its content type", "filename", source.OriginalFilename, "contentType", source.ContentType)
+			decoded.FormatName = "png"
+			decoded.ImageTypeForPDF = "PNG"
+			decoded.Raw = data
+			decoded.Width = float64(imgConfig.Width)
+			decoded.Height = float64(imgConfig.Height)
+			return decoded, nil
+		}
+		if webpAnimated(data) {
+			img, still, err := decodeAnimatedWebP(data)
+			if err != nil {
+				return decodedSource{}, fmt.Errorf("could not decode webp image %s: %w", source.OriginalFilename, err)
+			}
+			slog.Info("Decoded image with unknown initial content type", "detectedFormat", "webp", "filename", source.OriginalFilename)
+			decoded.FormatName = "webp"
+			decoded.ImageTypeForPDF = cfg.webpPDFType(still)
+			decoded.Image = img
+			return decoded, nil
+		}
+		img, detectedFormat, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return decodedSource{}, fmt.Errorf("could not decode image (unknown content type %s) %s: %w", source.ContentType, source.OriginalFilename, err)
+		}
 		slog.Info("Decoded image with unknown initial content type", "detectedFormat", detectedFormat, "filename", source.OriginalFilename)
-
-		// Reset reader if possible (not possible for http body without buffering, this is a simplification)
-		// This part of the logic assumes source.Reader can be re-read or the 'img' is used directly.
-		// For API, the reader is likely a one-shot deal.
-		// If we decoded it, we must use the 'img' object.
-
+		decoded.FormatName = detectedFormat
+		decoded.Image = orient(img, orientation)
 		switch detectedFormat {
-		case "jpeg":
-			imageTypeForPDF = "JPG"
-			needsReEncoding = false // It's already decoded, but we need to re-encode to pass to gofpdf if not JPG/PNG
-			// To avoid re-encoding if not necessary, we'd need to pass the raw stream.
-			// For simplicity now: if decoded, and it's JPEG, we'll re-encode to ensure it's in a buffer.
-			// This is a slight inefficiency for JPEGs that fell into this path.
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset()
-			if err := imaging.Encode(buf, img, imaging.JPEG, imaging.JPEGQuality(cfg.JPEGQuality)); err != nil {
-				bufferPool.Put(buf)
-				processedInfo.Error = fmt.Errorf("could not re-encode %s (originally %s) to jpg: %w", source.OriginalFilename, detectedFormat, err)
-				return processedInfo
-			}
-			processedInfo.Reader = buf
-			processedInfo.Width = float64(img.Bounds().Dx())
-			processedInfo.Height = float64(img.Bounds().Dy())
-			processedInfo.ImageTypeForPDF = "JPG"
-			slog.Debug("Successfully processed image (decoded from unknown type)", "filename", source.OriginalFilename, "originalFormat", formatName, "pdfType", imageTypeForPDF, "width", processedInfo.Width, "height", processedInfo.Height)
-			return processedInfo
-
-		case "png":
-			imageTypeForPDF = "PNG"
-			needsReEncoding = false // Similar to JPEG, re-encode to buffer for consistent handling
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset()
-			if err := imaging.Encode(buf, img, imaging.PNG); err != nil {
-				bufferPool.Put(buf)
-				processedInfo.Error = fmt.Errorf("could not re-encode %s (originally %s) to png: %w", source.OriginalFilename, detectedFormat, err)
-				return processedInfo
-			}
-			processedInfo.Reader = buf
-			processedInfo.Width = float64(img.Bounds().Dx())
-			processedInfo.Height = float64(img.Bounds().Dy())
-			processedInfo.ImageTypeForPDF = "PNG"
-			slog.Debug("Successfully processed image (decoded from unknown type)", "filename", source.OriginalFilename, "originalFormat", formatName, "pdfType", imageTypeForPDF, "width", processedInfo.Width, "height", processedInfo.Height)
-			return processedInfo
 		case "webp":
-			imageTypeForPDF = "JPG" // WebP will be converted to JPG for PDF
-			needsReEncoding = true  // It's decoded, but needs re-encoding to JPG
+			decoded.ImageTypeForPDF = cfg.webpPDFType(data)
+		case "jpeg", "bmp":
+			decoded.ImageTypeForPDF = "JPG"
+		case "png", "gif":
+			decoded.ImageTypeForPDF = "PNG"
 		default:
-			processedInfo.Error = fmt.Errorf("unsupported image format '%s' for %s (content type: %s)", detectedFormat, source.OriginalFilename, source.ContentType)
-			return processedInfo
-		}
-		// If we are here, it means we decoded 'img' and it's webp, or jpeg/png that needs re-encoding to buffer.
-		// Re-use the decoded 'img' for webp conversion or jpeg/png buffering.
-		if needsReEncoding { // True for WebP, or if we decided to re-encode for jpeg/png in this path
-			slog.Debug("Processing image that needs re-encoding", "filename", source.OriginalFilename, "originalFormat", formatName)
-			if formatName == "webp" { // Explicitly handle 16-bit WebP
-				switch img.(type) {
-				case *image.Gray16, *image.NRGBA64, *image.RGBA64:
-					slog.Debug("Converting 16-bit WebP image to 8-bit NRGBA", "filename", source.OriginalFilename)
-					img = imaging.Clone(img) // imaging.Clone converts to NRGBA
-				}
-			}
-			buf := bufferPool.Get().(*bytes.Buffer)
-			buf.Reset()
-			targetFormat := imaging.JPEG
-			if imageTypeForPDF == "PNG" { // Should not happen if needsReEncoding is true for PNG from unknown type
-				targetFormat = imaging.PNG
-			}
+			return decodedSource{}, fmt.Errorf("unsupported image format '%s' for %s (content type: %s)", detectedFormat, source.OriginalFilename, source.ContentType)
+		}
+		return decoded, nil
+	}
+}
 
-			encodeOptions := []imaging.EncodeOption{}
-			if targetFormat == imaging.JPEG {
-				encodeOptions = append(encodeOptions, imaging.JPEGQuality(cfg.JPEGQuality))
-			}
+// jpegPassThroughInfo is what jpegPassThrough learned about a JPEG that can be
+// embedded without re-encoding.
+type jpegPassThroughInfo struct {
+	config  image.Config
+	quality int
+}
 
-			if err := imaging.Encode(buf, img, targetFormat, encodeOptions...); err != nil {
-				bufferPool.Put(buf)
-				processedInfo.Error = fmt.Errorf("could not re-encode %s (format %s) to %s: %w", source.OriginalFilename, formatName, imageTypeForPDF, err)
-				return processedInfo
-			}
-			processedInfo.Reader = buf
-			processedInfo.Width = float64(img.Bounds().Dx())
-			processedInfo.Height = float64(img.Bounds().Dy())
-			processedInfo.ImageTypeForPDF = imageTypeForPDF
-			slog.Debug("Successfully processed image (re-encoded)", "filename", source.OriginalFilename, "originalFormat", formatName, "pdfType", imageTypeForPDF, "width", processedInfo.Width, "height", processedInfo.Height)
-			return processedInfo
-		}
-		// Fallthrough if not handled, though logic above should cover it.
-		processedInfo.Error = fmt.Errorf("internal error processing image %s with detected format %s", source.OriginalFilename, formatName)
-		return processedInfo
+// jpegPassThrough reports whether data is a JPEG whose estimated quality is
+// already at or below the target quality. Re-encoding such a page would only
+// add generation loss and cost CPU, so it is embedded as is.
+func jpegPassThrough(target int, data []byte) (jpegPassThroughInfo, bool) {
+	quality, ok := EstimateJPEGQuality(data)
+	if !ok || quality > target {
+		return jpegPassThroughInfo{}, false
 	}
+	imgConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		return jpegPassThroughInfo{}, false
+	}
+	return jpegPassThroughInfo{config: imgConfig, quality: quality}, true
+}
 
-	// Standard path for known content types (JPG, PNG, WebP)
-	if !needsReEncoding { // JPG or PNG
-		slog.Debug("Processing as PNG/JPG (direct reader)", "filename", source.OriginalFilename)
-		// We need to pass the original reader to gofpdf for JPG/PNG.
-		// However, we also need the dimensions. DecodeConfig first.
-		// This means the reader might be consumed. We need a TeeReader or to buffer it.
-		// For simplicity, let's read into a buffer first. This is less memory efficient for large files
-		// but simplifies handling and ensures the reader can be used by gofpdf.
+// pngPassThrough reports whether data is a PNG that gofpdf can embed as is,
+// returning its header. gofpdf rejects 16-bit and interlaced PNGs, so those
+// are decoded and re-encoded instead.
+func pngPassThrough(data []byte) (image.Config, bool) {
+	imgConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "png" {
+		return image.Config{}, false
+	}
+	switch imgConfig.ColorModel {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return image.Config{}, false
+	}
+	// The interlace method is the last byte of the IHDR chunk, which
+	// image.DecodeConfig has just validated.
+	if len(data) < 29 || data[28] != 0 {
+		return image.Config{}, false
+	}
+	return imgConfig, true
+}
 
-		data, readErr := io.ReadAll(source.Reader)
-		if readErr != nil {
-			processedInfo.Error = fmt.Errorf("could not read image data for %s: %w", source.OriginalFilename, readErr)
-			return processedInfo
-		}
+// encodeDecoded is the encode stage: it turns a decodedSource into a
+// page. Pass-through sources are only wrapped; decoded images are
+// re-encoded, which is CPU-bound.
+func encodeDecoded(ctx context.Context, cfg *Config, decoded decodedSource) pageResult {
+	res := pageResult{
+		Page: Page{
+			Format: "jpeg",
+			DPI:    decoded.DPI,
+			Source: PageSource{
+				Index:     decoded.Index,
+				Filename:  decoded.OriginalFilename,
+				Chapter:   decoded.Chapter,
+				Format:    decoded.FormatName,
+				Bytes:     decoded.SourceBytes,
+				URL:       decoded.URL,
+				FetchedAt: decoded.FetchedAt,
+			},
+		},
+		contentHash: decoded.ContentHash,
+	}
+	if decoded.ImageTypeForPDF == "PNG" {
+		res.Format = "png"
+	}
+	if cfg.Inspect != nil {
+		before := decoded
+		defer func() { cfg.inspect(before, decoded, res) }()
+	}
 
-		imgConfig, formatName, err = image.DecodeConfig(bytes.NewReader(data))
-		if err != nil {
-			processedInfo.Error = fmt.Errorf("could not decode image config for %s: %w", source.OriginalFilename, err)
-			return processedInfo
-		}
+	decoded, err := transformSource(cfg, decoded)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	if cfg.SkipBlank {
+		res.blank = isBlankSource(decoded)
+	}
 
-		processedInfo.Reader = bytes.NewReader(data) // Pass the buffered data
-		processedInfo.Width = float64(imgConfig.Width)
-		processedInfo.Height = float64(imgConfig.Height)
-		processedInfo.ImageTypeForPDF = imageTypeForPDF
-	} else { // WebP
-		slog.Debug("Processing as WEBP (decode and re-encode to JPG)", "filename", source.OriginalFilename)
-		decodedImg, webpFormatName, err := image.Decode(source.Reader)
-		if err != nil {
-			processedInfo.Error = fmt.Errorf("could not decode webp image %s: %w", source.OriginalFilename, err)
-			return processedInfo
-		}
-		formatName = webpFormatName // Store the actual decoded format name
+	if decoded.Raw != nil {
+		res.Data = decoded.Raw
+		res.PassedThrough = true
+		res.Width, res.Height = int(decoded.Width), int(decoded.Height)
+		res.layoutWidth, res.layoutHeight = decoded.Width, decoded.Height
+		slog.Debug("Successfully processed image", "filename", decoded.OriginalFilename, "originalFormat", decoded.FormatName, "format", res.Format, "width", res.Width, "height", res.Height)
+		return res
+	}
 
-		// Handle 16-bit depth WebP by converting to 8-bit NRGBA before JPEG encoding
-		switch decodedImg.(type) {
+	img := decoded.Image
+	if decoded.FormatName == "webp" || decoded.ImageTypeForPDF == "PNG" {
+		// Handle 16-bit depth WebP by converting to 8-bit NRGBA before JPEG
+		// encoding; 16-bit PNGs are converted too, as gofpdf can't embed them.
+		switch img.(type) {
 		case *image.Gray16, *image.NRGBA64, *image.RGBA64:
-			slog.Debug("Converting 16-bit WebP image to 8-bit NRGBA", "filename", source.OriginalFilename)
-			// imaging.Clone converts to NRGBA which is 8-bit per channel
-			decodedImg = imaging.Clone(decodedImg)
+			slog.Debug("Converting 16-bit image to 8-bit NRGBA", "filename", decoded.OriginalFilename, "format", decoded.FormatName)
+			img = imaging.Clone(img) // imaging.Clone converts to NRGBA
 		}
+	}
 
-		buf := bufferPool.Get().(*bytes.Buffer)
-		buf.Reset()
-		if err := imaging.Encode(buf, decodedImg, imaging.JPEG, imaging.JPEGQuality(cfg.JPEGQuality)); err != nil {
-			bufferPool.Put(buf)
-			processedInfo.Error = fmt.Errorf("could not re-encode webp %s to jpg: %w", source.OriginalFilename, err)
-			return processedInfo
-		}
-		processedInfo.Reader = buf
-		processedInfo.Width = float64(decodedImg.Bounds().Dx())
-		processedInfo.Height = float64(decodedImg.Bounds().Dy())
-		processedInfo.ImageTypeForPDF = "JPG" // Always JPG for WebP
+	quality := cfg.pageQuality(decoded.Chapter, decoded.Index)
+	if cfg.SmartQuality && decoded.ImageTypeForPDF != "PNG" {
+		kind := classifyPage(img)
+		quality = smartJPEGQuality(kind, quality)
+		slog.Debug("Chose JPEG quality by page kind", "filename", decoded.OriginalFilename, "kind", kind, "quality", quality)
+	}
+	targetFormat := imaging.JPEG
+	encodeOptions := []imaging.EncodeOption{imaging.JPEGQuality(quality)}
+	if decoded.ImageTypeForPDF == "PNG" {
+		targetFormat = imaging.PNG
+		encodeOptions = nil
 	}
 
-	slog.Debug("Successfully processed image", "filename", source.OriginalFilename, "originalFormat", formatName, "pdfType", imageTypeForPDF, "width", processedInfo.Width, "height", processedInfo.Height)
-	return processedInfo
+	buf, reused := getBuffer()
+	if err := imaging.Encode(buf, img, targetFormat, encodeOptions...); err != nil {
+		bufferPool.Put(buf)
+		res.err = fmt.Errorf("could not re-encode %s (format %s) to %s: %w", decoded.OriginalFilename, decoded.FormatName, res.Format, err)
+		return res
+	}
+	res.buf, res.pooledReused = buf, reused
+	if targetFormat == imaging.JPEG {
+		res.quality = quality
+	}
+	res.untrack = trackResource(ctx, "pool buffer", decoded.OriginalFilename)
+	res.Data = buf.Bytes()
+	res.Width, res.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	res.layoutWidth, res.layoutHeight = float64(res.Width), float64(res.Height)
+	slog.Debug("Successfully processed image (re-encoded)", "filename", decoded.OriginalFilename, "originalFormat", decoded.FormatName, "format", res.Format, "width", res.Width, "height", res.Height)
+	return res
 }
 
-// processImagesConcurrently processes a list of ImageSource concurrently.
-func processImagesConcurrently(ctx context.Context, cfg *Config, imageSources []ImageSource) []ProcessedImage {
-	slog.Debug("Starting concurrent image processing", "numSources", len(imageSources), "numWorkers", cfg.NumWorkers)
-	if len(imageSources) == 0 {
-		return []ProcessedImage{}
+// processSingleImage runs both stages for a single ImageSource.
+// The source reader is always closed.
+func processSingleImage(ctx context.Context, cfg *Config, source ImageSource) pageResult {
+	decoded, err := decodeSource(ctx, cfg, source)
+	if err == nil {
+		err = chargePixels(ctx, decoded.OriginalFilename, decoded.pixels())
 	}
+	if err != nil {
+		return failedPage(source, err)
+	}
+	return encodeDecoded(ctx, cfg, decoded)
+}
 
-	processedImageChan := make(chan ProcessedImage, len(imageSources)) // Buffered channel
-	semaphoreChan := make(chan struct{}, cfg.NumWorkers)
-	var wg sync.WaitGroup
-	results := make([]ProcessedImage, len(imageSources))
+// stageWorkers returns the concurrency of the decode and encode stages,
+// falling back to NumWorkers for stages that are not configured.
+func (cfg *Config) stageWorkers() (decodeWorkers, encodeWorkers int) {
+	decodeWorkers, encodeWorkers = cfg.DecodeWorkers, cfg.EncodeWorkers
+	if decodeWorkers <= 0 {
+		decodeWorkers = cfg.NumWorkers
+	}
+	if encodeWorkers <= 0 {
+		encodeWorkers = cfg.NumWorkers
+	}
+	if decodeWorkers <= 0 {
+		decodeWorkers = 1
+	}
+	if encodeWorkers <= 0 {
+		encodeWorkers = 1
+	}
+	return decodeWorkers, encodeWorkers
+}
 
-	for i, source := range imageSources {
-		select {
-		case <-ctx.Done():
-			slog.Info("Cancellation detected before starting all goroutines for image sources", "lastProcessedIndex", i-1, "filename", source.OriginalFilename)
-			// Mark remaining as cancelled
-			for j := i; j < len(imageSources); j++ {
-				if results[j].OriginalFilename == "" { // Check if not already processed by a fast finishing goroutine
-					results[j] = ProcessedImage{Index: imageSources[j].Index, OriginalFilename: imageSources[j].OriginalFilename, Error: ctx.Err()}
-					if imageSources[j].Reader != nil {
-						imageSources[j].Reader.Close() // Ensure readers are closed
-					}
-				}
+// positionedResult tags a pageResult with its position in the input slice.
+type positionedResult struct {
+	position int
+	result   pageResult
+}
+
+// positionedDecode tags a decodedSource with its position in the input,
+// and the source it came from.
+type positionedDecode struct {
+	position int
+	source   ImageSource
+	decoded  decodedSource
+	cacheKey string // Where Config.PageCache is to keep the page; empty without one
+}
+
+// nextSource yields the sources a pipeline processes, one per call, with
+// their position in the input; ok is false once there are no more. It is
+// only called from one goroutine at a time.
+type nextSource func() (position int, src ImageSource, ok bool)
+
+// sliceSources returns a nextSource yielding imageSources in the given
+// order of positions.
+func sliceSources(imageSources []ImageSource, order []int) nextSource {
+	return func() (int, ImageSource, bool) {
+		if len(order) == 0 {
+			return 0, ImageSource{}, false
+		}
+		position := order[0]
+		order = order[1:]
+		return position, imageSources[position], true
+	}
+}
+
+// processImagesConcurrently processes a list of ImageSource as a two-stage
+// pipeline. Decoding (memory-bound) and encoding (CPU-bound) run in separate
+// worker pools sized by Config.DecodeWorkers and Config.EncodeWorkers. The
+// hand-off channel holds at most EncodeWorkers decoded images, so decoders
+// cannot run arbitrarily far ahead of the encoders.
+//
+// The returned slice has one entry per source, in input order. If stats is
+// not nil, the time spent in each stage is added to it.
+func processImagesConcurrently(ctx context.Context, cfg *Config, imageSources []ImageSource, stats *Stats) []pageResult {
+	if len(imageSources) == 0 {
+		return []pageResult{}
+	}
+	results := make([]pageResult, len(imageSources))
+	for res := range runPipeline(ctx, cfg, sliceSources(imageSources, scheduleOrder(cfg, imageSources)), len(imageSources), nil, stats) {
+		results[res.position] = res.result
+	}
+
+	// If the context was cancelled while collecting, successful results are unusable.
+	if ctx.Err() != nil {
+		for i := range results {
+			if results[i].err == nil {
+				results[i].release()
+				results[i].err = CancellationError(ctx)
 			}
-			goto endGoroutineLoop // Break out of the loop
-		default:
 		}
+	}
 
-		wg.Add(1)
-		go func(src ImageSource) {
-			defer wg.Done()
-			slog.Debug("Goroutine started for image source", "filename", src.OriginalFilename, "index", src.Index)
-			select {
-			case semaphoreChan <- struct{}{}:
-				defer func() { <-semaphoreChan }()
-			case <-ctx.Done():
-				slog.Debug("Cancellation detected before acquiring semaphore for image source", "filename", src.OriginalFilename)
-				if src.Reader != nil {
-					src.Reader.Close()
-				}
-				processedImageChan <- ProcessedImage{Index: src.Index, OriginalFilename: src.OriginalFilename, Error: ctx.Err()}
+	slog.Debug("Finished collecting image processing results.")
+	return results
+}
+
+// runPipeline feeds the sources next yields through the decode and encode
+// worker pools and sends one result per source, in completion order. When
+// slots is not nil a slot is taken before each source is asked for; the
+// consumer gives it back once done with the result. The returned channel
+// buffers up to pending results, at least as many as may be outstanding
+// for sends to never block. The stage timings are added to stats, if not
+// nil, before it is closed.
+func runPipeline(ctx context.Context, cfg *Config, next nextSource, pending int, slots chan struct{}, stats *Stats) <-chan positionedResult {
+	decodeWorkers, encodeWorkers := cfg.stageWorkers()
+	slog.Debug("Starting concurrent image processing", "pending", pending, "decodeWorkers", decodeWorkers, "encodeWorkers", encodeWorkers)
+
+	sourceChan := make(chan positionedDecode)
+	decodedChan := make(chan positionedDecode, encodeWorkers)
+	resultChan := make(chan positionedResult, pending) // Never blocks
+	var decodeNanos, encodeNanos, bytesDecoded, bytesEncoded, bufferGets, bufferHits, passedThrough, cached atomic.Int64
+
+	cancelled := func(src ImageSource) pageResult {
+		return failedPage(src, CancellationError(ctx))
+	}
+	formatLimits := cfg.formatLimits()
+	var cacheKeys pageCacheKeys
+	if cfg.PageCache != nil {
+		cacheKeys = newPageCacheKeys(cfg)
+	}
+
+	// Feed every source; workers close the readers of sources they skip after cancellation.
+	go func() {
+		defer close(sourceChan)
+		for {
+			if slots != nil {
+				slots <- struct{}{}
+			}
+			position, src, ok := next()
+			if !ok {
 				return
 			}
+			sourceChan <- positionedDecode{position: position, source: src}
+		}
+	}()
+	var sources <-chan positionedDecode = sourceChan
+	if cfg.ReadAhead > 0 {
+		sources = readAhead(ctx, sourceChan, cfg.ReadAhead)
+	}
 
-			// Check context again before potentially long operation
-			select {
-			case <-ctx.Done():
-				slog.Debug("Cancellation detected just before processing image source", "filename", src.OriginalFilename)
-				if src.Reader != nil {
-					src.Reader.Close()
+	var decodeWG sync.WaitGroup
+	for i := 0; i < decodeWorkers; i++ {
+		decodeWG.Add(1)
+		go func() {
+			defer decodeWG.Done()
+			for item := range sources {
+				position, src := item.position, item.source
+				if ctx.Err() != nil {
+					slog.Debug("Cancellation detected before decoding image source", "filename", src.OriginalFilename)
+					if src.Reader != nil {
+						src.Reader.Close()
+					}
+					resultChan <- positionedResult{position, cancelled(src)}
+					continue
 				}
-				processedImageChan <- ProcessedImage{Index: src.Index, OriginalFilename: src.OriginalFilename, Error: ctx.Err()}
-				return
-			default:
-				processedResult := processSingleImage(ctx, cfg, src) // src.Reader is closed by processSingleImage
-				select {
-				case processedImageChan <- processedResult:
-				case <-ctx.Done():
-					slog.Debug("Cancellation detected while trying to send result for image source", "filename", src.OriginalFilename)
-					// If result was successful but now cancelled, update error
-					if processedResult.Error == nil {
-						processedResult.Error = ctx.Err()
+				var cacheKey string
+				if cfg.PageCache != nil && src.Reader != nil && src.FetchErr == nil {
+					cfg.progress(ProgressStarted, src.Index, src.OriginalFilename, 0, nil)
+					res, key, done := cacheKeys.loadCachedPage(cfg, &src)
+					if done {
+						// A cached page counts against the budget as if it
+						// had been decoded, at the size it was stored.
+						if res.err == nil {
+							if err := chargePixels(ctx, src.OriginalFilename, int64(res.Width)*int64(res.Height)); err != nil {
+								resultChan <- positionedResult{position, failedPage(src, err)}
+								continue
+							}
+						}
+						if res.err != nil {
+							cfg.progress(ProgressFailed, src.Index, src.OriginalFilename, 0, res.err)
+						} else {
+							cached.Add(1)
+							cfg.progress(ProgressTransformed, src.Index, src.OriginalFilename, int64(len(res.Data)), nil)
+						}
+						resultChan <- positionedResult{position, res}
+						continue
 					}
-					// Clean up reader if it wasn't closed due to early exit in processSingleImage
-					if closer, ok := processedResult.Reader.(io.Closer); ok {
-						closer.Close()
-					} else if buf, ok := processedResult.Reader.(*bytes.Buffer); ok {
-						bufferPool.Put(buf)
+					cacheKey = key
+				}
+				formatLimit := formatLimits[decodeFormat(src.ContentType)] // nil without a limit
+				if err := acquireLimits(ctx, formatLimit, cfg.Limit); err != nil {
+					if src.Reader != nil {
+						src.Reader.Close()
 					}
-					// Attempt to send anyway for accounting, or it might block wg.Wait if channel is full and main routine exited.
-					// However, with buffered channel and proper draining, this might not be strictly necessary.
-					// For safety, try non-blocking send or ensure channel is drained.
-					// Since channel is buffered to len(imageSources), this send should not block.
-					processedImageChan <- processedResult
+					resultChan <- positionedResult{position, cancelled(src)}
+					continue
+				}
+				cfg.progress(ProgressStarted, src.Index, src.OriginalFilename, 0, nil)
+				started := time.Now()
+				decoded, err := decodeSource(ctx, cfg, src) // src.Reader is closed by decodeSource
+				decodeNanos.Add(int64(time.Since(started)))
+				cfg.Limit.release()
+				formatLimit.release()
+				bytesDecoded.Add(decoded.SourceBytes)
+				if err != nil {
+					if ctx.Err() == nil {
+						cfg.progress(ProgressFailed, src.Index, src.OriginalFilename, 0, err)
+					}
+					resultChan <- positionedResult{position, failedPage(src, err)}
+					continue
+				}
+				if err := chargePixels(ctx, decoded.OriginalFilename, decoded.pixels()); err != nil {
+					resultChan <- positionedResult{position, failedPage(src, err)}
+					continue
 				}
+				cfg.progress(ProgressDecoded, src.Index, src.OriginalFilename, decoded.SourceBytes, nil)
+				decodedChan <- positionedDecode{position, src, decoded, cacheKey}
 			}
-		}(source)
+		}()
 	}
+	go func() {
+		decodeWG.Wait()
+		close(decodedChan)
+	}()
 
-endGoroutineLoop:
-
+	var encodeWG sync.WaitGroup
+	for i := 0; i < encodeWorkers; i++ {
+		encodeWG.Add(1)
+		go func() {
+			defer encodeWG.Done()
+			for item := range decodedChan {
+				src := item.source
+				if ctx.Err() != nil {
+					slog.Debug("Cancellation detected before encoding image source", "filename", src.OriginalFilename)
+					resultChan <- positionedResult{item.position, cancelled(src)}
+					continue
+				}
+				if err := cfg.Limit.acquire(ctx); err != nil {
+					resultChan <- positionedResult{item.position, cancelled(src)}
+					continue
+				}
+				started := time.Now()
+				result := encodeDecoded(ctx, cfg, item.decoded)
+				encodeNanos.Add(int64(time.Since(started)))
+				cfg.Limit.release()
+				if result.err != nil {
+					cfg.progress(ProgressFailed, src.Index, src.OriginalFilename, 0, result.err)
+				} else {
+					if item.cacheKey != "" && !result.blank {
+						cfg.PageCache.Store(item.cacheKey, result.Page)
+					}
+					cfg.progress(ProgressTransformed, src.Index, src.OriginalFilename, int64(len(result.Data)), nil)
+				}
+				bytesEncoded.Add(int64(len(result.Data)))
+				if result.PassedThrough {
+					passedThrough.Add(1)
+				}
+				if result.buf != nil {
+					bufferGets.Add(1)
+					if result.pooledReused {
+						bufferHits.Add(1)
+					}
+				}
+				resultChan <- positionedResult{item.position, result}
+			}
+		}()
+	}
 	go func() {
-		wg.Wait()
-		close(processedImageChan)
-		close(semaphoreChan) // Close semaphore channel once all workers are done
+		encodeWG.Wait()
+		if stats != nil {
+			stats.DecodeTime += time.Duration(decodeNanos.Load())
+			stats.EncodeTime += time.Duration(encodeNanos.Load())
+			stats.BytesDecoded += bytesDecoded.Load()
+			stats.BytesEncoded += bytesEncoded.Load()
+			stats.BufferGets += bufferGets.Load()
+			stats.BufferHits += bufferHits.Load()
+			stats.PagesPassedThrough += int(passedThrough.Load())
+			stats.PagesCached += int(cached.Load())
+		}
+		close(resultChan)
 		slog.Debug("All image processing goroutines completed.")
 	}()
+	return resultChan
+}
 
-	// Collect results
-	// Initialize results with a placeholder to detect if a slot was filled
-	for i := range results {
-		results[i].Index = -1 // Mark as not filled
+// generatePDFFromPages generates a PDF from the pages of feed,
+// registering each image with gofpdf and releasing its buffer as soon as the
+// feed yields it. The writer `w` is where the PDF output will be written. It
+// returns the number of pages added; nothing is written when that is zero.
+// When tags is not nil, each image is written as /Figure marked content and
+// every PDF page is recorded in *tags for tagPDF. Every image placed is
+// recorded in provenance, if not nil, for addPieceInfo. A bookmark is added
+// at the first page of every chapter and, with cfg.PageBookmarks, at every
+// page; cfg.Captions are written onto their pages.
+func generatePDFFromPages(ctx context.Context, writer io.Writer, feed *pageFeed, pdf *gofpdf.Fpdf, tags *[]taggedPage, provenance *pageProvenance, cfg *Config) (pagesAdded int, err error) {
+	slog.Debug("Starting PDF generation from processed images")
+	defer feed.drain()
+
+	chapter := ""
+	var captions *captionWriter
+	if len(cfg.Captions) > 0 {
+		captions = newCaptionWriter(pdf)
 	}
-
-	for res := range processedImageChan {
-		if res.Index >= 0 && res.Index < len(results) {
-			results[res.Index] = res
-		} else {
-			slog.Error("Received processed image with out-of-bounds index", "index", res.Index, "filename", res.OriginalFilename)
-			// Clean up resources if any, though processSingleImage should handle its own.
-			if res.Error == nil { // If no error but bad index, still clean up reader
-				if closer, ok := res.Reader.(io.Closer); ok {
-					closer.Close()
-				} else if buf, ok := res.Reader.(*bytes.Buffer); ok {
-					bufferPool.Put(buf)
-				}
-			}
+	var watermark *watermarkStamper
+	if cfg.Watermark != nil {
+		if watermark, err = newWatermarkStamper(pdf, cfg.Watermark); err != nil {
+			return 0, err
 		}
 	}
-
-	// Ensure all results slots are filled, especially if cancellation happened early
-	if ctx.Err() != nil {
-		for _, src := range imageSources {
-			// Check if the result for this index was not set or was set but then processing was cancelled
-			// If results[src.Index] is still the initial placeholder or has no error yet.
-			// src.Index should be the correct one.
-			if src.Index >= 0 && src.Index < len(results) && (results[src.Index].Index == -1 || results[src.Index].OriginalFilename == "") {
-				results[src.Index] = ProcessedImage{Index: src.Index, OriginalFilename: src.OriginalFilename, Error: ctx.Err()}
-			} else if src.Index >= 0 && src.Index < len(results) && results[src.Index].Error == nil {
-				// If it was processed but context cancelled during collection, ensure error is set
-				results[src.Index].Error = ctx.Err()
-				// Clean up associated reader if it exists and is not already closed
-				if closer, ok := results[src.Index].Reader.(io.Closer); ok {
-					closer.Close()
-				} else if buf, ok := results[src.Index].Reader.(*bytes.Buffer); ok {
-					bufferPool.Put(buf)
-				}
-				results[src.Index].Reader = nil // Nullify reader as it's unusable
+	var sheets *printSheets
+	if cfg.PrintLayout != nil {
+		sheets = newPrintSheets(pdf, cfg)
+	}
+	var frames *pageFrames
+	if sheets == nil {
+		frames = newPageFrames(pdf, cfg)
+	}
+	var placeholders *placeholderPages
+	if cfg.Placeholders {
+		placeholders = newPlaceholderPages(pdf)
+	}
+	// addPage starts the page, or print sheet cell, for an image laid out at
+	// width x height and returns where the image goes.
+	addPage := func(width, height float64) (page gofpdf.SizeType, x, y, w, h float64) {
+		if sheets != nil {
+			x, y, w, h = sheets.place(width, height)
+			return page, x, y, w, h
+		}
+		return frames.add(width, height)
+	}
+	// pageNo returns the number of the page just added.
+	pageNo := func() int {
+		if sheets != nil {
+			return sheets.placed
+		}
+		return pdf.PageNo()
+	}
+	bookmark := func(src PageSource, y float64) {
+		pageLevel := 0
+		if src.Chapter != "" {
+			if src.Chapter != chapter {
+				pdf.Bookmark(bookmarkText(src.Chapter), 0, max(y, 0))
+				chapter = src.Chapter
 			}
+			pageLevel = 1
+		}
+		if cfg.PageBookmarks {
+			pdf.Bookmark(bookmarkText(fmt.Sprintf("Page %d", pageNo())), pageLevel, max(y, 0))
 		}
 	}
-
-	slog.Debug("Finished collecting image processing results.")
-	return results
-}
-
-// generatePDFFromProcessedImages generates a PDF from a slice of ProcessedImage.
-// The writer `w` is where the PDF output will be written.
-func generatePDFFromProcessedImages(ctx context.Context, writer io.Writer, processedImages []ProcessedImage, pdf *gofpdf.Fpdf) (hasContent bool, err error) {
-	slog.Debug("Starting PDF generation from processed images", "numImages", len(processedImages))
-	hasContent = false
-
-	// Sort processedImages by original index to ensure correct order in PDF
-	sort.SliceStable(processedImages, func(i, j int) bool {
-		return processedImages[i].Index < processedImages[j].Index
-	})
-
-	for i, res := range processedImages {
+	for i := 0; ; i++ {
+		res, ok := feed.next()
+		if !ok {
+			break
+		}
 		select {
 		case <-ctx.Done():
-			slog.Info("Cancellation detected before adding image to PDF", "filename", res.OriginalFilename)
-			// Clean up reader if processing was successful but cancelled here
-			if res.Error == nil {
-				if closer, ok := res.Reader.(io.Closer); ok {
-					closer.Close()
-				} else if buf, ok := res.Reader.(*bytes.Buffer); ok {
-					bufferPool.Put(buf)
-				}
-			}
-			return hasContent, ctx.Err()
+			slog.Info("Cancellation detected before adding image to PDF", "filename", res.Source.Filename)
+			res.release()
+			return pagesAdded, CancellationError(ctx)
 		default:
 		}
 
-		if res.Error != nil {
-			if errors.Is(res.Error, context.Canceled) {
-				slog.Debug("Skipping image due to earlier cancellation", "filename", res.OriginalFilename)
-			} else {
-				slog.Warn("Skipping image due to error during its processing", "filename", res.OriginalFilename, "error", res.Error)
-			}
-			// Ensure any associated reader/buffer is cleaned up if an error occurred during processing
-			if closer, ok := res.Reader.(io.Closer); ok {
-				closer.Close()
-			} else if buf, ok := res.Reader.(*bytes.Buffer); ok {
-				bufferPool.Put(buf)
+		if res.err != nil {
+			switch {
+			case errors.Is(res.err, context.Canceled):
+				slog.Debug("Skipping image due to earlier cancellation", "filename", res.Source.Filename)
+			case placeholders != nil:
+				slog.Warn("Adding placeholder page for image that failed", "filename", res.Source.Filename, "error", res.err)
+				page, x, y, w, h := addPage(placeholders.width, placeholders.height)
+				clipped := frames != nil && frames.clip(page, w, h)
+				headline := placeholderHeadline(pageNo(), res.err)
+				if tags != nil {
+					*tags = append(*tags, taggedPage{Alt: headline})
+				}
+				placeholders.draw(headline, res.err, x, y, w, h)
+				if clipped {
+					pdf.ClipEnd()
+				}
+				bookmark(res.Source, y)
+				feed.stats.PagesPlaceholder++
+			default:
+				slog.Warn("Skipping image due to error during its processing", "filename", res.Source.Filename, "error", res.err)
 			}
+			res.release()
 			continue
 		}
-		if res.Reader == nil {
-			slog.Warn("Reader for image is nil, skipping", "filename", res.OriginalFilename)
+		if len(res.Data) == 0 {
+			slog.Warn("Image data is empty, skipping", "filename", res.Source.Filename)
 			continue
 		}
+		encodedBytes := int64(len(res.Data))
 
-		slog.Debug("Adding image to PDF", "filename", res.OriginalFilename, "width", res.Width, "height", res.Height, "type", res.ImageTypeForPDF)
-
-		// Ensure the reader is handled correctly (closed or buffer returned to pool)
-		readerToClean := res.Reader
-		defer func(r io.Reader) {
-			if fCloser, ok := r.(*os.File); ok { // Should not happen with API based sources
-				fCloser.Close()
-			} else if bReader, ok := r.(*bytes.Buffer); ok {
-				bufferPool.Put(bReader)
-			} else if rc, ok := r.(io.ReadCloser); ok { // Generic ReadCloser from ImageSource after processing
-				rc.Close()
-			}
-		}(readerToClean)
+		slog.Debug("Adding image to PDF", "filename", res.Source.Filename, "width", res.layoutWidth, "height", res.layoutHeight, "type", res.pdfImageType())
 
-		pdf.AddPageFormat("P", gofpdf.SizeType{Wd: res.Width, Ht: res.Height})
+		page, x, y, w, h := addPage(res.layoutWidth, res.layoutHeight)
+		if placeholders != nil {
+			placeholders.follow(res.layoutWidth, res.layoutHeight)
+		}
 		if pdf.Err() {
-			slog.Warn("Could not add page to PDF for image", "filename", res.OriginalFilename, "error", pdf.Error())
+			slog.Warn("Could not add page to PDF for image", "filename", res.Source.Filename, "error", pdf.Error())
+			cfg.progress(ProgressFailed, res.Source.Index, res.Source.Filename, 0, pdf.Error())
 			pdf.ClearError()
+			res.release()
 			continue // Skip this image
 		}
+		caption := cfg.captionFor(res.Source.Filename, pdf.PageNo())
+		if tags != nil {
+			alt := caption
+			if alt == "" {
+				alt = fmt.Sprintf("Page %d: %s", pdf.PageNo(), path.Base(res.Source.Filename))
+			}
+			*tags = append(*tags, taggedPage{Alt: alt})
+		}
 
-		imageName := fmt.Sprintf("image%d_%d", res.Index, i) // Ensure unique name
-		// Use res.Reader directly. It's either a *bytes.Buffer (for webp/re-encoded) or a *bytes.Reader (for direct jpg/png)
-		pdf.RegisterImageOptionsReader(imageName, gofpdf.ImageOptions{ImageType: res.ImageTypeForPDF, ReadDpi: false}, res.Reader)
+		imageName := fmt.Sprintf("image%d_%d", res.Source.Index, i) // Ensure unique name
+		pdf.RegisterImageOptionsReader(imageName, gofpdf.ImageOptions{ImageType: res.pdfImageType(), ReadDpi: false}, bytes.NewReader(res.Data))
+		// gofpdf keeps its own copy of the image data, so the buffer can go
+		// back to the pool before the next page arrives.
+		res.release()
 
 		if pdf.Err() {
-			slog.Warn("Could not register image in PDF", "filename", res.OriginalFilename, "error", pdf.Error())
+			slog.Warn("Could not register image in PDF", "filename", res.Source.Filename, "error", pdf.Error())
+			cfg.progress(ProgressFailed, res.Source.Index, res.Source.Filename, 0, pdf.Error())
 			pdf.ClearError()
 			continue // Skip this image
 		}
 
-		pdf.ImageOptions(imageName, 0, 0, res.Width, res.Height, false, gofpdf.ImageOptions{ImageType: res.ImageTypeForPDF}, 0, "")
+		if tags != nil {
+			pdf.RawWriteStr("/Figure <</MCID 0>> BDC")
+		}
+		clipped := frames != nil && frames.clip(page, w, h)
+		pdf.ImageOptions(imageName, x, y, w, h, false, gofpdf.ImageOptions{ImageType: res.pdfImageType()}, 0, "")
+		if clipped {
+			pdf.ClipEnd()
+		}
+		if tags != nil {
+			pdf.RawWriteStr("EMC")
+		}
 		if pdf.Err() {
-			slog.Warn("Could not place image on PDF page", "filename", res.OriginalFilename, "error", pdf.Error())
+			slog.Warn("Could not place image on PDF page", "filename", res.Source.Filename, "error", pdf.Error())
+			cfg.progress(ProgressFailed, res.Source.Index, res.Source.Filename, 0, pdf.Error())
 			pdf.ClearError()
 			continue // Skip this image
 		}
-		hasContent = true
-		slog.Debug("Successfully added image to PDF", "filename", res.OriginalFilename)
+		if tags != nil {
+			(*tags)[len(*tags)-1].Figure = true
+		}
+		if caption != "" {
+			captions.write(caption, page.Wd, tags != nil)
+		}
+		if watermark != nil {
+			watermark.stamp(page.Wd, page.Ht, tags != nil)
+		}
+		bookmark(res.Source, y)
+		provenance.add(pdf.PageNo(), res)
+		pagesAdded++
+		cfg.progress(ProgressPageAdded, res.Source.Index, res.Source.Filename, encodedBytes, nil)
+		slog.Debug("Successfully added image to PDF", "filename", res.Source.Filename)
 	}
 
 	if pdf.Err() { // Check for any accumulated errors in gofpdf
-		return hasContent, fmt.Errorf("error generating PDF structure: %w", pdf.Error())
+		return pagesAdded, fmt.Errorf("error generating PDF structure: %w", pdf.Error())
 	}
 
 	select {
 	case <-ctx.Done():
 		slog.Info("Cancellation detected before writing PDF output.")
-		return hasContent, ctx.Err()
+		return pagesAdded, CancellationError(ctx)
 	default:
 	}
 
-	if hasContent {
+	if pagesAdded > 0 {
 		slog.Debug("Writing PDF to output stream...")
 		if err := pdf.Output(writer); err != nil {
-			return true, fmt.Errorf("could not write PDF to writer: %w", err)
+			return pagesAdded, fmt.Errorf("could not write PDF to writer: %w", err)
 		}
 		slog.Debug("Successfully wrote PDF to output stream.")
 	} else {
 		if ctx.Err() != nil { // If context was cancelled, and no content, return context error
-			return false, ctx.Err()
+			return 0, CancellationError(ctx)
 		}
 		// If no content but also no cancellation, it means all images failed or were skipped.
-		if len(processedImages) > 0 {
+		if feed.results > 0 {
 			slog.Info("No content was added to the PDF (all images skipped or failed).")
 		} else {
 			slog.Info("No images processed and no content to add to PDF.")
 		}
 	}
-	return hasContent, nil
+	return pagesAdded, nil
+}
+
+// setPDFMetadata fills the PDF info dictionary from cfg.
+func setPDFMetadata(pdf *gofpdf.Fpdf, cfg *Config) {
+	if title := cfg.documentTitle(); title != "" {
+		pdf.SetTitle(title, true)
+	}
+	if cfg.Author != "" {
+		pdf.SetAuthor(cfg.Author, true)
+	}
+	if cfg.Subject != "" {
+		pdf.SetSubject(cfg.Subject, true)
+	}
+	if cfg.Keywords != "" {
+		pdf.SetKeywords(cfg.Keywords, true)
+	}
+}
+
+// documentTitle is the title for the document metadata: Title, or else the
+// output filename without its extension.
+func (cfg *Config) documentTitle() string {
+	if cfg.Title != "" {
+		return cfg.Title
+	}
+	return strings.TrimSuffix(cfg.OutputFilename, path.Ext(cfg.OutputFilename))
+}
+
+// bookmarkText encodes s for gofpdf's Bookmark, which writes titles
+// byte for byte: as UTF-16BE with a byte order mark, PDF readers show any
+// chapter name correctly.
+func bookmarkText(s string) string {
+	var b strings.Builder
+	b.WriteString("\xFE\xFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		b.WriteByte(byte(u >> 8))
+		b.WriteByte(byte(u))
+	}
+	return b.String()
 }
 
 // ConvertToPDF is the main entry point for the converter package.
 // It takes a context, a list of ImageSource, a Config, and an io.Writer for the PDF output.
 // It returns true if content was added to the PDF, and an error if one occurred.
 func ConvertToPDF(ctx context.Context, sources []ImageSource, cfg *Config, writer io.Writer) (hasContent bool, err error) {
+	return ConvertToPDFWithStats(ctx, sources, cfg, writer, nil)
+}
+
+// ConvertToPDFWithStats is ConvertToPDF that also fills in stats, if not nil,
+// with page counts and per-stage timings. With cfg.OutputFormat set to
+// FormatEPUB a fixed-layout EPUB is written instead of a PDF.
+func ConvertToPDFWithStats(ctx context.Context, sources []ImageSource, cfg *Config, writer io.Writer, stats *Stats) (hasContent bool, err error) {
+	ctx, leaks := trackLeaks(ctx)
+	defer leaks.report()
+	sources = leaks.trackSources(sources)
+	if stats == nil {
+		stats = &Stats{}
+	}
+	if err := cfg.Validate(); err != nil {
+		for _, src := range sources {
+			if src.Reader != nil {
+				src.Reader.Close()
+			}
+		}
+		return false, err
+	}
+	if cfg.TargetSize > 0 {
+		return convertToTargetSize(ctx, sources, cfg, writer, stats)
+	}
+	ctx, stopBudget := withPixelBudget(ctx, cfg)
+	defer stopBudget(nil)
 	slog.Debug("Starting PDF conversion process via converter package", "numSources", len(sources))
 	select {
 	case <-ctx.Done():
-		return false, ctx.Err()
+		for _, src := range sources {
+			if src.Reader != nil {
+				src.Reader.Close()
+			}
+		}
+		return false, CancellationError(ctx)
 	default:
 	}
 
@@ -551,9 +1213,9 @@ func ConvertToPDF(ctx context.Context, sources []ImageSource, cfg *Config, write
 	// Filter out sources that are obviously invalid before concurrent processing
 	validSources := make([]ImageSource, 0, len(sources))
 	for _, src := range sources {
-		if src.Reader == nil && src.URL == "" {
+		if src.Reader == nil && src.URL == "" && src.FetchErr == nil {
 			slog.Warn("Skipping image source with no reader and no URL", "originalFilename", src.OriginalFilename, "index", src.Index)
-			// Potentially create a ProcessedImage with an error for this source if strict result parity is needed.
+			// Potentially create a failed page result for this source if strict result parity is needed.
 			// For now, just skip. The API handler will be responsible for creating valid ImageSource objects.
 			continue
 		}
@@ -572,82 +1234,92 @@ func ConvertToPDF(ctx context.Context, sources []ImageSource, cfg *Config, write
 		return false, ErrNoSupportedImages
 	}
 
+	if cfg.GIFFrames {
+		validSources = expandGIFFrames(validSources)
+	}
 	slog.Info("Processing valid image sources", "count", len(validSources))
+	stats.Sources = len(validSources)
+
+	// Pages are streamed into the document as they are processed, in input
+	// order, unless normalizing widths needs every page before the first one
+	// can be placed.
+	processStarted := time.Now()
+	var feed *pageFeed
+	if cfg.NormalizeWidth {
+		processedImageInfos := processImagesConcurrently(ctx, cfg, validSources, stats)
+		stats.ProcessTime = time.Since(processStarted)
+		if filter := newPageFilter(cfg, stats); filter != nil {
+			processedImageInfos = dropPages(processedImageInfos, filter)
+		}
+		normalizePageWidths(processedImageInfos)
+		feed = sliceFeed(processedImageInfos, stats)
+	} else {
+		feed = &pageFeed{pages: streamProcessedImages(ctx, cfg, validSources, cfg.streamWindow(), stats), stats: stats, started: processStarted, filter: newPageFilter(cfg, stats)}
+	}
+	return writeDocument(ctx, cfg, feed, writer, stats)
+}
 
+// writeDocument writes the pages of feed as a PDF, or an EPUB with
+// cfg.OutputFormat set to FormatEPUB, and reports whether any page was
+// added. A conversion without pages fails with ErrNoSupportedImages, or
+// with the cancellation that kept them out. gofpdf keeps every image
+// registered with it until the document is output, so a PDF is held in
+// memory whole before anything reaches writer.
+func writeDocument(ctx context.Context, cfg *Config, feed *pageFeed, writer io.Writer, stats *Stats) (hasContent bool, err error) {
 	pdf := gofpdf.New("P", "pt", "A4", "") // Default page size, actual size set per image
+	setPDFMetadata(pdf, cfg)
 
-	// Process images concurrently
-	processedImageInfos := processImagesConcurrently(ctx, cfg, validSources)
-
-	// Ensure all readers from original sources that might not have been consumed by
-	// processImagesConcurrently (e.g. due to early cancellation) are closed.
-	// processSingleImage is responsible for closing readers it processes.
-	// Goroutines in processImagesConcurrently also attempt to close readers on cancellation.
-	// This is a final safeguard.
-	processedIndexes := make(map[int]bool)
-	for _, pInfo := range processedImageInfos {
-		processedIndexes[pInfo.Index] = true
-	}
-	for _, src := range validSources {
-		if !processedIndexes[src.Index] && src.Reader != nil {
-			// This source was intended for processing but didn't make it into processedImageInfos
-			// or its goroutine exited very early.
-			slog.Debug("Closing reader for unprocessed or early-cancelled source", "filename", src.OriginalFilename, "index", src.Index)
-			src.Reader.Close()
+	// Generate PDF from processed images
+	pdfStarted := time.Now()
+	output := &countingWriter{w: writer}
+	var pagesAdded int
+	var genErr error
+	switch {
+	case cfg.OutputFormat == FormatEPUB:
+		pagesAdded, genErr = generateEPUBFromPages(ctx, output, feed, cfg)
+	case cfg.RightToLeft || cfg.Tagged || cfg.Language != "" || cfg.Provenance:
+		// Tags, language, reading direction and provenance are patched into
+		// the finished document, so it is assembled in memory first.
+		var buf bytes.Buffer
+		var tags *[]taggedPage
+		if cfg.Tagged {
+			tags = new([]taggedPage)
 		}
-	}
-
-	select {
-	case <-ctx.Done():
-		slog.Info("Cancellation detected before PDF generation phase in ConvertToPDF.")
-		// Clean up any readers from successfully processed images that won't be used
-		for _, info := range processedImageInfos {
-			if info.Error == nil || !errors.Is(info.Error, context.Canceled) {
-				if closer, ok := info.Reader.(io.Closer); ok {
-					closer.Close()
-				} else if buf, ok := info.Reader.(*bytes.Buffer); ok {
-					bufferPool.Put(buf)
-				}
-			}
+		var provenance *pageProvenance
+		if cfg.Provenance {
+			provenance = newPageProvenance()
+		}
+		pagesAdded, genErr = generatePDFFromPages(ctx, &buf, feed, pdf, tags, provenance, cfg)
+		if genErr == nil && pagesAdded > 0 {
+			genErr = writePatchedPDF(output, buf.Bytes(), tags, provenance, cfg)
 		}
-		return false, ctx.Err()
 	default:
+		pagesAdded, genErr = generatePDFFromPages(ctx, output, feed, pdf, nil, nil, cfg)
+	}
+	stats.PDFTime = time.Since(pdfStarted)
+	stats.PagesAdded = pagesAdded
+	stats.OutputBytes = output.n
+	contentAdded := pagesAdded > 0
+	if overPixelBudget(ctx) {
+		slog.Warn("Conversion stopped by its pixel budget", "error", context.Cause(ctx), "pagesAdded", pagesAdded)
+		return contentAdded, CancellationError(ctx)
 	}
-
-	// Generate PDF from processed images
-	contentAdded, genErr := generatePDFFromProcessedImages(ctx, writer, processedImageInfos, pdf)
 	if genErr != nil {
-		if errors.Is(genErr, context.Canceled) {
-			slog.Info("PDF generation was canceled.")
-			return contentAdded, context.Canceled // Return contentAdded status along with cancellation
+		if ctx.Err() != nil {
+			slog.Info("PDF generation was canceled.", "reason", CancellationReason(genErr))
+			return contentAdded, genErr // Return contentAdded status along with cancellation
 		}
 		slog.Error("Failed during PDF generation", "error", genErr)
 		return contentAdded, fmt.Errorf("pdf generation failed: %w", genErr)
 	}
 
-	if !contentAdded && len(validSources) > 0 {
-		// Check if any processed image had an error OTHER than cancellation.
-		// If all errors are cancellations, then the overall status is cancellation.
-		// If there are other errors, it's more like "no content due to errors".
-		allCancelled := true
-		hasOtherErrors := false
-		for _, pInfo := range processedImageInfos {
-			if pInfo.Error != nil {
-				if !errors.Is(pInfo.Error, context.Canceled) {
-					allCancelled = false
-					hasOtherErrors = true
-					break
-				}
-			} else {
-				// If an image was processed successfully but not added (e.g. PDF error for that specific image)
-				// this also means not all were cancelled.
-				allCancelled = false
-			}
-		}
+	if !contentAdded {
+		// If every source failed because of cancellation, the overall status
+		// is cancellation; otherwise it's "no content due to errors".
 		if ctx.Err() != nil { // Global context cancellation
-			return false, ctx.Err()
+			return false, CancellationError(ctx)
 		}
-		if allCancelled && !hasOtherErrors && len(processedImageInfos) > 0 { // All were attempted but cancelled
+		if feed.results > 0 && feed.canceled == feed.results { // All were attempted but cancelled
 			return false, context.Canceled // Or a more specific error if needed
 		}
 		// If no content and not due to cancellation of all items, return ErrNoSupportedImages
@@ -661,65 +1333,11 @@ func ConvertToPDF(ctx context.Context, sources []ImageSource, cfg *Config, write
 // Helper function to determine content type from file extension
 // This is a fallback if http.DetectContentType is not sufficient or not available (e.g. from filename only)
 func GetContentTypeFromFilename(filename string) string {
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".png":
-		return "image/png"
-	case ".webp":
-		return "image/webp"
-	default:
-		return "" // Unknown
-	}
-}
-
-// FetchImage downloads an image from a URL.
-// It returns an ImageSource with the Reader populated, or an error.
-// The caller is responsible for closing the ImageSource.Reader.
-func FetchImage(ctx context.Context, imageURL string, index int) (ImageSource, error) {
-	slog.Debug("Fetching image from URL", "url", imageURL, "index", index)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
-	if err != nil {
-		slog.Error("Failed to create request for URL", "url", imageURL, "error", err)
-		return ImageSource{}, fmt.Errorf("failed to create request for %s: %w", imageURL, err)
-	}
-
-	client := &http.Client{} // Consider customizing timeout
-	resp, err := client.Do(req)
-	if err != nil {
-		slog.Error("Failed to fetch image from URL", "url", imageURL, "error", err)
-		return ImageSource{}, fmt.Errorf("failed to fetch %s: %w", imageURL, err)
-	}
-	// Caller must close resp.Body via ImageSource.Reader.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		slog.Warn("Failed to fetch image, non-OK status", "url", imageURL, "status", resp.StatusCode)
-		return ImageSource{}, fmt.Errorf("failed to fetch %s: status %s", imageURL, resp.Status)
-	}
-
-	contentType := resp.Header.Get("Content-Type")
-	// Basic validation of content type
-	if !strings.HasPrefix(strings.ToLower(contentType), "image/") {
-		resp.Body.Close()
-		slog.Warn("Unsupported content type from URL", "url", imageURL, "contentType", contentType)
-		return ImageSource{}, fmt.Errorf("%w: %s from %s", ErrUnsupportedContentType, contentType, imageURL)
-	}
-
-	// Try to get a filename from URL
-	filename := filepath.Base(imageURL)
-	parsedURL, parseErr := url.ParseRequestURI(imageURL)
-	if parseErr == nil {
-		filename = filepath.Base(parsedURL.Path)
+	ext := strings.ToLower(path.Ext(filename))
+	for _, format := range inputFormats {
+		if slices.Contains(format.Extensions, ext) {
+			return format.ContentType
+		}
 	}
-
-	return ImageSource{
-		OriginalFilename: filename,
-		Reader:           resp.Body, // This is an io.ReadCloser
-		URL:              imageURL,
-		ContentType:      contentType,
-		Index:            index,
-	}, nil
+	return "" // Unknown
 }
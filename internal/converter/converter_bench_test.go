@@ -0,0 +1,111 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"runtime"
+	"testing"
+)
+
+// syntheticPage renders a w×h page with gradients and line work so that the
+// encoders have realistic (not trivially compressible) content.
+func syntheticPage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*7 + y*13) % 256)
+			if (x/9+y/5)%11 == 0 {
+				v = 0 // Ink lines
+			}
+			img.Set(x, y, color.RGBA{v, uint8(255 - int(v)), uint8(x % 256), 255})
+		}
+	}
+	return img
+}
+
+// mixedVolume returns encoded pages alternating between JPEGs (pass-through,
+// decode stage only) and PNGs served with an unknown content type (decoded
+// and re-encoded, exercising the encode stage).
+func mixedVolume(b *testing.B, pages, w, h int) [][]byte {
+	b.Helper()
+	page := syntheticPage(w, h)
+	var jpegData, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, page, &jpeg.Options{Quality: 90}); err != nil {
+		b.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	if err := png.Encode(&pngData, page); err != nil {
+		b.Fatalf("Failed to encode PNG: %v", err)
+	}
+	volume := make([][]byte, pages)
+	for i := range volume {
+		if i%2 == 0 {
+			volume[i] = jpegData.Bytes()
+		} else {
+			volume[i] = pngData.Bytes()
+		}
+	}
+	return volume
+}
+
+func volumeSources(volume [][]byte) []ImageSource {
+	sources := make([]ImageSource, len(volume))
+	for i, data := range volume {
+		contentType := "image/jpeg"
+		if i%2 == 1 {
+			contentType = "application/octet-stream"
+		}
+		sources[i] = ImageSource{
+			OriginalFilename: fmt.Sprintf("%03d", i),
+			Reader:           io.NopCloser(bytes.NewReader(data)),
+			ContentType:      contentType,
+			Index:            i,
+		}
+	}
+	return sources
+}
+
+// BenchmarkProcessImagesConcurrently compares a single shared worker budget
+// with split decode/encode stages on a large mixed-format volume. Limiting
+// decoders bounds how many decoded pages are alive at once while the
+// encoders keep every CPU busy; compare on a multi-core machine with
+// go test -bench ProcessImagesConcurrently -benchmem ./internal/converter
+func BenchmarkProcessImagesConcurrently(b *testing.B) {
+	originalLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer slog.SetDefault(originalLogger)
+
+	volume := mixedVolume(b, 16, 1000, 1400)
+	cpus := runtime.NumCPU()
+	variants := []struct {
+		name           string
+		decode, encode int
+	}{
+		{"shared", cpus, cpus},
+		{"split-decode2", 2, cpus},
+		{"split-decode1", 1, cpus},
+	}
+	for _, v := range variants {
+		b.Run(v.name, func(b *testing.B) {
+			cfg := NewDefaultConfig()
+			cfg.DecodeWorkers = v.decode
+			cfg.EncodeWorkers = v.encode
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				results := processImagesConcurrently(context.Background(), cfg, volumeSources(volume), nil)
+				for _, res := range results {
+					if res.err != nil {
+						b.Fatalf("Unexpected processing error: %v", res.err)
+					}
+					res.release()
+				}
+			}
+		})
+	}
+}
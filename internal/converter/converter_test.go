@@ -7,8 +7,6 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
-	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -27,6 +25,17 @@ func newStringImageSource(name, content, contentType string, index int) ImageSou
 	}
 }
 
+// blockingReader blocks every Read until release is closed, then reports EOF.
+// It keeps workers busy so cancellation reliably reaches queued sources.
+type blockingReader struct {
+	release <-chan struct{}
+}
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	<-r.release
+	return 0, io.EOF
+}
+
 // Helper to create a dummy ImageSource from a file
 func newFileImageSource(t *testing.T, filename, contentType string, index int) ImageSource {
 	t.Helper()
@@ -42,7 +51,6 @@ func newFileImageSource(t *testing.T, filename, contentType string, index int) I
 		// These tests might focus on flow rather than actual image decoding.
 	}
 
-
 	file, err := os.Open(path)
 	if err != nil {
 		t.Fatalf("Failed to open test file %s: %v", path, err)
@@ -86,16 +94,14 @@ func TestProcessSingleImage_InvalidData(t *testing.T) {
 
 	processedImg := processSingleImage(ctx, cfg, source)
 
-	if processedImg.Error == nil {
+	if processedImg.err == nil {
 		t.Errorf("Expected error for invalid image data, got nil. Logs: %s", logBuf.String())
 	} else {
-		t.Logf("Received expected error for invalid data: %v", processedImg.Error)
+		t.Logf("Received expected error for invalid data: %v", processedImg.err)
 	}
-	if processedImg.Reader != nil {
-		t.Error("Expected reader to be nil on error")
-		if closer, ok := processedImg.Reader.(io.Closer); ok {
-			closer.Close()
-		}
+	if processedImg.Data != nil {
+		t.Error("Expected no page data on error")
+		processedImg.release()
 	}
 }
 
@@ -107,8 +113,8 @@ func TestProcessSingleImage_ContextCancellation(t *testing.T) {
 	source := newStringImageSource("test.jpg", "dummy_jpeg_data", "image/jpeg", 0)
 	processedImg := processSingleImage(ctx, cfg, source)
 
-	if !errors.Is(processedImg.Error, context.Canceled) {
-		t.Errorf("Expected context.Canceled error, got %v", processedImg.Error)
+	if !errors.Is(processedImg.err, context.Canceled) {
+		t.Errorf("Expected context.Canceled error, got %v", processedImg.err)
 	}
 }
 
@@ -151,7 +157,6 @@ func TestConvertToPDF_AllSourcesError(t *testing.T) {
 		t.Errorf("Expected ErrNoSupportedImages or similar, got %v", err)
 	}
 
-
 	if hasContent {
 		t.Error("Expected no content when all sources fail")
 	}
@@ -181,85 +186,6 @@ func TestConvertToPDF_ContextCancellation(t *testing.T) {
 	}
 }
 
-// This test requires a running HTTP server for FetchImage.
-// We'll use httptest.NewServer.
-func TestFetchImage_Success(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "image/jpeg")
-		fmt.Fprint(w, "fake_jpeg_data")
-	}))
-	defer server.Close()
-
-	ctx := context.Background()
-	imgSrc, err := FetchImage(ctx, server.URL, 0)
-	if err != nil {
-		t.Fatalf("FetchImage failed: %v", err)
-	}
-	defer imgSrc.Reader.Close()
-
-	if imgSrc.ContentType != "image/jpeg" {
-		t.Errorf("Expected content type image/jpeg, got %s", imgSrc.ContentType)
-	}
-	if imgSrc.OriginalFilename == "" {
-		t.Error("Expected a filename to be derived from URL")
-	}
-	data, _ := io.ReadAll(imgSrc.Reader)
-	if string(data) != "fake_jpeg_data" {
-		t.Errorf("Expected 'fake_jpeg_data', got '%s'", string(data))
-	}
-}
-
-func TestFetchImage_NotFound(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNotFound)
-	}))
-	defer server.Close()
-
-	ctx := context.Background()
-	_, err := FetchImage(ctx, server.URL, 0)
-	if err == nil {
-		t.Fatal("Expected error for 404 Not Found, got nil")
-	}
-	t.Logf("Received expected error for 404: %v", err)
-}
-
-func TestFetchImage_UnsupportedContentType(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprint(w, "<html></html>")
-	}))
-	defer server.Close()
-
-	ctx := context.Background()
-	imgSrc, err := FetchImage(ctx, server.URL, 0)
-	if err == nil {
-		imgSrc.Reader.Close() // Close reader if FetchImage unexpectedly succeeded
-		t.Fatal("Expected error for unsupported content type, got nil")
-	}
-	if !errors.Is(err, ErrUnsupportedContentType) {
-		t.Errorf("Expected ErrUnsupportedContentType, got %v", err)
-	}
-	t.Logf("Received expected error for unsupported content type: %v", err)
-}
-
-func TestFetchImage_ContextCancellation(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(100 * time.Millisecond) // Ensure request starts
-		fmt.Fprint(w, "slow_response")
-	}))
-	defer server.Close()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel() // Cancel before request can complete
-
-	_, err := FetchImage(ctx, server.URL, 0)
-	if !errors.Is(err, context.Canceled) && !strings.Contains(err.Error(), "context canceled") {
-		// Error might be wrapped, so check string too
-		t.Errorf("Expected context.Canceled error, got %v", err)
-	}
-}
-
-
 // TestProcessImagesConcurrently_OrderAndCancellation
 // This test is more complex as it involves concurrency and timing.
 func TestProcessImagesConcurrently_OrderAndCancellation(t *testing.T) {
@@ -269,11 +195,17 @@ func TestProcessImagesConcurrently_OrderAndCancellation(t *testing.T) {
 	// Create some dummy sources.
 	// processSingleImage will likely error out on these as they are not real images.
 	// The focus here is on the orchestration by processImagesConcurrently.
-	sources := []ImageSource{
-		newStringImageSource("img0.txt", "data0", "text/plain", 0),
-		newStringImageSource("img1.txt", "data1", "text/plain", 1),
-		newStringImageSource("img2.txt", "data2", "text/plain", 2),
-		newStringImageSource("img3.txt", "data3", "text/plain", 3),
+	// Their readers block until released so that, with only two workers,
+	// the remaining sources are still queued when the context is cancelled.
+	release := make(chan struct{})
+	sources := make([]ImageSource, 4)
+	for i := range sources {
+		sources[i] = ImageSource{
+			OriginalFilename: fmt.Sprintf("img%d.txt", i),
+			Reader:           io.NopCloser(blockingReader{release: release}),
+			ContentType:      "text/plain",
+			Index:            i,
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -281,15 +213,16 @@ func TestProcessImagesConcurrently_OrderAndCancellation(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1) // For the main processing goroutine
 
-	var results []ProcessedImage
+	var results []pageResult
 	go func() {
 		defer wg.Done()
-		results = processImagesConcurrently(ctx, cfg, sources)
+		results = processImagesConcurrently(ctx, cfg, sources, nil)
 	}()
 
 	// Allow some processing to start, then cancel
 	time.Sleep(50 * time.Millisecond) // Small delay
 	cancel()
+	close(release)
 	wg.Wait() // Wait for processImagesConcurrently to finish
 
 	if len(results) != len(sources) {
@@ -298,23 +231,23 @@ func TestProcessImagesConcurrently_OrderAndCancellation(t *testing.T) {
 
 	cancelledCount := 0
 	for i, res := range results {
-		if res.Index != sources[i].Index {
+		if res.Source.Index != sources[i].Index {
 			// This check assumes results are implicitly ordered by source input order before explicit sort.
-			// processImagesConcurrently populates a slice of the same length, so res.Index should map to original.
-			// The final sort in generatePDFFromProcessedImages is what matters for PDF.
+			// processImagesConcurrently populates a slice of the same length, so res.Source.Index should map to original.
+			// The final sort in generatePDFFromPages is what matters for PDF.
 			// Let's check if all original indices are present.
 		}
-		if res.Error != nil {
-			if errors.Is(res.Error, context.Canceled) {
+		if res.err != nil {
+			if errors.Is(res.err, context.Canceled) {
 				cancelledCount++
 			} else {
 				// If it's not a cancellation error, it's likely a processing error due to dummy data
-				t.Logf("Result for index %d has non-cancellation error: %v (expected due to dummy data or cancellation)", res.Index, res.Error)
+				t.Logf("Result for index %d has non-cancellation error: %v (expected due to dummy data or cancellation)", res.Source.Index, res.err)
 			}
 		} else {
 			// This shouldn't happen with dummy text data if processSingleImage is strict.
 			// Or if cancellation was too fast.
-			t.Logf("Result for index %d has no error, but context was cancelled.", res.Index)
+			t.Logf("Result for index %d has no error, but context was cancelled.", res.Source.Index)
 		}
 	}
 
@@ -327,7 +260,6 @@ func TestProcessImagesConcurrently_OrderAndCancellation(t *testing.T) {
 	// Precise number of cancelled vs processed-with-error can vary based on timing.
 }
 
-
 // To properly test ConvertToPDF with actual PDF generation, you'd need:
 // 1. Valid small image files (jpg, png, webp).
 // 2. A way to inspect the generated PDF (e.g., check page count, or if it's a valid PDF).
@@ -347,7 +279,6 @@ func TestConvertToPDF_WithValidDummyImages(t *testing.T) {
 	_ = os.WriteFile(filepath.Join(td, "test.png"), []byte("dummy png"), 0644)
 
 	// Override testdata path for newFileImageSource for this test
-	originalTestDataPath := "testdata"
 	defer func() {
 		// This is a bit hacky; ideally, newFileImageSource would take the base path.
 		// For now, we know it prepends "testdata". This won't work as intended
@@ -364,7 +295,7 @@ func TestConvertToPDF_WithValidDummyImages(t *testing.T) {
 
 	sources := []ImageSource{
 		newFileImageSource(t, "test.jpg", "image/jpeg", 0), // Will use dummy_test_file.txt if test.jpg not found
-		newFileImageSource(t, "test.png", "image/png", 1), // Will use dummy_test_file.txt if test.png not found
+		newFileImageSource(t, "test.png", "image/png", 1),  // Will use dummy_test_file.txt if test.png not found
 	}
 
 	hasContent, err := ConvertToPDF(ctx, sources, cfg, &writer)
@@ -386,7 +317,6 @@ func TestConvertToPDF_WithValidDummyImages(t *testing.T) {
 	}
 }
 
-
 func TestGetContentTypeFromFilename(t *testing.T) {
 	tests := []struct {
 		filename string
@@ -408,3 +338,83 @@ func TestGetContentTypeFromFilename(t *testing.T) {
 		}
 	}
 }
+
+func TestConvertToPDF_Bookmarks(t *testing.T) {
+	sources := []ImageSource{pngSource(t, 10, 10, 0), pngSource(t, 10, 10, 1), pngSource(t, 10, 10, 2)}
+	sources[0].Chapter = "Chapter 1"
+	sources[1].Chapter = "Chapter 1"
+	sources[2].Chapter = "Chapitre 2 – fin"
+	cfg := NewDefaultConfig()
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("/Dest [")); n != 2 {
+		t.Errorf("Expected one bookmark per chapter, got %d", n)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(bookmarkText("Chapitre 2 – fin"))) {
+		t.Error("Expected the chapter name as a UTF-16 bookmark title")
+	}
+
+	for i := range sources {
+		sources[i] = pngSource(t, 10, 10, i)
+	}
+	sources[0].Chapter = "Chapter 1"
+	cfg.PageBookmarks = true
+	out.Reset()
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("/Dest [")); n != 4 {
+		t.Errorf("Expected a chapter bookmark and one per page, got %d", n)
+	}
+}
+
+func TestConvertToPDF_Metadata(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.OutputFilename = "Vol 01.pdf"
+	cfg.Author = "Oda"
+	cfg.Keywords = "manga, shonen"
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 10, 10, 0)}, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	for key, value := range map[string]string{"/Title": "Vol 01", "/Author": "Oda", "/Keywords": "manga, shonen"} {
+		if !bytes.Contains(out.Bytes(), []byte(key+" ("+bookmarkText(value)+")")) {
+			t.Errorf("Expected %s %q in the info dictionary", key, value)
+		}
+	}
+	if bytes.Contains(out.Bytes(), []byte("/Subject")) {
+		t.Error("Expected no subject when none is set")
+	}
+}
+
+func TestConvertToPDF_Progress(t *testing.T) {
+	cfg := NewDefaultConfig()
+	var mu sync.Mutex
+	stages := map[int][]ProgressStage{}
+	bytesRead := map[ProgressStage]int64{}
+	cfg.Progress = func(event ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		stages[event.Index] = append(stages[event.Index], event.Stage)
+		bytesRead[event.Stage] += event.Bytes
+	}
+	sources := []ImageSource{
+		pngSource(t, 10, 10, 0),
+		newStringImageSource("broken.png", "not an image", "image/png", 1),
+	}
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if got := fmt.Sprint(stages[0]); got != "[started decoded transformed added]" {
+		t.Errorf("Expected the good page to be started, decoded, transformed then added, got %s", got)
+	}
+	if bytesRead[ProgressDecoded] == 0 || bytesRead[ProgressTransformed] == 0 || bytesRead[ProgressPageAdded] == 0 {
+		t.Errorf("Expected byte counts on decoded and added events, got %v", bytesRead)
+	}
+	if got := fmt.Sprint(stages[1]); got != "[started failed]" {
+		t.Errorf("Expected the broken page to fail, got %s", got)
+	}
+}
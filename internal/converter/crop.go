@@ -0,0 +1,139 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrInvalidAutoCrop is returned for an auto crop tolerance out of range.
+var ErrInvalidAutoCrop = errors.New("invalid auto_crop")
+
+// cropNoise is the share of a line's pixels that may stray from the margin
+// colour without ending the margin, so dust and specks in scans do not
+// stop the crop short.
+const cropNoise = 0.005
+
+// minCropKeep is the smallest part of a page's width or height a crop may
+// leave. Pages that would be cut down further are nearly blank, such as a
+// lone sound effect, and are kept whole rather than blown up on screen.
+const minCropKeep = 0.25
+
+// AutoCrop trims the uniform margins around every page, white, black or any
+// other flat colour, so e-readers spend their screen on the content. Each
+// side is trimmed on its own: its outermost line sets the margin colour,
+// and lines are trimmed while their pixels stay within Tolerance levels of
+// it.
+type AutoCrop struct {
+	Tolerance int `json:"tolerance,omitempty"` // Levels, out of 255, a margin pixel may differ from the margin colour, 0 to 128
+}
+
+func (c *AutoCrop) validate() error {
+	if c.Tolerance < 0 || c.Tolerance > 128 {
+		return fmt.Errorf("%w: tolerance %d is not between 0 and 128", ErrInvalidAutoCrop, c.Tolerance)
+	}
+	return nil
+}
+
+// bounds returns the part of gray left once its margins are trimmed, in
+// gray's coordinates. ok is false if there is nothing to trim or the page
+// is kept whole.
+func (c *AutoCrop) bounds(gray *image.Gray) (crop image.Rectangle, ok bool) {
+	b := gray.Bounds()
+	line := make([]uint8, max(b.Dx(), b.Dy()))
+	row := func(y, x0, x1 int) []uint8 {
+		return gray.Pix[gray.PixOffset(x0, y):][:x1-x0]
+	}
+	column := func(x, y0, y1 int) []uint8 {
+		col := line[:y1-y0]
+		for i := range col {
+			col[i] = gray.Pix[gray.PixOffset(x, y0+i)]
+		}
+		return col
+	}
+
+	crop = b
+	if ref, uniform := c.marginColour(row(b.Min.Y, b.Min.X, b.Max.X)); uniform {
+		for crop.Min.Y < b.Max.Y && c.isMargin(row(crop.Min.Y, b.Min.X, b.Max.X), ref) {
+			crop.Min.Y++
+		}
+	}
+	if crop.Min.Y == b.Max.Y {
+		return b, false // Blank page
+	}
+	if ref, uniform := c.marginColour(row(b.Max.Y-1, b.Min.X, b.Max.X)); uniform {
+		for crop.Max.Y > crop.Min.Y && c.isMargin(row(crop.Max.Y-1, b.Min.X, b.Max.X), ref) {
+			crop.Max.Y--
+		}
+	}
+	if ref, uniform := c.marginColour(column(b.Min.X, crop.Min.Y, crop.Max.Y)); uniform {
+		for crop.Min.X < b.Max.X && c.isMargin(column(crop.Min.X, crop.Min.Y, crop.Max.Y), ref) {
+			crop.Min.X++
+		}
+	}
+	if ref, uniform := c.marginColour(column(b.Max.X-1, crop.Min.Y, crop.Max.Y)); uniform {
+		for crop.Max.X > crop.Min.X && c.isMargin(column(crop.Max.X-1, crop.Min.Y, crop.Max.Y), ref) {
+			crop.Max.X--
+		}
+	}
+
+	if crop == b || crop.Empty() ||
+		float64(crop.Dx()) < float64(b.Dx())*minCropKeep || float64(crop.Dy()) < float64(b.Dy())*minCropKeep {
+		return b, false
+	}
+	return crop, true
+}
+
+// marginColour returns the median level of an edge line, and whether the
+// line is uniform enough around it to be a margin.
+func (c *AutoCrop) marginColour(line []uint8) (ref uint8, uniform bool) {
+	var hist [256]int
+	for _, v := range line {
+		hist[v]++
+	}
+	for seen := 0; ; ref++ {
+		if seen += hist[ref]; seen*2 >= len(line) {
+			break
+		}
+	}
+	return ref, c.isMargin(line, ref)
+}
+
+// isMargin reports whether all of line but the allowed noise is within the
+// tolerance of the margin colour ref.
+func (c *AutoCrop) isMargin(line []uint8, ref uint8) bool {
+	allowed := int(float64(len(line)) * cropNoise)
+	for _, v := range line {
+		if diff := int(v) - int(ref); diff > c.Tolerance || -diff > c.Tolerance {
+			if allowed--; allowed < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apply returns img with its margins trimmed, found on its luma. Grayscale
+// images stay grayscale. If there is nothing to trim, img itself and false
+// are returned.
+func (c *AutoCrop) apply(img image.Image) (image.Image, bool) {
+	gray, isGray := img.(*image.Gray)
+	if !isGray {
+		gray = toGray(img)
+	}
+	crop, ok := c.bounds(gray)
+	if !ok {
+		return img, false
+	}
+	if !isGray {
+		// toGray's copy starts at the origin; img may not.
+		return imaging.Crop(img, crop.Add(img.Bounds().Min)), true
+	}
+	cropped := image.NewGray(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	for y := 0; y < crop.Dy(); y++ {
+		copy(cropped.Pix[y*cropped.Stride:][:crop.Dx()], gray.Pix[gray.PixOffset(crop.Min.X, crop.Min.Y+y):])
+	}
+	return cropped, true
+}
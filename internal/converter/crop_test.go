@@ -0,0 +1,100 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// marginedPage returns a w x h page with a margin of the given colour and
+// widths around a dark content block, plus a few stray specks in the top
+// margin like the dust on a scan.
+func marginedPage(w, h, top, right, bottom, left int, margin uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := margin
+			if x >= left && x < w-right && y >= top && y < h-bottom {
+				v = uint8(40 + (x+y)%60) // Content, not flat
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	img.SetGray(w/2, top/2, color.Gray{Y: 255 - margin})
+	return img
+}
+
+func TestAutoCropBounds(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		img  *image.Gray
+		crop image.Rectangle
+		ok   bool
+	}{
+		{"white margins", marginedPage(400, 600, 50, 30, 70, 20, 255), image.Rect(20, 50, 370, 530), true},
+		{"black margins", marginedPage(400, 600, 50, 30, 70, 20, 0), image.Rect(20, 50, 370, 530), true},
+		{"no margins", marginedPage(400, 600, 0, 0, 0, 0, 255), image.Rect(0, 0, 400, 600), false},
+		{"nearly blank", marginedPage(400, 600, 280, 180, 280, 180, 255), image.Rect(0, 0, 400, 600), false},
+		{"blank", image.NewGray(image.Rect(0, 0, 40, 60)), image.Rect(0, 0, 40, 60), false},
+	} {
+		crop, ok := (&AutoCrop{Tolerance: 8}).bounds(tc.img)
+		if crop != tc.crop || ok != tc.ok {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", tc.name, tc.crop, tc.ok, crop, ok)
+		}
+	}
+
+	// Paper that is not quite white is a margin only within the tolerance.
+	img := marginedPage(400, 600, 50, 30, 70, 20, 255)
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 400; x++ {
+			if v := img.GrayAt(x, y).Y; v == 255 && (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 235})
+			}
+		}
+	}
+	if _, ok := (&AutoCrop{Tolerance: 8}).bounds(img); ok {
+		t.Error("Expected grainy paper beyond the tolerance to be kept")
+	}
+	if crop, _ := (&AutoCrop{Tolerance: 24}).bounds(img); crop != image.Rect(20, 50, 370, 530) {
+		t.Errorf("Expected grainy paper within the tolerance to be cropped, got %v", crop)
+	}
+}
+
+func TestProcessImage_AutoCrop(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, marginedPage(400, 600, 50, 30, 70, 20, 255)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewDefaultConfig()
+	cfg.AutoCrop = &AutoCrop{Tolerance: 16}
+	cfg.MaxWidth = 175 // Applied to the cropped page, 350 wide
+
+	src := ImageSource{OriginalFilename: "margins.png", ContentType: "image/png", Reader: io.NopCloser(bytes.NewReader(buf.Bytes()))}
+	page, err := ProcessImage(context.Background(), cfg, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Width != 175 || page.Height != 240 || page.PassedThrough {
+		t.Errorf("Expected the page cropped to 350x480 then halved, got %dx%d (passed through: %v)", page.Width, page.Height, page.PassedThrough)
+	}
+
+	// Blank pages are kept whole, and so stay pass-through.
+	cfg.MaxWidth = 0
+	page, err = ProcessImage(context.Background(), cfg, pngSource(t, 80, 100, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.PassedThrough {
+		t.Error("Expected a blank page to be passed through")
+	}
+
+	cfg.AutoCrop.Tolerance = 200
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidAutoCrop) {
+		t.Errorf("Expected ErrInvalidAutoCrop, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidDecodeLimits is returned by Config.Validate and
+// ParseDecodeLimits for a limit on an unknown format, or a negative one.
+var ErrInvalidDecodeLimits = errors.New("invalid decode_limits")
+
+// DecodeFormats returns the keys of Config.DecodeLimits: the names of the
+// input formats, e.g. "jpeg" and "webp".
+func DecodeFormats() []string {
+	formats := make([]string, len(inputFormats))
+	for i, format := range inputFormats {
+		formats[i] = strings.TrimPrefix(format.ContentType, "image/")
+	}
+	return formats
+}
+
+// decodeFormat returns the key of Config.DecodeLimits for a source of
+// contentType, or "" for a content type the converter only tries to decode.
+func decodeFormat(contentType string) string {
+	switch contentType {
+	case "image/jpeg", "image/jpg":
+		return "jpeg"
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/bmp", "image/x-bmp", "image/x-ms-bmp":
+		return "bmp"
+	case "image/webp":
+		return "webp"
+	}
+	return ""
+}
+
+// ParseDecodeLimits parses decode limits written as "webp=2,jpeg=8".
+func ParseDecodeLimits(value string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		format, n, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w %q (expected format=count, e.g. webp=2)", ErrInvalidDecodeLimits, item)
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidDecodeLimits, item, err)
+		}
+		limits[strings.ToLower(strings.TrimSpace(format))] = count
+	}
+	if err := ValidateDecodeLimits(limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// ValidateDecodeLimits checks limits as Config.Validate checks
+// Config.DecodeLimits.
+func ValidateDecodeLimits(limits map[string]int) error {
+	formats := DecodeFormats()
+	for format, n := range limits {
+		if !slices.Contains(formats, format) {
+			return fmt.Errorf("%w: unknown format %q (expected one of %s)", ErrInvalidDecodeLimits, format, strings.Join(formats, ", "))
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: %s=%d (expected 0 for no limit, or more)", ErrInvalidDecodeLimits, format, n)
+		}
+	}
+	return nil
+}
+
+// formatLimits returns a WorkerLimit for each format cfg.DecodeLimits caps,
+// for the decode workers of one conversion.
+func (cfg *Config) formatLimits() map[string]*WorkerLimit {
+	limits := make(map[string]*WorkerLimit, len(cfg.DecodeLimits))
+	for format, n := range cfg.DecodeLimits {
+		if n > 0 {
+			limits[format] = NewWorkerLimit(n)
+		}
+	}
+	return limits
+}
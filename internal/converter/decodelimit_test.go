@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrentReader counts the readers of one conversion being read at once,
+// from their first Read to their Close.
+type concurrentReader struct {
+	io.ReadCloser
+	active, peak *atomic.Int64
+	once         sync.Once
+}
+
+func (r *concurrentReader) Read(p []byte) (int, error) {
+	r.once.Do(func() {
+		n := r.active.Add(1)
+		for peak := r.peak.Load(); n > peak && !r.peak.CompareAndSwap(peak, n); peak = r.peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond) // Give other decodes the chance to overlap
+	})
+	return r.ReadCloser.Read(p)
+}
+
+func (r *concurrentReader) Close() error {
+	r.active.Add(-1)
+	return r.ReadCloser.Close()
+}
+
+func TestDecodeLimits(t *testing.T) {
+	var active, peak atomic.Int64
+	var sources []ImageSource
+	for i := range 8 {
+		src := pngSource(t, 10+i, 10, i)
+		src.Reader = &concurrentReader{ReadCloser: src.Reader, active: &active, peak: &peak}
+		sources = append(sources, src)
+	}
+	cfg := NewDefaultConfig()
+	cfg.NumWorkers = 4
+	cfg.LargestFirst = false // Its pre-scan reads every header up front
+	cfg.DecodeLimits = map[string]int{"png": 1, "webp": 2}
+	var out bytes.Buffer
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &out, &stats); err != nil || stats.PagesAdded != 8 {
+		t.Fatalf("Conversion with decode limits failed: %v, %d pages", err, stats.PagesAdded)
+	}
+	if peak.Load() != 1 {
+		t.Errorf("Expected one PNG decode at a time, got %d", peak.Load())
+	}
+}
+
+func TestParseDecodeLimits(t *testing.T) {
+	limits, err := ParseDecodeLimits(" WebP=2, jpeg=8,")
+	if err != nil || len(limits) != 2 || limits["webp"] != 2 || limits["jpeg"] != 8 {
+		t.Errorf("Unexpected limits %v, error %v", limits, err)
+	}
+	for _, value := range []string{"webp", "webp=two", "tiff=2", "png=-1"} {
+		if _, err := ParseDecodeLimits(value); !errors.Is(err, ErrInvalidDecodeLimits) {
+			t.Errorf("%q: expected ErrInvalidDecodeLimits, got %v", value, err)
+		}
+	}
+	cfg := NewDefaultConfig()
+	cfg.DecodeLimits = map[string]int{"heic": 1}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidDecodeLimits) {
+		t.Errorf("Expected Validate to reject an unknown format, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package converter
+
+import (
+	"log/slog"
+)
+
+// Reasons a page was dropped, as recorded in PageDrop.Reason.
+const (
+	DropDuplicate = "duplicate" // Config.DedupPages: byte-identical to an earlier page
+	DropBlank     = "blank"     // Config.SkipBlank: blank or nearly so
+)
+
+// PageDrop identifies a page that was left out on purpose.
+type PageDrop struct {
+	Index       int    `json:"index"`
+	Filename    string `json:"filename"`
+	Reason      string `json:"reason"`                 // DropDuplicate or DropBlank
+	DuplicateOf string `json:"duplicate_of,omitempty"` // Filename of the page kept, for duplicates
+}
+
+// pageFilter drops the pages Config.DedupPages and Config.SkipBlank leave
+// out and records them in stats. Duplicates are pages whose source bytes
+// are identical to an earlier page, such as a cover or credits page
+// repeated at the start of every chapter when several chapters are merged
+// into one volume; the first occurrence is kept. Pages must be passed in
+// input order.
+type pageFilter struct {
+	seen      map[string]string // Source hash of every page kept so far, to its filename; nil without DedupPages
+	skipBlank bool
+	stats     *Stats
+}
+
+// newPageFilter returns the filter for cfg, or nil if it drops nothing.
+func newPageFilter(cfg *Config, stats *Stats) *pageFilter {
+	if !cfg.DedupPages && !cfg.SkipBlank {
+		return nil
+	}
+	f := &pageFilter{skipBlank: cfg.SkipBlank, stats: stats}
+	if cfg.DedupPages {
+		f.seen = make(map[string]string)
+	}
+	return f
+}
+
+// drop reports whether res is to be left out, and remembers it otherwise.
+// Pages that failed are never dropped.
+func (f *pageFilter) drop(res pageResult) bool {
+	if res.err != nil {
+		return false
+	}
+	if f.skipBlank && res.blank {
+		slog.Info("Dropping blank page", "filename", res.Source.Filename)
+		f.stats.PagesBlank++
+		f.stats.Dropped = append(f.stats.Dropped, PageDrop{Index: res.Source.Index, Filename: res.Source.Filename, Reason: DropBlank})
+		return true
+	}
+	if f.seen == nil || res.contentHash == nil {
+		return false
+	}
+	if first, ok := f.seen[string(res.contentHash)]; ok {
+		slog.Info("Dropping duplicate page", "filename", res.Source.Filename, "duplicateOf", first)
+		f.stats.PagesDuplicate++
+		f.stats.Dropped = append(f.stats.Dropped, PageDrop{Index: res.Source.Index, Filename: res.Source.Filename, Reason: DropDuplicate, DuplicateOf: first})
+		return true
+	}
+	f.seen[string(res.contentHash)] = res.Source.Filename
+	return false
+}
+
+// dropPages removes the pages filter drops from results, which must be in
+// input order, releasing their buffers, and returns the remaining pages.
+func dropPages(results []pageResult, filter *pageFilter) []pageResult {
+	kept := results[:0]
+	for _, res := range results {
+		if filter.drop(res) {
+			res.release()
+			continue
+		}
+		kept = append(kept, res)
+	}
+	return kept
+}
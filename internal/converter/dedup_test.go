@@ -0,0 +1,124 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestConvertToPDF_DedupPages(t *testing.T) {
+	sources := func() []ImageSource {
+		// The 30x30 page stands in for a cover repeated at the start of each chapter.
+		return []ImageSource{
+			pngSource(t, 30, 30, 0),
+			pngSource(t, 10, 10, 1),
+			pngSource(t, 30, 30, 2),
+			pngSource(t, 20, 20, 3),
+		}
+	}
+
+	cfg := NewDefaultConfig()
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources(), cfg, &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if stats.PagesAdded != 4 || stats.PagesDuplicate != 0 {
+		t.Errorf("Expected all pages without DedupPages, got %+v", stats)
+	}
+
+	cfg.DedupPages = true
+	stats = Stats{}
+	if _, err := ConvertToPDFWithStats(context.Background(), sources(), cfg, &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if stats.PagesAdded != 3 || stats.PagesDuplicate != 1 {
+		t.Errorf("Expected the repeated page to be dropped once, got %+v", stats)
+	}
+}
+
+func TestDropDuplicatePages_KeepsFirstOccurrence(t *testing.T) {
+	page := func(index int, filename, hash string) pageResult {
+		return pageResult{Page: Page{Source: PageSource{Index: index, Filename: filename}}, contentHash: []byte(hash)}
+	}
+	pages := []pageResult{
+		page(0, "ch1/cover.png", "a"),
+		page(1, "ch1/001.png", "b"),
+		page(2, "ch2/cover.png", "a"),
+		{Page: Page{Source: PageSource{Index: 3, Filename: "broken.png"}}, err: ErrNoSupportedImages},
+	}
+	var stats Stats
+	kept := dropPages(pages, newPageFilter(&Config{DedupPages: true}, &stats))
+	if stats.PagesDuplicate != 1 || len(kept) != 3 {
+		t.Fatalf("Expected one page dropped, got %d dropped, %d kept", stats.PagesDuplicate, len(kept))
+	}
+	if kept[0].Source.Filename != "ch1/cover.png" || kept[1].Source.Index != 1 || kept[2].Source.Index != 3 {
+		t.Errorf("Unexpected pages kept: %+v", kept)
+	}
+	want := PageDrop{Index: 2, Filename: "ch2/cover.png", Reason: DropDuplicate, DuplicateOf: "ch1/cover.png"}
+	if len(stats.Dropped) != 1 || stats.Dropped[0] != want {
+		t.Errorf("Expected the dropped page reported as %+v, got %+v", want, stats.Dropped)
+	}
+}
+
+func TestConvertToPDF_SkipBlank(t *testing.T) {
+	page := func(index int, draw func(*image.Gray)) ImageSource {
+		img := image.NewGray(image.Rect(0, 0, 200, 300))
+		for i := range img.Pix {
+			img.Pix[i] = 255
+		}
+		draw(img)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return ImageSource{OriginalFilename: fmt.Sprintf("%03d.png", index), Reader: io.NopCloser(&buf), ContentType: "image/png", Index: index}
+	}
+	text := func(img *image.Gray) { // A line of credits
+		for y := 140; y < 150; y++ {
+			for x := 40; x < 160; x += 3 {
+				img.Pix[y*img.Stride+x] = 0
+			}
+		}
+	}
+	noise := func(img *image.Gray) { // Scanner noise and a speck of dust
+		for i := range img.Pix {
+			img.Pix[i] -= uint8(i % 17)
+		}
+		img.Pix[100*img.Stride+100] = 0
+	}
+	sources := []ImageSource{page(0, text), page(1, noise), page(2, func(*image.Gray) {}), page(3, text)}
+
+	cfg := NewDefaultConfig()
+	cfg.SkipBlank = true
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if stats.PagesAdded != 2 || stats.PagesBlank != 2 {
+		t.Errorf("Expected the two blank pages dropped, got %+v", stats)
+	}
+	if len(stats.Dropped) != 2 || stats.Dropped[0].Filename != "001.png" || stats.Dropped[1].Reason != DropBlank {
+		t.Errorf("Unexpected dropped pages: %+v", stats.Dropped)
+	}
+}
+
+func TestIsBlankPage(t *testing.T) {
+	black := image.NewGray(image.Rect(0, 0, 50, 50))
+	if !isBlankPage(black) {
+		t.Error("Expected a black page to be blank")
+	}
+	if !isBlankPage(image.NewGray(image.Rect(0, 0, 2000, 2000))) {
+		t.Error("Expected a large page to be sampled and found blank")
+	}
+	half := image.NewGray(image.Rect(0, 0, 50, 50))
+	for i := range half.Pix[:len(half.Pix)/2] {
+		half.Pix[i] = 255
+	}
+	if isBlankPage(half) {
+		t.Error("Expected a half-white page not to be blank")
+	}
+}
@@ -0,0 +1,43 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// rightToLeftPreferences makes PDF readers page right to left, the reading
+// order of manga.
+const rightToLeftPreferences = "\n/ViewerPreferences << /Direction /R2L >>"
+
+// writeRightToLeft writes the finished PDF doc to w with the
+// /ViewerPreferences /Direction /R2L entry added to its document catalog.
+// gofpdf has no API for viewer preferences, so the entry is inserted after
+// "/Type /Catalog" and the startxref offset, which follows the catalog, is
+// shifted to match. Objects before the catalog keep their offsets.
+func writeRightToLeft(w io.Writer, doc []byte) error {
+	offset, numStart, numEnd, err := lastStartXref(doc)
+	if err != nil {
+		return fmt.Errorf("could not set right-to-left reading order: %w", err)
+	}
+	catalog := bytes.LastIndex(doc, []byte("/Type /Catalog"))
+	if catalog < 0 || numStart < catalog {
+		return errors.New("could not set right-to-left reading order: PDF catalog not found")
+	}
+
+	insertAt := catalog + len("/Type /Catalog")
+	for _, part := range [][]byte{
+		doc[:insertAt],
+		[]byte(rightToLeftPreferences),
+		doc[insertAt:numStart],
+		[]byte(strconv.Itoa(offset + len(rightToLeftPreferences))),
+		doc[numEnd:],
+	} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
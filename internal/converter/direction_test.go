@@ -0,0 +1,51 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var xrefEntryRE = regexp.MustCompile(`(?m)^(\d{10}) 00000 n `)
+
+func TestConvertToPDF_RightToLeft(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.RightToLeft = true
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 10, 10, 0), pngSource(t, 10, 10, 1)}, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	doc := out.Bytes()
+	if !bytes.Contains(doc, []byte("/Type /Catalog\n/ViewerPreferences << /Direction /R2L >>")) {
+		t.Fatal("Expected R2L viewer preferences in the catalog")
+	}
+
+	// The cross-reference table must still point at the right places.
+	i := bytes.LastIndex(doc, []byte("startxref\n")) + len("startxref\n")
+	j := i + bytes.IndexByte(doc[i:], '\n')
+	startxref, err := strconv.Atoi(string(doc[i:j]))
+	if err != nil || !bytes.HasPrefix(doc[startxref:], []byte("xref")) {
+		t.Fatalf("startxref %q does not point at the xref table", doc[i:j])
+	}
+	for n, m := range xrefEntryRE.FindAllSubmatch(doc[startxref:], -1) {
+		offset, _ := strconv.Atoi(string(m[1]))
+		if want := fmt.Sprintf("%d 0 obj", n+1); !bytes.HasPrefix(doc[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q, want %q", n+1, doc[offset:min(offset+12, len(doc))], want)
+		}
+	}
+}
+
+func TestEPUBPackage_RightToLeft(t *testing.T) {
+	pages := []epubPage{{id: "p0001", image: "images/p0001.png", mediaType: "image/png", width: 10, height: 10}}
+	cfg := NewDefaultConfig()
+	cfg.RightToLeft = true
+	if opf := epubPackageOPF("Vol", "urn:uuid:x", pages, cfg); !bytes.Contains([]byte(opf), []byte(`page-progression-direction="rtl"`)) {
+		t.Errorf("Expected an RTL spine:\n%s", opf)
+	}
+	if opf := epubPackageOPF("Vol", "urn:uuid:x", pages, NewDefaultConfig()); bytes.Contains([]byte(opf), []byte("page-progression-direction")) {
+		t.Error("Expected no page progression direction by default")
+	}
+}
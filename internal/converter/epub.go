@@ -0,0 +1,278 @@
+package converter
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+// Output formats for Config.OutputFormat.
+const (
+	FormatPDF  = "pdf"
+	FormatEPUB = "epub"
+)
+
+// ErrUnsupportedFormat is returned for an unknown Config.OutputFormat.
+var ErrUnsupportedFormat = errors.New("unsupported output format")
+
+// OutputType returns the media type and file extension of cfg.OutputFormat
+// ("" means PDF), or ErrUnsupportedFormat.
+func (cfg *Config) OutputType() (contentType, ext string, err error) {
+	switch cfg.OutputFormat {
+	case "", FormatPDF:
+		return "application/pdf", ".pdf", nil
+	case FormatEPUB:
+		return "application/epub+zip", ".epub", nil
+	}
+	return "", "", fmt.Errorf("%w %q (expected %q or %q)", ErrUnsupportedFormat, cfg.OutputFormat, FormatPDF, FormatEPUB)
+}
+
+// epubPage is a page of the EPUB being written.
+type epubPage struct {
+	id, image, mediaType string
+	width, height        int
+	alt                  string // Caption used as the image's alternate text; "Page N" if empty
+}
+
+// generateEPUBFromPages writes the pages of feed as an EPUB 3
+// fixed-layout book: one XHTML page per image, sized to the image, with the
+// OPF package, EPUB 3 navigation document and NCX that Kobo and Kindle apps
+// expect. Each image is written out as soon as the feed yields it. It
+// releases every page's buffer and returns the pages added.
+func generateEPUBFromPages(ctx context.Context, writer io.Writer, feed *pageFeed, cfg *Config) (pagesAdded int, err error) {
+	slog.Debug("Starting EPUB generation from processed images")
+	defer feed.drain()
+
+	zw := zip.NewWriter(writer)
+	// The mimetype entry must come first and be stored uncompressed.
+	if err := writeZipEntry(zw, "mimetype", zip.Store, "application/epub+zip"); err != nil {
+		return 0, err
+	}
+	if err := writeZipEntry(zw, "META-INF/container.xml", zip.Deflate, epubContainerXML); err != nil {
+		return 0, err
+	}
+
+	var pages []epubPage
+	for res, ok := feed.next(); ok; res, ok = feed.next() {
+		if err := CancellationError(ctx); err != nil {
+			res.release()
+			return len(pages), err
+		}
+		if res.err != nil || len(res.Data) == 0 {
+			res.release()
+			continue
+		}
+		page := epubPage{
+			id:     fmt.Sprintf("p%04d", len(pages)+1),
+			width:  int(math.Round(res.layoutWidth)),
+			height: int(math.Round(res.layoutHeight)),
+			alt:    cfg.captionFor(res.Source.Filename, len(pages)+1),
+		}
+		page.image, page.mediaType = "images/"+page.id+".jpg", "image/jpeg"
+		if res.Format == "png" {
+			page.image, page.mediaType = "images/"+page.id+".png", "image/png"
+		}
+		// Images are already compressed; deflating them again only costs time.
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "OEBPS/" + page.image, Method: zip.Store})
+		if err != nil {
+			return len(pages), err
+		}
+		encodedBytes := int64(len(res.Data))
+		_, err = w.Write(res.Data)
+		res.release()
+		if err != nil {
+			return len(pages), fmt.Errorf("could not add %s to EPUB: %w", res.Source.Filename, err)
+		}
+		if err := writeZipEntry(zw, "OEBPS/"+page.id+".xhtml", zip.Deflate, epubPageXHTML(page, len(pages)+1)); err != nil {
+			return len(pages), err
+		}
+		pages = append(pages, page)
+		cfg.progress(ProgressPageAdded, res.Source.Index, res.Source.Filename, encodedBytes, nil)
+	}
+	if len(pages) == 0 {
+		if err := CancellationError(ctx); err != nil {
+			return 0, err
+		}
+		slog.Info("No content was added to the EPUB (all images skipped or failed).")
+		return 0, nil
+	}
+
+	title := cfg.documentTitle()
+	if title == "" {
+		title = "Converted"
+	}
+	identifier, err := newEPUBIdentifier()
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range []struct{ name, content string }{
+		{"OEBPS/content.opf", epubPackageOPF(title, identifier, pages, cfg)},
+		{"OEBPS/nav.xhtml", epubNavXHTML(title, pages)},
+		{"OEBPS/toc.ncx", epubNCX(title, identifier, pages)},
+	} {
+		if err := writeZipEntry(zw, entry.name, zip.Deflate, entry.content); err != nil {
+			return 0, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("could not write EPUB: %w", err)
+	}
+	return len(pages), nil
+}
+
+func writeZipEntry(zw *zip.Writer, name string, method uint16, content string) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+// newEPUBIdentifier returns a random urn:uuid identifier for the book.
+func newEPUBIdentifier() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40 // Version 4
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+const epubContainerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+func epubPageXHTML(page epubPage, number int) string {
+	alt := page.alt
+	if alt == "" {
+		alt = fmt.Sprintf("Page %d", number)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+  <title>Page %[1]d</title>
+  <meta name="viewport" content="width=%[2]d, height=%[3]d"/>
+  <style>html, body { margin: 0; padding: 0; } img { display: block; width: %[2]dpx; height: %[3]dpx; }</style>
+</head>
+<body>
+  <img src="%[4]s" alt="%[5]s"/>
+</body>
+</html>
+`, number, page.width, page.height, page.image, xmlEscape(alt))
+}
+
+// epubPackageOPF returns the package document. Language, reading direction
+// and the author, subject and keywords metadata come from cfg.
+func epubPackageOPF(title, identifier string, pages []epubPage, cfg *Config) string {
+	language := cfg.Language
+	if language == "" {
+		language = "und"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+%s    <meta property="dcterms:modified">%s</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">auto</meta>
+    <meta property="rendition:spread">none</meta>
+    <meta name="fixed-layout" content="true"/>
+    <meta name="book-type" content="comic"/>
+    <meta name="original-resolution" content="%dx%d"/>
+    <meta name="cover" content="img-%s"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+`, identifier, xmlEscape(title), xmlEscape(language), epubOptionalMetadata(cfg), time.Now().UTC().Format(time.RFC3339), pages[0].width, pages[0].height, pages[0].id)
+	for i, page := range pages {
+		properties := ""
+		if i == 0 {
+			properties = ` properties="cover-image"`
+		}
+		fmt.Fprintf(&b, "    <item id=\"img-%s\" href=\"%s\" media-type=\"%s\"%s/>\n", page.id, page.image, page.mediaType, properties)
+		fmt.Fprintf(&b, "    <item id=\"%s\" href=\"%s.xhtml\" media-type=\"application/xhtml+xml\"/>\n", page.id, page.id)
+	}
+	b.WriteString("  </manifest>\n")
+	if cfg.RightToLeft {
+		b.WriteString("  <spine toc=\"ncx\" page-progression-direction=\"rtl\">\n")
+	} else {
+		b.WriteString("  <spine toc=\"ncx\">\n")
+	}
+	for _, page := range pages {
+		fmt.Fprintf(&b, "    <itemref idref=\"%s\"/>\n", page.id)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+// epubOptionalMetadata returns the Dublin Core elements for the metadata
+// that is set in cfg; each keyword becomes its own dc:subject.
+func epubOptionalMetadata(cfg *Config) string {
+	var b strings.Builder
+	if cfg.Author != "" {
+		fmt.Fprintf(&b, "    <dc:creator>%s</dc:creator>\n", xmlEscape(cfg.Author))
+	}
+	if cfg.Subject != "" {
+		fmt.Fprintf(&b, "    <dc:description>%s</dc:description>\n", xmlEscape(cfg.Subject))
+	}
+	for _, keyword := range strings.Split(cfg.Keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			fmt.Fprintf(&b, "    <dc:subject>%s</dc:subject>\n", xmlEscape(keyword))
+		}
+	}
+	return b.String()
+}
+
+func epubNavXHTML(title string, pages []epubPage) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>%[1]s</title></head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <ol><li><a href="%[2]s.xhtml">%[1]s</a></li></ol>
+  </nav>
+</body>
+</html>
+`, xmlEscape(title), pages[0].id)
+}
+
+func epubNCX(title, identifier string, pages []epubPage) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head><meta name="dtb:uid" content="%[2]s"/></head>
+  <docTitle><text>%[1]s</text></docTitle>
+  <navMap>
+    <navPoint id="start" playOrder="1">
+      <navLabel><text>%[1]s</text></navLabel>
+      <content src="%[3]s.xhtml"/>
+    </navPoint>
+  </navMap>
+</ncx>
+`, xmlEscape(title), identifier, pages[0].id)
+}
@@ -0,0 +1,67 @@
+package converter
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConvertToPDF_EPUB(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.OutputFormat = FormatEPUB
+	cfg.OutputFilename = "Volume <1>.epub"
+	sources := []ImageSource{
+		pngSource(t, 30, 40, 0),
+		newStringImageSource("broken.png", "not an image", "image/png", 1),
+		pngSource(t, 20, 20, 2),
+	}
+	var out bytes.Buffer
+	var stats Stats
+	hasContent, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &out, &stats)
+	if err != nil || !hasContent || stats.PagesAdded != 2 {
+		t.Fatalf("Expected an EPUB with two pages, got %v, %v, %+v", hasContent, err, stats)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("Output is not a ZIP: %v", err)
+	}
+	if first := zr.File[0]; first.Name != "mimetype" || first.Method != zip.Store {
+		t.Errorf("Expected an uncompressed mimetype entry first, got %s (method %d)", first.Name, first.Method)
+	}
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(data)
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/nav.xhtml", "OEBPS/toc.ncx", "OEBPS/images/p0001.png", "OEBPS/images/p0002.png"} {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("Missing %s", name)
+		}
+	}
+	opf := entries["OEBPS/content.opf"]
+	if !strings.Contains(opf, "pre-paginated") || strings.Count(opf, "<itemref ") != 2 || !strings.Contains(opf, "<dc:title>Volume &lt;1&gt;</dc:title>") {
+		t.Errorf("Unexpected package document:\n%s", opf)
+	}
+	if !strings.Contains(entries["OEBPS/p0001.xhtml"], `content="width=30, height=40"`) {
+		t.Errorf("Expected the first page sized to its image:\n%s", entries["OEBPS/p0001.xhtml"])
+	}
+}
+
+func TestConvertToPDF_UnsupportedFormat(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.OutputFormat = "mobi"
+	_, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 10, 10, 0)}, cfg, &bytes.Buffer{})
+	if !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// jpegOrientation returns the EXIF orientation of the JPEG data: 1 when it
+// is to be shown as stored, which is also what JPEGs without EXIF data or
+// with an orientation out of range get; 2 to 8 when it must be flipped or
+// rotated first, as phone cameras and some scanners store pages.
+func jpegOrientation(data []byte) int {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return 1
+	}
+	for rest := data[2:]; len(rest) >= 4 && rest[0] == 0xff; {
+		marker := rest[1]
+		if marker == 0xda || marker == 0xd9 { // Start of scan, end of image
+			break
+		}
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		if length < 2 || 2+length > len(rest) {
+			break
+		}
+		segment := rest[4 : 2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		rest = rest[2+length:]
+	}
+	return 1
+}
+
+// exifOrientation returns the orientation tag of the first IFD of the TIFF
+// structure tiff, or 1 if it has none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			break
+		}
+		// Tag 0x0112 of type SHORT holds the orientation in its value field.
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient turns img, stored with the EXIF orientation, the way it is to be
+// shown. imaging rotates counter-clockwise.
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
@@ -0,0 +1,397 @@
+//go:build !js
+
+package converter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FetchPolicy limits how hard the fetcher hits any single host, so a
+// conversion pulling hundreds of pages from one CDN looks like a polite
+// client rather than a scraper.
+type FetchPolicy struct {
+	MaxConnsPerHost int           // Concurrent downloads per host (0 = unlimited)
+	HostDelay       time.Duration // Minimum time between the starts of two requests to the same host
+	// Proxy, if set, is a socks5:// or socks5h:// proxy, such as Tor, that
+	// every download goes through; host names are resolved by the proxy.
+	// Each Fetcher.ForJob then gets its own connections and, unless Proxy
+	// has credentials of its own, its own proxy credentials, which Tor
+	// (with IsolateSOCKSAuth, its default) answers with a separate circuit.
+	Proxy *url.URL
+}
+
+func (p FetchPolicy) limited() bool {
+	return p.MaxConnsPerHost > 0 || p.HostDelay > 0
+}
+
+// FetchStatusError is returned by Fetch for a response other than 200 OK.
+type FetchStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *FetchStatusError) Error() string {
+	return fmt.Sprintf("failed to fetch %s: status %s", e.URL, e.Status)
+}
+
+// Errors of downloads whose body is not what the response promised,
+// wrapped in a FetchBodyError. Both are transient: an empty 200 or a body
+// that stops early is usually a CDN or proxy hiccup.
+var (
+	ErrEmptyDownload     = errors.New("empty download")
+	ErrTruncatedDownload = errors.New("truncated download")
+)
+
+// FetchBodyError is returned by Fetch, or by reading the Reader of the
+// ImageSource it returned, for a body that is empty or shorter than its
+// Content-Length.
+type FetchBodyError struct {
+	URL      string
+	Received int64 // Bytes read before the body ended
+	Expected int64 // Content-Length, or -1 if the response did not give one
+	Err      error // ErrEmptyDownload or ErrTruncatedDownload
+}
+
+func (e *FetchBodyError) Error() string {
+	if e.Expected >= 0 {
+		return fmt.Sprintf("failed to read %s: %v: %d of %d bytes", e.URL, e.Err, e.Received, e.Expected)
+	}
+	return fmt.Sprintf("failed to read %s: %v after %d bytes", e.URL, e.Err, e.Received)
+}
+
+func (e *FetchBodyError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransientFetchError reports whether a download that failed with err
+// may well succeed if tried again later: a network error, an empty or
+// truncated body, or a timeout, rate limit or server error status. Missing
+// images, unsupported content and cancellation are not transient.
+func IsTransientFetchError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrEmptyDownload) || errors.Is(err, ErrTruncatedDownload) {
+		return true
+	}
+	var statusErr *FetchStatusError
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.StatusCode; {
+		case code == http.StatusRequestTimeout, code == http.StatusTooEarly, code == http.StatusTooManyRequests:
+			return true
+		default:
+			return code >= 500
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// ErrInvalidFetchProxy is returned by ParseFetchProxy for a proxy that is
+// not a SOCKS5 URL.
+var ErrInvalidFetchProxy = errors.New("invalid fetch proxy")
+
+// ParseFetchProxy parses a FetchPolicy.Proxy URL, socks5://host:port or
+// socks5h://host:port, optionally with user:password.
+func ParseFetchProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFetchProxy, err)
+	}
+	if (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Host == "" || u.Port() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("%w %q (expected socks5://host:port or socks5h://host:port)", ErrInvalidFetchProxy, raw)
+	}
+	return u, nil
+}
+
+// Fetcher downloads images under a FetchPolicy. The policy applies across
+// all callers, so one Fetcher should be shared by every conversion.
+type Fetcher struct {
+	client *http.Client
+	policy FetchPolicy
+	limits *hostLimits // Shared with the Fetchers of ForJob
+}
+
+// hostLimits is the politeness state of every host a Fetcher has seen.
+type hostLimits struct {
+	mu    sync.Mutex
+	hosts map[string]*hostLimiter
+}
+
+// hostLimiter tracks the politeness state of one host.
+type hostLimiter struct {
+	slots     chan struct{} // nil when connections are unlimited
+	nextStart time.Time
+}
+
+// NewFetcher returns a Fetcher enforcing policy.
+func NewFetcher(policy FetchPolicy) *Fetcher {
+	f := &Fetcher{
+		client: &http.Client{}, // Consider customizing timeout
+		policy: policy,
+		limits: &hostLimits{hosts: map[string]*hostLimiter{}},
+	}
+	if policy.Proxy != nil {
+		f.client = proxyClient(policy.Proxy)
+	}
+	return f
+}
+
+// ForJob returns the Fetcher for one job's downloads and a function to
+// call once they are done. Without a FetchPolicy.Proxy that is f itself.
+// With one it is a Fetcher under the same per-host limits that shares no
+// connections with other jobs and, unless the proxy URL has credentials,
+// authenticates to the proxy with credentials of its own, so a Tor proxy
+// keeps the job on its own circuit. Fetchers never keep cookies.
+func (f *Fetcher) ForJob() (*Fetcher, func()) {
+	if f.policy.Proxy == nil {
+		return f, func() {}
+	}
+	proxy := *f.policy.Proxy
+	if proxy.User == nil {
+		id := make([]byte, 8)
+		rand.Read(id)
+		proxy.User = url.UserPassword("job-"+hex.EncodeToString(id), "isolate")
+	}
+	client := proxyClient(&proxy)
+	return &Fetcher{client: client, policy: f.policy, limits: f.limits}, client.CloseIdleConnections
+}
+
+// proxyClient returns a client whose connections all go through the SOCKS5
+// proxy. net/http passes host names to SOCKS5 proxies unresolved, so no
+// DNS lookups leak around the proxy.
+func proxyClient(proxy *url.URL) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyURL(proxy),
+		TLSHandshakeTimeout: 30 * time.Second, // Circuits are slow to build
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}}
+}
+
+// defaultFetcher serves FetchImage and applies no limits.
+var defaultFetcher = NewFetcher(FetchPolicy{})
+
+// FetchImage downloads an image from a URL without any per-host limits.
+// It returns an ImageSource with the Reader populated, or an error.
+// The caller is responsible for closing the ImageSource.Reader.
+func FetchImage(ctx context.Context, imageURL string, index int) (ImageSource, error) {
+	return defaultFetcher.Fetch(ctx, imageURL, index)
+}
+
+// acquire waits until the policy allows another request to host. The
+// returned function releases the connection slot.
+func (f *Fetcher) acquire(ctx context.Context, host string) (release func(), err error) {
+	f.limits.mu.Lock()
+	limiter, ok := f.limits.hosts[host]
+	if !ok {
+		limiter = &hostLimiter{}
+		if f.policy.MaxConnsPerHost > 0 {
+			limiter.slots = make(chan struct{}, f.policy.MaxConnsPerHost)
+		}
+		f.limits.hosts[host] = limiter
+	}
+	f.limits.mu.Unlock()
+
+	release = func() {}
+	if limiter.slots != nil {
+		select {
+		case limiter.slots <- struct{}{}:
+			release = func() { <-limiter.slots }
+		case <-ctx.Done():
+			return nil, CancellationError(ctx)
+		}
+	}
+
+	if f.policy.HostDelay > 0 {
+		// Reserve the next start time under the lock, then wait outside it.
+		f.limits.mu.Lock()
+		now := time.Now()
+		start := limiter.nextStart
+		if start.Before(now) {
+			start = now
+		}
+		limiter.nextStart = start.Add(f.policy.HostDelay)
+		f.limits.mu.Unlock()
+
+		if wait := time.Until(start); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				release()
+				return nil, CancellationError(ctx)
+			}
+		}
+	}
+	return release, nil
+}
+
+// Fetch downloads an image from a URL, waiting as the policy requires.
+// When a policy is set the body is read completely before the connection
+// slot is released, so the returned Reader is in memory.
+// The caller is responsible for closing the ImageSource.Reader.
+func (f *Fetcher) Fetch(ctx context.Context, imageURL string, index int) (ImageSource, error) {
+	slog.Debug("Fetching image from URL", "url", imageURL, "index", index)
+
+	resp, release, err := f.get(ctx, imageURL)
+	if err != nil {
+		return ImageSource{}, err
+	}
+	defer release()
+	// Caller must close resp.Body via ImageSource.Reader.Close()
+
+	contentType := resp.Header.Get("Content-Type")
+	// Basic validation of content type
+	if !strings.HasPrefix(strings.ToLower(contentType), "image/") {
+		resp.Body.Close()
+		slog.Warn("Unsupported content type from URL", "url", imageURL, "contentType", contentType)
+		return ImageSource{}, fmt.Errorf("%w: %s from %s", ErrUnsupportedContentType, contentType, imageURL)
+	}
+	if resp.ContentLength == 0 {
+		resp.Body.Close()
+		slog.Warn("Empty response from URL", "url", imageURL)
+		return ImageSource{}, &FetchBodyError{URL: imageURL, Expected: 0, Err: ErrEmptyDownload}
+	}
+
+	// Try to get a filename from URL
+	filename := filepath.Base(imageURL)
+	parsedURL, parseErr := url.ParseRequestURI(imageURL)
+	if parseErr == nil {
+		filename = filepath.Base(parsedURL.Path)
+	}
+
+	var reader io.ReadCloser = &checkedBody{ReadCloser: resp.Body, url: imageURL, expected: resp.ContentLength}
+	if f.policy.limited() {
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		var bodyErr *FetchBodyError
+		if errors.As(err, &bodyErr) {
+			return ImageSource{}, err
+		}
+		if err != nil {
+			return ImageSource{}, fmt.Errorf("failed to read %s: %w", imageURL, err)
+		}
+		reader = io.NopCloser(bytes.NewReader(data))
+	}
+
+	return ImageSource{
+		OriginalFilename: filename,
+		Reader:           reader,
+		URL:              imageURL,
+		ContentType:      contentType,
+		Index:            index,
+		FetchedAt:        time.Now(),
+	}, nil
+}
+
+// Download writes the body at rawURL to w, whatever its content type,
+// waiting as the policy requires, and returns its size. It is for files
+// other than images, such as a CBZ archive; its errors are those of Fetch.
+func (f *Fetcher) Download(ctx context.Context, rawURL string, w io.Writer) (int64, error) {
+	slog.Debug("Downloading URL", "url", rawURL)
+	resp, release, err := f.get(ctx, rawURL)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	body := &checkedBody{ReadCloser: resp.Body, url: rawURL, expected: resp.ContentLength}
+	defer body.Close()
+	n, err := io.Copy(w, body)
+	var bodyErr *FetchBodyError
+	if err != nil && !errors.As(err, &bodyErr) {
+		err = fmt.Errorf("failed to read %s: %w", rawURL, err)
+	}
+	return n, err
+}
+
+// get sends a GET request for rawURL once the policy allows it and returns
+// the response if it is 200 OK. Its body must be closed, and release called
+// once the body has been read.
+func (f *Fetcher) get(ctx context.Context, rawURL string) (resp *http.Response, release func(), err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		slog.Error("Failed to create request for URL", "url", rawURL, "error", err)
+		return nil, nil, fmt.Errorf("failed to create request for %s: %w", rawURL, err)
+	}
+
+	release = func() {}
+	if f.policy.limited() {
+		release, err = f.acquire(ctx, req.URL.Host)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+		}
+	}
+
+	resp, err = f.client.Do(req)
+	if err != nil {
+		release()
+		slog.Error("Failed to fetch URL", "url", rawURL, "error", err)
+		return nil, nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		release()
+		slog.Warn("Failed to fetch URL, non-OK status", "url", rawURL, "status", resp.StatusCode)
+		return nil, nil, &FetchStatusError{URL: rawURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp, release, nil
+}
+
+// checkedBody is the body of a download. It turns an empty body, and one
+// that ends before its Content-Length, into a FetchBodyError.
+type checkedBody struct {
+	io.ReadCloser
+	url      string
+	expected int64 // Content-Length, or -1 if unknown
+	received int64
+}
+
+func (b *checkedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.received += int64(n)
+	switch {
+	case err == io.EOF && b.received == 0:
+		return n, &FetchBodyError{URL: b.url, Expected: b.expected, Err: ErrEmptyDownload}
+	case err == io.EOF && b.received < b.expected, errors.Is(err, io.ErrUnexpectedEOF):
+		return n, &FetchBodyError{URL: b.url, Received: b.received, Expected: b.expected, Err: ErrTruncatedDownload}
+	}
+	return n, err
+}
+
+// FetchFailureReason classifies why a download failed, for reports:
+// "empty_body", "truncated_body", "http_status", "unsupported_type",
+// "network" or "" for anything else.
+func FetchFailureReason(err error) string {
+	var statusErr *FetchStatusError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrEmptyDownload):
+		return "empty_body"
+	case errors.Is(err, ErrTruncatedDownload):
+		return "truncated_body"
+	case errors.As(err, &statusErr):
+		return "http_status"
+	case errors.Is(err, ErrUnsupportedContentType):
+		return "unsupported_type"
+	case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return "network"
+	}
+	return ""
+}
@@ -0,0 +1,385 @@
+//go:build !js
+
+package converter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// This test requires a running HTTP server for FetchImage.
+// We'll use httptest.NewServer.
+func TestFetchImage_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		fmt.Fprint(w, "fake_jpeg_data")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	imgSrc, err := FetchImage(ctx, server.URL, 0)
+	if err != nil {
+		t.Fatalf("FetchImage failed: %v", err)
+	}
+	defer imgSrc.Reader.Close()
+
+	if imgSrc.ContentType != "image/jpeg" {
+		t.Errorf("Expected content type image/jpeg, got %s", imgSrc.ContentType)
+	}
+	if imgSrc.OriginalFilename == "" {
+		t.Error("Expected a filename to be derived from URL")
+	}
+	data, _ := io.ReadAll(imgSrc.Reader)
+	if string(data) != "fake_jpeg_data" {
+		t.Errorf("Expected 'fake_jpeg_data', got '%s'", string(data))
+	}
+}
+
+func TestFetchImage_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	_, err := FetchImage(ctx, server.URL, 0)
+	if err == nil {
+		t.Fatal("Expected error for 404 Not Found, got nil")
+	}
+	t.Logf("Received expected error for 404: %v", err)
+}
+
+func TestFetchImage_UnsupportedContentType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	imgSrc, err := FetchImage(ctx, server.URL, 0)
+	if err == nil {
+		imgSrc.Reader.Close() // Close reader if FetchImage unexpectedly succeeded
+		t.Fatal("Expected error for unsupported content type, got nil")
+	}
+	if !errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("Expected ErrUnsupportedContentType, got %v", err)
+	}
+	t.Logf("Received expected error for unsupported content type: %v", err)
+}
+
+func TestFetchImage_ContextCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // Ensure request starts
+		fmt.Fprint(w, "slow_response")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // Cancel before request can complete
+
+	_, err := FetchImage(ctx, server.URL, 0)
+	if !errors.Is(err, context.Canceled) && !strings.Contains(err.Error(), "context canceled") {
+		// Error might be wrapped, so check string too
+		t.Errorf("Expected context.Canceled error, got %v", err)
+	}
+}
+
+func TestFetcher_HostPoliteness(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var starts []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		starts = append(starts, time.Now())
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "fake_png_data")
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	delay := 10 * time.Millisecond
+	fetcher := NewFetcher(FetchPolicy{MaxConnsPerHost: 2, HostDelay: delay})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src, err := fetcher.Fetch(context.Background(), fmt.Sprintf("%s/p%d.png", server.URL, i), i)
+			if err != nil {
+				t.Errorf("Fetch failed: %v", err)
+				return
+			}
+			// Bodies are buffered, so holding them open must not block other fetches.
+			data, _ := io.ReadAll(src.Reader)
+			if string(data) != "fake_png_data" {
+				t.Errorf("Unexpected body %q", data)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxInFlight > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, saw %d", maxInFlight)
+	}
+	// Individual gaps jitter with scheduling, but the starts as a whole must
+	// be spread over at least (n-1) delays.
+	if span := starts[len(starts)-1].Sub(starts[0]); span < time.Duration(len(starts)-1)*delay-delay/2 {
+		t.Errorf("Expected %d requests to be spread by %v each, all started within %v", len(starts), delay, span)
+	}
+}
+
+func TestFetcher_CancelWhileWaiting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "fake_png_data")
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher(FetchPolicy{HostDelay: time.Hour})
+	if _, err := fetcher.Fetch(context.Background(), server.URL, 0); err != nil {
+		t.Fatalf("First fetch should not wait: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := fetcher.Fetch(ctx, server.URL, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the delayed fetch to give up with the context, got %v", err)
+	}
+}
+
+// socks5Server is a minimal SOCKS5 proxy that requires username/password
+// authentication and records the usernames it is given.
+type socks5Server struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	users []string
+}
+
+func newSOCKS5Server(t *testing.T) *socks5Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Server{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	read := func(n int) []byte {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil
+		}
+		return buf
+	}
+	// Greeting: version, methods. Only username/password (2) is accepted.
+	hdr := read(2)
+	if hdr == nil || read(int(hdr[1])) == nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	// Username/password sub-negotiation (RFC 1929).
+	ver := read(2)
+	if ver == nil {
+		return
+	}
+	user := read(int(ver[1]))
+	plen := read(1)
+	if user == nil || plen == nil || read(int(plen[0])) == nil {
+		return
+	}
+	s.mu.Lock()
+	s.users = append(s.users, string(user))
+	s.mu.Unlock()
+	conn.Write([]byte{1, 0})
+	// CONNECT request with a domain name or IPv4 address.
+	req := read(4)
+	if req == nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(read(4)).String()
+	case 3:
+		n := read(1)
+		host = string(read(int(n[0])))
+	default:
+		return
+	}
+	port := read(2)
+	target, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(int(port[0])<<8|int(port[1]))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+func TestFetcher_SOCKS5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "fake_png_data")
+	}))
+	defer server.Close()
+	socks := newSOCKS5Server(t)
+
+	proxy, err := ParseFetchProxy("socks5h://" + socks.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := NewFetcher(FetchPolicy{Proxy: proxy})
+	for job := range 2 {
+		jobFetcher, done := fetcher.ForJob()
+		for page := range 2 {
+			src, err := jobFetcher.Fetch(context.Background(), fmt.Sprintf("%s/%d-%d.png", server.URL, job, page), page)
+			if err != nil {
+				t.Fatalf("Fetch through the proxy failed: %v", err)
+			}
+			src.Reader.Close()
+		}
+		done()
+	}
+
+	socks.mu.Lock()
+	defer socks.mu.Unlock()
+	distinct := map[string]bool{}
+	for _, user := range socks.users {
+		distinct[user] = true
+	}
+	if len(distinct) != 2 || !strings.HasPrefix(socks.users[0], "job-") {
+		t.Errorf("Expected one set of proxy credentials per job, got %v", socks.users)
+	}
+
+	for _, bad := range []string{"http://127.0.0.1:8080", "socks5://127.0.0.1", "socks5h:///", "127.0.0.1:9050"} {
+		if _, err := ParseFetchProxy(bad); !errors.Is(err, ErrInvalidFetchProxy) {
+			t.Errorf("Expected ErrInvalidFetchProxy for %q, got %v", bad, err)
+		}
+	}
+}
+
+func TestFetchImage_EmptyAndTruncated(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		switch r.URL.Path {
+		case "/empty.png":
+			w.Header().Set("Content-Length", "0")
+		case "/chunked.png":
+			w.(http.Flusher).Flush() // No Content-Length, and no body either
+		case "/truncated.png":
+			w.Header().Set("Content-Length", "100")
+			w.WriteHeader(http.StatusOK)
+			w.Write(make([]byte, 10))
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		}
+	}))
+	defer server.Close()
+
+	_, err := FetchImage(context.Background(), server.URL+"/empty.png", 0)
+	if !errors.Is(err, ErrEmptyDownload) {
+		t.Errorf("Expected ErrEmptyDownload for a zero Content-Length, got %v", err)
+	}
+
+	for path, want := range map[string]error{"/chunked.png": ErrEmptyDownload, "/truncated.png": ErrTruncatedDownload} {
+		src, err := FetchImage(context.Background(), server.URL+path, 0)
+		if err != nil {
+			t.Fatalf("FetchImage %s failed before reading: %v", path, err)
+		}
+		_, err = io.ReadAll(src.Reader)
+		src.Reader.Close()
+		if !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", path, want, err)
+		}
+		var bodyErr *FetchBodyError
+		if !errors.As(err, &bodyErr) || !strings.Contains(bodyErr.URL, path) {
+			t.Errorf("%s: expected a FetchBodyError naming the URL, got %v", path, err)
+		}
+	}
+
+	// With a fetch policy the body is read in Fetch itself.
+	fetcher := NewFetcher(FetchPolicy{MaxConnsPerHost: 1})
+	_, err = fetcher.Fetch(context.Background(), server.URL+"/truncated.png", 0)
+	var bodyErr *FetchBodyError
+	if !errors.As(err, &bodyErr) || bodyErr.Received != 10 || bodyErr.Expected != 100 {
+		t.Errorf("Expected a truncated download of 10 of 100 bytes, got %v", err)
+	}
+}
+
+func TestIsTransientFetchError(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{&FetchStatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&FetchStatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{fmt.Errorf("wrapped: %w", &FetchStatusError{StatusCode: http.StatusBadGateway}), true},
+		{&FetchStatusError{StatusCode: http.StatusNotFound}, false},
+		{fmt.Errorf("failed to read: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{fmt.Errorf("%w: text/html", ErrUnsupportedContentType), false},
+		{&FetchBodyError{Err: ErrEmptyDownload}, true},
+		{&FetchBodyError{Received: 10, Expected: 100, Err: ErrTruncatedDownload}, true},
+		{fmt.Errorf("failed to fetch: %w", context.Canceled), false},
+	} {
+		if got := IsTransientFetchError(tc.err); got != tc.transient {
+			t.Errorf("%v: expected transient %v, got %v", tc.err, tc.transient, got)
+		}
+	}
+}
+
+func TestFetchFailureReason(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		reason string
+	}{
+		{&FetchBodyError{Err: ErrEmptyDownload}, "empty_body"},
+		{fmt.Errorf("wrapped: %w", &FetchBodyError{Err: ErrTruncatedDownload}), "truncated_body"},
+		{&FetchStatusError{StatusCode: http.StatusNotFound}, "http_status"},
+		{fmt.Errorf("%w: text/html", ErrUnsupportedContentType), "unsupported_type"},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "network"},
+		{errors.New("something else"), ""},
+	} {
+		if got := FetchFailureReason(tc.err); got != tc.reason {
+			t.Errorf("%v: expected reason %q, got %q", tc.err, tc.reason, got)
+		}
+	}
+}
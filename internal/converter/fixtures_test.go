@@ -0,0 +1,214 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// The images in testdata are real-world files that trip up converters; see
+// testdata/README.md for where each comes from.
+
+// convertFixture converts the testdata file name end to end with cfg, read
+// with contentType, and returns the inspection of its page and the PDF.
+func convertFixture(t *testing.T, cfg *Config, name, contentType string) (PageInspection, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var pages []PageInspection
+	cfg.Inspect = func(page PageInspection) {
+		mu.Lock()
+		defer mu.Unlock()
+		page.Data = bytes.Clone(page.Data)
+		pages = append(pages, page)
+	}
+	src := ImageSource{OriginalFilename: name, Reader: io.NopCloser(bytes.NewReader(data)), ContentType: contentType}
+	var pdf bytes.Buffer
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), []ImageSource{src}, cfg, &pdf, &stats); err != nil || stats.PagesAdded != 1 {
+		t.Fatalf("%s (%s): conversion failed: %v, %d pages added", name, contentType, err, stats.PagesAdded)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("%s (%s): expected 1 inspected page, got %d", name, contentType, len(pages))
+	}
+	return pages[0], pdf.Bytes()
+}
+
+// decodePage decodes the data of page as embedded.
+func decodePage(t *testing.T, page PageInspection) image.Image {
+	t.Helper()
+	img, _, err := image.Decode(bytes.NewReader(page.Data))
+	if err != nil {
+		t.Fatalf("%s: page data does not decode: %v", page.Source.Filename, err)
+	}
+	return img
+}
+
+// near reports whether colours a and b differ by at most tolerance in every
+// channel, out of 255.
+func near(a, b color.Color, tolerance int) bool {
+	ar, ag, ab, _ := a.RGBA()
+	br, bg, bb, _ := b.RGBA()
+	for _, d := range []int{int(ar>>8) - int(br>>8), int(ag>>8) - int(bg>>8), int(ab>>8) - int(bb>>8)} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// meanColour returns the mean colour of img.
+func meanColour(img image.Image) color.RGBA {
+	var r, g, b, n uint64
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			r, g, b, n = r+uint64(cr>>8), g+uint64(cg>>8), b+uint64(cb>>8), n+1
+		}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}
+}
+
+func TestFixtures(t *testing.T) {
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	tests := []struct {
+		name  string
+		check func(t *testing.T, page PageInspection, pdf []byte)
+	}{
+		{"cmyk.jpeg", func(t *testing.T, page PageInspection, pdf []byte) {
+			// Adobe CMYK JPEGs store inverted ink values; embedded as is,
+			// the PDF must say so or the page comes out as a negative.
+			// (TestFixtures_CMYKReencoded covers the page re-encoded.)
+			if page.PassedThrough && (!bytes.Contains(pdf, []byte("/ColorSpace /DeviceCMYK")) || !bytes.Contains(pdf, []byte("/Decode [1 0 1 0 1 0 1 0]"))) {
+				t.Error("Expected the CMYK JPEG passed through with an inverting /Decode")
+			}
+		}},
+		{"interlaced.png", func(t *testing.T, page PageInspection, pdf []byte) {
+			// gofpdf cannot embed interlaced PNGs.
+			if page.PassedThrough || page.Format != "png" {
+				t.Errorf("Expected the interlaced PNG re-encoded as PNG, got %s, passed through: %v", page.Format, page.PassedThrough)
+			}
+			if img := decodePage(t, page); img.Bounds() != page.Before.Bounds() {
+				t.Errorf("Expected %v, got %v", page.Before.Bounds(), img.Bounds())
+			}
+		}},
+		{"rgb16.png", func(t *testing.T, page PageInspection, pdf []byte) {
+			// gofpdf cannot embed 16-bit PNGs either.
+			if page.PassedThrough || page.Format != "png" {
+				t.Errorf("Expected the 16-bit PNG re-encoded as PNG, got %s, passed through: %v", page.Format, page.PassedThrough)
+			}
+			switch decodePage(t, page).ColorModel() {
+			case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+				t.Error("Expected the page re-encoded with 8 bits per channel")
+			}
+		}},
+		{"exif-orientation-6.jpeg", func(t *testing.T, page PageInspection, pdf []byte) {
+			// Stored 16x8, red left and blue right, with the EXIF
+			// orientation to turn it a quarter clockwise for display.
+			img := decodePage(t, page)
+			if page.Width != 8 || page.Height != 16 || img.Bounds().Dx() != 8 || img.Bounds().Dy() != 16 {
+				t.Fatalf("Expected an 8x16 page, got %dx%d", page.Width, page.Height)
+			}
+			if top, bottom := img.At(4, 3), img.At(4, 12); !near(top, red, 60) || !near(bottom, blue, 60) {
+				t.Errorf("Expected red on top and blue at the bottom, got %v and %v", top, bottom)
+			}
+		}},
+		{"animated.webp", func(t *testing.T, page PageInspection, pdf []byte) {
+			// The first frame is the page, like with GIFs.
+			data, err := os.ReadFile(filepath.Join("testdata", "lossless.webp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			img := decodePage(t, page)
+			if img.Bounds() != first.Bounds() {
+				t.Fatalf("Expected the first frame's %v, got %v", first.Bounds(), img.Bounds())
+			}
+			for _, p := range []image.Point{{10, 10}, {37, 50}, {60, 90}} {
+				if !near(img.At(p.X, p.Y), first.At(p.X, p.Y), 8) {
+					t.Errorf("At %v: expected the first frame's %v, got %v", p, first.At(p.X, p.Y), img.At(p.X, p.Y))
+				}
+			}
+		}},
+	}
+	for _, tt := range tests {
+		for _, contentType := range []string{GetContentTypeFromFilename(tt.name), "application/octet-stream"} {
+			t.Run(tt.name+"/"+contentType, func(t *testing.T) {
+				page, pdf := convertFixture(t, NewDefaultConfig(), tt.name, contentType)
+				tt.check(t, page, pdf)
+			})
+		}
+	}
+}
+
+func TestFixtures_CMYKReencoded(t *testing.T) {
+	// Re-encoded, the page keeps the colours of the source instead of
+	// coming out as a negative.
+	data, err := os.ReadFile(filepath.Join("testdata", "cmyk.jpeg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewDefaultConfig()
+	cfg.JPEGQuality = 50 // Below the source's, so it is re-encoded
+	page, _ := convertFixture(t, cfg, "cmyk.jpeg", "application/octet-stream")
+	if page.PassedThrough {
+		t.Fatal("Expected the CMYK JPEG re-encoded")
+	}
+	if got, want := meanColour(decodePage(t, page)), meanColour(source); !near(got, want, 8) {
+		t.Errorf("Expected a mean colour of about %v, got %v", want, got)
+	}
+}
+
+func TestFixtures_ShiftJISArchive(t *testing.T) {
+	// Japanese archivers write Shift-JIS names without the UTF-8 flag;
+	// read as UTF-8 they would name the bookmarks with mojibake.
+	file, err := os.Open(filepath.Join("testdata", "shift-jis.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources, err := ArchiveSources(file, info.Size(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names, chapters []string
+	for _, src := range sources {
+		names = append(names, src.OriginalFilename)
+		chapters = append(chapters, src.Chapter)
+	}
+	if want := []string{"第1話/001.png", "第1話/002.png", "第2話/001.png"}; !slices.Equal(names, want) {
+		t.Errorf("Expected pages %q, got %q", want, names)
+	}
+	if want := []string{"第1話", "第1話", "第2話"}; !slices.Equal(chapters, want) {
+		t.Errorf("Expected chapters %q, got %q", want, chapters)
+	}
+	var pdf bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources, NewDefaultConfig(), &pdf); err != nil {
+		t.Fatalf("Converting the archive failed: %v", err)
+	}
+	if doc, err := ReadPDF(pdf.Bytes()); err != nil || doc.NumPages() != 3 {
+		t.Errorf("Expected a 3-page PDF, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"log/slog"
+)
+
+// expandGIFFrames replaces every animated GIF among sources with one PNG
+// source per frame, for Config.GIFFrames. Frames are composited as a GIF
+// viewer would show them, since later frames usually only redraw the part
+// of the image that changed. They keep the GIF's index and chapter and are
+// named "<filename>#<frame>". A GIF that cannot be decoded is left as is,
+// so the conversion reports it like any other broken page.
+func expandGIFFrames(sources []ImageSource) []ImageSource {
+	expanded := make([]ImageSource, 0, len(sources))
+	for _, src := range sources {
+		if src.ContentType != "image/gif" || src.Reader == nil {
+			expanded = append(expanded, src)
+			continue
+		}
+		data, err := io.ReadAll(src.Reader)
+		src.Reader.Close()
+		src.Reader = io.NopCloser(bytes.NewReader(data))
+		if err != nil {
+			expanded = append(expanded, src)
+			continue
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) < 2 {
+			expanded = append(expanded, src)
+			continue
+		}
+		frames, err := gifFramePNGs(g)
+		if err != nil {
+			slog.Warn("Could not expand GIF frames, using the first frame", "filename", src.OriginalFilename, "error", err)
+			expanded = append(expanded, src)
+			continue
+		}
+		slog.Debug("Expanded animated GIF", "filename", src.OriginalFilename, "frames", len(frames))
+		for i, frame := range frames {
+			expanded = append(expanded, ImageSource{
+				OriginalFilename: fmt.Sprintf("%s#%d", src.OriginalFilename, i+1),
+				Reader:           io.NopCloser(bytes.NewReader(frame)),
+				ContentType:      "image/png",
+				Index:            src.Index,
+				Chapter:          src.Chapter,
+				URL:              src.URL,
+				FetchedAt:        src.FetchedAt,
+			})
+		}
+	}
+	return expanded
+}
+
+// gifFramePNGs renders every frame of g onto the logical screen, applying
+// each frame's disposal method before the next, and encodes the results as
+// PNG.
+func gifFramePNGs(g *gif.GIF) ([][]byte, error) {
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		screen = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(screen)
+	frames := make([][]byte, 0, len(g.Image))
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(screen)
+			draw.Draw(previous, screen, canvas, screen.Min, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, canvas); err != nil {
+			return nil, err
+		}
+		frames = append(frames, buf.Bytes())
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames, nil
+}
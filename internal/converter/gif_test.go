@@ -0,0 +1,76 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// animatedGIF returns a 4x4 GIF whose first frame is black and whose second
+// frame only paints the top-left pixel white.
+func animatedGIF(t *testing.T) []byte {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	first := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	second := image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+	second.SetColorIndex(0, 0, 1)
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, &gif.GIF{
+		Image:    []*image.Paletted{first, second},
+		Delay:    []int{10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone},
+		Config:   image.Config{ColorModel: palette, Width: 4, Height: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestConvertToPDF_GIF(t *testing.T) {
+	data := animatedGIF(t)
+	for _, tt := range []struct {
+		frames bool
+		pages  int
+	}{{false, 1}, {true, 2}} {
+		cfg := NewDefaultConfig()
+		cfg.GIFFrames = tt.frames
+		sources := []ImageSource{{OriginalFilename: "anim.gif", Reader: io.NopCloser(bytes.NewReader(data)), ContentType: GetContentTypeFromFilename("anim.gif")}}
+		var stats Stats
+		if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil {
+			t.Fatalf("Conversion failed: %v", err)
+		}
+		if stats.PagesAdded != tt.pages {
+			t.Errorf("gif_frames=%v: expected %d pages, got %d", tt.frames, tt.pages, stats.PagesAdded)
+		}
+	}
+}
+
+func TestGIFFramePNGs_Composites(t *testing.T) {
+	g, err := gif.DecodeAll(bytes.NewReader(animatedGIF(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := gifFramePNGs(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(frames[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 4 {
+		t.Errorf("Expected frames at the full screen size, got %v", img.Bounds())
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("Expected the second frame's white pixel")
+	}
+	if r, _, _, a := img.At(3, 3).RGBA(); r != 0 || a == 0 {
+		t.Error("Expected the first frame to show through outside the second frame")
+	}
+}
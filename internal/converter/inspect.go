@@ -0,0 +1,33 @@
+package converter
+
+import (
+	"bytes"
+	"image"
+)
+
+// PageInspection shows what a conversion did to one page, for reports
+// comparing pages before and after (Config.Inspect).
+type PageInspection struct {
+	Page                   // As it goes into the document; Data is only valid during the call
+	Before     image.Image // The source as decoded, before any transform; nil if it could not be decoded
+	Quality    int         // JPEG quality the page was re-encoded at; 0 if passed through or PNG
+	Transforms []string    // What changed the page, in order: flatten, auto-crop, grayscale, downscale, auto-levels, adjust, colour
+	Err        error       // Why the page failed, if it did
+}
+
+// InspectFunc receives a PageInspection for every page that was decoded.
+// It is called from the conversion's worker goroutines, so it must be
+// safe for concurrent use. A conversion with Config.TargetSize inspects
+// its pages again on every pass.
+type InspectFunc func(page PageInspection)
+
+// inspect reports the page encoded as res from before, which transforms
+// turned into after, to cfg.Inspect. Pages that were passed through are
+// decoded for it.
+func (cfg *Config) inspect(before, after decodedSource, res pageResult) {
+	inspection := PageInspection{Page: res.Page, Before: before.Image, Quality: res.quality, Transforms: after.Transforms, Err: res.err}
+	if inspection.Before == nil && before.Raw != nil {
+		inspection.Before, _, _ = image.Decode(bytes.NewReader(before.Raw))
+	}
+	cfg.Inspect(inspection)
+}
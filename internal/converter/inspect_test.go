@@ -0,0 +1,39 @@
+package converter
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestProcessImage_Inspect(t *testing.T) {
+	var inspections []PageInspection
+	cfg := NewDefaultConfig()
+	cfg.MaxWidth, cfg.MaxHeight = 100, 100
+	cfg.Inspect = func(page PageInspection) {
+		page.Data = nil // Only valid during the call
+		inspections = append(inspections, page)
+	}
+
+	if _, err := ProcessImage(context.Background(), cfg, pngSource(t, 400, 200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ProcessImage(context.Background(), cfg, pngSource(t, 80, 100, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(inspections) != 2 {
+		t.Fatalf("Expected 2 inspections, got %d", len(inspections))
+	}
+
+	downscaled := inspections[0]
+	if downscaled.Before == nil || downscaled.Before.Bounds().Dx() != 400 || downscaled.Width != 100 {
+		t.Errorf("Expected a 400 pixel wide page before and 100 after, got %v and %d", downscaled.Before, downscaled.Width)
+	}
+	if !slices.Equal(downscaled.Transforms, []string{"downscale"}) {
+		t.Errorf("Expected the page to be downscaled, got %q", downscaled.Transforms)
+	}
+	passed := inspections[1]
+	if !passed.PassedThrough || passed.Before == nil || passed.Before.Bounds().Dx() != 80 || len(passed.Transforms) != 0 {
+		t.Errorf("Expected an untransformed page passed through and decoded for inspection, got %+v", passed)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !leakcheck
+
+package converter
+
+import "context"
+
+// leakScope tracks the resources opened during a conversion. Tracking is
+// only compiled in with the leakcheck build tag; see leak_debug.go.
+type leakScope struct{}
+
+func trackLeaks(ctx context.Context) (context.Context, *leakScope) { return ctx, nil }
+
+func (s *leakScope) trackSources(sources []ImageSource) []ImageSource { return sources }
+
+func (s *leakScope) report() {}
+
+func untrackNothing() {}
+
+func trackResource(ctx context.Context, kind, name string) (untrack func()) { return untrackNothing }
@@ -0,0 +1,105 @@
+//go:build leakcheck
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// leakScope tracks the resources opened during a conversion, with the
+// stack that opened each one, so that report can name every source reader
+// or pool buffer still open when the conversion ends. It is compiled in
+// with the leakcheck build tag; otherwise leak.go makes it free.
+type leakScope struct {
+	mu   sync.Mutex
+	next int
+	open map[int]trackedResource
+}
+
+type trackedResource struct {
+	kind, name string
+	stack      []byte
+}
+
+type leakScopeKey struct{}
+
+// leakHandler receives one description per leaked resource. Tests replace
+// it to fail on leaks.
+var leakHandler = func(leaks []string) {
+	for _, leak := range leaks {
+		slog.Error("Resource leaked by conversion", "leak", leak)
+	}
+}
+
+// trackLeaks starts a scope for the resources opened under the returned
+// context.
+func trackLeaks(ctx context.Context) (context.Context, *leakScope) {
+	s := &leakScope{open: make(map[int]trackedResource)}
+	return context.WithValue(ctx, leakScopeKey{}, s), s
+}
+
+// track records an open resource and returns the function that marks it
+// closed; calling that function more than once is harmless.
+func (s *leakScope) track(kind, name string) (untrack func()) {
+	s.mu.Lock()
+	id := s.next
+	s.next++
+	s.open[id] = trackedResource{kind: kind, name: name, stack: debug.Stack()}
+	s.mu.Unlock()
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		delete(s.open, id)
+		s.mu.Unlock()
+	})
+}
+
+// trackedReadCloser marks its source reader closed when it is closed.
+type trackedReadCloser struct {
+	io.ReadCloser
+	untrack func()
+}
+
+func (r trackedReadCloser) Close() error {
+	r.untrack()
+	return r.ReadCloser.Close()
+}
+
+// trackSources returns a copy of sources whose readers are tracked.
+func (s *leakScope) trackSources(sources []ImageSource) []ImageSource {
+	tracked := make([]ImageSource, len(sources))
+	for i, src := range sources {
+		if src.Reader != nil {
+			src.Reader = trackedReadCloser{ReadCloser: src.Reader, untrack: s.track("source reader", src.OriginalFilename)}
+		}
+		tracked[i] = src
+	}
+	return tracked
+}
+
+// report hands every resource still open to leakHandler.
+func (s *leakScope) report() {
+	s.mu.Lock()
+	leaks := make([]string, 0, len(s.open))
+	for _, res := range s.open {
+		leaks = append(leaks, fmt.Sprintf("%s %q opened at:\n%s", res.kind, res.name, res.stack))
+	}
+	s.mu.Unlock()
+	if len(leaks) > 0 {
+		sort.Strings(leaks)
+		leakHandler(leaks)
+	}
+}
+
+// trackResource records a resource in the scope of ctx, if any.
+func trackResource(ctx context.Context, kind, name string) (untrack func()) {
+	if s, ok := ctx.Value(leakScopeKey{}).(*leakScope); ok {
+		return s.track(kind, name)
+	}
+	return func() {}
+}
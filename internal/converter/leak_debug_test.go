@@ -0,0 +1,54 @@
+//go:build leakcheck
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestMain fails the run if any test leaked a resource, so that
+// `go test -tags leakcheck` catches a missing Close or release anywhere in
+// the package's tests.
+func TestMain(m *testing.M) {
+	var mu sync.Mutex
+	var leaked []string
+	leakHandler = func(leaks []string) {
+		mu.Lock()
+		leaked = append(leaked, leaks...)
+		mu.Unlock()
+	}
+	code := m.Run()
+	if len(leaked) > 0 {
+		fmt.Fprintf(os.Stderr, "%d resources leaked:\n%s\n", len(leaked), strings.Join(leaked, "\n"))
+		code = 1
+	}
+	os.Exit(code)
+}
+
+func TestLeakScope_ReportsOpenResources(t *testing.T) {
+	handler := leakHandler
+	defer func() { leakHandler = handler }()
+	var reported []string
+	leakHandler = func(leaks []string) { reported = leaks }
+
+	ctx, leaks := trackLeaks(context.Background())
+	sources := leaks.trackSources([]ImageSource{
+		newStringImageSource("closed.png", "data", "image/png", 0),
+		newStringImageSource("open.png", "data", "image/png", 1),
+	})
+	sources[0].Reader.Close()
+	untrack := trackResource(ctx, "pool buffer", "returned.png")
+	untrack()
+	untrack() // Harmless
+	leaks.report()
+
+	if len(reported) != 1 || !strings.Contains(reported[0], `source reader "open.png"`) || !strings.Contains(reported[0], "TestLeakScope_ReportsOpenResources") {
+		t.Errorf("Expected only the open reader reported with its stack, got %q", reported)
+	}
+	sources[1].Reader.Close()
+}
@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrInvalidAutoLevels is returned for auto levels with out-of-range clip
+// percentages.
+var ErrInvalidAutoLevels = errors.New("invalid auto_levels")
+
+// minLevelsRange is the narrowest tonal range, in levels out of 256, that
+// auto levels stretches. Narrower pages are blank or nearly so, and
+// stretching them would mostly amplify noise and JPEG artefacts; it also
+// caps the gain at 4x.
+const minLevelsRange = 64
+
+// AutoLevels stretches the tonal range of every page to full black and
+// white, which brightens washed-out digital rips. Up to BlackClip percent
+// of the pixels may end up pure black and WhiteClip percent pure white, so
+// a few stray dark or bright pixels do not hold the stretch back.
+//
+// A page's darkest and lightest levels are always free to move, but the
+// clip never cuts into a level it cannot take whole: a screentone that
+// covers more of the page than the clip allows keeps its own level rather
+// than being crushed into solid black or white.
+type AutoLevels struct {
+	BlackClip float64 `json:"black_clip,omitempty"` // Percent of pixels that may be clipped to black, 0 to 50
+	WhiteClip float64 `json:"white_clip,omitempty"` // Percent of pixels that may be clipped to white, 0 to 50
+}
+
+func (l *AutoLevels) validate() error {
+	for _, clip := range []float64{l.BlackClip, l.WhiteClip} {
+		if clip < 0 || clip >= 50 {
+			return fmt.Errorf("%w: clip percentage %v is not at least 0 and below 50", ErrInvalidAutoLevels, clip)
+		}
+	}
+	return nil
+}
+
+// levels returns the black and white points for a page with the given
+// luma histogram of total pixels: the levels that map to 0 and 255. ok is
+// false if the page should be left as it is, because its range is already
+// full or too narrow to stretch.
+func (l *AutoLevels) levels(hist *[256]int, total int) (black, white int, ok bool) {
+	maxBlack := int(float64(total) * l.BlackClip / 100)
+	maxWhite := int(float64(total) * l.WhiteClip / 100)
+
+	for black < 255 && hist[black] == 0 {
+		black++
+	}
+	for v, clipped := black+1, hist[black]; v < 256; v++ {
+		if clipped += hist[v]; clipped > maxBlack {
+			break
+		}
+		black = v
+	}
+
+	white = 255
+	for white > 0 && hist[white] == 0 {
+		white--
+	}
+	for v, clipped := white-1, hist[white]; v >= 0; v-- {
+		if clipped += hist[v]; clipped > maxWhite {
+			break
+		}
+		white = v
+	}
+
+	if white-black < minLevelsRange || (black == 0 && white == 255) {
+		return 0, 0, false
+	}
+	return black, white, true
+}
+
+// levelsTable returns the lookup table mapping black to 0 and white to 255.
+func levelsTable(black, white int) *[256]uint8 {
+	var table [256]uint8
+	for v := range table {
+		switch {
+		case v <= black:
+			table[v] = 0
+		case v >= white:
+			table[v] = 255
+		default:
+			table[v] = uint8(((v-black)*255 + (white-black)/2) / (white - black))
+		}
+	}
+	return &table
+}
+
+// apply returns img with its levels stretched. The same curve, taken from
+// the luma histogram, is applied to each colour channel so hues are kept.
+// Grayscale images stay grayscale. If the page is left as it is, img itself
+// and false are returned.
+func (l *AutoLevels) apply(img image.Image) (image.Image, bool) {
+	var hist [256]int
+	if gray, ok := img.(*image.Gray); ok {
+		b := gray.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := gray.Pix[gray.PixOffset(b.Min.X, y):][:b.Dx()]
+			for _, v := range row {
+				hist[v]++
+			}
+		}
+		black, white, ok := l.levels(&hist, b.Dx()*b.Dy())
+		if !ok {
+			return img, false
+		}
+		table := levelsTable(black, white)
+		adjusted := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+		for y := 0; y < b.Dy(); y++ {
+			row := gray.Pix[gray.PixOffset(b.Min.X, b.Min.Y+y):][:b.Dx()]
+			out := adjusted.Pix[y*adjusted.Stride:][:b.Dx()]
+			for x, v := range row {
+				out[x] = table[v]
+			}
+		}
+		return adjusted, true
+	}
+
+	adjusted := imaging.Clone(img)
+	for i := 0; i < len(adjusted.Pix); i += 4 {
+		r, g, b := int(adjusted.Pix[i]), int(adjusted.Pix[i+1]), int(adjusted.Pix[i+2])
+		hist[(299*r+587*g+114*b)/1000]++
+	}
+	black, white, ok := l.levels(&hist, len(adjusted.Pix)/4)
+	if !ok {
+		return img, false
+	}
+	table := levelsTable(black, white)
+	for i := 0; i < len(adjusted.Pix); i += 4 {
+		adjusted.Pix[i] = table[adjusted.Pix[i]]
+		adjusted.Pix[i+1] = table[adjusted.Pix[i+1]]
+		adjusted.Pix[i+2] = table[adjusted.Pix[i+2]]
+	}
+	return adjusted, true
+}
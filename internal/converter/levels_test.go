@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestAutoLevels_Levels(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		fill         func(hist *[256]int)
+		clip         float64
+		black, white int
+		ok           bool
+	}{
+		{
+			name: "washed out",
+			fill: func(hist *[256]int) {
+				for v := 60; v <= 200; v++ {
+					hist[v] = 100
+				}
+			},
+			black: 60, white: 200, ok: true,
+		},
+		{
+			name: "stray pixels within the clip",
+			fill: func(hist *[256]int) {
+				hist[0], hist[255] = 100, 100 // 1% each
+				for v := 80; v < 180; v++ {
+					hist[v] = 98
+				}
+			},
+			clip:  1,
+			black: 79, white: 180, ok: true,
+		},
+		{
+			name: "dark screentone larger than the clip",
+			fill: func(hist *[256]int) {
+				hist[10] = 300   // 3% ink
+				hist[30] = 2000  // 20% dark tone
+				hist[220] = 7700 // Paper
+			},
+			clip:  5,
+			black: 29, white: 220, ok: true,
+		},
+		{
+			name: "already full range",
+			fill: func(hist *[256]int) {
+				hist[0], hist[128], hist[255] = 10, 10, 10
+			},
+			ok: false,
+		},
+		{
+			name: "nearly blank",
+			fill: func(hist *[256]int) {
+				for v := 230; v <= 250; v++ {
+					hist[v] = 100
+				}
+			},
+			ok: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var hist [256]int
+			tc.fill(&hist)
+			total := 0
+			for _, n := range hist {
+				total += n
+			}
+			l := &AutoLevels{BlackClip: tc.clip, WhiteClip: tc.clip}
+			black, white, ok := l.levels(&hist, total)
+			if ok != tc.ok || (ok && (black != tc.black || white != tc.white)) {
+				t.Errorf("Expected black %d, white %d, ok %v; got %d, %d, %v", tc.black, tc.white, tc.ok, black, white, ok)
+			}
+		})
+	}
+}
+
+func TestAutoLevels_Apply(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 128, 1))
+	for x := range 128 {
+		gray.SetGray(x, 0, color.Gray{Y: uint8(64 + x)})
+	}
+	l := &AutoLevels{}
+	adjusted, changed := l.apply(gray)
+	if !changed {
+		t.Fatal("Expected a washed-out page to be adjusted")
+	}
+	out, ok := adjusted.(*image.Gray)
+	if !ok {
+		t.Fatalf("Expected a grayscale page to stay grayscale, got %T", adjusted)
+	}
+	if first, last := out.GrayAt(0, 0).Y, out.GrayAt(127, 0).Y; first != 0 || last != 255 {
+		t.Errorf("Expected the range stretched to 0-255, got %d-%d", first, last)
+	}
+
+	// Pass-through data keeps its bytes when its levels are left alone.
+	full := image.NewGray(image.Rect(0, 0, 2, 1))
+	full.SetGray(1, 0, color.Gray{Y: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, full); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := transformSource(&Config{AutoLevels: l}, decodedSource{Raw: buf.Bytes(), ImageTypeForPDF: "PNG"})
+	if err != nil || decoded.Raw == nil || decoded.Image != nil {
+		t.Errorf("Expected a full-range page to stay pass-through, got raw %v, image %v, err %v", decoded.Raw != nil, decoded.Image != nil, err)
+	}
+
+	cfg := &Config{AutoLevels: &AutoLevels{BlackClip: 50}}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidAutoLevels) {
+		t.Errorf("Expected ErrInvalidAutoLevels for a 50%% clip, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package converter
+
+import "context"
+
+// WorkerLimit caps how many pages are decoded or encoded at once across
+// all the conversions sharing it through Config.Limit, so several
+// conversions can run side by side on one budget of CPUs. Each conversion
+// still starts its own workers; they wait for a slot before each decode or
+// encode.
+type WorkerLimit struct {
+	slots chan struct{}
+}
+
+// NewWorkerLimit returns a WorkerLimit of n decodes and encodes at once,
+// at least 1.
+func NewWorkerLimit(n int) *WorkerLimit {
+	return &WorkerLimit{slots: make(chan struct{}, max(n, 1))}
+}
+
+// acquire waits for a slot, or for ctx to be done. It never waits on a nil
+// WorkerLimit.
+func (l *WorkerLimit) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return CancellationError(ctx)
+	}
+}
+
+// release gives back a slot taken by acquire.
+func (l *WorkerLimit) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// acquireLimits takes a slot of each of limits in turn, so a slot of a
+// shared limit is not held while waiting for a narrower one. On error no
+// slot is held.
+func acquireLimits(ctx context.Context, limits ...*WorkerLimit) error {
+	for i, l := range limits {
+		if err := l.acquire(ctx); err != nil {
+			for _, taken := range limits[:i] {
+				taken.release()
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestWorkerLimit_SharedByConversions(t *testing.T) {
+	limit := NewWorkerLimit(1)
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg := NewDefaultConfig()
+			cfg.NumWorkers = 4
+			cfg.Limit = limit
+			sources := []ImageSource{pngSource(t, 10, 10, 0), pngSource(t, 12, 12, 1), pngSource(t, 14, 14, 2)}
+			var out bytes.Buffer
+			var stats Stats
+			if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &out, &stats); err != nil || stats.PagesAdded != 3 {
+				t.Errorf("Conversion with a shared limit failed: %v, %d pages", err, stats.PagesAdded)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every slot is free again, and waiting for a taken one stops with the context.
+	if err := limit.acquire(context.Background()); err != nil {
+		t.Fatalf("Expected a free slot after the conversions, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limit.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected waiting for a taken slot to stop with the context, got %v", err)
+	}
+	limit.release()
+}
@@ -0,0 +1,38 @@
+package converter
+
+import (
+	"log/slog"
+)
+
+// normalizePageWidths scales every successfully processed page to the most
+// common page width, keeping its aspect ratio, so a volume mixing scan
+// resolutions does not jump in zoom between pages on fixed-zoom readers.
+// Only the page geometry changes; the embedded image data is not resampled.
+// Ties between equally common widths go to the wider one. It returns the
+// width used, or 0 if there were no pages.
+func normalizePageWidths(results []pageResult) float64 {
+	counts := make(map[float64]int)
+	var modal float64
+	for _, res := range results {
+		if res.err != nil || res.layoutWidth <= 0 {
+			continue
+		}
+		counts[res.layoutWidth]++
+		if n := counts[res.layoutWidth]; n > counts[modal] || (n == counts[modal] && res.layoutWidth > modal) {
+			modal = res.layoutWidth
+		}
+	}
+	if modal == 0 {
+		return 0
+	}
+	for i := range results {
+		res := &results[i]
+		if res.err != nil || res.layoutWidth <= 0 || res.layoutWidth == modal {
+			continue
+		}
+		res.layoutHeight = res.layoutHeight * modal / res.layoutWidth
+		res.layoutWidth = modal
+	}
+	slog.Debug("Normalized page widths", "width", modal, "distinctWidths", len(counts))
+	return modal
+}
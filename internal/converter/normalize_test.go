@@ -0,0 +1,43 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestNormalizePageWidths(t *testing.T) {
+	pages := []pageResult{
+		{layoutWidth: 800, layoutHeight: 1200},
+		{layoutWidth: 1600, layoutHeight: 2400},
+		{layoutWidth: 800, layoutHeight: 1100},
+		{layoutWidth: 400, layoutHeight: 300},
+		{layoutWidth: 1000, layoutHeight: 1000, err: context.Canceled},
+	}
+	if width := normalizePageWidths(pages); width != 800 {
+		t.Fatalf("Expected modal width 800, got %v", width)
+	}
+	expected := [][2]float64{{800, 1200}, {800, 1200}, {800, 1100}, {800, 600}, {1000, 1000}}
+	for i, page := range pages {
+		if page.layoutWidth != expected[i][0] || page.layoutHeight != expected[i][1] {
+			t.Errorf("Page %d: expected %vx%v, got %vx%v", i, expected[i][0], expected[i][1], page.layoutWidth, page.layoutHeight)
+		}
+	}
+
+	if width := normalizePageWidths([]pageResult{{layoutWidth: 10, layoutHeight: 10}, {layoutWidth: 20, layoutHeight: 20}}); width != 20 {
+		t.Errorf("Expected a tie to go to the wider page, got %v", width)
+	}
+}
+
+func TestConvertToPDF_NormalizeWidth(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.NormalizeWidth = true
+	sources := []ImageSource{pngSource(t, 10, 20, 0), pngSource(t, 20, 20, 1), pngSource(t, 10, 10, 2)}
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if stats.PagesAdded != 3 {
+		t.Errorf("Expected all pages, got %+v", stats)
+	}
+}
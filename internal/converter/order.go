@@ -0,0 +1,189 @@
+package converter
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// OrderResolver decides the page order of a set of image file names.
+// Implementations must return every input name exactly once.
+type OrderResolver interface {
+	Order(names []string) ([]string, error)
+}
+
+// NaturalOrder is the default ordering heuristic: names are compared
+// case-insensitively with embedded numbers compared by value, so "p2.jpg"
+// comes before "p10.jpg".
+type NaturalOrder struct{}
+
+// Order implements OrderResolver.
+func (NaturalOrder) Order(names []string) ([]string, error) {
+	ordered := append([]string(nil), names...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return naturalLess(ordered[i], ordered[j])
+	})
+	return ordered, nil
+}
+
+// ExplicitOrder puts the listed names first, in the listed order. Names that
+// are not listed follow in natural order; listed names that do not exist are
+// ignored.
+type ExplicitOrder struct {
+	Names []string
+}
+
+// Order implements OrderResolver.
+func (o ExplicitOrder) Order(names []string) ([]string, error) {
+	remaining := make(map[string]bool, len(names))
+	for _, name := range names {
+		remaining[name] = true
+	}
+	ordered := make([]string, 0, len(names))
+	for _, name := range o.Names {
+		if !remaining[name] {
+			slog.Warn("Ignoring unknown or repeated file in explicit page order", "filename", name)
+			continue
+		}
+		ordered = append(ordered, name)
+		delete(remaining, name)
+	}
+	var rest []string
+	for _, name := range names {
+		if remaining[name] {
+			rest = append(rest, name)
+		}
+	}
+	rest, _ = NaturalOrder{}.Order(rest)
+	return append(ordered, rest...), nil
+}
+
+// PatternOrder sorts names by the first capture group of Pattern (or the
+// whole match if it has none), compared naturally. Names that do not match
+// follow in natural order. It handles release groups whose page number is
+// not the first number in the file name.
+type PatternOrder struct {
+	Pattern *regexp.Regexp
+}
+
+// Order implements OrderResolver.
+func (o PatternOrder) Order(names []string) ([]string, error) {
+	type keyed struct {
+		name, key string
+	}
+	var matched []keyed
+	var unmatched []string
+	for _, name := range names {
+		m := o.Pattern.FindStringSubmatch(name)
+		switch {
+		case m == nil:
+			unmatched = append(unmatched, name)
+		case len(m) > 1:
+			matched = append(matched, keyed{name, m[1]})
+		default:
+			matched = append(matched, keyed{name, m[0]})
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].key != matched[j].key {
+			return naturalLess(matched[i].key, matched[j].key)
+		}
+		return naturalLess(matched[i].name, matched[j].name)
+	})
+	ordered := make([]string, 0, len(names))
+	for _, k := range matched {
+		ordered = append(ordered, k.name)
+	}
+	unmatched, _ = NaturalOrder{}.Order(unmatched)
+	return append(ordered, unmatched...), nil
+}
+
+// ReverseOrder reverses the order produced by Base.
+type ReverseOrder struct {
+	Base OrderResolver
+}
+
+// Order implements OrderResolver.
+func (o ReverseOrder) Order(names []string) ([]string, error) {
+	ordered, err := o.Base.Order(names)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	}
+	return ordered, nil
+}
+
+// OrderOverride is the per-series manifest, as stored in a JSON file next
+// to the input: the ordering override of its pages and the quality rules
+// of the conversions of that input. At most one of Order and Pattern may
+// be set.
+type OrderOverride struct {
+	Order        []string      `json:"order,omitempty"`         // Explicit page order
+	Pattern      string        `json:"pattern,omitempty"`       // Regular expression whose first group is the sort key
+	Reverse      bool          `json:"reverse,omitempty"`       // Reverse the resulting order
+	QualityRules []QualityRule `json:"quality_rules,omitempty"` // Config.QualityRules of conversions of the input
+}
+
+// Resolver builds the OrderResolver described by the override.
+func (o OrderOverride) Resolver() (OrderResolver, error) {
+	var resolver OrderResolver = NaturalOrder{}
+	switch {
+	case len(o.Order) > 0 && o.Pattern != "":
+		return nil, fmt.Errorf("order and pattern cannot be combined")
+	case len(o.Order) > 0:
+		resolver = ExplicitOrder{Names: o.Order}
+	case o.Pattern != "":
+		re, err := regexp.Compile(o.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		resolver = PatternOrder{Pattern: re}
+	}
+	if o.Reverse {
+		resolver = ReverseOrder{Base: resolver}
+	}
+	return resolver, nil
+}
+
+// naturalLess compares a and b case-insensitively, treating runs of digits
+// as numbers.
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNum, bNum := strings.TrimLeft(aDigits, "0"), strings.TrimLeft(bDigits, "0")
+			if len(aNum) != len(bNum) {
+				return len(aNum) < len(bNum)
+			}
+			if aNum != bNum {
+				return aNum < bNum
+			}
+			if len(aDigits) != len(bDigits) {
+				return len(aDigits) < len(bDigits)
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingDigits returns the run of ASCII digits at the start of s.
+func leadingDigits(s string) string {
+	for i, r := range s {
+		if r > unicode.MaxASCII || !unicode.IsDigit(r) {
+			return s[:i]
+		}
+	}
+	return s
+}
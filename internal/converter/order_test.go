@@ -0,0 +1,51 @@
+package converter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNaturalOrder(t *testing.T) {
+	names := []string{"p10.jpg", "P2.jpg", "p1.jpg", "cover.jpg", "p02b.jpg"}
+	ordered, _ := NaturalOrder{}.Order(names)
+	expected := []string{"cover.jpg", "p1.jpg", "P2.jpg", "p02b.jpg", "p10.jpg"}
+	if !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("Expected %v, got %v", expected, ordered)
+	}
+}
+
+func TestOrderOverride_Resolver(t *testing.T) {
+	names := []string{"v2_c3_p10.png", "v2_c3_p9.png", "credits.png", "v2_c3_p1.png"}
+	tests := []struct {
+		name     string
+		override OrderOverride
+		expected []string
+	}{
+		{"default", OrderOverride{}, []string{"credits.png", "v2_c3_p1.png", "v2_c3_p9.png", "v2_c3_p10.png"}},
+		{"pattern", OrderOverride{Pattern: `_p(\d+)`}, []string{"v2_c3_p1.png", "v2_c3_p9.png", "v2_c3_p10.png", "credits.png"}},
+		{"explicit", OrderOverride{Order: []string{"v2_c3_p9.png", "missing.png", "credits.png"}}, []string{"v2_c3_p9.png", "credits.png", "v2_c3_p1.png", "v2_c3_p10.png"}},
+		{"reverse", OrderOverride{Pattern: `_p(\d+)`, Reverse: true}, []string{"credits.png", "v2_c3_p10.png", "v2_c3_p9.png", "v2_c3_p1.png"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := tt.override.Resolver()
+			if err != nil {
+				t.Fatalf("Resolver failed: %v", err)
+			}
+			ordered, err := resolver.Order(names)
+			if err != nil {
+				t.Fatalf("Order failed: %v", err)
+			}
+			if !reflect.DeepEqual(ordered, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, ordered)
+			}
+		})
+	}
+
+	if _, err := (OrderOverride{Order: []string{"a"}, Pattern: "a"}).Resolver(); err == nil {
+		t.Error("Expected error when combining order and pattern")
+	}
+	if _, err := (OrderOverride{Pattern: "("}).Resolver(); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+}
@@ -0,0 +1,131 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// Page is a single image normalized for embedding in a document: JPEG or
+// PNG data with its size in pixels. It is what the processing stages hand
+// to the PDF and EPUB writers, and holds no readers or other resources.
+type Page struct {
+	Data          []byte
+	Format        string // "jpeg" or "png"
+	Width         int
+	Height        int
+	DPI           float64 // Resolution recorded in the source (JFIF density or PNG pHYs); 0 if none
+	PassedThrough bool    // Data is the source's own bytes rather than re-encoded
+	Source        PageSource
+}
+
+// PageSource records where a page came from.
+type PageSource struct {
+	Index    int    // ImageSource.Index
+	Filename string // ImageSource.OriginalFilename
+	Chapter  string // ImageSource.Chapter
+	Format   string // Format the source was decoded as ("jpeg", "png", "webp", "gif", "bmp")
+	Bytes    int64  // Size of the source data
+
+	URL       string    // ImageSource.URL
+	FetchedAt time.Time // ImageSource.FetchedAt
+}
+
+// pdfImageType returns the image type gofpdf registers the page data as.
+func (p Page) pdfImageType() string {
+	if p.Format == "png" {
+		return "PNG"
+	}
+	return "JPG"
+}
+
+// pageResult is the outcome of processing one source: its page, or the
+// error that kept the source from becoming one. The page is laid out at
+// layoutWidth x layoutHeight points, its pixel size unless
+// normalizePageWidths scaled it.
+type pageResult struct {
+	Page
+	err                       error
+	layoutWidth, layoutHeight float64
+
+	contentHash  []byte        // SHA-256 of the source bytes, set when Config.DedupPages is on
+	blank        bool          // The page is blank or nearly so, set when Config.SkipBlank is on
+	quality      int           // JPEG quality Data was encoded at; 0 if passed through or PNG
+	buf          *bytes.Buffer // Pooled buffer backing Data for re-encoded pages; nil otherwise
+	pooledReused bool          // That buffer was reused rather than newly allocated
+	untrack      func()        // Marks the buffer returned for leak tracking
+}
+
+// failedPage returns the result for a source that could not be processed.
+func failedPage(src ImageSource, err error) pageResult {
+	return pageResult{Page: Page{Source: PageSource{Index: src.Index, Filename: src.OriginalFilename, Chapter: src.Chapter}}, err: err}
+}
+
+// release returns the page's buffer to the pool. Data must not be used
+// afterwards.
+func (r *pageResult) release() {
+	if r.buf != nil {
+		r.untrack()
+		bufferPool.Put(r.buf)
+		r.buf, r.Data = nil, nil
+	}
+}
+
+// ProcessImage runs a single image through the same decode and encode
+// stages as a conversion, with the same settings from cfg, and returns the
+// resulting page instead of adding it to a document. The source reader is
+// always closed.
+func ProcessImage(ctx context.Context, cfg *Config, src ImageSource) (Page, error) {
+	if err := cfg.Validate(); err != nil {
+		if src.Reader != nil {
+			src.Reader.Close()
+		}
+		return Page{}, err
+	}
+	ctx, leaks := trackLeaks(ctx)
+	defer leaks.report()
+	src = leaks.trackSources([]ImageSource{src})[0]
+	ctx, stopBudget := withPixelBudget(ctx, cfg)
+	defer stopBudget(nil)
+	res := processSingleImage(ctx, cfg, src)
+	if res.err != nil {
+		return Page{}, res.err
+	}
+	page := res.Page
+	if res.buf != nil {
+		// Copied out, so the pooled buffer can go back to the pool.
+		page.Data = bytes.Clone(page.Data)
+		res.release()
+	}
+	return page, nil
+}
+
+// imageDPI returns the resolution recorded in JPEG (JFIF APP0) or PNG
+// (pHYs) data, or 0 if there is none or it is not in absolute units.
+func imageDPI(data []byte) float64 {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff\xe0")) && len(data) >= 18 && string(data[6:11]) == "JFIF\x00":
+		density := float64(binary.BigEndian.Uint16(data[14:16]))
+		switch data[13] {
+		case 1: // Dots per inch
+			return density
+		case 2: // Dots per centimeter
+			return density * 2.54
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		// pHYs must come before the first IDAT chunk.
+		for rest := data[8:]; len(rest) >= 12; {
+			length := binary.BigEndian.Uint32(rest[:4])
+			kind := string(rest[4:8])
+			if kind == "IDAT" || uint64(length)+12 > uint64(len(rest)) {
+				break
+			}
+			if kind == "pHYs" && length == 9 && rest[16] == 1 { // Unit is the meter
+				return float64(binary.BigEndian.Uint32(rest[8:12])) * 0.0254
+			}
+			rest = rest[12+length:]
+		}
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestProcessImage(t *testing.T) {
+	page, err := ProcessImage(context.Background(), NewDefaultConfig(), pngSource(t, 30, 20, 0))
+	if err != nil {
+		t.Fatalf("ProcessImage failed: %v", err)
+	}
+	if page.Format != "png" || page.Width != 30 || page.Height != 20 || !page.PassedThrough {
+		t.Errorf("Unexpected page %+v", page)
+	}
+	if src := page.Source; src.Format != "png" || src.Bytes != int64(len(page.Data)) {
+		t.Errorf("Unexpected provenance %+v", src)
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(page.Data)); err != nil || format != "png" {
+		t.Errorf("Expected PNG data, got %q, %v", format, err)
+	}
+
+	// A JPEG above the target quality is re-encoded.
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), &jpeg.Options{Quality: 100})
+	cfg := NewDefaultConfig()
+	cfg.JPEGQuality = 50
+	page, err = ProcessImage(context.Background(), cfg, newStringImageSource("page", buf.String(), "application/octet-stream", 0))
+	if err != nil {
+		t.Fatalf("ProcessImage failed: %v", err)
+	}
+	if page.Format != "jpeg" || page.PassedThrough || len(page.Data) == 0 {
+		t.Errorf("Expected a re-encoded JPEG, got %+v", page)
+	}
+
+	if _, err := ProcessImage(context.Background(), NewDefaultConfig(), newStringImageSource("broken.png", "not an image", "image/png", 0)); err == nil {
+		t.Error("Expected an error for undecodable data")
+	}
+}
+
+func TestImageDPI(t *testing.T) {
+	jfif := func(units byte, density uint16) []byte {
+		return []byte{0xff, 0xd8, 0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, units, byte(density >> 8), byte(density), byte(density >> 8), byte(density)}
+	}
+	png := []byte("\x89PNG\r\n\x1a\n" +
+		"\x00\x00\x00\x0dIHDR" + strings.Repeat("\x00", 13) + "crc." +
+		"\x00\x00\x00\x09pHYs\x00\x00\x0b\x13\x00\x00\x0b\x13\x01crc.")
+	for _, tc := range []struct {
+		name string
+		data []byte
+		dpi  float64
+	}{
+		{"jfif-inch", jfif(1, 300), 300},
+		{"jfif-cm", jfif(2, 100), 254},
+		{"jfif-aspect-only", jfif(0, 1), 0},
+		{"png-phys", png, 2835 * 0.0254},
+		{"garbage", []byte("not an image"), 0},
+	} {
+		if dpi := imageDPI(tc.data); math.Abs(dpi-tc.dpi) > 1e-9 {
+			t.Errorf("%s: expected %v dpi, got %v", tc.name, tc.dpi, dpi)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package converter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// pageCacheVersion is part of every PageCache key; raising it when pages
+// come out differently for the same settings retires the pages cached
+// before.
+const pageCacheVersion = 1
+
+// PageCache keeps the pages of conversions, so that a later conversion of
+// the same source bytes with the same page settings can take them instead
+// of decoding and encoding them again, e.g. to resume a conversion that
+// was stopped. Keys are opaque strings of hex digits. It is used from the
+// conversion's worker goroutines, so it must be safe for concurrent use.
+type PageCache interface {
+	// Load returns the page stored under key, if there is one.
+	Load(key string) (Page, bool)
+	// Store keeps page under key. page.Data is only valid during the call.
+	Store(key string, page Page)
+}
+
+// pageCacheKeys derives the PageCache keys of one conversion with cfg.
+type pageCacheKeys struct {
+	settings []byte // SHA-256 of everything in cfg that changes how pages come out
+}
+
+// newPageCacheKeys returns the keys of a conversion with cfg. Settings
+// that only affect how fast pages are made or what is done with them
+// afterwards are left out, so changing them keeps the cached pages.
+func newPageCacheKeys(cfg *Config) pageCacheKeys {
+	settings := *cfg
+	settings.NumWorkers, settings.DecodeWorkers, settings.EncodeWorkers = 0, 0, 0
+	settings.LargestFirst, settings.DecodeLimits, settings.MaxTotalMegapixels = false, nil, 0
+	settings.OutputFilename = ""
+	encoded, _ := json.Marshal(&settings) // Plain values only
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d %+v ", pageCacheVersion, cfg.sizePass)
+	hash.Write(encoded)
+	return pageCacheKeys{settings: hash.Sum(nil)}
+}
+
+// key returns the key of the page made from source bytes with SHA-256
+// contentHash at JPEG quality, which QualityRules can make depend on where
+// the page is.
+func (k pageCacheKeys) key(contentHash []byte, quality int) string {
+	hash := sha256.New()
+	hash.Write(k.settings)
+	hash.Write(contentHash)
+	fmt.Fprintf(hash, " %d", quality)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// loadCachedPage reads src to look its page up in cfg.PageCache. If done,
+// res is the outcome of src: the cached page, or the failure to read src.
+// Otherwise src's reader is replaced with one over the bytes read, and key
+// is what its page is to be stored under once made.
+func (k pageCacheKeys) loadCachedPage(cfg *Config, src *ImageSource) (res pageResult, key string, done bool) {
+	data, err := io.ReadAll(src.Reader)
+	src.Reader.Close()
+	if err != nil {
+		return failedPage(*src, fmt.Errorf("could not read image data for %s: %w", src.OriginalFilename, err)), "", true
+	}
+	contentHash := sha256.Sum256(data)
+	key = k.key(contentHash[:], cfg.pageQuality(src.Chapter, src.Index))
+	page, ok := cfg.PageCache.Load(key)
+	if !ok {
+		src.Reader = io.NopCloser(bytes.NewReader(data))
+		return pageResult{}, key, false
+	}
+	page.Source.Index, page.Source.Filename, page.Source.Chapter = src.Index, src.OriginalFilename, src.Chapter
+	page.Source.URL, page.Source.FetchedAt = src.URL, src.FetchedAt
+	page.Source.Bytes = int64(len(data))
+	res = pageResult{Page: page, contentHash: contentHash[:]}
+	res.layoutWidth, res.layoutHeight = float64(page.Width), float64(page.Height)
+	return res, key, true
+}
@@ -0,0 +1,105 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// mapPageCache is a PageCache in memory.
+type mapPageCache struct {
+	mu    sync.Mutex
+	pages map[string]Page
+}
+
+func (c *mapPageCache) Load(key string) (Page, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page, ok := c.pages[key]
+	return page, ok
+}
+
+func (c *mapPageCache) Store(key string, page Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page.Data = bytes.Clone(page.Data)
+	c.pages[key] = page
+}
+
+func TestPageCache(t *testing.T) {
+	cache := &mapPageCache{pages: map[string]Page{}}
+	convert := func(cfg *Config, pages int) Stats {
+		t.Helper()
+		var sources []ImageSource
+		for i := range pages {
+			sources = append(sources, pngSource(t, 10+i, 12, i))
+		}
+		cfg.PageCache = cache
+		var stats Stats
+		if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil || stats.PagesAdded != pages {
+			t.Fatalf("Conversion with a page cache failed: %v, %d pages", err, stats.PagesAdded)
+		}
+		return stats
+	}
+
+	if stats := convert(NewDefaultConfig(), 2); stats.PagesCached != 0 || len(cache.pages) != 2 {
+		t.Fatalf("Expected both pages converted and cached, got %d cached, %d stored", stats.PagesCached, len(cache.pages))
+	}
+	cfg := NewDefaultConfig()
+	cfg.NumWorkers = 1 // Worker counts do not change the pages
+	if stats := convert(cfg, 3); stats.PagesCached != 2 || len(cache.pages) != 3 {
+		t.Errorf("Expected the first two pages from the cache and the third converted, got %d cached, %d stored", stats.PagesCached, len(cache.pages))
+	}
+	cfg = NewDefaultConfig()
+	cfg.MaxWidth = 8
+	if stats := convert(cfg, 3); stats.PagesCached != 0 {
+		t.Errorf("Expected no cached pages with other page settings, got %d", stats.PagesCached)
+	}
+
+	// Quality rules by page range make a page's quality depend on where
+	// it is, so a page moved to another position is converted again.
+	cache.pages = map[string]Page{}
+	rules := func() *Config {
+		cfg := NewDefaultConfig()
+		cfg.QualityRules = []QualityRule{{Pages: "1", JPEGQuality: 50}}
+		return cfg
+	}
+	convert(rules(), 2)
+	sources := []ImageSource{pngSource(t, 11, 12, 0), pngSource(t, 10, 12, 1)}
+	cfg = rules()
+	cfg.PageCache = cache
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil || stats.PagesCached != 0 {
+		t.Errorf("Expected pages at other positions converted again, got %v, %d cached", err, stats.PagesCached)
+	}
+}
+
+func TestPageCache_PixelBudget(t *testing.T) {
+	cache := &mapPageCache{pages: map[string]Page{}}
+	sources := func() []ImageSource {
+		var sources []ImageSource
+		for i := range 4 {
+			sources = append(sources, pngSource(t, 500, 500, i)) // 0.25 megapixels each
+		}
+		return sources
+	}
+	cfg := NewDefaultConfig()
+	cfg.PageCache = cache
+	if _, err := ConvertToPDF(context.Background(), sources(), cfg, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The budget does not change the pages, so they all come from the
+	// cache, and still count against it.
+	cfg = NewDefaultConfig()
+	cfg.PageCache = cache
+	cfg.MaxTotalMegapixels = 0.6
+	var stats Stats
+	_, err := ConvertToPDFWithStats(context.Background(), sources(), cfg, &bytes.Buffer{}, &stats)
+	var budgetErr *PixelBudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Expected a *PixelBudgetError for cached pages over the budget, got %v (%d cached)", err, stats.PagesCached)
+	}
+}
@@ -0,0 +1,175 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Fit modes for PageSize.Fit.
+const (
+	FitContain = "contain" // Whole image on the page, letterboxed with the background
+	FitCover   = "cover"   // Image fills the page; what overflows it is cut off
+	FitStretch = "stretch" // Image fills the page, ignoring its aspect ratio
+)
+
+// ErrInvalidPageSize is returned for a page size with an unknown paper or
+// fit mode, or combined with settings it cannot honour.
+var ErrInvalidPageSize = errors.New("invalid page size")
+
+// ErrInvalidMargin is returned for a negative margin, one that leaves no
+// room on a PageSize page, or one combined with a print layout.
+var ErrInvalidMargin = errors.New("invalid margin")
+
+// ErrInvalidBackground is returned for a background that is not #rrggbb.
+var ErrInvalidBackground = errors.New("invalid background")
+
+// PageSize gives every page of a PDF the same size, a paper or Width x
+// Height points, instead of the size of its image, so mixed-size outputs
+// can be printed double-sided. Images are scaled onto the pages, inside
+// Config.Margin, with Fit; Config.Background fills the letterbox.
+type PageSize struct {
+	Paper  string  `json:"paper,omitempty"`  // One of the Paper constants; used unless Width and Height are set
+	Width  float64 `json:"width,omitempty"`  // Custom page width in points (1/72 inch)
+	Height float64 `json:"height,omitempty"` // Custom page height in points
+	Fit    string  `json:"fit,omitempty"`    // One of the Fit constants; default FitContain
+}
+
+func (cfg *Config) validatePageSize() error {
+	p := cfg.PageSize
+	switch {
+	case p.Width != 0 || p.Height != 0:
+		if p.Width <= 0 || p.Height <= 0 || p.Paper != "" {
+			return fmt.Errorf("%w: a custom size needs a positive width and height, and no paper", ErrInvalidPageSize)
+		}
+	case paperSizes[p.Paper] == gofpdf.SizeType{}:
+		return fmt.Errorf("%w: unknown paper %q (expected %q, %q, %q, %q or %q, or a width and height)", ErrInvalidPageSize, p.Paper, PaperA4, PaperA5, PaperA3, PaperLetter, PaperLegal)
+	}
+	switch p.Fit {
+	case "", FitContain, FitCover, FitStretch:
+	default:
+		return fmt.Errorf("%w: unknown fit %q (expected %q, %q or %q)", ErrInvalidPageSize, p.Fit, FitContain, FitCover, FitStretch)
+	}
+	switch {
+	case cfg.OutputFormat == FormatEPUB:
+		return fmt.Errorf("%w: fixed page sizes are only supported for PDF output", ErrInvalidPageSize)
+	case cfg.PrintLayout != nil:
+		return fmt.Errorf("%w: a print layout sets the size of its sheets itself", ErrInvalidPageSize)
+	}
+	return nil
+}
+
+func (cfg *Config) validateFrame() error {
+	if _, _, _, err := parseHexColour(cfg.Background); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBackground, err)
+	}
+	if cfg.Margin == 0 && cfg.Background == "" {
+		return nil
+	}
+	switch {
+	case cfg.Margin < 0:
+		return fmt.Errorf("%w %g (expected 0 or more points)", ErrInvalidMargin, cfg.Margin)
+	case cfg.OutputFormat == FormatEPUB:
+		return fmt.Errorf("%w: margins and backgrounds are only supported for PDF output", ErrInvalidMargin)
+	case cfg.PrintLayout != nil:
+		return fmt.Errorf("%w: a print layout sets the margins of its sheets itself", ErrInvalidMargin)
+	case cfg.PageSize != nil:
+		if page := cfg.PageSize.size(); 2*cfg.Margin >= min(page.Wd, page.Ht) {
+			return fmt.Errorf("%w %g: no room left on a %gx%g page", ErrInvalidMargin, cfg.Margin, page.Wd, page.Ht)
+		}
+	}
+	return nil
+}
+
+// size returns the page size in points.
+func (p *PageSize) size() gofpdf.SizeType {
+	if p.Width > 0 {
+		return gofpdf.SizeType{Wd: p.Width, Ht: p.Height}
+	}
+	return paperSizes[p.Paper]
+}
+
+// place returns where an image of the given size goes in a box of the
+// given size: scaled with Fit and centred. With FitCover the image may
+// extend past the box.
+func (p *PageSize) place(box gofpdf.SizeType, width, height float64) (x, y, w, h float64) {
+	switch p.Fit {
+	case FitStretch:
+		return 0, 0, box.Wd, box.Ht
+	case FitCover:
+		scale := max(box.Wd/width, box.Ht/height)
+		w, h = width*scale, height*scale
+	default:
+		scale := min(box.Wd/width, box.Ht/height)
+		w, h = width*scale, height*scale
+	}
+	return (box.Wd - w) / 2, (box.Ht - h) / 2, w, h
+}
+
+// pageFrames adds a page per image to a PDF: the size of the image, or
+// cfg.PageSize, with the image inset by cfg.Margin on a page filled with
+// cfg.Background.
+type pageFrames struct {
+	pdf        *gofpdf.Fpdf
+	size       *PageSize
+	margin     float64
+	background bool
+	r, g, b    int
+}
+
+func newPageFrames(pdf *gofpdf.Fpdf, cfg *Config) *pageFrames {
+	f := &pageFrames{pdf: pdf, size: cfg.PageSize, margin: cfg.Margin, background: cfg.Background != ""}
+	f.r, f.g, f.b, _ = parseHexColour(cfg.Background)
+	return f
+}
+
+// add starts the page for an image laid out at the given size and returns
+// the page size and where the image goes on it. With FitCover, what
+// extends past the margins is cut off at them.
+func (f *pageFrames) add(width, height float64) (page gofpdf.SizeType, x, y, w, h float64) {
+	x, y, w, h = f.margin, f.margin, width, height
+	page = gofpdf.SizeType{Wd: width + 2*f.margin, Ht: height + 2*f.margin}
+	if f.size != nil {
+		page = f.size.size()
+		box := gofpdf.SizeType{Wd: page.Wd - 2*f.margin, Ht: page.Ht - 2*f.margin}
+		x, y, w, h = f.size.place(box, width, height)
+		x, y = x+f.margin, y+f.margin
+	}
+	f.pdf.AddPageFormat("P", page)
+	if f.background {
+		f.pdf.SetFillColor(f.r, f.g, f.b)
+		f.pdf.Rect(0, 0, page.Wd, page.Ht, "F")
+	}
+	return page, x, y, w, h
+}
+
+// clip clips what is drawn next to the margins of page if an image placed
+// by add at w x h extends past them, and reports whether it did; the
+// caller ends the clip with ClipEnd once the image is drawn.
+func (f *pageFrames) clip(page gofpdf.SizeType, w, h float64) bool {
+	box := gofpdf.SizeType{Wd: page.Wd - 2*f.margin, Ht: page.Ht - 2*f.margin}
+	if w <= box.Wd+0.01 && h <= box.Ht+0.01 {
+		return false
+	}
+	f.pdf.ClipRect(f.margin, f.margin, box.Wd, box.Ht, false)
+	return true
+}
+
+// parseHexColour parses an "#rrggbb" colour. "" is black.
+func parseHexColour(s string) (r, g, b int, err error) {
+	if s == "" {
+		return 0, 0, 0, nil
+	}
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("colour %q is not #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("colour %q is not #rrggbb", s)
+	}
+	return int(v >> 16), int(v >> 8 & 0xff), int(v & 0xff), nil
+}
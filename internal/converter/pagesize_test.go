@@ -0,0 +1,110 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+func TestPageSize_Place(t *testing.T) {
+	for _, tc := range []struct {
+		fit        string
+		x, y, w, h float64
+	}{
+		{FitContain, 0, 100, 400, 400},
+		{"", 0, 100, 400, 400},
+		{FitCover, -100, 0, 600, 600},
+		{FitStretch, 0, 0, 400, 600},
+	} {
+		size := &PageSize{Fit: tc.fit}
+		x, y, w, h := size.place(gofpdf.SizeType{Wd: 400, Ht: 600}, 1000, 1000)
+		if x != tc.x || y != tc.y || w != tc.w || h != tc.h {
+			t.Errorf("%q: expected %v,%v %vx%v, got %v,%v %vx%v", tc.fit, tc.x, tc.y, tc.w, tc.h, x, y, w, h)
+		}
+	}
+}
+
+func TestConvertToPDF_PageSize(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.PageSize = &PageSize{Paper: PaperA5}
+	cfg.Background = "#000000"
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 60, 90, 0), pngSource(t, 200, 80, 1)}, cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ReadPDF(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.NumPages(); got != 2 {
+		t.Fatalf("Expected 2 pages, got %d", got)
+	}
+	if n := bytes.Count(out.Bytes(), []byte("/MediaBox [0 0 419.53 595.28]")); n != 2 {
+		t.Errorf("Expected both pages A5, found %d", n)
+	}
+
+	for _, size := range []*PageSize{
+		{Paper: "b5"},
+		{Width: 400},
+		{Width: 400, Height: 600, Paper: PaperA4},
+		{Paper: PaperA4, Fit: "fill"},
+	} {
+		cfg.PageSize = size
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidPageSize) {
+			t.Errorf("Expected ErrInvalidPageSize for %+v, got %v", size, err)
+		}
+	}
+	cfg.PageSize = &PageSize{Paper: PaperA4}
+	cfg.Background = ""
+	cfg.PrintLayout = &PrintLayout{PagesPerSheet: 2}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidPageSize) {
+		t.Errorf("Expected ErrInvalidPageSize with a print layout, got %v", err)
+	}
+}
+
+func TestConvertToPDF_MarginAndBackground(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Margin = 20
+	cfg.Background = "#102030"
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 60, 90, 0)}, cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("/MediaBox [0 0 100.00 130.00]")) {
+		t.Error("Expected the page to grow by the margin on each side")
+	}
+
+	// Covering images are clipped to the margins of a fixed-size page.
+	cfg.PageSize = &PageSize{Width: 200, Height: 200, Fit: FitCover}
+	out.Reset()
+	if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 60, 90, 0)}, cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := ReadPDF(out.Bytes()); err != nil || doc.NumPages() != 1 {
+		t.Fatalf("Expected a readable one-page PDF, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		margin     float64
+		background string
+		err        error
+	}{
+		{-1, "", ErrInvalidMargin},
+		{100, "", ErrInvalidMargin}, // No room left on the 200x200 page
+		{0, "navy", ErrInvalidBackground},
+		{0, "#12345", ErrInvalidBackground},
+	} {
+		cfg.Margin, cfg.Background = tc.margin, tc.background
+		if err := cfg.Validate(); !errors.Is(err, tc.err) {
+			t.Errorf("Margin %g, background %q: expected %v, got %v", tc.margin, tc.background, tc.err, err)
+		}
+	}
+	cfg.PageSize, cfg.Margin, cfg.Background = nil, 10, ""
+	cfg.OutputFormat = FormatEPUB
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidMargin) {
+		t.Errorf("Expected ErrInvalidMargin for EPUB output, got %v", err)
+	}
+}
@@ -0,0 +1,544 @@
+package converter
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	_ "image/jpeg" // Decoders for PageImage
+	_ "image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+// ErrUnreadablePDF is returned by ReadPDF and PDFDocument.PageImage for PDFs
+// that do not have the structure this package writes.
+var ErrUnreadablePDF = errors.New("unreadable PDF")
+
+// PDFDocument is a PDF written by this package (or another gofpdf-based
+// tool), opened to read back its pages and metadata. It understands
+// uncompressed cross-reference tables, including incremental updates, and
+// page images stored as JPEG or as PNG-predicted Flate data; it is not a
+// general PDF parser.
+type PDFDocument struct {
+	data    []byte
+	offsets map[int]int // Object number to offset of its latest definition
+	pages   []int       // Page object numbers in reading order
+	Info    map[string]string
+}
+
+var (
+	pdfRefRE      = regexp.MustCompile(`^\s*(\d+) 0 R`)
+	pdfIntRE      = regexp.MustCompile(`^\s*(\d+)`)
+	pdfNameRE     = regexp.MustCompile(`^\s*/([^\s/<>\[\]()]+)`)
+	pdfRefListRE  = regexp.MustCompile(`(\d+) 0 R`)
+	pdfNamedRefRE = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+) 0 R`)
+	pdfDoRE       = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+Do\b`)
+)
+
+// ReadPDF opens data as a PDF and lists its pages.
+func ReadPDF(data []byte) (*PDFDocument, error) {
+	doc := &PDFDocument{data: data, offsets: map[int]int{}, Info: map[string]string{}}
+	xref, _, _, err := lastStartXref(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreadablePDF, err)
+	}
+	var root, info int
+	for seen := map[int]bool{}; !seen[xref]; {
+		seen[xref] = true
+		trailer, err := doc.readXrefSection(xref)
+		if err != nil {
+			return nil, err
+		}
+		if root == 0 {
+			root, _ = dictRef(trailer, "Root")
+			info, _ = dictRef(trailer, "Info")
+		}
+		prev, ok := dictInt(trailer, "Prev")
+		if !ok {
+			break
+		}
+		xref = prev
+	}
+
+	catalog, _, err := doc.object(root)
+	if err != nil {
+		return nil, err
+	}
+	pagesRoot, ok := dictRef(catalog, "Pages")
+	if !ok {
+		return nil, fmt.Errorf("%w: catalog has no /Pages", ErrUnreadablePDF)
+	}
+	if err := doc.collectPages(pagesRoot, 0); err != nil {
+		return nil, err
+	}
+	if info != 0 {
+		if dict, _, err := doc.object(info); err == nil {
+			for _, key := range []string{"Title", "Author", "Subject", "Keywords", "Creator", "Producer"} {
+				if value, ok := dictString(dict, key); ok {
+					doc.Info[key] = value
+				}
+			}
+		}
+	}
+	return doc, nil
+}
+
+// NumPages returns the number of pages.
+func (doc *PDFDocument) NumPages() int {
+	return len(doc.pages)
+}
+
+// PageImage decodes the largest image drawn on page i (0-based), which for
+// the PDFs this package writes is the page itself. Soft masks are ignored.
+func (doc *PDFDocument) PageImage(i int) (image.Image, error) {
+	data, _, err := doc.PageImageData(i)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// PageImageData returns the largest image drawn on page i (0-based) as an
+// image file, with its content type: a JPEG as it is stored, or a PNG
+// rebuilt around the stored data, so the page can be embedded again
+// without re-encoding. Soft masks are ignored.
+func (doc *PDFDocument) PageImageData(i int) (data []byte, contentType string, err error) {
+	if i < 0 || i >= len(doc.pages) {
+		return nil, "", fmt.Errorf("page %d out of range", i+1)
+	}
+	page, _, err := doc.object(doc.pages[i])
+	if err != nil {
+		return nil, "", err
+	}
+	resources, err := doc.dictOrRef(page, "Resources")
+	if err != nil {
+		return nil, "", err
+	}
+	xobjects, err := doc.dictOrRef(resources, "XObject")
+	if err != nil {
+		return nil, "", err
+	}
+	names := map[string]int{}
+	for _, m := range pdfNamedRefRE.FindAllSubmatch(xobjects, -1) {
+		num, _ := strconv.Atoi(string(m[2]))
+		names[string(m[1])] = num
+	}
+
+	var content []byte
+	for _, num := range doc.contentRefs(page) {
+		dict, stream, err := doc.object(num)
+		if err != nil {
+			return nil, "", err
+		}
+		if stream, err = decodeStream(dict, stream); err != nil {
+			return nil, "", err
+		}
+		content = append(append(content, stream...), '\n')
+	}
+	best, bestPixels := 0, -1
+	for _, m := range pdfDoRE.FindAllSubmatch(content, -1) {
+		num, ok := names[string(m[1])]
+		if !ok {
+			continue
+		}
+		dict, _, err := doc.object(num)
+		if err != nil {
+			return nil, "", err
+		}
+		w, _ := dictInt(dict, "Width")
+		h, _ := dictInt(dict, "Height")
+		if w*h > bestPixels {
+			best, bestPixels = num, w*h
+		}
+	}
+	if bestPixels < 0 {
+		return nil, "", fmt.Errorf("%w: page %d has no image", ErrUnreadablePDF, i+1)
+	}
+	return doc.imageData(best)
+}
+
+func (doc *PDFDocument) readXrefSection(offset int) (trailer []byte, err error) {
+	if offset < 0 || offset >= len(doc.data) || !bytes.HasPrefix(doc.data[offset:], []byte("xref")) {
+		return nil, fmt.Errorf("%w: no xref table at %d (cross-reference streams are not supported)", ErrUnreadablePDF, offset)
+	}
+	rest := doc.data[offset+len("xref"):]
+	for {
+		rest = bytes.TrimLeft(rest, " \r\n")
+		if bytes.HasPrefix(rest, []byte("trailer")) {
+			end := bytes.Index(rest, []byte("startxref"))
+			if end < 0 {
+				end = len(rest)
+			}
+			return rest[len("trailer"):end], nil
+		}
+		var first, count int
+		if _, err := fmt.Sscanf(string(rest[:bytes.IndexByte(rest, '\n')+1]), "%d %d", &first, &count); err != nil {
+			return nil, fmt.Errorf("%w: bad xref subsection: %w", ErrUnreadablePDF, err)
+		}
+		rest = rest[bytes.IndexByte(rest, '\n')+1:]
+		for i := 0; i < count; i++ {
+			if len(rest) < 18 {
+				return nil, fmt.Errorf("%w: truncated xref table", ErrUnreadablePDF)
+			}
+			entry := rest[:18]
+			rest = bytes.TrimLeft(rest[18:], " \r\n")
+			if entry[17] != 'n' {
+				continue
+			}
+			if _, seen := doc.offsets[first+i]; !seen { // Later sections are read first
+				at, _ := strconv.Atoi(string(entry[:10]))
+				doc.offsets[first+i] = at
+			}
+		}
+	}
+}
+
+// object returns the dictionary of object num and, if it has one, its raw
+// stream.
+func (doc *PDFDocument) object(num int) (dict, stream []byte, err error) {
+	offset, ok := doc.offsets[num]
+	if !ok || offset >= len(doc.data) {
+		return nil, nil, fmt.Errorf("%w: object %d not found", ErrUnreadablePDF, num)
+	}
+	header := fmt.Sprintf("%d 0 obj", num)
+	body := doc.data[offset:]
+	if !bytes.HasPrefix(body, []byte(header)) {
+		return nil, nil, fmt.Errorf("%w: object %d not at its xref offset", ErrUnreadablePDF, num)
+	}
+	body = bytes.TrimLeft(body[len(header):], " \r\n")
+	end := dictEnd(body)
+	if end < 0 {
+		return nil, nil, fmt.Errorf("%w: object %d is not a dictionary", ErrUnreadablePDF, num)
+	}
+	dict, body = body[:end], body[end:]
+	body = bytes.TrimLeft(body, " \r\n")
+	if !bytes.HasPrefix(body, []byte("stream")) {
+		return dict, nil, nil
+	}
+	body = body[len("stream"):]
+	body = bytes.TrimPrefix(bytes.TrimPrefix(body, []byte("\r")), []byte("\n"))
+	length, ok := dictInt(dict, "Length")
+	if !ok || length > len(body) {
+		if length = bytes.Index(body, []byte("endstream")); length < 0 {
+			return nil, nil, fmt.Errorf("%w: object %d has an unterminated stream", ErrUnreadablePDF, num)
+		}
+	}
+	return dict, body[:length], nil
+}
+
+func (doc *PDFDocument) collectPages(num, depth int) error {
+	if depth > 32 {
+		return fmt.Errorf("%w: page tree too deep", ErrUnreadablePDF)
+	}
+	dict, _, err := doc.object(num)
+	if err != nil {
+		return err
+	}
+	if name, _ := dictName(dict, "Type"); name == "Page" {
+		doc.pages = append(doc.pages, num)
+		return nil
+	}
+	start := bytes.Index(dict, []byte("/Kids"))
+	if start < 0 {
+		return fmt.Errorf("%w: page tree node %d has no /Kids", ErrUnreadablePDF, num)
+	}
+	kids := dict[start:]
+	kids = kids[:bytes.IndexByte(kids, ']')+1]
+	for _, m := range pdfRefListRE.FindAllSubmatch(kids, -1) {
+		kid, _ := strconv.Atoi(string(m[1]))
+		if err := doc.collectPages(kid, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dictOrRef returns the dictionary value of key in dict, following an
+// indirect reference.
+func (doc *PDFDocument) dictOrRef(dict []byte, key string) ([]byte, error) {
+	if num, ok := dictRef(dict, key); ok {
+		value, _, err := doc.object(num)
+		return value, err
+	}
+	value := dictValue(dict, key)
+	if end := dictEnd(value); end >= 0 {
+		return value[:end], nil
+	}
+	return nil, fmt.Errorf("%w: no /%s dictionary", ErrUnreadablePDF, key)
+}
+
+func (doc *PDFDocument) contentRefs(page []byte) []int {
+	if num, ok := dictRef(page, "Contents"); ok {
+		return []int{num}
+	}
+	value := dictValue(page, "Contents")
+	if !bytes.HasPrefix(value, []byte("[")) {
+		return nil
+	}
+	var refs []int
+	for _, m := range pdfRefListRE.FindAllSubmatch(value[:bytes.IndexByte(value, ']')+1], -1) {
+		num, _ := strconv.Atoi(string(m[1]))
+		refs = append(refs, num)
+	}
+	return refs
+}
+
+func (doc *PDFDocument) imageData(num int) ([]byte, string, error) {
+	dict, stream, err := doc.object(num)
+	if err != nil {
+		return nil, "", err
+	}
+	filter, _ := dictName(dict, "Filter")
+	switch filter {
+	case "DCTDecode":
+		return stream, "image/jpeg", nil
+	case "FlateDecode":
+	default:
+		return nil, "", fmt.Errorf("%w: image filter %q is not supported", ErrUnreadablePDF, filter)
+	}
+	if !bytes.Contains(dict, []byte("/Predictor 15")) {
+		return nil, "", fmt.Errorf("%w: Flate image without PNG predictors", ErrUnreadablePDF)
+	}
+
+	// gofpdf keeps a PNG's IDAT data as is, so wrapping it in PNG chunks
+	// again gives a decodable PNG.
+	width, _ := dictInt(dict, "Width")
+	height, _ := dictInt(dict, "Height")
+	bpc, _ := dictInt(dict, "BitsPerComponent")
+	var colorType byte
+	var palette []byte
+	colorSpace := dictValue(dict, "ColorSpace")
+	switch {
+	case bytes.HasPrefix(colorSpace, []byte("/DeviceGray")):
+		colorType = 0
+	case bytes.HasPrefix(colorSpace, []byte("/DeviceRGB")):
+		colorType = 2
+	case bytes.HasPrefix(colorSpace, []byte("[/Indexed")):
+		colorType = 3
+		refs := pdfRefListRE.FindSubmatch(colorSpace)
+		if refs == nil {
+			return nil, "", fmt.Errorf("%w: indexed image without a palette object", ErrUnreadablePDF)
+		}
+		palNum, _ := strconv.Atoi(string(refs[1]))
+		palDict, palStream, err := doc.object(palNum)
+		if err != nil {
+			return nil, "", err
+		}
+		if palette, err = decodeStream(palDict, palStream); err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", fmt.Errorf("%w: color space %.20q is not supported", ErrUnreadablePDF, colorSpace)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = byte(bpc), colorType
+	writePNGChunk(&buf, "IHDR", ihdr)
+	if palette != nil {
+		writePNGChunk(&buf, "PLTE", palette)
+	}
+	writePNGChunk(&buf, "IDAT", stream)
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes(), "image/png", nil
+}
+
+func writePNGChunk(w *bytes.Buffer, kind string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	w.Write(n[:])
+}
+
+// decodeStream undoes a stream's /FlateDecode filter; other streams are
+// returned as they are.
+func decodeStream(dict, stream []byte) ([]byte, error) {
+	if filter, _ := dictName(dict, "Filter"); filter != "FlateDecode" {
+		return stream, nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreadablePDF, err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// dictEnd returns the length of the dictionary at the start of b, or -1.
+func dictEnd(b []byte) int {
+	if !bytes.HasPrefix(b, []byte("<<")) {
+		return -1
+	}
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		switch {
+		case b[i] == '(':
+			i = literalStringEnd(b, i) - 1
+		case b[i] == '<' && i+1 < len(b) && b[i+1] == '<':
+			depth++
+			i++
+		case b[i] == '>' && i+1 < len(b) && b[i+1] == '>':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// literalStringEnd returns the offset just past the literal string that
+// starts at b[start] == '('.
+func literalStringEnd(b []byte, start int) int {
+	depth := 0
+	for i := start; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(b)
+}
+
+// dictValue returns what follows /key in dict (up to the end of dict). It
+// does not tell nested dictionaries apart, which is enough for the flat
+// dictionaries gofpdf writes.
+func dictValue(dict []byte, key string) []byte {
+	needle := []byte("/" + key)
+	for from := 0; ; {
+		i := bytes.Index(dict[from:], needle)
+		if i < 0 {
+			return nil
+		}
+		end := from + i + len(needle)
+		if end == len(dict) || bytes.IndexByte([]byte(" \r\n/<[("), dict[end]) >= 0 {
+			return bytes.TrimLeft(dict[end:], " \r\n")
+		}
+		from = end
+	}
+}
+
+func dictRef(dict []byte, key string) (int, bool) {
+	m := pdfRefRE.FindSubmatch(dictValue(dict, key))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	return n, err == nil
+}
+
+func dictInt(dict []byte, key string) (int, bool) {
+	m := pdfIntRE.FindSubmatch(dictValue(dict, key))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	return n, err == nil
+}
+
+func dictName(dict []byte, key string) (string, bool) {
+	m := pdfNameRE.FindSubmatch(dictValue(dict, key))
+	if m == nil {
+		return "", false
+	}
+	return string(m[1]), true
+}
+
+// dictString decodes the literal or hex string value of key, as UTF-16 when
+// it starts with a byte order mark and byte for byte otherwise.
+func dictString(dict []byte, key string) (string, bool) {
+	value := dictValue(dict, key)
+	var raw []byte
+	switch {
+	case bytes.HasPrefix(value, []byte("(")):
+		end := literalStringEnd(value, 0)
+		raw = unescapePDFString(value[1 : end-1])
+	case bytes.HasPrefix(value, []byte("<")) && !bytes.HasPrefix(value, []byte("<<")):
+		end := bytes.IndexByte(value, '>')
+		if end < 0 {
+			return "", false
+		}
+		hex := bytes.Join(bytes.Fields(value[1:end]), nil)
+		if len(hex)%2 == 1 {
+			hex = append(hex, '0')
+		}
+		for i := 0; i+1 < len(hex); i += 2 {
+			b, err := strconv.ParseUint(string(hex[i:i+2]), 16, 8)
+			if err != nil {
+				return "", false
+			}
+			raw = append(raw, byte(b))
+		}
+	default:
+		return "", false
+	}
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units)), true
+	}
+	return string(raw), true
+}
+
+func unescapePDFString(s []byte) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r', '\n': // Line continuation
+		default:
+			if c >= '0' && c <= '7' {
+				n := 0
+				for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+					n = n*8 + int(s[i]-'0')
+					i++
+				}
+				i--
+				out = append(out, byte(n))
+			} else {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func gradientImage(w, h int, flip bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if flip {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, uint8(y * 255 / h), 128, 255})
+		}
+	}
+	return img
+}
+
+func TestReadPDF_RoundTrip(t *testing.T) {
+	var pngData, jpegData, grayData bytes.Buffer
+	if err := png.Encode(&pngData, gradientImage(40, 30, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, gradientImage(40, 30, true), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&grayData, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	var palettedData bytes.Buffer
+	paletted := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	paletted.SetColorIndex(1, 1, 1)
+	if err := png.Encode(&palettedData, paletted); err != nil {
+		t.Fatal(err)
+	}
+	cfg := NewDefaultConfig()
+	cfg.Title = "Vol. 1 (special)"
+	cfg.Author = "作者"
+	cfg.Tagged = true // Reading must follow the incremental update
+	var out bytes.Buffer
+	sources := []ImageSource{
+		newStringImageSource("a.png", pngData.String(), "image/png", 0),
+		newStringImageSource("b.jpg", jpegData.String(), "image/jpeg", 1),
+		newStringImageSource("c.png", grayData.String(), "image/png", 2),
+		newStringImageSource("d.png", palettedData.String(), "image/png", 3),
+	}
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+
+	doc, err := ReadPDF(out.Bytes())
+	if err != nil {
+		t.Fatalf("ReadPDF failed: %v", err)
+	}
+	if doc.NumPages() != 4 {
+		t.Fatalf("Expected 4 pages, got %d", doc.NumPages())
+	}
+	if doc.Info["Title"] != cfg.Title || doc.Info["Author"] != cfg.Author {
+		t.Errorf("Expected title and author to round-trip, got %v", doc.Info)
+	}
+	for i, want := range []image.Image{gradientImage(40, 30, false), gradientImage(40, 30, true)} {
+		img, err := doc.PageImage(i)
+		if err != nil {
+			t.Fatalf("PageImage(%d) failed: %v", i, err)
+		}
+		if img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 {
+			t.Errorf("Page %d: expected 40x30, got %v", i+1, img.Bounds())
+		}
+		if d := HashDistance(PerceptualHash(img), PerceptualHash(want)); d > 4 {
+			t.Errorf("Page %d: perceptual hash differs by %d bits", i+1, d)
+		}
+	}
+	if _, err := doc.PageImage(2); err != nil {
+		t.Errorf("Expected the grayscale page to decode: %v", err)
+	}
+	if img, err := doc.PageImage(3); err != nil {
+		t.Errorf("Expected the paletted page to decode: %v", err)
+	} else if r, _, _, _ := img.At(1, 1).RGBA(); r != 0xffff {
+		t.Errorf("Expected the paletted page's white pixel, got %v", img.At(1, 1))
+	}
+	if HashDistance(PerceptualHash(gradientImage(40, 30, false)), PerceptualHash(gradientImage(40, 30, true))) < 20 {
+		t.Error("Expected mirrored gradients to hash far apart")
+	}
+
+	if _, err := ReadPDF([]byte("%PDF-1.3\nnot really")); err == nil {
+		t.Error("Expected an error for a malformed PDF")
+	}
+}
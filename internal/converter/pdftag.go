@@ -0,0 +1,192 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// taggedPage is what tagPDF needs to know about one PDF page.
+type taggedPage struct {
+	Alt    string // Alternate text for the page image
+	Figure bool   // The page content wraps its image in a /Figure marked-content sequence (MCID 0)
+}
+
+var (
+	trailerRootRE = regexp.MustCompile(`/Root (\d+) 0 R`)
+	trailerInfoRE = regexp.MustCompile(`/Info (\d+) 0 R`)
+	trailerSizeRE = regexp.MustCompile(`/Size (\d+)`)
+)
+
+// tagPDF makes a gofpdf document accessible: with figures it adds a
+// structure tree in which every page image is a /Figure with alternate text
+// and marks the document as tagged, and with lang it sets the document
+// language. gofpdf cannot write either, so they are appended to doc as an
+// incremental update (updated page and catalog objects, the new structure
+// objects and a cross-reference section chained to the original one),
+// which leaves the original bytes untouched. The catalog is written last,
+// so the update stays compatible with writeRightToLeft.
+func tagPDF(doc []byte, pages []taggedPage, lang string, figures bool) ([]byte, error) {
+	prevXref, _, _, err := lastStartXref(doc)
+	if err != nil {
+		return nil, err
+	}
+	trailer := doc[bytes.LastIndex(doc, []byte("trailer")):]
+	root, err1 := trailerRef(trailerRootRE, trailer)
+	info, err2 := trailerRef(trailerInfoRE, trailer)
+	size, err3 := trailerRef(trailerSizeRE, trailer)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("could not tag PDF: %w", err)
+	}
+	catalog, err := pdfObject(doc, root)
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(doc)+4096))
+	out.Write(doc)
+	if !bytes.HasSuffix(doc, []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	offsets := map[int]int{}
+	var order []int
+	writeObject := func(num int, body string) {
+		offsets[num] = out.Len()
+		order = append(order, num)
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", num, body)
+	}
+
+	catalogExtra := ""
+	if figures {
+		structRoot, document, parentTree := size, size+1, size+2
+		next := size + 3
+		var kids, nums bytes.Buffer
+		for i, page := range pages {
+			if !page.Figure {
+				continue
+			}
+			pageNum := 3 + 2*i // gofpdf numbers page objects 3, 5, 7, ...
+			body, err := pdfObject(doc, pageNum)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.HasPrefix(body, []byte("<</Type /Page\n")) {
+				return nil, fmt.Errorf("could not tag PDF: object %d is not page %d", pageNum, i+1)
+			}
+			writeObject(pageNum, fmt.Sprintf("<</Type /Page\n/StructParents %d\n%s", i, body[len("<</Type /Page\n"):]))
+			writeObject(next, fmt.Sprintf("<</Type /StructElem /S /Figure /P %d 0 R /Pg %d 0 R /Alt %s /K 0>>", document, pageNum, pdfTextString(page.Alt)))
+			fmt.Fprintf(&kids, " %d 0 R", next)
+			fmt.Fprintf(&nums, " %d [%d 0 R]", i, next)
+			next++
+		}
+		writeObject(structRoot, fmt.Sprintf("<</Type /StructTreeRoot /K %d 0 R /ParentTree %d 0 R /ParentTreeNextKey %d>>", document, parentTree, len(pages)))
+		writeObject(document, fmt.Sprintf("<</Type /StructElem /S /Document /P %d 0 R /K [%s]>>", structRoot, bytes.TrimSpace(kids.Bytes())))
+		writeObject(parentTree, fmt.Sprintf("<</Nums [%s]>>", bytes.TrimSpace(nums.Bytes())))
+		size = next
+		catalogExtra += fmt.Sprintf("\n/MarkInfo <</Marked true>>\n/StructTreeRoot %d 0 R", structRoot)
+	}
+	if lang != "" {
+		catalogExtra += "\n/Lang " + pdfTextString(lang)
+	}
+	insertAt := bytes.Index(catalog, []byte("/Type /Catalog"))
+	if insertAt < 0 {
+		return nil, errors.New("could not tag PDF: PDF catalog not found")
+	}
+	insertAt += len("/Type /Catalog")
+	writeObject(root, string(catalog[:insertAt])+catalogExtra+string(catalog[insertAt:]))
+
+	xref := out.Len()
+	out.WriteString("xref\n")
+	for _, num := range order {
+		fmt.Fprintf(out, "%d 1\n%010d 00000 n \n", num, offsets[num])
+	}
+	fmt.Fprintf(out, "trailer\n<<\n/Size %d\n/Root %d 0 R\n/Info %d 0 R\n/Prev %d\n>>\nstartxref\n%d\n%%%%EOF\n", size, root, info, prevXref, xref)
+	return out.Bytes(), nil
+}
+
+// writePatchedPDF writes the finished PDF doc to w with the provenance,
+// tags, language and reading direction from cfg added. tags is nil unless
+// cfg.Tagged, provenance unless cfg.Provenance.
+func writePatchedPDF(w io.Writer, doc []byte, tags *[]taggedPage, provenance *pageProvenance, cfg *Config) error {
+	if provenance != nil {
+		var err error
+		if doc, err = addPieceInfo(doc, provenance, time.Now()); err != nil {
+			return err
+		}
+	}
+	if tags != nil || cfg.Language != "" {
+		var pages []taggedPage
+		if tags != nil {
+			pages = *tags
+		}
+		var err error
+		if doc, err = tagPDF(doc, pages, cfg.Language, tags != nil); err != nil {
+			return err
+		}
+	}
+	if cfg.RightToLeft {
+		return writeRightToLeft(w, doc)
+	}
+	_, err := w.Write(doc)
+	return err
+}
+
+// lastStartXref returns the offset recorded after the last "startxref" of
+// doc, and where that number starts and ends.
+func lastStartXref(doc []byte) (offset, numStart, numEnd int, err error) {
+	startxref := bytes.LastIndex(doc, []byte("startxref\n"))
+	if startxref < 0 {
+		return 0, 0, 0, errors.New("malformed PDF: startxref not found")
+	}
+	numStart = startxref + len("startxref\n")
+	numEnd = numStart + bytes.IndexByte(doc[numStart:], '\n')
+	if numEnd < numStart {
+		return 0, 0, 0, errors.New("malformed PDF: startxref not terminated")
+	}
+	offset, err = strconv.Atoi(string(doc[numStart:numEnd]))
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed PDF startxref: %w", err)
+	}
+	return offset, numStart, numEnd, nil
+}
+
+func trailerRef(re *regexp.Regexp, trailer []byte) (int, error) {
+	m := re.FindSubmatch(trailer)
+	if m == nil {
+		return 0, fmt.Errorf("trailer has no %s", re.String())
+	}
+	return strconv.Atoi(string(m[1]))
+}
+
+// pdfObject returns the body of the last definition of object num in doc,
+// between its "num 0 obj" line and "endobj".
+func pdfObject(doc []byte, num int) ([]byte, error) {
+	header := []byte(fmt.Sprintf("\n%d 0 obj\n", num))
+	start := bytes.LastIndex(doc, header)
+	if start < 0 {
+		return nil, fmt.Errorf("malformed PDF: object %d not found", num)
+	}
+	start += len(header)
+	end := bytes.Index(doc[start:], []byte("\nendobj"))
+	if end < 0 {
+		return nil, fmt.Errorf("malformed PDF: object %d not terminated", num)
+	}
+	return doc[start : start+end], nil
+}
+
+// pdfTextString encodes s as a UTF-16BE PDF hex string, which can hold any
+// text without escaping.
+func pdfTextString(s string) string {
+	var b bytes.Buffer
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var xrefSubsectionRE = regexp.MustCompile(`(?m)^(\d+) 1\n(\d{10}) 00000 n `)
+
+func TestConvertToPDF_Tagged(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Tagged = true
+	cfg.Language = "ja"
+	cfg.RightToLeft = true
+	sources := []ImageSource{pngSource(t, 10, 10, 0), pngSource(t, 10, 10, 1)}
+	sources[1].OriginalFilename = "chapter 1/002.png"
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	doc := out.Bytes()
+
+	root, _ := trailerRef(trailerRootRE, doc[bytes.LastIndex(doc, []byte("trailer")):])
+	catalog, err := pdfObject(doc, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"/ViewerPreferences << /Direction /R2L >>", "/MarkInfo <</Marked true>>", "/StructTreeRoot ", "/Lang " + pdfTextString("ja")} {
+		if !bytes.Contains(catalog, []byte(want)) {
+			t.Errorf("Expected %q in the catalog:\n%s", want, catalog)
+		}
+	}
+	if !bytes.Contains(doc, []byte("/S /Figure /P")) || !bytes.Contains(doc, []byte("/Alt "+pdfTextString("Page 2: 002.png"))) {
+		t.Error("Expected a figure with alternate text for every page")
+	}
+	for i, num := range []int{3, 5} {
+		page, err := pdfObject(doc, num)
+		if err != nil || !bytes.Contains(page, []byte(fmt.Sprintf("/StructParents %d\n", i))) {
+			t.Errorf("Expected page object %d to reference the parent tree, got %s (%v)", num, page, err)
+		}
+	}
+
+	// The update's cross-reference section must point at its objects and
+	// chain to the original one.
+	startxref, _, _, err := lastStartXref(doc)
+	if err != nil || !bytes.HasPrefix(doc[startxref:], []byte("xref")) {
+		t.Fatalf("startxref does not point at the xref section: %v", err)
+	}
+	entries := xrefSubsectionRE.FindAllSubmatch(doc[startxref:], -1)
+	if len(entries) == 0 {
+		t.Fatal("Expected entries in the update's xref section")
+	}
+	for _, m := range entries {
+		offset, _ := strconv.Atoi(string(m[2]))
+		if want := string(m[1]) + " 0 obj"; !bytes.HasPrefix(doc[offset:], []byte(want)) {
+			t.Errorf("xref entry %s points at %q", m[1], doc[offset:min(offset+12, len(doc))])
+		}
+	}
+	prev := regexp.MustCompile(`/Prev (\d+)`).FindSubmatch(doc[startxref:])
+	if prev == nil {
+		t.Fatal("Expected the update trailer to chain to the original xref")
+	}
+	if offset, _ := strconv.Atoi(string(prev[1])); !bytes.HasPrefix(doc[offset:], []byte("xref")) {
+		t.Errorf("/Prev %d does not point at the original xref table", offset)
+	}
+}
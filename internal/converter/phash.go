@@ -0,0 +1,37 @@
+package converter
+
+import (
+	"image"
+	"math/bits"
+
+	"github.com/disintegration/imaging"
+)
+
+// PerceptualHash returns a 64-bit difference hash (dHash) of img: the image
+// is shrunk to 9x8 grey pixels and each bit records whether a pixel is
+// brighter than its right neighbour. Re-encoding, resizing or small quality
+// changes leave most bits alone; compare hashes with HashDistance.
+func PerceptualHash(img image.Image) uint64 {
+	small := imaging.Resize(img, 9, 8, imaging.Box)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1 << (y*8 + x)
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance returns the number of differing bits between two
+// PerceptualHash values: 0 for the same picture, up to about 10 for
+// re-encoded copies of it.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func luminance(img *image.NRGBA, x, y int) int {
+	p := img.PixOffset(x, y)
+	return 299*int(img.Pix[p]) + 587*int(img.Pix[p+1]) + 114*int(img.Pix[p+2])
+}
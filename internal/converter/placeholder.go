@@ -0,0 +1,73 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// placeholderFontSize is the size, in points, of a placeholder's headline;
+// the error below it is set smaller.
+const placeholderFontSize = 16
+
+// fetchError is the error of a source whose ImageSource.FetchErr was set.
+type fetchError struct{ err error }
+
+func (e fetchError) Error() string { return e.err.Error() }
+func (e fetchError) Unwrap() error { return e.err }
+
+// isFetchFailure reports whether err is that of a source that could not be
+// fetched, rather than one that failed in the converter.
+func isFetchFailure(err error) bool {
+	var fe fetchError
+	return errors.As(err, &fe)
+}
+
+// placeholderPages draws the pages Config.Placeholders puts in place of
+// failed ones. A placeholder takes the layout size of the page before it,
+// or A4 before the first, so it blends in with its neighbours.
+type placeholderPages struct {
+	pdf           *gofpdf.Fpdf
+	translate     func(string) string
+	width, height float64
+}
+
+func newPlaceholderPages(pdf *gofpdf.Fpdf) *placeholderPages {
+	pdf.SetAutoPageBreak(false, 0) // Long errors must not spill onto a new page
+	pdf.SetMargins(0, 0, 0)
+	a4 := paperSizes[PaperA4]
+	return &placeholderPages{pdf: pdf, translate: pdf.UnicodeTranslatorFromDescriptor(""), width: a4.Wd, height: a4.Ht}
+}
+
+// follow makes the next placeholders the size of a page laid out at
+// width x height.
+func (p *placeholderPages) follow(width, height float64) {
+	p.width, p.height = width, height
+}
+
+// placeholderHeadline returns the text a placeholder for the page-th page,
+// which failed with err, opens with.
+func placeholderHeadline(page int, err error) string {
+	reason := "conversion error"
+	if isFetchFailure(err) {
+		reason = "fetch error"
+	}
+	return fmt.Sprintf("Page %d missing: %s", page, reason)
+}
+
+// draw frames the box at x, y, w, h on the current page and writes headline
+// and the error across its middle.
+func (p *placeholderPages) draw(headline string, err error, x, y, w, h float64) {
+	p.pdf.SetDrawColor(160, 160, 160)
+	p.pdf.SetLineWidth(1)
+	p.pdf.Rect(x, y, w, h, "D")
+
+	p.pdf.SetTextColor(64, 64, 64) // A watermark may have changed it
+	p.pdf.SetFont("Helvetica", "B", placeholderFontSize)
+	p.pdf.SetXY(x, y+h/2-2*placeholderFontSize)
+	p.pdf.MultiCell(w, placeholderFontSize*1.5, p.translate(headline), "", "C", false)
+	p.pdf.SetFont("Helvetica", "", placeholderFontSize*0.6)
+	p.pdf.SetX(x)
+	p.pdf.MultiCell(w, placeholderFontSize*0.9, p.translate(err.Error()), "", "C", false)
+}
@@ -0,0 +1,65 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConvertToPDF_Placeholders(t *testing.T) {
+	sources := func() []ImageSource {
+		return []ImageSource{
+			pngSource(t, 100, 200, 0),
+			{OriginalFilename: "broken.png", Reader: io.NopCloser(strings.NewReader("not a png")), ContentType: "image/png", Index: 1},
+			{OriginalFilename: "https://example.com/3.png", FetchErr: errors.New("404 Not Found"), Index: 2},
+			pngSource(t, 50, 50, 3),
+		}
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.Placeholders = true
+	var out bytes.Buffer
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources(), cfg, &out, &stats); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ReadPDF(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.NumPages() != 4 {
+		t.Errorf("Expected the failed pages kept as placeholders, got %d pages", doc.NumPages())
+	}
+	// Placeholders take the size of the page before them.
+	if n := bytes.Count(out.Bytes(), []byte("/MediaBox [0 0 100.00 200.00]")); n != 3 {
+		t.Errorf("Expected the first page and both placeholders at 100x200, got %d pages", n)
+	}
+	if stats.PagesAdded != 2 || stats.PagesFailed != 2 || stats.PagesPlaceholder != 2 {
+		t.Errorf("Expected 2 pages added, 2 failed and 2 placeholders, got %d, %d and %d", stats.PagesAdded, stats.PagesFailed, stats.PagesPlaceholder)
+	}
+	if len(stats.Failures) != 2 || stats.Failures[0].Fetch || !stats.Failures[1].Fetch {
+		t.Errorf("Expected only the second failure marked as a fetch failure, got %+v", stats.Failures)
+	}
+
+	// Without placeholders the failed pages are left out.
+	cfg.Placeholders = false
+	out.Reset()
+	if _, err := ConvertToPDF(context.Background(), sources(), cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := ReadPDF(out.Bytes()); err != nil || doc.NumPages() != 2 {
+		t.Fatalf("Expected a readable two-page PDF, got %v", err)
+	}
+}
+
+func TestPlaceholderHeadline(t *testing.T) {
+	if got := placeholderHeadline(47, fetchError{errors.New("timeout")}); got != "Page 47 missing: fetch error" {
+		t.Errorf("Unexpected headline for a fetch failure: %q", got)
+	}
+	if got := placeholderHeadline(3, errors.New("unexpected EOF")); got != "Page 3 missing: conversion error" {
+		t.Errorf("Unexpected headline for a conversion failure: %q", got)
+	}
+}
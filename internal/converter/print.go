@@ -0,0 +1,104 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Paper sizes for PrintLayout.Paper.
+const (
+	PaperA4     = "a4"
+	PaperA5     = "a5"
+	PaperA3     = "a3"
+	PaperLetter = "letter"
+	PaperLegal  = "legal"
+)
+
+// paperSizes are the portrait sizes of the papers, in points.
+var paperSizes = map[string]gofpdf.SizeType{
+	PaperA4:     {Wd: 595.28, Ht: 841.89},
+	PaperA5:     {Wd: 419.53, Ht: 595.28},
+	PaperA3:     {Wd: 841.89, Ht: 1190.55},
+	PaperLetter: {Wd: 612, Ht: 792},
+	PaperLegal:  {Wd: 612, Ht: 1008},
+}
+
+// printMargin is the blank border around a sheet and between its pages, in
+// points (a quarter inch), which keeps the pages clear of the area most
+// printers cannot print on.
+const printMargin = 18
+
+// ErrInvalidPrintLayout is returned for a print layout with an unsupported
+// number of pages per sheet or paper, or combined with settings it cannot
+// honour.
+var ErrInvalidPrintLayout = errors.New("invalid print layout")
+
+// PrintLayout lays pages out several to a sheet of paper, for reading
+// printed chapters: 2 pages side by side on landscape sheets, or 4 in a 2x2
+// grid on portrait sheets. With Config.RightToLeft each row of the grid is
+// filled from the right, as a manga is read.
+type PrintLayout struct {
+	PagesPerSheet int    `json:"pages_per_sheet"`
+	Paper         string `json:"paper,omitempty"` // One of the Paper constants; default PaperA4
+}
+
+func (cfg *Config) validatePrintLayout() error {
+	switch p := cfg.PrintLayout; {
+	case p.PagesPerSheet != 2 && p.PagesPerSheet != 4:
+		return fmt.Errorf("%w: %d pages per sheet (expected 2 or 4)", ErrInvalidPrintLayout, p.PagesPerSheet)
+	case p.Paper != "" && paperSizes[p.Paper] == gofpdf.SizeType{}:
+		return fmt.Errorf("%w: unknown paper %q (expected %q, %q, %q, %q or %q)", ErrInvalidPrintLayout, p.Paper, PaperA4, PaperA5, PaperA3, PaperLetter, PaperLegal)
+	case cfg.OutputFormat == FormatEPUB:
+		return fmt.Errorf("%w: print layouts are only supported for PDF output", ErrInvalidPrintLayout)
+	case cfg.Tagged || len(cfg.Captions) > 0 || cfg.Watermark != nil:
+		return fmt.Errorf("%w: tagged PDFs, captions and watermarks need one page per sheet", ErrInvalidPrintLayout)
+	}
+	return nil
+}
+
+// printSheets places pages on the sheets of a print layout, starting a new
+// sheet whenever the current one is full.
+type printSheets struct {
+	pdf        *gofpdf.Fpdf
+	size       gofpdf.SizeType
+	cols, rows int
+	rtl        bool
+	placed     int
+}
+
+func newPrintSheets(pdf *gofpdf.Fpdf, cfg *Config) *printSheets {
+	size := paperSizes[PaperA4]
+	if cfg.PrintLayout.Paper != "" {
+		size = paperSizes[cfg.PrintLayout.Paper]
+	}
+	s := &printSheets{pdf: pdf, size: size, cols: 2, rows: 2, rtl: cfg.RightToLeft}
+	if cfg.PrintLayout.PagesPerSheet == 2 {
+		s.size = gofpdf.SizeType{Wd: size.Ht, Ht: size.Wd} // Landscape
+		s.rows = 1
+	}
+	return s
+}
+
+// place returns where a page of the given size goes: the next cell of the
+// grid, with the page scaled to fit it and centred.
+func (s *printSheets) place(width, height float64) (x, y, w, h float64) {
+	cell := s.placed % (s.cols * s.rows)
+	if cell == 0 {
+		s.pdf.AddPageFormat("P", s.size)
+	}
+	s.placed++
+
+	col, row := cell%s.cols, cell/s.cols
+	if s.rtl {
+		col = s.cols - 1 - col
+	}
+	cellWidth := (s.size.Wd - printMargin*float64(s.cols+1)) / float64(s.cols)
+	cellHeight := (s.size.Ht - printMargin*float64(s.rows+1)) / float64(s.rows)
+	scale := min(cellWidth/width, cellHeight/height)
+	w, h = width*scale, height*scale
+	x = printMargin + float64(col)*(cellWidth+printMargin) + (cellWidth-w)/2
+	y = printMargin + float64(row)*(cellHeight+printMargin) + (cellHeight-h)/2
+	return x, y, w, h
+}
@@ -0,0 +1,62 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+func TestPrintSheets_Place(t *testing.T) {
+	for _, rtl := range []bool{false, true} {
+		pdf := gofpdf.New("P", "pt", "A4", "")
+		sheets := newPrintSheets(pdf, &Config{PrintLayout: &PrintLayout{PagesPerSheet: 2}, RightToLeft: rtl})
+		x1, _, w1, h1 := sheets.place(800, 1200)
+		x2, _, _, _ := sheets.place(800, 1200)
+		if got := pdf.PageNo(); got != 1 {
+			t.Fatalf("Expected two pages on one sheet, got %d sheets", got)
+		}
+		if (x1 < x2) == rtl {
+			t.Errorf("RTL %v: first page at x=%.0f, second at x=%.0f", rtl, x1, x2)
+		}
+		if w1/h1 != 800.0/1200.0 || h1 > 595.28-2*printMargin {
+			t.Errorf("Expected the page scaled to fit the landscape sheet, got %.0fx%.0f", w1, h1)
+		}
+		sheets.place(800, 1200)
+		if got := pdf.PageNo(); got != 2 {
+			t.Errorf("Expected a third page to start a new sheet, got %d sheets", got)
+		}
+	}
+}
+
+func TestConvertToPDF_PrintLayout(t *testing.T) {
+	var sources []ImageSource
+	for i := range 5 {
+		sources = append(sources, pngSource(t, 60, 90, i))
+	}
+	cfg := NewDefaultConfig()
+	cfg.PrintLayout = &PrintLayout{PagesPerSheet: 4, Paper: PaperLetter}
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ReadPDF(out.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.NumPages(); got != 2 {
+		t.Errorf("Expected 5 pages on 2 sheets, got %d", got)
+	}
+
+	cfg.PrintLayout = &PrintLayout{PagesPerSheet: 3}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidPrintLayout) {
+		t.Errorf("Expected ErrInvalidPrintLayout for 3 pages per sheet, got %v", err)
+	}
+	cfg.PrintLayout = &PrintLayout{PagesPerSheet: 2}
+	cfg.OutputFormat = FormatEPUB
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidPrintLayout) {
+		t.Errorf("Expected ErrInvalidPrintLayout for EPUB output, got %v", err)
+	}
+}
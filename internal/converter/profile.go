@@ -0,0 +1,102 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrUnknownProfile is returned for a Config.Profile that is not one of
+// Profiles.
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// Profile is a set of conversion settings tuned for reading on one device:
+// pages no larger than its screen, grayscale and stronger contrast for
+// e-ink, more vivid and fewer colours for colour e-ink. The screen size
+// also sets the page size, as pages are laid out at their pixel size.
+type Profile struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	MaxWidth    int         `json:"max_width"`
+	MaxHeight   int         `json:"max_height"`
+	Grayscale   bool        `json:"grayscale"`
+	AutoLevels  *AutoLevels `json:"auto_levels,omitempty"`
+	JPEGQuality int         `json:"jpeg_quality"`
+
+	Colour *ColourTuning `json:"colour,omitempty"`
+}
+
+// eInkLevels is the contrast stretch of the e-ink profiles: their screens
+// show washed-out scans even paler.
+var eInkLevels = AutoLevels{BlackClip: 0.5, WhiteClip: 0.5}
+
+// profiles are the device profiles, by name.
+var profiles = map[string]Profile{
+	"kindle-paperwhite": {
+		Description: "Kindle Paperwhite (2021 and later), 1236x1648 e-ink",
+		MaxWidth:    1236, MaxHeight: 1648, Grayscale: true, AutoLevels: &eInkLevels, JPEGQuality: 85,
+	},
+	"kobo-clara": {
+		Description: "Kobo Clara HD and Clara 2E, 1072x1448 e-ink",
+		MaxWidth:    1072, MaxHeight: 1448, Grayscale: true, AutoLevels: &eInkLevels, JPEGQuality: 85,
+	},
+	"kaleido-3": {
+		// The colour filter has half the panel's resolution, so larger
+		// pages only add bytes; its muted colours need a boost.
+		Description: "Colour e-ink with a Kaleido 3 panel (Kobo Libra Colour, Clara Colour, PocketBook Verse Pro Color), 4096 colours",
+		MaxWidth:    948, MaxHeight: 1264, AutoLevels: &eInkLevels, JPEGQuality: 80,
+		Colour: &ColourTuning{Saturation: 1.5, Levels: 16},
+	},
+	"ipad": {
+		Description: "iPad (10th generation), 1640x2360 colour",
+		MaxWidth:    1640, MaxHeight: 2360, JPEGQuality: 90,
+	},
+}
+
+// Profiles returns the device profiles, sorted by name.
+func Profiles() []Profile {
+	list := make([]Profile, 0, len(profiles))
+	for name, p := range profiles {
+		p.Name = name
+		list = append(list, p)
+	}
+	slices.SortFunc(list, func(a, b Profile) int { return strings.Compare(a.Name, b.Name) })
+	return list
+}
+
+func validProfile(name string) error {
+	if _, ok := profiles[name]; ok || name == "" {
+		return nil
+	}
+	names := make([]string, 0, len(profiles))
+	for _, p := range Profiles() {
+		names = append(names, p.Name)
+	}
+	return fmt.Errorf("%w %q (expected one of %s)", ErrUnknownProfile, name, strings.Join(names, ", "))
+}
+
+// ApplyProfile sets the fields of cfg that the named profile covers and
+// records it in cfg.Profile. Settings made afterwards override the
+// profile's.
+func (cfg *Config) ApplyProfile(name string) error {
+	if err := validProfile(name); err != nil {
+		return err
+	}
+	p := profiles[name]
+	cfg.Profile = name
+	cfg.MaxWidth, cfg.MaxHeight = p.MaxWidth, p.MaxHeight
+	cfg.Grayscale = p.Grayscale
+	cfg.AutoLevels = nil
+	if p.AutoLevels != nil {
+		levels := *p.AutoLevels
+		cfg.AutoLevels = &levels
+	}
+	cfg.JPEGQuality = p.JPEGQuality
+	cfg.Colour = nil
+	if p.Colour != nil {
+		colour := *p.Colour
+		cfg.Colour = &colour
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestApplyProfile(t *testing.T) {
+	cfg := NewDefaultConfig()
+	if err := cfg.ApplyProfile("kobo-clara"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "kobo-clara" || cfg.MaxWidth != 1072 || cfg.MaxHeight != 1448 || !cfg.Grayscale || cfg.AutoLevels == nil {
+		t.Errorf("Expected the Kobo Clara settings, got %+v", cfg)
+	}
+	cfg.AutoLevels.BlackClip = 5
+	if profiles["kobo-clara"].AutoLevels.BlackClip == 5 {
+		t.Error("Changing a config's auto levels changed the profile's")
+	}
+	if err := cfg.ApplyProfile("ipad"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Grayscale || cfg.AutoLevels != nil {
+		t.Errorf("Expected the iPad profile to replace the e-ink settings, got %+v", cfg)
+	}
+
+	if err := cfg.ApplyProfile("etch-a-sketch"); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("Expected ErrUnknownProfile, got %v", err)
+	}
+	cfg.Profile = "etch-a-sketch"
+	if err := cfg.Validate(); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("Expected Validate to reject an unknown profile, got %v", err)
+	}
+}
+
+func TestProcessImage_Grayscale(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.Grayscale = true
+	page, err := ProcessImage(context.Background(), cfg, ImageSource{OriginalFilename: "color.png", Reader: io.NopCloser(&buf), ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := png.Decode(bytes.NewReader(page.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out.(*image.Gray); !ok || page.PassedThrough {
+		t.Errorf("Expected a re-encoded grayscale page, got %T (passed through: %v)", out, page.PassedThrough)
+	}
+
+	page, err = ProcessImage(context.Background(), cfg, pngSource(t, 4, 4, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.PassedThrough {
+		t.Error("Expected a grayscale page to be passed through")
+	}
+}
@@ -0,0 +1,41 @@
+package converter
+
+// ProgressStage says what happened to a source in a ProgressEvent.
+type ProgressStage string
+
+// Progress stages, in the order a source goes through them.
+const (
+	ProgressFetched   ProgressStage = "fetched" // An image URL was downloaded (reported by callers that fetch)
+	ProgressStarted   ProgressStage = "started" // A worker began reading the source
+	ProgressDecoded   ProgressStage = "decoded" // The image was read and decoded
+	ProgressPageAdded ProgressStage = "added"   // The page was added to the output document
+	ProgressFailed    ProgressStage = "failed"  // The source could not be fetched, decoded or added
+
+	ProgressTransformed ProgressStage = "transformed" // The page was transformed and encoded, or passed through, for the document; between decoded and added
+)
+
+// ProgressEvent reports the progress of one source of a conversion.
+type ProgressEvent struct {
+	Stage    ProgressStage `json:"stage"`
+	Index    int           `json:"index"` // ImageSource.Index
+	Filename string        `json:"filename"`
+	Bytes    int64         `json:"bytes,omitempty"` // Source bytes read (decoded) or page bytes for the document (transformed, added)
+	Error    string        `json:"error,omitempty"` // Set for ProgressFailed
+}
+
+// ProgressFunc receives ProgressEvents. It is called from the conversion's
+// worker goroutines, so it must be safe for concurrent use, and it should
+// return quickly.
+type ProgressFunc func(event ProgressEvent)
+
+// progress reports event to cfg.Progress, if set.
+func (cfg *Config) progress(stage ProgressStage, index int, filename string, bytes int64, err error) {
+	if cfg.Progress == nil {
+		return
+	}
+	event := ProgressEvent{Stage: stage, Index: index, Filename: filename, Bytes: bytes}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	cfg.Progress(event)
+}
@@ -0,0 +1,120 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pieceInfoApp names the application data this converter keeps in a
+// page's /PieceInfo dictionary.
+const pieceInfoApp = "MangaToPDF"
+
+// pageProvenance collects where the images on each PDF page came from, for
+// addPieceInfo. A nil *pageProvenance collects nothing.
+type pageProvenance struct {
+	pages map[int][]sourceProvenance // By 1-based PDF page number
+}
+
+// sourceProvenance is where one image on a page came from.
+type sourceProvenance struct {
+	PageSource
+	hash []byte // SHA-256 of the source bytes
+}
+
+func newPageProvenance() *pageProvenance {
+	return &pageProvenance{pages: map[int][]sourceProvenance{}}
+}
+
+// add records that res was placed on PDF page number page.
+func (p *pageProvenance) add(page int, res pageResult) {
+	if p == nil {
+		return
+	}
+	p.pages[page] = append(p.pages[page], sourceProvenance{PageSource: res.Source, hash: res.contentHash})
+}
+
+// addPieceInfo records provenance in the /PieceInfo dictionary of every
+// page it knows about: the filename, chapter, URL, download time and
+// SHA-256 of each source image on the page, so an archived document can be
+// traced back to what it was made from. Like tagPDF it appends an
+// incremental update with the changed page objects, leaving the original
+// bytes untouched; it leaves the catalog alone, so it must run before
+// tagPDF and writeRightToLeft.
+func addPieceInfo(doc []byte, provenance *pageProvenance, modified time.Time) ([]byte, error) {
+	prevXref, _, _, err := lastStartXref(doc)
+	if err != nil {
+		return nil, err
+	}
+	trailer := doc[bytes.LastIndex(doc, []byte("trailer")):]
+	root, err1 := trailerRef(trailerRootRE, trailer)
+	info, err2 := trailerRef(trailerInfoRE, trailer)
+	size, err3 := trailerRef(trailerSizeRE, trailer)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("could not add provenance to PDF: %w", err)
+	}
+
+	pages := make([]int, 0, len(provenance.pages))
+	for page := range provenance.pages {
+		pages = append(pages, page)
+	}
+	sort.Ints(pages)
+	date := pdfDate(modified)
+
+	out := bytes.NewBuffer(make([]byte, 0, len(doc)+256*len(pages)))
+	out.Write(doc)
+	if !bytes.HasSuffix(doc, []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	var xref strings.Builder
+	for _, page := range pages {
+		pageNum := 1 + 2*page // gofpdf numbers page objects 3, 5, 7, ...
+		body, err := pdfObject(doc, pageNum)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasPrefix(body, []byte("<</Type /Page\n")) {
+			return nil, fmt.Errorf("could not add provenance to PDF: object %d is not page %d", pageNum, page)
+		}
+		var sources strings.Builder
+		for _, src := range provenance.pages[page] {
+			sources.WriteString(src.pdfDict())
+		}
+		fmt.Fprintf(&xref, "%d 1\n%010d 00000 n \n", pageNum, out.Len())
+		fmt.Fprintf(out, "%d 0 obj\n<</Type /Page\n/LastModified %s\n/PieceInfo <</%s <</LastModified %s /Private <</Sources [%s]>>>>>>\n%s\nendobj\n",
+			pageNum, date, pieceInfoApp, date, sources.String(), body[len("<</Type /Page\n"):])
+	}
+
+	xrefOffset := out.Len()
+	fmt.Fprintf(out, "xref\n%strailer\n<<\n/Size %d\n/Root %d 0 R\n/Info %d 0 R\n/Prev %d\n>>\nstartxref\n%d\n%%%%EOF\n", xref.String(), size, root, info, prevXref, xrefOffset)
+	return out.Bytes(), nil
+}
+
+// pdfDict writes src as a PDF dictionary, leaving out what is not known.
+func (src sourceProvenance) pdfDict() string {
+	var b strings.Builder
+	b.WriteString("<</Filename " + pdfTextString(src.Filename))
+	if src.Chapter != "" {
+		b.WriteString(" /Chapter " + pdfTextString(src.Chapter))
+	}
+	if src.URL != "" {
+		b.WriteString(" /URL " + pdfTextString(src.URL))
+	}
+	if !src.FetchedAt.IsZero() {
+		b.WriteString(" /Fetched " + pdfDate(src.FetchedAt))
+	}
+	if src.hash != nil {
+		b.WriteString(" /SHA256 (" + hex.EncodeToString(src.hash) + ")")
+	}
+	b.WriteString(">>")
+	return b.String()
+}
+
+// pdfDate writes t as a PDF date string, in UTC.
+func pdfDate(t time.Time) string {
+	return t.UTC().Format("(D:20060102150405Z)")
+}
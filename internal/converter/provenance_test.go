@@ -0,0 +1,57 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestConvertToPDF_Provenance(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.Provenance = true
+	cfg.Tagged = true
+	cfg.RightToLeft = true
+	fetched := time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC)
+	sources := []ImageSource{pngSource(t, 10, 10, 0), pngSource(t, 12, 12, 1)}
+	data, err := io.ReadAll(sources[1].Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources[1].Reader = io.NopCloser(bytes.NewReader(data))
+	sources[1].URL, sources[1].FetchedAt, sources[1].Chapter = "https://example.com/ch1/002.png", fetched, "Chapter 1"
+	var out bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources, cfg, &out); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	doc := out.Bytes()
+
+	page, err := pdfObject(doc, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	for _, want := range []string{
+		"/PieceInfo <</MangaToPDF <</LastModified (D:",
+		"/URL " + pdfTextString("https://example.com/ch1/002.png"),
+		"/Chapter " + pdfTextString("Chapter 1"),
+		"/Fetched (D:20260314150926Z)",
+		"/SHA256 (" + hex.EncodeToString(sum[:]) + ")",
+		"/StructParents 1\n", // Tagged on top of the provenance update
+	} {
+		if !bytes.Contains(page, []byte(want)) {
+			t.Errorf("Expected %q in the second page:\n%s", want, page)
+		}
+	}
+	if page, _ := pdfObject(doc, 3); bytes.Contains(page, []byte("/URL")) || !bytes.Contains(page, []byte("/Filename "+pdfTextString("page.png"))) {
+		t.Errorf("Expected the first page to name its file only:\n%s", page)
+	}
+
+	read, err := ReadPDF(doc)
+	if err != nil || read.NumPages() != 2 {
+		t.Fatalf("Expected the updated PDF to read back with 2 pages, got %v", err)
+	}
+}
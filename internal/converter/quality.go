@@ -0,0 +1,152 @@
+package converter
+
+import (
+	"image"
+)
+
+// pageKind is the coarse content class smart quality picks a JPEG quality by.
+type pageKind int
+
+const (
+	pageGrayscale pageKind = iota // Screentones and shading: the configured quality
+	pageLineArt                   // Mostly pure black and white: compresses well at lower quality
+	pageColor                     // Colour or gradient-heavy: artefacts show, so quality goes up
+)
+
+func (k pageKind) String() string {
+	switch k {
+	case pageLineArt:
+		return "line art"
+	case pageColor:
+		return "color"
+	}
+	return "grayscale"
+}
+
+// classifySamples is the sampling grid size per axis used by classifyPage.
+const classifySamples = 64
+
+// classifyPage estimates whether img is colour, line art or ordinary
+// grayscale from a grid of sample pixels. A page counts as colour when more
+// than 5% of samples are noticeably saturated, and as line art when more
+// than 90% are near black or white.
+func classifyPage(img image.Image) pageKind {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return pageGrayscale
+	}
+	stepX := max(bounds.Dx()/classifySamples, 1)
+	stepY := max(bounds.Dy()/classifySamples, 1)
+	var samples, saturated, extreme int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			samples++
+			if max(r, g, b)-min(r, g, b) > 32 {
+				saturated++
+			}
+			if luma := (299*r + 587*g + 114*b) / 1000; luma < 48 || luma > 208 {
+				extreme++
+			}
+		}
+	}
+	switch {
+	case saturated*20 > samples:
+		return pageColor
+	case extreme*10 > samples*9:
+		return pageLineArt
+	}
+	return pageGrayscale
+}
+
+// smartJPEGQuality adapts the configured quality to the page kind.
+func smartJPEGQuality(kind pageKind, quality int) int {
+	switch kind {
+	case pageLineArt:
+		return max(quality-15, 50)
+	case pageColor:
+		return max(min(quality+5, 95), quality)
+	}
+	return quality
+}
+
+// stdLuminanceQuant is the IJG standard luminance quantisation table in
+// zig-zag order, the baseline libjpeg scales by quality.
+var stdLuminanceQuant = [64]int{
+	16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+	26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+	56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+	95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+}
+
+// EstimateJPEGQuality estimates the IJG quality (1-100) a JPEG was saved at
+// from its luminance quantisation table. ok is false if data is not a JPEG
+// or has no luminance table before the image data.
+func EstimateJPEGQuality(data []byte) (quality int, ok bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, false
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 0, false
+		}
+		marker := data[i+1]
+		if marker == 0xFF { // Fill byte
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 { // Start of scan or end of image
+			return 0, false
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		if length < 2 || i+2+length > len(data) {
+			return 0, false
+		}
+		if marker == 0xDB {
+			if quality, ok := luminanceQuality(data[i+4 : i+2+length]); ok {
+				return quality, true
+			}
+		}
+		i += 2 + length
+	}
+	return 0, false
+}
+
+// luminanceQuality finds table 0 in the payload of a DQT segment and
+// inverts libjpeg's quality scaling on it.
+func luminanceQuality(segment []byte) (int, bool) {
+	for len(segment) > 0 {
+		precision, id := segment[0]>>4, segment[0]&0x0F
+		size := 64
+		if precision == 1 {
+			size = 128
+		}
+		if len(segment) < 1+size {
+			return 0, false
+		}
+		if id == 0 {
+			sum := 0
+			for k := 0; k < 64; k++ {
+				q := int(segment[1+k])
+				if precision == 1 {
+					q = int(segment[1+2*k])<<8 | int(segment[2+2*k])
+				}
+				sum += q * 100 / stdLuminanceQuant[k]
+			}
+			scale := (sum + 32) / 64
+			var quality int
+			switch {
+			case scale <= 0:
+				quality = 100
+			case scale <= 100:
+				quality = (200 - scale + 1) / 2
+			default:
+				quality = (5000 + scale/2) / scale
+			}
+			return min(max(quality, 1), 100), true
+		}
+		segment = segment[1+size:]
+	}
+	return 0, false
+}
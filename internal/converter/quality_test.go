@@ -0,0 +1,131 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestClassifyPage(t *testing.T) {
+	lineArt := image.NewGray(image.Rect(0, 0, 200, 300))
+	for i := range lineArt.Pix {
+		lineArt.Pix[i] = 255
+	}
+	for y := 100; y < 110; y++ {
+		for x := 0; x < 200; x++ {
+			lineArt.SetGray(x, y, color.Gray{Y: 0})
+		}
+	}
+
+	gradient := image.NewGray(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			gradient.SetGray(x, y, color.Gray{Y: uint8(x)})
+		}
+	}
+
+	colorPage := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			colorPage.Set(x, y, color.RGBA{R: uint8(2 * x), G: 80, B: 200, A: 255})
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		img  image.Image
+		kind pageKind
+	}{
+		"line art": {lineArt, pageLineArt},
+		"gradient": {gradient, pageGrayscale},
+		"color":    {colorPage, pageColor},
+		"empty":    {image.NewGray(image.Rect(0, 0, 0, 0)), pageGrayscale},
+	} {
+		if kind := classifyPage(tc.img); kind != tc.kind {
+			t.Errorf("%s: expected %v, got %v", name, tc.kind, kind)
+		}
+	}
+}
+
+func TestSmartJPEGQuality(t *testing.T) {
+	if q := smartJPEGQuality(pageLineArt, 90); q != 75 {
+		t.Errorf("Expected line art at 75, got %d", q)
+	}
+	if q := smartJPEGQuality(pageLineArt, 55); q != 50 {
+		t.Errorf("Expected line art quality floored at 50, got %d", q)
+	}
+	if q := smartJPEGQuality(pageColor, 93); q != 95 {
+		t.Errorf("Expected color quality capped at 95, got %d", q)
+	}
+	if q := smartJPEGQuality(pageColor, 98); q != 98 {
+		t.Errorf("Expected color never to lower quality, got %d", q)
+	}
+	if q := smartJPEGQuality(pageGrayscale, 90); q != 90 {
+		t.Errorf("Expected grayscale unchanged, got %d", q)
+	}
+}
+
+func TestEstimateJPEGQuality(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for _, quality := range []int{30, 50, 75, 90, 100} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		estimated, ok := EstimateJPEGQuality(buf.Bytes())
+		if !ok || estimated < quality-1 || estimated > quality+1 {
+			t.Errorf("Quality %d: estimated %d (ok=%v)", quality, estimated, ok)
+		}
+	}
+	if _, ok := EstimateJPEGQuality([]byte("\x89PNG\r\n\x1a\n")); ok {
+		t.Error("Expected no estimate for a PNG")
+	}
+}
+
+func TestConvertToPDF_JPEGPassThrough(t *testing.T) {
+	jpegSource := func(quality, index int) ImageSource {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		// No usable content type, so the page goes through the decode fallback.
+		return ImageSource{OriginalFilename: "page", Reader: io.NopCloser(&buf), ContentType: "application/octet-stream", Index: index}
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.JPEGQuality = 80
+	var stats Stats
+	sources := []ImageSource{jpegSource(70, 0), jpegSource(80, 1), jpegSource(95, 2)}
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if stats.PagesAdded != 3 || stats.PagesPassedThrough != 2 {
+		t.Errorf("Expected the two pages at or below quality 80 passed through, got %+v", stats)
+	}
+}
+
+func TestConvertToPDF_PNGPassThroughUnknownContentType(t *testing.T) {
+	pngSource := func(img image.Image, index int) ImageSource {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return ImageSource{OriginalFilename: "page", Reader: io.NopCloser(&buf), ContentType: "application/octet-stream", Index: index}
+	}
+
+	var stats Stats
+	sources := []ImageSource{
+		pngSource(image.NewGray(image.Rect(0, 0, 16, 16)), 0),
+		pngSource(image.NewGray16(image.Rect(0, 0, 16, 16)), 1), // gofpdf can't embed 16-bit PNGs
+	}
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, NewDefaultConfig(), &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion failed: %v", err)
+	}
+	if stats.PagesAdded != 2 || stats.PagesPassedThrough != 1 {
+		t.Errorf("Expected only the 8-bit PNG passed through, got %+v", stats)
+	}
+}
@@ -0,0 +1,98 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidQualityRule is returned by Config.Validate for a quality rule
+// without a chapter or pages, with malformed pages, or with a quality
+// outside 1-100.
+var ErrInvalidQualityRule = errors.New("invalid quality_rules")
+
+// QualityRule gives a group of pages a JPEG quality other than
+// Config.JPEGQuality, e.g. colour inserts at 95 in a volume encoded at 80.
+// A page is in the group when its chapter matches Chapter and its position
+// is in Pages; an empty Chapter or Pages matches every page.
+type QualityRule struct {
+	Chapter     string `json:"chapter,omitempty"` // path.Match pattern on ImageSource.Chapter, e.g. "Color*"
+	Pages       string `json:"pages,omitempty"`   // 1-based source positions, e.g. "1-8" or "1-4,200"
+	JPEGQuality int    `json:"jpeg_quality"`
+}
+
+func (r QualityRule) validate() error {
+	if r.Chapter == "" && r.Pages == "" {
+		return fmt.Errorf("%w: a rule needs a chapter or pages", ErrInvalidQualityRule)
+	}
+	if _, err := path.Match(r.Chapter, ""); err != nil {
+		return fmt.Errorf("%w: chapter %q: %w", ErrInvalidQualityRule, r.Chapter, err)
+	}
+	if _, err := parsePageRanges(r.Pages); err != nil {
+		return err
+	}
+	if r.JPEGQuality < 1 || r.JPEGQuality > 100 {
+		return fmt.Errorf("%w: jpeg_quality %d (expected 1-100)", ErrInvalidQualityRule, r.JPEGQuality)
+	}
+	return nil
+}
+
+// matches reports whether the page-th page, of chapter, is in the group of r.
+func (r QualityRule) matches(chapter string, page int) bool {
+	if r.Chapter != "" {
+		if ok, _ := path.Match(r.Chapter, chapter); !ok {
+			return false
+		}
+	}
+	if r.Pages == "" {
+		return true
+	}
+	ranges, _ := parsePageRanges(r.Pages) // Checked by Validate
+	for _, pr := range ranges {
+		if page >= pr[0] && page <= pr[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePageRanges parses comma-separated page numbers and ranges, such as
+// "1-4,200", into inclusive [first, last] pairs.
+func parsePageRanges(pages string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, item := range strings.Split(pages, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+		}
+		if err != nil || from < 1 || to < from {
+			return nil, fmt.Errorf("%w: pages %q (expected page numbers or ranges from 1, e.g. 1-8,12)", ErrInvalidQualityRule, item)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
+
+// pageQuality returns the JPEG quality of the source at index (0-based) in
+// chapter: that of the first of cfg.QualityRules it matches, or
+// cfg.JPEGQuality. On the passes of a TargetSize conversion, which lower
+// JPEGQuality, no rule raises a page above it.
+func (cfg *Config) pageQuality(chapter string, index int) int {
+	for _, rule := range cfg.QualityRules {
+		if rule.matches(chapter, index+1) {
+			if cfg.sizePass.reencodeJPEG {
+				return min(rule.JPEGQuality, cfg.JPEGQuality)
+			}
+			return rule.JPEGQuality
+		}
+	}
+	return cfg.JPEGQuality
+}
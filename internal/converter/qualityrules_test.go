@@ -0,0 +1,62 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"sync"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func TestQualityRules(t *testing.T) {
+	var data bytes.Buffer
+	if err := bmp.Encode(&data, image.NewRGBA(image.Rect(0, 0, 12, 8))); err != nil {
+		t.Fatal(err)
+	}
+	var sources []ImageSource
+	for i, chapter := range []string{"Color inserts", "Chapter 1", "Chapter 1", "Chapter 2"} {
+		sources = append(sources, ImageSource{OriginalFilename: "page.bmp", Reader: io.NopCloser(bytes.NewReader(data.Bytes())), ContentType: "image/bmp", Index: i, Chapter: chapter})
+	}
+	cfg := NewDefaultConfig()
+	cfg.JPEGQuality = 80
+	cfg.QualityRules = []QualityRule{
+		{Chapter: "Color*", JPEGQuality: 95},
+		{Chapter: "Chapter 1", Pages: "3-10", JPEGQuality: 60},
+	}
+	var mu sync.Mutex
+	qualities := map[int]int{}
+	cfg.Inspect = func(page PageInspection) {
+		mu.Lock()
+		defer mu.Unlock()
+		qualities[page.Source.Index] = page.Quality
+	}
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &Stats{}); err != nil {
+		t.Fatalf("Conversion with quality rules failed: %v", err)
+	}
+	for index, want := range []int{95, 80, 60, 80} {
+		if qualities[index] != want {
+			t.Errorf("Page %d: expected quality %d, got %d", index+1, want, qualities[index])
+		}
+	}
+}
+
+func TestQualityRules_Validate(t *testing.T) {
+	for _, rule := range []QualityRule{
+		{JPEGQuality: 90},
+		{Pages: "1-4", JPEGQuality: 0},
+		{Pages: "4-1", JPEGQuality: 90},
+		{Pages: "0", JPEGQuality: 90},
+		{Pages: "one", JPEGQuality: 90},
+		{Chapter: "[", JPEGQuality: 90},
+	} {
+		cfg := NewDefaultConfig()
+		cfg.QualityRules = []QualityRule{rule}
+		if err := cfg.Validate(); !errors.Is(err, ErrInvalidQualityRule) {
+			t.Errorf("%+v: expected ErrInvalidQualityRule, got %v", rule, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidReadAhead is returned by Config.Validate for a negative
+// ReadAhead.
+var ErrInvalidReadAhead = errors.New("invalid read_ahead")
+
+// readAhead reads the sources coming from in into memory on its own
+// goroutine, in the order the pipeline starts them, and passes them on
+// with readers over that memory. Up to depth read sources wait for a decode
+// worker, so slow reads, such as the decompression of archive entries,
+// overlap with the decoding and encoding of earlier pages instead of
+// holding up a decode worker. Sources without a reader, that failed to
+// download, or that arrive after ctx is done are passed on as they are. A
+// source that cannot be read fails in the decode stage as it would have.
+func readAhead(ctx context.Context, in <-chan positionedDecode, depth int) <-chan positionedDecode {
+	out := make(chan positionedDecode, depth)
+	go func() {
+		defer close(out)
+		for item := range in {
+			src := item.source
+			if ctx.Err() == nil && src.Reader != nil && src.FetchErr == nil {
+				data, err := io.ReadAll(src.Reader)
+				src.Reader.Close()
+				item.source.Reader = readAheadReader{Reader: bytes.NewReader(data), err: err}
+			}
+			out <- item
+		}
+	}()
+	return out
+}
+
+// readAheadReader replays a source read by readAhead, then reports the
+// error that ended the read, if any.
+type readAheadReader struct {
+	*bytes.Reader
+	err error
+}
+
+func (r readAheadReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && r.err != nil {
+		return n, fmt.Errorf("could not read ahead: %w", r.err)
+	}
+	return n, err
+}
+
+func (r readAheadReader) Close() error { return nil }
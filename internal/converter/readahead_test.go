@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// signalReader closes done once its source has been read to the end.
+type signalReader struct {
+	io.ReadCloser
+	done chan struct{}
+}
+
+func (r *signalReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		select {
+		case <-r.done:
+		default:
+			close(r.done)
+		}
+	}
+	return n, err
+}
+
+func TestReadAhead(t *testing.T) {
+	sources := []ImageSource{pngSource(t, 10, 10, 0), pngSource(t, 10, 10, 1), pngSource(t, 10, 10, 2)}
+	read := make(chan struct{})
+	sources[1].Reader = &signalReader{ReadCloser: sources[1].Reader, done: read}
+	sources[2].Reader = io.NopCloser(io.MultiReader(strings.NewReader("\x89PNG"), iotest.ErrReader(errors.New("disk gone"))))
+	cfg := NewDefaultConfig()
+	cfg.NumWorkers = 1
+	cfg.LargestFirst = false
+	cfg.ReadAhead = 2
+	// The only decode worker waits on the first page until the second has
+	// been read, which only read-ahead can do meanwhile.
+	cfg.Progress = func(ev ProgressEvent) {
+		if ev.Stage == ProgressStarted && ev.Index == 0 {
+			select {
+			case <-read:
+			case <-time.After(5 * time.Second):
+				t.Error("Expected the next page to be read ahead while the first one was decoding")
+			}
+		}
+	}
+	var stats Stats
+	if _, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &bytes.Buffer{}, &stats); err != nil {
+		t.Fatalf("Conversion with read-ahead failed: %v", err)
+	}
+	if stats.PagesAdded != 2 || stats.PagesFailed != 1 {
+		t.Errorf("Expected the unreadable page to fail on its own, got %+v", stats)
+	}
+
+	cfg.ReadAhead = -1
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidReadAhead) {
+		t.Errorf("Expected a negative read-ahead to be rejected, got %v", err)
+	}
+}
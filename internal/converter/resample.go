@@ -0,0 +1,55 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+)
+
+// Resampling filters for Config.ResampleFilter.
+const (
+	ResampleLanczos    = "lanczos"    // Sharpest; best for photos and gradients
+	ResampleCatmullRom = "catmullrom" // Slightly softer, with less ringing around line art
+	ResampleNearest    = "nearest"    // No smoothing; by far the fastest, for previews
+)
+
+// ErrUnsupportedResampleFilter is returned for an unknown Config.ResampleFilter.
+var ErrUnsupportedResampleFilter = errors.New("unsupported resample filter")
+
+func validResampleFilter(filter string) error {
+	switch filter {
+	case "", ResampleLanczos, ResampleCatmullRom, ResampleNearest:
+		return nil
+	}
+	return fmt.Errorf("%w %q (expected %q, %q or %q)", ErrUnsupportedResampleFilter, filter, ResampleLanczos, ResampleCatmullRom, ResampleNearest)
+}
+
+// resampleFilter returns the filter pages are resized with, Lanczos unless
+// cfg.ResampleFilter says otherwise.
+func (cfg *Config) resampleFilter() imaging.ResampleFilter {
+	switch cfg.ResampleFilter {
+	case ResampleCatmullRom:
+		return imaging.CatmullRom
+	case ResampleNearest:
+		return imaging.NearestNeighbor
+	}
+	return imaging.Lanczos
+}
+
+// resizePage scales a page image to width x height pixels with the filter
+// from cfg. Every resize of page content goes through it, so the filter
+// choice applies everywhere; the perceptual hash keeps its own fixed
+// filter, as its results must not depend on the settings. Grayscale pages
+// stay grayscale, so they are still encoded as such.
+func (cfg *Config) resizePage(img image.Image, width, height int) image.Image {
+	resized := imaging.Resize(img, width, height, cfg.resampleFilter())
+	if _, ok := img.(*image.Gray); !ok {
+		return resized
+	}
+	gray := image.NewGray(resized.Bounds())
+	draw.Draw(gray, gray.Bounds(), resized, image.Point{}, draw.Src)
+	return gray
+}
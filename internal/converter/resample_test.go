@@ -0,0 +1,52 @@
+package converter
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResizePage_Filters(t *testing.T) {
+	// A one-pixel black and white checkerboard: nearest keeps pure black
+	// and white when halving it, the smoothing filters blend them to grey.
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		filter string
+		pure   bool
+	}{
+		{"", false},
+		{ResampleLanczos, false},
+		{ResampleCatmullRom, false},
+		{ResampleNearest, true},
+	} {
+		cfg := &Config{ResampleFilter: tc.filter}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Validate(%q): %v", tc.filter, err)
+		}
+		small := cfg.resizePage(img, 4, 4)
+		if got := small.Bounds().Size(); got != image.Pt(4, 4) {
+			t.Fatalf("Filter %q: expected a 4x4 result, got %v", tc.filter, got)
+		}
+		v, _, _, _ := small.At(1, 1).RGBA()
+		v >>= 8
+		if pure := v == 0 || v == 255; pure != tc.pure {
+			t.Errorf("Filter %q: got pixel value %d, expected pure black or white: %v", tc.filter, v, tc.pure)
+		}
+	}
+
+	cfg := &Config{ResampleFilter: "bicubic"}
+	if err := cfg.Validate(); !errors.Is(err, ErrUnsupportedResampleFilter) {
+		t.Errorf("Expected ErrUnsupportedResampleFilter, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package converter
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"log/slog"
+	"sort"
+)
+
+// prefixedReadCloser replays bytes already consumed from a reader before
+// continuing with the rest of it, while closing the original reader.
+type prefixedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// prescanPixels reads just enough of the source to decode its header and
+// returns the page's pixel count, or 0 if it cannot be determined. The
+// consumed bytes are replayed, so the source can still be processed
+// normally afterwards.
+func prescanPixels(source *ImageSource) int {
+	if source.Reader == nil {
+		return 0
+	}
+	var header bytes.Buffer
+	imgConfig, _, err := image.DecodeConfig(io.TeeReader(source.Reader, &header))
+	source.Reader = prefixedReadCloser{
+		Reader: io.MultiReader(&header, source.Reader),
+		Closer: source.Reader,
+	}
+	if err != nil {
+		return 0
+	}
+	return imgConfig.Width * imgConfig.Height
+}
+
+// scheduleOrder returns the order in which sources should enter the
+// pipeline. With LargestFirst, pages are pre-scanned and the biggest ones
+// start first, so the run does not end with a single huge spread still
+// decoding while the other workers sit idle. Otherwise the input order is
+// kept. Output order is unaffected either way.
+func scheduleOrder(cfg *Config, imageSources []ImageSource) []int {
+	order := make([]int, len(imageSources))
+	for i := range order {
+		order[i] = i
+	}
+	if !cfg.LargestFirst || len(imageSources) < 2 {
+		return order
+	}
+
+	pixels := make([]int, len(imageSources))
+	for i := range imageSources {
+		pixels[i] = prescanPixels(&imageSources[i])
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return pixels[order[a]] > pixels[order[b]]
+	})
+	slog.Debug("Scheduled image sources largest-first", "order", order)
+	return order
+}
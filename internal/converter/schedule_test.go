@@ -0,0 +1,59 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func pngSource(t *testing.T, w, h, index int) ImageSource {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return ImageSource{
+		OriginalFilename: "page.png",
+		Reader:           io.NopCloser(&buf),
+		ContentType:      "image/png",
+		Index:            index,
+	}
+}
+
+func TestScheduleOrder_LargestFirst(t *testing.T) {
+	cfg := NewDefaultConfig()
+	sources := []ImageSource{
+		pngSource(t, 10, 10, 0),
+		pngSource(t, 40, 30, 1),
+		newStringImageSource("broken.png", "not an image", "image/png", 2),
+		pngSource(t, 20, 20, 3),
+	}
+	order := scheduleOrder(cfg, sources)
+	expected := []int{1, 3, 0, 2}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected order %v, got %v", expected, order)
+		}
+	}
+
+	// Pre-scanning must not consume the data needed for processing.
+	processed := processSingleImage(context.Background(), cfg, sources[1])
+	if processed.err != nil {
+		t.Fatalf("Expected pre-scanned source to process, got %v", processed.err)
+	}
+	if processed.Width != 40 || processed.Height != 30 {
+		t.Errorf("Expected 40x30 page, got %vx%v", processed.Width, processed.Height)
+	}
+}
+
+func TestScheduleOrder_InputOrder(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.LargestFirst = false
+	sources := []ImageSource{pngSource(t, 1, 1, 0), pngSource(t, 50, 50, 1)}
+	if order := scheduleOrder(cfg, sources); order[0] != 0 || order[1] != 1 {
+		t.Errorf("Expected input order without LargestFirst, got %v", order)
+	}
+}
@@ -0,0 +1,40 @@
+package converter
+
+import (
+	"bytes"
+	"io"
+)
+
+// SniffLen is the number of leading bytes DetectContentType needs.
+const SniffLen = 12
+
+// DetectContentType identifies a supported image format from its leading
+// bytes (magic numbers), for files whose name carries no usable extension.
+// It returns "" if the data is not a supported image.
+func DetectContentType(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "image/gif"
+	case len(header) >= 10 && bytes.HasPrefix(header, []byte("BM")) && bytes.Equal(header[6:10], []byte{0, 0, 0, 0}):
+		// "BM" alone is too common a prefix; the reserved header fields are zero.
+		return "image/bmp"
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return "image/webp"
+	}
+	return ""
+}
+
+// SniffContentType reads up to SniffLen bytes from r and detects the image
+// format with DetectContentType.
+func SniffContentType(r io.Reader) (string, error) {
+	header := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return DetectContentType(header[:n]), nil
+}
@@ -0,0 +1,41 @@
+package converter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"jpeg", "\xFF\xD8\xFF\xE0\x00\x10JFIF", "image/jpeg"},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00\x00\x0d", "image/png"},
+		{"gif", "GIF89a\x10\x00\x10\x00\x80\x00", "image/gif"},
+		{"bmp", "BM\x46\x00\x00\x00\x00\x00\x00\x00\x36\x00", "image/bmp"},
+		{"bm text", "BMW is a car", ""},
+		{"webp", "RIFF\x24\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"riff but not webp", "RIFF\x24\x00\x00\x00WAVEfmt ", ""},
+		{"text", "hello world!", ""},
+		{"short", "\xFF\xD8", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectContentType([]byte(tt.header)); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestSniffContentType_ShortInput(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("\xFF\xD8\xFF")
+	if got, err := SniffContentType(&buf); err != nil || got != "image/jpeg" {
+		t.Errorf("Expected image/jpeg from a short JPEG header, got %q, %v", got, err)
+	}
+	if got, err := SniffContentType(strings.NewReader("")); err != nil || got != "" {
+		t.Errorf("Expected no type for empty input, got %q, %v", got, err)
+	}
+}
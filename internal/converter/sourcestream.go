@@ -0,0 +1,117 @@
+package converter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// SourceStream returns the next source of a conversion whose sources arrive
+// one at a time, in page order, and io.EOF after the last one.
+type SourceStream func() (ImageSource, error)
+
+// ConvertStreamToPDF is ConvertToPDFWithStats for sources that arrive one
+// at a time, such as the entries of a tar stream read from a pipe. Each
+// source is processed as soon as next returns it, while later ones are
+// still on their way, and only a window of sources is held at once however
+// long the stream is. Pages are started in arrival order, so
+// cfg.LargestFirst does not apply; NormalizeWidth and TargetSize need every
+// page before the first one is placed, so with them the whole stream is
+// read first.
+//
+// An error from next other than io.EOF ends the stream and, once the pages
+// read so far are processed, fails the conversion. next is not called again
+// after ctx is done, but a call that is already waiting for input is not
+// interrupted. Sources next has not returned are the caller's to close.
+func ConvertStreamToPDF(ctx context.Context, next SourceStream, cfg *Config, writer io.Writer, stats *Stats) (hasContent bool, err error) {
+	if cfg.NormalizeWidth || cfg.TargetSize > 0 {
+		var sources []ImageSource
+		for {
+			src, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				for _, src := range sources {
+					if src.Reader != nil {
+						src.Reader.Close()
+					}
+				}
+				return false, fmt.Errorf("could not read sources: %w", err)
+			}
+			sources = append(sources, src)
+		}
+		return ConvertToPDFWithStats(ctx, sources, cfg, writer, stats)
+	}
+
+	ctx, leaks := trackLeaks(ctx)
+	defer leaks.report()
+	if stats == nil {
+		stats = &Stats{}
+	}
+	if err := cfg.Validate(); err != nil {
+		return false, err
+	}
+	ctx, stopBudget := withPixelBudget(ctx, cfg)
+	defer stopBudget(nil)
+	if ctx.Err() != nil {
+		return false, CancellationError(ctx)
+	}
+
+	in := &streamSources{ctx: ctx, next: next, leaks: leaks, gifFrames: cfg.GIFFrames}
+	window := cfg.streamWindow()
+	slots := make(chan struct{}, window)
+	feed := &pageFeed{pages: inOrder(runPipeline(ctx, cfg, in.source, window, slots, stats), slots, window), stats: stats, started: time.Now(), filter: newPageFilter(cfg, stats)}
+	hasContent, err = writeDocument(ctx, cfg, feed, writer, stats)
+	// The pipeline has stopped asking for sources once the feed is drained.
+	stats.Sources = in.count
+	if in.err != nil {
+		return hasContent, fmt.Errorf("could not read sources: %w", in.err)
+	}
+	return hasContent, err
+}
+
+// streamSources hands the sources of a SourceStream to the pipeline,
+// skipping those without a reader or URL and expanding animated GIFs with
+// gifFrames, as ConvertToPDFWithStats does for a slice.
+type streamSources struct {
+	ctx       context.Context
+	next      SourceStream
+	leaks     *leakScope
+	gifFrames bool
+
+	queue []ImageSource // Frames of an expanded GIF not handed out yet
+	count int           // Sources handed out
+	err   error         // Read error that ended the stream
+}
+
+// source is the pipeline's nextSource.
+func (s *streamSources) source() (int, ImageSource, bool) {
+	for len(s.queue) == 0 {
+		if s.ctx.Err() != nil || s.err != nil {
+			return 0, ImageSource{}, false
+		}
+		src, err := s.next()
+		if err != nil {
+			if err != io.EOF {
+				slog.Warn("Source stream failed", "sources", s.count, "error", err)
+				s.err = err
+			}
+			return 0, ImageSource{}, false
+		}
+		if src.Reader == nil && src.URL == "" && src.FetchErr == nil {
+			slog.Warn("Skipping image source with no reader and no URL", "originalFilename", src.OriginalFilename, "index", src.Index)
+			continue
+		}
+		s.queue = s.leaks.trackSources([]ImageSource{src})
+		if s.gifFrames {
+			s.queue = expandGIFFrames(s.queue)
+		}
+	}
+	src := s.queue[0]
+	s.queue = s.queue[1:]
+	s.count++
+	return s.count - 1, src, true
+}
@@ -0,0 +1,85 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestConvertStreamToPDF(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.NumWorkers = 2
+
+	var mu sync.Mutex
+	var added []int
+	cfg.Progress = func(ev ProgressEvent) {
+		if ev.Stage == ProgressPageAdded {
+			mu.Lock()
+			added = append(added, ev.Index)
+			mu.Unlock()
+		}
+	}
+
+	const pages = 40
+	arrived := 0
+	addedBeforeEnd := 0
+	next := func() (ImageSource, error) {
+		if arrived == pages {
+			mu.Lock()
+			addedBeforeEnd = len(added)
+			mu.Unlock()
+			return ImageSource{}, io.EOF
+		}
+		arrived++
+		return pngSource(t, 10+arrived%7, 20, arrived-1), nil
+	}
+
+	var buf bytes.Buffer
+	var stats Stats
+	hasContent, err := ConvertStreamToPDF(context.Background(), next, cfg, &buf, &stats)
+	if err != nil || !hasContent {
+		t.Fatalf("Expected a PDF, got %v (content: %v)", err, hasContent)
+	}
+	doc, err := ReadPDF(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := doc.NumPages(); n != pages || stats.Sources != pages || stats.PagesAdded != pages {
+		t.Errorf("Expected %d pages, got %d in the PDF (stats: %+v)", pages, n, stats)
+	}
+	for i, index := range added {
+		if index != i {
+			t.Fatalf("Expected pages added in arrival order, got %v", added)
+		}
+	}
+	// The window holds far fewer pages than the stream, so earlier pages
+	// must have gone into the document before the last one arrived.
+	if addedBeforeEnd == 0 {
+		t.Error("Expected pages to be added while the stream was still arriving")
+	}
+}
+
+func TestConvertStreamToPDF_ReadError(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	arrived := 0
+	next := func() (ImageSource, error) {
+		if arrived == 3 {
+			return ImageSource{}, errBroken
+		}
+		arrived++
+		return pngSource(t, 10, 10, arrived-1), nil
+	}
+	_, err := ConvertStreamToPDF(context.Background(), next, NewDefaultConfig(), io.Discard, nil)
+	if !errors.Is(err, errBroken) {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+
+	// Without any page the stream is like an empty directory.
+	empty := func() (ImageSource, error) { return ImageSource{}, io.EOF }
+	if _, err := ConvertStreamToPDF(context.Background(), empty, NewDefaultConfig(), io.Discard, nil); !errors.Is(err, ErrNoSupportedImages) {
+		t.Errorf("Expected ErrNoSupportedImages for an empty stream, got %v", err)
+	}
+}
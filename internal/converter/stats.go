@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"io"
+	"time"
+)
+
+// Stats describes how a conversion went and where its time was spent.
+// DecodeTime and EncodeTime are summed over all workers of their stage, so
+// with several workers they can exceed ProcessTime, which is wall-clock.
+// Pages are usually streamed into the document while later ones are still
+// processed, so ProcessTime and PDFTime overlap.
+type Stats struct {
+	Sources            int           `json:"sources"`              // Image sources handed to the pipeline
+	PagesAdded         int           `json:"pages_added"`          // Pages that made it into the PDF
+	PagesFailed        int           `json:"pages_failed"`         // Sources skipped because of an error
+	PagesDuplicate     int           `json:"pages_duplicate"`      // Pages dropped by Config.DedupPages
+	PagesBlank         int           `json:"pages_blank"`          // Pages dropped by Config.SkipBlank
+	PagesPlaceholder   int           `json:"pages_placeholder"`    // Failed pages replaced by a placeholder (Config.Placeholders)
+	PagesPassedThrough int           `json:"pages_passed_through"` // Pages embedded from the source bytes without re-encoding
+	PagesCached        int           `json:"pages_cached"`         // Pages taken from Config.PageCache instead of being converted
+	OutputBytes        int64         `json:"output_bytes"`         // Size of the written PDF
+	DecodeTime         time.Duration `json:"decode_time"`          // Time spent in the decode stage
+	EncodeTime         time.Duration `json:"encode_time"`          // Time spent in the encode stage
+	ProcessTime        time.Duration `json:"process_time"`         // Wall-clock time of the decode/encode pipeline
+	PDFTime            time.Duration `json:"pdf_time"`             // Wall-clock time assembling and writing the PDF
+
+	BytesDecoded int64 `json:"bytes_decoded"` // Source bytes read by the decode stage
+	BytesEncoded int64 `json:"bytes_encoded"` // Page data produced by the encode stage (re-encoded or passed through)
+	BufferGets   int64 `json:"buffer_gets"`   // Encode buffers requested from the pool
+	BufferHits   int64 `json:"buffer_hits"`   // Requests served by reusing a pooled buffer
+
+	SizePasses int `json:"size_passes,omitempty"` // Conversions run to meet Config.TargetSize; the other stats are the last one's
+
+	Failures []PageFailure `json:"failures,omitempty"` // One entry per source counted in PagesFailed
+	Dropped  []PageDrop    `json:"dropped,omitempty"`  // One entry per page counted in PagesDuplicate or PagesBlank
+}
+
+// PageFailure identifies a source that could not be turned into a page.
+type PageFailure struct {
+	Index    int    `json:"index"`
+	Filename string `json:"filename"`
+	Error    string `json:"error"`
+	Fetch    bool   `json:"fetch,omitempty"` // The source was not fetched (ImageSource.FetchErr)
+}
+
+// BufferHitRate returns the fraction of encode buffers that were reused
+// from the pool, or 0 if none were needed.
+func (s Stats) BufferHitRate() float64 {
+	if s.BufferGets == 0 {
+		return 0
+	}
+	return float64(s.BufferHits) / float64(s.BufferGets)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
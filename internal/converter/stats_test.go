@@ -0,0 +1,54 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestConvertToPDFWithStats(t *testing.T) {
+	cfg := NewDefaultConfig()
+	sources := []ImageSource{
+		pngSource(t, 10, 10, 0),
+		newStringImageSource("broken.png", "not an image", "image/png", 1),
+		pngSource(t, 20, 20, 2),
+	}
+
+	var out bytes.Buffer
+	var stats Stats
+	hasContent, err := ConvertToPDFWithStats(context.Background(), sources, cfg, &out, &stats)
+	if err != nil || !hasContent {
+		t.Fatalf("Expected content and no error, got %v, %v", hasContent, err)
+	}
+	if stats.Sources != 3 || stats.PagesAdded != 2 || stats.PagesFailed != 1 {
+		t.Errorf("Unexpected page counts: %+v", stats)
+	}
+	if len(stats.Failures) != 1 || stats.Failures[0].Filename != "broken.png" || stats.Failures[0].Index != 1 {
+		t.Errorf("Expected the broken page in Failures, got %+v", stats.Failures)
+	}
+	if stats.OutputBytes != int64(out.Len()) {
+		t.Errorf("Expected OutputBytes %d, got %d", out.Len(), stats.OutputBytes)
+	}
+	if stats.BytesDecoded <= 0 || stats.BytesEncoded <= 0 {
+		t.Errorf("Expected decoded and encoded byte counts: %+v", stats)
+	}
+	if stats.DecodeTime <= 0 || stats.ProcessTime <= 0 || stats.PDFTime <= 0 {
+		t.Errorf("Expected stage timings to be recorded: %+v", stats)
+	}
+}
+
+func TestTrimBuffers(t *testing.T) {
+	TrimBuffers() // Whatever earlier tests left
+	for range 3 {
+		buf, _ := getBuffer()
+		buf.Grow(1 << 16)
+		bufferPool.Put(buf)
+	}
+	// The pool may drop buffers on its own, so only an upper bound holds.
+	if buffers, size := TrimBuffers(); buffers > 3 || size < int64(buffers)<<16 {
+		t.Errorf("Expected at most 3 buffers of 64 KiB, got %d of %d bytes", buffers, size)
+	}
+	if _, reused := getBuffer(); reused {
+		t.Error("Expected no pooled buffer after TrimBuffers")
+	}
+}
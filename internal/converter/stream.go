@@ -0,0 +1,120 @@
+package converter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// streamWindow returns how many pages may be in flight, or done and waiting
+// for an earlier page, while a document is streamed: enough to keep every
+// worker busy when page sizes vary, without holding the whole volume.
+func (cfg *Config) streamWindow() int {
+	decodeWorkers, encodeWorkers := cfg.stageWorkers()
+	return max(4*(decodeWorkers+encodeWorkers), 16)
+}
+
+// streamProcessedImages processes imageSources like processImagesConcurrently
+// but yields the results in input order as soon as each is ready, so the
+// document takes every page while later ones are still being processed.
+// At most window sources are being processed or waiting for an earlier page,
+// however long the volume is; the document still keeps every page it has
+// taken until it is written. cfg.LargestFirst reorders sources within each run of window sources
+// only, so the page the document needs next is always among those started.
+// The consumer must read the channel to the end.
+func streamProcessedImages(ctx context.Context, cfg *Config, imageSources []ImageSource, window int, stats *Stats) <-chan pageResult {
+	order := make([]int, 0, len(imageSources))
+	for start := 0; start < len(imageSources); start += window {
+		end := min(start+window, len(imageSources))
+		for _, i := range scheduleOrder(cfg, imageSources[start:end]) {
+			order = append(order, start+i)
+		}
+	}
+	slots := make(chan struct{}, window)
+	return inOrder(runPipeline(ctx, cfg, sliceSources(imageSources, order), len(imageSources), slots, stats), slots, window)
+}
+
+// inOrder yields the results of a pipeline fed with slots in input order,
+// giving a slot back as each one is taken, with up to window results held
+// until the one before them is ready.
+func inOrder(results <-chan positionedResult, slots chan struct{}, window int) <-chan pageResult {
+	out := make(chan pageResult)
+	go func() {
+		defer close(out)
+		pending := make(map[int]pageResult, window)
+		next := 0
+		for res := range results {
+			pending[res.position] = res.result
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				out <- ready
+				<-slots // The page is the generator's now; let the next source start
+				next++
+			}
+		}
+	}()
+	return out
+}
+
+// pageFeed hands processed pages to a document generator in input order.
+// It records failed sources in stats, drops the pages filter leaves out
+// when it is set, and counts how the sources ended up for the verdict of
+// the conversion.
+type pageFeed struct {
+	pages    <-chan pageResult
+	stats    *Stats
+	filter   *pageFilter // Set when dropping duplicate or blank pages from a stream
+	started  time.Time   // When a stream began; stats.ProcessTime is set when it ends
+	results  int         // Results handed out, failures included
+	canceled int         // Results that failed because of cancellation
+}
+
+// sliceFeed returns a pageFeed over already processed pages.
+func sliceFeed(results []pageResult, stats *Stats) *pageFeed {
+	pages := make(chan pageResult, len(results))
+	for _, res := range results {
+		pages <- res
+	}
+	close(pages)
+	return &pageFeed{pages: pages, stats: stats}
+}
+
+// next returns the next result, or false when there are no more.
+func (f *pageFeed) next() (pageResult, bool) {
+	for res := range f.pages {
+		if f.filter != nil && f.filter.drop(res) {
+			res.release()
+			continue
+		}
+		f.results++
+		if res.err != nil {
+			f.stats.PagesFailed++
+			f.stats.Failures = append(f.stats.Failures, PageFailure{Index: res.Source.Index, Filename: res.Source.Filename, Error: res.err.Error(), Fetch: isFetchFailure(res.err)})
+			if errors.Is(res.err, context.Canceled) {
+				f.canceled++
+			}
+		}
+		return res, true
+	}
+	if !f.started.IsZero() {
+		f.stats.ProcessTime = time.Since(f.started)
+		f.started = time.Time{}
+	}
+	return pageResult{}, false
+}
+
+// drain releases the pages the generator did not take, letting the
+// pipeline finish.
+func (f *pageFeed) drain() {
+	for {
+		res, ok := f.next()
+		if !ok {
+			return
+		}
+		res.release()
+	}
+}
@@ -0,0 +1,49 @@
+package converter
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestStreamProcessedImages_InputOrderWithinWindow(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.NumWorkers = 4
+	cfg.LargestFirst = true
+
+	var mu sync.Mutex
+	started := 0
+	cfg.Progress = func(ev ProgressEvent) {
+		if ev.Stage == ProgressStarted {
+			mu.Lock()
+			started++
+			mu.Unlock()
+		}
+	}
+
+	var sources []ImageSource
+	for i := 0; i < 12; i++ {
+		sources = append(sources, pngSource(t, 10+(i%4)*30, 20, i))
+	}
+
+	const window = 3
+	received := 0
+	for res := range streamProcessedImages(context.Background(), cfg, sources, window, &Stats{}) {
+		if res.err != nil {
+			t.Fatalf("Unexpected error for page %d: %v", res.Source.Index, res.err)
+		}
+		if res.Source.Index != received {
+			t.Fatalf("Expected page %d next, got %d", received, res.Source.Index)
+		}
+		res.release()
+		received++
+		mu.Lock()
+		if started > received+window {
+			t.Errorf("%d sources started with only %d pages taken and a window of %d", started, received, window)
+		}
+		mu.Unlock()
+	}
+	if received != len(sources) {
+		t.Errorf("Expected %d pages, got %d", len(sources), received)
+	}
+}
@@ -0,0 +1,99 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+)
+
+// ErrTargetSizeNotReached is returned when a conversion with
+// Config.TargetSize is still too large after its last pass.
+var ErrTargetSizeNotReached = errors.New("output could not be brought under the target size")
+
+// ErrInvalidTargetSize is returned for a negative Config.TargetSize.
+var ErrInvalidTargetSize = errors.New("invalid target_size")
+
+const (
+	maxSizePasses    = 6  // Conversions tried before giving up on Config.TargetSize
+	minTargetQuality = 50 // JPEG quality below which only the resolution is lowered
+	sizeQualityStep  = 15 // JPEG quality given up per pass until minTargetQuality
+)
+
+// sizePass holds the settings a conversion with Config.TargetSize changes
+// from one pass to the next.
+type sizePass struct {
+	reencodeJPEG bool    // Re-encode JPEGs above JPEGQuality instead of embedding them as is
+	scale        float64 // Scale every page down by this factor; 0 keeps the size
+}
+
+// convertToTargetSize converts sources again and again, first lowering the
+// JPEG quality down to minTargetQuality and then the resolution, until the
+// output fits in cfg.TargetSize bytes, and writes the first output that
+// does. The sources are read into memory once so every pass can use them.
+func convertToTargetSize(ctx context.Context, sources []ImageSource, cfg *Config, writer io.Writer, stats *Stats) (hasContent bool, err error) {
+	data := make([][]byte, len(sources))
+	for i, src := range sources {
+		if src.Reader == nil {
+			continue
+		}
+		if err == nil {
+			data[i], err = io.ReadAll(src.Reader)
+		}
+		src.Reader.Close()
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not read image data: %w", err)
+	}
+
+	passCfg := *cfg
+	passCfg.TargetSize = 0
+	var out bytes.Buffer
+	for pass := 1; ; pass++ {
+		passSources := make([]ImageSource, len(sources))
+		for i, src := range sources {
+			passSources[i] = src
+			if data[i] != nil {
+				passSources[i].Reader = io.NopCloser(bytes.NewReader(data[i]))
+			}
+		}
+		out.Reset()
+		*stats = Stats{SizePasses: pass}
+		hasContent, err = ConvertToPDFWithStats(ctx, passSources, &passCfg, &out, stats)
+		if err != nil {
+			return hasContent, err
+		}
+		size := int64(out.Len())
+		slog.Info("Finished target size pass", "pass", pass, "bytes", size, "targetBytes", cfg.TargetSize, "jpegQuality", passCfg.JPEGQuality, "scale", passCfg.sizePass.scale)
+		if size <= cfg.TargetSize {
+			if _, err := writer.Write(out.Bytes()); err != nil {
+				return hasContent, fmt.Errorf("could not write PDF to writer: %w", err)
+			}
+			return hasContent, nil
+		}
+		if pass == maxSizePasses {
+			return false, fmt.Errorf("%w: %d bytes after %d passes (target %d bytes)", ErrTargetSizeNotReached, size, pass, cfg.TargetSize)
+		}
+
+		passCfg.sizePass.reencodeJPEG = true
+		switch {
+		case passCfg.JPEGQuality > minTargetQuality && size > cfg.TargetSize*3/2:
+			// Far off: quality alone rarely gets there, so go straight to
+			// the lowest one and leave the remaining passes to resolution.
+			passCfg.JPEGQuality = minTargetQuality
+		case passCfg.JPEGQuality > minTargetQuality:
+			passCfg.JPEGQuality = max(minTargetQuality, passCfg.JPEGQuality-sizeQualityStep)
+		default:
+			// The output shrinks roughly with the pixel count, so the sides
+			// shrink with its square root, plus a little to land below it.
+			scale := passCfg.sizePass.scale
+			if scale == 0 {
+				scale = 1
+			}
+			passCfg.sizePass.scale = scale * math.Sqrt(float64(cfg.TargetSize)/float64(size)) * 0.95
+		}
+	}
+}
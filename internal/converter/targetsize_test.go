@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// noisyJPEGSource returns a JPEG of random noise, which only gets smaller
+// with lower quality or resolution.
+func noisyJPEGSource(t *testing.T, w, h, index int) ImageSource {
+	t.Helper()
+	rng := rand.New(rand.NewSource(int64(index)))
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return ImageSource{OriginalFilename: "noise.jpg", Reader: io.NopCloser(&buf), ContentType: "image/jpeg", Index: index}
+}
+
+func TestConvertToPDF_TargetSize(t *testing.T) {
+	sources := func() []ImageSource {
+		return []ImageSource{noisyJPEGSource(t, 400, 600, 0), noisyJPEGSource(t, 400, 600, 1)}
+	}
+	var full bytes.Buffer
+	if _, err := ConvertToPDF(context.Background(), sources(), NewDefaultConfig(), &full); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []int64{int64(full.Len()) * 2, int64(full.Len()) * 3 / 4, int64(full.Len()) / 5} {
+		cfg := NewDefaultConfig()
+		cfg.TargetSize = target
+		var out bytes.Buffer
+		stats := &Stats{}
+		if _, err := ConvertToPDFWithStats(context.Background(), sources(), cfg, &out, stats); err != nil {
+			t.Fatalf("Target %d: %v", target, err)
+		}
+		if int64(out.Len()) > target || stats.OutputBytes != int64(out.Len()) {
+			t.Errorf("Target %d: got %d bytes (stats say %d)", target, out.Len(), stats.OutputBytes)
+		}
+		if target > int64(full.Len()) && stats.SizePasses != 1 {
+			t.Errorf("Expected an output under the target to take one pass, took %d", stats.SizePasses)
+		}
+		if target < int64(full.Len()) && stats.SizePasses < 2 {
+			t.Errorf("Target %d: expected several passes, got %d", target, stats.SizePasses)
+		}
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.TargetSize = 100
+	_, err := ConvertToPDF(context.Background(), sources(), cfg, &bytes.Buffer{})
+	if !errors.Is(err, ErrTargetSizeNotReached) {
+		t.Errorf("Expected ErrTargetSizeNotReached for an impossible target, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log/slog"
+	"math"
+)
+
+// ErrInvalidMaxSize is returned for a negative Config.MaxWidth or
+// Config.MaxHeight.
+var ErrInvalidMaxSize = errors.New("invalid max page size")
+
+// downscaledSize returns the size a width x height page is scaled down to
+// so it fits cfg.MaxWidth and cfg.MaxHeight, and is scaled by the current
+// target size pass, keeping its aspect ratio. ok is false if the page fits
+// already.
+func (cfg *Config) downscaledSize(width, height int) (w, h int, ok bool) {
+	scale := 1.0
+	if cfg.sizePass.scale > 0 && cfg.sizePass.scale < 1 {
+		scale = cfg.sizePass.scale
+	}
+	if cfg.MaxWidth > 0 && width > cfg.MaxWidth {
+		scale = float64(cfg.MaxWidth) / float64(width)
+	}
+	if cfg.MaxHeight > 0 && height > cfg.MaxHeight {
+		scale = min(scale, float64(cfg.MaxHeight)/float64(height))
+	}
+	if scale == 1 {
+		return width, height, false
+	}
+	return max(1, int(math.Round(float64(width)*scale))), max(1, int(math.Round(float64(height)*scale))), true
+}
+
+// transformSource applies the page transforms set in cfg to a decoded
+// source: alpha flattening, auto crop, conversion to grayscale, downscaling
+// to the maximum page size, auto levels, then adjustments. Pass-through
+// data is only decoded when a transform may change it, and stays
+// pass-through if none did.
+func transformSource(cfg *Config, decoded decodedSource) (decodedSource, error) {
+	flatten := !cfg.KeepAlpha && mayHaveAlpha(decoded)
+	if !flatten && !cfg.Grayscale && cfg.AutoLevels == nil && cfg.AutoCrop == nil && !cfg.Adjust.active() && !cfg.Colour.active() && cfg.MaxWidth <= 0 && cfg.MaxHeight <= 0 && cfg.sizePass.scale == 0 {
+		return decoded, nil
+	}
+	img := decoded.Image
+	if decoded.Raw != nil {
+		_, _, oversized := cfg.downscaledSize(int(decoded.Width), int(decoded.Height))
+		if !flatten && !oversized && cfg.AutoLevels == nil && cfg.AutoCrop == nil && !cfg.Adjust.active() && (!cfg.Grayscale || isGrayData(decoded.Raw)) && (!cfg.Colour.active() || isGrayData(decoded.Raw)) {
+			return decoded, nil
+		}
+		var err error
+		if img, _, err = image.Decode(bytes.NewReader(decoded.Raw)); err != nil {
+			return decoded, fmt.Errorf("could not decode %s to transform it: %w", decoded.OriginalFilename, err)
+		}
+	}
+
+	changed := false
+	if flatten {
+		if flat, ok := flattenAlpha(img, cfg.alphaBackground()); ok {
+			slog.Debug("Flattened transparent page", "filename", decoded.OriginalFilename, "background", cfg.alphaBackground())
+			img, changed = flat, true
+			decoded.Transforms = append(decoded.Transforms, "flatten")
+		}
+	}
+	if cfg.AutoCrop != nil {
+		if cropped, ok := cfg.AutoCrop.apply(img); ok {
+			slog.Debug("Cropped page margins", "filename", decoded.OriginalFilename, "width", img.Bounds().Dx(), "height", img.Bounds().Dy(), "newWidth", cropped.Bounds().Dx(), "newHeight", cropped.Bounds().Dy())
+			img, changed = cropped, true
+			decoded.Transforms = append(decoded.Transforms, "auto-crop")
+		}
+	}
+	if _, gray := img.(*image.Gray); cfg.Grayscale && !gray {
+		img, changed = toGray(img), true
+		decoded.Transforms = append(decoded.Transforms, "grayscale")
+	}
+	if w, h, ok := cfg.downscaledSize(img.Bounds().Dx(), img.Bounds().Dy()); ok {
+		slog.Debug("Downscaling page", "filename", decoded.OriginalFilename, "width", img.Bounds().Dx(), "height", img.Bounds().Dy(), "newWidth", w, "newHeight", h)
+		decoded.DPI *= float64(w) / float64(img.Bounds().Dx())
+		img, changed = cfg.resizePage(img, w, h), true
+		decoded.Transforms = append(decoded.Transforms, "downscale")
+	}
+	if cfg.AutoLevels != nil {
+		if adjusted, ok := cfg.AutoLevels.apply(img); ok {
+			img, changed = adjusted, true
+			decoded.Transforms = append(decoded.Transforms, "auto-levels")
+		}
+	}
+	if adjusted, ok := cfg.Adjust.apply(img); ok {
+		img, changed = adjusted, true
+		decoded.Transforms = append(decoded.Transforms, "adjust")
+	}
+	if tuned, ok := cfg.Colour.apply(img); ok {
+		img, changed = tuned, true
+		decoded.Transforms = append(decoded.Transforms, "colour")
+	}
+	if changed {
+		decoded.Image, decoded.Raw = img, nil
+	}
+	return decoded, nil
+}
+
+// isGrayData reports whether JPEG or PNG data is grayscale already.
+func isGrayData(data []byte) bool {
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	return err == nil && imgConfig.ColorModel == color.GrayModel
+}
+
+// toGray converts img to grayscale. Transparent areas become white, like
+// the paper they would show on.
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Over)
+	return gray
+}
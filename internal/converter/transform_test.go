@@ -0,0 +1,64 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestDownscaledSize(t *testing.T) {
+	for _, tc := range []struct {
+		maxWidth, maxHeight int
+		width, height       int
+		w, h                int
+		ok                  bool
+	}{
+		{0, 0, 6000, 9000, 6000, 9000, false},
+		{2000, 0, 6000, 9000, 2000, 3000, true},
+		{0, 3000, 6000, 9000, 2000, 3000, true},
+		{2000, 2000, 6000, 9000, 1333, 2000, true}, // The tighter side wins
+		{2000, 3000, 1500, 2000, 1500, 2000, false},
+	} {
+		cfg := &Config{MaxWidth: tc.maxWidth, MaxHeight: tc.maxHeight}
+		w, h, ok := cfg.downscaledSize(tc.width, tc.height)
+		if w != tc.w || h != tc.h || ok != tc.ok {
+			t.Errorf("%dx%d within %dx%d: expected %dx%d (%v), got %dx%d (%v)", tc.width, tc.height, tc.maxWidth, tc.maxHeight, tc.w, tc.h, tc.ok, w, h, ok)
+		}
+	}
+}
+
+func TestProcessImage_MaxSize(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.MaxWidth, cfg.MaxHeight = 100, 100
+
+	page, err := ProcessImage(context.Background(), cfg, pngSource(t, 400, 200, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Width != 100 || page.Height != 50 || page.PassedThrough {
+		t.Errorf("Expected an oversized page re-encoded at 100x50, got %dx%d (passed through: %v)", page.Width, page.Height, page.PassedThrough)
+	}
+	img, err := png.Decode(bytes.NewReader(page.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("Expected a grayscale page to stay grayscale, got %T", img)
+	}
+
+	page, err = ProcessImage(context.Background(), cfg, pngSource(t, 80, 100, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.PassedThrough {
+		t.Error("Expected a page within the maximum size to be passed through")
+	}
+
+	cfg.MaxWidth = -1
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidMaxSize) {
+		t.Errorf("Expected ErrInvalidMaxSize, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package converter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Watermark positions.
+const (
+	PositionCenter      = "center"
+	PositionTop         = "top"
+	PositionBottom      = "bottom"
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+)
+
+// defaultWatermarkOpacity is used when Watermark.Opacity is 0.
+const defaultWatermarkOpacity = 0.3
+
+// ErrInvalidWatermark is returned for a watermark without text or image, or
+// with an unknown position or out-of-range opacity.
+var ErrInvalidWatermark = errors.New("invalid watermark")
+
+// Watermark is text or an image stamped on the pages of a PDF, e.g. to mark
+// review or personalised copies.
+type Watermark struct {
+	Text          string  `json:"text,omitempty"`
+	Image         []byte  `json:"image,omitempty"`    // PNG or JPEG data (base64 in JSON); used instead of Text
+	Position      string  `json:"position,omitempty"` // One of the Position constants; default PositionCenter
+	Opacity       float64 `json:"opacity,omitempty"`  // 0 < Opacity <= 1; 0 means 0.3
+	FirstPageOnly bool    `json:"first_page_only,omitempty"`
+}
+
+func (wm *Watermark) validate() error {
+	if wm.Text == "" && len(wm.Image) == 0 {
+		return fmt.Errorf("%w: text or image is required", ErrInvalidWatermark)
+	}
+	if len(wm.Image) > 0 {
+		if _, err := watermarkImageType(wm.Image); err != nil {
+			return err
+		}
+		// The header alone is sniffed above; a truncated or empty image
+		// would otherwise only fail once gofpdf reads it.
+		config, _, err := image.DecodeConfig(bytes.NewReader(wm.Image))
+		if err != nil {
+			return fmt.Errorf("%w: image: %v", ErrInvalidWatermark, err)
+		}
+		if config.Width == 0 || config.Height == 0 {
+			return fmt.Errorf("%w: image is empty", ErrInvalidWatermark)
+		}
+	}
+	switch wm.Position {
+	case "", PositionCenter, PositionTop, PositionBottom, PositionTopLeft, PositionTopRight, PositionBottomLeft, PositionBottomRight:
+	default:
+		return fmt.Errorf("%w: unknown position %q", ErrInvalidWatermark, wm.Position)
+	}
+	if wm.Opacity < 0 || wm.Opacity > 1 {
+		return fmt.Errorf("%w: opacity %v is not between 0 and 1", ErrInvalidWatermark, wm.Opacity)
+	}
+	return nil
+}
+
+func watermarkImageType(data []byte) (string, error) {
+	switch DetectContentType(data) {
+	case "image/png":
+		return "PNG", nil
+	case "image/jpeg":
+		return "JPG", nil
+	}
+	return "", fmt.Errorf("%w: image must be PNG or JPEG", ErrInvalidWatermark)
+}
+
+// watermarkStamper stamps a validated Watermark on pages.
+type watermarkStamper struct {
+	pdf       *gofpdf.Fpdf
+	wm        *Watermark
+	translate func(string) string
+	imageType string
+	stamped   bool
+}
+
+// newWatermarkStamper registers the image of wm, if any, with pdf. It fails
+// if gofpdf cannot read the image, rather than leaving the error on pdf
+// for the first page to be blamed for.
+func newWatermarkStamper(pdf *gofpdf.Fpdf, wm *Watermark) (*watermarkStamper, error) {
+	s := &watermarkStamper{pdf: pdf, wm: wm, translate: pdf.UnicodeTranslatorFromDescriptor("")}
+	if len(wm.Image) > 0 {
+		s.imageType, _ = watermarkImageType(wm.Image) // Checked by validate
+		pdf.RegisterImageOptionsReader("watermark", gofpdf.ImageOptions{ImageType: s.imageType}, bytes.NewReader(wm.Image))
+		if pdf.Err() {
+			return nil, fmt.Errorf("%w: could not register image: %v", ErrInvalidWatermark, pdf.Error())
+		}
+	}
+	return s, nil
+}
+
+// stamp draws the watermark on the current page, which is width by height
+// points, unless it is limited to the first page and already drawn. With
+// tagged it is marked as an artifact, outside the structure tree.
+func (s *watermarkStamper) stamp(width, height float64, tagged bool) {
+	if s.wm.FirstPageOnly && s.stamped {
+		return
+	}
+	s.stamped = true
+	opacity := s.wm.Opacity
+	if opacity == 0 {
+		opacity = defaultWatermarkOpacity
+	}
+	margin := width * 0.04
+
+	if tagged {
+		s.pdf.RawWriteStr("/Artifact BMC")
+	}
+	s.pdf.SetAlpha(opacity, "Normal")
+	if s.imageType != "" {
+		info := s.pdf.GetImageInfo("watermark")
+		w := width / 4
+		if s.position() == PositionCenter {
+			w = width / 2
+		}
+		h := w * info.Height() / info.Width()
+		x, y := s.place(width, height, w, h, margin)
+		s.pdf.ImageOptions("watermark", x, y, w, h, false, gofpdf.ImageOptions{ImageType: s.imageType}, 0, "")
+	} else {
+		fontSize := width / 30
+		if s.position() == PositionCenter {
+			fontSize = width / 12
+		}
+		text := s.translate(s.wm.Text)
+		s.pdf.SetFont("Helvetica", "B", fontSize)
+		s.pdf.SetTextColor(128, 128, 128)
+		w := s.pdf.GetStringWidth(text)
+		x, y := s.place(width, height, w, fontSize, margin)
+		s.pdf.Text(x, y+fontSize*0.8, text) // Text takes the baseline
+	}
+	s.pdf.SetAlpha(1, "Normal")
+	if tagged {
+		s.pdf.RawWriteStr("EMC")
+	}
+}
+
+func (s *watermarkStamper) position() string {
+	if s.wm.Position == "" {
+		return PositionCenter
+	}
+	return s.wm.Position
+}
+
+// place returns the top-left corner of a w by h box at the watermark's
+// position on a width by height page.
+func (s *watermarkStamper) place(width, height, w, h, margin float64) (x, y float64) {
+	x, y = (width-w)/2, (height-h)/2
+	switch s.position() {
+	case PositionTop, PositionTopLeft, PositionTopRight:
+		y = margin
+	case PositionBottom, PositionBottomLeft, PositionBottomRight:
+		y = height - h - margin
+	}
+	switch s.position() {
+	case PositionTopLeft, PositionBottomLeft:
+		x = margin
+	case PositionTopRight, PositionBottomRight:
+		x = width - w - margin
+	}
+	return x, y
+}
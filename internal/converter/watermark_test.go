@@ -0,0 +1,77 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+func TestWatermarkValidate(t *testing.T) {
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewGray(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	valid := []Watermark{
+		{Text: "Review copy"},
+		{Image: logo.Bytes(), Position: PositionBottomRight, Opacity: 1},
+	}
+	for _, wm := range valid {
+		if err := wm.validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", wm, err)
+		}
+	}
+	invalid := []Watermark{
+		{},
+		{Text: "x", Position: "middle"},
+		{Text: "x", Opacity: 1.5},
+		{Image: []byte("GIF89a")},
+		{Image: logo.Bytes()[:20]}, // A PNG signature without a complete header
+		{Image: []byte("\xff\xd8\xff\xe0")},
+	}
+	for _, wm := range invalid {
+		if err := wm.validate(); !errors.Is(err, ErrInvalidWatermark) {
+			t.Errorf("Expected ErrInvalidWatermark for %+v, got %v", wm, err)
+		}
+	}
+	cfg := &Config{OutputFormat: FormatEPUB, Watermark: &valid[0]}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidWatermark) {
+		t.Errorf("Expected watermarks to be refused for EPUB, got %v", err)
+	}
+
+	// gofpdf cannot embed 16-bit PNGs, which decode fine.
+	var deep bytes.Buffer
+	if err := png.Encode(&deep, image.NewGray16(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	if _, err := newWatermarkStamper(pdf, &Watermark{Image: deep.Bytes()}); !errors.Is(err, ErrInvalidWatermark) {
+		t.Errorf("Expected ErrInvalidWatermark for an image gofpdf cannot register, got %v", err)
+	}
+}
+
+func TestConvertToPDF_Watermark(t *testing.T) {
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewGray(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	for _, wm := range []*Watermark{
+		{Text: "Review copy – do not share"},
+		{Image: logo.Bytes(), Position: PositionTopLeft, FirstPageOnly: true},
+	} {
+		cfg := NewDefaultConfig()
+		cfg.Watermark = wm
+		cfg.Captions = map[string]string{"1": "caption"}
+		var out bytes.Buffer
+		if _, err := ConvertToPDF(context.Background(), []ImageSource{pngSource(t, 100, 100, 0), pngSource(t, 100, 50, 1)}, cfg, &out); err != nil {
+			t.Fatalf("Conversion with watermark %+v failed: %v", wm, err)
+		}
+		if !bytes.Contains(out.Bytes(), []byte("/ca 0.3")) {
+			t.Errorf("Expected the default watermark opacity in the PDF")
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"golang.org/x/image/webp"
+)
+
+// WebP re-encoding targets for Config.WebPTarget.
+const (
+	WebPTargetAuto = "auto" // PNG for lossless or transparent WebP, JPEG for lossy
+	WebPTargetPNG  = "png"
+	WebPTargetJPEG = "jpeg"
+)
+
+// ErrUnsupportedWebPTarget is returned for an unknown Config.WebPTarget.
+var ErrUnsupportedWebPTarget = errors.New("unsupported webp target")
+
+// webpHeaderPeek is how much of a WebP is inspected to tell lossless from
+// lossy. It covers the chunks that may precede the image data, such as an
+// ICC profile.
+const webpHeaderPeek = 8 << 10
+
+func validWebPTarget(target string) error {
+	switch target {
+	case "", WebPTargetAuto, WebPTargetPNG, WebPTargetJPEG:
+		return nil
+	}
+	return fmt.Errorf("%w %q (expected %q, %q or %q)", ErrUnsupportedWebPTarget, target, WebPTargetAuto, WebPTargetPNG, WebPTargetJPEG)
+}
+
+// webpPDFType returns the type a WebP whose leading bytes are header is
+// re-encoded to. Lossless WebP is mostly line art and text, which JPEG
+// would blur with ringing, so by default it becomes PNG, as does WebP with
+// transparency.
+func (cfg *Config) webpPDFType(header []byte) string {
+	switch cfg.WebPTarget {
+	case WebPTargetPNG:
+		return "PNG"
+	case WebPTargetJPEG:
+		return "JPG"
+	}
+	if webpLosslessOrAlpha(header) {
+		return "PNG"
+	}
+	return "JPG"
+}
+
+// webpLosslessOrAlpha reports whether the WebP starting with header holds a
+// lossless (VP8L) image or an alpha channel.
+func webpLosslessOrAlpha(header []byte) bool {
+	if len(header) < 16 || string(header[:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return false
+	}
+	alpha := false
+	for rest := header[12:]; len(rest) >= 8; {
+		switch string(rest[:4]) {
+		case "VP8L", "ALPH":
+			return true
+		case "VP8 ":
+			return false
+		case "VP8X":
+			// The extended format header's flags byte follows the chunk header.
+			alpha = len(rest) > 8 && rest[8]&0x10 != 0
+		}
+		size := uint64(binary.LittleEndian.Uint32(rest[4:8]))
+		size += size & 1 // Chunks are padded to an even size
+		if 8+size > uint64(len(rest)) {
+			break
+		}
+		rest = rest[8+size:]
+	}
+	// The image data lies beyond the peeked header; go by the alpha flag.
+	return alpha
+}
+
+// webpAnimated reports whether the WebP starting with header is an
+// animation, which golang.org/x/image/webp cannot decode.
+func webpAnimated(header []byte) bool {
+	// The extended format header must come first; its flags byte follows
+	// the chunk header.
+	return len(header) > 20 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP" &&
+		string(header[12:16]) == "VP8X" && header[20]&0x02 != 0
+}
+
+// decodeAnimatedWebP decodes the first frame of the animated WebP data,
+// placed on a canvas of the animation's size, as the first frame of a GIF
+// is. still is the frame as a WebP of its own, for webpPDFType.
+func decodeAnimatedWebP(data []byte) (img image.Image, still []byte, err error) {
+	var canvas image.Rectangle
+	for rest := data[12:]; len(rest) >= 8; {
+		size := uint64(binary.LittleEndian.Uint32(rest[4:8]))
+		if 8+size > uint64(len(rest)) {
+			break
+		}
+		body := rest[8 : 8+size]
+		switch string(rest[:4]) {
+		case "VP8X":
+			if len(body) < 10 {
+				return nil, nil, errors.New("webp: invalid VP8X chunk")
+			}
+			canvas = image.Rect(0, 0, 1+uint24(body[4:]), 1+uint24(body[7:]))
+		case "ANMF":
+			if len(body) < 16 {
+				return nil, nil, errors.New("webp: invalid ANMF chunk")
+			}
+			// The frame's offset (in units of 2 pixels) and size, then its
+			// image chunks: ALPH and VP8, or VP8L.
+			offset := image.Pt(2*uint24(body[0:]), 2*uint24(body[3:]))
+			width, height := 1+uint24(body[6:]), 1+uint24(body[9:])
+			frame := body[16:]
+			var chunks []byte
+			if bytes.HasPrefix(frame, []byte("ALPH")) {
+				// An alpha chunk is only valid in the extended format.
+				chunks = append([]byte("VP8X\x0a\x00\x00\x00\x10\x00\x00\x00"), putUint24(width-1)...)
+				chunks = append(chunks, putUint24(height-1)...)
+			}
+			chunks = append(chunks, frame...)
+			still = append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(4+len(chunks)))...)
+			still = append(append(still, "WEBP"...), chunks...)
+			img, err := webp.Decode(bytes.NewReader(still))
+			if err != nil {
+				return nil, nil, fmt.Errorf("first frame: %w", err)
+			}
+			if img.Bounds() == canvas {
+				return img, still, nil
+			}
+			// A frame smaller than the canvas leaves the rest transparent.
+			full := image.NewNRGBA(canvas)
+			draw.Draw(full, image.Rectangle{Min: offset, Max: offset.Add(img.Bounds().Size())}, img, img.Bounds().Min, draw.Src)
+			return full, still, nil
+		}
+		size += size & 1 // Chunks are padded to an even size
+		if 8+size > uint64(len(rest)) {
+			break
+		}
+		rest = rest[8+size:]
+	}
+	return nil, nil, errors.New("webp: animation without frames")
+}
+
+// uint24 reads the little-endian 24-bit number WebP headers use.
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// putUint24 writes v as a little-endian 24-bit number.
+func putUint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
@@ -0,0 +1,65 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebPLosslessOrAlpha(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"lossy", "RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00", false},
+		{"lossless", "RIFF\x24\x00\x00\x00WEBPVP8L\x18\x00\x00\x00", true},
+		{"extended lossy", "RIFF\x24\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00VP8 \x18\x00\x00\x00", false},
+		{"extended with alpha", "RIFF\x24\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00\x00\x00ALPH\x18\x00\x00\x00", true},
+		{"alpha flag, image data not peeked", "RIFF\x24\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00\x00\x00ICCP\xff\xff\x00\x00", true},
+		{"not webp", "RIFF\x24\x00\x00\x00WAVEfmt ", false},
+	}
+	for _, tt := range tests {
+		if got := webpLosslessOrAlpha([]byte(tt.header)); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestProcessImage_WebPTarget(t *testing.T) {
+	tests := []struct {
+		file, target, format string
+	}{
+		{"lossless.webp", "", "png"},
+		{"lossy.webp", "", "jpeg"},
+		{"lossless.webp", WebPTargetJPEG, "jpeg"},
+		{"lossy.webp", WebPTargetPNG, "png"},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, contentType := range []string{"image/webp", "application/octet-stream"} {
+			cfg := NewDefaultConfig()
+			cfg.WebPTarget = tt.target
+			src := ImageSource{OriginalFilename: tt.file, Reader: io.NopCloser(bytes.NewReader(data)), ContentType: contentType}
+			page, err := ProcessImage(context.Background(), cfg, src)
+			if err != nil {
+				t.Fatalf("%s (%s): %v", tt.file, contentType, err)
+			}
+			if page.Format != tt.format {
+				t.Errorf("%s with target %q (%s): expected %s, got %s", tt.file, tt.target, contentType, tt.format, page.Format)
+			}
+		}
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.WebPTarget = "gif"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an unknown WebP target to be rejected")
+	}
+}
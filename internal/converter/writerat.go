@@ -0,0 +1,68 @@
+package converter
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	// writeAtChunkSize is the size of the pieces the PDF is split into for
+	// WriteAt outputs; it matches common S3 multipart part sizes.
+	writeAtChunkSize = 8 << 20
+	// writeAtParallelism is how many chunks are written at once.
+	writeAtParallelism = 4
+)
+
+// ConvertToPDFAt is ConvertToPDFWithStats for seekable outputs such as local
+// files or multipart object uploads. The PDF is written from offset 0 in
+// chunks, several at a time, through WriteAt. Output must allow concurrent
+// WriteAt calls on disjoint ranges (*os.File does). Use ConvertToPDF or
+// ConvertToPDFWithStats for streaming outputs like HTTP responses.
+func ConvertToPDFAt(ctx context.Context, sources []ImageSource, cfg *Config, output io.WriterAt, stats *Stats) (hasContent bool, err error) {
+	return ConvertToPDFWithStats(ctx, sources, cfg, &chunkedWriterAt{w: output}, stats)
+}
+
+// chunkedWriterAt turns Write calls into parallel WriteAt calls. gofpdf hands
+// over the whole document in a single Write, so each call is split into
+// writeAtChunkSize pieces written by up to writeAtParallelism goroutines.
+type chunkedWriterAt struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (c *chunkedWriterAt) Write(p []byte) (int, error) {
+	if len(p) <= writeAtChunkSize {
+		n, err := c.w.WriteAt(p, c.off)
+		c.off += int64(n)
+		return n, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		slots    = make(chan struct{}, writeAtParallelism)
+	)
+	for start := 0; start < len(p); start += writeAtChunkSize {
+		end := min(start+writeAtChunkSize, len(p))
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(chunk []byte, off int64) {
+			defer func() { <-slots; wg.Done() }()
+			if _, err := c.w.WriteAt(chunk, off); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(p[start:end], c.off+int64(start))
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	c.off += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,60 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// memWriterAt is an in-memory io.WriterAt safe for concurrent writes.
+type memWriterAt struct {
+	mu   sync.Mutex
+	data []byte
+	err  error
+}
+
+func (m *memWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return 0, m.err
+	}
+	if end := int(off) + len(p); end > len(m.data) {
+		m.data = append(m.data, make([]byte, end-len(m.data))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func TestChunkedWriterAt(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), (3*writeAtChunkSize+100)/16)
+	var out memWriterAt
+	w := &chunkedWriterAt{w: &out}
+	if _, err := w.Write([]byte("head")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := w.Write(payload); err != nil || n != len(payload) {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	if !bytes.Equal(out.data, append([]byte("head"), payload...)) {
+		t.Error("Chunked output does not match the input")
+	}
+
+	failing := &chunkedWriterAt{w: &memWriterAt{err: errors.New("disk full")}}
+	if _, err := failing.Write(payload); err == nil {
+		t.Error("Expected the WriteAt error to be returned")
+	}
+}
+
+func TestConvertToPDFAt(t *testing.T) {
+	var out memWriterAt
+	var stats Stats
+	hasContent, err := ConvertToPDFAt(context.Background(), []ImageSource{pngSource(t, 10, 10, 0)}, NewDefaultConfig(), &out, &stats)
+	if err != nil || !hasContent {
+		t.Fatalf("Expected content, got %v, %v", hasContent, err)
+	}
+	if !bytes.HasPrefix(out.data, []byte("%PDF-")) || int64(len(out.data)) != stats.OutputBytes {
+		t.Errorf("Expected a %d byte PDF, got %d bytes", stats.OutputBytes, len(out.data))
+	}
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsignedPayload lets requests be signed without hashing the body first,
+// so objects can be streamed.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// maxPresignExpiry is the longest validity S3 accepts for a presigned URL.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// Credentials are the AWS access keys used to sign S3 requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Only for temporary credentials
+}
+
+// S3 stores artifacts in an S3 bucket, or any store speaking the S3 API
+// (MinIO, R2, ...). Requests are signed with AWS Signature Version 4, and
+// downloads are offered as presigned URLs so clients fetch outputs from the
+// bucket directly.
+type S3 struct {
+	Bucket      string
+	Prefix      string // Prepended to every key, e.g. "jobs/"
+	Region      string
+	Endpoint    string // Base URL of an S3-compatible service, addressed path-style; empty for AWS
+	Credentials Credentials
+	Client      *http.Client // nil means http.DefaultClient
+
+	now func() time.Time // Replaced in tests
+}
+
+// NewS3 returns an S3 storing under location, given as "s3://bucket" or
+// "s3://bucket/prefix".
+func NewS3(location, endpoint, region string, creds Credentials) (*S3, error) {
+	rest, ok := strings.CutPrefix(location, "s3://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return nil, fmt.Errorf("invalid S3 location %q (expected s3://bucket/prefix)", location)
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 storage needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3{Bucket: bucket, Prefix: prefix, Region: region, Endpoint: strings.TrimSuffix(endpoint, "/"), Credentials: creds}, nil
+}
+
+// objectURL returns the URL of the object stored under key.
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	path := uriEncode(s.Prefix+key, false)
+	if s.Endpoint != "" {
+		return url.Parse(s.Endpoint + "/" + uriEncode(s.Bucket, true) + "/" + path)
+	}
+	host := "s3." + s.Region + ".amazonaws.com"
+	if s.Region == "us-east-1" {
+		host = "s3.amazonaws.com"
+	}
+	return url.Parse("https://" + s.Bucket + "." + host + "/" + path)
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req)
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, contentType)
+	if err != nil {
+		return fmt.Errorf("could not store %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not store %s: %w", key, s3Error(resp))
+	}
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", key, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	defer resp.Body.Close()
+	return nil, fmt.Errorf("could not read %s: %w", key, s3Error(resp))
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return fmt.Errorf("could not delete %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return fmt.Errorf("could not delete %s: %w", key, s3Error(resp))
+}
+
+// DownloadURL returns a presigned GET URL that makes browsers save the
+// object as filename.
+func (s *S3) DownloadURL(ctx context.Context, key, filename string, expiry time.Duration) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	if filename != "" {
+		q := u.Query()
+		q.Set("response-content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		u.RawQuery = q.Encode()
+	}
+	return s.presign(u, min(expiry, maxPresignExpiry)), nil
+}
+
+// s3Error describes a failed S3 response, including the start of the XML
+// error document.
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (s *S3) timestamp() time.Time {
+	if s.now != nil {
+		return s.now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s *S3) scope(date string) string {
+	return date + "/" + s.Region + "/s3/aws4_request"
+}
+
+// sign adds the Signature Version 4 Authorization header to req, covering
+// the host and every x-amz-* header.
+func (s *S3) sign(req *http.Request) {
+	amzDate := s.timestamp().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if s.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.Credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	signature := s.signature(amzDate, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.Credentials.AccessKeyID, s.scope(amzDate[:8]), signedHeaders, signature))
+}
+
+// presign returns u with Signature Version 4 query parameters valid for
+// expiry.
+func (s *S3) presign(u *url.URL, expiry time.Duration) string {
+	amzDate := s.timestamp().Format("20060102T150405Z")
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.Credentials.AccessKeyID+"/"+s.scope(amzDate[:8]))
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if s.Credentials.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.Credentials.SessionToken)
+	}
+	query := canonicalQuery(q)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		query,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signed := *u
+	signed.RawQuery = query + "&X-Amz-Signature=" + s.signature(amzDate, canonicalRequest)
+	return signed.String()
+}
+
+// signature signs canonicalRequest with the key derived for the date of
+// amzDate.
+func (s *S3) signature(amzDate, canonicalRequest string) string {
+	date := amzDate[:8]
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + s.scope(date) + "\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+s.Credentials.SecretAccessKey), date)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key and value, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but the RFC 3986 unreserved
+// characters, and '/' unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
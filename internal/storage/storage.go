@@ -0,0 +1,144 @@
+// Package storage keeps finished conversion outputs (job artifacts) outside
+// the server's memory: on local disk or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no object is stored under a key.
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores artifacts under keys such as "<tenant>/<job id>.pdf":
+// names separated by slashes, none of them empty, "." or "..".
+type Storage interface {
+	// Put stores size bytes from r under key, replacing any previous object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the object stored under key, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// DownloadURL returns a URL clients can fetch the object from directly,
+	// valid for at least expiry and offering it as filename, or "" if the
+	// backend has no such URL and the server has to send the object itself.
+	DownloadURL(ctx context.Context, key, filename string, expiry time.Duration) (string, error)
+}
+
+// Pruner is implemented by a Storage that can find its objects without
+// being told their keys, so the objects of a server that stopped before
+// deleting them can be cleaned up.
+type Pruner interface {
+	// Prune removes the objects stored before cutoff and returns how many
+	// it removed.
+	Prune(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// Local stores artifacts as files in a directory.
+type Local struct {
+	Dir string
+}
+
+// NewLocal returns a Local storing into dir, creating it if needed.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create storage directory: %w", err)
+	}
+	return &Local{Dir: dir}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	for _, name := range strings.Split(key, "/") {
+		if name == "" || strings.Contains(name, `\`) || name == "." || name == ".." {
+			return "", fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file first, so a partially written
+// object is never visible under key.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not store %s: %w", key, err)
+	}
+	tmp, err := os.CreateTemp(l.Dir, ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not store %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not store %s: %w", key, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return file, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DownloadURL returns "": local files are served by the server.
+func (l *Local) DownloadURL(ctx context.Context, key, filename string, expiry time.Duration) (string, error) {
+	return "", nil
+}
+
+// Prune removes the files stored before cutoff, partially written ones
+// included.
+func (l *Local) Prune(ctx context.Context, cutoff time.Time) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(l.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // Deleted meanwhile
+		}
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
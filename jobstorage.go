@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"manga_to_pdf/internal/storage"
+)
+
+// openJobStorage returns the backend selected by cfg.JobStorage for /jobs
+// outputs, or nil to keep them in memory. S3 credentials come from the
+// standard AWS environment variables, so they never appear in the config.
+func openJobStorage(cfg Config, getenv func(string) string) (storage.Storage, error) {
+	switch {
+	case cfg.JobStorage == "" || cfg.JobStorage == "memory":
+		return nil, nil
+	case cfg.JobStorage == "local":
+		local, err := storage.NewLocal(jobStorageDir(cfg))
+		if err != nil {
+			return nil, err
+		}
+		return local, nil
+	case strings.HasPrefix(cfg.JobStorage, "s3://"):
+		s3, err := storage.NewS3(cfg.JobStorage, cfg.S3Endpoint, cfg.S3Region, storage.Credentials{
+			AccessKeyID:     getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    getenv("AWS_SESSION_TOKEN"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	}
+	return nil, fmt.Errorf("unknown job storage %q (expected memory, local or s3://bucket/prefix)", cfg.JobStorage)
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// libraryStateFile is the library's conversion history, kept in the library
+// root unless -state says otherwise.
+const libraryStateFile = ".manga_to_pdf-library.json"
+
+// seriesConfigFile holds the per-series settings, in the series directory.
+const seriesConfigFile = ".manga_to_pdf-series.json"
+
+// seriesConfig is the per-series configuration of a library. Unset fields
+// keep the library-wide settings.
+type seriesConfig struct {
+	Skip          bool   `json:"skip"`           // Leave the series out
+	Title         string `json:"title"`          // Series title used in volume titles (default: directory name)
+	Format        string `json:"format"`         // "pdf" or "epub"
+	RTL           bool   `json:"rtl"`            // Read right to left
+	Normalize     bool   `json:"normalize"`      // Scale pages to the volume's most common width
+	Tagged        bool   `json:"tagged"`         // Write tagged PDFs
+	Lang          string `json:"lang"`           // Document language
+	Author        string `json:"author"`         // Document author
+	Keywords      string `json:"keywords"`       // Document keywords
+	PageBookmarks bool   `json:"page_bookmarks"` // Add a bookmark for every page
+}
+
+// libraryState records, per volume, the source fingerprint it was last
+// converted from and where the output went.
+type libraryState struct {
+	Volumes map[string]libraryRecord `json:"volumes"` // Keyed by the volume path relative to the library root
+}
+
+type libraryRecord struct {
+	Fingerprint string    `json:"fingerprint"`
+	Output      string    `json:"output"`
+	ConvertedAt time.Time `json:"converted_at"`
+}
+
+// libraryVolume is a volume found in the library: a directory of pages
+// (optionally in chapter subdirectories) or a CBZ/ZIP archive.
+type libraryVolume struct {
+	Key         string // Path relative to the library root, with forward slashes
+	Series      string // Series directory name
+	Name        string // Volume name, without an archive extension
+	Input       string
+	Fingerprint string
+}
+
+// libraryResult is the outcome of one volume in the summary report.
+type libraryResult struct {
+	Volume libraryVolume
+	Action string // "converted", "up to date", "would convert", "duplicate", "linked", "would link", "failed" or "skipped"
+	Output string
+	Err    error
+}
+
+// runLibraryCommand scans the library root named in args and converts every
+// volume without an up-to-date output, then prints a summary report to out.
+// It returns 1 if any volume failed and 2 on usage or scan errors.
+func runLibraryCommand(ctx context.Context, args []string, out, errOut io.Writer) int {
+	flagSet := flag.NewFlagSet("library", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: manga_to_pdf library [flags] ROOT")
+		fmt.Fprintln(errOut, "ROOT holds one directory per series, each holding one directory or CBZ/ZIP per volume.")
+		flagSet.PrintDefaults()
+	}
+	cfg := defaultConfig()
+	outputRoot := flagSet.String("o", "", "Directory for the outputs, mirroring ROOT's series directories (default: next to each volume)")
+	statePath := flagSet.String("state", "", "Conversion history file (default: ROOT/"+libraryStateFile+")")
+	dryRun := flagSet.Bool("dry-run", false, "Report what would be converted without converting")
+	force := flagSet.Bool("force", false, "Convert every volume, even those with an up-to-date output")
+	indexPath := flagSet.String("hash-index", "", "Content-hash index shared across runs and libraries, to find volumes converted before under another name (default: none)")
+	duplicates := flagSet.String("duplicates", duplicatesSkip, "What to do with a volume found in -hash-index: skip, or link its output to the original's")
+	flagSet.StringVar(&cfg.Format, "format", "", "Default output format: pdf or epub")
+	flagSet.IntVar(&cfg.Workers, "workers", cfg.Workers, "Image workers per conversion")
+	flagSet.BoolVar(&cfg.Lenient, "lenient", false, "Skip thumbnails, OS metadata and empty files")
+	flagSet.StringVar(&cfg.Profile, "profile", "", "Device profile for every volume: "+profileNames())
+	flagSet.StringVar(&cfg.PreCmd, "pre-cmd", "", "Shell command to run before each volume's conversion")
+	flagSet.StringVar(&cfg.PostCmd, "post-cmd", "", "Shell command to run after each volume's conversion")
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return 2
+	}
+	if *duplicates != duplicatesSkip && *duplicates != duplicatesLink {
+		fmt.Fprintf(errOut, "library: invalid -duplicates %q: must be %s or %s\n", *duplicates, duplicatesSkip, duplicatesLink)
+		return 2
+	}
+	root := filepath.Clean(flagSet.Arg(0))
+	if *statePath == "" {
+		*statePath = filepath.Join(root, libraryStateFile)
+	}
+
+	state, err := loadLibraryState(*statePath)
+	if err != nil {
+		fmt.Fprintln(errOut, "library:", err)
+		return 2
+	}
+	series, err := scanLibrary(root)
+	if err != nil {
+		fmt.Fprintln(errOut, "library:", err)
+		return 2
+	}
+	var index *hashIndex
+	if *indexPath != "" {
+		if index, err = loadHashIndex(*indexPath); err != nil {
+			fmt.Fprintln(errOut, "library:", err)
+			return 2
+		}
+	}
+
+	var results []libraryResult
+scan:
+	for _, s := range series {
+		for _, vol := range s.volumes {
+			result := libraryResult{Volume: vol, Output: libraryOutput(*outputRoot, vol, s.config, cfg)}
+			record, seen := state.Volumes[vol.Key]
+			var hash string
+			var original indexedOutput
+			var duplicate bool
+			if index != nil && !s.config.Skip {
+				if hash, result.Err = index.hash(cfg, vol); result.Err == nil {
+					original, duplicate = index.original(vol, hash)
+				}
+			}
+			switch {
+			case s.config.Skip:
+				result.Action = "skipped"
+			case result.Err != nil:
+				result.Action = "failed"
+				slog.Error("Could not index volume", "volume", vol.Key, "error", result.Err)
+			case !*force && seen && record.Fingerprint == vol.Fingerprint && fileExists(record.Output):
+				result.Action, result.Output = "up to date", record.Output
+			case duplicate && *duplicates == duplicatesSkip:
+				result.Action, result.Output = "duplicate", original.Output
+			case duplicate && *dryRun:
+				result.Action, result.Output = "would link", original.Output
+			case duplicate:
+				if result.Err = linkOutput(result.Output, original.Output); result.Err != nil {
+					result.Action = "failed"
+					slog.Error("Could not link duplicate volume", "volume", vol.Key, "error", result.Err)
+					break
+				}
+				result.Action = "linked"
+			case *dryRun:
+				result.Action = "would convert"
+			default:
+				result.Err = convertLibraryVolume(ctx, cfg, s.config, vol, result.Output)
+				if result.Err != nil {
+					result.Action = "failed"
+					slog.Error("Could not convert volume", "volume", vol.Key, "error", result.Err)
+					break
+				}
+				result.Action = "converted"
+				state.Volumes[vol.Key] = libraryRecord{Fingerprint: vol.Fingerprint, Output: result.Output, ConvertedAt: time.Now().UTC()}
+				if index != nil {
+					index.record(vol, hash, result.Output)
+				}
+				// Saved after every volume so an interrupted run keeps its progress.
+				if err := saveLibraryState(*statePath, state); err != nil {
+					fmt.Fprintln(errOut, "library:", err)
+					return 2
+				}
+			}
+			if index != nil && result.Action == "up to date" {
+				index.record(vol, hash, result.Output) // Indexes libraries converted before the index
+			}
+			results = append(results, result)
+			if converter.CancellationReason(result.Err) != "" {
+				break scan
+			}
+		}
+	}
+
+	if index != nil && !*dryRun {
+		if err := saveHashIndex(*indexPath, index); err != nil {
+			fmt.Fprintln(errOut, "library:", err)
+			return 2
+		}
+	}
+	writeLibraryReport(out, root, results)
+	for _, result := range results {
+		if result.Err != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// librarySeries is a series directory and the volumes found in it.
+type librarySeries struct {
+	config  seriesConfig
+	volumes []libraryVolume
+}
+
+// scanLibrary lists the series directories of root in natural order, with
+// their configuration and volumes. A series directory that holds pages
+// directly is a single volume.
+func scanLibrary(root string) ([]librarySeries, error) {
+	names, err := naturalDirEntries(root)
+	if err != nil {
+		return nil, fmt.Errorf("could not read library: %w", err)
+	}
+	var series []librarySeries
+	for _, entry := range names {
+		if !entry.IsDir() || junkReason(entry.Name()) != "" {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		s := librarySeries{}
+		if s.config, err = loadSeriesConfig(dir); err != nil {
+			return nil, err
+		}
+		direct, err := volumeFingerprint(dir, false)
+		if err != nil {
+			return nil, err
+		}
+		if direct != "" {
+			s.volumes = append(s.volumes, libraryVolume{Key: entry.Name(), Series: entry.Name(), Name: entry.Name(), Input: dir, Fingerprint: direct})
+			series = append(series, s)
+			continue
+		}
+		volumes, err := naturalDirEntries(dir)
+		if err != nil {
+			return nil, fmt.Errorf("could not read series: %w", err)
+		}
+		for _, v := range volumes {
+			input := filepath.Join(dir, v.Name())
+			if junkReason(v.Name()) != "" || (!v.IsDir() && !isArchive(input)) {
+				continue
+			}
+			fingerprint, err := volumeFingerprint(input, true)
+			if err != nil {
+				return nil, err
+			}
+			if fingerprint == "" {
+				continue // No pages
+			}
+			s.volumes = append(s.volumes, libraryVolume{
+				Key:         entry.Name() + "/" + v.Name(),
+				Series:      entry.Name(),
+				Name:        strings.TrimSuffix(v.Name(), filepath.Ext(v.Name())),
+				Input:       input,
+				Fingerprint: fingerprint,
+			})
+		}
+		if len(s.volumes) > 0 {
+			series = append(series, s)
+		}
+	}
+	return series, nil
+}
+
+// naturalDirEntries returns the entries of dir in natural name order.
+func naturalDirEntries(dir string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]fs.DirEntry{}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name()] = entry
+		names = append(names, entry.Name())
+	}
+	names, _ = converter.NaturalOrder{}.Order(names)
+	sorted := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		sorted[i] = byName[name]
+	}
+	return sorted, nil
+}
+
+// volumeFingerprint summarises the pages of a volume (the number of image
+// files, their total size and latest modification time) so that changes to
+// the source are noticed. An archive is fingerprinted as a whole. It
+// returns "" when there are no pages; subdirectories are only included with
+// recursive.
+func volumeFingerprint(input string, recursive bool) (string, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().IsRegular() {
+		return fmt.Sprintf("archive:%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+	}
+	var files, size int64
+	var latest time.Time
+	err = filepath.WalkDir(input, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != input && (!recursive || junkReason(d.Name()) != "") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if converter.GetContentTypeFromFilename(d.Name()) == "" || junkReason(d.Name()) != "" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		size += info.Size()
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil || files == 0 {
+		return "", err
+	}
+	return fmt.Sprintf("dir:%d:%d:%d", files, size, latest.UnixNano()), nil
+}
+
+// loadSeriesConfig reads the series configuration of dir, if any.
+func loadSeriesConfig(dir string) (seriesConfig, error) {
+	var sc seriesConfig
+	configPath := filepath.Join(dir, seriesConfigFile)
+	data, err := os.ReadFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return sc, nil
+	}
+	if err != nil {
+		return sc, fmt.Errorf("could not read %s: %w", configPath, err)
+	}
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return sc, fmt.Errorf("could not parse %s: %w", configPath, err)
+	}
+	if _, _, err := (&converter.Config{OutputFormat: sc.Format}).OutputType(); err != nil {
+		return sc, fmt.Errorf("invalid %s: %w", configPath, err)
+	}
+	return sc, nil
+}
+
+// libraryOutput returns where the output of vol goes: next to the volume,
+// or under outputRoot in a directory named after the series.
+func libraryOutput(outputRoot string, vol libraryVolume, sc seriesConfig, cfg Config) string {
+	cfg.Format = cmp.Or(sc.Format, cfg.Format)
+	dir := filepath.Dir(vol.Input)
+	if outputRoot != "" {
+		dir = filepath.Join(outputRoot, filepath.Dir(filepath.FromSlash(vol.Key)))
+	}
+	return filepath.Join(dir, vol.Name+outputExt(cfg))
+}
+
+// convertLibraryVolume converts vol to output with the library settings in
+// cfg and the series settings in sc.
+func convertLibraryVolume(ctx context.Context, cfg Config, sc seriesConfig, vol libraryVolume, output string) error {
+	cfg.Input, cfg.Output = vol.Input, output
+	cfg.OnExists = "overwrite" // The library tracks its outputs; an outdated one is replaced
+	// Chapter subdirectories belong to the volume, except in a series
+	// directory that is itself the volume, where they are other volumes.
+	cfg.Recursive = vol.Key != vol.Series
+	cfg.Format = cmp.Or(sc.Format, cfg.Format)
+	cfg.RTL = sc.RTL
+	cfg.Normalize = sc.Normalize
+	cfg.Tagged = sc.Tagged
+	cfg.PageBookmarks = sc.PageBookmarks
+	cfg.Lang, cfg.Author, cfg.Keywords = sc.Lang, sc.Author, sc.Keywords
+	cfg.Title = cmp.Or(sc.Title, vol.Series)
+	if vol.Name != vol.Series {
+		cfg.Title += " - " + vol.Name
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+	return runApp(ctx, cfg)
+}
+
+// loadLibraryState reads the conversion history at path; a missing file is
+// an empty history.
+func loadLibraryState(path string) (*libraryState, error) {
+	state := &libraryState{Volumes: map[string]libraryRecord{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if state.Volumes == nil {
+		state.Volumes = map[string]libraryRecord{}
+	}
+	return state, nil
+}
+
+// saveLibraryState writes the conversion history to path, replacing the
+// previous file only once the new one is complete.
+func saveLibraryState(path string, state *libraryState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("could not save library state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not save library state: %w", err)
+	}
+	return nil
+}
+
+// writeLibraryReport prints one line per volume and the totals per action.
+func writeLibraryReport(out io.Writer, root string, results []libraryResult) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Action]++
+		detail := result.Output
+		if result.Err != nil {
+			detail = result.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", result.Action, result.Volume.Key, detail)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "%s: %d volumes", root, len(results))
+	for _, action := range []string{"converted", "would convert", "up to date", "duplicate", "linked", "would link", "skipped", "failed"} {
+		if counts[action] > 0 {
+			fmt.Fprintf(out, ", %d %s", counts[action], action)
+		}
+	}
+	fmt.Fprintln(out)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
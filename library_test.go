@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunLibraryCommand(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"Alpha/Vol 2/ch1", "Alpha/Vol 10", "Beta", "Gamma/Vol 1"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePNG(t, filepath.Join(root, "Alpha/Vol 2/ch1/001.png"))
+	writePNG(t, filepath.Join(root, "Alpha/Vol 10/001.png"))
+	writePNG(t, filepath.Join(root, "Beta/001.png")) // A single-volume series
+	writePNG(t, filepath.Join(root, "Gamma/Vol 1/001.png"))
+	if err := os.WriteFile(filepath.Join(root, "Gamma", seriesConfigFile), []byte(`{"skip": true}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) string {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		if code := runLibraryCommand(context.Background(), append(args, root), &out, &errOut); code != 0 {
+			t.Fatalf("library exited with %d: %s%s", code, out.String(), errOut.String())
+		}
+		return out.String()
+	}
+
+	report := run()
+	for _, output := range []string{"Alpha/Vol 2.pdf", "Alpha/Vol 10.pdf", "Beta.pdf"} {
+		if _, err := os.Stat(filepath.Join(root, output)); err != nil {
+			t.Errorf("Expected %s to be written: %v", output, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "Gamma/Vol 1.pdf")); err == nil {
+		t.Error("Expected the skipped series not to be converted")
+	}
+	if !strings.Contains(report, "4 volumes, 3 converted, 1 skipped") {
+		t.Errorf("Unexpected report:\n%s", report)
+	}
+	if strings.Index(report, "Alpha/Vol 2") > strings.Index(report, "Alpha/Vol 10") {
+		t.Errorf("Expected volumes in natural order:\n%s", report)
+	}
+
+	if report := run(); !strings.Contains(report, "3 up to date") {
+		t.Errorf("Expected a second run to convert nothing:\n%s", report)
+	}
+
+	writePNG(t, filepath.Join(root, "Alpha/Vol 10/002.png"))
+	if report := run("-dry-run"); !strings.Contains(report, "would convert  Alpha/Vol 10") || !strings.Contains(report, "1 would convert, 2 up to date") {
+		t.Errorf("Expected the changed volume to be picked up:\n%s", report)
+	}
+}
+
+func TestRunLibraryCommand_HashIndex(t *testing.T) {
+	root := t.TempDir()
+	index := filepath.Join(t.TempDir(), "index.json")
+	for _, dir := range []string{"Alpha/Vol 1", "Alpha/Vol 1 (repack)", "Beta/Chapter 1"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePNG(t, filepath.Join(root, "Alpha/Vol 1/001.png"))
+	writePNG(t, filepath.Join(root, "Alpha/Vol 1 (repack)/page-a.png")) // Same content, other names
+	writePNG(t, filepath.Join(root, "Beta/Chapter 1/001.png"))
+	writePNG(t, filepath.Join(root, "Beta/Chapter 1/002.png"))
+
+	run := func(args ...string) string {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		if code := runLibraryCommand(context.Background(), append(args, root), &out, &errOut); code != 0 {
+			t.Fatalf("library exited with %d: %s%s", code, out.String(), errOut.String())
+		}
+		return out.String()
+	}
+
+	report := run("-hash-index", index)
+	if !strings.Contains(report, "3 volumes, 2 converted, 1 duplicate") || !strings.Contains(report, "duplicate  Alpha/Vol 1 (repack)") {
+		t.Errorf("Expected the repacked volume to be skipped:\n%s", report)
+	}
+	if _, err := os.Stat(filepath.Join(root, "Alpha/Vol 1 (repack).pdf")); err == nil {
+		t.Error("Expected no output for the duplicate")
+	}
+
+	report = run("-hash-index", index, "-duplicates", "link")
+	if !strings.Contains(report, "2 up to date, 1 linked") {
+		t.Errorf("Expected the duplicate to be linked:\n%s", report)
+	}
+	target, err := os.Readlink(filepath.Join(root, "Alpha/Vol 1 (repack).pdf"))
+	if err != nil || filepath.Base(target) != "Vol 1.pdf" {
+		t.Errorf("Expected a link to the original output, got %q: %v", target, err)
+	}
+
+	var out, errOut bytes.Buffer
+	if code := runLibraryCommand(context.Background(), []string{"-duplicates", "merge", root}, &out, &errOut); code != 2 {
+		t.Errorf("Expected an invalid -duplicates to exit with 2, got %d", code)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// hashIndex is the library's optional content-hash index (-hash-index). It
+// knows which volume first produced an output for each page content, so a
+// chapter or volume that turns up again under another name, in another
+// series or in another library sharing the index, is recognised as a
+// duplicate instead of being converted a second time.
+type hashIndex struct {
+	Inputs  map[string]hashedInput   `json:"inputs"`  // Keyed by the absolute volume path
+	Outputs map[string]indexedOutput `json:"outputs"` // Keyed by content hash
+}
+
+// hashedInput caches the content hash of a volume as long as its
+// fingerprint is unchanged, so pages are only read again after a change.
+type hashedInput struct {
+	Fingerprint string `json:"fingerprint"`
+	Hash        string `json:"hash"`
+}
+
+// indexedOutput is the volume a content hash was first converted from.
+type indexedOutput struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+}
+
+// Duplicate handling of the library's -duplicates flag.
+const (
+	duplicatesSkip = "skip" // Leave the duplicate without output
+	duplicatesLink = "link" // Symlink its output to the original's
+)
+
+// loadHashIndex reads the index at path; a missing file is an empty index.
+func loadHashIndex(path string) (*hashIndex, error) {
+	index := &hashIndex{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, index); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+	}
+	if index.Inputs == nil {
+		index.Inputs = map[string]hashedInput{}
+	}
+	if index.Outputs == nil {
+		index.Outputs = map[string]indexedOutput{}
+	}
+	return index, nil
+}
+
+// saveHashIndex writes the index to path, replacing the previous file only
+// once the new one is complete.
+func saveHashIndex(path string, index *hashIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("could not save hash index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not save hash index: %w", err)
+	}
+	return nil
+}
+
+// hash returns the content hash of vol, reading its pages as selected by
+// the library settings in cfg unless the index has one for the volume's
+// current fingerprint.
+func (x *hashIndex) hash(cfg Config, vol libraryVolume) (string, error) {
+	input, err := filepath.Abs(vol.Input)
+	if err != nil {
+		return "", err
+	}
+	if cached, ok := x.Inputs[input]; ok && cached.Fingerprint == vol.Fingerprint {
+		return cached.Hash, nil
+	}
+	hash, err := volumeContentHash(cfg, vol)
+	if err != nil {
+		return "", fmt.Errorf("could not hash pages: %w", err)
+	}
+	x.Inputs[input] = hashedInput{Fingerprint: vol.Fingerprint, Hash: hash}
+	return hash, nil
+}
+
+// original returns the output of the other volume that hash was converted
+// from, if it still exists.
+func (x *hashIndex) original(vol libraryVolume, hash string) (indexedOutput, bool) {
+	input, _ := filepath.Abs(vol.Input) // Resolved by hash before
+	entry, ok := x.Outputs[hash]
+	if !ok || entry.Input == input || !fileExists(entry.Output) {
+		return indexedOutput{}, false
+	}
+	return entry, true
+}
+
+// record makes vol, converted to output, the original of hash unless
+// another volume's output for it still exists.
+func (x *hashIndex) record(vol libraryVolume, hash, output string) {
+	if _, taken := x.original(vol, hash); taken {
+		return
+	}
+	input, _ := filepath.Abs(vol.Input)
+	if abs, err := filepath.Abs(output); err == nil {
+		output = abs // Other libraries sharing the index run elsewhere
+	}
+	x.Outputs[hash] = indexedOutput{Input: input, Output: output}
+}
+
+// volumeContentHash hashes the contents of the pages of vol in reading
+// order, ignoring their names, so a volume renamed or repacked with other
+// page names hashes the same.
+func volumeContentHash(cfg Config, vol libraryVolume) (string, error) {
+	sum := sha256.New()
+	page := sha256.New()
+	add := func(r io.Reader) error {
+		page.Reset()
+		if _, err := io.Copy(page, r); err != nil {
+			return err
+		}
+		sum.Write(page.Sum(nil))
+		return nil
+	}
+
+	if isArchive(vol.Input) {
+		file, err := os.Open(vol.Input)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return "", err
+		}
+		sources, err := converter.ArchiveSources(file, info.Size(), nil)
+		if err != nil {
+			return "", err
+		}
+		for i, src := range sources {
+			err := add(src.Reader)
+			src.Reader.Close()
+			if err != nil {
+				for _, rest := range sources[i+1:] {
+					rest.Reader.Close()
+				}
+				return "", err
+			}
+		}
+		return hex.EncodeToString(sum.Sum(nil)), nil
+	}
+
+	cfg.Input, cfg.Recursive = vol.Input, vol.Key != vol.Series // As convertLibraryVolume
+	chapters, err := scanChapters(cfg)
+	if err != nil {
+		return "", err
+	}
+	for _, ch := range chapters {
+		for _, name := range ch.Files {
+			file, err := os.Open(filepath.Join(ch.Dir, name))
+			if err != nil {
+				return "", err
+			}
+			err = add(file)
+			file.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// linkOutput replaces output with a symlink to original.
+func linkOutput(output, original string) error {
+	target, err := filepath.Abs(original)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+	if err := os.Remove(output); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Symlink(target, output); err != nil {
+		return fmt.Errorf("could not link output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixListenPrefix marks a listen address that is the path of a Unix
+// socket, e.g. "unix:/run/manga_to_pdf.sock", for reverse proxies and
+// -via-server on the same machine without a TCP port.
+const unixListenPrefix = "unix:"
+
+// listen opens the listener for cfg.ListenAddress: a TCP address, or a Unix
+// socket created with cfg.SocketMode and owned by cfg.SocketGroup.
+func listen(cfg Config) (net.Listener, error) {
+	path, ok := strings.CutPrefix(cfg.ListenAddress, unixListenPrefix)
+	if !ok {
+		return net.Listen("tcp", cfg.ListenAddress)
+	}
+	if path == "" {
+		return nil, errors.New("listen address unix: without a socket path")
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The socket is removed again when the listener is closed.
+	if err := os.Chmod(path, os.FileMode(cfg.SocketMode)); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not set socket permissions: %w", err)
+	}
+	if cfg.SocketGroup != "" {
+		gid, err := lookupGroup(cfg.SocketGroup)
+		if err == nil {
+			err = os.Chown(path, -1, gid)
+		}
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("could not set socket group: %w", err)
+		}
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket a server that did not shut down
+// cleanly left at path. A socket another server still answers on, or a
+// file that is no socket, is an error instead.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("another server is listening on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// lookupGroup returns the GID of group, a group name or a numeric GID.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	cfg, _, err := loadCommandConfig(commandServe, []string{"-listen", "unix:" + path, "-socket-mode", "600", "-socket-group", strconv.Itoa(os.Getgid())}, envMap(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("loadCommandConfig failed: %v", err)
+	}
+	listener, err := listen(cfg)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Socket not created: %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected a socket with mode 0600, got %s", info.Mode())
+	}
+	if _, err := listen(cfg); err == nil {
+		t.Error("Expected a second server on the same socket to be refused")
+	}
+
+	// A socket left behind by a crashed server is replaced.
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	listener, err = listen(cfg)
+	if err != nil {
+		t.Fatalf("listen over a stale socket failed: %v", err)
+	}
+	listener.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed on close, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(cfg); err == nil {
+		t.Error("Expected a regular file at the socket path to be left alone")
+	}
+	if err := cfg.SocketMode.Set("999"); err == nil {
+		t.Error("Expected an invalid socket mode to be rejected")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadTestOptions configures the loadtest subcommand.
+type loadTestOptions struct {
+	Server      string        // Base URL of the server under test
+	Concurrency int           // Requests in flight at once
+	Requests    int           // Total requests to send
+	Pages       int           // Synthetic pages per request
+	PageWidth   int           // Page size in pixels
+	PageHeight  int           //
+	Token       string        // Bearer token, if the server requires one
+	Timeout     time.Duration // Per-request timeout
+}
+
+// loadTestResult summarises a load test run.
+type loadTestResult struct {
+	Requests  int
+	Failures  int
+	Statuses  map[string]int  // Response status (or "error") to count
+	Latencies []time.Duration // Sorted latencies of all requests
+	Elapsed   time.Duration
+	Bytes     int64 // Response bytes received
+}
+
+// runLoadTestCommand parses the loadtest arguments, runs the test and prints
+// the report to out. It returns the process exit code.
+func runLoadTestCommand(ctx context.Context, args []string, out, errOut io.Writer) int {
+	opts := loadTestOptions{}
+	flagSet := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.StringVar(&opts.Server, "server", "", "Base URL of the server to test, e.g. http://localhost:8080 (required)")
+	flagSet.IntVar(&opts.Concurrency, "concurrency", 4, "Requests in flight at once")
+	flagSet.IntVar(&opts.Requests, "requests", 100, "Total number of conversion requests")
+	flagSet.IntVar(&opts.Pages, "pages", 8, "Synthetic pages per request")
+	flagSet.IntVar(&opts.PageWidth, "page-width", 800, "Synthetic page width in pixels")
+	flagSet.IntVar(&opts.PageHeight, "page-height", 1200, "Synthetic page height in pixels")
+	flagSet.StringVar(&opts.Token, "token", "", "Bearer token for servers with AUTH_TOKENS")
+	flagSet.DurationVar(&opts.Timeout, "timeout", 2*time.Minute, "Per-request timeout")
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if opts.Server == "" || opts.Concurrency < 1 || opts.Requests < 1 || opts.Pages < 1 || opts.PageWidth < 1 || opts.PageHeight < 1 {
+		fmt.Fprintln(errOut, "loadtest: -server is required; -concurrency, -requests, -pages and the page size must be positive")
+		return 2
+	}
+
+	result, err := runLoadTest(ctx, opts)
+	if err != nil {
+		fmt.Fprintln(errOut, "loadtest:", err)
+		return 1
+	}
+	printLoadTestReport(out, opts, result)
+	if result.Failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runLoadTest sends opts.Requests conversion requests with synthetic JPEG
+// pages to opts.Server, opts.Concurrency at a time, and measures them.
+func runLoadTest(ctx context.Context, opts loadTestOptions) (loadTestResult, error) {
+	page, err := syntheticPage(opts.PageWidth, opts.PageHeight)
+	if err != nil {
+		return loadTestResult{}, err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i < opts.Pages; i++ {
+		part, err := mw.CreateFormFile("images", fmt.Sprintf("%03d.jpg", i+1))
+		if err != nil {
+			return loadTestResult{}, err
+		}
+		part.Write(page)
+	}
+	if err := mw.Close(); err != nil {
+		return loadTestResult{}, err
+	}
+	url := strings.TrimSuffix(opts.Server, "/") + "/convert"
+	if _, err := http.NewRequest(http.MethodPost, url, nil); err != nil {
+		return loadTestResult{}, fmt.Errorf("invalid -server: %w", err)
+	}
+	client := &http.Client{Timeout: opts.Timeout}
+
+	result := loadTestResult{Statuses: make(map[string]int)}
+	var mu sync.Mutex
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	started := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body.Bytes())) // URL checked above
+				req.Header.Set("Content-Type", mw.FormDataContentType())
+				if opts.Token != "" {
+					req.Header.Set("Authorization", "Bearer "+opts.Token)
+				}
+				requestStarted := time.Now()
+				status, n := "error", int64(0)
+				resp, err := client.Do(req)
+				if err == nil {
+					n, err = io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					status = resp.Status
+					if err != nil {
+						status = "error"
+					}
+				}
+				latency := time.Since(requestStarted)
+
+				mu.Lock()
+				result.Requests++
+				result.Statuses[status]++
+				if err != nil || resp.StatusCode != http.StatusOK {
+					result.Failures++
+				}
+				result.Latencies = append(result.Latencies, latency)
+				result.Bytes += n
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < opts.Requests && ctx.Err() == nil; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	result.Elapsed = time.Since(started)
+	sort.Slice(result.Latencies, func(i, j int) bool { return result.Latencies[i] < result.Latencies[j] })
+	return result, nil
+}
+
+// syntheticPage returns a JPEG with gradients and line work, so the server
+// does real decoding and encoding work for it.
+func syntheticPage(width, height int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: uint8((x + y) % 256), A: 255}
+			if x%40 == 0 || y%60 == 0 {
+				c = color.RGBA{A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// percentile returns the p-th percentile (0-100) of sorted latencies using
+// the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// printLoadTestReport writes the load test summary as a table.
+func printLoadTestReport(w io.Writer, opts loadTestOptions, result loadTestResult) {
+	errorRate := 0.0
+	if result.Requests > 0 {
+		errorRate = float64(result.Failures) / float64(result.Requests) * 100
+	}
+	fmt.Fprintf(w, "%d requests of %d pages (%dx%d) to %s, %d concurrent, in %s\n",
+		result.Requests, opts.Pages, opts.PageWidth, opts.PageHeight, opts.Server, opts.Concurrency, result.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput %.2f req/s, %.1f pages/s; error rate %.1f%% (%d failed); %s received\n\n",
+		float64(result.Requests)/result.Elapsed.Seconds(), float64(result.Requests*opts.Pages)/result.Elapsed.Seconds(),
+		errorRate, result.Failures, byteSize(result.Bytes))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LATENCY\tVALUE")
+	for _, p := range []struct {
+		name  string
+		value float64
+	}{{"p50", 50}, {"p90", 90}, {"p95", 95}, {"p99", 99}, {"max", 100}} {
+		fmt.Fprintf(tw, "%s\t%s\n", p.name, percentile(result.Latencies, p.value).Round(time.Millisecond))
+	}
+	tw.Flush()
+
+	statuses := make([]string, 0, len(result.Statuses))
+	for status := range result.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tCOUNT")
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "%s\t%d\n", status, result.Statuses[status])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, expected := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond, 0: time.Millisecond} {
+		if got := percentile(latencies, p); got != expected {
+			t.Errorf("p%v: expected %v, got %v", p, expected, got)
+		}
+	}
+	if percentile(nil, 50) != 0 {
+		t.Error("Expected 0 for no samples")
+	}
+}
+
+func TestRunLoadTestCommand(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil || len(r.MultipartForm.File["images"]) != 2 {
+			t.Errorf("Expected two synthetic pages, got %v", err)
+		}
+		if requests.Add(1)%5 == 0 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("%PDF-1.4\n%%EOF\n"))
+	}))
+	defer server.Close()
+
+	var out, errOut bytes.Buffer
+	code := runLoadTestCommand(context.Background(), []string{
+		"-server", server.URL, "-concurrency", "3", "-requests", "10", "-pages", "2",
+		"-page-width", "40", "-page-height", "60", "-token", "secret",
+	}, &out, &errOut)
+	if code != 1 {
+		t.Errorf("Expected exit code 1 with failed requests, got %d (%s)", code, errOut.String())
+	}
+	if requests.Load() != 10 {
+		t.Errorf("Expected 10 requests, server saw %d", requests.Load())
+	}
+	report := out.String()
+	for _, want := range []string{"error rate 20.0% (2 failed)", "p99", "200 OK", "503 Service Unavailable"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Report is missing %q:\n%s", want, report)
+		}
+	}
+
+	if code := runLoadTestCommand(context.Background(), nil, &out, &errOut); code != 2 {
+		t.Errorf("Expected usage error without -server, got %d", code)
+	}
+}
@@ -3,38 +3,98 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall" // For SIGTERM
-	"time"
+	"strings"
 
-	"manga_to_pdf/api" // Import the new api package
-	// "manga_to_pdf/internal/converter" // No longer directly needed by main
+	"manga_to_pdf/internal/converter"
 )
 
-// Config holds all application configuration for the server.
-type Config struct {
-	ListenAddress  string
-	VerboseLogging bool
-	// CPUProfileFile string // Profiling can be added back if needed via HTTP endpoints (e.g. net/http/pprof)
-	// MemProfileFile string
-}
+// usage lists the subcommands. Without one, the flags of both convert and
+// serve are accepted, and -i selects a conversion.
+const usage = `Usage: manga_to_pdf <command> [flags]
+
+Commands:
+  convert       Convert a directory, CBZ/ZIP archive or tar stream of images
+  serve         Run the HTTP API server
+  merge         Concatenate PDFs into one, with a bookmark per input
+  library       Keep a collection of series converted
+  remote        Submit conversions to a server and fetch their results
+  diff          Compare two converted documents
+  loadtest      Send conversion requests to a server and report latencies
+  capabilities  Print what this build supports
+  version       Print the version
+
+Run "manga_to_pdf <command> -h" for the flags of a command.
+`
 
 func main() {
-	cfg := Config{
-		ListenAddress:  ":8080", // Default listen address
-		VerboseLogging: false,   // Default logging level
+	command, args := commandAny, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
+	switch command {
+	case "loadtest":
+		ctx, stop := signalContext(context.Background())
+		code := runLoadTestCommand(ctx, args, os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	case "library":
+		ctx, stop := signalContext(context.Background())
+		code := runLibraryCommand(ctx, args, os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	case "remote":
+		ctx, stop := signalContext(context.Background())
+		code := runRemoteCommand(ctx, args, os.Stdout, os.Stderr)
+		stop()
+		os.Exit(code)
+	case "merge":
+		ctx, stop := signalContext(context.Background())
+		code := runMergeCommand(ctx, args, os.Stderr)
+		stop()
+		os.Exit(code)
+	case "diff":
+		os.Exit(runDiffCommand(args, os.Stdout, os.Stderr))
+	case "capabilities":
+		os.Exit(runCapabilitiesCommand(args, os.Stdout, os.Stderr))
+	case "version":
+		os.Exit(runVersionCommand(args, os.Stdout, os.Stderr))
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	case commandAny, commandConvert, commandServe:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	os.Exit(runMain(command, args, os.Stdout, os.Stderr))
+}
 
-	// Basic environment variable configuration (optional)
-	if addr := os.Getenv("LISTEN_ADDRESS"); addr != "" {
-		cfg.ListenAddress = addr
+// runMain runs convert, serve or, for commandAny, whichever of them the
+// flags in args select, and returns the process exit code.
+func runMain(command string, args []string, out, errOut io.Writer) int {
+	cfg, printOnly, err := loadCommandConfig(command, args, os.Getenv, errOut)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintln(errOut, "Configuration error:", err)
+		return 2
 	}
-	if verbose := os.Getenv("VERBOSE_LOGGING"); verbose == "true" || verbose == "1" {
-		cfg.VerboseLogging = true
+	if printOnly {
+		if err := printConfig(out, cfg); err != nil {
+			fmt.Fprintln(errOut, "Failed to print configuration:", err)
+			return 1
+		}
+		return 0
+	}
+	if command == commandConvert && cfg.Input == "" {
+		fmt.Fprintln(errOut, "Configuration error: nothing to convert; give the input as argument or with -i")
+		return 2
 	}
 
 	// Setup structured logger
@@ -44,62 +104,29 @@ func main() {
 	} else {
 		logLevel = slog.LevelInfo
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	logger := slog.New(slog.NewTextHandler(errOut, &slog.HandlerOptions{Level: logLevel}))
 	slog.SetDefault(logger)
 
-	slog.Info("Starting API server...", "address", cfg.ListenAddress, "verbose_logging", cfg.VerboseLogging)
-
-	// Setup HTTP server and router
-	mux := http.NewServeMux()
-	mux.HandleFunc("/convert", api.HandleConvert) // Register the /convert handler
-
-	// Add health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, `{"status":"ok"}`)
-	})
-
-	// Consider adding pprof endpoints for profiling if needed
-	// mux.HandleFunc("/debug/pprof/", pprof.Index)
-	// mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	// mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	// mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	// mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-
-
-	server := &http.Server{
-		Addr:    cfg.ListenAddress,
-		Handler: mux,
-		// ReadTimeout:  5 * time.Second, // Example: Add timeouts for security
-		// WriteTimeout: 60 * time.Second, // Example: Longer for PDF generation
-		// IdleTimeout:  120 * time.Second,
-	}
-
-	// Graceful shutdown
-	idleConnsClosed := make(chan struct{})
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		sig := <-sigChan
-		slog.Info("Received signal, shutting down gracefully...", "signal", sig)
-
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second) // 30-second shutdown timeout
-		defer cancel()
-
-		if err := server.Shutdown(shutdownCtx); err != nil {
-			slog.Error("HTTP server Shutdown error", "error", err)
+	if cfg.Info {
+		if !printDependencyInfo(context.Background(), out, cfg) {
+			return 1
 		}
-		slog.Info("HTTP server shutdown complete.")
-		close(idleConnsClosed)
-	}()
-
-	slog.Info("Server is listening", "address", cfg.ListenAddress)
-	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		slog.Error("Failed to start HTTP server", "error", err)
-		os.Exit(1)
+		return 0
 	}
 
-	<-idleConnsClosed // Wait for graceful shutdown to complete
-	slog.Info("Application shut down successfully.")
+	if cfg.Input == "" {
+		return runServer(cfg)
+	}
+	ctx, stop := signalContext(context.Background())
+	err = runApp(ctx, cfg)
+	stop()
+	if reason := converter.CancellationReason(err); reason != "" {
+		slog.Error("Conversion stopped", "reason", reason, "error", err)
+		return 1
+	}
+	if err != nil {
+		slog.Error("Conversion failed", "error", err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// Output statuses in a manifest.
+const (
+	manifestWritten = "written"
+	manifestFailed  = "failed"
+	manifestSkipped = "skipped" // Left alone by -on-exists skip
+)
+
+// manifest collects what a run did for -manifest: every output, what
+// became of each of its pages and how long it took. It is written as JSON
+// when the run ends, so scripts can tell which pages were left out.
+type manifest struct {
+	mu      sync.Mutex
+	input   string
+	started time.Time
+	outputs []manifestOutput
+}
+
+// manifestFile is the JSON written for -manifest.
+type manifestFile struct {
+	Input      string           `json:"input"`
+	Started    time.Time        `json:"started"`
+	DurationMS int64            `json:"duration_ms"`
+	Error      string           `json:"error,omitempty"` // Why the run failed, if it did
+	Outputs    []manifestOutput `json:"outputs"`
+}
+
+// manifestOutput is one output of the run.
+type manifestOutput struct {
+	Output       string         `json:"output"`
+	Status       string         `json:"status"` // manifestWritten, manifestFailed or manifestSkipped
+	Error        string         `json:"error,omitempty"`
+	Bytes        int64          `json:"bytes,omitempty"`
+	PagesAdded   int            `json:"pages_added"`
+	PagesFailed  int            `json:"pages_failed"`
+	PagesDropped int            `json:"pages_dropped"` // Duplicate or blank pages left out
+	DecodeMS     int64          `json:"decode_ms"`     // Time spent in the decode stage
+	EncodeMS     int64          `json:"encode_ms"`     // Time spent in the encode stage
+	ProcessMS    int64          `json:"process_ms"`    // Wall-clock time of the decode/encode pipeline
+	PDFMS        int64          `json:"pdf_ms"`        // Wall-clock time writing the document
+	Pages        []manifestPage `json:"pages"`
+}
+
+// manifestPage is one source of an output and what became of it.
+type manifestPage struct {
+	Index         int    `json:"index"`
+	Filename      string `json:"filename"`
+	Chapter       string `json:"chapter,omitempty"`
+	Status        string `json:"status"` // "added", "failed", or why it was dropped: "duplicate" or "blank"
+	Error         string `json:"error,omitempty"`
+	DuplicateOf   string `json:"duplicate_of,omitempty"`
+	SourceFormat  string `json:"source_format,omitempty"` // As decoded: "jpeg", "png", "webp", "gif" or "bmp"
+	SourceWidth   int    `json:"source_width,omitempty"`
+	SourceHeight  int    `json:"source_height,omitempty"`
+	SourceBytes   int64  `json:"source_bytes,omitempty"`
+	Format        string `json:"format,omitempty"` // As embedded: "jpeg" or "png"
+	Width         int    `json:"width,omitempty"`
+	Height        int    `json:"height,omitempty"`
+	Bytes         int64  `json:"bytes,omitempty"`
+	Quality       int    `json:"quality,omitempty"` // JPEG quality the page was re-encoded at
+	PassedThrough bool   `json:"passed_through,omitempty"`
+}
+
+func newManifest(input string) *manifest {
+	return &manifest{input: input, started: time.Now()}
+}
+
+// manifestPages collects the pages of one output as they are converted.
+type manifestPages struct {
+	mu    sync.Mutex
+	pages map[int]manifestPage // By source index; a later target size pass replaces an earlier one
+}
+
+func newManifestPages() *manifestPages {
+	return &manifestPages{pages: make(map[int]manifestPage)}
+}
+
+// inspect returns a converter.InspectFunc that records every page and
+// passes it on to next, if set.
+func (p *manifestPages) inspect(next converter.InspectFunc) converter.InspectFunc {
+	return func(page converter.PageInspection) {
+		if next != nil {
+			next(page)
+		}
+		row := manifestPage{
+			Index:        page.Source.Index,
+			Filename:     page.Source.Filename,
+			Chapter:      page.Source.Chapter,
+			Status:       "added",
+			SourceFormat: page.Source.Format,
+			SourceBytes:  page.Source.Bytes,
+		}
+		if page.Before != nil {
+			row.SourceWidth, row.SourceHeight = page.Before.Bounds().Dx(), page.Before.Bounds().Dy()
+		}
+		if page.Err != nil {
+			row.Status, row.Error = manifestFailed, page.Err.Error()
+		} else {
+			row.Format, row.Width, row.Height = page.Format, page.Width, page.Height
+			row.Bytes, row.Quality, row.PassedThrough = int64(len(page.Data)), page.Quality, page.PassedThrough
+		}
+		p.mu.Lock()
+		p.pages[row.Index] = row
+		p.mu.Unlock()
+	}
+}
+
+// add records output, converted with stats into pages, which is nil if
+// the conversion never started; err is why it failed. It is a no-op on a
+// nil manifest.
+func (m *manifest) add(output string, pages *manifestPages, stats converter.Stats, err error) {
+	if m == nil {
+		return
+	}
+	entry := manifestOutput{
+		Output:       output,
+		Status:       manifestWritten,
+		Bytes:        stats.OutputBytes,
+		PagesAdded:   stats.PagesAdded,
+		PagesFailed:  stats.PagesFailed,
+		PagesDropped: stats.PagesDuplicate + stats.PagesBlank,
+		DecodeMS:     stats.DecodeTime.Milliseconds(),
+		EncodeMS:     stats.EncodeTime.Milliseconds(),
+		ProcessMS:    stats.ProcessTime.Milliseconds(),
+		PDFMS:        stats.PDFTime.Milliseconds(),
+		Pages:        []manifestPage{},
+	}
+	if err != nil {
+		entry.Status, entry.Error, entry.Bytes = manifestFailed, err.Error(), 0
+	}
+	if pages != nil {
+		pages.mu.Lock()
+		rows := maps.Clone(pages.pages)
+		pages.mu.Unlock()
+		// Sources that failed before they were decoded were never inspected.
+		for _, failure := range stats.Failures {
+			row, ok := rows[failure.Index]
+			if !ok {
+				row = manifestPage{Index: failure.Index, Filename: failure.Filename}
+			}
+			row.Status, row.Error = manifestFailed, failure.Error
+			rows[failure.Index] = row
+		}
+		for _, drop := range stats.Dropped {
+			row := rows[drop.Index]
+			row.Index, row.Filename, row.Status, row.DuplicateOf = drop.Index, drop.Filename, drop.Reason, drop.DuplicateOf
+			rows[drop.Index] = row
+		}
+		entry.Pages = slices.SortedFunc(maps.Values(rows), func(a, b manifestPage) int { return a.Index - b.Index })
+	}
+	m.mu.Lock()
+	m.outputs = append(m.outputs, entry)
+	m.mu.Unlock()
+}
+
+// skip records output as left alone by -on-exists skip. It is a no-op on a
+// nil manifest.
+func (m *manifest) skip(output string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.outputs = append(m.outputs, manifestOutput{Output: output, Status: manifestSkipped, Pages: []manifestPage{}})
+	m.mu.Unlock()
+}
+
+// write writes the manifest of a run that ended with runErr to path.
+func (m *manifest) write(path string, runErr error) error {
+	m.mu.Lock()
+	file := manifestFile{
+		Input:      m.input,
+		Started:    m.started,
+		DurationMS: time.Since(m.started).Milliseconds(),
+		Outputs:    slices.Clone(m.outputs),
+	}
+	m.mu.Unlock()
+	if file.Outputs == nil {
+		file.Outputs = []manifestOutput{}
+	}
+	if runErr != nil {
+		file.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunApp_Manifest(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.png", "2.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "3.png"), []byte("\x89PNG\r\n\x1a\ncut short"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.Manifest = filepath.Join(t.TempDir(), "manifest.json")
+	cfg.Dedupe = true
+	cfg.MaxWidth = 4
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.Manifest)
+	if err != nil {
+		t.Fatalf("Manifest not written: %v", err)
+	}
+	var m manifestFile
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Could not decode manifest: %v", err)
+	}
+	if m.Input != dir || m.Error != "" || len(m.Outputs) != 1 {
+		t.Fatalf("Unexpected manifest: %s", data)
+	}
+	out := m.Outputs[0]
+	if out.Output != cfg.Output || out.Status != manifestWritten || out.PagesAdded != 1 || out.PagesFailed != 1 || out.PagesDropped != 1 || out.Bytes == 0 {
+		t.Errorf("Unexpected output entry: %+v", out)
+	}
+	if len(out.Pages) != 3 {
+		t.Fatalf("Expected 3 pages, got %+v", out.Pages)
+	}
+	added, duplicate, failed := out.Pages[0], out.Pages[1], out.Pages[2]
+	if added.Filename != "1.png" || added.Status != "added" || added.SourceFormat != "png" || added.SourceWidth != 8 || added.Width != 4 || added.Format == "" || added.Bytes == 0 {
+		t.Errorf("Unexpected added page: %+v", added)
+	}
+	if duplicate.Filename != "2.png" || duplicate.Status != "duplicate" || duplicate.DuplicateOf != "1.png" {
+		t.Errorf("Unexpected duplicate page: %+v", duplicate)
+	}
+	if failed.Filename != "3.png" || failed.Status != manifestFailed || failed.Error == "" {
+		t.Errorf("Unexpected failed page: %+v", failed)
+	}
+
+	// A failed run still writes its manifest, with the reason.
+	cfg.Input = filepath.Join(dir, "missing")
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Fatal("Expected a missing input to fail")
+	}
+	data, err = os.ReadFile(cfg.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = manifestFile{}
+	if err := json.Unmarshal(data, &m); err != nil || m.Error == "" || len(m.Outputs) != 0 {
+		t.Errorf("Expected a manifest with the run's error and no outputs, got %s", data)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// applyGCSettings sets the garbage collector target and soft memory limit
+// of a server from cfg. A memory limit does what a heap ballast used to:
+// with a high or disabled GCPercent, the heap may grow freely between
+// small jobs but is collected hard as it nears the limit.
+func applyGCSettings(cfg Config) {
+	if cfg.GCPercent != 0 {
+		previous := debug.SetGCPercent(cfg.GCPercent)
+		slog.Info("Set garbage collector target", "gc_percent", cfg.GCPercent, "previous", previous)
+	}
+	if cfg.MemoryLimit > 0 {
+		debug.SetMemoryLimit(int64(cfg.MemoryLimit))
+		slog.Info("Set soft memory limit", "memory_limit", cfg.MemoryLimit.String())
+	}
+}
+
+// trimMemory returns memory retained after large conversions to the OS
+// every interval until ctx is done, so one huge job does not keep the
+// server's resident size high for good.
+func trimMemory(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			trimMemoryOnce()
+		}
+	}
+}
+
+// trimMemoryOnce drops the pooled encode buffers and has the runtime
+// collect and return all the memory it can to the OS.
+func trimMemoryOnce() {
+	buffers, size := converter.TrimBuffers()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+	slog.Debug("Trimmed memory", "buffers", buffers, "buffer_bytes", byteSize(size).String(),
+		"heap_in_use", byteSize(after.HeapInuse).String(), "released", byteSize(max(0, int64(after.HeapReleased)-int64(before.HeapReleased))).String())
+}
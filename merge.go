@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// runMergeCommand concatenates the PDFs named in args into one, with a
+// bookmark at the first page of each. It returns 1 if the merge failed and
+// 2 on usage errors.
+func runMergeCommand(ctx context.Context, args []string, errOut io.Writer) int {
+	flagSet := flag.NewFlagSet("merge", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: manga_to_pdf merge -o volume.pdf chapter1.pdf chapter2.pdf ...")
+		fmt.Fprintln(errOut, "The inputs must be PDFs written by manga_to_pdf or another gofpdf-based tool.")
+		flagSet.PrintDefaults()
+	}
+	cfg := defaultConfig()
+	flagSet.StringVar(&cfg.Output, "o", "", "Output PDF (required)")
+	flagSet.StringVar(&cfg.OnExists, "on-exists", "rename", "When the output file exists: overwrite, skip, rename or prompt")
+	flagSet.StringVar(&cfg.Title, "title", "", "Document title")
+	flagSet.StringVar(&cfg.Author, "author", "", "Document author")
+	flagSet.StringVar(&cfg.Lang, "lang", "", "Document language, e.g. ja")
+	flagSet.BoolVar(&cfg.RTL, "rtl", false, "Mark the output as read right to left")
+	flagSet.IntVar(&cfg.Workers, "workers", cfg.Workers, "Image workers")
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flagSet.NArg() == 0 || cfg.Output == "" {
+		flagSet.Usage()
+		return 2
+	}
+	switch cfg.OnExists {
+	case "overwrite", "skip", "rename", "prompt":
+	default:
+		fmt.Fprintf(errOut, "merge: invalid -on-exists %q: must be overwrite, skip, rename or prompt\n", cfg.OnExists)
+		return 2
+	}
+	cfg.Input = flagSet.Arg(0)
+
+	sources, err := mergeSources(flagSet.Args())
+	if err != nil {
+		fmt.Fprintln(errOut, "merge:", err)
+		return 1
+	}
+	if _, err := convertSources(ctx, cfg, sources, flagSet.NArg(), cfg.Output); err != nil {
+		fmt.Fprintln(errOut, "merge:", err)
+		return 1
+	}
+	return 0
+}
+
+// mergeSources reads the page images of the PDFs at paths as sources, in
+// order, each PDF a chapter named after its file. Pages are taken as they
+// are stored, so JPEG pages are embedded again without re-encoding.
+func mergeSources(paths []string) ([]converter.ImageSource, error) {
+	var sources []converter.ImageSource
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := converter.ReadPDF(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		base := filepath.Base(path)
+		chapter := strings.TrimSuffix(base, filepath.Ext(base))
+		for i := range doc.NumPages() {
+			page, contentType, err := doc.PageImageData(i)
+			if err != nil {
+				return nil, fmt.Errorf("%s: page %d: %w", path, i+1, err)
+			}
+			ext := ".png"
+			if contentType == "image/jpeg" {
+				ext = ".jpg"
+			}
+			sources = append(sources, converter.ImageSource{
+				OriginalFilename: fmt.Sprintf("%s/%03d%s", base, i+1, ext),
+				Reader:           io.NopCloser(bytes.NewReader(page)),
+				ContentType:      contentType,
+				Index:            len(sources),
+				Chapter:          chapter,
+			})
+		}
+	}
+	return sources, nil
+}
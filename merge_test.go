@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func TestRunMergeCommand(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "ch1.pdf"), filepath.Join(dir, "ch2.pdf")
+	writeDiffPDF(t, first, "Chapter 1", diffTestPage(t, false), diffTestPage(t, true))
+	writeDiffPDF(t, second, "Chapter 2", diffTestPage(t, true))
+	output := filepath.Join(dir, "volume.pdf")
+
+	var errOut bytes.Buffer
+	if code := runMergeCommand(context.Background(), []string{"-o", output, "-title", "Volume 1", first, second}, &errOut); code != 0 {
+		t.Fatalf("merge exited with %d: %s", code, errOut.String())
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := converter.ReadPDF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.NumPages() != 3 || merged.Info["Title"] != "Volume 1" {
+		t.Fatalf("Expected 3 pages titled Volume 1, got %d titled %q", merged.NumPages(), merged.Info["Title"])
+	}
+	if n := bytes.Count(data, []byte("/Dest [")); n != 2 {
+		t.Errorf("Expected one bookmark per input, got %d", n)
+	}
+
+	// Pages are carried over as stored, not re-encoded.
+	data, err = os.ReadFile(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, err := converter.ReadPDF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _, err := input.PageImageData(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, contentType, err := merged.PageImageData(2)
+	if err != nil || contentType != "image/png" || !bytes.Equal(got, want) {
+		t.Errorf("Expected the last page to be the second input's page unchanged (%s, %v)", contentType, err)
+	}
+
+	for _, args := range [][]string{{first}, {"-o", output}, {"-o", output, "-on-exists", "append", first}} {
+		if code := runMergeCommand(context.Background(), args, &errOut); code != 2 {
+			t.Errorf("Expected merge %q to exit with 2, got %d", args, code)
+		}
+	}
+	if code := runMergeCommand(context.Background(), []string{"-o", output, filepath.Join(dir, "missing.pdf")}, &errOut); code != 1 {
+		t.Errorf("Expected a missing input to exit with 1, got %d", code)
+	}
+}
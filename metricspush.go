@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// metricsJob is the Pushgateway job, and the default StatsD prefix, that
+// -metrics-push reports runs under.
+const metricsJob = "manga_to_pdf"
+
+// metricsPushTimeout bounds how long pushing a run's metrics may take, so
+// an unreachable endpoint cannot hold up a nightly batch.
+const metricsPushTimeout = 10 * time.Second
+
+// runMetrics collects the metrics of a run for -metrics-push: how long it
+// took and how many outputs and pages it converted or failed. Its methods
+// are safe for concurrent use, and do nothing on a nil *runMetrics.
+type runMetrics struct {
+	mu             sync.Mutex
+	started        time.Time
+	outputsWritten int
+	outputsFailed  int
+	outputsSkipped int
+	pages          int
+	pagesFailed    int
+	outputBytes    int64
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{started: time.Now()}
+}
+
+// add records an output converted with stats; err is why it failed.
+func (m *runMetrics) add(stats converter.Stats, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pages += stats.PagesAdded
+	m.pagesFailed += stats.PagesFailed
+	if err != nil {
+		m.outputsFailed++
+		return
+	}
+	m.outputsWritten++
+	m.outputBytes += stats.OutputBytes
+}
+
+// skip records an output left alone by -on-exists skip.
+func (m *runMetrics) skip() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.outputsSkipped++
+	m.mu.Unlock()
+}
+
+// parseMetricsPush parses a -metrics-push endpoint: the http(s) URL of a
+// Prometheus Pushgateway, or statsd://host:port for a StatsD server.
+func parseMetricsPush(value string) (*url.URL, error) {
+	endpoint, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch endpoint.Scheme {
+	case "http", "https", "statsd":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q: expected http or https for a Pushgateway, or statsd", endpoint.Scheme)
+	}
+	if endpoint.Host == "" {
+		return nil, errors.New("no host")
+	}
+	return endpoint, nil
+}
+
+// push sends the metrics of a run that ended with runErr to endpoint (see
+// parseMetricsPush). Failing to push is only logged: the run's outcome is
+// what counts.
+func (m *runMetrics) push(ctx context.Context, endpoint string, runErr error) {
+	target, err := parseMetricsPush(endpoint) // Validated when it was set
+	if err == nil {
+		// Pushed even when the run was stopped, with a deadline of its own.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), metricsPushTimeout)
+		defer cancel()
+		if target.Scheme == "statsd" {
+			err = m.pushStatsD(ctx, target, runErr)
+		} else {
+			err = m.pushGateway(ctx, target, runErr)
+		}
+	}
+	if err != nil {
+		slog.Warn("Failed to push run metrics", "endpoint", target.Redacted(), "error", err)
+		return
+	}
+	slog.Debug("Pushed run metrics", "endpoint", target.Redacted())
+}
+
+// metric is one value of a run for push.
+type metric struct {
+	name, help string
+	value      float64
+}
+
+// metrics returns the values of a run that ended with runErr.
+func (m *runMetrics) metrics(runErr error) []metric {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	success := 1.0
+	if runErr != nil {
+		success = 0
+	}
+	return []metric{
+		{"duration_seconds", "How long the run took.", time.Since(m.started).Seconds()},
+		{"success", "1 if the run succeeded, 0 if it failed.", success},
+		{"outputs_written", "Outputs written.", float64(m.outputsWritten)},
+		{"outputs_failed", "Outputs that failed to convert.", float64(m.outputsFailed)},
+		{"outputs_skipped", "Outputs left alone by -on-exists skip.", float64(m.outputsSkipped)},
+		{"pages", "Pages added to the outputs.", float64(m.pages)},
+		{"pages_failed", "Pages that failed to convert.", float64(m.pagesFailed)},
+		{"output_bytes", "Bytes of the outputs written.", float64(m.outputBytes)},
+		{"last_run_timestamp_seconds", "When the run ended, in Unix time.", float64(time.Now().Unix())},
+	}
+}
+
+// pushGateway replaces the metrics of the manga_to_pdf job on the
+// Pushgateway at target with those of the run, in the Prometheus text
+// format. A target whose path has no /metrics/job/ grouping key of its own
+// gets /metrics/job/manga_to_pdf.
+func (m *runMetrics) pushGateway(ctx context.Context, target *url.URL, runErr error) error {
+	var body bytes.Buffer
+	for _, metric := range m.metrics(runErr) {
+		name := metricsJob + "_run_" + metric.name
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, metric.help, name, name, metric.value)
+	}
+	pushURL := *target
+	if !strings.Contains(pushURL.Path, "/metrics/job/") {
+		pushURL.Path = strings.TrimSuffix(pushURL.Path, "/") + "/metrics/job/" + metricsJob
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushURL.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("pushgateway answered %s", resp.Status)
+	}
+	return nil
+}
+
+// pushStatsD sends the metrics of the run to the StatsD server at target
+// in one UDP packet: the duration as a timer, the success as a gauge and
+// the counts as counters, named <prefix>.run.<metric>. The prefix is the
+// path of target, e.g. statsd://host:8125/homelab.manga, or manga_to_pdf.
+func (m *runMetrics) pushStatsD(ctx context.Context, target *url.URL, runErr error) error {
+	prefix := strings.Trim(target.Path, "/")
+	if prefix == "" {
+		prefix = metricsJob
+	}
+	var packet bytes.Buffer
+	for _, metric := range m.metrics(runErr) {
+		switch metric.name {
+		case "duration_seconds":
+			fmt.Fprintf(&packet, "%s.run.duration:%d|ms\n", prefix, int64(metric.value*1000))
+		case "success", "last_run_timestamp_seconds":
+			fmt.Fprintf(&packet, "%s.run.%s:%g|g\n", prefix, metric.name, metric.value)
+		default:
+			fmt.Fprintf(&packet, "%s.run.%s:%d|c\n", prefix, metric.name, int64(metric.value))
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", target.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(bytes.TrimSuffix(packet.Bytes(), []byte("\n")))
+	return err
+}
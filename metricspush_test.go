@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunApp_MetricsPushGateway(t *testing.T) {
+	type push struct{ method, path, body string }
+	pushes := make(chan push, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pushes <- push{r.Method, r.URL.Path, string(body)}
+	}))
+	defer gateway.Close()
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	writePNG(t, filepath.Join(dir, "2.png"))
+	if err := os.WriteFile(filepath.Join(dir, "3.png"), []byte("\x89PNG\r\n\x1a\ncut short"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.MetricsPush = gateway.URL
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+
+	got := <-pushes
+	if got.method != http.MethodPut || got.path != "/metrics/job/manga_to_pdf" {
+		t.Errorf("Pushed with %s %s, want PUT /metrics/job/manga_to_pdf", got.method, got.path)
+	}
+	for _, line := range []string{
+		"# TYPE manga_to_pdf_run_pages gauge",
+		"manga_to_pdf_run_pages 2\n",
+		"manga_to_pdf_run_pages_failed 1\n",
+		"manga_to_pdf_run_outputs_written 1\n",
+		"manga_to_pdf_run_outputs_failed 0\n",
+		"manga_to_pdf_run_success 1\n",
+		"manga_to_pdf_run_duration_seconds ",
+	} {
+		if !strings.Contains(got.body, line) {
+			t.Errorf("Push is missing %q:\n%s", line, got.body)
+		}
+	}
+
+	// A failed run is pushed too, and a grouping key of its own is kept.
+	cfg.Input = filepath.Join(dir, "missing")
+	cfg.MetricsPush = gateway.URL + "/metrics/job/nightly/instance/nas"
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Fatal("runApp of a missing input succeeded")
+	}
+	got = <-pushes
+	if got.path != "/metrics/job/nightly/instance/nas" || !strings.Contains(got.body, "manga_to_pdf_run_success 0\n") {
+		t.Errorf("Unexpected push of a failed run to %s:\n%s", got.path, got.body)
+	}
+}
+
+func TestRunApp_MetricsPushStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.MetricsPush = "statsd://" + conn.LocalAddr().String() + "/homelab.manga"
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+
+	packet := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(packet[:n]), "\n")
+	for _, want := range []string{"homelab.manga.run.pages:1|c", "homelab.manga.run.outputs_written:1|c", "homelab.manga.run.success:1|g"} {
+		if !strings.Contains(string(packet[:n]), want) {
+			t.Errorf("Packet is missing %q:\n%s", want, packet[:n])
+		}
+	}
+	if !strings.HasPrefix(lines[0], "homelab.manga.run.duration:") || !strings.HasSuffix(lines[0], "|ms") {
+		t.Errorf("Unexpected duration line %q", lines[0])
+	}
+}
+
+func TestLoadConfig_MetricsPush(t *testing.T) {
+	for _, endpoint := range []string{"http://localhost:9091", "statsd://localhost:8125/manga"} {
+		if _, _, err := loadConfig([]string{"-i", "in", "-metrics-push", endpoint}, envMap(nil), &bytes.Buffer{}); err != nil {
+			t.Errorf("-metrics-push %s: %v", endpoint, err)
+		}
+	}
+	for _, endpoint := range []string{"localhost:9091", "udp://localhost:8125", "http://"} {
+		if _, _, err := loadConfig([]string{"-i", "in", "-metrics-push", endpoint}, envMap(nil), &bytes.Buffer{}); err == nil {
+			t.Errorf("-metrics-push %s was accepted", endpoint)
+		}
+	}
+}
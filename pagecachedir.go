@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// pageCacheDir is the -cache directory: pages converted by earlier runs,
+// found again by the content of their source and the page settings, so
+// that converting a volume again after a few pages were added or changed
+// only converts those. Unlike a checkpoint it is kept after the run, up to
+// maxSize bytes, dropping the pages used longest ago beyond that.
+type pageCacheDir struct {
+	pages   pageDir
+	maxSize int64 // 0 = unlimited
+}
+
+// openPageCacheDir opens the page cache in dir, creating dir if need be.
+func openPageCacheDir(dir string, maxSize int64) (*pageCacheDir, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create page cache: %w", err)
+	}
+	return &pageCacheDir{pages: pageDir(dir), maxSize: maxSize}, nil
+}
+
+// pageCache returns the page cache of conversions with c, nil without a
+// -cache.
+func (c *pageCacheDir) pageCache() converter.PageCache {
+	if c == nil {
+		return nil
+	}
+	return c
+}
+
+// Load returns the page kept under key, for converter.PageCache, and marks
+// it as used now, so prune keeps it over pages not used as recently.
+func (c *pageCacheDir) Load(key string) (converter.Page, bool) {
+	page, ok := c.pages.Load(key)
+	if ok {
+		now := time.Now()
+		os.Chtimes(filepath.Join(string(c.pages), key+".json"), now, now)
+	}
+	return page, ok
+}
+
+// Store keeps page under key, for converter.PageCache.
+func (c *pageCacheDir) Store(key string, page converter.Page) {
+	c.pages.Store(key, page)
+}
+
+// prune removes the pages used longest ago until the cache holds no more
+// than maxSize bytes.
+func (c *pageCacheDir) prune() {
+	if c.maxSize <= 0 {
+		return
+	}
+	type cachedPage struct {
+		key  string
+		size int64
+		used time.Time
+	}
+	entries, err := os.ReadDir(string(c.pages))
+	if err != nil {
+		slog.Warn("Failed to read page cache", "cache", string(c.pages), "error", err)
+		return
+	}
+	var pages []cachedPage
+	var total int64
+	for _, entry := range entries {
+		key, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		meta, err1 := entry.Info()
+		data, err2 := os.Stat(filepath.Join(string(c.pages), key))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		page := cachedPage{key: key, size: meta.Size() + data.Size(), used: meta.ModTime()}
+		pages = append(pages, page)
+		total += page.size
+	}
+	if total <= c.maxSize {
+		return
+	}
+	slices.SortFunc(pages, func(a, b cachedPage) int { return a.used.Compare(b.used) })
+	removed := 0
+	for _, page := range pages {
+		if total <= c.maxSize {
+			break
+		}
+		// The description goes first, so the page is never loaded without its data.
+		path := filepath.Join(string(c.pages), page.key)
+		if err := os.Remove(path + ".json"); err != nil {
+			continue
+		}
+		os.Remove(path)
+		total -= page.size
+		removed++
+	}
+	slog.Info("Pruned page cache", "cache", string(c.pages), "pages_removed", removed, "size", byteSize(total).String())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func TestRunApp_Cache(t *testing.T) {
+	dir, cacheDir := t.TempDir(), filepath.Join(t.TempDir(), "cache")
+	writePage := func(name string, width int) {
+		t.Helper()
+		file, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err := png.Encode(file, image.NewGray(image.Rect(0, 0, width, 8))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePage("1.png", 8)
+	writePage("2.png", 9)
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.OnExists = "overwrite"
+	cfg.Cache = cacheDir
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp with -cache failed: %v", err)
+	}
+	if pages := pageDir(cacheDir).count(); pages != 2 {
+		t.Fatalf("Expected 2 pages in the cache, got %d", pages)
+	}
+	old := time.Now().Add(-time.Hour)
+	keys := cachedKeys(t, cacheDir)
+	for _, key := range keys {
+		os.Chtimes(filepath.Join(cacheDir, key+".json"), old, old)
+	}
+
+	// A page is added: the two converted before are taken from the cache.
+	writePage("3.png", 10)
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Second run with -cache failed: %v", err)
+	}
+	if pages := pageDir(cacheDir).count(); pages != 3 {
+		t.Errorf("Expected the new page added to the cache, got %d pages", pages)
+	}
+	for _, key := range keys {
+		info, err := os.Stat(filepath.Join(cacheDir, key+".json"))
+		if err != nil || !info.ModTime().After(old) {
+			t.Errorf("Expected cached page %s to be used by the second run", key)
+		}
+	}
+	if pages := pdfPages(t, cfg.Output); pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+}
+
+func TestPageCacheDir_Prune(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openPageCacheDir(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"aa", "bb", "cc"} {
+		cache.Store(key, converter.Page{Data: make([]byte, 100), Format: "png", Width: 1, Height: 1})
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(filepath.Join(dir, key+".json"), used, used)
+	}
+	cache.Load("aa")    // Used now, so kept over bb
+	cache.maxSize = 400 // Two pages with their descriptions
+	cache.prune()
+	if keys := cachedKeys(t, dir); strings.Join(keys, ",") != "aa,cc" {
+		t.Errorf("Expected the page used longest ago pruned, got %q", keys)
+	}
+	if fileExists(filepath.Join(dir, "bb")) {
+		t.Error("Expected the pruned page's data removed too")
+	}
+}
+
+// cachedKeys returns the keys of the pages in the page directory dir.
+func cachedKeys(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, entry := range entries {
+		if key, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// pageDir is a directory of converted pages, each kept as its data in
+// <key> and its description in <key>.json. It is a converter.PageCache.
+type pageDir string
+
+// pageDirEntry describes a converted page, kept in <key>.json next to its
+// data.
+type pageDirEntry struct {
+	Format        string  `json:"format"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	DPI           float64 `json:"dpi,omitempty"`
+	PassedThrough bool    `json:"passed_through,omitempty"`
+	SourceFormat  string  `json:"source_format"`
+}
+
+// count returns how many pages d holds.
+func (d pageDir) count() int {
+	entries, _ := os.ReadDir(string(d))
+	n := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			n++
+		}
+	}
+	return n
+}
+
+// Load returns the page kept under key, for converter.PageCache.
+func (d pageDir) Load(key string) (converter.Page, bool) {
+	path := filepath.Join(string(d), key)
+	meta, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return converter.Page{}, false
+	}
+	var kept pageDirEntry
+	if err := json.Unmarshal(meta, &kept); err != nil {
+		return converter.Page{}, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return converter.Page{}, false
+	}
+	return converter.Page{
+		Data:          data,
+		Format:        kept.Format,
+		Width:         kept.Width,
+		Height:        kept.Height,
+		DPI:           kept.DPI,
+		PassedThrough: kept.PassedThrough,
+		Source:        converter.PageSource{Format: kept.SourceFormat},
+	}, true
+}
+
+// Store keeps page under key, for converter.PageCache. Its description is
+// written last, so a page whose writing was cut short is never loaded.
+func (d pageDir) Store(key string, page converter.Page) {
+	path := filepath.Join(string(d), key)
+	meta, _ := json.Marshal(pageDirEntry{
+		Format:        page.Format,
+		Width:         page.Width,
+		Height:        page.Height,
+		DPI:           page.DPI,
+		PassedThrough: page.PassedThrough,
+		SourceFormat:  page.Source.Format,
+	})
+	err := writeFileAtomic(path, page.Data)
+	if err == nil {
+		err = writeFileAtomic(path+".json", meta)
+	}
+	if err != nil {
+		slog.Warn("Failed to keep converted page", "page", page.Source.Filename, "dir", string(d), "error", err)
+	}
+}
+
+// writeFileAtomic writes data to path through a temporary file, so path is
+// either left as it was or holds all of data.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pageCaches looks pages up in each of its caches in turn and stores them
+// in all of them. It is a converter.PageCache.
+type pageCaches []converter.PageCache
+
+// combinePageCaches returns a page cache over the non-nil caches, or nil
+// if there are none.
+func combinePageCaches(caches ...converter.PageCache) converter.PageCache {
+	var combined pageCaches
+	for _, cache := range caches {
+		if cache != nil {
+			combined = append(combined, cache)
+		}
+	}
+	switch len(combined) {
+	case 0:
+		return nil
+	case 1:
+		return combined[0]
+	}
+	return combined
+}
+
+// Load returns the page kept under key by the first cache that has it.
+func (c pageCaches) Load(key string) (converter.Page, bool) {
+	for _, cache := range c {
+		if page, ok := cache.Load(key); ok {
+			return page, true
+		}
+	}
+	return converter.Page{}, false
+}
+
+// Store keeps page under key in every cache.
+func (c pageCaches) Store(key string, page converter.Page) {
+	for _, cache := range c {
+		cache.Store(key, page)
+	}
+}
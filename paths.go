@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// resolvePaths fills in the directories derived from DataDir so that, unless
+// explicitly overridden, everything the server writes lives under one
+// writable directory. This keeps the server usable with a read-only root
+// filesystem where only a single volume (or tmpfs) is mounted writable.
+func resolvePaths(cfg *Config) {
+	if cfg.DataDir == "" {
+		cfg.DataDir = filepath.Join(os.TempDir(), "manga_to_pdf")
+	}
+	if cfg.TempDir == "" {
+		cfg.TempDir = filepath.Join(cfg.DataDir, "tmp")
+	}
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = filepath.Join(cfg.DataDir, "cache")
+	}
+	if cfg.SlowLogFile == "" {
+		cfg.SlowLogFile = filepath.Join(cfg.DataDir, "slow.log")
+	}
+}
+
+// writableDirs lists the configured directories the server needs write access to.
+func writableDirs(cfg Config) map[string]string {
+	dirs := map[string]string{
+		"data_dir":  cfg.DataDir,
+		"temp_dir":  cfg.TempDir,
+		"cache_dir": cfg.CacheDir,
+	}
+	if len(cfg.AutocertDomains) > 0 {
+		dirs["autocert cache"] = autocertCacheDir(cfg)
+	}
+	if cfg.JobStorage == "local" {
+		dirs["job storage"] = jobStorageDir(cfg)
+	}
+	if slowLogEnabled(cfg) {
+		dirs["slow-log directory"] = filepath.Dir(cfg.SlowLogFile)
+	}
+	return dirs
+}
+
+// checkWritableDirs creates every configured directory and verifies it is
+// writable by creating and removing a probe file. It is run at startup so a
+// misconfigured volume fails fast instead of on the first large upload.
+func checkWritableDirs(cfg Config) error {
+	for name, dir := range writableDirs(cfg) {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("%s %q cannot be created: %w", name, dir, err)
+		}
+		probe, err := os.CreateTemp(dir, ".write-check-*")
+		if err != nil {
+			return fmt.Errorf("%s %q is not writable: %w", name, dir, err)
+		}
+		probe.Close()
+		if err := os.Remove(probe.Name()); err != nil {
+			return fmt.Errorf("%s %q does not allow removing files: %w", name, dir, err)
+		}
+		slog.Debug("Directory self-check passed", "name", name, "dir", dir)
+	}
+	return nil
+}
+
+// jobStorageDir is where job_storage "local" keeps /jobs outputs.
+func jobStorageDir(cfg Config) string {
+	return filepath.Join(cfg.DataDir, "jobs")
+}
+
+// webhookStateFile is where pending /jobs webhook deliveries and dead
+// letters are kept across restarts.
+func webhookStateFile(cfg Config) string {
+	return filepath.Join(cfg.DataDir, "webhooks.json")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePaths_DefaultsUnderDataDir(t *testing.T) {
+	cfg := Config{DataDir: "/data", CacheDir: "/custom/cache"}
+	resolvePaths(&cfg)
+	if cfg.TempDir != filepath.Join("/data", "tmp") {
+		t.Errorf("Expected temp dir under data dir, got %q", cfg.TempDir)
+	}
+	if cfg.CacheDir != "/custom/cache" {
+		t.Errorf("Expected explicit cache dir to be kept, got %q", cfg.CacheDir)
+	}
+}
+
+func TestCheckWritableDirs(t *testing.T) {
+	cfg := Config{DataDir: t.TempDir()}
+	resolvePaths(&cfg)
+	if err := checkWritableDirs(cfg); err != nil {
+		t.Fatalf("Expected writable dirs to pass the self-check, got %v", err)
+	}
+	if _, err := os.Stat(cfg.CacheDir); err != nil {
+		t.Errorf("Expected cache dir to be created: %v", err)
+	}
+
+	// A path below a regular file can never be created, regardless of privileges.
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatalf("Failed to create blocker file: %v", err)
+	}
+	cfg.TempDir = filepath.Join(blocker, "tmp")
+	if err := checkWritableDirs(cfg); err == nil {
+		t.Error("Expected self-check to fail for an uncreatable temp dir")
+	}
+}
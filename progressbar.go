@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// progressOutput is where the progress bar is drawn, if progressIsTerminal
+// says it is a terminal.
+var (
+	progressOutput     io.Writer = os.Stdout
+	progressIsTerminal           = isTerminal
+)
+
+const (
+	progressBarWidth    = 30                     // Characters of the bar itself
+	progressRedrawEvery = 100 * time.Millisecond // Redraw at most this often
+)
+
+// progressBar draws the pages a conversion has processed, its throughput
+// and the time left on a single terminal line while it runs.
+type progressBar struct {
+	out     io.Writer
+	label   string
+	total   int // 0 if the number of pages is not known
+	started time.Time
+	restore func() // Puts the logger back
+
+	mu     sync.Mutex
+	done   int // Pages added or failed
+	failed int
+	drawn  time.Time // When the line was last drawn; zero while it is cleared
+}
+
+// newProgressBar returns the progress bar for a conversion of total pages
+// (0 if unknown) to the output named label, or nil if there is to be none:
+// without cfg.Progress, with verbose logging, with a local server doing
+// the work or when progressOutput is not a terminal. Until finish, log
+// records clear the bar before they are written so they don't run into it;
+// as with captureWarnings, the default logger must be one set with
+// slog.SetDefault.
+func newProgressBar(cfg Config, label string, total int) *progressBar {
+	if !cfg.Progress || cfg.VerboseLogging || cfg.server != nil || !progressIsTerminal(progressOutput) {
+		return nil
+	}
+	b := &progressBar{out: progressOutput, label: label, total: total, started: time.Now()}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(&progressHandler{Handler: previous.Handler(), bar: b}))
+	b.restore = func() { slog.SetDefault(previous) }
+	b.draw()
+	return b
+}
+
+// isTerminal reports whether w is a terminal that can redraw a line.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progress returns a converter.ProgressFunc that updates the bar and
+// passes every event on to next, if set.
+func (b *progressBar) progress(next converter.ProgressFunc) converter.ProgressFunc {
+	return func(event converter.ProgressEvent) {
+		if next != nil {
+			next(event)
+		}
+		if event.Stage != converter.ProgressPageAdded && event.Stage != converter.ProgressFailed {
+			return
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.done++
+		if event.Stage == converter.ProgressFailed {
+			b.failed++
+		}
+		if time.Since(b.drawn) >= progressRedrawEvery {
+			b.draw()
+		}
+	}
+}
+
+// finish draws the bar a last time and leaves it on its line. It is a
+// no-op on a nil bar.
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	b.restore()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw()
+	fmt.Fprintln(b.out)
+	b.drawn = time.Time{}
+}
+
+// draw writes the bar over the current line. b.mu must be held.
+func (b *progressBar) draw() {
+	elapsed := time.Since(b.started)
+	done := b.done
+	if b.total > 0 {
+		done = min(done, b.total) // Target size conversions go over the pages again
+	}
+	var line strings.Builder
+	line.WriteString("\r\033[K" + b.label + " ")
+	if b.total > 0 {
+		filled := done * progressBarWidth / b.total
+		fmt.Fprintf(&line, "[%s%s] %d/%d pages", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), done, b.total)
+	} else {
+		fmt.Fprintf(&line, "%d pages", done)
+	}
+	if b.failed > 0 {
+		fmt.Fprintf(&line, ", %d failed", b.failed)
+	}
+	if seconds := elapsed.Seconds(); done > 0 && seconds > 0 {
+		rate := float64(done) / seconds
+		fmt.Fprintf(&line, "  %.1f pages/s", rate)
+		if b.total > 0 && done < b.total {
+			eta := time.Duration(float64(b.total-done) / rate * float64(time.Second))
+			fmt.Fprintf(&line, "  ETA %s", eta.Round(time.Second))
+		}
+	}
+	io.WriteString(b.out, line.String())
+	b.drawn = time.Now()
+}
+
+// clear removes the bar from its line, if it is drawn. b.mu must be held.
+func (b *progressBar) clear() {
+	if !b.drawn.IsZero() {
+		io.WriteString(b.out, "\r\033[K")
+	}
+}
+
+// progressHandler clears the progress bar before passing each record on to
+// Handler, and draws it again afterwards.
+type progressHandler struct {
+	slog.Handler
+	bar *progressBar
+}
+
+func (h *progressHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.bar.mu.Lock()
+	defer h.bar.mu.Unlock()
+	wasDrawn := !h.bar.drawn.IsZero()
+	h.bar.clear()
+	err := h.Handler.Handle(ctx, r)
+	if wasDrawn {
+		h.bar.draw()
+	}
+	return err
+}
+
+func (h *progressHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &progressHandler{Handler: h.Handler.WithAttrs(attrs), bar: h.bar}
+}
+
+func (h *progressHandler) WithGroup(name string) slog.Handler {
+	return &progressHandler{Handler: h.Handler.WithGroup(name), bar: h.bar}
+}
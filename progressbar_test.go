@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunApp_ProgressBar(t *testing.T) {
+	var logs bytes.Buffer
+	originalLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(originalLogger)
+	var bar bytes.Buffer
+	progressOutput, progressIsTerminal = &bar, func(io.Writer) bool { return true }
+	defer func() { progressOutput, progressIsTerminal = os.Stdout, isTerminal }()
+
+	dir := t.TempDir()
+	for _, name := range []string{"1.png", "2.png", "3.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.Progress = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(bar.String(), "\n"), "\n")
+	last := lines[len(lines)-1]
+	if !strings.Contains(last, "vol.pdf [") || !strings.HasSuffix(last, "pages/s") || !strings.Contains(last, "3/3 pages") {
+		t.Errorf("Expected the finished bar to be left on its line, got %q", last)
+	}
+	if !strings.Contains(logs.String(), "Wrote output") {
+		t.Error("Expected logging to go on after the bar")
+	}
+
+	// Without a terminal, or with -verbose, there is no bar.
+	bar.Reset()
+	progressIsTerminal = func(io.Writer) bool { return false }
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	progressIsTerminal = func(io.Writer) bool { return true }
+	cfg.VerboseLogging = true
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+	if bar.Len() != 0 {
+		t.Errorf("Expected no bar, got %q", bar.String())
+	}
+}
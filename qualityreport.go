@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// Bounds of the thumbnails in a quality report, in pixels.
+const (
+	reportThumbWidth  = 240
+	reportThumbHeight = 360
+)
+
+// qualityReport collects what a conversion did to every page for
+// -quality-report: thumbnails of the page before and after, their sizes
+// and the transforms that were applied.
+type qualityReport struct {
+	mu    sync.Mutex
+	pages map[int]reportPage // By source index; a later target size pass replaces an earlier one
+}
+
+// reportPage is one row of a quality report.
+type reportPage struct {
+	Index       int
+	Filename    string
+	Before      template.URL // data: URL of the thumbnail, "" if there is none
+	After       template.URL
+	BeforeSize  string // Pixel dimensions, e.g. "1200x1800"
+	AfterSize   string
+	SourceBytes int64
+	PageBytes   int64
+	Encoding    string // How the page was embedded, e.g. "jpeg q85"
+	Transforms  []string
+	Error       string
+}
+
+// Delta is the change from the source to the page size, in percent.
+func (p reportPage) Delta() string {
+	return sizeDelta(p.SourceBytes, p.PageBytes)
+}
+
+func newQualityReport() *qualityReport {
+	return &qualityReport{pages: make(map[int]reportPage)}
+}
+
+// qualityReportPath is where the quality report for output is written:
+// next to it, as name.report.html.
+func qualityReportPath(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".report.html"
+}
+
+// add records page; it is a converter.InspectFunc. The thumbnails are
+// made right away, as the page data is only valid during the call.
+func (r *qualityReport) add(page converter.PageInspection) {
+	row := reportPage{
+		Index:       page.Source.Index,
+		Filename:    page.Source.Filename,
+		SourceBytes: page.Source.Bytes,
+		PageBytes:   int64(len(page.Data)),
+		Transforms:  page.Transforms,
+	}
+	if page.Before != nil {
+		row.Before = thumbnailURL(page.Before)
+		row.BeforeSize = fmt.Sprintf("%dx%d", page.Before.Bounds().Dx(), page.Before.Bounds().Dy())
+	}
+	switch {
+	case page.Err != nil:
+		row.Error = page.Err.Error()
+	case page.PassedThrough:
+		row.Encoding = page.Format + " (passed through)"
+	case page.Quality > 0:
+		row.Encoding = fmt.Sprintf("%s q%d", page.Format, page.Quality)
+	default:
+		row.Encoding = page.Format
+	}
+	if page.Err == nil {
+		row.AfterSize = fmt.Sprintf("%dx%d", page.Width, page.Height)
+		if after, _, err := image.Decode(bytes.NewReader(page.Data)); err == nil {
+			row.After = thumbnailURL(after)
+		}
+	}
+	r.mu.Lock()
+	r.pages[row.Index] = row
+	r.mu.Unlock()
+}
+
+// write writes the report on the conversion to output as an HTML page
+// with the thumbnails embedded, so it can be moved or mailed on its own.
+func (r *qualityReport) write(path, output string, stats converter.Stats) error {
+	r.mu.Lock()
+	pages := make([]reportPage, 0, len(r.pages))
+	for _, page := range r.pages {
+		pages = append(pages, page)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(pages, func(a, b reportPage) int { return a.Index - b.Index })
+
+	var sourceBytes, pageBytes int64
+	for _, page := range pages {
+		if page.Error == "" {
+			sourceBytes += page.SourceBytes
+			pageBytes += page.PageBytes
+		}
+	}
+	var buf bytes.Buffer
+	err := qualityReportTemplate.Execute(&buf, map[string]any{
+		"Output":      filepath.Base(output),
+		"Pages":       pages,
+		"Stats":       stats,
+		"SourceBytes": sourceBytes,
+		"PageBytes":   pageBytes,
+		"Delta":       sizeDelta(sourceBytes, pageBytes),
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// thumbnailURL returns a data: URL of a JPEG thumbnail of img.
+func thumbnailURL(img image.Image) template.URL {
+	var buf bytes.Buffer
+	thumb := imaging.Fit(img, reportThumbWidth, reportThumbHeight, imaging.Box)
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 75}); err != nil {
+		return ""
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// sizeDelta formats the change from before to after bytes in percent.
+func sizeDelta(before, after int64) string {
+	if before == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+.0f%%", float64(after-before)*100/float64(before))
+}
+
+// humanBytes formats n bytes for reading, e.g. "1.4 MB".
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+var qualityReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes": humanBytes,
+	"page":  func(index int) int { return index + 1 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Quality report: {{.Output}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.5em; text-align: left; vertical-align: top; }
+img { max-width: 240px; max-height: 360px; background: #eee; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>{{.Output}}</h1>
+<p>{{.Stats.PagesAdded}} pages added, {{.Stats.PagesFailed}} failed.
+Sources {{bytes .SourceBytes}}, pages {{bytes .PageBytes}} ({{.Delta}}), output {{bytes .Stats.OutputBytes}}.</p>
+<table>
+<tr><th>#</th><th>Before</th><th>After</th><th>Page</th></tr>
+{{range .Pages}}<tr>
+<td>{{page .Index}}</td>
+<td>{{if .Before}}<img src="{{.Before}}" alt="{{.Filename}} before"><br>{{end}}{{.BeforeSize}}</td>
+<td>{{if .After}}<img src="{{.After}}" alt="{{.Filename}} after"><br>{{end}}{{.AfterSize}}</td>
+<td><strong>{{.Filename}}</strong><br>
+{{if .Error}}<span class="failed">Failed: {{.Error}}</span>{{else}}{{bytes .SourceBytes}} &rarr; {{bytes .PageBytes}} ({{.Delta}})<br>
+{{.Encoding}}<br>
+{{if .Transforms}}Transforms: {{range $i, $t := .Transforms}}{{if $i}}, {{end}}{{$t}}{{end}}{{else}}No transforms{{end}}{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunApp_QualityReport(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.png", "2.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	cfg.MaxWidth = 4
+	cfg.QualityReport = true
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp failed: %v", err)
+	}
+
+	data, err := os.ReadFile(qualityReportPath(cfg.Output))
+	if err != nil {
+		t.Fatalf("Report not written: %v", err)
+	}
+	report := string(data)
+	if n := strings.Count(report, `src="data:image/jpeg;base64,`); n != 4 {
+		t.Errorf("Expected a thumbnail before and after for each of 2 pages, got %d", n)
+	}
+	for _, want := range []string{"<h1>vol.pdf</h1>", "<strong>1.png</strong>", "<strong>2.png</strong>", "8x8", "4x4", "Transforms: downscale"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected %q in the report", want)
+		}
+	}
+}
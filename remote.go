@@ -0,0 +1,380 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"manga_to_pdf/api"
+	"manga_to_pdf/internal/converter"
+)
+
+// remoteClient drives the /jobs API of a manga_to_pdf server.
+type remoteClient struct {
+	server *url.URL
+	token  string // Bearer token, if the server requires one
+	client *http.Client
+}
+
+// runRemoteCommand runs `remote submit|status|download` against the server
+// named by -server. It returns the process exit code: 0 on success, 1 when a
+// request or the job failed and 2 on usage errors.
+func runRemoteCommand(ctx context.Context, args []string, out, errOut io.Writer) int {
+	flagSet := flag.NewFlagSet("remote", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: manga_to_pdf remote -server URL [-token T] submit [-config JSON] [-webhook URL] [-wait] [-o file] images-or-dirs...")
+		fmt.Fprintln(errOut, "       manga_to_pdf remote -server URL [-token T] status JOB")
+		fmt.Fprintln(errOut, "       manga_to_pdf remote -server URL [-token T] download [-o file] JOB")
+		flagSet.PrintDefaults()
+	}
+	server := flagSet.String("server", "", "Base URL of the server, e.g. http://converter:8080 (required)")
+	token := flagSet.String("token", "", "Bearer token for servers with AUTH_TOKENS")
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	serverURL, err := url.Parse(*server)
+	if *server == "" || err != nil || (serverURL.Scheme != "http" && serverURL.Scheme != "https") {
+		fmt.Fprintln(errOut, "remote: -server must be an http or https URL")
+		return 2
+	}
+	if flagSet.NArg() == 0 {
+		flagSet.Usage()
+		return 2
+	}
+	rc := &remoteClient{server: serverURL, token: *token, client: &http.Client{}}
+
+	command, args := flagSet.Arg(0), flagSet.Args()[1:]
+	sub := flag.NewFlagSet("remote "+command, flag.ContinueOnError)
+	sub.SetOutput(errOut)
+	output := sub.String("o", "", "Where to save the output (default: the job's filename in the current directory)")
+	var configJSON, webhook *string
+	var wait *bool
+	switch command {
+	case "submit":
+		configJSON = sub.String("config", "", `Conversion settings as the API's config JSON, e.g. '{"output_filename": "vol1.pdf"}'`)
+		webhook = sub.String("webhook", "", "URL the server notifies when the job finishes")
+		wait = sub.Bool("wait", false, "Wait for the job and download its output")
+	case "status", "download":
+	default:
+		fmt.Fprintf(errOut, "remote: unknown command %q\n", command)
+		flagSet.Usage()
+		return 2
+	}
+	if err := sub.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	switch command {
+	case "submit":
+		if sub.NArg() == 0 {
+			fmt.Fprintln(errOut, "remote submit: no images given")
+			return 2
+		}
+		files, err := remoteUploadFiles(sub.Args())
+		if err != nil {
+			fmt.Fprintln(errOut, "remote submit:", err)
+			return 1
+		}
+		status, err := rc.submit(ctx, files, *configJSON, *webhook)
+		if err != nil {
+			fmt.Fprintln(errOut, "remote submit:", err)
+			return 1
+		}
+		fmt.Fprintf(out, "Submitted job %s with %d images\n", status.ID, len(files))
+		if !*wait {
+			return 0
+		}
+		if status, err = rc.wait(ctx, status.ID); err != nil {
+			fmt.Fprintln(errOut, "remote submit:", err)
+			return 1
+		}
+		return rc.downloadCommand(ctx, status, *output, out, errOut)
+	default:
+		if sub.NArg() != 1 {
+			fmt.Fprintf(errOut, "remote %s: expected one job ID\n", command)
+			return 2
+		}
+		status, err := rc.status(ctx, sub.Arg(0))
+		if err != nil {
+			fmt.Fprintf(errOut, "remote %s: %v\n", command, err)
+			return 1
+		}
+		if command == "download" {
+			return rc.downloadCommand(ctx, status, *output, out, errOut)
+		}
+		printJobStatus(out, status)
+		if status.Status == api.JobFailed {
+			return 1
+		}
+		return 0
+	}
+}
+
+// remoteUploadFiles expands args into the image files to upload: files are
+// taken as given, directories contribute their images in the order a local
+// conversion would use.
+func remoteUploadFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		chapters, err := scanChapters(Config{Input: arg})
+		if err != nil {
+			return nil, err
+		}
+		for _, ch := range chapters {
+			for _, name := range ch.Files {
+				files = append(files, filepath.Join(ch.Dir, name))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no supported images in %s: %w", strings.Join(args, ", "), converter.ErrNoSupportedImages)
+	}
+	return files, nil
+}
+
+// submit uploads files as a new job. The request body is streamed from disk,
+// so a whole volume is never held in memory.
+func (rc *remoteClient) submit(ctx context.Context, files []string, configJSON, webhook string) (api.JobStatus, error) {
+	body, writer := io.Pipe()
+	mw := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeRemoteUpload(mw, files, configJSON, webhook))
+	}()
+	req, err := rc.request(ctx, http.MethodPost, "/jobs", body)
+	if err != nil {
+		body.Close()
+		return api.JobStatus{}, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var status api.JobStatus
+	err = rc.doJSON(req, &status)
+	return status, err
+}
+
+// writeRemoteUpload writes the multipart form of a job: the config and
+// webhook fields, then every file as an "images" part.
+func writeRemoteUpload(mw *multipart.Writer, files []string, configJSON, webhook string) error {
+	if configJSON != "" {
+		if err := mw.WriteField("config", configJSON); err != nil {
+			return err
+		}
+	}
+	if webhook != "" {
+		if err := mw.WriteField("webhook_url", webhook); err != nil {
+			return err
+		}
+	}
+	for _, name := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="images"; filename=%q`, filepath.Base(name)))
+		header.Set("Content-Type", cmp.Or(converter.GetContentTypeFromFilename(name), "application/octet-stream"))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("could not upload %s: %w", name, err)
+		}
+	}
+	return mw.Close()
+}
+
+// status fetches the status of job id.
+func (rc *remoteClient) status(ctx context.Context, id string) (api.JobStatus, error) {
+	req, err := rc.request(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil)
+	if err != nil {
+		return api.JobStatus{}, err
+	}
+	var status api.JobStatus
+	err = rc.doJSON(req, &status)
+	return status, err
+}
+
+// wait follows the event stream of job id, which ends when the job does,
+// and returns the final status.
+func (rc *remoteClient) wait(ctx context.Context, id string) (api.JobStatus, error) {
+	for {
+		req, err := rc.request(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/events", nil)
+		if err != nil {
+			return api.JobStatus{}, err
+		}
+		resp, err := rc.do(req)
+		if err != nil {
+			return api.JobStatus{}, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		status, err := rc.status(ctx, id)
+		if err != nil || status.Status != api.JobRunning {
+			return status, err
+		}
+		// The stream was cut short, e.g. by a proxy timeout; follow it again.
+		select {
+		case <-ctx.Done():
+			return api.JobStatus{}, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// downloadCommand saves the output of a finished job to output, or to the
+// job's filename in the current directory, never overwriting a file.
+func (rc *remoteClient) downloadCommand(ctx context.Context, status api.JobStatus, output string, out, errOut io.Writer) int {
+	switch {
+	case status.Status == api.JobFailed:
+		fmt.Fprintf(errOut, "remote: job %s failed: %s\n", status.ID, status.Error)
+		return 1
+	case status.Status != api.JobDone || status.Download == "":
+		fmt.Fprintf(errOut, "remote: job %s is %s, try again once it is done\n", status.ID, status.Status)
+		return 1
+	}
+	if output == "" {
+		// The name comes from the server; only its last element is used.
+		output = path.Base(strings.ReplaceAll(status.Filename, `\`, "/"))
+		if output == "." || output == "/" || output == ".." {
+			output = status.ID + ".pdf"
+		}
+	}
+	saved, err := rc.download(ctx, status, output)
+	if err != nil {
+		fmt.Fprintln(errOut, "remote download:", err)
+		return 1
+	}
+	fmt.Fprintf(out, "Saved job %s to %s\n", status.ID, saved)
+	return 0
+}
+
+// download fetches the output of a finished job into a new file at output
+// and returns the path written.
+func (rc *remoteClient) download(ctx context.Context, status api.JobStatus, output string) (string, error) {
+	req, err := rc.request(ctx, http.MethodGet, status.Download, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := rc.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	file, saved, err := createOutput(Config{OnExists: "rename"}, output)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(saved)
+		return "", err
+	}
+	return saved, nil
+}
+
+// request builds a request for ref, a path on the server or an absolute
+// URL such as a presigned storage download.
+func (rc *remoteClient) request(ctx context.Context, method, ref string, body io.Reader) (*http.Request, error) {
+	target, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !target.IsAbs() {
+		target = rc.server.JoinPath(target.Path)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if rc.token != "" && target.Host == rc.server.Host {
+		req.Header.Set("Authorization", "Bearer "+rc.token)
+	}
+	return req, nil
+}
+
+// do sends req and turns answers other than 2xx into errors carrying the
+// server's error message.
+func (rc *remoteClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var apiErr api.APIErrorResponse
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiErr) == nil && apiErr.Error != "" {
+		if apiErr.Details != nil {
+			return nil, fmt.Errorf("server answered %s: %s (%v)", resp.Status, apiErr.Error, apiErr.Details)
+		}
+		return nil, fmt.Errorf("server answered %s: %s", resp.Status, apiErr.Error)
+	}
+	return nil, fmt.Errorf("server answered %s", resp.Status)
+}
+
+// doJSON sends req and decodes the JSON answer into v.
+func (rc *remoteClient) doJSON(req *http.Request, v interface{}) error {
+	resp, err := rc.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid answer from server: %w", err)
+	}
+	return nil
+}
+
+// printJobStatus prints the status of a job, one field per line.
+func printJobStatus(w io.Writer, status api.JobStatus) {
+	fmt.Fprintf(w, "Job:      %s\n", status.ID)
+	fmt.Fprintf(w, "Status:   %s\n", status.Status)
+	fmt.Fprintf(w, "Created:  %s\n", status.CreatedAt.Local().Format(time.DateTime))
+	if status.FinishedAt != nil {
+		fmt.Fprintf(w, "Finished: %s\n", status.FinishedAt.Local().Format(time.DateTime))
+	}
+	if status.Filename != "" {
+		fmt.Fprintf(w, "Output:   %s\n", status.Filename)
+	}
+	if status.Error != "" {
+		fmt.Fprintf(w, "Error:    %s\n", status.Error)
+	}
+	for _, failure := range status.Failures {
+		fmt.Fprintf(w, "Failed:   %s (%s): %s\n", failure.Source, failure.Stage, failure.Error)
+	}
+}
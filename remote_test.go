@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"manga_to_pdf/api"
+)
+
+func TestRunRemoteCommand(t *testing.T) {
+	jobs := api.NewJobManager(api.Options{}, 0)
+	defer jobs.Close()
+	server := httptest.NewServer(api.RequireBearerToken([]string{"secret"}, jobs.Handler()))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"p2.png", "p10.png", "p1.png"} {
+		writePNG(t, filepath.Join(dir, name))
+	}
+	run := func(args ...string) (int, string, string) {
+		t.Helper()
+		var out, errOut bytes.Buffer
+		code := runRemoteCommand(context.Background(), append([]string{"-server", server.URL, "-token", "secret"}, args...), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	output := filepath.Join(t.TempDir(), "vol.pdf")
+	code, out, errOut := run("submit", "-config", `{"output_filename": "vol.pdf"}`, "-wait", "-o", output, dir)
+	if code != 0 {
+		t.Fatalf("submit -wait exited with %d: %s%s", code, out, errOut)
+	}
+	if !strings.Contains(out, "with 3 images") || !strings.Contains(out, "Saved job") {
+		t.Errorf("Unexpected output:\n%s", out)
+	}
+	if data, err := os.ReadFile(output); err != nil || !bytes.HasPrefix(data, []byte("%PDF")) {
+		t.Fatalf("Expected the PDF at %s: %v", output, err)
+	}
+
+	id := strings.Fields(strings.TrimPrefix(out, "Submitted job "))[0]
+	if code, out, _ := run("status", id); code != 0 || !strings.Contains(out, "done") || !strings.Contains(out, "vol.pdf") {
+		t.Errorf("Unexpected status (%d):\n%s", code, out)
+	}
+	// The first download is not overwritten.
+	if code, out, errOut := run("download", "-o", output, id); code != 0 || !strings.Contains(out, "vol (1).pdf") {
+		t.Errorf("Expected a renamed download (%d): %s%s", code, out, errOut)
+	}
+
+	if code, _, errOut := run("status", "missing"); code != 1 || !strings.Contains(errOut, "404") {
+		t.Errorf("Expected an unknown job to fail with the server's answer, got %d: %s", code, errOut)
+	}
+	var errOutput bytes.Buffer
+	if code := runRemoteCommand(context.Background(), []string{"-server", server.URL, "status", id}, &bytes.Buffer{}, &errOutput); code != 1 || !strings.Contains(errOutput.String(), "401") {
+		t.Errorf("Expected a missing token to be refused, got %d: %s", code, errOutput.String())
+	}
+	if code, _, _ := run("frobnicate"); code != 2 {
+		t.Errorf("Expected an unknown command to be a usage error, got %d", code)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall" // For SIGTERM
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"manga_to_pdf/api"
+	"manga_to_pdf/internal/converter"
+)
+
+// runServer serves the HTTP API with cfg until a shutdown signal, then lets
+// running requests finish for cfg.ShutdownTimeout. It returns the process
+// exit code: 0 after a clean shutdown, 1 if the server could not start.
+func runServer(cfg Config) int {
+	slog.Info("Starting API server...", "address", cfg.ListenAddress, "verbose_logging", cfg.VerboseLogging,
+		"max_upload", cfg.MaxUploadBytes.String(), "workers", cfg.Workers, "auth_enabled", len(cfg.AuthTokens) > 0, "admin_enabled", len(cfg.AdminTokens) > 0, "data_dir", cfg.DataDir, "job_storage", cfg.JobStorage)
+
+	if err := checkWritableDirs(cfg); err != nil {
+		slog.Error("Startup self-check failed; set DATA_DIR (or -data-dir) to a writable location", "error", err)
+		return 1
+	}
+	// Other temporary files of the server go to os.TempDir() as well.
+	os.Setenv("TMPDIR", cfg.TempDir)
+	applyGCSettings(cfg)
+
+	slowLog, slowLogFile, err := openSlowLog(cfg)
+	if err != nil {
+		slog.Error("Failed to open slow-log", "error", err)
+		return 1
+	}
+	if slowLogFile != nil {
+		defer slowLogFile.Close()
+		slog.Info("Logging slow conversions", "file", cfg.SlowLogFile, "duration", cfg.SlowLogDuration.String(), "size", cfg.SlowLogSize.String())
+	}
+
+	jobStorage, err := openJobStorage(cfg, os.Getenv)
+	if err != nil {
+		slog.Error("Invalid job storage", "error", err)
+		return 1
+	}
+
+	webhooks, err := api.NewWebhookNotifier(webhookStateFile(cfg), api.WebhookPolicy{AllowedNetworks: webhookAllowNetworks(cfg)})
+	if err != nil {
+		slog.Error("Failed to start webhook notifier", "error", err)
+		return 1
+	}
+	defer webhooks.Close()
+
+	// Setup HTTP server and router
+	mux := http.NewServeMux()
+	apiOpts := api.Options{
+		MaxUploadBytes:    int64(cfg.MaxUploadBytes),
+		Workers:           cfg.Workers,
+		ConvertTimeout:    time.Duration(cfg.ConvertTimeout),
+		MaxMegapixels:     cfg.MaxTotalMegapixels,
+		DecodeLimits:      cfg.DecodeLimits,
+		UploadTypes:       cfg.UploadTypes,
+		SlowLog:           slowLog,
+		SlowLogDuration:   time.Duration(cfg.SlowLogDuration),
+		SlowLogBytes:      int64(cfg.SlowLogSize),
+		Storage:           jobStorage,
+		TenantQuota:       int64(cfg.TenantQuota),
+		TempDir:           cfg.TempDir,
+		MaxJobs:           cfg.MaxJobs,
+		MaxTenantJobs:     cfg.MaxTenantJobs,
+		MaxSessions:       cfg.MaxSessions,
+		MaxTenantSessions: cfg.MaxTenantSessions,
+		Webhooks:          webhooks,
+		Fetcher: converter.NewFetcher(converter.FetchPolicy{
+			MaxConnsPerHost: cfg.FetchMaxConnsPerHost,
+			HostDelay:       time.Duration(cfg.FetchHostDelay),
+			Proxy:           fetchProxy(cfg),
+		}),
+		FetchRetries:    cfg.FetchRetries,
+		FetchRetryDelay: time.Duration(cfg.FetchRetryDelay),
+	}
+	mux.Handle("/convert", api.RequireBearerToken(cfg.AuthTokens, api.NewConvertHandler(apiOpts))) // Register the /convert handler
+
+	// Background conversions with a progress event stream for web front-ends,
+	// and the upload sessions that feed them a page at a time.
+	jobs := api.NewJobManager(apiOpts, 0)
+	jobsHandler := api.RequireBearerToken(cfg.AuthTokens, jobs.Handler())
+	mux.Handle("/jobs", jobsHandler)
+	mux.Handle("/jobs/", jobsHandler)
+	mux.Handle("/sessions", jobsHandler)
+	mux.Handle("/sessions/", jobsHandler)
+	if len(cfg.AdminTokens) > 0 {
+		mux.Handle("/admin/", api.RequireBearerToken(cfg.AdminTokens, webhooks.AdminHandler()))
+	}
+
+	// Add health check endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, `{"status":"ok"}`)
+	})
+
+	// /health only says the process is up; /readyz also covers dependencies,
+	// checked once now and then periodically so failures show up early.
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	monitor := api.NewHealthMonitor(dependencyChecks(cfg), 0)
+	monitor.CheckNow(monitorCtx)
+	if cfg.HealthInterval > 0 {
+		go monitor.Run(monitorCtx, time.Duration(cfg.HealthInterval))
+	}
+	mux.Handle("/readyz", monitor.ReadyHandler())
+	// Return what large conversions left behind to the OS now and then.
+	if cfg.MemoryTrimInterval > 0 {
+		go trimMemory(monitorCtx, time.Duration(cfg.MemoryTrimInterval))
+	}
+
+	// What this build supports, for front-ends and scripts to adapt to.
+	mux.Handle("/capabilities", api.CapabilitiesHandler(api.NewCapabilities(apiVersions...)))
+
+	// Consider adding pprof endpoints for profiling if needed
+	// mux.HandleFunc("/debug/pprof/", pprof.Index)
+	// mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	// mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	// mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	// mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// Every route is also served under /v<N>/ for each of apiVersions.
+	// JSON responses are compressed; the PDF stream is passed through as is.
+	var handler http.Handler = api.Compress(api.Versioned(mux, apiVersions...))
+	if cfg.H2C && !tlsEnabled(cfg) {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	server := &http.Server{
+		Addr:    cfg.ListenAddress,
+		Handler: handler,
+		// No read or write timeout: uploads and conversions of whole
+		// volumes legitimately take minutes; CONVERT_TIMEOUT bounds those.
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+	}
+
+	// Conversions still running when the shutdown grace period ends are
+	// stopped with converter.ErrShuttingDown rather than a bare cancellation.
+	serverCtx, stopServer := context.WithCancelCause(context.Background())
+	defer stopServer(nil)
+	server.BaseContext = func(net.Listener) context.Context { return serverCtx }
+	// Background jobs are not tracked by Shutdown; stop them right away so
+	// their event streams report the failure and close within the grace period.
+	server.RegisterOnShutdown(jobs.Close)
+
+	// Graceful shutdown
+	idleConnsClosed := make(chan struct{})
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigChan
+		slog.Info("Received signal, shutting down gracefully...", "signal", sig)
+		if err := sdNotify(os.Getenv, "STOPPING=1"); err != nil {
+			slog.Warn("Failed to notify systemd of shutdown", "error", err)
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("HTTP server Shutdown error", "error", err)
+			stopServer(converter.ErrShuttingDown)
+		}
+		slog.Info("HTTP server shutdown complete.")
+		close(idleConnsClosed)
+	}()
+
+	tlsConfig, err := buildTLSConfig(cfg)
+	if err != nil {
+		slog.Error("Invalid TLS configuration", "error", err)
+		return 1
+	}
+
+	listener, err := systemdListener(os.Getenv)
+	if err != nil {
+		slog.Error("Failed to use systemd socket activation", "error", err)
+		return 1
+	}
+	if listener != nil {
+		slog.Info("Using socket passed by systemd", "address", listener.Addr().String())
+	} else {
+		listener, err = listen(cfg)
+		if err != nil {
+			slog.Error("Failed to start HTTP server", "error", err)
+			return 1
+		}
+	}
+
+	slog.Info("Server is listening", "address", listener.Addr().String(), "tls", tlsConfig != nil, "h2c", cfg.H2C && tlsConfig == nil)
+	if err := sdNotify(os.Getenv, "READY=1"); err != nil {
+		slog.Warn("Failed to notify systemd of readiness", "error", err)
+	}
+	if tlsConfig != nil {
+		// ServeTLS adds "h2" to NextProtos, enabling HTTP/2 over TLS.
+		server.TLSConfig = tlsConfig
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Failed to start HTTP server", "error", err)
+		return 1
+	}
+
+	<-idleConnsClosed // Wait for graceful shutdown to complete
+	slog.Info("Application shut down successfully.")
+	return 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// signalContext is signal.NotifyContext that records why it was canceled:
+// an interrupt (Ctrl-C) as converter.ErrCanceledByUser, SIGTERM as
+// converter.ErrShuttingDown.
+func signalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			cause := converter.ErrShuttingDown
+			if sig == os.Interrupt {
+				cause = converter.ErrCanceledByUser
+			}
+			cancel(fmt.Errorf("%w (%v)", cause, sig))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
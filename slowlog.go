@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// slowLogEnabled reports whether any slow-log threshold is configured.
+func slowLogEnabled(cfg Config) bool {
+	return cfg.SlowLogDuration > 0 || cfg.SlowLogSize > 0
+}
+
+// openSlowLog opens the slow-log file for appending and returns a logger
+// writing one JSON object per slow conversion. The file must be closed by
+// the caller. It returns a nil logger when the slow-log is disabled.
+func openSlowLog(cfg Config) (*slog.Logger, *os.File, error) {
+	if !slowLogEnabled(cfg) {
+		return nil, nil, nil
+	}
+	file, err := os.OpenFile(cfg.SlowLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open slow-log: %w", err)
+	}
+	return slog.New(slog.NewJSONHandler(file, nil)), file, nil
+}
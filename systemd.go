@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// sdListenFDsStart is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START in sd-daemon.h).
+const sdListenFDsStart = 3
+
+// systemdListener returns the listener passed by systemd socket activation,
+// or nil if the process was not socket-activated. Only the first socket is
+// used; the activation variables are cleared so child processes do not
+// inherit them.
+func systemdListener(getenv func(string) string) (net.Listener, error) {
+	pid, err := strconv.Atoi(getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(sdListenFDsStart), "LISTEN_FD_3")
+	if file == nil {
+		return nil, errors.New("systemd socket fd 3 is not valid")
+	}
+	defer file.Close() // net.FileListener dups the descriptor
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not use systemd socket: %w", err)
+	}
+	return ln, nil
+}
+
+// sdNotify sends a state string (e.g. "READY=1") to the service manager
+// when running under systemd with Type=notify. It is a no-op when
+// NOTIFY_SOCKET is not set.
+func sdNotify(getenv func(string) string, state string) error {
+	socketPath := getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	if socketPath[0] == '@' { // Abstract namespace socket
+		socketPath = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("could not send %q to notify socket: %w", state, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSystemdListener_NotActivated(t *testing.T) {
+	env := envMap(map[string]string{"LISTEN_PID": strconv.Itoa(1 << 30), "LISTEN_FDS": "1"})
+	ln, err := systemdListener(env)
+	if err != nil || ln != nil {
+		t.Errorf("Expected no listener for another process's LISTEN_PID, got %v, %v", ln, err)
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	if err := sdNotify(envMap(map[string]string{"NOTIFY_SOCKET": socketPath}), "READY=1"); err != nil {
+		t.Fatalf("sdNotify failed: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("Expected READY=1, got %q", got)
+	}
+
+	if err := sdNotify(envMap(nil), "READY=1"); err != nil {
+		t.Errorf("Expected no-op without NOTIFY_SOCKET, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// tarInputPrefix marks an -i input that is a tar stream: "tar:-" reads it
+// from stdin, "tar:<file>" from a file. An input of stdioName is "tar:-".
+const tarInputPrefix = "tar:"
+
+// runTarStream converts the images of the tar stream named by cfg.Input as
+// they arrive, in the order of the stream, so another program can pipe
+// pages in without them touching the disk. Each entry is held in memory
+// only until its page is done. The output defaults to the tar file's name
+// with the output extension; stdin has none, so -o is required, which may
+// be stdioName to write to stdout as well.
+func runTarStream(ctx context.Context, cfg Config) error {
+	name := strings.TrimPrefix(cfg.Input, tarInputPrefix)
+	output := cfg.Output
+	var input io.Reader
+	if name == stdioName {
+		if output == "" {
+			return errors.New("-o is required with -i - (use -o - for stdout)")
+		}
+		if cfg.OnExists == "prompt" && output != stdioName {
+			return errors.New("-on-exists prompt reads its answer from stdin, which -i - is reading the pages from")
+		}
+		input = os.Stdin
+	} else {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+		if output == "" {
+			output = strings.TrimSuffix(name, path.Ext(name)) + outputExt(cfg)
+		}
+	}
+	stream := &tarSources{tr: tar.NewReader(input), name: name, sniff: cfg.Sniff, maxPages: cfg.MaxPages, include: cfg.Include, exclude: cfg.Exclude}
+	return convert(ctx, cfg, output, 0, 0, func() {}, func(convCfg *converter.Config, out io.Writer, stats *converter.Stats) (bool, error) {
+		return converter.ConvertStreamToPDF(ctx, stream.next, convCfg, out, stats)
+	})
+}
+
+// tarSources reads the pages of a tar stream one entry at a time. Entries
+// that are not regular files, junk such as hidden files and thumbnails
+// (anywhere in their path), files left out by -include and -exclude, and
+// files that are not supported images are skipped. Pages in folders are bookmarked by folder.
+type tarSources struct {
+	tr       *tar.Reader
+	name     string // Input name for errors
+	sniff    bool   // Detect extension-less images by their magic bytes
+	maxPages int    // Fail once the stream has more pages than this (0 = no limit)
+	pages    int
+
+	include, exclude []string // -include and -exclude patterns
+}
+
+// next is the converter.SourceStream of the tar stream.
+func (t *tarSources) next() (converter.ImageSource, error) {
+	for {
+		hdr, err := t.tr.Next()
+		if err == io.EOF {
+			return converter.ImageSource{}, io.EOF
+		}
+		if err != nil {
+			return converter.ImageSource{}, fmt.Errorf("%s: %w", t.name, err)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() || tarJunk(hdr.Name) {
+			continue
+		}
+		if reason := filterReason(hdr.Name, t.include, t.exclude); reason != "" {
+			slog.Info("Skipped file", "file", hdr.Name, "reason", reason)
+			continue
+		}
+		contentType := converter.GetContentTypeFromFilename(hdr.Name)
+		if contentType == "" && (!t.sniff || path.Ext(hdr.Name) != "") {
+			slog.Debug("Skipping tar entry that is not a supported image", "entry", hdr.Name)
+			continue
+		}
+		// The entry must be read before the stream can move on to the next.
+		data, err := io.ReadAll(t.tr)
+		if err != nil {
+			return converter.ImageSource{}, fmt.Errorf("%s: reading %s: %w", t.name, hdr.Name, err)
+		}
+		if contentType == "" {
+			if contentType = converter.DetectContentType(data); contentType == "" {
+				slog.Debug("Skipping tar entry that is not a supported image", "entry", hdr.Name)
+				continue
+			}
+		}
+		if t.maxPages > 0 && t.pages >= t.maxPages {
+			return converter.ImageSource{}, fmt.Errorf("%s has more than %d pages; check the input or raise -max-pages (0 disables the limit)", t.name, t.maxPages)
+		}
+		chapter := path.Dir(path.Clean(hdr.Name))
+		if chapter == "." {
+			chapter = ""
+		}
+		t.pages++
+		return converter.ImageSource{
+			OriginalFilename: hdr.Name,
+			Reader:           io.NopCloser(bytes.NewReader(data)),
+			ContentType:      contentType,
+			Index:            t.pages - 1,
+			Chapter:          chapter,
+		}, nil
+	}
+}
+
+// tarJunk reports whether any part of a tar entry's path is clutter rather
+// than a page.
+func tarJunk(name string) bool {
+	for _, part := range strings.Split(path.Clean(name), "/") {
+		if junkReason(part) != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertCacheDir returns where ACME account keys and certificates are
+// kept: <cache_dir>/autocert, or <data_dir>/autocert where earlier versions
+// kept them if that exists, so their certificates are not issued again.
+func autocertCacheDir(cfg Config) string {
+	legacy := filepath.Join(cfg.DataDir, "autocert")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	return filepath.Join(cfg.CacheDir, "autocert")
+}
+
+// tlsEnabled reports whether the server is configured to speak HTTPS.
+func tlsEnabled(cfg Config) bool {
+	return cfg.TLSCert != "" || cfg.TLSKey != "" || len(cfg.AutocertDomains) > 0
+}
+
+// buildTLSConfig returns the TLS configuration for the listener, or nil when
+// TLS is disabled. A static certificate (TLSCert/TLSKey) and automatic ACME
+// certificates (AutocertDomains) are mutually exclusive.
+//
+// Autocert answers the TLS-ALPN-01 challenge on the TLS listener itself, so
+// the server must be reachable on port 443 for the configured domains.
+func buildTLSConfig(cfg Config) (*tls.Config, error) {
+	hasStatic := cfg.TLSCert != "" || cfg.TLSKey != ""
+	hasAutocert := len(cfg.AutocertDomains) > 0
+
+	switch {
+	case hasStatic && hasAutocert:
+		return nil, errors.New("tls_cert/tls_key and autocert_domains cannot be used together")
+	case hasStatic:
+		if cfg.TLSCert == "" || cfg.TLSKey == "" {
+			return nil, errors.New("both tls_cert and tls_key must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load TLS key pair: %w", err)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	case hasAutocert:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(autocertCacheDir(cfg)),
+			Email:      cfg.AutocertEmail,
+		}
+		// TLSConfig offers h2 first, so clients get HTTP/2 over TLS, and
+		// acme-tls/1 for the TLS-ALPN-01 challenge.
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, nil
+	default:
+		return nil, nil
+	}
+}
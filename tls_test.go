@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a throwaway certificate/key pair and returns their paths.
+func writeSelfSignedCert(t *testing.T) (certPath, keyPath string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certPath, keyPath
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	certPath, keyPath := writeSelfSignedCert(t)
+
+	tlsConfig, err := buildTLSConfig(Config{})
+	if err != nil || tlsConfig != nil {
+		t.Errorf("Expected TLS to be disabled by default, got %v, %v", tlsConfig, err)
+	}
+
+	tlsConfig, err = buildTLSConfig(Config{TLSCert: certPath, TLSKey: keyPath})
+	if err != nil {
+		t.Fatalf("Expected static key pair to load, got %v", err)
+	}
+	if len(tlsConfig.Certificates) != 1 {
+		t.Errorf("Expected one certificate, got %d", len(tlsConfig.Certificates))
+	}
+
+	if _, err := buildTLSConfig(Config{TLSCert: certPath}); err == nil {
+		t.Error("Expected error when tls_key is missing")
+	}
+	if _, err := buildTLSConfig(Config{TLSCert: certPath, TLSKey: keyPath, AutocertDomains: []string{"example.com"}}); err == nil {
+		t.Error("Expected error when combining static certificates with autocert")
+	}
+
+	tlsConfig, err = buildTLSConfig(Config{DataDir: t.TempDir(), AutocertDomains: []string{"example.com"}})
+	if err != nil || tlsConfig == nil || tlsConfig.GetCertificate == nil {
+		t.Errorf("Expected autocert TLS config with GetCertificate, got %v, %v", tlsConfig, err)
+	}
+}
+
+func TestBuildTLSConfig_AutocertNegotiatesHTTP2(t *testing.T) {
+	tlsConfig, err := buildTLSConfig(Config{DataDir: t.TempDir(), AutocertDomains: []string{"localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Serve a self-signed certificate in place of one from Let's Encrypt.
+	cert, err := tls.LoadX509KeyPair(writeSelfSignedCert(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil }
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.NotFoundHandler(), TLSConfig: tlsConfig, ErrorLog: log.New(io.Discard, "", 0)}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	defer conn.Close()
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+		t.Errorf("Expected autocert connections to negotiate h2, got %q", proto)
+	}
+}
+
+func TestAutocertCacheDir(t *testing.T) {
+	cfg := Config{DataDir: t.TempDir()}
+	resolvePaths(&cfg)
+	if got, want := autocertCacheDir(cfg), filepath.Join(cfg.CacheDir, "autocert"); got != want {
+		t.Errorf("Expected the autocert cache in %s, got %s", want, got)
+	}
+	// Certificates cached where earlier versions kept them stay there.
+	legacy := filepath.Join(cfg.DataDir, "autocert")
+	if err := os.Mkdir(legacy, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if got := autocertCacheDir(cfg); got != legacy {
+		t.Errorf("Expected the existing autocert cache %s, got %s", legacy, got)
+	}
+}
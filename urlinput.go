@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// urlDownloads is how many -url downloads run at once; the fetch policy
+// limits them per host on top of that.
+const urlDownloads = 8
+
+// readURLsFile reads an -urls-file: one URL per line, with blank lines and
+// lines starting with # left out.
+func readURLsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read URLs: %w", err)
+	}
+	defer file.Close()
+	var urls []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read URLs: %w", err)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no URLs in %s", path)
+	}
+	return urls, nil
+}
+
+// isArchiveURL reports whether rawURL names a CBZ or ZIP file rather than
+// an image.
+func isArchiveURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(parsed.Path)) {
+	case ".cbz", ".zip":
+		return true
+	}
+	return false
+}
+
+// runURLs downloads cfg.URLs into a temporary directory and converts them,
+// as the server converts image_urls: the images in the order of the URLs
+// to one output at cfg.Output, which is then required, or the CBZ/ZIP
+// archive that is the only URL to cfg.Output (default: the archive's name
+// with a .pdf extension, in the working directory). Downloads follow the
+// -fetch-* policy, and those that fail with a transient error are retried
+// -fetch-retries times. An image that cannot be downloaded is left out, or
+// with cfg.Placeholders replaced by a placeholder page; the conversion
+// fails if none can be.
+func runURLs(ctx context.Context, cfg Config) error {
+	archive := slices.ContainsFunc(cfg.URLs, isArchiveURL)
+	if archive && len(cfg.URLs) > 1 {
+		return errors.New("a CBZ/ZIP URL must be the only URL to convert")
+	}
+	output := cfg.Output
+	if output == "" {
+		if !archive {
+			return errors.New("-o is required to convert image URLs")
+		}
+		name := inputName(path.Base(strings.SplitN(cfg.URLs[0], "?", 2)[0]))
+		output = name + outputExt(cfg)
+	}
+	dir, err := os.MkdirTemp("", "manga_to_pdf-urls-")
+	if err != nil {
+		return fmt.Errorf("could not create download directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	fetcher, done := converter.NewFetcher(converter.FetchPolicy{
+		MaxConnsPerHost: cfg.FetchMaxConnsPerHost,
+		HostDelay:       time.Duration(cfg.FetchHostDelay),
+		Proxy:           fetchProxy(cfg),
+	}).ForJob()
+	defer done()
+
+	if archive {
+		file, err := downloadArchive(ctx, cfg, fetcher, filepath.Join(dir, "archive.zip"))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return convertArchive(ctx, cfg, file, output)
+	}
+	sources, err := downloadImages(ctx, cfg, fetcher, dir)
+	if err != nil {
+		return err
+	}
+	cfg.events.emit(conversionEvent{Event: eventScan, Input: cfg.Input, Chapters: 1, Pages: len(sources)})
+	if cfg.MaxPages > 0 && len(sources) > cfg.MaxPages {
+		for _, src := range sources {
+			if src.Reader != nil {
+				src.Reader.Close()
+			}
+		}
+		return fmt.Errorf("%d URLs are more than the limit of %d pages; raise -max-pages (0 disables the limit)", len(sources), cfg.MaxPages)
+	}
+	_, err = convertSources(ctx, cfg, sources, 1, output)
+	return err
+}
+
+// downloadArchive downloads the archive at the only URL of cfg to path,
+// retrying transient failures, and returns it open for reading.
+func downloadArchive(ctx context.Context, cfg Config, fetcher *converter.Fetcher, path string) (*os.File, error) {
+	rawURL := cfg.URLs[0]
+	var err error
+	for attempt := 1; ; attempt++ {
+		var file *os.File
+		if file, err = os.Create(path); err != nil {
+			return nil, err
+		}
+		var n int64
+		if n, err = fetcher.Download(ctx, rawURL, file); err == nil {
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			slog.Info("Downloaded archive", "url", rawURL, "size", byteSize(n).String())
+			return file, nil
+		}
+		file.Close()
+		if !converter.IsTransientFetchError(err) || attempt > cfg.FetchRetries || !sleepContext(ctx, time.Duration(cfg.FetchRetryDelay)) {
+			return nil, err
+		}
+		slog.Info("Retrying archive URL that failed with a transient error", "url", rawURL, "retry", attempt, "of", cfg.FetchRetries, "error", err)
+	}
+}
+
+// downloadImages downloads the images at cfg.URLs into dir, urlDownloads
+// at a time, and returns them as sources open for reading, indexed by
+// their position in cfg.URLs. Downloads that fail with a transient error
+// are tried again in rounds, like the server's image_urls.
+func downloadImages(ctx context.Context, cfg Config, fetcher *converter.Fetcher, dir string) ([]converter.ImageSource, error) {
+	sources := make([]converter.ImageSource, len(cfg.URLs))
+	errs := make([]error, len(cfg.URLs))
+	pending := make([]int, len(cfg.URLs))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; ; attempt++ {
+		slots := make(chan struct{}, urlDownloads)
+		var wg sync.WaitGroup
+		for _, i := range pending {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+				sources[i], errs[i] = downloadImage(ctx, cfg, fetcher, cfg.URLs[i], i, dir)
+			}()
+		}
+		wg.Wait()
+
+		var retry []int
+		for _, i := range pending {
+			if errs[i] != nil && converter.IsTransientFetchError(errs[i]) {
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 || attempt > cfg.FetchRetries || !sleepContext(ctx, time.Duration(cfg.FetchRetryDelay)) {
+			break
+		}
+		slog.Info("Retrying image URLs that failed with transient errors", "count", len(retry), "retry", attempt, "of", cfg.FetchRetries)
+		pending = retry
+	}
+	if ctx.Err() != nil {
+		for i, src := range sources {
+			if errs[i] == nil {
+				src.Reader.Close()
+			}
+		}
+		return nil, converter.CancellationError(ctx)
+	}
+
+	var kept []converter.ImageSource
+	var failed []error
+	for i, src := range sources {
+		if errs[i] == nil {
+			kept = append(kept, src)
+			continue
+		}
+		slog.Warn("Failed to download image", "url", cfg.URLs[i], "error", errs[i])
+		failed = append(failed, errs[i])
+		if cfg.Placeholders {
+			// The converter puts a placeholder page in its place.
+			kept = append(kept, converter.ImageSource{OriginalFilename: cfg.URLs[i], URL: cfg.URLs[i], Index: i, FetchErr: errs[i]})
+		}
+	}
+	if len(failed) == len(cfg.URLs) {
+		return nil, fmt.Errorf("could not download any of the %d image URLs: %w", len(cfg.URLs), errors.Join(failed...))
+	}
+	return kept, nil
+}
+
+// downloadImage downloads the image at rawURL, the index-th URL, to a file
+// in dir and returns it as a source reading that file.
+func downloadImage(ctx context.Context, cfg Config, fetcher *converter.Fetcher, rawURL string, index int, dir string) (converter.ImageSource, error) {
+	if ctx.Err() != nil {
+		return converter.ImageSource{}, converter.CancellationError(ctx)
+	}
+	src, err := fetcher.Fetch(ctx, rawURL, index)
+	if err != nil {
+		return converter.ImageSource{}, err // Fetch closes the body on errors
+	}
+	defer src.Reader.Close()
+	file, err := os.Create(filepath.Join(dir, fmt.Sprintf("%06d", index)))
+	if err != nil {
+		return converter.ImageSource{}, err
+	}
+	n, err := io.Copy(file, src.Reader)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		var bodyErr *converter.FetchBodyError
+		if errors.As(err, &bodyErr) {
+			return converter.ImageSource{}, err // Names the URL already
+		}
+		return converter.ImageSource{}, fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	index0 := index
+	cfg.events.emit(conversionEvent{Event: eventFetch, Index: &index0, Filename: src.OriginalFilename, Bytes: n})
+	src.Reader = file // Closed by the converter
+	return src, nil
+}
+
+// sleepContext waits for d, and reports false if ctx ends first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"manga_to_pdf/internal/converter"
+)
+
+func TestRunApp_URLs(t *testing.T) {
+	served := t.TempDir()
+	writePNG(t, filepath.Join(served, "1.png"))
+	writePNG(t, filepath.Join(served, "2.png"))
+	file, err := os.Create(filepath.Join(served, "volume01.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	for _, name := range []string{"001.png", "002.png", "003.png"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(w, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	server := httptest.NewServer(http.FileServer(http.Dir(served)))
+	defer server.Close()
+
+	dir := t.TempDir()
+	urlsFile := filepath.Join(dir, "urls.txt")
+	list := fmt.Sprintf("# Chapter 1\n%[1]s/1.png\n\n%[1]s/missing.png\n%[1]s/2.png\n", server.URL)
+	if err := os.WriteFile(urlsFile, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "chapter.pdf")
+	cfg, _, err := loadConfig([]string{"-urls-file", urlsFile, "-o", output}, envMap(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.URLs) != 3 {
+		t.Fatalf("Expected 3 URLs from %s, got %q", urlsFile, cfg.URLs)
+	}
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Converting image URLs failed: %v", err)
+	}
+	if pages := pdfPages(t, output); pages != 2 {
+		t.Errorf("Expected the missing image to be left out of 2 pages, got %d", pages)
+	}
+	cfg.Placeholders, cfg.Output = true, filepath.Join(dir, "placeholders.pdf")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Converting image URLs with placeholders failed: %v", err)
+	}
+	if pages := pdfPages(t, cfg.Output); pages != 3 {
+		t.Errorf("Expected a placeholder for the missing image in 3 pages, got %d", pages)
+	}
+
+	output = filepath.Join(dir, "volume01.pdf")
+	cfg, _, err = loadConfig([]string{"-url", server.URL + "/volume01.cbz", "-o", output}, envMap(nil), &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("Converting a CBZ URL failed: %v", err)
+	}
+	if pages := pdfPages(t, output); pages != 3 {
+		t.Errorf("Expected 3 pages from the CBZ, got %d", pages)
+	}
+
+	for _, args := range [][]string{
+		{"-url", server.URL + "/1.png"},
+		{"-url", server.URL + "/1.png", "-url", server.URL + "/volume01.cbz", "-o", output},
+		{"-url", server.URL + "/1.png", "-i", served, "-o", output},
+	} {
+		cfg, _, err := loadConfig(args, envMap(nil), &bytes.Buffer{})
+		if err == nil {
+			err = runApp(context.Background(), cfg)
+		}
+		if err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
+
+func pdfPages(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := converter.ReadPDF(data)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return doc.NumPages()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at release build time with -ldflags "-X main.version=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// runVersionCommand prints the version of this build. Builds without
+// release information report the module version and VCS revision Go
+// recorded, if any.
+func runVersionCommand(args []string, out, errOut io.Writer) int {
+	flagSet := flag.NewFlagSet("version", flag.ContinueOnError)
+	flagSet.SetOutput(errOut)
+	flagSet.Usage = func() {
+		fmt.Fprintln(errOut, "Usage: manga_to_pdf version")
+	}
+	if err := flagSet.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flagSet.NArg() != 0 {
+		flagSet.Usage()
+		return 2
+	}
+	fmt.Fprintln(out, versionString())
+	return 0
+}
+
+// versionString describes this build in one line.
+func versionString() string {
+	v, rev, built := version, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && built == "":
+				built = setting.Value
+			}
+		}
+	}
+	s := "manga_to_pdf " + v
+	if rev != "" {
+		s += " (" + rev
+		if built != "" {
+			s += ", " + built
+		}
+		s += ")"
+	}
+	return s + " " + runtime.Version()
+}
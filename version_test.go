@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunVersionCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := runVersionCommand(nil, &out, &errOut); code != 0 {
+		t.Fatalf("version exited with %d: %s", code, errOut.String())
+	}
+	if !strings.HasPrefix(out.String(), "manga_to_pdf ") || !strings.Contains(out.String(), " go") {
+		t.Errorf("Unexpected version line %q", out.String())
+	}
+	if code := runVersionCommand([]string{"extra"}, &out, &errOut); code != 2 {
+		t.Errorf("Expected an argument to exit with 2, got %d", code)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"manga_to_pdf/api"
+	"manga_to_pdf/internal/converter"
+)
+
+// defaultServerSocket is where -via-server looks for a local server.
+const defaultServerSocket = "/run/manga_to_pdf.sock"
+
+// localServer submits conversions to a manga_to_pdf server listening on a
+// Unix socket on this machine, so they run with its settings and caches
+// instead of in-process.
+type localServer struct {
+	socket string
+	token  string // Bearer token, if the server requires one
+	client *http.Client
+}
+
+// dialLocalServer returns the server listening on socket, to be sent token
+// if it is not empty, or nil if none answers there.
+func dialLocalServer(socket, token string) *localServer {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		slog.Info("No server found, converting in-process", "socket", socket, "error", err)
+		return nil
+	}
+	conn.Close()
+	slog.Info("Converting via the local server", "socket", socket)
+	dialer := &net.Dialer{}
+	return &localServer{socket: socket, token: token, client: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}}
+}
+
+// convert has the server convert sources with convCfg, closing them, and
+// writes the output to out. It fills in the stats the server reports.
+func (s *localServer) convert(ctx context.Context, sources []converter.ImageSource, convCfg *converter.Config, out io.Writer, stats *converter.Stats) (bool, error) {
+	configJSON, err := json.Marshal(convCfg)
+	if err != nil {
+		closeSources(sources)
+		return false, err
+	}
+	body, writer := io.Pipe()
+	mw := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeServerUpload(mw, sources, configJSON))
+	}()
+	// The host is not used to connect; the transport always dials the socket.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/convert", body)
+	if err != nil {
+		body.Close()
+		return false, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	rc := &remoteClient{client: s.client}
+	resp, err := rc.do(req)
+	body.Close() // Stops the upload if the server answered early
+	if err != nil {
+		return false, fmt.Errorf("local server: %w", err)
+	}
+	defer resp.Body.Close()
+	return readServerResponse(resp, out, stats)
+}
+
+// writeServerUpload writes the multipart form of a /convert request asking
+// for a report with the output: the settings, then every source as an
+// "images" part. Every source is closed.
+func writeServerUpload(mw *multipart.Writer, sources []converter.ImageSource, configJSON []byte) error {
+	defer closeSources(sources)
+	if err := mw.WriteField("config", string(configJSON)); err != nil {
+		return err
+	}
+	if err := mw.WriteField("response_mode", "multipart"); err != nil {
+		return err
+	}
+	for _, src := range sources {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="images"; filename=%q`, src.OriginalFilename))
+		header.Set("Content-Type", cmp.Or(src.ContentType, "application/octet-stream"))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(part, src.Reader); err != nil {
+			return fmt.Errorf("could not upload %s: %w", src.OriginalFilename, err)
+		}
+	}
+	return mw.Close()
+}
+
+// readServerResponse copies the output of a multipart /convert response to
+// out, logging the pages the report lists as failed like a local
+// conversion would.
+func readServerResponse(resp *http.Response, out io.Writer, stats *converter.Stats) (bool, error) {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return false, fmt.Errorf("local server: unexpected answer of type %q", resp.Header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	part, err := reader.NextPart()
+	if err != nil {
+		return false, fmt.Errorf("local server: %w", err)
+	}
+	var report api.ConversionReport
+	if err := json.NewDecoder(part).Decode(&report); err != nil {
+		return false, fmt.Errorf("local server: invalid report: %w", err)
+	}
+	for _, failure := range report.Failures {
+		slog.Warn("Page failed", "filename", failure.Source, "error", failure.Error)
+	}
+	stats.Sources = report.Sources
+	stats.PagesAdded = report.PagesAdded
+	stats.PagesFailed = report.PagesFailed
+	stats.PagesPlaceholder = report.PagesPlaceholder
+
+	if part, err = reader.NextPart(); err != nil {
+		return false, fmt.Errorf("local server: no output in answer: %w", err)
+	}
+	stats.OutputBytes, err = io.Copy(out, part)
+	if err != nil {
+		return false, fmt.Errorf("local server: %w", err)
+	}
+	return report.PagesAdded > 0, nil
+}
+
+// closeSources closes the readers of sources.
+func closeSources(sources []converter.ImageSource) {
+	for _, src := range sources {
+		src.Reader.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"manga_to_pdf/api"
+	"manga_to_pdf/internal/converter"
+)
+
+func TestRunApp_ViaServer(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+	writePNG(t, filepath.Join(dir, "2.png"))
+
+	socket := filepath.Join(t.TempDir(), "mtp.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	handler := api.NewConvertHandler(api.Options{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.Title = "Via server"
+	cfg.ViaServer, cfg.ServerSocket = true, socket
+	for _, want := range []int32{1, 1} {
+		cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+		if err := runApp(context.Background(), cfg); err != nil {
+			t.Fatalf("runApp -via-server failed: %v", err)
+		}
+		if got := requests.Load(); got != want {
+			t.Errorf("Expected %d requests to the server, got %d", want, got)
+		}
+		data, err := os.ReadFile(cfg.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := converter.ReadPDF(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.NumPages() != 2 || doc.Info["Title"] != cfg.Title {
+			t.Errorf("Expected 2 pages titled %q, got %d titled %q", cfg.Title, doc.NumPages(), doc.Info["Title"])
+		}
+		// Without a server, the second round converts in-process.
+		server.Close()
+	}
+}
+
+func TestRunApp_ViaServerToken(t *testing.T) {
+	dir := t.TempDir()
+	writePNG(t, filepath.Join(dir, "1.png"))
+
+	socket := filepath.Join(t.TempDir(), "mtp.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: api.RequireBearerToken([]string{"secret"}, api.NewConvertHandler(api.Options{}))}
+	go server.Serve(listener)
+	defer server.Close()
+
+	cfg := defaultConfig()
+	cfg.Input = dir
+	cfg.ViaServer, cfg.ServerSocket = true, socket
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Error("Expected a server requiring a token to refuse a conversion without one")
+	}
+
+	cfg.ServerToken = "secret"
+	cfg.Output = filepath.Join(t.TempDir(), "vol.pdf")
+	if err := runApp(context.Background(), cfg); err != nil {
+		t.Fatalf("runApp -via-server with -server-token failed: %v", err)
+	}
+	if _, err := os.Stat(cfg.Output); err != nil {
+		t.Errorf("Expected the output written: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"manga_to_pdf/internal/converter"
+)
+
+// watchMaxRetryDelay caps the wait before a failed conversion of an
+// unchanged watched entry is tried again.
+const watchMaxRetryDelay = time.Hour
+
+// watchEntry is what runWatch knows about one chapter directory or archive
+// in the watched directory.
+type watchEntry struct {
+	fingerprint string    // As of the last scan (see volumeFingerprint)
+	since       time.Time // When fingerprint was first seen
+	done        string    // Fingerprint last converted; "" if none
+	failures    int       // Failed conversions of fingerprint in a row
+	retryAt     time.Time // When fingerprint may be tried again after a failure
+}
+
+// runWatch turns cfg.Input into a drop folder: every cfg.WatchInterval it
+// scans the directory for chapter directories and CBZ/ZIP archives, and
+// converts each one to the cfg.Output directory (default: cfg.Input) once
+// it has stayed unchanged for cfg.WatchSettle, so copies still in progress
+// are left alone. An entry that changes afterwards is converted again, and
+// one that failed to convert is tried again with backoff; entries whose
+// output exists when watching starts count as converted.
+// The directory is polled rather than watched for events, which also works
+// on network shares. It runs until ctx is done.
+func runWatch(ctx context.Context, cfg Config) error {
+	if len(cfg.Inputs) > 1 {
+		return errors.New("-watch takes a single input directory")
+	}
+	info, err := os.Stat(cfg.Input)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("-watch needs a directory as input, not %s", cfg.Input)
+	}
+	if cfg.WatchInterval <= 0 {
+		return errors.New("-watch-interval must be positive")
+	}
+	outputDir := cmp.Or(cfg.Output, cfg.Input)
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+	slog.Info("Watching for chapters and archives", "input", cfg.Input, "output", outputDir, "settle", cfg.WatchSettle.String())
+
+	entries := map[string]*watchEntry{}
+	ticker := time.NewTicker(time.Duration(cfg.WatchInterval))
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		if err := watchScan(ctx, cfg, outputDir, entries, first); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped watching", "input", cfg.Input)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchScan updates entries from the current contents of cfg.Input and
+// converts those that have settled since they last changed. Only a
+// cancelled conversion is returned as an error; other failures are logged
+// and the entry is tried again after cfg.WatchInterval, doubled after each
+// further failure up to watchMaxRetryDelay, or as soon as it changes and
+// settles again.
+func watchScan(ctx context.Context, cfg Config, outputDir string, entries map[string]*watchEntry, first bool) error {
+	dirEntries, err := naturalDirEntries(cfg.Input)
+	if err != nil {
+		slog.Warn("Could not scan watched directory", "input", cfg.Input, "error", err) // A share may be back by the next scan
+		return nil
+	}
+	now := time.Now()
+	seen := make(map[string]bool, len(dirEntries))
+	for _, d := range dirEntries {
+		name := d.Name()
+		input := filepath.Join(cfg.Input, name)
+		if junkReason(name) != "" || !d.IsDir() && !isArchive(input) {
+			continue
+		}
+		fingerprint, err := volumeFingerprint(input, true)
+		if err != nil {
+			slog.Warn("Could not scan dropped entry", "input", input, "error", err)
+			continue
+		}
+		if fingerprint == "" {
+			continue // No pages yet, or the output directory
+		}
+		seen[name] = true
+		output := filepath.Join(outputDir, strings.TrimSuffix(name, watchArchiveExt(input))+outputExt(cfg))
+		entry := entries[name]
+		if entry == nil {
+			entry = &watchEntry{fingerprint: fingerprint, since: now}
+			if first && fileExists(output) {
+				entry.done = fingerprint
+			}
+			entries[name] = entry
+		}
+		if fingerprint != entry.fingerprint {
+			entry.fingerprint, entry.since = fingerprint, now
+			entry.failures, entry.retryAt = 0, time.Time{}
+		}
+		if entry.done == fingerprint || now.Sub(entry.since) < time.Duration(cfg.WatchSettle) || now.Before(entry.retryAt) {
+			continue
+		}
+		err = convertWatched(ctx, cfg, input, output, d.IsDir())
+		if converter.CancellationReason(err) != "" {
+			return err
+		}
+		if err != nil {
+			entry.failures++
+			delay := min(time.Duration(cfg.WatchInterval)<<min(entry.failures-1, 20), watchMaxRetryDelay)
+			entry.retryAt = time.Now().Add(delay)
+			slog.Error("Could not convert dropped entry", "input", input, "attempts", entry.failures, "retryIn", delay, "error", err)
+			continue
+		}
+		entry.done, entry.failures, entry.retryAt = fingerprint, 0, time.Time{}
+	}
+	for name := range entries {
+		if !seen[name] {
+			delete(entries, name)
+		}
+	}
+	return nil
+}
+
+// watchArchiveExt returns the extension to strip from input for its output
+// name: its own for an archive, none for a directory.
+func watchArchiveExt(input string) string {
+	if isArchive(input) {
+		return filepath.Ext(input)
+	}
+	return ""
+}
+
+// convertWatched converts one entry of the watched directory to output,
+// replacing an earlier output of it. A directory's subdirectories are its
+// chapters.
+func convertWatched(ctx context.Context, cfg Config, input, output string, dir bool) error {
+	cfg.Watch = false
+	cfg.Input, cfg.Output = input, output
+	cfg.Recursive = dir
+	cfg.Split = false
+	cfg.OnExists = "overwrite" // A changed entry replaces its earlier output
+	return runApp(ctx, cfg)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunApp_Watch(t *testing.T) {
+	input, outputDir := t.TempDir(), t.TempDir()
+	// Converted before watching started: left alone.
+	if err := os.Mkdir(filepath.Join(input, "ch0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(input, "ch0", "1.png"))
+	if err := os.WriteFile(filepath.Join(outputDir, "ch0.pdf"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Input, cfg.Output = input, outputDir
+	cfg.Watch = true
+	cfg.WatchInterval = duration(10 * time.Millisecond)
+	cfg.WatchSettle = duration(50 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- runApp(ctx, cfg) }()
+
+	if err := os.MkdirAll(filepath.Join(input, "ch1", "part2"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(input, "ch1", "1.png"))
+	writePNG(t, filepath.Join(input, "ch1", "part2", "2.png"))
+	file, err := os.Create(filepath.Join(input, "vol2.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	w, err := zw.Create("001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(w, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !pdfWritten(filepath.Join(outputDir, "ch1.pdf")) || !pdfWritten(filepath.Join(outputDir, "vol2.pdf")) {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatal("Dropped chapter and archive were not converted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected watching to stop cleanly, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outputDir, "ch0.pdf")); string(data) != "old" {
+		t.Error("Expected the entry converted before watching to be left alone")
+	}
+
+	cfg.Input = filepath.Join(input, "vol2.cbz")
+	if err := runApp(context.Background(), cfg); err == nil {
+		t.Error("Expected -watch on an archive to fail")
+	}
+}
+
+// pdfWritten reports whether path holds a PDF written to the end.
+func pdfWritten(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.HasSuffix(bytes.TrimSpace(data), []byte("%%EOF"))
+}
+
+func TestWatchScan_RetriesFailures(t *testing.T) {
+	input, outputDir := t.TempDir(), t.TempDir()
+	if err := os.Mkdir(filepath.Join(input, "ch1"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writePNG(t, filepath.Join(input, "ch1", "1.png"))
+	// A directory in the way of the output fails the conversion until it
+	// is removed, while the entry itself does not change.
+	blocked := filepath.Join(outputDir, "ch1.pdf")
+	if err := os.MkdirAll(filepath.Join(blocked, "keep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Input = input
+	cfg.WatchInterval = duration(20 * time.Millisecond)
+	cfg.WatchSettle = 0
+	entries := map[string]*watchEntry{}
+	if err := watchScan(context.Background(), cfg, outputDir, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	entry := entries["ch1"]
+	if entry == nil || entry.done != "" || entry.failures != 1 || entry.retryAt.IsZero() {
+		t.Fatalf("Expected a failed conversion to be recorded for a retry, got %+v", entry)
+	}
+
+	if err := os.RemoveAll(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if err := watchScan(context.Background(), cfg, outputDir, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(blocked) {
+		t.Error("Expected no retry before the backoff has passed")
+	}
+	time.Sleep(time.Until(entry.retryAt))
+	if err := watchScan(context.Background(), cfg, outputDir, entries, false); err != nil {
+		t.Fatal(err)
+	}
+	if !pdfWritten(blocked) || entry.done != entry.fingerprint || entry.failures != 0 {
+		t.Errorf("Expected the unchanged entry to be converted on retry, got %+v", entry)
+	}
+}